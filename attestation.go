@@ -0,0 +1,267 @@
+// Copyright 2020 Matthew Holt
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package xcaddy
+
+import (
+	"bufio"
+	"bytes"
+	"crypto"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// The types of the in-toto statement written for AttestationFile,
+// which policy engines like Kyverno or Tekton Chains verify.
+const (
+	inTotoStatementType = "https://in-toto.io/Statement/v1"
+	slsaProvenanceType  = "https://slsa.dev/provenance/v1"
+	xcaddyBuildType     = "https://github.com/caddyserver/xcaddy/build/v1"
+	xcaddyBuilderID     = "https://github.com/caddyserver/xcaddy"
+
+	// the payload type of DSSE envelopes of in-toto statements
+	inTotoPayloadType = "application/vnd.in-toto+json"
+)
+
+// inTotoStatement is an in-toto statement of the SLSA provenance
+// of a binary; see https://slsa.dev/spec/v1.0/provenance.
+type inTotoStatement struct {
+	Type          string               `json:"_type"`
+	Subject       []resourceDescriptor `json:"subject"`
+	PredicateType string               `json:"predicateType"`
+	Predicate     slsaProvenance       `json:"predicate"`
+}
+
+type resourceDescriptor struct {
+	Name   string            `json:"name,omitempty"`
+	URI    string            `json:"uri,omitempty"`
+	Digest map[string]string `json:"digest"`
+}
+
+type slsaProvenance struct {
+	BuildDefinition struct {
+		BuildType            string               `json:"buildType"`
+		ExternalParameters   attestationParams    `json:"externalParameters"`
+		InternalParameters   map[string]string    `json:"internalParameters,omitempty"`
+		ResolvedDependencies []resourceDescriptor `json:"resolvedDependencies"`
+	} `json:"buildDefinition"`
+	RunDetails struct {
+		Builder struct {
+			ID      string            `json:"id"`
+			Version map[string]string `json:"version,omitempty"`
+		} `json:"builder"`
+		Metadata struct {
+			StartedOn  string `json:"startedOn"`
+			FinishedOn string `json:"finishedOn"`
+		} `json:"metadata"`
+	} `json:"runDetails"`
+}
+
+// attestationParams are the parameters of a build which
+// its user chose, as recorded in the attestation.
+type attestationParams struct {
+	CaddyVersion string       `json:"caddy_version,omitempty"`
+	Plugins      []Dependency `json:"plugins,omitempty"`
+	Replacements []string     `json:"replacements,omitempty"`
+	Platform     Platform     `json:"platform"`
+	Args         []string     `json:"args,omitempty"`
+}
+
+// writeAttestation writes the in-toto statement of the SLSA
+// provenance of the binary built by b to b.AttestationFile: its
+// subject is the binary, and its dependencies are the modules of
+// the go.sum of the build, with their hashes as in go.sum.
+func (env environment) writeAttestation(b Builder, binary string, started, finished time.Time) error {
+	digest, err := fileSHA256(binary)
+	if err != nil {
+		return err
+	}
+	goSum, err := os.ReadFile(filepath.Join(env.tempFolder, "go.sum"))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	st := inTotoStatement{
+		Type:          inTotoStatementType,
+		Subject:       []resourceDescriptor{{Name: filepath.Base(binary), Digest: map[string]string{"sha256": digest}}},
+		PredicateType: slsaProvenanceType,
+	}
+	def := &st.Predicate.BuildDefinition
+	def.BuildType = xcaddyBuildType
+	def.ExternalParameters = attestationParams{
+		CaddyVersion: env.caddyVersion,
+		Plugins:      env.plugins,
+		Platform:     b.Platform,
+	}
+	for _, r := range b.Replacements {
+		def.ExternalParameters.Replacements = append(def.ExternalParameters.Replacements, string(r.Old)+"="+string(r.New))
+	}
+	if env.goVersion != "" {
+		def.InternalParameters = map[string]string{"go_version": env.goVersion}
+	}
+	def.ResolvedDependencies = goSumDependencies(goSum)
+	run := &st.Predicate.RunDetails
+	run.Builder.ID = xcaddyBuilderID
+	if b.Invocation != nil {
+		def.ExternalParameters.Args = b.Invocation.Args
+		if b.Invocation.XcaddyVersion != "" {
+			run.Builder.Version = map[string]string{"xcaddy": b.Invocation.XcaddyVersion}
+		}
+	}
+	run.Metadata.StartedOn = started.UTC().Format(time.RFC3339)
+	run.Metadata.FinishedOn = finished.UTC().Format(time.RFC3339)
+
+	data, err := json.MarshalIndent(st, "", "\t")
+	if err != nil {
+		return err
+	}
+	if b.AttestationKey != "" {
+		key, err := readSigningKey(b.AttestationKey)
+		if err != nil {
+			return err
+		}
+		envelope, err := signEnvelope(inTotoPayloadType, data, key)
+		if err != nil {
+			return fmt.Errorf("signing attestation: %v", err)
+		}
+		data, err = json.MarshalIndent(envelope, "", "\t")
+		if err != nil {
+			return err
+		}
+	}
+	log.Printf("[INFO] Writing attestation: %s", b.AttestationFile)
+	return os.WriteFile(b.AttestationFile, append(data, '\n'), 0o644)
+}
+
+// dsseEnvelope is a signed payload, as specified by
+// https://github.com/secure-systems-lab/dsse.
+type dsseEnvelope struct {
+	PayloadType string          `json:"payloadType"`
+	Payload     []byte          `json:"payload"`
+	Signatures  []dsseSignature `json:"signatures"`
+}
+
+type dsseSignature struct {
+	KeyID string `json:"keyid"`
+	Sig   []byte `json:"sig"`
+}
+
+// signEnvelope returns the DSSE envelope of payload, signed with key.
+func signEnvelope(payloadType string, payload []byte, key crypto.Signer) (dsseEnvelope, error) {
+	message := dssePAE(payloadType, payload)
+	var (
+		sig []byte
+		err error
+	)
+	if _, ok := key.(ed25519.PrivateKey); ok {
+		// Ed25519 signs the message itself, not its hash
+		sig, err = key.Sign(rand.Reader, message, crypto.Hash(0))
+	} else {
+		digest := sha256.Sum256(message)
+		sig, err = key.Sign(rand.Reader, digest[:], crypto.SHA256)
+	}
+	if err != nil {
+		return dsseEnvelope{}, err
+	}
+	return dsseEnvelope{
+		PayloadType: payloadType,
+		Payload:     payload,
+		Signatures:  []dsseSignature{{Sig: sig}},
+	}, nil
+}
+
+// dssePAE returns the pre-authentication encoding of payload, which is
+// what is signed, so the signature covers the payload type as well.
+func dssePAE(payloadType string, payload []byte) []byte {
+	return []byte(fmt.Sprintf("DSSEv1 %d %s %d %s", len(payloadType), payloadType, len(payload), payload))
+}
+
+// readSigningKey reads the unencrypted private key in the PEM file,
+// in PKCS #8, or SEC 1 or PKCS #1 for ECDSA and RSA keys.
+func readSigningKey(file string) (crypto.Signer, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, fmt.Errorf("reading attestation key: %v", err)
+	}
+	block, _ := pem.Decode(data)
+	if block == nil {
+		return nil, fmt.Errorf("attestation key %s: no PEM data", file)
+	}
+	var key any
+	switch block.Type {
+	case "PRIVATE KEY":
+		key, err = x509.ParsePKCS8PrivateKey(block.Bytes)
+	case "EC PRIVATE KEY":
+		key, err = x509.ParseECPrivateKey(block.Bytes)
+	case "RSA PRIVATE KEY":
+		key, err = x509.ParsePKCS1PrivateKey(block.Bytes)
+	default:
+		// like cosign's ENCRYPTED SIGSTORE PRIVATE KEY
+		return nil, fmt.Errorf("attestation key %s: unsupported PEM block %s; the key must be unencrypted", file, block.Type)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("attestation key %s: %v", file, err)
+	}
+	signer, ok := key.(crypto.Signer)
+	if !ok {
+		return nil, fmt.Errorf("attestation key %s: unsupported key type %T", file, key)
+	}
+	return signer, nil
+}
+
+// goSumDependencies returns the modules of goSum, the content of a
+// go.sum file, as resource descriptors with the hash of their content,
+// which is a dirhash as go.sum has it, like h1:...; the hashes of
+// go.mod files alone are left out, since those modules are not built.
+func goSumDependencies(goSum []byte) []resourceDescriptor {
+	deps := []resourceDescriptor{}
+	sc := bufio.NewScanner(bytes.NewReader(goSum))
+	for sc.Scan() {
+		fields := strings.Fields(sc.Text())
+		if len(fields) != 3 || strings.HasSuffix(fields[1], "/go.mod") {
+			continue
+		}
+		deps = append(deps, resourceDescriptor{
+			URI:    "pkg:golang/" + fields[0] + "@" + fields[1],
+			Digest: map[string]string{"dirHash": fields[2]},
+		})
+	}
+	return deps
+}
+
+// fileSHA256 returns the SHA-256 hash of the file, in hex.
+func fileSHA256(file string) (string, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return "", err
+	}
+	defer f.Close()
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,210 @@
+// Copyright 2020 Matthew Holt
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package xcaddy
+
+import (
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func Test_goSumDependencies(t *testing.T) {
+	goSum := []byte(`github.com/caddyserver/caddy/v2 v2.8.4 h1:q3pe0wpBj1OcHFZ3n/1nl4V4bxBrYoSoab7rL9BMYNk=
+github.com/caddyserver/caddy/v2 v2.8.4/go.mod h1:vmDAHp7d4JOq6IvQxc8xmUsMlpwBmBHRBcdhmA0sbAU=
+go.uber.org/zap v1.27.0/go.mod h1:GB2qFLM7cTU87MWRP2mPIjqfIDnGu+VIO4V/SdhGo2E=
+
+not a go.sum line
+`)
+	want := []resourceDescriptor{{
+		URI:    "pkg:golang/github.com/caddyserver/caddy/v2@v2.8.4",
+		Digest: map[string]string{"dirHash": "h1:q3pe0wpBj1OcHFZ3n/1nl4V4bxBrYoSoab7rL9BMYNk="},
+	}}
+	if got := goSumDependencies(goSum); !reflect.DeepEqual(got, want) {
+		t.Errorf("goSumDependencies() = %v, want %v", got, want)
+	}
+	if got := goSumDependencies(nil); got == nil || len(got) != 0 {
+		t.Errorf("goSumDependencies(nil) = %#v, want an empty slice", got)
+	}
+}
+
+func Test_writeAttestation(t *testing.T) {
+	dir := t.TempDir()
+	binary := filepath.Join(dir, "caddy")
+	if err := os.WriteFile(binary, []byte("caddy"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	goSum := "github.com/caddyserver/caddy/v2 v2.8.4 h1:q3pe0wpBj1OcHFZ3n/1nl4V4bxBrYoSoab7rL9BMYNk=\n"
+	if err := os.WriteFile(filepath.Join(dir, "go.sum"), []byte(goSum), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	env := environment{
+		caddyVersion: "v2.8.4",
+		plugins:      []Dependency{{PackagePath: "github.com/caddyserver/ntlm-transport", Version: "v0.1.1"}},
+		tempFolder:   dir,
+		goVersion:    "go1.22.5",
+	}
+	b := Builder{
+		Compile:         Compile{Platform: Platform{OS: "linux", Arch: "amd64"}},
+		Replacements:    []Replace{NewReplace("github.com/example/plugin", "../plugin")},
+		Invocation:      &Invocation{XcaddyVersion: "v0.4.4", Args: []string{"build", "v2.8.4"}},
+		AttestationFile: filepath.Join(dir, "caddy.intoto.json"),
+	}
+	started := time.Date(2024, 7, 1, 12, 0, 0, 0, time.UTC)
+	if err := env.writeAttestation(b, binary, started, started.Add(time.Minute)); err != nil {
+		t.Fatalf("writeAttestation() error = %v", err)
+	}
+
+	data, err := os.ReadFile(b.AttestationFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var st inTotoStatement
+	if err := json.Unmarshal(data, &st); err != nil {
+		t.Fatal(err)
+	}
+	if st.Type != inTotoStatementType || st.PredicateType != slsaProvenanceType {
+		t.Errorf("statement types = %s, %s", st.Type, st.PredicateType)
+	}
+	wantSubject := []resourceDescriptor{{
+		Name:   "caddy",
+		Digest: map[string]string{"sha256": fmt.Sprintf("%x", sha256.Sum256([]byte("caddy")))},
+	}}
+	if !reflect.DeepEqual(st.Subject, wantSubject) {
+		t.Errorf("subject = %v, want %v", st.Subject, wantSubject)
+	}
+	def := st.Predicate.BuildDefinition
+	if def.ExternalParameters.CaddyVersion != "v2.8.4" || len(def.ExternalParameters.Plugins) != 1 {
+		t.Errorf("external parameters = %+v", def.ExternalParameters)
+	}
+	if want := []string{"github.com/example/plugin=../plugin"}; !reflect.DeepEqual(def.ExternalParameters.Replacements, want) {
+		t.Errorf("replacements = %v, want %v", def.ExternalParameters.Replacements, want)
+	}
+	if len(def.ResolvedDependencies) != 1 {
+		t.Errorf("resolved dependencies = %v, want 1", def.ResolvedDependencies)
+	}
+	run := st.Predicate.RunDetails
+	if run.Builder.Version["xcaddy"] != "v0.4.4" {
+		t.Errorf("builder version = %v", run.Builder.Version)
+	}
+	if run.Metadata.StartedOn != "2024-07-01T12:00:00Z" || run.Metadata.FinishedOn != "2024-07-01T12:01:00Z" {
+		t.Errorf("metadata = %+v", run.Metadata)
+	}
+}
+
+func Test_writeAttestationSigned(t *testing.T) {
+	dir := t.TempDir()
+	binary := filepath.Join(dir, "caddy")
+	if err := os.WriteFile(binary, []byte("caddy"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, edKey, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ecDER, err := x509.MarshalECPrivateKey(ecKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	edDER, err := x509.MarshalPKCS8PrivateKey(edKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		name   string
+		block  *pem.Block
+		verify func(message, sig []byte) bool
+	}{
+		{
+			name:  "ecdsa",
+			block: &pem.Block{Type: "EC PRIVATE KEY", Bytes: ecDER},
+			verify: func(message, sig []byte) bool {
+				digest := sha256.Sum256(message)
+				return ecdsa.VerifyASN1(&ecKey.PublicKey, digest[:], sig)
+			},
+		},
+		{
+			name:  "ed25519",
+			block: &pem.Block{Type: "PRIVATE KEY", Bytes: edDER},
+			verify: func(message, sig []byte) bool {
+				return ed25519.Verify(edKey.Public().(ed25519.PublicKey), message, sig)
+			},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			keyFile := filepath.Join(dir, tt.name+".pem")
+			if err := os.WriteFile(keyFile, pem.EncodeToMemory(tt.block), 0o600); err != nil {
+				t.Fatal(err)
+			}
+			b := Builder{
+				AttestationFile: filepath.Join(dir, tt.name+".intoto.json"),
+				AttestationKey:  keyFile,
+			}
+			now := time.Now()
+			if err := (environment{tempFolder: dir}).writeAttestation(b, binary, now, now); err != nil {
+				t.Fatalf("writeAttestation() error = %v", err)
+			}
+
+			data, err := os.ReadFile(b.AttestationFile)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var envelope dsseEnvelope
+			if err := json.Unmarshal(data, &envelope); err != nil {
+				t.Fatal(err)
+			}
+			if envelope.PayloadType != inTotoPayloadType || len(envelope.Signatures) != 1 {
+				t.Fatalf("envelope = %s", data)
+			}
+			var st inTotoStatement
+			if err := json.Unmarshal(envelope.Payload, &st); err != nil {
+				t.Fatalf("payload: %v", err)
+			}
+			if st.Type != inTotoStatementType {
+				t.Errorf("statement type = %s", st.Type)
+			}
+			if !tt.verify(dssePAE(envelope.PayloadType, envelope.Payload), envelope.Signatures[0].Sig) {
+				t.Error("the signature doesn't verify")
+			}
+			envelope.Payload = append(envelope.Payload, ' ')
+			if tt.verify(dssePAE(envelope.PayloadType, envelope.Payload), envelope.Signatures[0].Sig) {
+				t.Error("the signature verifies a changed payload")
+			}
+		})
+	}
+
+	encrypted := filepath.Join(dir, "cosign.key")
+	if err := os.WriteFile(encrypted, pem.EncodeToMemory(&pem.Block{Type: "ENCRYPTED SIGSTORE PRIVATE KEY", Bytes: []byte("x")}), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := readSigningKey(encrypted); err == nil {
+		t.Error("readSigningKey() of an encrypted key: error = nil")
+	}
+}
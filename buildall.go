@@ -0,0 +1,187 @@
+// Copyright 2020 Matthew Holt
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package xcaddy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/caddyserver/xcaddy/internal/utils"
+)
+
+// maxParallelBuilds is how many targets BuildAll compiles at once;
+// the go command parallelizes each compile already, but not linking.
+const maxParallelBuilds = 4
+
+// BuildResult is the result of building for one target with BuildAll.
+type BuildResult struct {
+	Platform
+
+	// The path of the binary, expanded from the output
+	// file template; empty if the build failed.
+	Output string
+
+	// How long the build took.
+	Duration time.Duration
+
+	// Why the build failed, if it did.
+	Err error
+}
+
+// BuildAll builds Caddy for each of targets, whose platforms replace
+// that of the Builder, like Build does, but resolves the modules only
+// once and compiles up to four targets in parallel. outputTemplate
+// is the name of the binaries, which must be a template that gives a
+// different file for each target; see OutputContext. The environment
+// in Environment is used if set; otherwise one is prepared for all
+// targets and cleaned up afterwards.
+//
+// It returns the results of all targets, in the order of targets,
+// and an error joining the errors of the failed ones, if any.
+// AttestationFile can't be set, since it names a single file.
+func (b Builder) BuildAll(ctx context.Context, targets []Platform, outputTemplate string) ([]BuildResult, error) {
+	ctx, cancel := b.withTimeoutTotal(ctx)
+	defer cancel()
+	if len(targets) == 0 {
+		return nil, fmt.Errorf("no targets to build for")
+	}
+	if b.AttestationFile != "" {
+		return nil, fmt.Errorf("an attestation file can't be written for several builds; attest each binary with Build instead")
+	}
+	if err := checkBuildAllOutputs(targets, outputTemplate); err != nil {
+		return nil, err
+	}
+
+	if b.Environment == "" {
+		tempDir, err := os.MkdirTemp("", "xcaddy-buildall-")
+		if err != nil {
+			return nil, err
+		}
+		if b.SkipCleanup {
+			log.Printf("[INFO] Skipping cleanup as requested; leaving folder intact: %s", tempDir)
+		} else {
+			defer os.RemoveAll(tempDir)
+		}
+		envDir := filepath.Join(tempDir, "env")
+		err = b.PrepareEnvironment(ctx, envDir)
+		if err != nil {
+			return nil, err
+		}
+		b.Environment = envDir
+	}
+	b.shared = new(sync.Mutex)
+
+	// the version of Caddy is the same for all targets, so it is
+	// found once, if needed, as are the version resources of each
+	// Windows architecture, which are kept in the shared environment
+	buildEnv, err := b.openEnvironment(ctx, b.Environment)
+	if err != nil {
+		return nil, withFailure(err, FailureResolution)
+	}
+	selected := selectedCaddy{}
+	if needsCaddyVersion(targets, outputTemplate) || buildEnv.caddyReplacedLocally() {
+		selected, err = buildEnv.selectCaddy(ctx)
+		if err != nil {
+			return nil, withFailure(err, FailureResolution)
+		}
+	}
+	b.selectedCaddy = &selected
+	b.resources = make(windowsResources)
+
+	results := make([]BuildResult, len(targets))
+	sem := make(chan struct{}, maxParallelBuilds)
+	var wg sync.WaitGroup
+	for i, target := range targets {
+		wg.Add(1)
+		go func(i int, target Platform) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+
+			tb := b
+			tb.Platform = target
+			start := time.Now()
+			output, err := tb.BuildFile(ctx, outputTemplate)
+			results[i] = BuildResult{
+				Platform: target,
+				Output:   output,
+				Duration: time.Since(start),
+				Err:      err,
+			}
+		}(i, target)
+	}
+	wg.Wait()
+
+	var errs []error
+	for _, r := range results {
+		if r.Err != nil {
+			errs = append(errs, fmt.Errorf("%s/%s%s: %w", r.OS, r.Arch, r.ARM, r.Err))
+		}
+	}
+	return results, errors.Join(errs...)
+}
+
+// needsCaddyVersion returns true if the builds for targets
+// need the selected version of Caddy, for the file names of
+// outputTemplate or the version resource of Windows.
+func needsCaddyVersion(targets []Platform, outputTemplate string) bool {
+	if isOutputTemplate(outputTemplate) {
+		return true
+	}
+	for _, t := range targets {
+		if t.OS == "windows" {
+			return true
+		}
+	}
+	return false
+}
+
+// checkBuildAllOutputs returns an error if outputTemplate
+// doesn't give a different file for each of targets.
+func checkBuildAllOutputs(targets []Platform, outputTemplate string) error {
+	if len(targets) > 1 && !isOutputTemplate(outputTemplate) {
+		return fmt.Errorf("output file %s must be a template to build for several targets, like %s", outputTemplate, `caddy_{{.OS}}_{{.Arch}}{{.ARM}}{{.Ext}}`)
+	}
+	seen := make(map[string]Platform)
+	for _, t := range targets {
+		if t.OS == "" {
+			t.OS = utils.GetGOOS()
+		}
+		if t.Arch == "" {
+			t.Arch = utils.GetGOARCH()
+		}
+		// the other fields are the same for all targets
+		output, err := expandOutputFile(outputTemplate, OutputContext{
+			OS:   t.OS,
+			Arch: t.Arch,
+			ARM:  t.ARM,
+			Ext:  executableExt(t.OS),
+		})
+		if err != nil {
+			return err
+		}
+		if other, ok := seen[output]; ok {
+			return fmt.Errorf("output file template %s gives the same file for %s/%s%s and %s/%s%s", outputTemplate, other.OS, other.Arch, other.ARM, t.OS, t.Arch, t.ARM)
+		}
+		seen[output] = t
+	}
+	return nil
+}
@@ -0,0 +1,78 @@
+// Copyright 2020 Matthew Holt
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package xcaddy
+
+import (
+	"context"
+	"testing"
+)
+
+func TestCheckBuildAllOutputs(t *testing.T) {
+	linux := Platform{OS: "linux", Arch: "amd64"}
+	linuxARM := Platform{OS: "linux", Arch: "arm64"}
+	arm6 := Platform{OS: "linux", Arch: "arm", ARM: "6"}
+	arm7 := Platform{OS: "linux", Arch: "arm", ARM: "7"}
+	windows := Platform{OS: "windows", Arch: "amd64"}
+	for i, tc := range []struct {
+		targets   []Platform
+		template  string
+		expectErr bool
+	}{
+		{targets: []Platform{linux}, template: "caddy"},
+		{targets: []Platform{linux, windows}, template: "caddy", expectErr: true},
+		{targets: []Platform{linux, linuxARM, windows}, template: "dist/caddy_{{.OS}}_{{.Arch}}{{.Ext}}"},
+		{targets: []Platform{linux, windows}, template: "dist/caddy_{{.Arch}}", expectErr: true},
+		{targets: []Platform{arm6, arm7}, template: "caddy_{{.OS}}_{{.Arch}}", expectErr: true},
+		{targets: []Platform{arm6, arm7}, template: "caddy_{{.OS}}_{{.Arch}}v{{.ARM}}"},
+		{targets: []Platform{linux, windows}, template: "caddy_{{.Nope}}", expectErr: true},
+	} {
+		err := checkBuildAllOutputs(tc.targets, tc.template)
+		if (err != nil) != tc.expectErr {
+			t.Errorf("Test %d: expected error %v, got %v", i, tc.expectErr, err)
+		}
+	}
+}
+
+func TestBuildAllChecks(t *testing.T) {
+	ctx := context.Background()
+	targets := []Platform{{OS: "linux", Arch: "amd64"}, {OS: "linux", Arch: "arm64"}}
+	if _, err := (Builder{}).BuildAll(ctx, nil, "caddy"); err == nil {
+		t.Error("expected an error without targets")
+	}
+	if _, err := (Builder{AttestationFile: "caddy.intoto.json"}).BuildAll(ctx, targets, "caddy_{{.Arch}}"); err == nil {
+		t.Error("expected an error with an attestation file")
+	}
+	if _, err := (Builder{}).BuildAll(ctx, targets, "caddy"); err == nil {
+		t.Error("expected an error with the same output file for all targets")
+	}
+}
+
+func TestNeedsCaddyVersion(t *testing.T) {
+	linux := Platform{OS: "linux", Arch: "amd64"}
+	windows := Platform{OS: "windows", Arch: "arm64"}
+	for i, tc := range []struct {
+		targets  []Platform
+		template string
+		expect   bool
+	}{
+		{targets: []Platform{linux}, template: "caddy"},
+		{targets: []Platform{linux, windows}, template: "caddy.exe", expect: true},
+		{targets: []Platform{linux}, template: "caddy_{{.CaddyVersion}}", expect: true},
+	} {
+		if got := needsCaddyVersion(tc.targets, tc.template); got != tc.expect {
+			t.Errorf("Test %d: expected %v, got %v", i, tc.expect, got)
+		}
+	}
+}
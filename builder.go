@@ -15,17 +15,20 @@
 package xcaddy
 
 import (
-	"bytes"
 	"context"
+	"encoding/json"
 	"fmt"
+	"io"
 	"log"
 	"os"
+	"os/exec"
 	"path"
 	"path/filepath"
 	"regexp"
 	"runtime"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/Masterminds/semver/v3"
@@ -39,6 +42,9 @@ type Builder struct {
 	CaddyVersion string        `json:"caddy_version,omitempty"`
 	Plugins      []Dependency  `json:"plugins,omitempty"`
 	Replacements []Replace     `json:"replacements,omitempty"`
+	Patches      []Patch       `json:"patches,omitempty"`
+	Excludes     []Dependency  `json:"excludes,omitempty"`
+	Requires     []Dependency  `json:"requires,omitempty"`
 	TimeoutGet   time.Duration `json:"timeout_get,omitempty"`
 	TimeoutBuild time.Duration `json:"timeout_build,omitempty"`
 	RaceDetector bool          `json:"race_detector,omitempty"`
@@ -48,141 +54,820 @@ type Builder struct {
 	BuildFlags   string        `json:"build_flags,omitempty"`
 	ModFlags     string        `json:"mod_flags,omitempty"`
 
+	// Directives added to go.mod of the build as they would be
+	// written in it, before the versions of the plugins are pinned,
+	// like "godebug default=go1.21", "toolchain go1.22.5" or "exclude
+	// example.com/mod v1.2.3". The directives go, toolchain, godebug,
+	// require, exclude, replace, retract and tool are supported, on
+	// a single line each; see "go help mod edit" for which need a
+	// newer go command.
+	GoModDirectives []string `json:"go_mod_directives,omitempty"`
+
+	// The caller's GOFLAGS apply to the go commands run for the build,
+	// except -mod and -modfile, which are dropped since xcaddy manages
+	// the module it builds in. Build tags set by GOFLAGS are added to
+	// the default ones; otherwise flags given on the command line, like
+	// BuildFlags, take precedence as usual. Set this to ignore GOFLAGS.
+	IgnoreGoFlags bool `json:"ignore_goflags,omitempty"`
+
+	// If set, the go commands run for the build use exactly this Go
+	// toolchain, like 1.22.5, regardless of the installed go command,
+	// which downloads it if needed; see GOTOOLCHAIN in `go help toolchain`.
+	// A prepared environment keeps using its toolchain unless this is set.
+	GoVersion string `json:"go_version,omitempty"`
+
+	// Limits the whole build, including those of all targets of
+	// BuildAll and the commands of Run and PrepareEnvironment, as
+	// opposed to TimeoutBuild, which limits each build by Build or
+	// BuildFile, TimeoutGet, which limits pinning the versions of the
+	// modules, and TimeoutCompile, which limits compiling Caddy. The
+	// go commands which are still running when a timeout expires are
+	// killed, along with their children.
+	TimeoutTotal   time.Duration `json:"timeout_total,omitempty"`
+	TimeoutCompile time.Duration `json:"timeout_compile,omitempty"`
+
+	// Run the go commands of the build, as well as git, with a
+	// throwaway HOME, GOPATH and no go env file, so settings and
+	// credentials of the user can't affect the build. The module
+	// and build caches of the user are still shared, since their
+	// contents are verified. A prepared environment stays sandboxed.
+	Sandbox bool `json:"sandbox,omitempty"`
+
+	// The .netrc file with the credentials for downloading modules, like
+	// from a private module proxy, instead of the one in the home folder
+	// of the user; see NETRC in `go help environment`. GoAuth, if set,
+	// is the GOAUTH of the go commands (Go 1.24 or newer), which selects
+	// how they authenticate; see `go help goauth`. A prepared environment
+	// keeps using both unless they are set.
+	Netrc  string `json:"netrc,omitempty"`
+	GoAuth string `json:"goauth,omitempty"`
+
+	// The proxy of the HTTP and HTTPS requests of the commands run for
+	// the build, like http://proxy.example.com:3128, instead of the one
+	// in the environment of the caller, and the hosts reached without it,
+	// as in NO_PROXY. Since the URL of the proxy may contain credentials,
+	// neither is recorded, not even by a prepared environment.
+	Proxy   string `json:"-"`
+	NoProxy string `json:"-"`
+
+	// TLS settings of the module downloads, for internal servers with
+	// a private CA. CACert is a PEM file with certificates which the go
+	// commands of the build and git trust in addition to those of the
+	// system; the go command ignores it on macOS and Windows, where it
+	// only trusts the system. ClientCert and ClientKey are the PEM files
+	// of a client certificate and its key, which git presents to servers
+	// requiring mutual TLS; the go command can't present one, so modules
+	// from such servers must be fetched with git directly, by matching
+	// them with GOPRIVATE, rather than from a module proxy. Insecure are
+	// patterns of module paths, as in GOINSECURE, which are fetched
+	// without verifying TLS at all, as a last resort. A prepared
+	// environment keeps using all of them unless they are set.
+	CACert     string   `json:"ca_cert,omitempty"`
+	ClientCert string   `json:"client_cert,omitempty"`
+	ClientKey  string   `json:"client_key,omitempty"`
+	Insecure   []string `json:"insecure,omitempty"`
+
+	// The module proxies of private modules, which are tried in order
+	// before the proxies of GOPROXY, only for the go commands of the
+	// build; modules whose path starts with one of their prefixes are
+	// not looked up in the checksum database, so the build fails if
+	// the proxies of a prefix don't serve one of its modules, which
+	// was then fetched from a public proxy. A prepared environment
+	// keeps using them unless this is set.
+	ModuleProxies []ModuleProxy `json:"module_proxies,omitempty"`
+
+	// The module cache of the go commands of the build, instead of
+	// the one of the user; see GOMODCACHE. With Offline, nothing is
+	// downloaded: the modules are only taken from the module cache,
+	// which must have them, and are not looked up in the checksum
+	// database, which can't be reached, so they are only as trustworthy
+	// as the module cache. A prepared environment keeps
+	// using both unless they are set.
+	ModCache string `json:"modcache,omitempty"`
+	Offline  bool   `json:"offline,omitempty"`
+
+	// Leave out the standard modules of Caddy, so the binary only has
+	// the core of Caddy and the plugins; mind that even the HTTP app
+	// is a standard module. Without, in contrast, lists the standard
+	// modules to leave out, as package paths relative to the modules
+	// folder of Caddy, like caddyhttp/templates, or absolute ones; the
+	// packages in their subfolders are left out as well. Both apply
+	// when the environment is prepared.
+	Bare    bool     `json:"bare,omitempty"`
+	Without []string `json:"without,omitempty"`
+
+	// The preset of the generated main package: plain, the default,
+	// only runs Caddy, while run applies MainDefaults first, so the
+	// binary runs with the right config without any flags, like in
+	// appliance-style deployments. Without arguments, a binary of the
+	// run preset runs `caddy run`. Both apply when the environment is
+	// prepared.
+	MainPreset   string       `json:"main_preset,omitempty"`
+	MainDefaults MainDefaults `json:"main_defaults,omitempty"`
+
+	// Experimental: the build mode, which is BuildModeExe, the default,
+	// or BuildModePlugin, which builds the plugins into a Go plugin
+	// instead of into Caddy, for exploring dynamic loading. The plugin,
+	// a shared object, is written next to the binary, with .so appended
+	// to its name, and Caddy loads the plugins in GoPluginsEnv when it
+	// starts. Go only loads plugins built with the same toolchain, flags
+	// and versions of the shared packages as the program, so Caddy is
+	// built from the same module right before, and both are checked to
+	// match. Go plugins need cgo, which is enabled, and are only
+	// supported on Linux, FreeBSD and macOS. It applies when the
+	// environment is prepared.
+	BuildMode string `json:"build_mode,omitempty"`
+
+	// Definitions of build-time configuration for plugins, like default
+	// endpoints, which plugins read with the package
+	// github.com/caddyserver/xcaddy/defines. They are set with the
+	// linker flag -X, added to the -ldflags of the build. A prepared
+	// environment keeps using them unless this is set.
+	Defines map[string]string `json:"defines,omitempty"`
+
+	// Skip checking for enough free space, and on Windows for paths
+	// which would get too long, before preparing the environment.
+	SkipPreflight bool `json:"skip_preflight,omitempty"`
+
+	// Fail if the module can't be tidied without errors, instead
+	// of ignoring them; see the -e flag of `go mod tidy`.
+	Strict bool `json:"strict,omitempty"`
+
+	// Build the binary with coverage instrumentation of the plugins'
+	// packages (Go 1.20+), so coverage can be collected from a running
+	// Caddy process by setting GOCOVERDIR; see `go help testflag`.
+	Cover bool `json:"cover,omitempty"`
+
+	// If the module proxy can't serve CaddyVersion, for example a
+	// commit that isn't known to the proxy yet, clone the repository
+	// at CaddyRepository (the official one if empty) at that ref and
+	// build from the clone instead.
+	CaddyGitFallback bool   `json:"caddy_git_fallback,omitempty"`
+	CaddyRepository  string `json:"caddy_repository,omitempty"`
+
+	// Always run an empty "go get" after pinning the versions of the
+	// plugins, which resolves ambiguous imports introduced by them, as
+	// xcaddy used to. Otherwise, it only runs if the imports of the
+	// build don't resolve with go.mod as it is.
+	ResolveAmbiguities bool `json:"resolve_ambiguities,omitempty"`
+
+	// Write the final go.mod and go.sum of the build next to the
+	// output file, named like the output file with a ".go.mod"
+	// and ".go.sum" extension added, so the build can be audited
+	// or reproduced later.
+	WriteModFiles bool `json:"write_mod_files,omitempty"`
+
+	// Embed a compressed copy of the final go.mod and go.sum
+	// into the binary; see ReadProvenance.
+	EmbedModFiles bool `json:"embed_mod_files,omitempty"`
+
+	// If set, an in-toto statement of the SLSA provenance of the
+	// binary is written to this file after the build, for policy
+	// engines which verify it before deployment. Its subject is the
+	// binary, and its dependencies are the modules of the final
+	// go.sum, with their hashes. It is not signed, unless
+	// AttestationKey is set.
+	AttestationFile string `json:"attestation_file,omitempty"`
+
+	// If set, the attestation is signed with the private key in this
+	// PEM file, an unencrypted ECDSA, Ed25519 or RSA key, and written
+	// as a DSSE envelope of the statement, which cosign and policy
+	// engines like Kyverno verify with the public key.
+	AttestationKey string `json:"attestation_key,omitempty"`
+
+	// If set, the dependency graph of the build is written to this
+	// file, with each requirement annotated with the plugins which
+	// introduced it; as JSON if the name ends in .json, otherwise
+	// in the DOT language of Graphviz.
+	GraphFile string `json:"graph_file,omitempty"`
+
+	// The paths of modules to explain why they are part of the build,
+	// as `go mod why -m` does, which is logged before compiling.
+	Why []string `json:"why,omitempty"`
+
+	// If set, the heaviest dependencies of the build are written to
+	// Stdout after it, by the size of their Go source which is
+	// compiled in, along with the plugins which introduced them.
+	PruneReport bool `json:"prune_report,omitempty"`
+
+	// If set, the invocation is recorded in the binary along with
+	// the Caddy version and plugins; see ReadInvocation.
+	Invocation *Invocation `json:"invocation,omitempty"`
+
+	// If set, the build uses the environment which was prepared in
+	// this folder by PrepareEnvironment, instead of preparing a new
+	// one. The Caddy version, plugins and other module settings it
+	// was prepared with are used, and those of the Builder ignored.
+	// EmbedDirs, if any, replace the directories embedded before.
+	Environment string `json:"-"`
+
+	// If set, the local replacements must be directories within one of
+	// these folders, like the workspace of a CI job, so a manifest can't
+	// build from arbitrary paths of the host; symlinks are resolved
+	// before checking. Not read from manifests, which it restricts.
+	ReplaceRoots []string `json:"-"`
+
+	// If set, the plugins given with a version are replaced by their
+	// forks in this namespace at the same version, like those of an
+	// organization with patched dependencies: with github.com/acme/forks,
+	// github.com/caddy-dns/cloudflare at v0.1.0 is built from
+	// github.com/acme/forks/cloudflare at v0.1.0. The fork must have
+	// the tag, and its go.mod may declare either module path. Plugins
+	// which are replaced otherwise are left as they are.
+	ReplaceFrom string `json:"replace_from,omitempty"`
+
 	// Experimental: subject to change
 	EmbedDirs []struct {
 		Dir  string `json:"dir,omitempty"`
 		Name string `json:"name,omitempty"`
 	} `json:"embed_dir,omitempty"`
+
+	// The maximum total size in bytes of the files in EmbedDirs;
+	// DefaultEmbedMaxSize if zero, and unlimited if negative.
+	EmbedMaxSize int64 `json:"embed_max_size,omitempty"`
+
+	// If set, called with the progress of builds, like the start and
+	// completion of each phase and the commands run with their output,
+	// for showing it without parsing the log. It is called by one
+	// goroutine of the build at a time, so it must not block for long,
+	// and it may be called concurrently for the builds of BuildAll.
+	ProgressFunc func(Event) `json:"-"`
+
+	// Where the go commands of builds write their standard output,
+	// and where the report of PruneReport is written; os.Stdout if
+	// nil. Set it to os.Stderr to keep the standard output free for
+	// something else, like the binary itself.
+	Stdout io.Writer `json:"-"`
+
+	// where the go commands of the build write their errors,
+	// in addition to the standard error of xcaddy, if set
+	stderr io.Writer
+
+	// serializes the steps of concurrent builds from the same
+	// environment by BuildAll, except compiling, if set
+	shared *sync.Mutex
+
+	// the version of Caddy selected in Environment and the version
+	// resources generated in it, which BuildAll finds and generates
+	// once for all builds, if set
+	selectedCaddy *selectedCaddy
+	resources     windowsResources
 }
 
+// DefaultEmbedMaxSize is the default maximum total size
+// of the embedded directories, 1 GiB.
+const DefaultEmbedMaxSize = 1 << 30
+
 // Build builds Caddy at the configured version with the
 // configured plugins and plops down a binary at outputFile.
+// The name of outputFile may be a template; see OutputContext.
 func (b Builder) Build(ctx context.Context, outputFile string) error {
-	var cancel context.CancelFunc
+	_, err := b.BuildFile(ctx, outputFile)
+	return err
+}
+
+// BuildFile is like Build, but also returns the path of the
+// binary, which is useful if outputFile is a template.
+func (b Builder) BuildFile(ctx context.Context, outputFile string) (string, error) {
+	p := &phases{report: b.ProgressFunc}
+	outputFile, err := b.buildFile(ctx, outputFile, p)
+	if err != nil {
+		err = phaseFailure(err, p.current)
+		if ctx.Err() != nil {
+			err = &Error{Failure: FailureOf(ctx.Err()), Err: err}
+		}
+	}
+	p.end(err)
+	return outputFile, err
+}
+
+// buildFile does the work of BuildFile, and reports its phases to p.
+func (b Builder) buildFile(ctx context.Context, outputFile string, p *phases) (string, error) {
+	started := time.Now()
+	ctx, cancel := b.withTimeoutTotal(ctx)
+	defer cancel()
 	if b.TimeoutBuild > 0 {
-		ctx, cancel = context.WithTimeout(ctx, b.TimeoutBuild)
-		defer cancel()
+		var cancelBuild context.CancelFunc
+		ctx, cancelBuild = context.WithTimeout(ctx, b.TimeoutBuild)
+		defer cancelBuild()
 	}
 	if outputFile == "" {
-		return fmt.Errorf("output file path is required")
+		return "", fmt.Errorf("output file path is required")
 	}
+	outputTemplate := outputFile
+	if isOutputTemplate(outputTemplate) {
+		// checked early, so mistakes don't take a whole build to show
+		if _, err := expandOutputFile(outputTemplate, OutputContext{}); err != nil {
+			return "", err
+		}
+	}
+	if err := checkEmbedAliases(b.EmbedDirs); err != nil {
+		return "", err
+	}
+	if err := checkDefines(b.Defines); err != nil {
+		return "", err
+	}
+	if err := checkMainPreset(b.MainPreset, b.MainDefaults); err != nil {
+		return "", err
+	}
+	if err := checkBuildMode(b.BuildMode, b); err != nil {
+		return "", err
+	}
+	if _, err := goToolchain(b.GoVersion); err != nil {
+		return "", err
+	}
+
+	b.setDefaults()
+
+	unlock := b.lockShared()
+	defer func() { unlock() }()
+
+	// prepare the build environment, unless it was prepared before
+	p.start(PhasePrepare)
+	var buildEnv *environment
+	var err error
+	if b.Environment != "" {
+		buildEnv, err = b.openEnvironment(ctx, b.Environment)
+	} else {
+		buildEnv, err = b.prepareEnvironment(ctx, "")
+	}
+	if err != nil {
+		return "", err
+	}
+	defer buildEnv.Close()
+	if buildEnv.goPlugin {
+		if err := checkGoPluginOS(b.OS); err != nil {
+			return "", err
+		}
+		if !b.Compile.Cgo {
+			log.Println("[WARNING] Enabling cgo because it is required by Go plugins")
+			b.Compile.Cgo = true
+		}
+	}
+
+	// the version of Caddy which was selected, as opposed to a tag,
+	// branch or commit; it takes a go command to find, so only if the
+	// build needs it
+	var selected selectedCaddy
+	if b.selectedCaddy != nil {
+		selected = *b.selectedCaddy
+	} else if needsCaddyVersion([]Platform{b.Platform}, outputTemplate) || buildEnv.caddyReplacedLocally() {
+		selected, err = buildEnv.selectCaddy(ctx)
+		if err != nil {
+			return "", err
+		}
+	}
+	caddyVersion := selected.version
+
+	if isOutputTemplate(outputTemplate) {
+		outputFile, err = expandOutputFile(outputTemplate, OutputContext{
+			CaddyVersion: caddyVersion,
+			OS:           b.OS,
+			Arch:         b.Arch,
+			ARM:          b.ARM,
+			Ext:          executableExt(b.OS),
+			Date:         time.Now().UTC().Format("20060102"),
+			PluginsHash:  pluginsHash(buildEnv.plugins),
+		})
+		if err != nil {
+			return "", err
+		}
+		log.Printf("[INFO] Expanded output file %s to %s", outputTemplate, outputFile)
+	}
+
 	// the user's specified output file might be relative, and
 	// because the `go build` command is executed in a different,
 	// temporary folder, we convert the user's input to an
 	// absolute path so it goes the expected place
 	absOutputFile, err := filepath.Abs(outputFile)
 	if err != nil {
-		return err
+		return "", err
 	}
 	log.Printf("[INFO] absolute output file path: %s", absOutputFile)
-
-	// set some defaults from the environment, if applicable
-	if b.OS == "" {
-		b.OS = utils.GetGOOS()
-	}
-	if b.Arch == "" {
-		b.Arch = utils.GetGOARCH()
-	}
-	if b.ARM == "" {
-		b.ARM = os.Getenv("GOARM")
-	}
-
-	// prepare the build environment
-	buildEnv, err := b.newEnvironment(ctx)
+	err = os.MkdirAll(filepath.Dir(absOutputFile), 0o755)
 	if err != nil {
-		return err
+		return "", err
 	}
-	defer buildEnv.Close()
 
 	// generating windows resources for embedding
 	if b.OS == "windows" {
-		// get version string, we need to parse the output to get the exact version instead tag, branch or commit
-		cmd, err := buildEnv.newGoBuildCommand(ctx, "list", "-m", buildEnv.caddyModulePath)
-		if err != nil {
-			return err
-		}
-		var buffer bytes.Buffer
-		cmd.Stdout = &buffer
-		err = buildEnv.runCommand(ctx, cmd)
+		err = b.resources.generate(caddyVersion, b.Arch, outputFile, buildEnv.tempFolder)
 		if err != nil {
-			return err
-		}
-
-		// output looks like: github.com/caddyserver/caddy/v2 v2.7.6
-		version := strings.TrimPrefix(buffer.String(), buildEnv.caddyModulePath)
-		// if caddy replacement is a local directory, version will be
-		// like v2.8.4 => c:\Users\test\caddy
-		// see https://github.com/caddyserver/xcaddy/issues/215
-		// strings.Cut return the string unchanged if separator is not found
-		version, _, _ = strings.Cut(version, "=>")
-		version = strings.TrimSpace(version)
-		err = utils.WindowsResource(version, outputFile, buildEnv.tempFolder)
-		if err != nil {
-			return err
+			return "", err
 		}
 	}
 
 	if b.SkipBuild {
 		log.Printf("[INFO] Skipping build as requested")
+		buildEnv.logWarnings()
 
-		return nil
+		return outputFile, nil
 	}
 
-	// prepare the environment for the go command; for
-	// the most part we want it to inherit our current
-	// environment, with a few customizations
-	env := os.Environ()
-	env = setEnv(env, "GOOS="+b.OS)
-	env = setEnv(env, "GOARCH="+b.Arch)
-	env = setEnv(env, "GOARM="+b.ARM)
-	if b.RaceDetector && !b.Compile.Cgo {
-		log.Println("[WARNING] Enabling cgo because it is required by the race detector")
-		b.Compile.Cgo = true
-	}
-	env = setEnv(env, fmt.Sprintf("CGO_ENABLED=%s", b.Compile.CgoEnabled()))
+	env := b.environ()
 
 	log.Println("[INFO] Building Caddy")
+	p.start(PhaseTidy)
 
-	// tidy the module to ensure go.mod and go.sum are consistent with the module prereq
+	// tidy the module to ensure go.mod and go.sum are consistent with the module prereq;
+	// -e proceeds despite errors, which may silently drop packages, unless strict
+	requiredBefore, err := buildEnv.requiredModules(ctx)
+	if err != nil {
+		return "", err
+	}
 	tidyCmd := buildEnv.newGoModCommand(ctx, "tidy", "-e")
+	if b.Strict {
+		tidyCmd = buildEnv.newGoModCommand(ctx, "tidy")
+	}
 	if err := buildEnv.runCommand(ctx, tidyCmd); err != nil {
-		return err
+		return "", err
+	}
+	requiredAfter, err := buildEnv.requiredModules(ctx)
+	if err != nil {
+		return "", err
+	}
+	if dropped := droppedPlugins(buildEnv.plugins, requiredBefore, requiredAfter); len(dropped) > 0 {
+		if b.Strict {
+			return "", &Error{Failure: FailurePolicy, Err: fmt.Errorf("go mod tidy removed the modules of plugins: %s", strings.Join(dropped, ", "))}
+		}
+		log.Printf("[WARNING] go mod tidy removed the modules of plugins, which will be missing from the build: %s", strings.Join(dropped, ", "))
+	}
+	deps, err := buildEnv.listDeps(ctx)
+	if err != nil {
+		log.Printf("[WARNING] Unable to list the packages of the build: %v", err)
+	} else {
+		buildEnv.transitivePlugins = findTransitivePlugins(deps, buildEnv.caddyModulePath, buildEnv.plugins, registersModules)
+		if len(buildEnv.transitivePlugins) > 0 {
+			log.Printf("[INFO] Plugins pulled in by other plugins, which are part of the build as well: %s", strings.Join(buildEnv.transitivePlugins, ", "))
+		}
+		if included := stillIncluded(deps, buildEnv.caddyModulePath, buildEnv.without); len(included) > 0 {
+			log.Printf("[WARNING] Standard modules left out as requested are still part of the build, since other modules import them: %s", strings.Join(included, ", "))
+		}
+	}
+
+	// go.mod and go.sum are final now, so they can be embedded
+	if b.EmbedModFiles {
+		err = buildEnv.writeProvenance()
+		if err != nil {
+			return "", err
+		}
+	}
+	if b.Invocation != nil {
+		err = buildEnv.writeInvocation(*b.Invocation)
+		if err != nil {
+			return "", err
+		}
+	}
+	if b.GraphFile != "" {
+		err = buildEnv.writeGraph(ctx, b.GraphFile)
+		if err != nil {
+			return "", err
+		}
+	}
+	if len(b.Why) > 0 {
+		err = buildEnv.explainModules(ctx, b.Why)
+		if err != nil {
+			return "", err
+		}
 	}
 
 	// compile
+	p.start(PhaseCompile)
+	if b.TimeoutCompile > 0 {
+		var cancelCompile context.CancelFunc
+		ctx, cancelCompile = context.WithTimeout(ctx, b.TimeoutCompile)
+		defer cancelCompile()
+	}
+	// the binary is written next to the output file and moved over it
+	// once complete, so a failed or canceled build neither leaves a
+	// partial binary behind nor replaces a good one
+	partialDir, err := os.MkdirTemp(filepath.Dir(absOutputFile), ".xcaddy-build-")
+	if err != nil {
+		return "", err
+	}
+	defer os.RemoveAll(partialDir)
+	partialOutputFile := filepath.Join(partialDir, filepath.Base(absOutputFile))
 	cmd, err := buildEnv.newGoBuildCommand(ctx, "build",
-		"-o", absOutputFile,
+		"-o", partialOutputFile,
 	)
 	if err != nil {
-		return err
+		return "", err
+	}
+	if len(buildEnv.defines) > 0 {
+		log.Printf("[INFO] Defining for plugins: %s", strings.Join(sortedKeys(buildEnv.defines), ", "))
+	}
+	cmd.Args = b.appendBuildFlags(cmd.Args, buildEnv, selected)
+	cmd.Env = buildEnv.environ(env)
+	unlock()
+	unlock = func() {}
+	err = buildEnv.runBuildCommand(ctx, cmd)
+	if err == nil && buildEnv.goPlugin {
+		err = buildEnv.buildGoPlugin(ctx, cmd, partialOutputFile)
+	}
+	unlock = b.lockShared()
+	if err != nil {
+		return "", err
+	}
+	if buildEnv.goPlugin {
+		err = os.Rename(goPluginFile(partialOutputFile), goPluginFile(absOutputFile))
+		if err != nil {
+			return "", err
+		}
+	}
+	err = os.Rename(partialOutputFile, absOutputFile)
+	if err != nil {
+		return "", err
+	}
+	p.end(nil)
+
+	if b.AttestationFile != "" {
+		err = buildEnv.writeAttestation(b, absOutputFile, started, time.Now())
+		if err != nil {
+			return "", err
+		}
+	}
+
+	if b.WriteModFiles {
+		for _, name := range []string{"go.mod", "go.sum"} {
+			src := filepath.Join(buildEnv.tempFolder, name)
+			dst := absOutputFile + "." + name
+			log.Printf("[INFO] Writing %s", dst)
+			err = copyFile(src, dst, 0o644)
+			if err != nil {
+				return "", err
+			}
+		}
 	}
+
+	log.Printf("[INFO] Build complete: %s", outputFile)
+	if b.PruneReport && deps != nil {
+		err = buildEnv.writePruneReport(ctx, b.stdout(), deps)
+		if err != nil {
+			return "", err
+		}
+	}
+	buildEnv.logWarnings()
+
+	return outputFile, nil
+}
+
+// appendBuildFlags appends the flags of go build for b to args, for
+// the build in buildEnv, in which Caddy at selected is part of it.
+func (b Builder) appendBuildFlags(args []string, buildEnv *environment, selected selectedCaddy) []string {
 	if b.Debug {
 		// support dlv
-		cmd.Args = append(cmd.Args, "-gcflags", "all=-N -l")
+		args = append(args, "-gcflags", "all=-N -l")
 	} else {
 		if buildEnv.buildFlags == "" {
-			cmd.Args = append(cmd.Args,
+			args = append(args,
 				"-ldflags", "-w -s", // trim debug symbols
 				"-trimpath",
-				"-tags", "nobadger,nomysql,nopgx",
+				"-tags", b.buildTags(buildEnv),
 			)
 		}
 	}
 
 	if b.RaceDetector {
-		cmd.Args = append(cmd.Args, "-race")
+		args = append(args, "-race")
 	}
-	cmd.Env = env
-	err = buildEnv.runCommand(ctx, cmd)
+	if b.Cover {
+		args = append(args, "-cover", "-coverpkg", coverPackages(b.Plugins))
+	}
+	if len(buildEnv.defines) > 0 {
+		args = addLdflags(args, buildEnv.goFlags, definesLdflag(buildEnv.defines))
+	}
+	// a Caddy replaced by a directory has no version in the
+	// binary, so it is set as the one Caddy reports instead
+	if selected.local {
+		if ldflag := customVersionLdflag(buildEnv.caddyModulePath, selected.version, args, buildEnv.goFlags); ldflag != "" {
+			args = addLdflags(args, buildEnv.goFlags, ldflag)
+		}
+	}
+	return args
+}
+
+// buildTags returns the build tags which appendBuildFlags sets for
+// b in buildEnv, if any; otherwise they are those of GOFLAGS, or of
+// the build flags given by XCADDY_GO_BUILD_FLAGS.
+func (b Builder) buildTags(buildEnv *environment) string {
+	if b.Debug || buildEnv.buildFlags != "" {
+		return ""
+	}
+	// GOFLAGS tags would be overridden, so merge them
+	return mergeTags("nobadger,nomysql,nopgx", buildEnv.goFlagsTags)
+}
+
+// lockShared locks the lock of builds sharing an environment,
+// if any, and returns the function which unlocks it.
+func (b Builder) lockShared() (unlock func()) {
+	if b.shared == nil {
+		return func() {}
+	}
+	b.shared.Lock()
+	return b.shared.Unlock
+}
+
+// withTimeoutTotal returns ctx with the deadline of TimeoutTotal, if any.
+func (b Builder) withTimeoutTotal(ctx context.Context) (context.Context, context.CancelFunc) {
+	if b.TimeoutTotal > 0 {
+		return context.WithTimeout(ctx, b.TimeoutTotal)
+	}
+	return ctx, func() {}
+}
+
+// coverPackages returns the value of -coverpkg which instruments
+// the packages of the plugins, including their subpackages.
+func coverPackages(plugins []Dependency) string {
+	patterns := make([]string, 0, len(plugins))
+	for _, p := range plugins {
+		patterns = append(patterns, p.PackagePath+"/...")
+	}
+	return strings.Join(patterns, ",")
+}
+
+// setDefaults sets the target platform from
+// the environment, if not configured.
+func (b *Builder) setDefaults() {
+	if b.OS == "" {
+		b.OS = utils.GetGOOS()
+	}
+	if b.Arch == "" {
+		b.Arch = utils.GetGOARCH()
+	}
+	if b.ARM == "" {
+		b.ARM = os.Getenv("GOARM")
+	}
+}
+
+// environ returns the environment for the go command; for
+// the most part we want it to inherit our current
+// environment, with a few customizations.
+func (b *Builder) environ() []string {
+	env := os.Environ()
+	env = setEnv(env, "GOOS="+b.OS)
+	env = setEnv(env, "GOARCH="+b.Arch)
+	env = setEnv(env, "GOARM="+b.ARM)
+	if b.RaceDetector && !b.Compile.Cgo {
+		log.Println("[WARNING] Enabling cgo because it is required by the race detector")
+		b.Compile.Cgo = true
+	}
+	return setEnv(env, fmt.Sprintf("CGO_ENABLED=%s", b.Compile.CgoEnabled()))
+}
+
+// Run runs a command, such as "go" with the arguments "vet", "./...",
+// in the build environment, with the same environment variables as
+// the build, writing its standard output to Stdout. The environment in Environment is used if set; otherwise
+// a new one is prepared for the command and cleaned up afterwards.
+func (b Builder) Run(ctx context.Context, name string, args ...string) error {
+	return b.RunOutput(ctx, b.stdout(), name, args...)
+}
+
+// stdout returns where the output of builds is written.
+func (b Builder) stdout() io.Writer {
+	if b.Stdout == nil {
+		return os.Stdout
+	}
+	return b.Stdout
+}
+
+// RunOutput is like Run, but writes the standard output
+// of the command to stdout.
+func (b Builder) RunOutput(ctx context.Context, stdout io.Writer, name string, args ...string) error {
+	ctx, cancel := b.withTimeoutTotal(ctx)
+	defer cancel()
+	b.setDefaults()
+	var buildEnv *environment
+	var err error
+	if b.Environment != "" {
+		buildEnv, err = b.openEnvironment(ctx, b.Environment)
+	} else {
+		buildEnv, err = b.prepareEnvironment(ctx, "")
+	}
+	if err != nil {
+		return withFailure(err, FailureResolution)
+	}
+	defer buildEnv.Close()
+
+	if name == "go" {
+		name = utils.GetGo()
+	}
+	cmd := buildEnv.newCommand(ctx, name, args...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = stdout
+	cmd.Env = buildEnv.environ(b.environ())
+	return buildEnv.runCommand(ctx, cmd)
+}
+
+// PrepareEnvironment prepares a build environment in dir, which must
+// not exist yet, without building anything. Set Environment to dir to
+// build from it, any number of times, without resolving modules again.
+// It is the caller's responsibility to remove dir when finished.
+func (b Builder) PrepareEnvironment(ctx context.Context, dir string) error {
+	if dir == "" {
+		return fmt.Errorf("environment folder is required")
+	}
+	ctx, cancel := b.withTimeoutTotal(ctx)
+	defer cancel()
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return err
+	}
+	p := &phases{report: b.ProgressFunc}
+	p.start(PhasePrepare)
+	env, err := b.prepareEnvironment(ctx, dir)
+	if err != nil {
+		err = phaseFailure(err, PhasePrepare)
+	}
+	p.end(err)
+	if err != nil {
+		return err
+	}
+	err = env.saveState()
 	if err != nil {
+		_ = os.RemoveAll(dir)
 		return err
 	}
+	log.Printf("[INFO] Environment prepared: %s", dir)
+	return nil
+}
 
-	log.Printf("[INFO] Build complete: %s", outputFile)
+// Prefetch downloads all modules needed to build Caddy with the
+// plugins of b into the module cache, without building anything, so
+// later builds with the same cache don't need the network. It uses
+// the environment in Environment if set, or else a temporary one.
+func (b Builder) Prefetch(ctx context.Context) error {
+	ctx, cancel := b.withTimeoutTotal(ctx)
+	defer cancel()
+	b.setDefaults()
+	var buildEnv *environment
+	var err error
+	if b.Environment != "" {
+		buildEnv, err = b.openEnvironment(ctx, b.Environment)
+	} else {
+		buildEnv, err = b.prepareEnvironment(ctx, "")
+	}
+	if err != nil {
+		return withFailure(err, FailureResolution)
+	}
+	defer buildEnv.Close()
 
+	// without arguments, go mod download fetches the modules needed
+	// to build and test the packages of the main module, which are
+	// exactly those a build needs, as opposed to the whole graph
+	log.Println("[INFO] Downloading modules")
+	cmd := buildEnv.newGoModCommand(ctx, "download")
+	err = buildEnv.runCommand(ctx, cmd)
+	if err != nil {
+		return withFailure(err, FailureResolution)
+	}
+	log.Println("[INFO] Modules downloaded")
 	return nil
 }
 
+// prepareEnvironment resolves the Caddy version and prepares
+// a build environment in folder, or a temporary folder if empty.
+func (b Builder) prepareEnvironment(ctx context.Context, folder string) (*environment, error) {
+	if err := checkMainPreset(b.MainPreset, b.MainDefaults); err != nil {
+		return nil, err
+	}
+	if err := checkBuildMode(b.BuildMode, b); err != nil {
+		return nil, err
+	}
+	if err := checkReplaceRoots(b.Replacements, b.ReplaceRoots); err != nil {
+		return nil, &Error{Failure: FailurePolicy, Err: err}
+	}
+	if b.AttestationKey != "" {
+		// the key is checked before the build, which it would fail
+		if b.AttestationFile == "" {
+			return nil, fmt.Errorf("an attestation key requires an attestation file")
+		}
+		if _, err := readSigningKey(b.AttestationKey); err != nil {
+			return nil, err
+		}
+	}
+	// resolve version channels and constraints to a concrete version;
+	// offline, the versions are those in the module cache, which are
+	// not cached, unlike those of the module proxy
+	var environ []string
+	if b.Offline {
+		modCache, err := b.moduleCache(ctx)
+		if err != nil {
+			return nil, err
+		}
+		environ = offlineEnviron(os.Environ(), modCache)
+		ctx = WithoutCache(ctx)
+	}
+	caddyVersion, err := resolveCaddyVersion(ctx, b.CaddyVersion, environ)
+	if err != nil {
+		return nil, err
+	}
+	if caddyVersion != b.CaddyVersion {
+		log.Printf("[INFO] Resolved Caddy version %s to %s", b.CaddyVersion, caddyVersion)
+		b.CaddyVersion = caddyVersion
+	}
+	return b.newEnvironment(ctx, folder)
+}
+
 // setEnv sets an environment variable-value pair in
 // env, overriding an existing variable if it already
 // exists. The env slice is one such as is returned
@@ -248,10 +933,221 @@ func NewReplace(old, new string) Replace {
 	}
 }
 
+// Validate returns an error if the replacement cannot be expressed
+// as a go.mod replace directive. The new path must either be a local
+// directory or a module path with a version, for example to replace
+// a module with a tagged release of a fork.
+func (r Replace) Validate() error {
+	oldPath, oldVersion, _ := strings.Cut(r.Old.Param(), "@")
+	if oldPath == "" {
+		return fmt.Errorf("replace %s: module path to replace is required", r.Old)
+	}
+	if r.isPattern() {
+		if strings.Count(oldPath, "*") > 1 || strings.Contains(r.Old.Param(), "@") {
+			return fmt.Errorf("replace %s: a module path pattern must contain a single * and no version", r.Old)
+		}
+		if !strings.Contains(string(r.New), "{name}") {
+			return fmt.Errorf("replace %s => %s: the replacement of a module path pattern must contain {name}", r.Old, r.New)
+		}
+	}
+	if strings.HasPrefix(r.Old.Param(), ".") || filepath.IsAbs(oldPath) {
+		return fmt.Errorf("replace %s: module path to replace must not be a local path", r.Old)
+	}
+	if strings.Contains(r.Old.Param(), "@") && oldVersion == "" {
+		return fmt.Errorf("replace %s: version is empty", r.Old)
+	}
+	if r.New == "" {
+		return fmt.Errorf("replace %s: replacement is required", r.Old)
+	}
+	if r.New.isLocal() {
+		return nil
+	}
+	newPath, newVersion, _ := strings.Cut(r.New.Param(), "@")
+	if newPath == "" || newVersion == "" {
+		return fmt.Errorf("replace %s => %s: module replacement must have a version (module@version)", r.Old, r.New)
+	}
+	return nil
+}
+
+// isPattern returns true if the old path of r is a pattern like
+// example.com/org/*, which replaces each module of the build that
+// matches it; {name} in the new path is what the * matched.
+func (r Replace) isPattern() bool {
+	return strings.Contains(string(r.Old), "*")
+}
+
+// expandReplacePattern returns a replacement for each of the
+// modulePaths that matches the pattern of r.
+func expandReplacePattern(r Replace, modulePaths []string) []Replace {
+	prefix, suffix, _ := strings.Cut(r.Old.String(), "*")
+	var replacements []Replace
+	for _, modulePath := range modulePaths {
+		if len(modulePath) <= len(prefix)+len(suffix) || !strings.HasPrefix(modulePath, prefix) || !strings.HasSuffix(modulePath, suffix) {
+			continue
+		}
+		// like path.Match, * doesn't match across path elements
+		name := modulePath[len(prefix) : len(modulePath)-len(suffix)]
+		if strings.Contains(name, "/") {
+			continue
+		}
+		replacements = append(replacements, NewReplace(modulePath, strings.ReplaceAll(r.New.String(), "{name}", name)))
+	}
+	return replacements
+}
+
+// uniquePlugins returns plugins with each package only once, in the
+// order they were first given. A package given again at the same
+// version, or without one, is left out, and one given without a
+// version takes the version it is given with elsewhere. A package
+// given at two different versions is an error, since go get would
+// quietly build the one it got last.
+func uniquePlugins(plugins []Dependency) ([]Dependency, error) {
+	var unique []Dependency
+	index := make(map[string]int)
+	for _, p := range plugins {
+		i, ok := index[p.PackagePath]
+		if !ok {
+			index[p.PackagePath] = len(unique)
+			unique = append(unique, p)
+			continue
+		}
+		seen := unique[i]
+		switch {
+		case seen.Version == p.Version || p.Version == "":
+		case seen.Version == "":
+			unique[i].Version = p.Version
+		default:
+			return nil, fmt.Errorf("plugin %s is given at two versions, %s and %s; give it once", p.PackagePath, seen.Version, p.Version)
+		}
+		log.Printf("[INFO] Plugin %s is given more than once; building it once as %s", p.PackagePath, unique[i])
+	}
+	return unique, nil
+}
+
+// shadowedVersions returns a warning for each dependency which is
+// pinned to a version, but replaced, which makes the pin inert.
+// A replacement applies to a dependency if it replaces its module
+// (or a parent module of its package) at any or the pinned version.
+func shadowedVersions(deps []Dependency, replacements []Replace) []string {
+	var warnings []string
+	for _, d := range deps {
+		if d.Version == "" {
+			continue
+		}
+		for _, r := range replacements {
+			oldPath, oldVersion, _ := strings.Cut(r.Old.Param(), "@")
+			if d.PackagePath != oldPath && !strings.HasPrefix(d.PackagePath, oldPath+"/") {
+				continue
+			}
+			if oldVersion != "" && oldVersion != d.Version {
+				continue
+			}
+			warnings = append(warnings, fmt.Sprintf("%s is pinned to %s, but it is replaced by %s, which is used instead", d.PackagePath, d.Version, r.New))
+		}
+	}
+	return warnings
+}
+
+// isLocal returns true if r refers to a directory on
+// disk rather than a module path.
+func (r ReplacementPath) isLocal() bool {
+	return strings.HasPrefix(string(r), ".") || filepath.IsAbs(string(r))
+}
+
+// checkReplaceRoots returns an error if one of the local replacements
+// is not a directory within one of roots, unless there are no roots.
+// The replacement of a pattern is checked up to its {name}, and
+// each replacement it expands to is checked again once the modules
+// of the build are known, since what follows {name} may lead
+// elsewhere, like through .. or a symlink.
+func checkReplaceRoots(replacements []Replace, roots []string) error {
+	if len(roots) == 0 {
+		return nil
+	}
+	resolvedRoots := make([]string, 0, len(roots))
+	for _, root := range roots {
+		dir, err := resolvePath(root)
+		if err != nil {
+			return fmt.Errorf("replacement root %s: %v", root, err)
+		}
+		resolvedRoots = append(resolvedRoots, dir)
+	}
+	for _, r := range replacements {
+		if !r.New.isLocal() {
+			continue
+		}
+		newPath := string(r.New)
+		if r.isPattern() {
+			before, _, _ := strings.Cut(newPath, "{name}")
+			newPath = filepath.Dir(before)
+		}
+		dir, err := resolvePath(newPath)
+		if err != nil {
+			return fmt.Errorf("replace %s => %s: %v", r.Old, r.New, err)
+		}
+		if !withinAny(dir, resolvedRoots) {
+			return fmt.Errorf("replace %s => %s: replacements must be within %s", r.Old, r.New, strings.Join(roots, ", "))
+		}
+	}
+	return nil
+}
+
+// resolvePath returns the absolute path of file,
+// with any symlinks in it resolved.
+func resolvePath(file string) (string, error) {
+	abs, err := filepath.Abs(file)
+	if err != nil {
+		return "", err
+	}
+	return filepath.EvalSymlinks(abs)
+}
+
+// withinAny returns true if dir is one of dirs,
+// or a path within one of them.
+func withinAny(dir string, dirs []string) bool {
+	for _, d := range dirs {
+		rel, err := filepath.Rel(d, dir)
+		if err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return true
+		}
+	}
+	return false
+}
+
+// Patch represents a diff to apply to the source of a
+// dependency before building.
+type Patch struct {
+	// The path of the Go module to patch.
+	ModulePath string `json:"module_path,omitempty"`
+
+	// The path to the patch file, as produced by
+	// `git diff` or `diff -u`, relative to the
+	// current folder if not absolute. The paths
+	// in the patch are relative to the root of
+	// the module.
+	File string `json:"file,omitempty"`
+}
+
+func (p Patch) String() string { return p.ModulePath + "=" + p.File }
+
 // newTempFolder creates a new folder in a temporary location.
 // It is the caller's responsibility to remove the folder when finished.
 func newTempFolder() (string, error) {
-	var parentDir string
+	parentDir, err := tempParentDir()
+	if err != nil {
+		return "", err
+	}
+	if runtime.GOOS == "windows" && !longPathsEnabled() {
+		// every character counts against the limit of path lengths
+		return os.MkdirTemp(parentDir, "xc")
+	}
+	ts := time.Now().Format(yearMonthDayHourMin)
+	return os.MkdirTemp(parentDir, fmt.Sprintf("buildenv_%s.", ts))
+}
+
+// tempParentDir returns the folder in which newTempFolder
+// creates temporary folders.
+func tempParentDir() (string, error) {
 	if runtime.GOOS == "darwin" {
 		// After upgrading to macOS High Sierra, Caddy builds mysteriously
 		// started missing the embedded version information that -ldflags
@@ -272,14 +1168,9 @@ func newTempFolder() (string, error) {
 		// and https://twitter.com/mholt6/status/978345803365273600 (thread)
 		// (using an absolute path prevents problems later when removing this
 		// folder if the CWD changes)
-		var err error
-		parentDir, err = filepath.Abs(".")
-		if err != nil {
-			return "", err
-		}
+		return filepath.Abs(".")
 	}
-	ts := time.Now().Format(yearMonthDayHourMin)
-	return os.MkdirTemp(parentDir, fmt.Sprintf("buildenv_%s.", ts))
+	return os.TempDir(), nil
 }
 
 // versionedModulePath helps enforce Go Module's Semantic Import Versioning (SIV) by
@@ -323,10 +1214,96 @@ func versionedModulePath(modulePath, moduleVersion string) (string, error) {
 
 var moduleVersionRegexp = regexp.MustCompile(`.+/v(\d+)$`)
 
+// resolveCaddyVersion resolves version channels and constraints to the
+// newest matching tag of Caddy, using the version list from the module
+// proxy. The "beta" channel is the newest tag including pre-releases;
+// constraints are anything like "2.8.x", "~2.8" or ">=2.8 <2.10". All
+// other versions, including "latest", are returned as-is for go get.
+// The go command runs with environ, or the environment of xcaddy if nil.
+func resolveCaddyVersion(ctx context.Context, version string, environ []string) (string, error) {
+	if version != "beta" && !isVersionConstraint(version) {
+		return version, nil
+	}
+	var constraint *semver.Constraints
+	if version != "beta" {
+		var err error
+		constraint, err = semver.NewConstraint(version)
+		if err != nil {
+			return "", fmt.Errorf("invalid Caddy version constraint %s: %v", version, err)
+		}
+	}
+
+	versions, err := utils.Cached(ctx, "versions "+defaultCaddyModulePath+"/v2", versionsCacheTTL, func() ([]string, error) {
+		cmd := exec.CommandContext(ctx, utils.GetGo(), "list", "-m", "-versions", "-json", defaultCaddyModulePath+"/v2")
+		cmd.Env = environ
+		cmd.Stderr = os.Stderr
+		out, err := cmd.Output()
+		if err != nil {
+			return nil, fmt.Errorf("listing Caddy versions: %v", err)
+		}
+		var mod struct {
+			Versions []string
+		}
+		err = json.Unmarshal(out, &mod)
+		return mod.Versions, err
+	})
+	if err != nil {
+		return "", err
+	}
+
+	var newest *semver.Version
+	for _, v := range versions {
+		ver, err := semver.NewVersion(v)
+		if err != nil {
+			continue
+		}
+		if constraint != nil && !constraint.Check(ver) {
+			continue
+		}
+		if newest == nil || ver.GreaterThan(newest) {
+			newest = ver
+		}
+	}
+	if newest == nil {
+		return "", fmt.Errorf("no Caddy version matches %s", version)
+	}
+	return newest.Original(), nil
+}
+
+// WithoutCache returns a copy of ctx with which lookups, like of
+// the versions of Caddy or the platforms supported by the go command,
+// are made again instead of taken from the cache in the cache folder
+// of the user, and their results are not cached.
+func WithoutCache(ctx context.Context) context.Context {
+	return utils.WithoutCache(ctx)
+}
+
+// The times for which the results of lookups are cached;
+// releases are frequent compared to new platforms of Go.
+const (
+	versionsCacheTTL  = time.Hour
+	platformsCacheTTL = 24 * time.Hour
+)
+
+// isVersionConstraint returns true if version is a semantic
+// version constraint rather than something go get understands,
+// such as an exact version, a branch, or a commit.
+func isVersionConstraint(version string) bool {
+	if strings.ContainsAny(version, "<>=~^!*|, ") ||
+		strings.HasSuffix(version, ".x") || strings.HasSuffix(version, ".X") {
+		return true
+	}
+	// versions without the "v" prefix, like "2.8" or "2.8.4"
+	return versionWithoutPrefixRegexp.MatchString(version)
+}
+
+var versionWithoutPrefixRegexp = regexp.MustCompile(`^\d+\.\d+(\.\d+)?$`)
+
 const (
 	// yearMonthDayHourMin is the date format
 	// used for temporary folder paths.
 	yearMonthDayHourMin = "2006-01-02-1504"
 
 	defaultCaddyModulePath = "github.com/caddyserver/caddy"
+	defaultCaddyRepository = "https://github.com/caddyserver/caddy.git"
 )
@@ -15,8 +15,13 @@
 package xcaddy
 
 import (
+	"context"
 	"fmt"
+	"os"
+	"path/filepath"
 	"reflect"
+	"runtime"
+	"strings"
 	"testing"
 )
 
@@ -86,3 +91,432 @@ func TestNewReplace(t *testing.T) {
 		})
 	}
 }
+
+func TestReplace_Validate(t *testing.T) {
+	tests := []struct {
+		name    string
+		r       Replace
+		wantErr bool
+	}{
+		{
+			"Local Path",
+			NewReplace("github.com/x/y", "../y"),
+			false,
+		},
+		{
+			"Absolute Path",
+			NewReplace("github.com/x/y@v1.2.3", "/x/y/z"),
+			false,
+		},
+		{
+			"Fork With Version",
+			NewReplace("github.com/x/y@v1.2.3", "github.com/fork/y@v1.2.3-patched"),
+			false,
+		},
+		{
+			"Fork With Version go.mod Syntax",
+			NewReplace("github.com/x/y v1.2.3", "github.com/fork/y v1.2.3-patched"),
+			false,
+		},
+		{
+			"Fork Without Version",
+			NewReplace("github.com/x/y@v1.2.3", "github.com/fork/y"),
+			true,
+		},
+		{
+			"Fork With Empty Version",
+			NewReplace("github.com/x/y", "github.com/fork/y@"),
+			true,
+		},
+		{
+			"Empty Old Version",
+			NewReplace("github.com/x/y@", "../y"),
+			true,
+		},
+		{
+			"Empty Old",
+			NewReplace("", "../y"),
+			true,
+		},
+		{
+			"Pattern",
+			NewReplace("github.com/x/*", "../forks/{name}"),
+			false,
+		},
+		{
+			"Pattern Without Placeholder",
+			NewReplace("github.com/x/*", "../forks/y"),
+			true,
+		},
+		{
+			"Pattern With Version",
+			NewReplace("github.com/x/*@v1.2.3", "../forks/{name}"),
+			true,
+		},
+		{
+			"Pattern With Two Wildcards",
+			NewReplace("github.com/*/*", "../forks/{name}"),
+			true,
+		},
+		{
+			"Empty New",
+			NewReplace("github.com/x/y", ""),
+			true,
+		},
+		{
+			"Local Old",
+			NewReplace("./y", "../y"),
+			true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := tt.r.Validate(); (err != nil) != tt.wantErr {
+				t.Errorf("Replace.Validate() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestIsVersionConstraint(t *testing.T) {
+	tests := []struct {
+		version string
+		want    bool
+	}{
+		{"", false},
+		{"latest", false},
+		{"master", false},
+		{"v2.8.4", false},
+		{"v2.9.0-beta.3", false},
+		{"a58f240d3ecbb59285303746406cab50217f8d24", false},
+		{"1234567", false},
+		{"2.8.4", true},
+		{"2.8", true},
+		{"2.8.x", true},
+		{"~2.8", true},
+		{"^2", true},
+		{">=2.8 <2.10", true},
+		{">=2.8, <2.10", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.version, func(t *testing.T) {
+			if got := isVersionConstraint(tt.version); got != tt.want {
+				t.Errorf("isVersionConstraint(%q) = %v, want %v", tt.version, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestUniquePlugins(t *testing.T) {
+	const cloudflare, l4 = "github.com/caddy-dns/cloudflare", "github.com/mholt/caddy-l4"
+	tests := []struct {
+		name    string
+		plugins []Dependency
+		want    []Dependency
+		wantErr bool
+	}{
+		{
+			name:    "unique",
+			plugins: []Dependency{{PackagePath: cloudflare, Version: "v0.1.0"}, {PackagePath: l4}},
+			want:    []Dependency{{PackagePath: cloudflare, Version: "v0.1.0"}, {PackagePath: l4}},
+		},
+		{
+			name:    "same version",
+			plugins: []Dependency{{PackagePath: cloudflare, Version: "v0.1.0"}, {PackagePath: l4}, {PackagePath: cloudflare, Version: "v0.1.0"}},
+			want:    []Dependency{{PackagePath: cloudflare, Version: "v0.1.0"}, {PackagePath: l4}},
+		},
+		{
+			name:    "without version",
+			plugins: []Dependency{{PackagePath: l4}, {PackagePath: cloudflare}, {PackagePath: l4, Version: "v0.0.0-20240101000000-abcdef123456"}, {PackagePath: l4}},
+			want:    []Dependency{{PackagePath: l4, Version: "v0.0.0-20240101000000-abcdef123456"}, {PackagePath: cloudflare}},
+		},
+		{
+			name:    "conflicting versions",
+			plugins: []Dependency{{PackagePath: cloudflare, Version: "v0.1.0"}, {PackagePath: cloudflare}, {PackagePath: cloudflare, Version: "v0.2.0"}},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := uniquePlugins(tt.plugins)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("uniquePlugins() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("uniquePlugins() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestShadowedVersions(t *testing.T) {
+	tests := []struct {
+		name         string
+		deps         []Dependency
+		replacements []Replace
+		want         int
+	}{
+		{
+			name:         "local replacement of pinned plugin",
+			deps:         []Dependency{{PackagePath: "github.com/me/plugin", Version: "v1.5.0"}},
+			replacements: []Replace{NewReplace("github.com/me/plugin@v1.5.0", "/home/me/plugin")},
+			want:         1,
+		},
+		{
+			name:         "unversioned replacement of package in pinned module",
+			deps:         []Dependency{{PackagePath: "github.com/me/plugin/caddy", Version: "v1.5.0"}},
+			replacements: []Replace{NewReplace("github.com/me/plugin", "github.com/fork/plugin@v1.5.1")},
+			want:         1,
+		},
+		{
+			name:         "replacement of other version",
+			deps:         []Dependency{{PackagePath: "github.com/me/plugin", Version: "v1.5.0"}},
+			replacements: []Replace{NewReplace("github.com/me/plugin@v1.4.0", "/home/me/plugin")},
+			want:         0,
+		},
+		{
+			name:         "unpinned plugin",
+			deps:         []Dependency{{PackagePath: "github.com/me/plugin"}},
+			replacements: []Replace{NewReplace("github.com/me/plugin", "/home/me/plugin")},
+			want:         0,
+		},
+		{
+			name:         "replacement of module with common prefix",
+			deps:         []Dependency{{PackagePath: "github.com/me/plugin-extra", Version: "v1.5.0"}},
+			replacements: []Replace{NewReplace("github.com/me/plugin", "/home/me/plugin")},
+			want:         0,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := shadowedVersions(tt.deps, tt.replacements); len(got) != tt.want {
+				t.Errorf("shadowedVersions() = %v, want %d warnings", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestExpandReplacePattern(t *testing.T) {
+	modulePaths := []string{
+		"caddy",
+		"github.com/caddyserver/caddy/v2",
+		"github.com/myorg/caddy-auth",
+		"github.com/myorg/caddy-auth/v2",
+		"github.com/myorg/logging",
+		"github.com/otherorg/logging",
+	}
+	tests := []struct {
+		pattern Replace
+		want    []Replace
+	}{
+		{
+			pattern: NewReplace("github.com/myorg/*", "/forks/{name}"),
+			want: []Replace{
+				NewReplace("github.com/myorg/caddy-auth", "/forks/caddy-auth"),
+				NewReplace("github.com/myorg/logging", "/forks/logging"),
+			},
+		},
+		{
+			pattern: NewReplace("github.com/myorg/caddy-*/v2", "github.com/fork/caddy-{name}/v2@v2.0.1"),
+			want: []Replace{
+				NewReplace("github.com/myorg/caddy-auth/v2", "github.com/fork/caddy-auth/v2@v2.0.1"),
+			},
+		},
+		{
+			pattern: NewReplace("github.com/nobody/*", "/forks/{name}"),
+			want:    nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.pattern.Old.String(), func(t *testing.T) {
+			if got := expandReplacePattern(tt.pattern, modulePaths); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("expandReplacePattern() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckReplaceRoots(t *testing.T) {
+	root := t.TempDir()
+	outside := t.TempDir()
+	for _, dir := range []string{filepath.Join(root, "plugin"), filepath.Join(root, "forks"), filepath.Join(outside, "plugin")} {
+		if err := os.Mkdir(dir, 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.Symlink(filepath.Join(outside, "plugin"), filepath.Join(root, "link")); err != nil {
+		t.Skipf("symlinks not supported: %v", err)
+	}
+
+	tests := []struct {
+		name         string
+		replacements []Replace
+		roots        []string
+		wantErr      bool
+	}{
+		{name: "no roots", replacements: []Replace{NewReplace("example.com/plugin", filepath.Join(outside, "plugin"))}},
+		{name: "within", replacements: []Replace{NewReplace("example.com/plugin", filepath.Join(root, "plugin"))}, roots: []string{root}},
+		{name: "root itself", replacements: []Replace{NewReplace("example.com/plugin", root)}, roots: []string{root}},
+		{name: "second root", replacements: []Replace{NewReplace("example.com/plugin", filepath.Join(outside, "plugin"))}, roots: []string{root, outside}},
+		{name: "module", replacements: []Replace{NewReplace("example.com/plugin", "example.com/fork@v1.0.0")}, roots: []string{root}},
+		{name: "pattern", replacements: []Replace{NewReplace("example.com/*", filepath.Join(root, "forks", "{name}"))}, roots: []string{root}},
+		{name: "outside", replacements: []Replace{NewReplace("example.com/plugin", filepath.Join(outside, "plugin"))}, roots: []string{root}, wantErr: true},
+		{name: "dot dot", replacements: []Replace{NewReplace("example.com/plugin", filepath.Join(root, "..", filepath.Base(outside), "plugin"))}, roots: []string{root}, wantErr: true},
+		{name: "symlink", replacements: []Replace{NewReplace("example.com/plugin", filepath.Join(root, "link"))}, roots: []string{root}, wantErr: true},
+		{name: "pattern outside", replacements: []Replace{NewReplace("example.com/*", filepath.Join(outside, "{name}"))}, roots: []string{root}, wantErr: true},
+		{name: "missing", replacements: []Replace{NewReplace("example.com/plugin", filepath.Join(root, "missing"))}, roots: []string{root}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := checkReplaceRoots(tt.replacements, tt.roots); (err != nil) != tt.wantErr {
+				t.Errorf("checkReplaceRoots() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestCoverPackages(t *testing.T) {
+	got := coverPackages([]Dependency{
+		{PackagePath: "github.com/caddy-dns/cloudflare", Version: "v0.1.0"},
+		{PackagePath: "github.com/me/myplugin"},
+	})
+	if expected := "github.com/caddy-dns/cloudflare/...,github.com/me/myplugin/..."; got != expected {
+		t.Errorf("Expected %s, got %s", expected, got)
+	}
+}
+
+func TestBuildKeepsOutputOnFailure(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses a shell script as the go command")
+	}
+	dir := t.TempDir()
+	goCmd := filepath.Join(dir, "go")
+	// the build writes part of the binary, then fails unless told otherwise
+	script := `#!/bin/sh
+if [ "$1 $2" = "mod edit" ]; then
+	echo '{}'
+fi
+if [ "$1" = build ]; then
+	while [ "$1" != -o ]; do shift; done
+	echo partial > "$2"
+	[ -n "$BUILD_SUCCEEDS" ] || exit 1
+	echo complete > "$2"
+fi
+`
+	if err := os.WriteFile(goCmd, []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("XCADDY_WHICH_GO", goCmd)
+	t.Setenv("XCADDY_GO_BUILD_FLAGS", "")
+	t.Setenv("GOFLAGS", "")
+	envDir := filepath.Join(dir, "env")
+	if err := os.Mkdir(envDir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(envDir, environmentStateFile), []byte(`{"caddy_module_path": "github.com/caddyserver/caddy/v2"}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	outDir := filepath.Join(dir, "out")
+	if err := os.Mkdir(outDir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	output := filepath.Join(outDir, "caddy")
+	if err := os.WriteFile(output, []byte("good\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	b := Builder{Environment: envDir, SkipPreflight: true}
+	if err := b.Build(context.Background(), output); err == nil {
+		t.Fatal("Build() succeeded, want error")
+	}
+	assertOutput := func(want string) {
+		t.Helper()
+		got, err := os.ReadFile(output)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != want {
+			t.Errorf("output file is %q, want %q", got, want)
+		}
+		entries, err := os.ReadDir(outDir)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(entries) != 1 {
+			t.Errorf("output folder has %d entries, want only the binary", len(entries))
+		}
+	}
+	assertOutput("good\n")
+
+	t.Setenv("BUILD_SUCCEEDS", "1")
+	if err := b.Build(context.Background(), output); err != nil {
+		t.Fatalf("Build() error = %v", err)
+	}
+	assertOutput("complete\n")
+}
+
+func TestBuildSelectsCaddyOnlyIfNeeded(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses a shell script as the go command")
+	}
+	dir := t.TempDir()
+	logFile := filepath.Join(dir, "log")
+	goCmd := filepath.Join(dir, "go")
+	script := `#!/bin/sh
+echo "$*" >> "` + logFile + `"
+if [ "$1 $2" = "mod edit" ]; then
+	echo '{}'
+fi
+if [ "$1 $2 $3" = "list -m -json" ]; then
+	echo '{"Path": "github.com/caddyserver/caddy/v2", "Version": "v2.8.4", "Replace": {"Path": "` + dir + `/caddy-src"}}'
+fi
+if [ "$1" = build ]; then
+	while [ "$1" != -o ]; do shift; done
+	echo complete > "$2"
+fi
+`
+	if err := os.WriteFile(goCmd, []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("XCADDY_WHICH_GO", goCmd)
+	t.Setenv("XCADDY_GO_BUILD_FLAGS", "")
+	t.Setenv("GOFLAGS", "")
+	envDir := filepath.Join(dir, "env")
+	if err := os.Mkdir(envDir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(envDir, environmentStateFile), []byte(`{"caddy_module_path": "github.com/caddyserver/caddy/v2"}`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	b := Builder{Environment: envDir, SkipPreflight: true}
+
+	for _, tt := range []struct {
+		name       string
+		gomod      string
+		wantSelect bool
+	}{
+		{name: "caddy from its module", gomod: "module caddy\n\nrequire github.com/caddyserver/caddy/v2 v2.8.4\n"},
+		{name: "caddy replaced by a directory", gomod: "module caddy\n\nreplace github.com/caddyserver/caddy/v2 => ../caddy-src\n", wantSelect: true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := os.WriteFile(filepath.Join(envDir, "go.mod"), []byte(tt.gomod), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			if err := os.WriteFile(logFile, nil, 0o644); err != nil {
+				t.Fatal(err)
+			}
+			if err := b.Build(context.Background(), filepath.Join(dir, "caddy")); err != nil {
+				t.Fatalf("Build() error = %v", err)
+			}
+			ran, err := os.ReadFile(logFile)
+			if err != nil {
+				t.Fatal(err)
+			}
+			selected := strings.Contains(string(ran), "list -m -json github.com/caddyserver/caddy/v2")
+			versioned := strings.Contains(string(ran), "github.com/caddyserver/caddy/v2.CustomVersion=v2.8.4")
+			if selected != tt.wantSelect || versioned != tt.wantSelect {
+				t.Errorf("Build() ran:\n%s\nwant the version of Caddy selected and set: %t", ran, tt.wantSelect)
+			}
+		})
+	}
+}
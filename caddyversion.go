@@ -0,0 +1,183 @@
+// Copyright 2020 Matthew Holt
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package xcaddy
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/Masterminds/semver/v3"
+)
+
+// selectedCaddy is the version of Caddy which was selected in an
+// environment, instead of a tag, branch or commit.
+type selectedCaddy struct {
+	version string
+
+	// whether Caddy is replaced by a directory, like a clone,
+	// so the version is derived from it, and isn't recorded in
+	// the binary, unless it is set as Caddy's CustomVersion
+	local bool
+}
+
+// selectCaddy returns the version of Caddy selected in the
+// environment. If Caddy is replaced by a fork, it is the version
+// of the fork, and if it is replaced by a directory, it is derived
+// from the git checkout in it; see checkoutVersion.
+func (env environment) selectCaddy(ctx context.Context) (selectedCaddy, error) {
+	cmd, err := env.newGoBuildCommand(ctx, "list", "-m", "-json", env.caddyModulePath)
+	if err != nil {
+		return selectedCaddy{}, err
+	}
+	var buffer bytes.Buffer
+	cmd.Stdout = &buffer
+	err = env.runCommand(ctx, cmd)
+	if err != nil {
+		return selectedCaddy{}, err
+	}
+	var mod struct {
+		Version string
+		Replace *struct {
+			Path    string
+			Version string
+			Dir     string
+		}
+	}
+	err = json.Unmarshal(buffer.Bytes(), &mod)
+	if err != nil {
+		return selectedCaddy{}, fmt.Errorf("parsing go list output: %v", err)
+	}
+	if mod.Replace == nil {
+		return selectedCaddy{version: mod.Version}, nil
+	}
+	if mod.Replace.Version != "" {
+		return selectedCaddy{version: mod.Replace.Version}, nil
+	}
+	dir := mod.Replace.Dir
+	if dir == "" {
+		dir = mod.Replace.Path
+	}
+	if !filepath.IsAbs(dir) {
+		dir = filepath.Join(env.tempFolder, dir)
+	}
+	version := env.checkoutVersion(ctx, dir, mod.Version)
+	log.Printf("[INFO] Caddy is replaced by %s, whose version is %s", dir, version)
+	return selectedCaddy{version: version, local: true}, nil
+}
+
+// caddyReplacedLocally reports whether go.mod of the environment
+// replaces Caddy by a directory, in which case the version Caddy
+// reports must be derived from it; see selectCaddy. It reads go.mod
+// instead of running a go command, so builds which don't need the
+// selected version of Caddy don't take longer.
+func (env environment) caddyReplacedLocally() bool {
+	data, err := os.ReadFile(filepath.Join(env.tempFolder, "go.mod"))
+	if err != nil {
+		return false
+	}
+	var inBlock bool
+	for _, line := range strings.Split(string(data), "\n") {
+		line, _, _ = strings.Cut(line, "//")
+		fields := strings.Fields(line)
+		switch {
+		case len(fields) == 0:
+			continue
+		case inBlock && fields[0] == ")":
+			inBlock = false
+			continue
+		case !inBlock && fields[0] == "replace":
+			if len(fields) == 2 && fields[1] == "(" {
+				inBlock = true
+				continue
+			}
+			fields = fields[1:]
+		case !inBlock:
+			continue
+		}
+		old, repl, ok := strings.Cut(strings.Join(fields, " "), "=>")
+		if oldFields := strings.Fields(old); ok && len(oldFields) > 0 && strings.Trim(oldFields[0], `"`) == env.caddyModulePath {
+			return isDirReplacement(strings.TrimSpace(repl))
+		}
+	}
+	return false
+}
+
+// isDirReplacement reports whether repl, the right side of a replace
+// directive, is a directory: its path has no version, unlike that of
+// a module, and may be quoted if it has spaces.
+func isDirReplacement(repl string) bool {
+	if strings.HasPrefix(repl, `"`) {
+		end := strings.Index(repl[1:], `"`)
+		return end >= 0 && strings.TrimSpace(repl[end+2:]) == ""
+	}
+	return len(strings.Fields(repl)) == 1
+}
+
+// checkoutVersion returns the version of the Caddy module in dir,
+// as described by git from its tags, like v2.8.4-3-g0123456789ab,
+// or a pseudo-version of its commit if it has no tags, like a
+// shallow clone. If dir isn't a git checkout, it is fallback,
+// the version required in go.mod.
+func (env environment) checkoutVersion(ctx context.Context, dir, fallback string) string {
+	describe, err := env.gitOutput(ctx, dir, "describe", "--tags", "--match", "v*", "--abbrev=12", "--dirty")
+	if err == nil {
+		if _, err := semver.NewVersion(describe); err == nil {
+			return describe
+		}
+	}
+	commit, err := env.gitOutput(ctx, dir, "log", "-1", "--format=%cd %H", "--date=format-local:%Y%m%d%H%M%S")
+	if date, hash, ok := strings.Cut(commit, " "); err == nil && ok && len(hash) >= 12 {
+		base := "v0.0.0"
+		if matches := moduleVersionRegexp.FindStringSubmatch(env.caddyModulePath); len(matches) == 2 {
+			base = "v" + matches[1] + ".0.0"
+		}
+		return base + "-" + date + "-" + hash[:12]
+	}
+	return fallback
+}
+
+// gitOutput runs git with args in dir, with dates in UTC,
+// and returns its output without surrounding whitespace.
+func (env environment) gitOutput(ctx context.Context, dir string, args ...string) (string, error) {
+	cmd := env.newCommand(ctx, "git", append([]string{"-C", dir}, args...)...)
+	cmd.Env = setEnv(cmd.Env, "TZ=UTC")
+	var buffer bytes.Buffer
+	cmd.Stdout = &buffer
+	cmd.Stderr = io.Discard
+	err := cmd.Run()
+	return strings.TrimSpace(buffer.String()), err
+}
+
+// customVersionLdflag returns the linker flag which sets the version
+// Caddy reports to version, unless args or goFlags already set it.
+func customVersionLdflag(caddyModulePath, version string, args []string, goFlags string) string {
+	variable := caddyModulePath + ".CustomVersion="
+	if strings.Contains(goFlags, variable) {
+		return ""
+	}
+	for _, arg := range args {
+		if strings.Contains(arg, variable) {
+			return ""
+		}
+	}
+	return "-X " + variable + version
+}
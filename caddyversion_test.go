@@ -0,0 +1,149 @@
+// Copyright 2020 Matthew Holt
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package xcaddy
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"testing"
+)
+
+// git runs git with args in dir for a test.
+func git(t *testing.T, dir string, args ...string) {
+	t.Helper()
+	cmd := exec.Command("git", append([]string{"-C", dir, "-c", "user.name=Test", "-c", "user.email=test@example.com", "-c", "commit.gpgsign=false"}, args...)...)
+	if out, err := cmd.CombinedOutput(); err != nil {
+		t.Fatalf("git %v: %v: %s", args, err, out)
+	}
+}
+
+func TestSelectCaddyReplacedByCheckout(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip(err)
+	}
+	t.Setenv("GOFLAGS", "-mod=mod")
+	t.Setenv("GOPROXY", "off")
+	dir := t.TempDir()
+	src := filepath.Join(dir, "caddy-src")
+	folder := filepath.Join(dir, "env")
+	for name, content := range map[string]string{
+		filepath.Join(src, "go.mod"):    "module github.com/caddyserver/caddy/v2\n\ngo 1.21\n",
+		filepath.Join(src, "caddy.go"):  "package caddy\n",
+		filepath.Join(folder, "go.mod"): "module caddy\n\ngo 1.21\n\nrequire github.com/caddyserver/caddy/v2 v2.0.0\n\nreplace github.com/caddyserver/caddy/v2 => ../caddy-src\n",
+	} {
+		if err := os.MkdirAll(filepath.Dir(name), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(name, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	env := environment{tempFolder: folder, caddyModulePath: defaultCaddyModulePath + "/v2"}
+	ctx := context.Background()
+
+	// not a checkout, so it is the version of go.mod
+	selected, err := env.selectCaddy(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := (selectedCaddy{version: "v2.0.0", local: true}); selected != want {
+		t.Errorf("without git: selectCaddy() = %+v, want %+v", selected, want)
+	}
+
+	// a checkout without tags, like a shallow clone
+	git(t, src, "init", "--quiet")
+	git(t, src, "add", ".")
+	git(t, src, "commit", "--quiet", "-m", "initial")
+	selected, err = env.selectCaddy(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !regexp.MustCompile(`^v2\.0\.0-\d{14}-[0-9a-f]{12}$`).MatchString(selected.version) {
+		t.Errorf("without tags: version = %s, want a pseudo-version", selected.version)
+	}
+
+	// a checkout of a tag and after it
+	git(t, src, "tag", "v2.8.4")
+	if got := env.checkoutVersion(ctx, src, ""); got != "v2.8.4" {
+		t.Errorf("at a tag: version = %s, want v2.8.4", got)
+	}
+	git(t, src, "commit", "--quiet", "--allow-empty", "-m", "fix")
+	if got := env.checkoutVersion(ctx, src, ""); !regexp.MustCompile(`^v2\.8\.4-1-g[0-9a-f]{12}$`).MatchString(got) {
+		t.Errorf("after a tag: version = %s, want it described from v2.8.4", got)
+	}
+}
+
+func TestCustomVersionLdflag(t *testing.T) {
+	caddy := defaultCaddyModulePath + "/v2"
+	if got, want := customVersionLdflag(caddy, "v2.8.4-1-g0123456789ab", []string{"build", "-ldflags", "-w -s"}, ""), "-X "+caddy+".CustomVersion=v2.8.4-1-g0123456789ab"; got != want {
+		t.Errorf("customVersionLdflag() = %q, want %q", got, want)
+	}
+	if got := customVersionLdflag(caddy, "v2.8.4", []string{"build", "-ldflags", "-X " + caddy + ".CustomVersion=mine"}, ""); got != "" {
+		t.Errorf("customVersionLdflag() = %q, want none when set on the command line", got)
+	}
+	if got := customVersionLdflag(caddy, "v2.8.4", []string{"build"}, "-ldflags=-X="+caddy+".CustomVersion=mine"); got != "" {
+		t.Errorf("customVersionLdflag() = %q, want none when set in GOFLAGS", got)
+	}
+}
+
+func Test_caddyReplacedLocally(t *testing.T) {
+	tests := []struct {
+		name  string
+		gomod string
+		want  bool
+	}{
+		{
+			name:  "not replaced",
+			gomod: "module caddy\n\nrequire github.com/caddyserver/caddy/v2 v2.8.4\n",
+		},
+		{
+			name:  "replaced by a directory",
+			gomod: "module caddy\n\nreplace github.com/caddyserver/caddy/v2 => ../caddy-src\n",
+			want:  true,
+		},
+		{
+			name:  "replaced at a version by a directory in a block",
+			gomod: "module caddy\n\nreplace (\n\tgithub.com/libdns/libdns => github.com/acme/libdns v0.2.3\n\tgithub.com/caddyserver/caddy/v2 v2.8.4 => \"/src/my caddy\" // fork\n)\n",
+			want:  true,
+		},
+		{
+			name:  "replaced by a fork",
+			gomod: "module caddy\n\nreplace github.com/caddyserver/caddy/v2 => github.com/acme/caddy/v2 v2.8.4\n",
+		},
+		{
+			name:  "other module replaced by a directory",
+			gomod: "module caddy\n\nreplace github.com/caddyserver/caddy/v2/modules => ../modules\n",
+		},
+		{
+			name:  "commented out",
+			gomod: "module caddy\n\n// replace github.com/caddyserver/caddy/v2 => ../caddy-src\n",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := t.TempDir()
+			if err := os.WriteFile(filepath.Join(dir, "go.mod"), []byte(tt.gomod), 0o644); err != nil {
+				t.Fatal(err)
+			}
+			env := environment{tempFolder: dir, caddyModulePath: defaultCaddyModulePath + "/v2"}
+			if got := env.caddyReplacedLocally(); got != tt.want {
+				t.Errorf("caddyReplacedLocally() = %t, want %t", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,168 @@
+package xcaddycmd
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"github.com/caddyserver/xcaddy"
+	"github.com/caddyserver/xcaddy/internal/utils"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	adaptManifestCommand.Flags().String("output", "xcaddy.json", "the manifest file to write, or - for stdout")
+	adaptManifestCommand.Flags().String("lock", "", "also resolve the build and write a manifest with the selected versions to this file")
+	addBuilderFlags(adaptManifestCommand.Flags())
+}
+
+var adaptManifestCommand = &cobra.Command{
+	Use: `adapt-manifest [--output <file>] [--lock <file>]
+    [<caddy_version>] [<flags of the build command>...]`,
+	Short: "Writes a manifest of a build given with the flags of the build command",
+	Long: `
+Writes a manifest, which is a JSON or YAML file with the fields of xcaddy.Builder,
+of the build given by a Caddy version and the flags of the build command, without
+building anything. Pass it the arguments of an existing xcaddy build command to replace that
+command with a manifest, which can be reviewed and versioned like any other file.
+
+Local replacements within the current folder are written relative to it, as the
+commands which read manifests resolve them against the current folder. The proxy
+settings and --replace-root are not written to manifests, so they must still be
+given on the command line.
+
+Flags:
+ --output is the manifest file to write; defaults to xcaddy.json. Use - for stdout.
+ Manifests whose name ends in .yaml or .yml, like xcaddy.yaml, are written as YAML.
+
+ --lock also resolves the versions of the modules of the build, as a build would,
+ and writes a second manifest to this file in which Caddy and the plugins are pinned
+ to the versions which were selected, so later builds from it are the same even
+ after new versions are released. Caddy and plugins replaced by other modules or
+ local directories are left as they are.
+`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		output, err := cmd.Flags().GetString("output")
+		if err != nil {
+			return fmt.Errorf("unable to parse --output arguments: %s", err.Error())
+		}
+		lockFile, err := cmd.Flags().GetString("lock")
+		if err != nil {
+			return fmt.Errorf("unable to parse --lock arguments: %s", err.Error())
+		}
+		builder, err := builderFromFlags(cmd, args)
+		if err != nil {
+			return err
+		}
+		if builder.Proxy != "" || builder.NoProxy != "" {
+			log.Println("[WARNING] --proxy and --no-proxy are not written to the manifest")
+		}
+		if len(builder.ReplaceRoots) > 0 {
+			log.Println("[WARNING] --replace-root is not written to the manifest, which it restricts")
+		}
+		cwd, err := os.Getwd()
+		if err != nil {
+			return err
+		}
+		manifest := builder
+		manifest.Replacements = relativeReplacements(builder.Replacements, cwd)
+		err = writeManifest(output, manifest)
+		if err != nil {
+			return err
+		}
+		if lockFile == "" {
+			return nil
+		}
+
+		log.Println("[INFO] Resolving versions")
+		var list bytes.Buffer
+		err = builder.RunOutput(cmd.Root().Context(), &list, "go", "list", "-m", "-f", "{{if not .Replace}}{{.Path}} {{.Version}}{{end}}", "all")
+		if err != nil {
+			return fmt.Errorf("resolving versions: %v", err)
+		}
+		return writeManifest(lockFile, lockManifest(manifest, list.Bytes()))
+	},
+}
+
+// writeManifest writes manifest to file, or to stdout if file is "-",
+// as YAML if the file is named like xcaddy.yaml, and JSON otherwise.
+func writeManifest(file string, manifest xcaddy.Builder) error {
+	data, err := json.MarshalIndent(manifest, "", "\t")
+	if err != nil {
+		return err
+	}
+	data = append(data, '\n')
+	if utils.IsYAML(file) {
+		data, err = utils.JSONToYAML(data)
+		if err != nil {
+			return err
+		}
+	}
+	if file == "-" {
+		_, err = os.Stdout.Write(data)
+		return err
+	}
+	log.Printf("[INFO] Writing manifest: %s", file)
+	return os.WriteFile(file, data, 0o644)
+}
+
+// relativeReplacements returns replacements with the local
+// directories within dir made relative to it.
+func relativeReplacements(replacements []xcaddy.Replace, dir string) []xcaddy.Replace {
+	var relative []xcaddy.Replace
+	for _, r := range replacements {
+		repl := string(r.New)
+		if filepath.IsAbs(repl) {
+			rel, err := filepath.Rel(dir, repl)
+			if err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+				repl = "." + string(filepath.Separator) + rel
+			}
+		}
+		relative = append(relative, xcaddy.NewReplace(string(r.Old), repl))
+	}
+	return relative
+}
+
+// lockManifest returns manifest with Caddy and the plugins pinned to
+// their versions in list, the output of `go list -m all` with a path
+// and version on each line, in which replaced modules are left out.
+func lockManifest(manifest xcaddy.Builder, list []byte) xcaddy.Builder {
+	versions := make(map[string]string)
+	scanner := bufio.NewScanner(bytes.NewReader(list))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) == 2 {
+			versions[fields[0]] = fields[1]
+		}
+	}
+	if v, ok := versions[caddyModulePath]; ok {
+		manifest.CaddyVersion = v
+	}
+	plugins := make([]xcaddy.Dependency, len(manifest.Plugins))
+	for i, p := range manifest.Plugins {
+		plugins[i] = p
+		if v := selectedVersion(p.PackagePath, versions); v != "" {
+			plugins[i].Version = v
+		}
+	}
+	manifest.Plugins = plugins
+	return manifest
+}
+
+// selectedVersion returns the version of the module in versions
+// which provides the package with the given path, if any.
+func selectedVersion(pkgPath string, versions map[string]string) string {
+	for p := pkgPath; p != "."; p = path.Dir(p) {
+		if v, ok := versions[p]; ok {
+			return v
+		}
+	}
+	return ""
+}
@@ -0,0 +1,87 @@
+package xcaddycmd
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/caddyserver/xcaddy"
+)
+
+func TestRelativeReplacements(t *testing.T) {
+	dir := t.TempDir()
+	got := relativeReplacements([]xcaddy.Replace{
+		xcaddy.NewReplace("github.com/caddy-dns/cloudflare", filepath.Join(dir, "cloudflare")),
+		xcaddy.NewReplace("github.com/caddy-dns/route53", filepath.Join(filepath.Dir(dir), "route53")),
+		xcaddy.NewReplace("github.com/caddyserver/caddy/v2", "github.com/acme/caddy/v2@v2.8.4"),
+	}, dir)
+	want := []xcaddy.Replace{
+		xcaddy.NewReplace("github.com/caddy-dns/cloudflare", "."+string(filepath.Separator)+"cloudflare"),
+		xcaddy.NewReplace("github.com/caddy-dns/route53", filepath.Join(filepath.Dir(dir), "route53")),
+		xcaddy.NewReplace("github.com/caddyserver/caddy/v2", "github.com/acme/caddy/v2@v2.8.4"),
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("relativeReplacements() = %v, want %v", got, want)
+	}
+}
+
+func TestLockManifest(t *testing.T) {
+	manifest := xcaddy.Builder{
+		Plugins: []xcaddy.Dependency{
+			{PackagePath: "github.com/caddy-dns/cloudflare"},
+			{PackagePath: "github.com/mholt/caddy-l4/layer4", Version: "master"},
+			{PackagePath: "github.com/example/local"},
+		},
+	}
+	list := []byte(`caddy
+github.com/caddyserver/caddy/v2 v2.8.4
+github.com/caddy-dns/cloudflare v0.1.0
+github.com/mholt/caddy-l4 v0.0.0-20240812213304-afa78d72257b
+
+github.com/libdns/libdns v0.2.2
+`)
+	got := lockManifest(manifest, list)
+	if got.CaddyVersion != "v2.8.4" {
+		t.Errorf("lockManifest() Caddy version = %q, want v2.8.4", got.CaddyVersion)
+	}
+	want := []xcaddy.Dependency{
+		{PackagePath: "github.com/caddy-dns/cloudflare", Version: "v0.1.0"},
+		{PackagePath: "github.com/mholt/caddy-l4/layer4", Version: "v0.0.0-20240812213304-afa78d72257b"},
+		{PackagePath: "github.com/example/local"},
+	}
+	if !reflect.DeepEqual(got.Plugins, want) {
+		t.Errorf("lockManifest() plugins = %v, want %v", got.Plugins, want)
+	}
+	if manifest.Plugins[1].Version != "master" {
+		t.Errorf("lockManifest() changed the plugins of the manifest: %v", manifest.Plugins)
+	}
+}
+
+func TestWriteManifestYAML(t *testing.T) {
+	manifest := xcaddy.Builder{
+		CaddyVersion: "v2.8.4",
+		Plugins:      []xcaddy.Dependency{{PackagePath: "github.com/caddy-dns/cloudflare", Version: "v0.1.0"}},
+		Defines:      map[string]string{"example.com/plugin.Enabled": "true"},
+		Replacements: []xcaddy.Replace{xcaddy.NewReplace("github.com/libdns/libdns", "./libdns")},
+	}
+	file := filepath.Join(t.TempDir(), "xcaddy.yaml")
+	if err := writeManifest(file, manifest); err != nil {
+		t.Fatalf("writeManifest() error = %v", err)
+	}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(string(data), "caddy_version: v2.8.4\n") {
+		t.Errorf("writeManifest() wrote:\n%s\nwant YAML", data)
+	}
+	got, err := xcaddy.ReadManifest(file)
+	if err != nil {
+		t.Fatalf("ReadManifest() error = %v", err)
+	}
+	if !reflect.DeepEqual(got, manifest) {
+		t.Errorf("ReadManifest() = %+v, want %+v", got, manifest)
+	}
+}
@@ -0,0 +1,127 @@
+package xcaddycmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// aliasTable maps shorthand names of popular plugins to their
+// module paths, and preset names to lists of plugins.
+type aliasTable struct {
+	Aliases map[string]string   `json:"aliases,omitempty"`
+	Presets map[string][]string `json:"presets,omitempty"`
+}
+
+// builtinAliases is the alias table that is always available;
+// it is extended (and may be overridden) by the file at the
+// path in the XCADDY_ALIASES environment variable.
+var builtinAliases = aliasTable{
+	Aliases: map[string]string{
+		"cloudflare-dns":    "github.com/caddy-dns/cloudflare",
+		"route53-dns":       "github.com/caddy-dns/route53",
+		"digitalocean-dns":  "github.com/caddy-dns/digitalocean",
+		"duckdns-dns":       "github.com/caddy-dns/duckdns",
+		"gandi-dns":         "github.com/caddy-dns/gandi",
+		"porkbun-dns":       "github.com/caddy-dns/porkbun",
+		"ntlm-transport":    "github.com/caddyserver/ntlm-transport",
+		"cache-handler":     "github.com/caddyserver/cache-handler",
+		"transform-encoder": "github.com/caddyserver/transform-encoder",
+		"replace-response":  "github.com/caddyserver/replace-response",
+		"l4":                "github.com/mholt/caddy-l4",
+		"ratelimit":         "github.com/mholt/caddy-ratelimit",
+		"dynamicdns":        "github.com/mholt/caddy-dynamicdns",
+		"webdav":            "github.com/mholt/caddy-webdav",
+		"mercure":           "github.com/dunglas/mercure/caddy",
+		"vulcain":           "github.com/dunglas/vulcain/caddy",
+		"frankenphp":        "github.com/dunglas/frankenphp/caddy",
+		"coraza":            "github.com/corazawaf/coraza-caddy/v2",
+		"security":          "github.com/greenpau/caddy-security",
+	},
+	Presets: map[string][]string{
+		"dns-all": {
+			"cloudflare-dns",
+			"route53-dns",
+			"digitalocean-dns",
+			"duckdns-dns",
+			"gandi-dns",
+			"porkbun-dns",
+		},
+		"frankenphp": {
+			"frankenphp",
+			"mercure",
+			"vulcain",
+		},
+	},
+}
+
+// loadAliases returns the built-in alias table merged with
+// the one in the file named by XCADDY_ALIASES, if set.
+func loadAliases() (aliasTable, error) {
+	table := aliasTable{
+		Aliases: make(map[string]string),
+		Presets: make(map[string][]string),
+	}
+	for name, mod := range builtinAliases.Aliases {
+		table.Aliases[name] = mod
+	}
+	for name, plugins := range builtinAliases.Presets {
+		table.Presets[name] = plugins
+	}
+
+	file := os.Getenv("XCADDY_ALIASES")
+	if file == "" {
+		return table, nil
+	}
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return table, fmt.Errorf("reading aliases: %v", err)
+	}
+	var user aliasTable
+	err = json.Unmarshal(data, &user)
+	if err != nil {
+		return table, fmt.Errorf("parsing aliases file %s: %v", file, err)
+	}
+	for name, mod := range user.Aliases {
+		table.Aliases[name] = mod
+	}
+	for name, plugins := range user.Presets {
+		table.Presets[name] = plugins
+	}
+	return table, nil
+}
+
+// isAlias returns true if name can't be a module path, since
+// the first element of a module path must contain a dot.
+func isAlias(name string) bool {
+	return name != "" && !strings.ContainsAny(name, "./\\")
+}
+
+// resolve returns the module path for the alias name.
+func (t aliasTable) resolve(name string) (string, error) {
+	mod, ok := t.Aliases[name]
+	if !ok {
+		return "", fmt.Errorf("unknown plugin alias '%s'; known aliases: %s", name, strings.Join(sortedKeys(t.Aliases), ", "))
+	}
+	return mod, nil
+}
+
+// expandPreset returns the --with arguments for the preset name.
+func (t aliasTable) expandPreset(name string) ([]string, error) {
+	plugins, ok := t.Presets[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown preset '%s'; known presets: %s", name, strings.Join(sortedKeys(t.Presets), ", "))
+	}
+	return plugins, nil
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
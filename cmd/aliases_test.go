@@ -0,0 +1,79 @@
+package xcaddycmd
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestIsAlias(t *testing.T) {
+	for i, tc := range []struct {
+		input  string
+		expect bool
+	}{
+		{input: "cloudflare-dns", expect: true},
+		{input: "github.com/caddy-dns/cloudflare", expect: false},
+		{input: "example.com", expect: false},
+		{input: "./local", expect: false},
+		{input: "", expect: false},
+	} {
+		if actual := isAlias(tc.input); actual != tc.expect {
+			t.Errorf("Test %d: Expected %t but got %t (input='%s')", i, tc.expect, actual, tc.input)
+		}
+	}
+}
+
+func TestLoadAliases(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "aliases.json")
+	err := os.WriteFile(file, []byte(`{
+	"aliases": {
+		"my-plugin": "git.example.com/me/caddy-plugin",
+		"cloudflare-dns": "github.com/my-fork/cloudflare"
+	},
+	"presets": {
+		"my-stack": ["my-plugin", "route53-dns@v1.5.0"]
+	}
+}`), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("XCADDY_ALIASES", file)
+
+	aliases, err := loadAliases()
+	if err != nil {
+		t.Fatalf("Expected no error but got: %s", err)
+	}
+	for alias, expect := range map[string]string{
+		"my-plugin":      "git.example.com/me/caddy-plugin",
+		"cloudflare-dns": "github.com/my-fork/cloudflare",
+		"route53-dns":    "github.com/caddy-dns/route53",
+	} {
+		actual, err := aliases.resolve(alias)
+		if err != nil {
+			t.Errorf("Expected no error resolving '%s' but got: %s", alias, err)
+		}
+		if actual != expect {
+			t.Errorf("Expected alias '%s' to resolve to '%s' but got '%s'", alias, expect, actual)
+		}
+	}
+	if _, err := aliases.resolve("unknown"); err == nil {
+		t.Errorf("Expected error resolving unknown alias but did not get one")
+	}
+
+	plugins, err := aliases.expandPreset("my-stack")
+	if err != nil {
+		t.Errorf("Expected no error expanding preset but got: %s", err)
+	}
+	if expect := []string{"my-plugin", "route53-dns@v1.5.0"}; !reflect.DeepEqual(plugins, expect) {
+		t.Errorf("Expected preset plugins '%v' but got '%v'", expect, plugins)
+	}
+	if _, err := aliases.expandPreset("unknown"); err == nil {
+		t.Errorf("Expected error expanding unknown preset but did not get one")
+	}
+
+	// the built-in table must not be modified by user aliases
+	if builtinAliases.Aliases["cloudflare-dns"] != "github.com/caddy-dns/cloudflare" {
+		t.Errorf("Built-in aliases were modified")
+	}
+}
@@ -0,0 +1,250 @@
+package xcaddycmd
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/caddyserver/xcaddy"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	benchCommand.Flags().String("caddy", "", "the Caddy version to benchmark with; defaults to CADDY_VERSION or latest")
+	benchCommand.Flags().String("compare", "", "also benchmarks with this older Caddy version and prints the difference")
+	benchCommand.Flags().Int("count", 1, "the number of times to run each benchmark")
+}
+
+var benchCommand = &cobra.Command{
+	Use: `bench [<pattern>]
+    [--caddy <caddy_version>]
+    [--compare <old_caddy_version>]
+    [--count <n>]`,
+	Short: "Runs the benchmarks of the plugin being developed",
+	Long: `
+Runs the benchmarks of the package in the current directory, which is the plugin
+being developed, with go test -bench in a build environment pinned to a Caddy
+version. <pattern> selects the benchmarks to run, like the -bench flag of go test;
+defaults to all of them.
+
+Flags:
+ --caddy sets the Caddy version, like the argument of the build command.
+
+ --compare also runs the benchmarks with an older Caddy version, then prints the
+ change of each measurement from the older to the newer version. This helps to
+ find out how an upgrade of Caddy affects the performance of the plugin.
+
+ --count runs each benchmark n times; the mean of the runs is compared. Use a
+ count of 5 or more for comparisons, since single runs are noisy.
+`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		pattern := "."
+		if len(args) > 0 {
+			pattern = args[0]
+		}
+		newVersion, err := cmd.Flags().GetString("caddy")
+		if err != nil {
+			return fmt.Errorf("unable to parse --caddy arguments: %s", err.Error())
+		}
+		if newVersion == "" {
+			newVersion = caddyVersion
+		}
+		oldVersion, err := cmd.Flags().GetString("compare")
+		if err != nil {
+			return fmt.Errorf("unable to parse --compare arguments: %s", err.Error())
+		}
+		count, err := cmd.Flags().GetInt("count")
+		if err != nil {
+			return fmt.Errorf("unable to parse --count arguments: %s", err.Error())
+		}
+		if count < 1 {
+			return fmt.Errorf("--count must be at least 1")
+		}
+
+		importPath, replacements, err := currentPlugin()
+		if err != nil {
+			return err
+		}
+		bench := func(version string) (benchResults, error) {
+			if version == "" {
+				log.Printf("[INFO] Benchmarking %s with the latest Caddy version", importPath)
+			} else {
+				log.Printf("[INFO] Benchmarking %s with Caddy %s", importPath, version)
+			}
+			builder := xcaddy.Builder{
+				Compile: xcaddy.Compile{
+					Cgo: os.Getenv("CGO_ENABLED") == "1",
+				},
+				CaddyVersion: version,
+				Plugins: []xcaddy.Dependency{
+					{PackagePath: importPath},
+				},
+				Replacements:     replacements,
+				SkipCleanup:      skipCleanup,
+				BuildFlags:       buildFlags,
+				ModFlags:         modFlags,
+				CaddyGitFallback: caddyGitFallback,
+				CaddyRepository:  caddyRepository,
+			}
+			// test-only dependencies of the plugin may not be
+			// in the go.sum of the environment yet
+			var out bytes.Buffer
+			err := builder.RunOutput(cmd.Root().Context(), io.MultiWriter(os.Stdout, &out), "go",
+				"test", "-mod=mod", "-run", "^$", "-bench", pattern, "-benchmem",
+				"-count", strconv.Itoa(count), importPath)
+			if err != nil {
+				return nil, err
+			}
+			return parseBenchmarks(out.Bytes()), nil
+		}
+
+		if oldVersion == "" {
+			_, err = bench(newVersion)
+			return err
+		}
+		oldResults, err := bench(oldVersion)
+		if err != nil {
+			return err
+		}
+		newResults, err := bench(newVersion)
+		if err != nil {
+			return err
+		}
+		if newVersion == "" {
+			newVersion = "latest"
+		}
+		fmt.Println()
+		return printBenchComparison(os.Stdout, oldResults, newResults, oldVersion, newVersion)
+	},
+}
+
+// benchResults maps the name of each benchmark to the
+// values it measured in each run, by their unit.
+type benchResults map[string]map[string][]float64
+
+// parseBenchmarks parses the result lines of the output of
+// go test -bench, like "BenchmarkFoo-8  1000  1234 ns/op".
+func parseBenchmarks(out []byte) benchResults {
+	results := make(benchResults)
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 4 || !strings.HasPrefix(fields[0], "Benchmark") {
+			continue
+		}
+		if _, err := strconv.Atoi(fields[1]); err != nil {
+			continue
+		}
+		for i := 2; i+1 < len(fields); i += 2 {
+			value, err := strconv.ParseFloat(fields[i], 64)
+			if err != nil {
+				break
+			}
+			if results[fields[0]] == nil {
+				results[fields[0]] = make(map[string][]float64)
+			}
+			unit := fields[i+1]
+			results[fields[0]][unit] = append(results[fields[0]][unit], value)
+		}
+	}
+	return results
+}
+
+// printBenchComparison prints a table for each unit with the mean
+// of each benchmark measured in both old and new, and its change.
+func printBenchComparison(w io.Writer, oldResults, newResults benchResults, oldLabel, newLabel string) error {
+	var names []string
+	units := make(map[string]bool)
+	for name, byUnit := range newResults {
+		if oldResults[name] == nil {
+			continue
+		}
+		names = append(names, name)
+		for unit := range byUnit {
+			if len(oldResults[name][unit]) > 0 {
+				units[unit] = true
+			}
+		}
+	}
+	if len(names) == 0 {
+		_, err := fmt.Fprintln(w, "No benchmarks to compare")
+		return err
+	}
+	sort.Strings(names)
+
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	for i, unit := range sortUnits(units) {
+		if i > 0 {
+			fmt.Fprintln(tw)
+		}
+		fmt.Fprintf(tw, "name\t%s %s\t%s %s\tdelta\n", oldLabel, unit, newLabel, unit)
+		for _, name := range names {
+			oldValues, newValues := oldResults[name][unit], newResults[name][unit]
+			if len(oldValues) == 0 || len(newValues) == 0 {
+				continue
+			}
+			oldMean, newMean := mean(oldValues), mean(newValues)
+			fmt.Fprintf(tw, "%s\t%s\t%s\t%s\n", strings.TrimPrefix(name, "Benchmark"),
+				formatBenchValue(oldMean), formatBenchValue(newMean), benchDelta(oldMean, newMean))
+		}
+	}
+	return tw.Flush()
+}
+
+// sortUnits returns the units with the time per operation
+// first, like benchstat does, then the others by name.
+func sortUnits(units map[string]bool) []string {
+	order := map[string]int{"ns/op": 1, "B/op": 2, "allocs/op": 3}
+	var sorted []string
+	for unit := range units {
+		sorted = append(sorted, unit)
+	}
+	sort.Slice(sorted, func(i, j int) bool {
+		oi, oj := order[sorted[i]], order[sorted[j]]
+		if oi == 0 {
+			oi = len(order) + 1
+		}
+		if oj == 0 {
+			oj = len(order) + 1
+		}
+		if oi != oj {
+			return oi < oj
+		}
+		return sorted[i] < sorted[j]
+	})
+	return sorted
+}
+
+func mean(values []float64) float64 {
+	var sum float64
+	for _, v := range values {
+		sum += v
+	}
+	return sum / float64(len(values))
+}
+
+// formatBenchValue formats v with at most two decimals.
+func formatBenchValue(v float64) string {
+	return strconv.FormatFloat(math.Round(v*100)/100, 'f', -1, 64)
+}
+
+// benchDelta formats the relative change from old to new,
+// or ~ if it can't be computed.
+func benchDelta(old, new float64) string {
+	if old == 0 {
+		if new == 0 {
+			return "0.00%"
+		}
+		return "~"
+	}
+	return fmt.Sprintf("%+.2f%%", (new-old)/old*100)
+}
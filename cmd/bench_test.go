@@ -0,0 +1,73 @@
+package xcaddycmd
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func TestParseBenchmarks(t *testing.T) {
+	out := []byte(`goos: linux
+goarch: amd64
+pkg: github.com/me/myplugin
+BenchmarkServeHTTP-8   	  100000	     1200 ns/op	     256 B/op	       4 allocs/op
+BenchmarkServeHTTP-8   	  100000	     1000 ns/op	     256 B/op	       4 allocs/op
+BenchmarkParse-8       	   50000	     30.5 ns/op
+PASS
+ok  	github.com/me/myplugin	3.210s
+`)
+	expected := benchResults{
+		"BenchmarkServeHTTP-8": {
+			"ns/op":     {1200, 1000},
+			"B/op":      {256, 256},
+			"allocs/op": {4, 4},
+		},
+		"BenchmarkParse-8": {
+			"ns/op": {30.5},
+		},
+	}
+	if got := parseBenchmarks(out); !reflect.DeepEqual(got, expected) {
+		t.Errorf("Expected %v, got %v", expected, got)
+	}
+}
+
+func TestPrintBenchComparison(t *testing.T) {
+	oldResults := benchResults{
+		"BenchmarkServeHTTP-8": {"ns/op": {1200, 1000}, "allocs/op": {4}},
+		"BenchmarkRemoved-8":   {"ns/op": {10}},
+	}
+	newResults := benchResults{
+		"BenchmarkServeHTTP-8": {"ns/op": {990}, "allocs/op": {4}},
+		"BenchmarkAdded-8":     {"ns/op": {10}},
+	}
+	var buf bytes.Buffer
+	err := printBenchComparison(&buf, oldResults, newResults, "v2.7.6", "v2.8.4")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expected := `name         v2.7.6 ns/op  v2.8.4 ns/op  delta
+ServeHTTP-8  1100          990           -10.00%
+
+name         v2.7.6 allocs/op  v2.8.4 allocs/op  delta
+ServeHTTP-8  4                 4                 +0.00%
+`
+	if buf.String() != expected {
+		t.Errorf("Expected:\n%s\ngot:\n%s", expected, buf.String())
+	}
+}
+
+func TestBenchDelta(t *testing.T) {
+	for _, tc := range []struct {
+		old, new float64
+		expected string
+	}{
+		{100, 150, "+50.00%"},
+		{100, 75, "-25.00%"},
+		{0, 0, "0.00%"},
+		{0, 5, "~"},
+	} {
+		if got := benchDelta(tc.old, tc.new); got != tc.expected {
+			t.Errorf("benchDelta(%v, %v): expected %s, got %s", tc.old, tc.new, tc.expected, got)
+		}
+	}
+}
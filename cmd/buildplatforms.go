@@ -0,0 +1,79 @@
+package xcaddycmd
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/caddyserver/xcaddy"
+	"github.com/spf13/cobra"
+)
+
+// defaultPlatformsOutput is the output file template of builds
+// for several platforms, unless --output gives another.
+const defaultPlatformsOutput = "caddy_{{.OS}}_{{.Arch}}{{if .ARM}}v{{.ARM}}{{end}}{{.Ext}}"
+
+// singleBuildFlags are the flags of the build command which
+// only make sense for a single binary, so they can't be combined
+// with --platforms.
+var singleBuildFlags = []string{"attestation", "changelog", "with-service", "deploy-to", "replace-running", "porcelain", "ci"}
+
+// checkPlatformsFlags returns an error if a flag which can't be
+// combined with --platforms was given, or output is stdout.
+func checkPlatformsFlags(cmd *cobra.Command, output string) error {
+	if output == "-" {
+		return fmt.Errorf("--platforms can't be combined with --output -")
+	}
+	for _, name := range singleBuildFlags {
+		if cmd.Flags().Changed(name) {
+			return fmt.Errorf("--platforms can't be combined with --%s", name)
+		}
+	}
+	return nil
+}
+
+// buildPlatforms builds Caddy with builder for each of platforms, with
+// the modules resolved once, to the files of the template output, and
+// prints a summary of the builds.
+func buildPlatforms(ctx context.Context, builder xcaddy.Builder, platforms []xcaddy.Platform, output string) error {
+	if output == "" {
+		output = defaultPlatformsOutput
+	}
+	log.Printf("[INFO] Building for %d platforms", len(platforms))
+	results, err := builder.BuildAll(ctx, platforms, output)
+	if results != nil {
+		if err := printPlatformResults(os.Stdout, results); err != nil {
+			return err
+		}
+	}
+	return err
+}
+
+// printPlatformResults prints the results of building for several
+// platforms as a table.
+func printPlatformResults(w io.Writer, results []xcaddy.BuildResult) error {
+	fmt.Fprintln(w)
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "PLATFORM\tOUTPUT\tDURATION")
+	for _, r := range results {
+		output := r.Output
+		if r.Err != nil {
+			output = "failed"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", platformName(r.Platform), output, r.Duration.Round(100*time.Millisecond))
+	}
+	return tw.Flush()
+}
+
+// platformName returns the name of p for humans, like linux/arm/v7.
+func platformName(p xcaddy.Platform) string {
+	name := p.OS + "/" + p.Arch
+	if p.ARM != "" {
+		name += "/v" + p.ARM
+	}
+	return name
+}
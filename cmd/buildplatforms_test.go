@@ -0,0 +1,50 @@
+package xcaddycmd
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/caddyserver/xcaddy"
+)
+
+func TestCheckPlatformsFlags(t *testing.T) {
+	if err := checkPlatformsFlags(buildCommand, "dist/caddy_{{.OS}}_{{.Arch}}{{.Ext}}"); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+	if err := checkPlatformsFlags(buildCommand, "-"); err == nil {
+		t.Error("expected an error for --output -")
+	}
+	if err := buildCommand.Flags().Set("porcelain", "true"); err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		_ = buildCommand.Flags().Set("porcelain", "false")
+		buildCommand.Flags().Lookup("porcelain").Changed = false
+	}()
+	err := checkPlatformsFlags(buildCommand, "")
+	if err == nil || !strings.Contains(err.Error(), "--porcelain") {
+		t.Errorf("error = %v, want one about --porcelain", err)
+	}
+}
+
+func TestPrintPlatformResults(t *testing.T) {
+	var buf bytes.Buffer
+	err := printPlatformResults(&buf, []xcaddy.BuildResult{
+		{Platform: xcaddy.Platform{OS: "linux", Arch: "amd64"}, Output: "caddy_linux_amd64", Duration: 61 * time.Second},
+		{Platform: xcaddy.Platform{OS: "linux", Arch: "arm", ARM: "7"}, Err: errors.New("boom"), Duration: time.Second},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `
+PLATFORM      OUTPUT             DURATION
+linux/amd64   caddy_linux_amd64  1m1s
+linux/arm/v7  failed             1s
+`
+	if buf.String() != want {
+		t.Errorf("printPlatformResults() =\n%s\nwant\n%s", buf.String(), want)
+	}
+}
@@ -0,0 +1,121 @@
+package xcaddycmd
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"regexp"
+	"strings"
+)
+
+// writeChangelogFile writes the changelog from the build summarized
+// by previous to the binary to file.
+func writeChangelogFile(file string, previous buildSummary, binary string) error {
+	bi, inv, err := readBinary(binary)
+	if err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	writeChangelog(&buf, diffBuilds(previous, summarizeBinary(bi, inv)), true)
+	log.Printf("[INFO] Writing changelog: %s", file)
+	return os.WriteFile(file, buf.Bytes(), 0o644)
+}
+
+// writeChangelog writes changes as a changelog in Markdown, for
+// reviewing a rebuild: Caddy and the plugins first, then the other
+// dependencies. If withLinks is true, the paths of changes are those
+// of modules, and changes of modules hosted on GitHub link to the
+// release notes and the commits between the versions.
+func writeChangelog(w io.Writer, changes []moduleChange, withLinks bool) {
+	var main, deps []moduleChange
+	for _, c := range changes {
+		if c.IsPlugin || c.Path == caddyModulePath {
+			main = append(main, c)
+		} else {
+			deps = append(deps, c)
+		}
+	}
+	fmt.Fprintln(w, "# Changes")
+	if len(changes) == 0 {
+		fmt.Fprintln(w)
+		fmt.Fprintln(w, "No modules changed.")
+		return
+	}
+	for _, section := range []struct {
+		title   string
+		changes []moduleChange
+	}{
+		{"Caddy and plugins", main},
+		{"Dependencies", deps},
+	} {
+		if len(section.changes) == 0 {
+			continue
+		}
+		fmt.Fprintf(w, "\n## %s\n\n", section.title)
+		for _, c := range section.changes {
+			fmt.Fprintf(w, "- %s\n", changelogEntry(c, withLinks))
+		}
+	}
+}
+
+// changelogEntry describes c in a line of a changelog.
+func changelogEntry(c moduleChange, withLinks bool) string {
+	var entry string
+	switch {
+	case c.IsAdded:
+		entry = fmt.Sprintf("`%s`: added at %s", c.Path, c.To)
+	case c.IsRemoved:
+		entry = fmt.Sprintf("`%s`: removed, was %s", c.Path, c.From)
+	default:
+		entry = fmt.Sprintf("`%s`: %s → %s", c.Path, c.From, c.To)
+	}
+	if !withLinks || c.IsRemoved {
+		return entry
+	}
+	repo, toRef, ok := githubRef(c.Path, c.To)
+	if !ok {
+		return entry
+	}
+	var links []string
+	if !pseudoVersionRegexp.MatchString(c.To) {
+		links = append(links, fmt.Sprintf("[release notes](%s/releases/tag/%s)", repo, toRef))
+	}
+	if _, fromRef, ok := githubRef(c.Path, c.From); ok && !c.IsAdded {
+		links = append(links, fmt.Sprintf("[commits](%s/compare/%s...%s)", repo, fromRef, toRef))
+	}
+	if len(links) == 0 {
+		return entry
+	}
+	return entry + " (" + strings.Join(links, ", ") + ")"
+}
+
+// pseudoVersionRegexp matches pseudo-versions, which end
+// in a timestamp and the prefix of a commit hash.
+var pseudoVersionRegexp = regexp.MustCompile(`[.-]\d{14}-([0-9a-f]{12})(\+incompatible)?$`)
+
+// githubRef returns the URL of the GitHub repository of the module
+// at modulePath and the git ref of version in it: the commit of a
+// pseudo-version, or else the tag, which is prefixed with the folder
+// of the module in the repository. It returns false if the module is
+// not hosted on GitHub or version is not one of a module proxy.
+func githubRef(modulePath, version string) (repo, ref string, ok bool) {
+	elems := strings.Split(modulePath, "/")
+	if len(elems) < 3 || elems[0] != "github.com" || !strings.HasPrefix(version, "v") || strings.Contains(version, " ") {
+		return "", "", false
+	}
+	repo = "https://" + strings.Join(elems[:3], "/")
+	if m := pseudoVersionRegexp.FindStringSubmatch(version); m != nil {
+		return repo, m[1], true
+	}
+	folder := elems[3:]
+	if len(folder) > 0 && majorVersionRegexp.MatchString(folder[len(folder)-1]) {
+		folder = folder[:len(folder)-1]
+	}
+	ref = strings.TrimSuffix(version, "+incompatible")
+	if len(folder) > 0 {
+		ref = strings.Join(folder, "/") + "/" + ref
+	}
+	return repo, ref, true
+}
@@ -0,0 +1,76 @@
+package xcaddycmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// ciReporter formats the output of a build for CI systems, using
+// GitHub Actions workflow commands, if enabled. Otherwise, its
+// methods behave like the rest of xcaddy does when run by a user.
+type ciReporter struct {
+	enabled bool
+	out     io.Writer
+}
+
+// group starts a collapsible group of log lines named title,
+// and returns a function which ends it.
+func (c ciReporter) group(title string) func() {
+	if !c.enabled {
+		return func() {}
+	}
+	fmt.Fprintf(c.out, "::group::%s\n", escapeWorkflowCommand(title))
+	return func() {
+		fmt.Fprintln(c.out, "::endgroup::")
+	}
+}
+
+// fail reports err as an error annotation, and returns it.
+func (c ciReporter) fail(err error) error {
+	if c.enabled {
+		fmt.Fprintf(c.out, "::error title=xcaddy build failed::%s\n", escapeWorkflowCommand(err.Error()))
+	}
+	return err
+}
+
+// escapeWorkflowCommand escapes s so it can be the data
+// of a workflow command, which must be on a single line.
+func escapeWorkflowCommand(s string) string {
+	return strings.NewReplacer("%", "%25", "\r", "%0D", "\n", "%0A").Replace(s)
+}
+
+// writeOutputs appends the path, Caddy version and SHA-256 of
+// the built binary to the file named by GITHUB_OUTPUT, if set,
+// so later steps of the workflow can use them.
+func (c ciReporter) writeOutputs(result buildResult) error {
+	outputFile := os.Getenv("GITHUB_OUTPUT")
+	if !c.enabled || outputFile == "" {
+		return nil
+	}
+	f, err := os.OpenFile(outputFile, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return fmt.Errorf("opening GITHUB_OUTPUT: %v", err)
+	}
+	defer f.Close()
+	return writeGitHubOutputs(f, [][2]string{
+		{"binary", result.Output},
+		{"version", result.CaddyVersion},
+		{"sha256", result.SHA256},
+	})
+}
+
+// writeGitHubOutputs writes name=value lines in the
+// format of the GITHUB_OUTPUT file.
+func writeGitHubOutputs(w io.Writer, outputs [][2]string) error {
+	for _, o := range outputs {
+		if strings.ContainsAny(o[1], "\r\n") {
+			return fmt.Errorf("output %s must be a single line: %q", o[0], o[1])
+		}
+		if _, err := fmt.Fprintf(w, "%s=%s\n", o[0], o[1]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,52 @@
+package xcaddycmd
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+func TestCIReporterGroup(t *testing.T) {
+	var buf bytes.Buffer
+	ci := ciReporter{enabled: true, out: &buf}
+	end := ci.group("Build Caddy")
+	buf.WriteString("building\n")
+	end()
+	if expected := "::group::Build Caddy\nbuilding\n::endgroup::\n"; buf.String() != expected {
+		t.Errorf("Expected %q, got %q", expected, buf.String())
+	}
+
+	buf.Reset()
+	ci.enabled = false
+	ci.group("Build Caddy")()
+	if buf.Len() != 0 {
+		t.Errorf("Expected no output when disabled, got %q", buf.String())
+	}
+}
+
+func TestEscapeWorkflowCommand(t *testing.T) {
+	err := errors.New("go build: exit status 1\n./main.go:3: 100% broken")
+	expected := "go build: exit status 1%0A./main.go:3: 100%25 broken"
+	if got := escapeWorkflowCommand(err.Error()); got != expected {
+		t.Errorf("Expected %q, got %q", expected, got)
+	}
+}
+
+func TestWriteGitHubOutputs(t *testing.T) {
+	var buf bytes.Buffer
+	err := writeGitHubOutputs(&buf, [][2]string{
+		{"binary", "/home/runner/work/caddy"},
+		{"version", "v2.8.4"},
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if expected := "binary=/home/runner/work/caddy\nversion=v2.8.4\n"; buf.String() != expected {
+		t.Errorf("Expected %q, got %q", expected, buf.String())
+	}
+
+	err = writeGitHubOutputs(&buf, [][2]string{{"binary", "a\nb"}})
+	if err == nil {
+		t.Errorf("Expected an error for a multi-line value")
+	}
+}
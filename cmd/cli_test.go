@@ -0,0 +1,390 @@
+package xcaddycmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+
+	"github.com/caddyserver/xcaddy"
+	"github.com/caddyserver/xcaddy/internal/fakego"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+func TestMain(m *testing.M) {
+	fakego.Main()
+	os.Exit(m.Run())
+}
+
+// runCLI runs xcaddy with args, as if from the command line.
+func runCLI(t *testing.T, args ...string) error {
+	t.Helper()
+	rootCmd.SetArgs(args)
+	defer rootCmd.SetArgs(nil)
+	defer resetFlags(rootCmd)
+	return rootCmd.ExecuteContext(context.Background())
+}
+
+// resetFlags sets the flags of cmd and its subcommands back to
+// their defaults, since the commands are the same in every run.
+func resetFlags(cmd *cobra.Command) {
+	reset := func(f *pflag.Flag) {
+		if !f.Changed {
+			return
+		}
+		if v, ok := f.Value.(pflag.SliceValue); ok {
+			_ = v.Replace(nil)
+		} else {
+			_ = f.Value.Set(f.DefValue)
+		}
+		f.Changed = false
+	}
+	cmd.Flags().VisitAll(reset)
+	cmd.PersistentFlags().VisitAll(reset)
+	for _, sub := range cmd.Commands() {
+		resetFlags(sub)
+	}
+}
+
+// chdir changes the current folder to dir for the test.
+func chdir(t *testing.T, dir string) {
+	t.Helper()
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chdir(dir); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() {
+		if err := os.Chdir(wd); err != nil {
+			t.Error(err)
+		}
+	})
+}
+
+// captureStdout redirects the standard output to a file for the
+// test, and returns a function which returns what was written.
+func captureStdout(t *testing.T) func() string {
+	t.Helper()
+	f, err := os.CreateTemp(t.TempDir(), "stdout")
+	if err != nil {
+		t.Fatal(err)
+	}
+	stdout := os.Stdout
+	os.Stdout = f
+	t.Cleanup(func() {
+		os.Stdout = stdout
+		f.Close()
+	})
+	return func() string {
+		t.Helper()
+		data, err := os.ReadFile(f.Name())
+		if err != nil {
+			t.Fatal(err)
+		}
+		return string(data)
+	}
+}
+
+// buildInvocations returns the invocations of go build by g.
+func buildInvocations(t *testing.T, g *fakego.Go) []fakego.Invocation {
+	t.Helper()
+	builds := g.Find("build")
+	if len(builds) == 0 {
+		t.Fatalf("go build was not run; invocations: %v", g.Invocations())
+	}
+	return builds
+}
+
+func TestCLIBuild(t *testing.T) {
+	g := fakego.New(t)
+	output := filepath.Join(t.TempDir(), "caddy")
+	err := runCLI(t, "build", "v2.8.4",
+		"--with", "github.com/caddy-dns/cloudflare@v0.1.0",
+		"--replace", "github.com/example/dep=github.com/example/fork@v1.2.3",
+		"--output", output)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := os.Stat(output); err != nil {
+		t.Errorf("output file: %v", err)
+	}
+	if len(g.Find("mod", "init")) != 1 {
+		t.Errorf("go mod init was not run once; invocations: %v", g.Invocations())
+	}
+	if len(g.Find("get", "*", "github.com/caddy-dns/cloudflare@v0.1.0")) == 0 &&
+		len(g.Find("get", "*", "*", "github.com/caddy-dns/cloudflare@v0.1.0")) == 0 {
+		t.Errorf("the plugin was not added with go get; invocations: %v", g.Find("get"))
+	}
+	if len(g.Find("mod", "edit", "-replace", "github.com/example/dep=github.com/example/fork@v1.2.3")) == 0 {
+		t.Errorf("the replacement was not added with go mod edit; invocations: %v", g.Find("mod", "edit"))
+	}
+
+	build := buildInvocations(t, g)[0]
+	if !slices.Contains(build.Args, "-trimpath") {
+		t.Errorf("go build %v, want -trimpath", build.Args)
+	}
+	if !slices.Contains(build.Files, "main.go") {
+		t.Errorf("files of the build %v, want main.go", build.Files)
+	}
+	// the built binary was run to report its version
+	var ranCaddy bool
+	for _, inv := range g.Invocations() {
+		if inv.Name == "caddy" && slices.Equal(inv.Args, []string{"version"}) {
+			ranCaddy = true
+		}
+	}
+	if !ranCaddy {
+		t.Errorf("the built binary was not run; invocations: %v", g.Invocations())
+	}
+}
+
+func TestCLIBuildEmbed(t *testing.T) {
+	g := fakego.New(t)
+	dir := t.TempDir()
+	site := filepath.Join(dir, "site")
+	if err := os.MkdirAll(filepath.Join(site, "css"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	for _, file := range []string{"index.html", "css/style.css"} {
+		if err := os.WriteFile(filepath.Join(site, file), []byte(file), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	err := runCLI(t, "build", "--embed", "www:"+site, "--output", filepath.Join(dir, "caddy"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	build := buildInvocations(t, g)[0]
+	for _, want := range []string{"main.go", "files/www/index.html", "files/www/css/style.css"} {
+		if !slices.Contains(build.Files, want) {
+			t.Errorf("files of the build %v, want %s", build.Files, want)
+		}
+	}
+}
+
+func TestCLIBuildPlatforms(t *testing.T) {
+	g := fakego.New(t)
+	dir := t.TempDir()
+	err := runCLI(t, "build", "--platforms", "linux/amd64,linux/arm64",
+		"--output", filepath.Join(dir, "caddy_{{.OS}}_{{.Arch}}"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the modules are resolved once, and built for each platform
+	if n := len(g.Find("mod", "init")); n != 1 {
+		t.Errorf("go mod init was run %d times, want 1", n)
+	}
+	var platforms []string
+	for _, build := range buildInvocations(t, g) {
+		platforms = append(platforms, build.Env["GOOS"]+"/"+build.Env["GOARCH"])
+	}
+	slices.Sort(platforms)
+	if want := []string{"linux/amd64", "linux/arm64"}; !slices.Equal(platforms, want) {
+		t.Errorf("built for %v, want %v", platforms, want)
+	}
+	for _, name := range []string{"caddy_linux_amd64", "caddy_linux_arm64"} {
+		if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+			t.Errorf("output file: %v", err)
+		}
+	}
+}
+
+func TestCLIBuildDuplicatePlugins(t *testing.T) {
+	g := fakego.New(t)
+	dir := t.TempDir()
+	err := runCLI(t, "build",
+		"--with", "github.com/caddy-dns/cloudflare@v0.1.0",
+		"--with", "github.com/caddy-dns/cloudflare",
+		"--with", "github.com/caddy-dns/cloudflare@v0.1.0",
+		"--output", filepath.Join(dir, "caddy"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var gets int
+	for _, inv := range g.Find("get") {
+		for _, arg := range inv.Args {
+			if strings.HasPrefix(arg, "github.com/caddy-dns/cloudflare") {
+				gets++
+			}
+		}
+	}
+	if gets != 1 {
+		t.Errorf("the plugin was fetched %d times, want 1; invocations: %v", gets, g.Find("get"))
+	}
+
+	g = fakego.New(t)
+	err = runCLI(t, "build",
+		"--with", "github.com/caddy-dns/cloudflare@v0.1.0",
+		"--with", "github.com/caddy-dns/cloudflare@v0.2.0",
+		"--output", filepath.Join(dir, "caddy"))
+	if err == nil || !strings.Contains(err.Error(), "two versions, v0.1.0 and v0.2.0") {
+		t.Errorf("build error = %v, want the conflicting versions", err)
+	}
+	if invs := g.Invocations(); len(invs) > 0 {
+		t.Errorf("go was run despite the conflict: %v", invs)
+	}
+}
+
+func TestCLIBuildPlan(t *testing.T) {
+	g := fakego.New(t)
+	plugin := t.TempDir()
+	if err := os.WriteFile(filepath.Join(plugin, "go.mod"), []byte("module example.com/plugin\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	err := runCLI(t, "build", "v2.8.4", "--with", "example.com/plugin@v1.2.3="+plugin, "--plan")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if invs := g.Invocations(); len(invs) > 0 {
+		t.Errorf("go was run for --plan: %v", invs)
+	}
+
+	err = runCLI(t, "build", "v2.8.4",
+		"--with", "example.com/plugin/v2",
+		"--replace", "example.com/plugin="+plugin,
+		"--output", filepath.Join(t.TempDir(), "caddy"))
+	if err == nil || !strings.Contains(err.Error(), "wouldn't apply; replace example.com/plugin/v2 instead") {
+		t.Errorf("build error = %v, want the replacement of the other major version", err)
+	}
+	if invs := g.Invocations(); len(invs) > 0 {
+		t.Errorf("go was run despite the contradiction: %v", invs)
+	}
+}
+
+func TestCLIPlan(t *testing.T) {
+	g := fakego.New(t)
+	g.Handle(fakego.Rule{
+		Args: []string{"list", "-m", "-json", "all"},
+		Stdout: `{"Path": "caddy", "Main": true}
+{"Path": "github.com/caddy-dns/cloudflare", "Version": "v0.1.0"}
+{"Path": "github.com/caddyserver/caddy/v2", "Version": "v2.8.4"}
+`,
+	})
+	stdout := captureStdout(t)
+	err := runCLI(t, "plan", "v2.8.4", "--with", "github.com/caddy-dns/cloudflare",
+		"--platforms", "linux/amd64,linux/arm64", "--json")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var plan buildPlan
+	if err := json.Unmarshal([]byte(stdout()), &plan); err != nil {
+		t.Fatalf("plan output: %v\n%s", err, stdout())
+	}
+	if plan.CaddyVersion != fakego.CaddyVersion {
+		t.Errorf("Caddy version = %s, want %s", plan.CaddyVersion, fakego.CaddyVersion)
+	}
+	wantPlugins := []xcaddy.ResolvedPlugin{{PackagePath: "github.com/caddy-dns/cloudflare", Module: "github.com/caddy-dns/cloudflare", Version: "v0.1.0"}}
+	if !reflect.DeepEqual(plan.Plugins, wantPlugins) {
+		t.Errorf("plugins = %+v, want %+v", plan.Plugins, wantPlugins)
+	}
+	wantPlatforms := []xcaddy.Platform{{OS: "linux", Arch: "amd64"}, {OS: "linux", Arch: "arm64"}}
+	if !reflect.DeepEqual(plan.Platforms, wantPlatforms) {
+		t.Errorf("platforms = %+v, want %+v", plan.Platforms, wantPlatforms)
+	}
+	// the plan is resolved, but nothing is compiled
+	if len(g.Find("get")) == 0 {
+		t.Errorf("the modules were not resolved; invocations: %v", g.Invocations())
+	}
+	if builds := g.Find("build"); len(builds) > 0 {
+		t.Errorf("go build was run: %v", builds)
+	}
+}
+
+func TestCLIBuildFailure(t *testing.T) {
+	g := fakego.New(t)
+	g.Handle(fakego.Rule{
+		Args:   []string{"build"},
+		Stderr: "./main.go:1:1: syntax error\n",
+		Exit:   1,
+	})
+	err := runCLI(t, "build", "--output", filepath.Join(t.TempDir(), "caddy"))
+	if got := exitCode(err); got != 4 {
+		t.Errorf("build error = %v, exit code %d, want 4", err, got)
+	}
+}
+
+func TestCLIReplacePatternOutsideRoot(t *testing.T) {
+	g := fakego.New(t)
+	g.Handle(fakego.Rule{
+		Args:   []string{"list", "-m", "-f", "{{.Path}}", "all"},
+		Stdout: "caddy\ngithub.com/caddyserver/caddy/v2\nexample.com/plugin\n",
+	})
+	dir := t.TempDir()
+	root := filepath.Join(dir, "root")
+	outside := filepath.Join(dir, "outside", "plugin")
+	for _, d := range []string{filepath.Join(root, "plugin"), outside} {
+		if err := os.MkdirAll(d, 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := os.WriteFile(filepath.Join(outside, "go.mod"), []byte("module example.com/plugin\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	// the pattern is within the root up to {name},
+	// but what follows leads out of it
+	manifest := filepath.Join(dir, "xcaddy.json")
+	data := fmt.Sprintf(`{"caddy_version": "v2.8.4", "replacements": [{"old": "example.com/*", "new": %q}]}`,
+		root+"/{name}/../../outside/{name}")
+	if err := os.WriteFile(manifest, []byte(data), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	err := runCLI(t, "prefetch", "--manifest", manifest, "--replace-root", root)
+	if got := exitCode(err); got != 6 {
+		t.Errorf("prefetch error = %v, exit code %d, want 6", err, got)
+	}
+	if err == nil || !strings.Contains(err.Error(), "replacements must be within") {
+		t.Errorf("prefetch error = %v, want the replacement to be outside of the root", err)
+	}
+}
+
+func TestCLIDevPassThrough(t *testing.T) {
+	g := fakego.New(t)
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "go.mod"), []byte("module example.com/plugin\n\ngo 1.21\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	chdir(t, dir)
+	g.Handle(fakego.Rule{
+		Args:   []string{"list", "-mod=readonly", "-m", "-json", "all"},
+		Stdout: fmt.Sprintf(`{"Path": "example.com/plugin", "Main": true, "Dir": %q}`, dir),
+	})
+
+	err := runCLI(t, "list-modules")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the plugin being developed is replaced by its folder
+	if len(g.Find("mod", "edit", "-replace", "example.com/plugin="+dir)) == 0 {
+		t.Errorf("the plugin was not replaced by its folder; invocations: %v", g.Find("mod", "edit"))
+	}
+	var passed []fakego.Invocation
+	for _, inv := range g.Invocations() {
+		if inv.Name == "caddy" && slices.Equal(inv.Args, []string{"list-modules"}) {
+			passed = append(passed, inv)
+		}
+	}
+	if len(passed) != 1 {
+		t.Fatalf("the command was passed through to Caddy %d times, want 1; invocations: %v", len(passed), g.Invocations())
+	}
+	if passed[0].Dir != dir {
+		t.Errorf("Caddy ran in %s, want %s", passed[0].Dir, dir)
+	}
+	// the binary is removed after it ran
+	if _, err := os.Stat(filepath.Join(dir, "caddy")); !os.IsNotExist(err) {
+		t.Errorf("dev binary was left behind: %v", err)
+	}
+}
@@ -2,9 +2,10 @@ package xcaddycmd
 
 import (
 	"fmt"
-	"log"
 	"os"
 	"os/exec"
+	"strings"
+	"time"
 
 	"github.com/caddyserver/xcaddy"
 	"github.com/caddyserver/xcaddy/internal/utils"
@@ -17,93 +18,113 @@ var rootCmd = &cobra.Command{
 		"The xcaddy command has two primary uses:\n" +
 		"- Compile custom caddy binaries\n" +
 		"- A replacement for `go run` while developing Caddy plugins\n" +
-		"xcaddy accepts any Caddy command (except help and version) to pass through to the custom-built Caddy, notably `run` and `list-modules`.  The command pass-through allows for iterative development process.\n\n" +
+		"xcaddy accepts any Caddy command (except help and version) to pass through to the custom-built Caddy, notably `list-modules`.  The command pass-through allows for iterative development process.\n" +
+		"`xcaddy run` builds and runs Caddy the same way, with flags of its own, like --watch; see `xcaddy run --help`.\n\n" +
 		"Report bugs on https://github.com/caddyserver/xcaddy\n",
 	Short:        "Caddy module development helper",
 	SilenceUsage: true,
 	Version:      xcaddyVersion(),
 	Args:         cobra.ArbitraryArgs,
-	RunE: func(cmd *cobra.Command, args []string) error {
-		binOutput := getCaddyOutputFile()
-
-		// get current/main module name and the root directory of the main module
-		//
-		// make sure the module being developed is replaced
-		// so that the local copy is used
-		//
-		// replace directives only apply to the top-level/main go.mod,
-		// and since this tool is a carry-through for the user's actual
-		// go.mod, we need to transfer their replace directives through
-		// to the one we're making
-		execCmd := exec.Command(utils.GetGo(), "list", "-mod=readonly", "-m", "-json", "all")
-		execCmd.Stderr = os.Stderr
-		out, err := execCmd.Output()
-		if err != nil {
-			return fmt.Errorf("exec %v: %v: %s", cmd.Args, err, string(out))
-		}
-		currentModule, moduleDir, replacements, err := parseGoListJson(out)
-		if err != nil {
-			return fmt.Errorf("json parse error: %v", err)
-		}
-
-		// reconcile remaining path segments; for example if a module foo/a
-		// is rooted at directory path /home/foo/a, but the current directory
-		// is /home/foo/a/b, then the package to import should be foo/a/b
-		cwd, err := os.Getwd()
+	PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+		noCache, err := cmd.Flags().GetBool("no-cache")
 		if err != nil {
-			return fmt.Errorf("unable to determine current directory: %v", err)
+			return fmt.Errorf("unable to parse --no-cache arguments: %s", err.Error())
 		}
-		importPath := normalizeImportPath(currentModule, cwd, moduleDir)
-
-		// build caddy with this module plugged in
-		builder := xcaddy.Builder{
-			Compile: xcaddy.Compile{
-				Cgo: os.Getenv("CGO_ENABLED") == "1",
-			},
-			CaddyVersion: caddyVersion,
-			Plugins: []xcaddy.Dependency{
-				{PackagePath: importPath},
-			},
-			Replacements: replacements,
-			RaceDetector: raceDetector,
-			SkipBuild:    skipBuild,
-			SkipCleanup:  skipCleanup,
-			Debug:        buildDebugOutput,
+		if noCache {
+			// commands use either their own context or that of the root
+			cmd.SetContext(xcaddy.WithoutCache(cmd.Context()))
+			cmd.Root().SetContext(xcaddy.WithoutCache(cmd.Root().Context()))
 		}
-		err = builder.Build(cmd.Context(), binOutput)
-		if err != nil {
+		return nil
+	},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := checkPassThrough(cmd, args); err != nil {
 			return err
 		}
+		return runDev(cmd.Context(), args, devOptions{Race: raceDetector})
+	},
+}
 
-		// if requested, run setcap to allow binding to low ports
-		err = setcapIfRequested(binOutput)
-		if err != nil {
-			return err
-		}
+// caddyCommands are the commands of Caddy, which are passed through
+// to the custom-built Caddy without a subcommand of xcaddy. Plugins
+// may register more.
+var caddyCommands = map[string]bool{
+	"adapt":          true,
+	"add-package":    true,
+	"build-info":     true,
+	"completion":     true,
+	"environ":        true,
+	"file-server":    true,
+	"fmt":            true,
+	"hash-password":  true,
+	"list-modules":   true,
+	"manpage":        true,
+	"reload":         true,
+	"remove-package": true,
+	"respond":        true,
+	"reverse-proxy":  true,
+	"start":          true,
+	"stop":           true,
+	"storage":        true,
+	"trust":          true,
+	"untrust":        true,
+	"upgrade":        true,
+	"validate":       true,
+}
 
-		log.Printf("[INFO] Running %v\n\n", append([]string{binOutput}, args...))
+// checkPassThrough returns an error if the command in args, which is
+// to be passed through to Caddy, is not one of Caddy's but looks like
+// a misspelled command of xcaddy, rather than building Caddy for it.
+func checkPassThrough(cmd *cobra.Command, args []string) error {
+	if len(args) == 0 || caddyCommands[args[0]] {
+		return nil
+	}
+	if suggestions := cmd.SuggestionsFor(args[0]); len(suggestions) > 0 {
+		return fmt.Errorf("unknown command %q for %q\n\nDid you mean this?\n\t%s", args[0], cmd.CommandPath(), strings.Join(suggestions, "\n\t"))
+	}
+	return nil
+}
 
-		execCmd = exec.Command(binOutput, args...)
-		execCmd.Stdin = os.Stdin
-		execCmd.Stdout = os.Stdout
-		execCmd.Stderr = os.Stderr
-		err = execCmd.Start()
-		if err != nil {
-			return err
-		}
-		defer func() {
-			if skipCleanup {
-				log.Printf("[INFO] Skipping cleanup as requested; leaving artifact: %s", binOutput)
-				return
-			}
-			err = os.Remove(binOutput)
-			if err != nil && !os.IsNotExist(err) {
-				log.Printf("[ERROR] Deleting temporary binary %s: %v", binOutput, err)
-			}
-		}()
+// caddyShutdownDelay is how long Caddy run in development
+// mode has to shut down after xcaddy is interrupted.
+const caddyShutdownDelay = 10 * time.Second
 
-		return execCmd.Wait()
-	},
+// currentPlugin returns the import path of the package in the current
+// directory, which is the plugin being developed, along with the
+// replacements needed to build it.
+func currentPlugin() (importPath string, replacements []xcaddy.Replace, err error) {
+	// get current/main module name and the root directory of the main module
+	//
+	// make sure the module being developed is replaced
+	// so that the local copy is used
+	//
+	// replace directives only apply to the top-level/main go.mod,
+	// and since this tool is a carry-through for the user's actual
+	// go.mod, we need to transfer their replace directives through
+	// to the one we're making
+	execCmd := exec.Command(utils.GetGo(), "list", "-mod=readonly", "-m", "-json", "all")
+	execCmd.Stderr = os.Stderr
+	out, err := execCmd.Output()
+	if err != nil {
+		return "", nil, fmt.Errorf("exec %v: %v: %s", execCmd.Args, err, string(out))
+	}
+	currentModule, moduleDir, replacements, err := parseGoListJson(out)
+	if err != nil {
+		return "", nil, fmt.Errorf("json parse error: %v", err)
+	}
+	replacements, err = checkNestedModule(currentModule, moduleDir, out, replacements)
+	if err != nil {
+		return "", nil, err
+	}
+
+	// reconcile remaining path segments; for example if a module foo/a
+	// is rooted at directory path /home/foo/a, but the current directory
+	// is /home/foo/a/b, then the package to import should be foo/a/b
+	cwd, err := os.Getwd()
+	if err != nil {
+		return "", nil, fmt.Errorf("unable to determine current directory: %v", err)
+	}
+	return normalizeImportPath(currentModule, cwd, moduleDir), replacements, nil
 }
 
 const fullDocsFooter = `Full documentation is available at:
@@ -111,7 +132,28 @@ https://github.com/caddyserver/xcaddy`
 
 func init() {
 	rootCmd.SetVersionTemplate("{{.Version}}\n")
+	rootCmd.SuggestionsMinimumDistance = 2
 	rootCmd.SetHelpTemplate(rootCmd.HelpTemplate() + "\n" + fullDocsFooter + "\n")
+	rootCmd.PersistentFlags().Bool("no-cache", false, "looks up versions of Caddy and modules, and platforms, again instead of using the cache in the cache folder of the user, and rebuilds Caddy in development mode even if nothing changed")
 	rootCmd.AddCommand(buildCommand)
 	rootCmd.AddCommand(versionCommand)
+	rootCmd.AddCommand(inspectCommand)
+	rootCmd.AddCommand(diffCommand)
+	rootCmd.AddCommand(importCommand)
+	rootCmd.AddCommand(adaptManifestCommand)
+	rootCmd.AddCommand(manifestCommand)
+	rootCmd.AddCommand(envCommand)
+	rootCmd.AddCommand(execCommand)
+	rootCmd.AddCommand(benchCommand)
+	rootCmd.AddCommand(vetCommand)
+	rootCmd.AddCommand(initCommand)
+	rootCmd.AddCommand(generateCommand)
+	rootCmd.AddCommand(platformsCommand)
+	rootCmd.AddCommand(prefetchCommand)
+	rootCmd.AddCommand(planCommand)
+	rootCmd.AddCommand(verifyOfflineCommand)
+	rootCmd.AddCommand(rolloutCommand)
+	rootCmd.AddCommand(historyCommand)
+	rootCmd.AddCommand(reproduceCommand)
+	rootCmd.AddCommand(runCommand)
 }
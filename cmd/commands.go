@@ -2,23 +2,96 @@ package xcaddycmd
 
 import (
 	"fmt"
+	"io"
 	"log"
+	"net/url"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"regexp"
 	"runtime"
+	"strconv"
 	"strings"
+	"time"
 
 	"github.com/caddyserver/xcaddy"
 	"github.com/caddyserver/xcaddy/internal/utils"
 	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
 )
 
 func init() {
-	buildCommand.Flags().StringArray("with", []string{}, "caddy modules package path to include in the build")
+	addBuilderFlags(buildCommand.Flags())
 	buildCommand.Flags().String("output", "", "change the output file name")
-	buildCommand.Flags().StringArray("replace", []string{}, "like --with but for Go modules")
-	buildCommand.Flags().StringArray("embed", []string{}, "embeds directories into the built Caddy executable to use with the `embedded` file-system")
+	buildCommand.Flags().StringArray("platforms", []string{}, "builds for several platforms, as os/arch or a bundle like common or all-first-class")
+	buildCommand.Flags().Bool("plan", false, "prints where Caddy and each plugin are built from, after the replacements, without building")
+	buildCommand.Flags().Bool("embed-gomod", false, "embeds a compressed copy of the final go.mod and go.sum into the built Caddy executable")
+	buildCommand.Flags().String("attestation", "", "writes an in-toto statement of the SLSA provenance of the built Caddy executable to a file")
+	buildCommand.Flags().String("sign", "", "signs the attestation with the private key in this PEM file")
+	buildCommand.Flags().String("changelog", "", "writes the modules which changed from the binary which is replaced to a file, in Markdown")
+	buildCommand.Flags().Bool("strict", false, "fails the build if go mod tidy reports errors, instead of ignoring them")
+	buildCommand.Flags().Bool("cover", false, "builds the Caddy executable with coverage instrumentation of the plugins")
+	buildCommand.Flags().String("graph", "", "writes the dependency graph of the build to a file, in DOT format or as JSON if the name ends in .json")
+	buildCommand.Flags().StringArray("why", []string{}, "explains why a module is part of the build, like go mod why -m")
+	buildCommand.Flags().Bool("prune-report", false, "prints the heaviest dependencies of the build with the plugins which introduced them")
+	buildCommand.Flags().Bool("with-service", false, "writes a systemd unit, a default Caddyfile and an install script next to the built Caddy executable")
+	buildCommand.Flags().String("deploy-to", "", "after the build, replaces the binary on a host over ssh and restarts Caddy there, as [user@]host[:path]")
+	buildCommand.Flags().String("deploy-restart", defaultDeployRestart, "the command which restarts Caddy on the host of --deploy-to")
+	buildCommand.Flags().String("deploy-admin", "", "the URL of the admin API of Caddy on the host of --deploy-to, which must respond after the restart")
+	buildCommand.Flags().Bool("replace-running", false, "builds next to the output file and moves the binary over it only once it is verified, even if it is running")
+	buildCommand.Flags().String("signal", "", "with --replace-running, the signal sent to the process in --pidfile after the binary was replaced, like TERM")
+	buildCommand.Flags().String("pidfile", "", "with --replace-running, the file with the process ID of the running Caddy, as written by caddy run --pidfile")
+	buildCommand.Flags().Bool("porcelain", false, "prints the build summary as name=value lines for scripts, with all other output on stderr")
+	buildCommand.Flags().Bool("ci", false, "formats output for GitHub Actions and writes the binary path, version and sha256 to GITHUB_OUTPUT")
+}
+
+// addBuilderFlags adds the flags which configure what goes into a
+// build, as parsed by builderFromFlags.
+func addBuilderFlags(flags *pflag.FlagSet) {
+	flags.StringArray("with", []string{}, "caddy modules package path to include in the build")
+	flags.String("caddy", "", "the Caddy version, channel or version constraint to build; same as <caddy_version>")
+	flags.StringArray("replace", []string{}, "like --with but for Go modules")
+	flags.StringArray("replace-root", []string{}, "only allows local replacements within this folder, like the workspace of a CI job")
+	flags.String("replace-from", "", "replaces the plugins given with a version by their forks in this namespace, like github.com/acme/forks")
+	flags.StringArray("preset", []string{}, "adds a named set of plugins to the build")
+	flags.Bool("bare", false, "leaves out the standard modules of Caddy, so only the plugins are included")
+	flags.StringArray("without", []string{}, "leaves out a standard module of Caddy, like caddyhttp/templates")
+	flags.String("main-preset", "", "the preset of the generated main package: plain, the default, or run, which applies the --default-* flags")
+	flags.String("default-config", "", "the config file Caddy uses unless given with --config; requires --main-preset run")
+	flags.String("default-adapter", "", "the config adapter Caddy uses unless given with --adapter; requires --main-preset run")
+	flags.StringArray("default-env", []string{}, "an environment variable set when Caddy starts unless already set, as name=value; requires --main-preset run")
+	flags.String("buildmode", "", "experimental: plugin builds the plugins into a Go plugin, a .so file next to the Caddy executable, which loads it")
+	flags.StringArray("embed", []string{}, "embeds directories into the built Caddy executable to use with the `embedded` file-system")
+	flags.String("embed-max-size", "", "the maximum total size of the embedded directories, like 2GiB, or unlimited; defaults to 1GiB")
+	flags.StringArray("patch", []string{}, "applies a patch file to the source of a Go module before building")
+	flags.StringArray("exclude", []string{}, "excludes a version of a Go module from the build")
+	flags.String("from-gomod", "", "imports replace and exclude directives from an existing go.mod file")
+	flags.Bool("from-gomod-requires", false, "also imports require directives from the file given with --from-gomod")
+	flags.Bool("ignore-goflags", false, "ignores GOFLAGS from the environment for the go commands of the build")
+	flags.String("go-version", "", "the exact Go toolchain to build with, like 1.22.5, regardless of the installed go")
+	flags.StringArray("define", []string{}, "defines build-time configuration for plugins, as key=value")
+	flags.Bool("sandbox", false, "runs the go commands of the build with a throwaway HOME, GOPATH and no go env file")
+	flags.String("netrc", "", "the .netrc file with the credentials for downloading modules")
+	flags.String("goauth", "", "the GOAUTH for authenticating module downloads (Go 1.24 or newer)")
+	flags.String("proxy", "", "the HTTP and HTTPS proxy of the commands of the build, like http://proxy.example.com:3128")
+	flags.String("no-proxy", "", "the hosts the commands of the build reach without the proxy, as in NO_PROXY")
+	flags.String("ca-cert", "", "a PEM file of CA certificates trusted for downloading modules, in addition to those of the system")
+	flags.String("client-cert", "", "the PEM file of a client certificate which git presents for mutual TLS; requires --client-key")
+	flags.String("client-key", "", "the PEM file of the key of --client-cert")
+	flags.StringArray("insecure-modules", []string{}, "a pattern of module paths which are fetched without verifying TLS, as in GOINSECURE")
+	flags.StringArray("module-proxy", []string{}, "downloads the modules with a path prefix from a private module proxy, like corp.example.com=https://athens.corp.example.com")
+	flags.String("modcache", "", "the module cache of the go commands of the build, instead of the one of the user")
+	flags.Bool("offline", false, "downloads nothing, taking the modules from the module cache only")
+	addTimeoutFlags(flags)
+	flags.Duration("timeout-get", 0, "the maximum time for pinning the versions of the modules, like 5m")
+	flags.Bool("resolve-ambiguities", false, "always runs an empty go get after pinning the versions of the plugins")
+}
+
+// addTimeoutFlags adds the flags which limit the time of a build.
+func addTimeoutFlags(flags *pflag.FlagSet) {
+	flags.Duration("timeout-build", 0, "the maximum time for building Caddy, for each platform of --platforms, like 20m")
+	flags.Duration("timeout-compile", 0, "the maximum time for compiling Caddy, like 10m")
+	flags.Duration("timeout-total", 0, "the maximum time for the whole build, like 30m")
 }
 
 var versionCommand = &cobra.Command{
@@ -32,80 +105,353 @@ var versionCommand = &cobra.Command{
 
 var buildCommand = &cobra.Command{
 	Use: `build [<caddy_version>]
+    [--caddy <caddy_version>]
     [--output <file>]
-    [--with <module[@version][=replacement]>...]
+    [--platforms <os/arch[/arm]|bundle>...]
+    [--with <module|repository_url[@version][=replacement]>...]
     [--replace <module[@version]=replacement>...]
-    [--embed <[alias]:path/to/dir>...]`,
+    [--replace-root <dir>...]
+    [--replace-from <namespace>]
+    [--plan]
+    [--preset <name>...]
+    [--bare]
+    [--without <module>...]
+    [--main-preset <plain|run>]
+    [--default-config <file>]
+    [--default-adapter <name>]
+    [--default-env <name=value>...]
+    [--buildmode <exe|plugin>]
+    [--embed <[alias]:path/to/dir>...]
+    [--embed-max-size <size>]
+    [--patch <module=path/to/file.patch>...]
+    [--exclude <module@version>...]
+    [--from-gomod <path/to/go.mod> [--from-gomod-requires]]
+    [--embed-gomod]
+    [--attestation <file> [--sign <key>]]
+    [--changelog <file>]
+    [--strict]
+    [--cover]
+    [--ignore-goflags]
+    [--go-version <version>]
+    [--define <key=value>...]
+    [--sandbox]
+    [--netrc <file>]
+    [--goauth <value>]
+    [--proxy <url> [--no-proxy <hosts>]]
+    [--ca-cert <file>]
+    [--client-cert <file> --client-key <file>]
+    [--insecure-modules <pattern>...]
+    [--module-proxy <prefix=url>...]
+    [--modcache <dir>]
+    [--offline]
+    [--timeout-get <duration>]
+    [--timeout-build <duration>]
+    [--timeout-compile <duration>]
+    [--timeout-total <duration>]
+    [--resolve-ambiguities]
+    [--graph <file>]
+    [--why <module>...]
+    [--prune-report]
+    [--with-service]
+    [--deploy-to <[user@]host[:path]> [--deploy-restart <command>] [--deploy-admin <url>]]
+    [--replace-running [--signal <signal> --pidfile <file>]]
+    [--porcelain]
+    [--ci]`,
 	Long: `
 <caddy_version> is the core Caddy version to build; defaults to CADDY_VERSION env variable or latest.
+It may also be given with the --caddy flag instead.
 This can be the keyword latest, which will use the latest stable tag, or any git ref such as:
 
 A tag like v2.0.1
 A branch like master
 A commit like a58f240d3ecbb59285303746406cab50217f8d24
 
+It can also be the keyword beta, which will use the newest tag including pre-releases,
+or a version constraint like 2.8.x, ~2.8 or ">=2.8 <2.10", which will use the newest matching tag.
+
 Flags: 
- --output changes the output file.
+ --output changes the output file; use - to write the binary to stdout for piping, in which case all logs go to stderr. The final go.mod and go.sum of the build are written next to it, with .go.mod and .go.sum appended to its name. The name may be a Go template with the fields CaddyVersion, OS, Arch, ARM, Ext (.exe on Windows), Date and PluginsHash, like dist/caddy_{{.CaddyVersion}}_{{.OS}}_{{.Arch}}{{.Ext}}; parent directories are created as needed.
 
- --with can be used multiple times to add plugins by specifying the Go module name and optionally its version, similar to go get. Module name is required, but specific version and/or local replacement are optional.
+ --platforms builds for several platforms at once, with the modules resolved only once, and prints a table of the binaries. Platforms are given as os/arch, like linux/arm64, with the version of ARM added for arm, like linux/arm/7, or as a bundle: common is linux/amd64, linux/arm64, windows/amd64 and darwin/arm64, and all-first-class is every first-class port of the go command, as listed by xcaddy platforms --first-class. --platforms can be used multiple times or with a comma-separated list. The output file must then be a template which gives a different file for each platform, and defaults to caddy_{{.OS}}_{{.Arch}}{{if .ARM}}v{{.ARM}}{{end}}{{.Ext}}. It can't be combined with --output -, --attestation, --changelog, --with-service, --deploy-to, --replace-running, --porcelain or --ci.
+
+ --with can be used multiple times to add plugins by specifying the Go module name and optionally its version, similar to go get. Module name is required, but specific version and/or local replacement are optional. Instead of the module name, the https:// URL of its repository may be given, optionally with a branch as version; the module name is then discovered from the go-import meta tag served by the repository host. A plugin given more than once, like also by a preset, is built once; it is an error to give it at two different versions.
 
  --replace is like --with, but does not add a blank import to the code; it only writes a replace directive to go.mod, which is useful when developing on Caddy's dependencies (ones that are not Caddy modules). Try this if you got an error when using --with, like cannot find module providing package.
 
- --embed can be used to embed the contents of a directory into the Caddy executable. --embed can be passed multiple times with separate source directories. The source directory can be prefixed with a custom alias and a colon : to write the embedded files into an aliased subdirectory, which is useful when combined with the root directive and sub-directive.
+ The module path given to --replace may be a pattern with a single *, like github.com/myorg/*=../forks/{name}, which replaces every module of the build matching it; {name} stands for what the * matched. Local replacements only apply to modules whose directory exists.
+
+ For both --with and --replace, a local replacement may be a relative path, start with ~ for the home directory, or contain environment variables like $HOME. It must be a directory with a go.mod file.
+
+ --replace-root only allows local replacements, including those of a manifest or imported with --from-gomod, within the given folder, like the workspace of a CI job; symlinks are resolved before checking. It is useful when building manifests which aren't trusted with arbitrary paths of the host. --replace-root can be used multiple times, and combined with --manifest.
+
+ --replace-from replaces each plugin given with a version, like --with github.com/caddy-dns/cloudflare@v0.1.0, by its fork in a namespace at the same version, like github.com/acme/forks/cloudflare@v0.1.0 for github.com/acme/forks, as organizations which patch their dependencies in forks do. The fork is named after the last element of the module path, keeping a major version suffix like /v2, and must have the tag. Plugins replaced otherwise are left as they are.
+
+ --plan prints where Caddy and each plugin are built from, one per line, and the other replacements, without building anything: a version, the latest version, or a replacement, like github.com/me/plugin: using local path ./plugin, version pin v1.2.3 ignored. Whether or not it is given, the build fails early if a replacement is meant for a plugin but wouldn't apply to it: if it is for another major version of its module, like --with example.com/plugin/v2 with --replace example.com/plugin=./plugin, or only for another version than the one the plugin is pinned to.
+
+ --preset adds a named set of plugins, like dns-all, as if each was given with --with. Plugin names in --with may also be shorthand aliases of popular plugins, like cloudflare-dns. Set XCADDY_ALIASES to the path of a JSON file to add or override aliases and presets.
+
+ --bare leaves out the standard modules of Caddy, so the binary only has the core of Caddy and the plugins, for minimal builds. Even the HTTP app is a standard module, so a plugin needing it must import it.
+
+ --without leaves out a single standard module of Caddy instead, given by its package path relative to the modules folder of Caddy, like caddyhttp/templates, or an absolute one; the packages in its subfolders are left out as well. The main package then imports the remaining standard modules one by one. A warning is printed if a module left out is still part of the build, since another module imports it. --without can be used multiple times.
+
+ --main-preset selects the main package which is generated for the build. plain, the default, only runs Caddy. run applies the defaults given with --default-config, --default-adapter and --default-env first, so the binary just runs with the right config, like in appliance-style deployments; without arguments, it runs caddy run. The default config and adapter apply to the commands run, start, reload, validate and adapt, unless --config or --adapter is given, and the default environment variables only apply if they are not set already. --default-env can be used multiple times.
+
+ --buildmode=plugin is experimental, for exploring dynamic loading: the plugins are built into a Go plugin, a shared object written next to the output file with .so appended to its name, instead of into Caddy, which is built without them. Caddy loads the Go plugins in CADDY_GO_PLUGINS, a list of paths like PATH, when it starts. Go only loads a plugin built with the same toolchain, build flags and versions of the packages it shares with the program, so both are built from the same go.mod with the same flags, and their build information is compared afterwards. Go plugins require cgo, which is enabled, and are only supported on Linux, FreeBSD and macOS.
+
+ --embed can be used to embed the contents of a directory into the Caddy executable. --embed can be passed multiple times with separate source directories. The source directory can be prefixed with a custom alias and a colon : to write the embedded files into an aliased subdirectory, which is useful when combined with the root directive and sub-directive. Aliases must be unique relative paths which don't nest in each other; the alias . embeds into the root. Each directory must exist and contain at least one file.
+
+ --embed-max-size sets the maximum total size of the embedded directories, like 500MB or 2GiB, or unlimited; the build fails if they are larger. Defaults to 1GiB, so huge directories aren't embedded by accident.
+
+ --patch applies a patch file to a copy of a module's source and replaces the module with the patched copy, which is useful for urgent fixes to dependencies that have not been released upstream yet. The module must be part of the build. --patch can be used multiple times.
+
+ --exclude writes an exclude directive to go.mod, which forces dependency resolution away from a known-broken version of a module. --exclude can be used multiple times.
+
+ --from-gomod imports the replace and exclude directives of an existing go.mod file, so an established dependency policy doesn't have to be repeated as flags. Relative replacements are resolved against the directory of that go.mod file. With --from-gomod-requires, its require directives are imported as well, as minimum versions.
+
+ --embed-gomod embeds a compressed copy of the final go.mod and go.sum into the Caddy executable, so it can be audited or reproduced even without the files written next to it.
+
+ --attestation writes an in-toto statement of the SLSA provenance of the binary to a file: its subject is the sha256 of the binary, and its dependencies are the modules of the final go.sum with their hashes. The statement is not signed, unless --sign is given.
+
+ --sign signs the attestation with the private key in a PEM file, an unencrypted ECDSA, Ed25519 or RSA key like one made by openssl genpkey, and writes it as a DSSE envelope of the statement, which cosign verify-blob-attestation and policy engines like Kyverno verify with the public key. Keys encrypted by cosign are not supported; sign the statement with cosign attest-blob instead.
+
+ --changelog writes the modules which changed from the binary at the output path, which the build replaces, to a file, as a changelog in Markdown, like for reviewing a rebuild in a pull request. Changes of modules on GitHub link to their release notes and commits. Nothing is written if there is no previous binary; see also the diff command.
+
+ --strict fails the build with the actual error if go mod tidy reports any, instead of ignoring errors and possibly dropping packages. Either way, a warning is printed if tidy removed the module of a requested plugin; with --strict, it is an error.
+
+ --cover builds the binary with coverage instrumentation of the plugins' packages (Go 1.20 or newer), for collecting the coverage of integration tests. Coverage data is written to the folder in GOCOVERDIR when Caddy exits; see go tool covdata for processing it.
+
+ --ignore-goflags ignores GOFLAGS from the environment. Otherwise, GOFLAGS applies to the go commands of the build, except -mod and -modfile, which are dropped since xcaddy manages the module it builds in. Build tags set by GOFLAGS are added to the default ones, while other flags are overridden by the same flags in XCADDY_GO_BUILD_FLAGS, like with the go command.
+
+ --go-version builds with exactly this Go toolchain, like 1.22.5, regardless of the installed go command, by setting GOTOOLCHAIN for all go commands of the build. The toolchain is downloaded if needed. The Go version the binary was built with is printed in the summary at the end of the build.
+
+ --define defines build-time configuration for plugins, like a default endpoint, as key=value, which plugins read with the package github.com/caddyserver/xcaddy/defines. The definitions are set with the linker flag -X, which is added to the -ldflags of the build. --define can be used multiple times.
+
+ --sandbox runs the go commands of the build, and git, with a throwaway HOME, GOPATH and no go env file, so go env settings, toolchains and credentials of the user can't affect the build. The module and build caches are still shared. Variables set in the environment, like GOPROXY or GOFLAGS, still apply. An environment created with --sandbox stays sandboxed.
+
+ --netrc sets the .netrc file with the credentials for downloading modules, like from a private module proxy, instead of the one in the home folder, by setting NETRC for the go commands of the build. With --sandbox, git uses it as well.
+
+ --goauth sets GOAUTH for the go commands of the build (Go 1.24 or newer), which selects how they authenticate module downloads; see go help goauth.
+
+ --proxy sets the proxy of the HTTP and HTTPS requests of the go commands of the build and git, like http://proxy.example.com:3128, by setting HTTP_PROXY and HTTPS_PROXY for them only, so the proxy doesn't have to be configured globally. --no-proxy sets the hosts reached without it, as NO_PROXY does. Since the URL may contain credentials, neither is kept by environments; give them again to build from one.
+
+ --ca-cert trusts the CA certificates in a PEM file for downloading modules, like from internal Git servers or module proxies with a private CA, in addition to those of the system, by giving a bundle of both to the go commands of the build (SSL_CERT_FILE) and git (GIT_SSL_CAINFO). The go command ignores it on macOS and Windows, where it only trusts the certificates of the system.
+
+ --client-cert and --client-key set a client certificate and its key, as PEM files, which git presents to servers requiring mutual TLS (GIT_SSL_CERT and GIT_SSL_KEY). The go command can't present client certificates, so modules from such servers must be fetched with git directly, by matching them with GOPRIVATE, rather than from a module proxy.
+
+ --insecure-modules fetches the modules matching a pattern of module paths, like git.corp.example.com/*, without verifying TLS, as GOINSECURE does, which it is added to; this is a last resort, prefer --ca-cert. It can be used multiple times. Since anyone on the way to those hosts can tamper with the modules, a warning is repeated at the end of the build, and the patterns, including those of GOINSECURE, are recorded in the binary and shown in the build summary.
+
+ --module-proxy downloads the modules whose path starts with a prefix from a private module proxy, like Athens or Artifactory, only for the go commands of the build. Since the go command can't choose a proxy by module path, the private proxies are tried in order before those of GOPROXY, falling back to the next one when a proxy doesn't have a module, and the modules of the prefixes are not looked up in the checksum database. So that a public module can't take the place of a private one this way, the build fails, before compiling anything, if a private proxy doesn't serve a module of its prefix. Credentials of the proxies belong in the .netrc file (see --netrc). --module-proxy can be used multiple times.
+
+ --modcache sets the module cache of the go commands of the build, instead of the one of the user, by setting GOMODCACHE.
+
+ --offline downloads nothing: the modules are taken from the module cache only, which serves as the module proxy, so the build fails if one is missing; see the verify-offline command. The checksum database can't be reached, so it is not used, and nothing verifies the modules: they are built as the module cache has them, so it must come from a trusted host and be copied without changes. --offline can't be combined with --module-proxy.
+
+ Environments created with --netrc, --goauth, --ca-cert, --client-cert, --insecure-modules, --module-proxy, --modcache or --offline keep using them.
+
+ --timeout-get, --timeout-compile, --timeout-build and --timeout-total limit the time for pinning the versions of the modules, for compiling Caddy, for building it for each platform, and for the whole build, including all platforms of --platforms, respectively, like 5m or 1h30m. They are unlimited by default. The go commands still running when a timeout expires are killed, along with the compiler and linker processes they started.
+
+ After pinning the versions of the plugins, an empty go get resolves the imports which a plugin made ambiguous or missing, if go list reports any. --resolve-ambiguities runs it regardless, as xcaddy used to, in case a build works only with it.
+
+ --graph writes the full dependency graph of the build, as reported by go mod graph, to a file in the DOT language of Graphviz, or as JSON if its name ends in .json. Each requirement is annotated with the plugins which introduced it, or caddy if Caddy itself needs it, which helps to find out why a dependency is part of the build.
+
+ --why explains why a module is part of the build before compiling, by printing the shortest chain of imports from the main package to one of its packages, as go mod why -m does. --why can be used multiple times.
+
+ --prune-report prints the heaviest dependencies after the build, by the size of their Go source which is compiled in, along with the plugins which introduced them, or caddy if Caddy itself needs them. This guides which plugins to drop for a smaller binary.
+
+ --with-service writes a systemd unit, a default Caddyfile and an install script next to the output file, with .service, .Caddyfile and .install.sh appended to its name. They follow the layout of the official Linux packages: a caddy user, the binary at /usr/bin/caddy and the config in /etc/caddy.
+
+ --deploy-to replaces the binary on a host after the build and restarts Caddy there, for deploying to a few hosts without other tooling. The binary is copied with ssh, which must be able to log in without prompting, to the path given after the host, /usr/bin/caddy by default, as in [user@]host[:path], which is not a URL, next to which it is written first and then moved over the old binary. Then the command of --deploy-restart is run on the host, systemctl restart caddy by default, since Caddy must be restarted to run a new binary. With --deploy-admin, the admin API of Caddy on the host, like http://web1.example.com:2019, must respond within 30 seconds after the restart. The build must be for the platform of the host; set GOOS and GOARCH accordingly.
+
+ --replace-running replaces the binary at the output path safely, even while Caddy is running from it: the new binary is built next to it, must run its version command, and is only then moved over the old one, along with the files written next to it. Processes running the old binary keep running it; on Windows, it is moved aside to a file with .old appended first. The build must be for the platform xcaddy runs on, and the output file can't be - or a template. With --signal and --pidfile, the signal is then sent to the process whose ID is in the file, as written by caddy run --pidfile, like TERM to make Caddy shut down gracefully so its supervisor, like systemd, restarts it with the new binary. --signal is not supported on Windows.
+
+ --porcelain prints the summary at the end of the build as name=value lines, which are stable for scripts: binary, size (in bytes), sha256, version, plugins (their number), duration (in seconds) and go (the Go version it was built with). All other output goes to stderr.
+
+ --ci formats the output for GitHub Actions: steps are wrapped in collapsible groups and failures are reported as error annotations. If GITHUB_OUTPUT is set, the path, Caddy version and sha256 of the binary are written to it as the outputs binary, version and sha256. Git is never allowed to prompt for credentials.
+
+Successful builds are recorded in a history file, unless XCADDY_HISTORY is off; see the history and reproduce commands.
 `,
 	Short: "Compile custom caddy binaries",
 	Args:  cobra.MaximumNArgs(1),
 	RunE: func(cmd *cobra.Command, args []string) error {
-		var output string
-		var plugins []xcaddy.Dependency
-		var replacements []xcaddy.Replace
-		var embedDir []string
-		var argCaddyVersion string
-		if len(args) > 0 {
-			argCaddyVersion = args[0]
-		}
-		withArgs, err := cmd.Flags().GetStringArray("with")
+		start := time.Now()
+		builder, err := builderFromFlags(cmd, args)
 		if err != nil {
-			return fmt.Errorf("unable to parse --with arguments: %s", err.Error())
+			return err
 		}
 
-		replaceArgs, err := cmd.Flags().GetStringArray("replace")
+		plan, err := cmd.Flags().GetBool("plan")
 		if err != nil {
-			return fmt.Errorf("unable to parse --replace arguments: %s", err.Error())
+			return fmt.Errorf("unable to parse --plan arguments: %s", err.Error())
 		}
-		for _, withArg := range withArgs {
-			mod, ver, repl, err := splitWith(withArg)
+		if plan {
+			lines, err := builder.Plan()
 			if err != nil {
 				return err
 			}
-			mod = strings.TrimSuffix(mod, "/") // easy to accidentally leave a trailing slash if pasting from a URL, but is invalid for Go modules
-			plugins = append(plugins, xcaddy.Dependency{
-				PackagePath: mod,
-				Version:     ver,
-			})
-			handleReplace(withArg, mod, ver, repl, &replacements)
+			for _, line := range lines {
+				fmt.Println(line)
+			}
+			return nil
+		}
+
+		output, err := cmd.Flags().GetString("output")
+		if err != nil {
+			return fmt.Errorf("unable to parse --output arguments: %s", err.Error())
+		}
+
+		platformSpecs, err := cmd.Flags().GetStringArray("platforms")
+		if err != nil {
+			return fmt.Errorf("unable to parse --platforms arguments: %s", err.Error())
+		}
+
+		embedGoMod, err := cmd.Flags().GetBool("embed-gomod")
+		if err != nil {
+			return fmt.Errorf("unable to parse --embed-gomod arguments: %s", err.Error())
+		}
+
+		attestation, err := cmd.Flags().GetString("attestation")
+		if err != nil {
+			return fmt.Errorf("unable to parse --attestation arguments: %s", err.Error())
+		}
+		signingKey, err := cmd.Flags().GetString("sign")
+		if err != nil {
+			return fmt.Errorf("unable to parse --sign arguments: %s", err.Error())
+		}
+		if signingKey != "" && attestation == "" {
+			return fmt.Errorf("--sign requires --attestation")
+		}
+
+		changelogFile, err := cmd.Flags().GetString("changelog")
+		if err != nil {
+			return fmt.Errorf("unable to parse --changelog arguments: %s", err.Error())
+		}
+
+		strict, err := cmd.Flags().GetBool("strict")
+		if err != nil {
+			return fmt.Errorf("unable to parse --strict arguments: %s", err.Error())
 		}
 
-		for _, withArg := range replaceArgs {
-			mod, ver, repl, err := splitWith(withArg)
+		cover, err := cmd.Flags().GetBool("cover")
+		if err != nil {
+			return fmt.Errorf("unable to parse --cover arguments: %s", err.Error())
+		}
+		if cover && len(builder.Plugins) == 0 {
+			return fmt.Errorf("--cover requires at least one plugin to instrument")
+		}
+
+		graphFile, err := cmd.Flags().GetString("graph")
+		if err != nil {
+			return fmt.Errorf("unable to parse --graph arguments: %s", err.Error())
+		}
+
+		why, err := cmd.Flags().GetStringArray("why")
+		if err != nil {
+			return fmt.Errorf("unable to parse --why arguments: %s", err.Error())
+		}
+		pruneReport, err := cmd.Flags().GetBool("prune-report")
+		if err != nil {
+			return fmt.Errorf("unable to parse --prune-report arguments: %s", err.Error())
+		}
+
+		withService, err := cmd.Flags().GetBool("with-service")
+		if err != nil {
+			return fmt.Errorf("unable to parse --with-service arguments: %s", err.Error())
+		}
+		if withService && utils.GetGOOS() != "linux" {
+			return fmt.Errorf("--with-service is only supported when building for linux, not %s", utils.GetGOOS())
+		}
+
+		deployTo, err := cmd.Flags().GetString("deploy-to")
+		if err != nil {
+			return fmt.Errorf("unable to parse --deploy-to arguments: %s", err.Error())
+		}
+		var target deployTarget
+		if deployTo != "" {
+			target, err = parseDeployTarget(deployTo)
 			if err != nil {
 				return err
 			}
-			handleReplace(withArg, mod, ver, repl, &replacements)
+			target.Restart, err = cmd.Flags().GetString("deploy-restart")
+			if err != nil {
+				return fmt.Errorf("unable to parse --deploy-restart arguments: %s", err.Error())
+			}
+			target.Admin, err = cmd.Flags().GetString("deploy-admin")
+			if err != nil {
+				return fmt.Errorf("unable to parse --deploy-admin arguments: %s", err.Error())
+			}
 		}
 
-		output, err = cmd.Flags().GetString("output")
+		ciMode, err := cmd.Flags().GetBool("ci")
 		if err != nil {
-			return fmt.Errorf("unable to parse --output arguments: %s", err.Error())
+			return fmt.Errorf("unable to parse --ci arguments: %s", err.Error())
+		}
+		porcelain, err := cmd.Flags().GetBool("porcelain")
+		if err != nil {
+			return fmt.Errorf("unable to parse --porcelain arguments: %s", err.Error())
+		}
+		if porcelain && ciMode {
+			return fmt.Errorf("--porcelain can't be combined with --ci")
 		}
 
-		embedDir, err = cmd.Flags().GetStringArray("embed")
+		replace, err := cmd.Flags().GetBool("replace-running")
+		if err != nil {
+			return fmt.Errorf("unable to parse --replace-running arguments: %s", err.Error())
+		}
+		signalName, err := cmd.Flags().GetString("signal")
+		if err != nil {
+			return fmt.Errorf("unable to parse --signal arguments: %s", err.Error())
+		}
+		pidFile, err := cmd.Flags().GetString("pidfile")
 		if err != nil {
-			return fmt.Errorf("unable to parse --embed arguments: %s", err.Error())
+			return fmt.Errorf("unable to parse --pidfile arguments: %s", err.Error())
+		}
+		if (signalName != "") != (pidFile != "") {
+			return fmt.Errorf("--signal and --pidfile must be given together")
+		}
+		if signalName != "" {
+			if !replace {
+				return fmt.Errorf("--signal requires --replace-running")
+			}
+			if _, err := parseSignal(signalName); err != nil {
+				return err
+			}
+		}
+		if replace && (runtime.GOOS != utils.GetGOOS() || runtime.GOARCH != utils.GetGOARCH()) {
+			return fmt.Errorf("--replace-running requires building for %s/%s, so the new binary can be verified", runtime.GOOS, runtime.GOARCH)
+		}
+
+		ci := ciReporter{enabled: ciMode, out: os.Stdout}
+		if ci.enabled {
+			// a credential prompt would hang the job until it times out
+			os.Setenv("GIT_TERMINAL_PROMPT", "0")
+		}
+
+		builder.EmbedModFiles = embedGoMod
+		builder.AttestationFile = attestation
+		builder.AttestationKey = signingKey
+		builder.Strict = strict
+		builder.Cover = cover
+		builder.GraphFile = graphFile
+		builder.Why = why
+		builder.PruneReport = pruneReport
+		builder.Invocation = &xcaddy.Invocation{
+			XcaddyVersion: xcaddyVersion(),
+			Args:          recordedArgs(os.Args[1:]),
 		}
-		// prefer caddy version from command line argument over env var
-		if argCaddyVersion != "" {
-			caddyVersion = argCaddyVersion
+
+		// builds for several platforms are summarized
+		// differently, since there is no single binary
+		if len(platformSpecs) > 0 {
+			err = checkPlatformsFlags(cmd, output)
+			if err != nil {
+				return err
+			}
+			platforms, err := xcaddy.ParsePlatforms(cmd.Root().Context(), platformSpecs...)
+			if err != nil {
+				return err
+			}
+			builder.WriteModFiles = true
+			return buildPlatforms(cmd.Root().Context(), builder, platforms, output)
 		}
 
 		// ensure an output file is always specified
@@ -113,41 +459,84 @@ Flags:
 			output = getCaddyOutputFile()
 		}
 
-		// perform the build
-		builder := xcaddy.Builder{
-			Compile: xcaddy.Compile{
-				Cgo: os.Getenv("CGO_ENABLED") == "1",
-			},
-			CaddyVersion: caddyVersion,
-			Plugins:      plugins,
-			Replacements: replacements,
-			RaceDetector: raceDetector,
-			SkipBuild:    skipBuild,
-			SkipCleanup:  skipCleanup,
-			Debug:        buildDebugOutput,
-			BuildFlags:   buildFlags,
-			ModFlags:     modFlags,
-		}
-		for _, md := range embedDir {
-			if before, after, found := strings.Cut(md, ":"); found {
-				builder.EmbedDirs = append(builder.EmbedDirs, struct {
-					Dir  string `json:"dir,omitempty"`
-					Name string `json:"name,omitempty"`
-				}{
-					after, before,
-				})
+		// the binary or the summary may be written to stdout for
+		// scripts, in which case everything else, including the
+		// output of the go commands, must go to stderr; a binary
+		// for stdout is built in a temporary folder
+		var out io.Writer = os.Stdout
+		toStdout := output == "-"
+		if toStdout && (withService || ci.enabled || porcelain || deployTo != "") {
+			return fmt.Errorf("--output - can't be combined with --with-service, --deploy-to, --porcelain or --ci")
+		}
+		if toStdout || porcelain {
+			out = os.Stderr
+		}
+		builder.Stdout = out
+		if toStdout {
+			outputDir, err := os.MkdirTemp("", "xcaddy-output-")
+			if err != nil {
+				return err
+			}
+			defer os.RemoveAll(outputDir)
+			output = filepath.Join(outputDir, "caddy")
+		}
+
+		// the binary which is replaced, if any, to compare with;
+		// a templated output file can't be known before the build
+		var previous *buildSummary
+		if changelogFile != "" {
+			if toStdout {
+				return fmt.Errorf("--output - can't be combined with --changelog")
+			}
+			if bi, inv, err := readBinary(output); err == nil {
+				summary := summarizeBinary(bi, inv)
+				previous = &summary
 			} else {
-				builder.EmbedDirs = append(builder.EmbedDirs, struct {
-					Dir  string `json:"dir,omitempty"`
-					Name string `json:"name,omitempty"`
-				}{
-					before, "",
-				})
+				log.Printf("[WARNING] No previous build to write a changelog against: %v", err)
 			}
 		}
-		err = builder.Build(cmd.Root().Context(), output)
+
+		// with --replace-running, the build goes to a staging folder
+		// next to the output file, from which it is moved over the
+		// old binary once verified
+		var staging, replaced string
+		if replace {
+			if toStdout || strings.Contains(output, "{{") {
+				return fmt.Errorf("--replace-running requires an output file which is neither - nor a template")
+			}
+			staging, err = stagingFolder(output)
+			if err != nil {
+				return err
+			}
+			defer os.RemoveAll(staging)
+			replaced = output
+			output = filepath.Join(staging, filepath.Base(output))
+		}
+
+		// perform the build
+		builder.WriteModFiles = !toStdout
+		endGroup := ci.group("Build Caddy")
+		output, err = builder.BuildFile(cmd.Root().Context(), output)
+		endGroup()
+		if err != nil && toStdout {
+			return err
+		}
 		if err != nil {
-			log.Fatalf("[FATAL] %v", err)
+			return ci.fail(err)
+		}
+
+		if toStdout {
+			if builder.SkipBuild {
+				return nil
+			}
+			return writeBinary(os.Stdout, output)
+		}
+
+		if withService {
+			err = writeServiceFiles(output)
+			if err != nil {
+				return err
+			}
 		}
 
 		// done if we're skipping the build
@@ -161,37 +550,599 @@ Flags:
 			return err
 		}
 
+		if previous != nil {
+			err = writeChangelogFile(changelogFile, *previous, output)
+			if err != nil {
+				return err
+			}
+		}
+
 		// prove the build is working by printing the version
 		if runtime.GOOS == utils.GetGOOS() && runtime.GOARCH == utils.GetGOARCH() {
 			if !filepath.IsAbs(output) {
 				output = "." + string(filepath.Separator) + output
 			}
-			fmt.Println()
-			fmt.Printf("%s version\n", output)
+			endGroup := ci.group("Check Caddy version")
+			fmt.Fprintln(out)
+			fmt.Fprintf(out, "%s version\n", output)
 			cmd := exec.Command(output, "version")
-			cmd.Stdout = os.Stdout
+			cmd.Stdout = out
 			cmd.Stderr = os.Stderr
 			err = cmd.Run()
+			endGroup()
 			if err != nil {
-				log.Fatalf("[FATAL] %v", err)
+				return ci.fail(err)
 			}
 		}
 
-		return nil
+		if replace {
+			err = replaceBinary(staging, replaced)
+			if err != nil {
+				return err
+			}
+			output = replaced
+			if signalName != "" {
+				err = signalPIDFile(pidFile, signalName)
+				if err != nil {
+					return err
+				}
+			}
+		}
+
+		if deployTo != "" {
+			endGroup := ci.group("Deploy to " + target.Host)
+			err = deploy(cmd.Context(), out, output, target)
+			endGroup()
+			if err != nil {
+				return ci.fail(err)
+			}
+		}
+
+		result, err := newBuildResult(output, len(builder.Plugins), time.Since(start))
+		if err != nil {
+			return err
+		}
+		if porcelain {
+			err = result.printPorcelain(os.Stdout)
+		} else {
+			err = result.print(out)
+		}
+		if err != nil {
+			return err
+		}
+		// the build succeeded, even if it can't be recorded
+		err = recordBuild(builder, result, buildReports(builder, result.Output))
+		if err != nil {
+			log.Printf("[WARNING] Unable to record the build in the history: %v", err)
+		}
+		return ci.writeOutputs(result)
 	},
 }
 
-func handleReplace(orig, mod, ver, repl string, replacements *[]xcaddy.Replace) {
-	if repl != "" {
-		// adjust relative replacements in current working directory since our temporary module is in a different directory
-		if strings.HasPrefix(repl, ".") {
-			var err error
-			repl, err = filepath.Abs(repl)
+// builderFromFlags returns a Builder configured by the flags added
+// with addBuilderFlags, the optional Caddy version argument, and the
+// environment variables.
+func builderFromFlags(cmd *cobra.Command, args []string) (xcaddy.Builder, error) {
+	var plugins []xcaddy.Dependency
+	var replacements []xcaddy.Replace
+	var patches []xcaddy.Patch
+	var excludes []xcaddy.Dependency
+	var requires []xcaddy.Dependency
+	var argCaddyVersion string
+	if len(args) > 0 {
+		argCaddyVersion = args[0]
+	}
+	fromGoMod, err := cmd.Flags().GetString("from-gomod")
+	if err != nil {
+		return xcaddy.Builder{}, fmt.Errorf("unable to parse --from-gomod arguments: %s", err.Error())
+	}
+	fromGoModRequires, err := cmd.Flags().GetBool("from-gomod-requires")
+	if err != nil {
+		return xcaddy.Builder{}, fmt.Errorf("unable to parse --from-gomod-requires arguments: %s", err.Error())
+	}
+	if fromGoModRequires && fromGoMod == "" {
+		return xcaddy.Builder{}, fmt.Errorf("--from-gomod-requires requires --from-gomod")
+	}
+	if fromGoMod != "" {
+		// directives from the file come first so that flags can override them
+		var gomodRequires []xcaddy.Dependency
+		replacements, excludes, gomodRequires, err = readGoMod(fromGoMod)
+		if err != nil {
+			return xcaddy.Builder{}, fmt.Errorf("reading %s: %v", fromGoMod, err)
+		}
+		if fromGoModRequires {
+			requires = gomodRequires
+		}
+	}
+
+	ignoreGoFlags, err := cmd.Flags().GetBool("ignore-goflags")
+	if err != nil {
+		return xcaddy.Builder{}, fmt.Errorf("unable to parse --ignore-goflags arguments: %s", err.Error())
+	}
+	goVersion, err := cmd.Flags().GetString("go-version")
+	if err != nil {
+		return xcaddy.Builder{}, fmt.Errorf("unable to parse --go-version arguments: %s", err.Error())
+	}
+	defineArgs, err := cmd.Flags().GetStringArray("define")
+	if err != nil {
+		return xcaddy.Builder{}, fmt.Errorf("unable to parse --define arguments: %s", err.Error())
+	}
+	defines, err := parseKeyValues(defineArgs, "define")
+	if err != nil {
+		return xcaddy.Builder{}, err
+	}
+	bare, err := cmd.Flags().GetBool("bare")
+	if err != nil {
+		return xcaddy.Builder{}, fmt.Errorf("unable to parse --bare arguments: %s", err.Error())
+	}
+	without, err := cmd.Flags().GetStringArray("without")
+	if err != nil {
+		return xcaddy.Builder{}, fmt.Errorf("unable to parse --without arguments: %s", err.Error())
+	}
+	mainPreset, err := cmd.Flags().GetString("main-preset")
+	if err != nil {
+		return xcaddy.Builder{}, fmt.Errorf("unable to parse --main-preset arguments: %s", err.Error())
+	}
+	buildMode, err := cmd.Flags().GetString("buildmode")
+	if err != nil {
+		return xcaddy.Builder{}, fmt.Errorf("unable to parse --buildmode arguments: %s", err.Error())
+	}
+	defaultConfig, err := cmd.Flags().GetString("default-config")
+	if err != nil {
+		return xcaddy.Builder{}, fmt.Errorf("unable to parse --default-config arguments: %s", err.Error())
+	}
+	defaultAdapter, err := cmd.Flags().GetString("default-adapter")
+	if err != nil {
+		return xcaddy.Builder{}, fmt.Errorf("unable to parse --default-adapter arguments: %s", err.Error())
+	}
+	defaultEnvArgs, err := cmd.Flags().GetStringArray("default-env")
+	if err != nil {
+		return xcaddy.Builder{}, fmt.Errorf("unable to parse --default-env arguments: %s", err.Error())
+	}
+	defaultEnv, err := parseKeyValues(defaultEnvArgs, "default environment variable")
+	if err != nil {
+		return xcaddy.Builder{}, err
+	}
+	sandbox, err := cmd.Flags().GetBool("sandbox")
+	if err != nil {
+		return xcaddy.Builder{}, fmt.Errorf("unable to parse --sandbox arguments: %s", err.Error())
+	}
+	netrc, err := cmd.Flags().GetString("netrc")
+	if err != nil {
+		return xcaddy.Builder{}, fmt.Errorf("unable to parse --netrc arguments: %s", err.Error())
+	}
+	goAuth, err := cmd.Flags().GetString("goauth")
+	if err != nil {
+		return xcaddy.Builder{}, fmt.Errorf("unable to parse --goauth arguments: %s", err.Error())
+	}
+	proxy, err := cmd.Flags().GetString("proxy")
+	if err != nil {
+		return xcaddy.Builder{}, fmt.Errorf("unable to parse --proxy arguments: %s", err.Error())
+	}
+	noProxy, err := cmd.Flags().GetString("no-proxy")
+	if err != nil {
+		return xcaddy.Builder{}, fmt.Errorf("unable to parse --no-proxy arguments: %s", err.Error())
+	}
+	if noProxy != "" && proxy == "" {
+		return xcaddy.Builder{}, fmt.Errorf("--no-proxy requires --proxy")
+	}
+	caCert, err := cmd.Flags().GetString("ca-cert")
+	if err != nil {
+		return xcaddy.Builder{}, fmt.Errorf("unable to parse --ca-cert arguments: %s", err.Error())
+	}
+	clientCert, err := cmd.Flags().GetString("client-cert")
+	if err != nil {
+		return xcaddy.Builder{}, fmt.Errorf("unable to parse --client-cert arguments: %s", err.Error())
+	}
+	clientKey, err := cmd.Flags().GetString("client-key")
+	if err != nil {
+		return xcaddy.Builder{}, fmt.Errorf("unable to parse --client-key arguments: %s", err.Error())
+	}
+	if (clientCert == "") != (clientKey == "") {
+		return xcaddy.Builder{}, fmt.Errorf("--client-cert and --client-key must be given together")
+	}
+	insecureModules, err := cmd.Flags().GetStringArray("insecure-modules")
+	if err != nil {
+		return xcaddy.Builder{}, fmt.Errorf("unable to parse --insecure-modules arguments: %s", err.Error())
+	}
+	replaceRoots, err := cmd.Flags().GetStringArray("replace-root")
+	if err != nil {
+		return xcaddy.Builder{}, fmt.Errorf("unable to parse --replace-root arguments: %s", err.Error())
+	}
+	replaceFrom, err := cmd.Flags().GetString("replace-from")
+	if err != nil {
+		return xcaddy.Builder{}, fmt.Errorf("unable to parse --replace-from arguments: %s", err.Error())
+	}
+	modCache, err := cmd.Flags().GetString("modcache")
+	if err != nil {
+		return xcaddy.Builder{}, fmt.Errorf("unable to parse --modcache arguments: %s", err.Error())
+	}
+	offline, err := cmd.Flags().GetBool("offline")
+	if err != nil {
+		return xcaddy.Builder{}, fmt.Errorf("unable to parse --offline arguments: %s", err.Error())
+	}
+	moduleProxyArgs, err := cmd.Flags().GetStringArray("module-proxy")
+	if err != nil {
+		return xcaddy.Builder{}, fmt.Errorf("unable to parse --module-proxy arguments: %s", err.Error())
+	}
+	var moduleProxies []xcaddy.ModuleProxy
+	for _, moduleProxyArg := range moduleProxyArgs {
+		moduleProxy, err := parseModuleProxy(moduleProxyArg)
+		if err != nil {
+			return xcaddy.Builder{}, err
+		}
+		moduleProxies = append(moduleProxies, moduleProxy)
+	}
+	timeoutGet, err := cmd.Flags().GetDuration("timeout-get")
+	if err != nil {
+		return xcaddy.Builder{}, fmt.Errorf("unable to parse --timeout-get arguments: %s", err.Error())
+	}
+	resolveAmbiguities, err := cmd.Flags().GetBool("resolve-ambiguities")
+	if err != nil {
+		return xcaddy.Builder{}, fmt.Errorf("unable to parse --resolve-ambiguities arguments: %s", err.Error())
+	}
+
+	withArgs, err := cmd.Flags().GetStringArray("with")
+	if err != nil {
+		return xcaddy.Builder{}, fmt.Errorf("unable to parse --with arguments: %s", err.Error())
+	}
+
+	presetArgs, err := cmd.Flags().GetStringArray("preset")
+	if err != nil {
+		return xcaddy.Builder{}, fmt.Errorf("unable to parse --preset arguments: %s", err.Error())
+	}
+	aliases, err := loadAliases()
+	if err != nil {
+		return xcaddy.Builder{}, err
+	}
+	for _, preset := range presetArgs {
+		presetPlugins, err := aliases.expandPreset(preset)
+		if err != nil {
+			return xcaddy.Builder{}, err
+		}
+		withArgs = append(withArgs, presetPlugins...)
+	}
+
+	replaceArgs, err := cmd.Flags().GetStringArray("replace")
+	if err != nil {
+		return xcaddy.Builder{}, fmt.Errorf("unable to parse --replace arguments: %s", err.Error())
+	}
+	for _, withArg := range withArgs {
+		mod, ver, repl, err := splitWith(withArg)
+		if err != nil {
+			return xcaddy.Builder{}, err
+		}
+		// a module without a dot may still be a local module if replaced
+		if repl == "" && isAlias(mod) {
+			mod, err = aliases.resolve(mod)
+			if err != nil {
+				return xcaddy.Builder{}, err
+			}
+		} else if isVCSURL(mod) {
+			mod, err = resolveVCSURL(cmd.Root().Context(), mod)
 			if err != nil {
-				log.Fatalf("[FATAL] %v", err)
+				return xcaddy.Builder{}, err
 			}
-			log.Printf("[INFO] Resolved relative replacement %s to %s", orig, repl)
 		}
-		*replacements = append(*replacements, xcaddy.NewReplace(xcaddy.Dependency{PackagePath: mod, Version: ver}.String(), repl))
+		mod = strings.TrimSuffix(mod, "/") // easy to accidentally leave a trailing slash if pasting from a URL, but is invalid for Go modules
+		plugins = append(plugins, xcaddy.Dependency{
+			PackagePath: mod,
+			Version:     ver,
+		})
+		err = handleReplace(withArg, mod, ver, repl, &replacements)
+		if err != nil {
+			return xcaddy.Builder{}, err
+		}
+	}
+
+	for _, withArg := range replaceArgs {
+		mod, ver, repl, err := splitWith(withArg)
+		if err != nil {
+			return xcaddy.Builder{}, err
+		}
+		err = handleReplace(withArg, mod, ver, repl, &replacements)
+		if err != nil {
+			return xcaddy.Builder{}, err
+		}
+	}
+
+	embedDir, err := cmd.Flags().GetStringArray("embed")
+	if err != nil {
+		return xcaddy.Builder{}, fmt.Errorf("unable to parse --embed arguments: %s", err.Error())
+	}
+	embedMaxSize, err := embedMaxSizeFromFlags(cmd)
+	if err != nil {
+		return xcaddy.Builder{}, err
+	}
+	patchArgs, err := cmd.Flags().GetStringArray("patch")
+	if err != nil {
+		return xcaddy.Builder{}, fmt.Errorf("unable to parse --patch arguments: %s", err.Error())
+	}
+	for _, patchArg := range patchArgs {
+		patch, err := parsePatch(patchArg)
+		if err != nil {
+			return xcaddy.Builder{}, err
+		}
+		patches = append(patches, patch)
+	}
+
+	excludeArgs, err := cmd.Flags().GetStringArray("exclude")
+	if err != nil {
+		return xcaddy.Builder{}, fmt.Errorf("unable to parse --exclude arguments: %s", err.Error())
+	}
+	for _, excludeArg := range excludeArgs {
+		mod, ver, repl, err := splitWith(excludeArg)
+		if err != nil {
+			return xcaddy.Builder{}, err
+		}
+		if ver == "" || repl != "" {
+			return xcaddy.Builder{}, fmt.Errorf("exclude must be of the form module@version: %s", excludeArg)
+		}
+		excludes = append(excludes, xcaddy.Dependency{
+			PackagePath: mod,
+			Version:     ver,
+		})
+	}
+
+	flagCaddyVersion, err := cmd.Flags().GetString("caddy")
+	if err != nil {
+		return xcaddy.Builder{}, fmt.Errorf("unable to parse --caddy arguments: %s", err.Error())
+	}
+	if argCaddyVersion != "" && flagCaddyVersion != "" && argCaddyVersion != flagCaddyVersion {
+		return xcaddy.Builder{}, fmt.Errorf("conflicting Caddy versions given as argument (%s) and with --caddy (%s)", argCaddyVersion, flagCaddyVersion)
+	}
+
+	// prefer caddy version from command line over env var
+	version := caddyVersion
+	if argCaddyVersion != "" {
+		version = argCaddyVersion
+	} else if flagCaddyVersion != "" {
+		version = flagCaddyVersion
+	}
+
+	builder := xcaddy.Builder{
+		Compile: xcaddy.Compile{
+			Cgo: os.Getenv("CGO_ENABLED") == "1",
+		},
+		CaddyVersion:     version,
+		Plugins:          plugins,
+		Replacements:     replacements,
+		Patches:          patches,
+		Excludes:         excludes,
+		Requires:         requires,
+		RaceDetector:     raceDetector,
+		SkipBuild:        skipBuild,
+		SkipCleanup:      skipCleanup,
+		Debug:            buildDebugOutput,
+		CaddyGitFallback: caddyGitFallback,
+		CaddyRepository:  caddyRepository,
+		SkipPreflight:    skipPreflight,
+		BuildFlags:       buildFlags,
+		ModFlags:         modFlags,
+	}
+	builder.EmbedDirs = parseEmbedDirs(embedDir)
+	builder.EmbedMaxSize = embedMaxSize
+	builder.IgnoreGoFlags = ignoreGoFlags
+	builder.GoVersion = goVersion
+	builder.Defines = defines
+	builder.Bare = bare
+	builder.Without = without
+	builder.MainPreset = mainPreset
+	builder.MainDefaults = xcaddy.MainDefaults{
+		Config:  defaultConfig,
+		Adapter: defaultAdapter,
+		Env:     defaultEnv,
+	}
+	builder.BuildMode = buildMode
+	builder.Sandbox = sandbox
+	builder.Netrc = netrc
+	builder.GoAuth = goAuth
+	builder.Proxy = proxy
+	builder.NoProxy = noProxy
+	builder.CACert = caCert
+	builder.ClientCert = clientCert
+	builder.ClientKey = clientKey
+	builder.Insecure = insecureModules
+	builder.ModuleProxies = moduleProxies
+	builder.ReplaceRoots = replaceRoots
+	builder.ReplaceFrom = replaceFrom
+	builder.ModCache = modCache
+	builder.Offline = offline
+	builder.TimeoutGet = timeoutGet
+	builder.ResolveAmbiguities = resolveAmbiguities
+	builder.TimeoutBuild, builder.TimeoutCompile, builder.TimeoutTotal, err = timeoutsFromFlags(cmd)
+	if err != nil {
+		return xcaddy.Builder{}, err
+	}
+	return builder, nil
+}
+
+// timeoutsFromFlags returns the values of --timeout-build,
+// --timeout-compile and --timeout-total for Builder.TimeoutBuild,
+// Builder.TimeoutCompile and Builder.TimeoutTotal.
+func timeoutsFromFlags(cmd *cobra.Command) (build, compile, total time.Duration, err error) {
+	build, err = cmd.Flags().GetDuration("timeout-build")
+	if err != nil {
+		return 0, 0, 0, fmt.Errorf("unable to parse --timeout-build arguments: %s", err.Error())
+	}
+	compile, err = cmd.Flags().GetDuration("timeout-compile")
+	if err != nil {
+		return 0, 0, 0, fmt.Errorf("unable to parse --timeout-compile arguments: %s", err.Error())
+	}
+	total, err = cmd.Flags().GetDuration("timeout-total")
+	if err != nil {
+		return 0, 0, 0, fmt.Errorf("unable to parse --timeout-total arguments: %s", err.Error())
+	}
+	return build, compile, total, nil
+}
+
+// embedMaxSizeFromFlags returns the value of --embed-max-size
+// for Builder.EmbedMaxSize.
+func embedMaxSizeFromFlags(cmd *cobra.Command) (int64, error) {
+	arg, err := cmd.Flags().GetString("embed-max-size")
+	if err != nil {
+		return 0, fmt.Errorf("unable to parse --embed-max-size arguments: %s", err.Error())
+	}
+	switch arg {
+	case "":
+		return 0, nil
+	case "unlimited":
+		return -1, nil
+	}
+	size, err := parseSize(arg)
+	if err != nil {
+		return 0, fmt.Errorf("invalid --embed-max-size: %v", err)
+	}
+	return size, nil
+}
+
+// sizeUnits are the units parseSize accepts, from the longest suffix.
+var sizeUnits = []struct {
+	suffix string
+	bytes  int64
+}{
+	{"KiB", 1 << 10}, {"MiB", 1 << 20}, {"GiB", 1 << 30}, {"TiB", 1 << 40},
+	{"KB", 1e3}, {"MB", 1e6}, {"GB", 1e9}, {"TB", 1e12},
+	{"B", 1},
+}
+
+// parseSize parses a positive size in bytes, optionally with
+// a decimal or binary unit, like 500MB, 1.5GiB or 1024.
+func parseSize(s string) (int64, error) {
+	number, unit := s, int64(1)
+	for _, u := range sizeUnits {
+		if strings.HasSuffix(s, u.suffix) {
+			number, unit = strings.TrimSpace(strings.TrimSuffix(s, u.suffix)), u.bytes
+			break
+		}
+	}
+	value, err := strconv.ParseFloat(number, 64)
+	if err != nil || value <= 0 {
+		return 0, fmt.Errorf("'%s' is not a size like 500MB or 2GiB", s)
+	}
+	return int64(value * float64(unit)), nil
+}
+
+// parseEmbedDirs parses the arguments of --embed.
+func parseEmbedDirs(embedArgs []string) []struct {
+	Dir  string `json:"dir,omitempty"`
+	Name string `json:"name,omitempty"`
+} {
+	var embedDirs []struct {
+		Dir  string `json:"dir,omitempty"`
+		Name string `json:"name,omitempty"`
+	}
+	for _, md := range embedArgs {
+		if before, after, found := strings.Cut(md, ":"); found {
+			embedDirs = append(embedDirs, struct {
+				Dir  string `json:"dir,omitempty"`
+				Name string `json:"name,omitempty"`
+			}{
+				after, before,
+			})
+		} else {
+			embedDirs = append(embedDirs, struct {
+				Dir  string `json:"dir,omitempty"`
+				Name string `json:"name,omitempty"`
+			}{
+				before, "",
+			})
+		}
+	}
+	return embedDirs
+}
+
+// recordedArgs returns args as they are recorded along with a build,
+// in the binary, its attestation and the history: the values of the
+// flags in redactedFlags are replaced by redactedValue, and URLs,
+// like those of --proxy, --module-proxy or the repositories of --with,
+// are recorded without the credentials they may contain.
+func recordedArgs(args []string) []string {
+	recorded := make([]string, len(args))
+	copy(recorded, args)
+	for i := 0; i < len(recorded); i++ {
+		arg := recorded[i]
+		flag, value, hasValue := strings.Cut(arg, "=")
+		switch {
+		case redactedFlags[flag] && hasValue:
+			recorded[i] = flag + "=" + redactedValue
+		case redactedFlags[flag] && i+1 < len(recorded):
+			recorded[i+1] = redactedValue
+			i++
+		case (flag == "--proxy" || flag == "--no-proxy") && hasValue:
+			recorded[i] = flag + "=" + withoutUserinfo(value)
+		case (flag == "--proxy" || flag == "--no-proxy") && i+1 < len(recorded):
+			recorded[i+1] = withoutUserinfo(recorded[i+1])
+			i++
+		default:
+			recorded[i] = urlUserinfoRegexp.ReplaceAllString(arg, "$1")
+		}
+	}
+	return recorded
+}
+
+// redactedFlags are the flags whose values are not recorded
+// with a build, since they are or point to credentials.
+var redactedFlags = map[string]bool{
+	"--netrc":       true,
+	"--goauth":      true,
+	"--client-cert": true,
+	"--client-key":  true,
+	"--sign":        true,
+	"--default-env": true,
+}
+
+// redactedValue replaces the values of redactedFlags.
+const redactedValue = "REDACTED"
+
+// urlUserinfoRegexp matches the scheme and user
+// of a URL, like https://user:password@.
+var urlUserinfoRegexp = regexp.MustCompile(`([A-Za-z][A-Za-z0-9+.-]*://)[^/?#@\s]*@`)
+
+// withoutUserinfo returns the comma-separated list of URLs
+// or hosts in value, without the user and password of each.
+func withoutUserinfo(value string) string {
+	items := strings.Split(value, ",")
+	for i, item := range items {
+		u, err := url.Parse(item)
+		switch {
+		case err == nil && u.User != nil:
+			u.User = nil
+			items[i] = u.String()
+		case (err != nil || u.Host == "") && strings.Contains(item, "@"):
+			// hosts without a scheme, like user:pass@proxy:3128,
+			// don't parse as URLs with a user
+			items[i] = item[strings.LastIndex(item, "@")+1:]
+		}
+	}
+	return strings.Join(items, ",")
+}
+
+// writeBinary copies the binary at file to w.
+func writeBinary(w io.Writer, file string) error {
+	f, err := os.Open(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = io.Copy(w, f)
+	if err != nil {
+		return fmt.Errorf("writing binary: %v", err)
+	}
+	return nil
+}
+
+func handleReplace(orig, mod, ver, repl string, replacements *[]xcaddy.Replace) error {
+	if repl != "" {
+		// adjust local replacements to absolute paths since our temporary module is in a different directory
+		resolved, err := resolveReplacementPath(repl)
+		if err != nil {
+			return fmt.Errorf("replacement %s: %v", orig, err)
+		}
+		if resolved != repl {
+			log.Printf("[INFO] Resolved replacement %s to %s", orig, resolved)
+		}
+		*replacements = append(*replacements, xcaddy.NewReplace(xcaddy.Dependency{PackagePath: mod, Version: ver}.String(), resolved))
 	}
+	return nil
 }
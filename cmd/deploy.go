@@ -0,0 +1,132 @@
+package xcaddycmd
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// sshCommand is the command which connects to the hosts to deploy to.
+var sshCommand = "ssh"
+
+// defaultDeployPath is where the binary is installed on a host
+// if the target doesn't say, as in the official packages.
+const defaultDeployPath = "/usr/bin/caddy"
+
+// defaultDeployRestart is the command which restarts Caddy on
+// a host, if installed as a service like the official packages.
+const defaultDeployRestart = "systemctl restart caddy"
+
+// deployTarget is a host to deploy a built binary to, over SSH.
+type deployTarget struct {
+	// The destination of ssh, like caddy@web1.example.com.
+	Host string
+
+	// If set, the port of ssh on the host.
+	Port string
+
+	// The path of the binary on the host, which is replaced.
+	Path string
+
+	// The command run on the host to restart Caddy
+	// after the binary was replaced.
+	Restart string
+
+	// If set, the URL of the admin API of Caddy on the host,
+	// like http://web1.example.com:2019, which must respond
+	// after the restart for the deployment to succeed.
+	Admin string
+}
+
+// parseDeployTarget parses a target of the form [user@]host[:path].
+// URLs, like that of the admin API, are rejected, since they would
+// parse as a host named after the scheme, and so are ports, which
+// would be taken as the path of the binary.
+func parseDeployTarget(s string) (deployTarget, error) {
+	host, path, _ := strings.Cut(s, ":")
+	if host == "" || strings.HasPrefix(host, "-") {
+		return deployTarget{}, fmt.Errorf("deploy target must be of the form [user@]host[:path]: %s", s)
+	}
+	if strings.HasPrefix(path, "//") {
+		return deployTarget{}, fmt.Errorf("deploy target must be of the form [user@]host[:path], not a URL: %s; Caddy is deployed over ssh, and the URL of its admin API goes in --deploy-admin", s)
+	}
+	if _, err := strconv.Atoi(path); err == nil {
+		return deployTarget{}, fmt.Errorf("deploy target must be of the form [user@]host[:path], where path is that of the binary, not a port: %s", s)
+	}
+	if path == "" {
+		path = defaultDeployPath
+	}
+	return deployTarget{Host: host, Path: path}, nil
+}
+
+// deploy copies binary to the host of t, replaces the binary there
+// and restarts Caddy with it. The new binary is written next to the
+// old one and moved over it, so the running process is unaffected
+// until the restart and a failed copy leaves the old binary in place.
+func deploy(ctx context.Context, stdout io.Writer, binary string, t deployTarget) error {
+	f, err := os.Open(binary)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	newPath := shellQuote(t.Path + ".xcaddy-new")
+	script := fmt.Sprintf("cat > %s && chmod 755 %s && mv -f %s %s", newPath, newPath, newPath, shellQuote(t.Path))
+	if t.Restart != "" {
+		script += " && " + t.Restart
+	}
+	log.Printf("[INFO] Deploying %s to %s:%s", binary, t.Host, t.Path)
+	var args []string
+	if t.Port != "" {
+		args = append(args, "-p", t.Port)
+	}
+	cmd := exec.CommandContext(ctx, sshCommand, append(args, "--", t.Host, script)...)
+	cmd.Stdin = f
+	cmd.Stdout = stdout
+	cmd.Stderr = os.Stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("deploying to %s: %v", t.Host, err)
+	}
+
+	if t.Admin == "" {
+		return nil
+	}
+	return waitForAdmin(ctx, t.Admin, 30*time.Second)
+}
+
+// waitForAdmin waits until the admin API at adminURL responds
+// successfully to a request of the config, or timeout expires.
+func waitForAdmin(ctx context.Context, adminURL string, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	configURL := strings.TrimSuffix(adminURL, "/") + "/config/"
+	log.Printf("[INFO] Waiting for the admin API at %s", adminURL)
+	var lastErr error
+	for {
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, configURL, nil)
+		if err != nil {
+			return err
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err == nil {
+			resp.Body.Close()
+			if resp.StatusCode == http.StatusOK {
+				return nil
+			}
+			err = fmt.Errorf("%s", resp.Status)
+		}
+		lastErr = err
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("admin API at %s did not respond after the restart: %v", adminURL, lastErr)
+		case <-time.After(time.Second):
+		}
+	}
+}
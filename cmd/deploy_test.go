@@ -0,0 +1,102 @@
+package xcaddycmd
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func Test_parseDeployTarget(t *testing.T) {
+	tests := []struct {
+		target  string
+		want    deployTarget
+		wantErr bool
+	}{
+		{target: "web1.example.com", want: deployTarget{Host: "web1.example.com", Path: "/usr/bin/caddy"}},
+		{target: "root@web1.example.com:/opt/caddy/bin/caddy", want: deployTarget{Host: "root@web1.example.com", Path: "/opt/caddy/bin/caddy"}},
+		{target: ":/usr/bin/caddy", wantErr: true},
+		{target: "-oProxyCommand=sh", wantErr: true},
+		{target: "http://web1.example.com:2019", wantErr: true},
+		{target: "ssh://root@web1.example.com", wantErr: true},
+		{target: "web1.example.com:22", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.target, func(t *testing.T) {
+			got, err := parseDeployTarget(tt.target)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parseDeployTarget() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("parseDeployTarget() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_deploy(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses a shell script as the ssh command")
+	}
+	dir := t.TempDir()
+	binary := filepath.Join(dir, "caddy")
+	if err := os.WriteFile(binary, []byte("new caddy"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	// the fake ssh runs the script locally, like on the host
+	fakeSSH := filepath.Join(dir, "ssh")
+	if err := os.WriteFile(fakeSSH, []byte("#!/bin/sh\nshift 2\nexec sh -c \"$1\"\n"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	defer func(cmd string) { sshCommand = cmd }(sshCommand)
+	sshCommand = fakeSSH
+
+	installed := filepath.Join(dir, "bin", "caddy")
+	if err := os.MkdirAll(filepath.Dir(installed), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(installed, []byte("old caddy"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	restarted := filepath.Join(dir, "restarted")
+	admin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/config/" {
+			http.NotFound(w, r)
+		}
+	}))
+	defer admin.Close()
+
+	target := deployTarget{Host: "web1", Path: installed, Restart: "touch " + shellQuote(restarted), Admin: admin.URL}
+	if err := deploy(context.Background(), io.Discard, binary, target); err != nil {
+		t.Fatalf("deploy() error = %v", err)
+	}
+	if got, err := os.ReadFile(installed); err != nil || string(got) != "new caddy" {
+		t.Errorf("installed binary = %q, %v, want the new one", got, err)
+	}
+	if _, err := os.Stat(installed + ".xcaddy-new"); !os.IsNotExist(err) {
+		t.Errorf("the new binary was left next to the installed one: %v", err)
+	}
+	if _, err := os.Stat(restarted); err != nil {
+		t.Errorf("the restart command was not run: %v", err)
+	}
+
+	target.Restart = "false"
+	if err := deploy(context.Background(), io.Discard, binary, target); err == nil {
+		t.Error("deploy() with a failing restart succeeded, want error")
+	}
+}
+
+func Test_waitForAdmin(t *testing.T) {
+	admin := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "not ready", http.StatusServiceUnavailable)
+	}))
+	defer admin.Close()
+	if err := waitForAdmin(context.Background(), admin.URL, 1500*time.Millisecond); err == nil {
+		t.Error("waitForAdmin() of an unavailable admin API succeeded, want error")
+	}
+}
@@ -0,0 +1,148 @@
+package xcaddycmd
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/caddyserver/xcaddy"
+	"github.com/caddyserver/xcaddy/internal/utils"
+)
+
+// buildDev builds Caddy with builder to output for the development of
+// the plugin at importPath, whose source and that of the other local
+// replacements is in dirs. The last build is reused if nothing changed
+// since, unless the cache is disabled or the build is skipped.
+func buildDev(ctx context.Context, builder xcaddy.Builder, importPath string, dirs []string, output string) error {
+	if builder.SkipBuild || utils.CacheDisabled(ctx) {
+		return builder.Build(ctx, output)
+	}
+	cacheDir, err := devCacheFolder(importPath)
+	if err != nil {
+		log.Printf("[WARNING] %v", err)
+		return builder.Build(ctx, output)
+	}
+	key, err := devBuildKey(builder, dirs)
+	if err != nil {
+		return err
+	}
+	return buildWithDevCache(cacheDir, key, output, func(output string) error {
+		return builder.Build(ctx, output)
+	})
+}
+
+// devBuildKey returns a hash of everything which goes into a build
+// by builder for development: its settings, the platform, the go
+// command and the content of the source files in dirs, which include
+// go.sum. Caddy is only rebuilt in development mode if it changed.
+func devBuildKey(builder xcaddy.Builder, dirs []string) (string, error) {
+	h := sha256.New()
+	settings, err := json.Marshal(builder)
+	if err != nil {
+		return "", err
+	}
+	fmt.Fprintf(h, "builder %s\n", settings)
+	for _, env := range []string{"GOOS", "GOARCH", "GOARM", "GOFLAGS", "GOEXPERIMENT", "XCADDY_GO_BUILD_FLAGS"} {
+		fmt.Fprintf(h, "env %s=%s\n", env, os.Getenv(env))
+	}
+	goVersion, err := exec.Command(utils.GetGo(), "env", "GOVERSION").Output()
+	if err != nil {
+		return "", fmt.Errorf("finding the Go version: %v", err)
+	}
+	fmt.Fprintf(h, "go %s\n", strings.TrimSpace(string(goVersion)))
+
+	files, err := sourceFiles(dirs)
+	if err != nil {
+		return "", err
+	}
+	for _, file := range files {
+		digest, err := fileDigest(file)
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(h, "file %s %s\n", file, digest)
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// devCacheFolder returns the folder in which the last binary
+// built for the development of the plugin at importPath is kept.
+func devCacheFolder(importPath string) (string, error) {
+	cacheDir, err := os.UserCacheDir()
+	if err != nil {
+		return "", fmt.Errorf("finding folder for development builds: %v", err)
+	}
+	sum := sha256.Sum256([]byte(importPath))
+	return filepath.Join(cacheDir, "xcaddy", "dev", hex.EncodeToString(sum[:16])), nil
+}
+
+// buildWithDevCache copies the binary kept in cacheDir to output if
+// it was built with key, or else builds it with build and keeps a
+// copy with key, replacing the previous one. Errors of the cache
+// itself are only logged, since it only saves time.
+func buildWithDevCache(cacheDir, key, output string, build func(string) error) error {
+	cached := filepath.Join(cacheDir, "caddy")
+	keyFile := filepath.Join(cacheDir, "key")
+	if lastKey, err := os.ReadFile(keyFile); err == nil && string(lastKey) == key {
+		err = copyBinary(cached, output)
+		if err == nil {
+			log.Printf("[INFO] Source and settings unchanged since the last build; reusing it")
+			return nil
+		}
+		log.Printf("[WARNING] Reusing the last build: %v", err)
+	}
+
+	err := build(output)
+	if err != nil {
+		return err
+	}
+	// the key is removed first, so the binary is
+	// never taken for one built with another key
+	os.Remove(keyFile)
+	err = os.MkdirAll(cacheDir, 0o755)
+	if err == nil {
+		err = copyBinary(output, cached)
+	}
+	if err == nil {
+		err = os.WriteFile(keyFile, []byte(key), 0o644)
+	}
+	if err != nil {
+		log.Printf("[WARNING] Keeping the build for reuse: %v", err)
+	}
+	return nil
+}
+
+// copyBinary copies the executable src to dst, replacing it.
+func copyBinary(src, dst string) error {
+	in, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer in.Close()
+	tmp, err := os.CreateTemp(filepath.Dir(dst), ".xcaddy-*")
+	if err != nil {
+		return err
+	}
+	_, err = io.Copy(tmp, in)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Chmod(tmp.Name(), 0o755)
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), dst)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+	return err
+}
@@ -0,0 +1,82 @@
+package xcaddycmd
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/caddyserver/xcaddy"
+)
+
+func TestDevBuildKey(t *testing.T) {
+	dir := t.TempDir()
+	source := filepath.Join(dir, "plugin.go")
+	if err := os.WriteFile(source, []byte("package plugin\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	builder := xcaddy.Builder{Plugins: []xcaddy.Dependency{{PackagePath: "example.com/plugin"}}}
+	key := func(b xcaddy.Builder) string {
+		t.Helper()
+		k, err := devBuildKey(b, []string{dir})
+		if err != nil {
+			t.Fatal(err)
+		}
+		return k
+	}
+
+	initial := key(builder)
+	later := time.Now().Add(time.Minute)
+	if err := os.Chtimes(source, later, later); err != nil {
+		t.Fatal(err)
+	}
+	if k := key(builder); k != initial {
+		t.Error("key changed after touching a source file without changing it")
+	}
+	race := builder
+	race.RaceDetector = true
+	if k := key(race); k == initial {
+		t.Error("key did not change with the race detector enabled")
+	}
+	if err := os.WriteFile(source, []byte("package plugin // changed\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if k := key(builder); k == initial {
+		t.Error("key did not change after changing a source file")
+	}
+}
+
+func TestBuildWithDevCache(t *testing.T) {
+	cacheDir := filepath.Join(t.TempDir(), "cache")
+	output := filepath.Join(t.TempDir(), "caddy")
+	var builds int
+	build := func(output string) error {
+		builds++
+		return os.WriteFile(output, []byte{byte(builds)}, 0o755)
+	}
+	check := func(key string, wantBuilds int, wantContent byte) {
+		t.Helper()
+		if err := os.Remove(output); err != nil && !os.IsNotExist(err) {
+			t.Fatal(err)
+		}
+		if err := buildWithDevCache(cacheDir, key, output, build); err != nil {
+			t.Fatal(err)
+		}
+		if builds != wantBuilds {
+			t.Errorf("built %d times, want %d", builds, wantBuilds)
+		}
+		content, err := os.ReadFile(output)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(content) != 1 || content[0] != wantContent {
+			t.Errorf("output is %v, want the binary of build %d", content, wantContent)
+		}
+	}
+
+	check("a", 1, 1)
+	check("a", 1, 1)
+	check("b", 2, 2)
+	check("b", 2, 2)
+	check("a", 3, 3)
+}
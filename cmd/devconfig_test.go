@@ -0,0 +1,68 @@
+package xcaddycmd
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestDevAdminURL(t *testing.T) {
+	for _, tc := range []struct {
+		env  string
+		want string
+	}{
+		{env: "", want: "http://localhost:2019"},
+		{env: ":2020", want: "http://localhost:2020"},
+		{env: "127.0.0.1:2021", want: "http://127.0.0.1:2021"},
+	} {
+		t.Setenv("CADDY_ADMIN", tc.env)
+		if got := devAdminURL(); got != tc.want {
+			t.Errorf("devAdminURL() with CADDY_ADMIN=%q = %q, want %q", tc.env, got, tc.want)
+		}
+	}
+}
+
+func TestFetchAndLoadConfig(t *testing.T) {
+	config := "null"
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodGet && r.URL.Path == "/config/":
+			io.WriteString(w, config+"\n")
+		case r.Method == http.MethodPost && r.URL.Path == "/load" && r.Header.Get("Content-Type") == "application/json":
+			body, _ := io.ReadAll(r.Body)
+			if string(body) == "invalid" {
+				http.Error(w, "loading config: invalid", http.StatusBadRequest)
+				return
+			}
+			config = string(body)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+	ctx := context.Background()
+
+	got, err := fetchConfig(ctx, srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != nil {
+		t.Errorf("fetchConfig() without a config = %q, want nil", got)
+	}
+	want := `{"apps":{"http":{}}}`
+	if err := loadConfig(ctx, srv.URL, []byte(want)); err != nil {
+		t.Fatal(err)
+	}
+	got, err = fetchConfig(ctx, srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(got) != want {
+		t.Errorf("fetchConfig() = %q, want %q", got, want)
+	}
+	if err := loadConfig(ctx, srv.URL, []byte("invalid")); err == nil {
+		t.Error("loadConfig() of an invalid config = nil, want an error")
+	}
+}
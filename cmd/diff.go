@@ -0,0 +1,226 @@
+package xcaddycmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"runtime/debug"
+
+	"github.com/caddyserver/xcaddy"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	diffCommand.Flags().Bool("changelog", false, "prints the changes as a changelog in Markdown, with links to release notes")
+}
+
+var diffCommand = &cobra.Command{
+	Use:   "diff [--changelog] <binary|manifest> <binary|manifest>",
+	Short: "Shows what changes between two builds",
+	Long: `
+Compares two Caddy builds and prints the modules that were added, removed or
+changed in version, going from the first to the second build. Each build is
+either a Caddy binary or a manifest, which is a JSON file with the fields of
+xcaddy.Builder, like {"caddy_version": "v2.8.4", "plugins": [...]}, or a YAML
+file with the same fields if it is named like xcaddy.yaml.
+
+If both builds are binaries, every module is compared; otherwise only Caddy
+and the plugins are, since a manifest doesn't know about other dependencies.
+
+Flags:
+ --changelog prints the changes as a changelog in Markdown, like for the
+ description of a pull request which updates a build. If both builds are
+ binaries, the changes of modules on GitHub link to the release notes of the
+ new version and to the commits between the versions.
+`,
+	Args: cobra.ExactArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		changelog, err := cmd.Flags().GetBool("changelog")
+		if err != nil {
+			return fmt.Errorf("unable to parse --changelog arguments: %s", err.Error())
+		}
+		from, err := readBuildSummary(args[0])
+		if err != nil {
+			return err
+		}
+		to, err := readBuildSummary(args[1])
+		if err != nil {
+			return err
+		}
+		changes := diffBuilds(from, to)
+		if changelog {
+			writeChangelog(os.Stdout, changes, from.Modules != nil && to.Modules != nil)
+			return nil
+		}
+		printDiff(os.Stdout, changes)
+		return nil
+	},
+}
+
+// buildSummary is what is known about the modules of a build.
+type buildSummary struct {
+	CaddyVersion string
+
+	// Plugins maps the package path of each
+	// plugin to the version of its module.
+	Plugins map[string]string
+
+	// Modules maps the path of every module to its
+	// version, including Caddy; nil for manifests.
+	Modules map[string]string
+
+	// PluginModules are the paths of the modules
+	// containing plugins; nil for manifests.
+	PluginModules map[string]bool
+}
+
+// readBuildSummary summarizes the binary or manifest in file.
+func readBuildSummary(file string) (buildSummary, error) {
+	bi, inv, binErr := readBinary(file)
+	if binErr == nil {
+		return summarizeBinary(bi, inv), nil
+	}
+	manifest, err := xcaddy.ReadManifest(file)
+	if err != nil {
+		return buildSummary{}, fmt.Errorf("%s is neither a Go binary (%v) nor a manifest (%v)", file, binErr, err)
+	}
+	return summarizeManifest(manifest), nil
+}
+
+func summarizeBinary(bi *debug.BuildInfo, inv *xcaddy.Invocation) buildSummary {
+	summary := buildSummary{
+		Plugins:       make(map[string]string),
+		Modules:       make(map[string]string),
+		PluginModules: make(map[string]bool),
+	}
+	for _, dep := range bi.Deps {
+		summary.Modules[dep.Path] = moduleVersion(dep)
+		if dep.Path == caddyModulePath {
+			summary.CaddyVersion = moduleVersion(dep)
+		}
+	}
+	if inv != nil {
+		for _, p := range inv.Plugins {
+			if mod := moduleOf(p.PackagePath, bi.Deps); mod != nil {
+				summary.Plugins[p.PackagePath] = moduleVersion(mod)
+				summary.PluginModules[mod.Path] = true
+			}
+		}
+	}
+	return summary
+}
+
+// moduleVersion returns the version of mod, including
+// what it is replaced with, if anything.
+func moduleVersion(mod *debug.Module) string {
+	if mod.Replace == nil {
+		return mod.Version
+	}
+	return mod.Version + " => " + xcaddy.Dependency{PackagePath: mod.Replace.Path, Version: mod.Replace.Version}.String()
+}
+
+func summarizeManifest(manifest xcaddy.Builder) buildSummary {
+	summary := buildSummary{
+		CaddyVersion: manifest.CaddyVersion,
+		Plugins:      make(map[string]string),
+	}
+	if summary.CaddyVersion == "" {
+		summary.CaddyVersion = "latest"
+	}
+	for _, p := range manifest.Plugins {
+		version := p.Version
+		if version == "" {
+			version = "latest"
+		}
+		summary.Plugins[p.PackagePath] = version
+	}
+	return summary
+}
+
+const caddyModulePath = "github.com/caddyserver/caddy/v2"
+
+// moduleChange is a difference between two builds. Either
+// version is empty if the module was added or removed.
+type moduleChange struct {
+	Path      string
+	From, To  string
+	IsPlugin  bool
+	IsAdded   bool
+	IsRemoved bool
+}
+
+// diffBuilds returns the changes going from one build to another,
+// sorted by path.
+func diffBuilds(from, to buildSummary) []moduleChange {
+	fromVersions, toVersions := from.Modules, to.Modules
+	if fromVersions == nil || toVersions == nil {
+		fromVersions, toVersions = from.pluginsAndCaddy(), to.pluginsAndCaddy()
+	}
+
+	paths := make(map[string]bool)
+	for p := range fromVersions {
+		paths[p] = true
+	}
+	for p := range toVersions {
+		paths[p] = true
+	}
+
+	var changes []moduleChange
+	for _, p := range sortedKeys(paths) {
+		fromVersion, inFrom := fromVersions[p]
+		toVersion, inTo := toVersions[p]
+		if inFrom && inTo && fromVersion == toVersion {
+			continue
+		}
+		changes = append(changes, moduleChange{
+			Path:      p,
+			From:      fromVersion,
+			To:        toVersion,
+			IsPlugin:  from.isPlugin(p) || to.isPlugin(p),
+			IsAdded:   !inFrom,
+			IsRemoved: !inTo,
+		})
+	}
+	return changes
+}
+
+// isPlugin returns true if path is the package path of a
+// plugin or the path of a module which contains one.
+func (s buildSummary) isPlugin(path string) bool {
+	_, ok := s.Plugins[path]
+	return ok || s.PluginModules[path]
+}
+
+// pluginsAndCaddy returns the plugins of a build
+// along with Caddy itself, if it is known.
+func (s buildSummary) pluginsAndCaddy() map[string]string {
+	versions := make(map[string]string)
+	for p, v := range s.Plugins {
+		versions[p] = v
+	}
+	if s.CaddyVersion != "" {
+		versions[caddyModulePath] = s.CaddyVersion
+	}
+	return versions
+}
+
+func printDiff(out io.Writer, changes []moduleChange) {
+	if len(changes) == 0 {
+		fmt.Fprintln(out, "No differences")
+		return
+	}
+	for _, c := range changes {
+		var plugin string
+		if c.IsPlugin {
+			plugin = " (plugin)"
+		}
+		switch {
+		case c.IsAdded:
+			fmt.Fprintf(out, "+ %s %s%s\n", c.Path, c.To, plugin)
+		case c.IsRemoved:
+			fmt.Fprintf(out, "- %s %s%s\n", c.Path, c.From, plugin)
+		default:
+			fmt.Fprintf(out, "~ %s %s -> %s%s\n", c.Path, c.From, c.To, plugin)
+		}
+	}
+}
@@ -0,0 +1,120 @@
+package xcaddycmd
+
+import (
+	"bytes"
+	"reflect"
+	"runtime/debug"
+	"testing"
+
+	"github.com/caddyserver/xcaddy"
+)
+
+func TestDiffBuilds(t *testing.T) {
+	deployed := summarizeBinary(&debug.BuildInfo{
+		Deps: []*debug.Module{
+			{Path: "github.com/caddyserver/caddy/v2", Version: "v2.8.4"},
+			{Path: "github.com/caddy-dns/cloudflare", Version: "v0.1.0"},
+			{Path: "github.com/mholt/caddy-l4", Version: "v0.0.0-20240812213304-afa78d72257b"},
+			{Path: "golang.org/x/net", Version: "v0.28.0"},
+		},
+	}, &xcaddy.Invocation{
+		Plugins: []xcaddy.Dependency{
+			{PackagePath: "github.com/caddy-dns/cloudflare"},
+			{PackagePath: "github.com/mholt/caddy-l4"},
+		},
+	})
+	proposed := summarizeBinary(&debug.BuildInfo{
+		Deps: []*debug.Module{
+			{Path: "github.com/caddyserver/caddy/v2", Version: "v2.9.0"},
+			{Path: "github.com/caddy-dns/cloudflare", Version: "v0.1.0", Replace: &debug.Module{Path: "../cloudflare"}},
+			{Path: "github.com/mholt/caddy-ratelimit", Version: "v0.1.0"},
+			{Path: "golang.org/x/net", Version: "v0.28.0"},
+		},
+	}, &xcaddy.Invocation{
+		Plugins: []xcaddy.Dependency{
+			{PackagePath: "github.com/caddy-dns/cloudflare"},
+			{PackagePath: "github.com/mholt/caddy-ratelimit"},
+		},
+	})
+	manifest := summarizeManifest(xcaddy.Builder{
+		CaddyVersion: "v2.9.0",
+		Plugins: []xcaddy.Dependency{
+			{PackagePath: "github.com/caddy-dns/cloudflare", Version: "v0.1.0"},
+			{PackagePath: "github.com/mholt/caddy-ratelimit"},
+		},
+	})
+
+	tests := []struct {
+		name     string
+		from, to buildSummary
+		want     []moduleChange
+	}{
+		{
+			name: "binaries",
+			from: deployed,
+			to:   proposed,
+			want: []moduleChange{
+				{Path: "github.com/caddy-dns/cloudflare", From: "v0.1.0", To: "v0.1.0 => ../cloudflare", IsPlugin: true},
+				{Path: "github.com/caddyserver/caddy/v2", From: "v2.8.4", To: "v2.9.0"},
+				{Path: "github.com/mholt/caddy-l4", From: "v0.0.0-20240812213304-afa78d72257b", IsPlugin: true, IsRemoved: true},
+				{Path: "github.com/mholt/caddy-ratelimit", To: "v0.1.0", IsPlugin: true, IsAdded: true},
+			},
+		},
+		{
+			name: "binary to manifest",
+			from: deployed,
+			to:   manifest,
+			want: []moduleChange{
+				{Path: "github.com/caddyserver/caddy/v2", From: "v2.8.4", To: "v2.9.0"},
+				{Path: "github.com/mholt/caddy-l4", From: "v0.0.0-20240812213304-afa78d72257b", IsPlugin: true, IsRemoved: true},
+				{Path: "github.com/mholt/caddy-ratelimit", To: "latest", IsPlugin: true, IsAdded: true},
+			},
+		},
+		{
+			name: "same",
+			from: deployed,
+			to:   deployed,
+			want: nil,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := diffBuilds(tt.from, tt.to); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("diffBuilds() = %+v, want %+v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestWriteChangelog(t *testing.T) {
+	changes := []moduleChange{
+		{Path: "github.com/caddy-dns/cloudflare", From: "v0.1.0", To: "v0.1.0 => ../cloudflare", IsPlugin: true},
+		{Path: "github.com/caddyserver/caddy/v2", From: "v2.8.4", To: "v2.9.0"},
+		{Path: "github.com/mholt/caddy-l4", From: "v0.0.0-20240812213304-afa78d72257b", To: "v0.0.0-20241015155040-9a7c4f3a3f3c", IsPlugin: true},
+		{Path: "github.com/mholt/caddy-ratelimit", To: "v0.1.0", IsPlugin: true, IsAdded: true},
+		{Path: "go.opentelemetry.io/contrib/propagators/aws", From: "v1.17.0", To: "v1.29.0"},
+		{Path: "github.com/aws/aws-sdk-go-v2/service/route53", From: "v1.40.0", To: "v1.42.3"},
+		{Path: "golang.org/x/net", From: "v0.28.0", IsRemoved: true},
+	}
+	var buf bytes.Buffer
+	writeChangelog(&buf, changes, true)
+	want := "# Changes\n\n" +
+		"## Caddy and plugins\n\n" +
+		"- `github.com/caddy-dns/cloudflare`: v0.1.0 → v0.1.0 => ../cloudflare\n" +
+		"- `github.com/caddyserver/caddy/v2`: v2.8.4 → v2.9.0 ([release notes](https://github.com/caddyserver/caddy/releases/tag/v2.9.0), [commits](https://github.com/caddyserver/caddy/compare/v2.8.4...v2.9.0))\n" +
+		"- `github.com/mholt/caddy-l4`: v0.0.0-20240812213304-afa78d72257b → v0.0.0-20241015155040-9a7c4f3a3f3c ([commits](https://github.com/mholt/caddy-l4/compare/afa78d72257b...9a7c4f3a3f3c))\n" +
+		"- `github.com/mholt/caddy-ratelimit`: added at v0.1.0 ([release notes](https://github.com/mholt/caddy-ratelimit/releases/tag/v0.1.0))\n" +
+		"\n## Dependencies\n\n" +
+		"- `go.opentelemetry.io/contrib/propagators/aws`: v1.17.0 → v1.29.0\n" +
+		"- `github.com/aws/aws-sdk-go-v2/service/route53`: v1.40.0 → v1.42.3 ([release notes](https://github.com/aws/aws-sdk-go-v2/releases/tag/service/route53/v1.42.3), [commits](https://github.com/aws/aws-sdk-go-v2/compare/service/route53/v1.40.0...service/route53/v1.42.3))\n" +
+		"- `golang.org/x/net`: removed, was v0.28.0\n"
+	if buf.String() != want {
+		t.Errorf("writeChangelog() =\n%s\nwant:\n%s", buf.String(), want)
+	}
+
+	buf.Reset()
+	writeChangelog(&buf, changes[1:2], false)
+	if want := "# Changes\n\n## Caddy and plugins\n\n- `github.com/caddyserver/caddy/v2`: v2.8.4 → v2.9.0\n"; buf.String() != want {
+		t.Errorf("writeChangelog() without links =\n%s\nwant:\n%s", buf.String(), want)
+	}
+}
@@ -0,0 +1,332 @@
+package xcaddycmd
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+
+	"github.com/caddyserver/xcaddy"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	for _, cmd := range []*cobra.Command{envCreateCommand, envBuildCommand, envExecCommand, envDestroyCommand} {
+		cmd.Flags().String("name", "", "the name of the environment")
+		_ = cmd.MarkFlagRequired("name")
+	}
+	addBuilderFlags(envCreateCommand.Flags())
+	addBuilderFlags(execCommand.Flags())
+	envBuildCommand.Flags().String("output", "", "change the output file name")
+	envBuildCommand.Flags().StringArray("embed", []string{}, "embeds directories into the built Caddy executable, replacing those the environment was created with")
+	envBuildCommand.Flags().String("embed-max-size", "", "the maximum total size of the embedded directories, like 2GiB, or unlimited; defaults to 1GiB")
+	envBuildCommand.Flags().Bool("embed-gomod", false, "embeds a compressed copy of the final go.mod and go.sum into the built Caddy executable")
+	envBuildCommand.Flags().StringArray("define", []string{}, "defines build-time configuration for plugins, replacing the definitions the environment was created with")
+	addTimeoutFlags(envBuildCommand.Flags())
+	for _, cmd := range []*cobra.Command{envBuildCommand, envExecCommand} {
+		cmd.Flags().Bool("ignore-goflags", false, "ignores GOFLAGS from the environment for the go commands")
+		cmd.Flags().String("go-version", "", "the exact Go toolchain to use instead of the one the environment was created with")
+	}
+
+	envCommand.AddCommand(envCreateCommand)
+	envCommand.AddCommand(envBuildCommand)
+	envCommand.AddCommand(envExecCommand)
+	envCommand.AddCommand(envDestroyCommand)
+	envCommand.AddCommand(envListCommand)
+}
+
+var envCommand = &cobra.Command{
+	Use:   "env",
+	Short: "Manages reusable build environments",
+	Long: `
+A build environment is the Go module in which xcaddy builds Caddy. Normally it is
+created for a single build and removed afterwards. With these commands it can be
+created once and reused for many quick builds, for example while iterating on
+build flags or embedded files, without resolving modules again.
+
+Environments are kept in the xcaddy folder of the user's cache directory.
+`,
+}
+
+var envCreateCommand = &cobra.Command{
+	Use: `create --name <name> [<caddy_version>]
+    [<flags of the build command>...]`,
+	Short: "Creates a reusable build environment",
+	Long: `
+Creates a build environment with the given name, configured like the build
+command is: with a Caddy version and the --with, --replace, --patch and other
+flags. Nothing is built yet.
+`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		dir, err := envFolder(cmd)
+		if err != nil {
+			return err
+		}
+		if _, err := os.Stat(dir); err == nil {
+			return fmt.Errorf("environment already exists: %s", dir)
+		}
+		builder, err := builderFromFlags(cmd, args)
+		if err != nil {
+			return err
+		}
+		return builder.PrepareEnvironment(cmd.Root().Context(), dir)
+	},
+}
+
+var envBuildCommand = &cobra.Command{
+	Use: `build --name <name>
+    [--output <file>]
+    [--embed <[alias]:path/to/dir>...]
+    [--embed-max-size <size>]
+    [--embed-gomod]
+    [--define <key=value>...]
+    [--ignore-goflags]
+    [--go-version <version>]
+    [--timeout-build <duration>]
+    [--timeout-compile <duration>]
+    [--timeout-total <duration>]`,
+	Short: "Builds Caddy in a reusable build environment",
+	Long: `
+Builds Caddy in the environment with the given name, with the Caddy version and
+plugins it was created with. Build settings are taken from the environment
+variables as usual, like XCADDY_GO_BUILD_FLAGS, so they can be changed between
+builds.
+
+Flags:
+ --output changes the output file, which may be a template like with the build command.
+
+ --embed embeds directories, like with the build command. If given, they replace
+ the directories the environment was created with.
+
+ --embed-max-size sets the maximum total size of the embedded directories, like
+ with the build command.
+
+ --embed-gomod embeds a compressed copy of the final go.mod and go.sum.
+
+ --define defines build-time configuration for plugins, like with the build
+ command. If given, the definitions replace those the environment was created with.
+
+ --ignore-goflags ignores GOFLAGS from the environment, like with the build command.
+
+ --go-version builds with exactly this Go toolchain, like with the build command.
+ Defaults to the toolchain the environment was created with, if any.
+
+ --timeout-build, --timeout-compile and --timeout-total limit the time for the
+ build, for compiling Caddy and for the whole command, like with the build command.
+`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		dir, err := envFolder(cmd)
+		if err != nil {
+			return err
+		}
+		output, err := cmd.Flags().GetString("output")
+		if err != nil {
+			return fmt.Errorf("unable to parse --output arguments: %s", err.Error())
+		}
+		embedDir, err := cmd.Flags().GetStringArray("embed")
+		if err != nil {
+			return fmt.Errorf("unable to parse --embed arguments: %s", err.Error())
+		}
+		embedMaxSize, err := embedMaxSizeFromFlags(cmd)
+		if err != nil {
+			return err
+		}
+		embedGoMod, err := cmd.Flags().GetBool("embed-gomod")
+		if err != nil {
+			return fmt.Errorf("unable to parse --embed-gomod arguments: %s", err.Error())
+		}
+		defineArgs, err := cmd.Flags().GetStringArray("define")
+		if err != nil {
+			return fmt.Errorf("unable to parse --define arguments: %s", err.Error())
+		}
+		defines, err := parseKeyValues(defineArgs, "define")
+		if err != nil {
+			return err
+		}
+		ignoreGoFlags, err := cmd.Flags().GetBool("ignore-goflags")
+		if err != nil {
+			return fmt.Errorf("unable to parse --ignore-goflags arguments: %s", err.Error())
+		}
+		goVersion, err := cmd.Flags().GetString("go-version")
+		if err != nil {
+			return fmt.Errorf("unable to parse --go-version arguments: %s", err.Error())
+		}
+		if output == "" {
+			output = getCaddyOutputFile()
+		}
+
+		builder := xcaddy.Builder{
+			Compile: xcaddy.Compile{
+				Cgo: os.Getenv("CGO_ENABLED") == "1",
+			},
+			RaceDetector:  raceDetector,
+			SkipBuild:     skipBuild,
+			Debug:         buildDebugOutput,
+			BuildFlags:    buildFlags,
+			ModFlags:      modFlags,
+			WriteModFiles: true,
+			EmbedModFiles: embedGoMod,
+			Invocation: &xcaddy.Invocation{
+				XcaddyVersion: xcaddyVersion(),
+				Args:          recordedArgs(os.Args[1:]),
+			},
+			Environment:   dir,
+			EmbedDirs:     parseEmbedDirs(embedDir),
+			EmbedMaxSize:  embedMaxSize,
+			IgnoreGoFlags: ignoreGoFlags,
+			GoVersion:     goVersion,
+			Defines:       defines,
+		}
+		builder.TimeoutBuild, builder.TimeoutCompile, builder.TimeoutTotal, err = timeoutsFromFlags(cmd)
+		if err != nil {
+			return err
+		}
+		output, err = builder.BuildFile(cmd.Root().Context(), output)
+		if err != nil {
+			return err
+		}
+		if builder.SkipBuild {
+			return nil
+		}
+		return setcapIfRequested(output)
+	},
+}
+
+var envExecCommand = &cobra.Command{
+	Use:   "exec --name <name> [--ignore-goflags] [--go-version <version>] -- <command> [<args>...]",
+	Short: "Runs a command in a reusable build environment",
+	Long: `
+Runs a command, usually a go command like go list -m all, go mod why or go vet,
+in the Go module of the environment with the given name. The environment
+variables are set like for a build, including GOOS, GOARCH and CGO_ENABLED.
+Changes the command makes to the module, like to go.mod, are kept. GOFLAGS is
+passed like for a build, unless --ignore-goflags is given, and --go-version
+selects the Go toolchain like for a build.
+`,
+	Args: cobra.MinimumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		dir, err := envFolder(cmd)
+		if err != nil {
+			return err
+		}
+		ignoreGoFlags, err := cmd.Flags().GetBool("ignore-goflags")
+		if err != nil {
+			return fmt.Errorf("unable to parse --ignore-goflags arguments: %s", err.Error())
+		}
+		goVersion, err := cmd.Flags().GetString("go-version")
+		if err != nil {
+			return fmt.Errorf("unable to parse --go-version arguments: %s", err.Error())
+		}
+		builder := xcaddy.Builder{
+			Compile: xcaddy.Compile{
+				Cgo: os.Getenv("CGO_ENABLED") == "1",
+			},
+			RaceDetector:  raceDetector,
+			BuildFlags:    buildFlags,
+			ModFlags:      modFlags,
+			Environment:   dir,
+			IgnoreGoFlags: ignoreGoFlags,
+			GoVersion:     goVersion,
+		}
+		return builder.Run(cmd.Root().Context(), args[0], args[1:]...)
+	},
+}
+
+var execCommand = &cobra.Command{
+	Use: `exec [<caddy_version>]
+    [<flags of the build command>...]
+    -- <command> [<args>...]`,
+	Short: "Runs a command in a new build environment",
+	Long: `
+Prepares a build environment like the build command does, then runs a command,
+usually a go command like go list -m all, go mod graph or go test, in its Go
+module instead of building Caddy. The environment is removed afterwards, unless
+XCADDY_SKIP_CLEANUP=1 is set. See also the env exec command.
+`,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		dash := cmd.ArgsLenAtDash()
+		if dash < 0 || dash == len(args) {
+			return fmt.Errorf("the command to run must follow --")
+		}
+		if dash > 1 {
+			return fmt.Errorf("too many arguments before --: %v", args[:dash])
+		}
+		builder, err := builderFromFlags(cmd, args[:dash])
+		if err != nil {
+			return err
+		}
+		return builder.Run(cmd.Root().Context(), args[dash], args[dash+1:]...)
+	},
+}
+
+var envDestroyCommand = &cobra.Command{
+	Use:   "destroy --name <name>",
+	Short: "Removes a reusable build environment",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		dir, err := envFolder(cmd)
+		if err != nil {
+			return err
+		}
+		if _, err := os.Stat(dir); err != nil {
+			return fmt.Errorf("no such environment: %v", err)
+		}
+		log.Printf("[INFO] Removing environment: %s", dir)
+		return os.RemoveAll(dir)
+	},
+}
+
+var envListCommand = &cobra.Command{
+	Use:   "list",
+	Short: "Lists the reusable build environments",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		root, err := envsFolder()
+		if err != nil {
+			return err
+		}
+		entries, err := os.ReadDir(root)
+		if err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		for _, e := range entries {
+			if e.IsDir() {
+				fmt.Println(e.Name())
+			}
+		}
+		return nil
+	},
+}
+
+// envNameRegexp matches valid environment names,
+// which must be usable as a folder name.
+var envNameRegexp = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+
+// envFolder returns the folder of the environment
+// named by the --name flag of cmd.
+func envFolder(cmd *cobra.Command) (string, error) {
+	name, err := cmd.Flags().GetString("name")
+	if err != nil {
+		return "", fmt.Errorf("unable to parse --name arguments: %s", err.Error())
+	}
+	if !envNameRegexp.MatchString(name) {
+		return "", fmt.Errorf("invalid environment name '%s': only letters, digits, '.', '_' and '-' are allowed", name)
+	}
+	root, err := envsFolder()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(root, name), nil
+}
+
+// envsFolder returns the folder containing all environments.
+func envsFolder() (string, error) {
+	cacheDir, err := os.UserCacheDir()
+	if err != nil {
+		return "", fmt.Errorf("finding folder for environments: %v", err)
+	}
+	return filepath.Join(cacheDir, "xcaddy", "environments"), nil
+}
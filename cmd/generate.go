@@ -0,0 +1,325 @@
+package xcaddycmd
+
+import (
+	"bytes"
+	"fmt"
+	"go/ast"
+	"go/format"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"io/fs"
+	"log"
+	"os"
+	"reflect"
+	"strconv"
+	"strings"
+	"text/template"
+	"unicode"
+
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	generateCommand.AddCommand(generateUnmarshalerCommand)
+	generateCommand.AddCommand(generateAdminEndpointCommand)
+}
+
+var generateCommand = &cobra.Command{
+	Use:   "generate",
+	Short: "Generates boilerplate code for the plugin being developed",
+	Long: `
+Generates idiomatic boilerplate code for the current Caddy APIs into a new file
+in the package in the current directory, which is the plugin being developed.
+Existing files are never overwritten.
+`,
+}
+
+var generateUnmarshalerCommand = &cobra.Command{
+	Use:   "caddyfile-unmarshaler <type>",
+	Short: "Generates an UnmarshalCaddyfile method for a module type",
+	Long: `
+Generates an UnmarshalCaddyfile method for the struct type of a module, which
+makes it configurable in the Caddyfile, along with an interface guard. Each
+exported field becomes a subdirective, named like its JSON key: strings, numbers
+and caddy.Duration take one value, []string takes any number of values, and bools
+take none. Fields of other types are left for you to parse. The method is written
+to <type>_caddyfile.go.
+`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		files, err := parseCurrentPackage()
+		if err != nil {
+			return err
+		}
+		data, err := newUnmarshalerData(files, args[0])
+		if err != nil {
+			return err
+		}
+		return writeGenerated(strings.ToLower(data.Type)+"_caddyfile.go", "caddyfile-unmarshaler.go.tmpl", data)
+	},
+}
+
+var generateAdminEndpointCommand = &cobra.Command{
+	Use:   "admin-endpoint <name>",
+	Short: "Generates a module serving an endpoint of the admin API",
+	Long: `
+Generates a module with the ID admin.api.<name>, which serves the path /<name>/
+of Caddy's admin API, for example to expose the state of the plugin. It is
+written to admin_<name>.go.
+`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		name := args[0]
+		if !scaffoldNameRegexp.MatchString(name) {
+			return fmt.Errorf("invalid name '%s': must start with a letter and contain only lower-case letters, digits and underscores", name)
+		}
+		files, err := parseCurrentPackage()
+		if err != nil {
+			return err
+		}
+		data := map[string]string{
+			"Package": files[0].Name.Name,
+			"Name":    name,
+			"Type":    camelCase(name) + "Admin",
+		}
+		return writeGenerated("admin_"+name+".go", "admin-endpoint.go.tmpl", data)
+	},
+}
+
+// parseCurrentPackage parses the non-test files
+// of the package in the current directory.
+func parseCurrentPackage() ([]vetFile, error) {
+	pkgs, err := parser.ParseDir(token.NewFileSet(), ".", func(fi fs.FileInfo) bool {
+		return !strings.HasSuffix(fi.Name(), "_test.go")
+	}, 0)
+	if err != nil {
+		return nil, err
+	}
+	if len(pkgs) != 1 {
+		return nil, fmt.Errorf("expected one Go package in the current directory, found %d", len(pkgs))
+	}
+	var files []vetFile
+	for _, pkg := range pkgs {
+		for _, f := range pkg.Files {
+			files = append(files, newVetFile(f))
+		}
+	}
+	return files, nil
+}
+
+// writeGenerated executes the scaffold template tmplName with data,
+// and writes the formatted result to file, which must not exist.
+func writeGenerated(file, tmplName string, data any) error {
+	if _, err := os.Stat(file); err == nil {
+		return fmt.Errorf("%s already exists", file)
+	}
+	tmpl, err := template.ParseFS(scaffoldTemplates, "scaffold/"+tmplName)
+	if err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	if err := tmpl.Execute(&buf, data); err != nil {
+		return err
+	}
+	content, err := format.Source(buf.Bytes())
+	if err != nil {
+		return fmt.Errorf("formatting %s: %v", file, err)
+	}
+	log.Printf("[INFO] Writing %s", file)
+	return os.WriteFile(file, content, 0o644)
+}
+
+// unmarshalerData is the data of the template of an UnmarshalCaddyfile method.
+type unmarshalerData struct {
+	Package      string
+	Type         string
+	Recv         string
+	Name         string // the name of the directive
+	Fields       []unmarshalerField
+	NeedsStrconv bool
+	NeedsCaddy   bool
+}
+
+// unmarshalerField is a field which is set by a subdirective.
+type unmarshalerField struct {
+	Field        string
+	Subdirective string
+	Kind         string // string, bool, list, duration, int, uint, float or unsupported
+	Type         string
+	Bits         int
+}
+
+// Convert returns whether a parsed number must be converted
+// to the type of the field.
+func (f unmarshalerField) Convert() bool {
+	return f.Type != "int64" && f.Type != "uint64" && f.Type != "float64"
+}
+
+// newUnmarshalerData returns the data for generating the UnmarshalCaddyfile
+// method of the struct type typeName, declared in one of files.
+func newUnmarshalerData(files []vetFile, typeName string) (unmarshalerData, error) {
+	var spec *ast.TypeSpec
+	var specFile vetFile
+	for _, f := range files {
+		ast.Inspect(f.File, func(n ast.Node) bool {
+			if ts, ok := n.(*ast.TypeSpec); ok && ts.Name.Name == typeName {
+				spec, specFile = ts, f
+			}
+			return spec == nil
+		})
+		for _, decl := range f.Decls {
+			fn, ok := decl.(*ast.FuncDecl)
+			if ok && fn.Name.Name == "UnmarshalCaddyfile" && receiverType(fn) == typeName {
+				return unmarshalerData{}, fmt.Errorf("%s already has an UnmarshalCaddyfile method", typeName)
+			}
+		}
+	}
+	if spec == nil {
+		return unmarshalerData{}, fmt.Errorf("type %s not found in the package in the current directory", typeName)
+	}
+	st, ok := spec.Type.(*ast.StructType)
+	if !ok {
+		return unmarshalerData{}, fmt.Errorf("%s is not a struct type", typeName)
+	}
+
+	data := unmarshalerData{
+		Package: specFile.Name.Name,
+		Type:    typeName,
+		Recv:    strings.ToLower(typeName[:1]),
+		Name:    moduleName(files, typeName),
+	}
+	for _, field := range st.Fields.List {
+		subdirective := ""
+		if field.Tag != nil {
+			tag, _ := strconv.Unquote(field.Tag.Value)
+			subdirective, _, _ = strings.Cut(reflect.StructTag(tag).Get("json"), ",")
+		}
+		if subdirective == "-" {
+			continue
+		}
+		kind, typ, bits := fieldKind(specFile, field.Type)
+		for _, name := range field.Names {
+			if !name.IsExported() {
+				continue
+			}
+			f := unmarshalerField{Field: name.Name, Subdirective: subdirective, Kind: kind, Type: typ, Bits: bits}
+			if f.Subdirective == "" || len(field.Names) > 1 {
+				f.Subdirective = snakeCase(name.Name)
+			}
+			switch kind {
+			case "int", "uint", "float":
+				data.NeedsStrconv = true
+			case "duration":
+				data.NeedsCaddy = true
+			}
+			data.Fields = append(data.Fields, f)
+		}
+	}
+	if len(data.Fields) == 0 {
+		return unmarshalerData{}, fmt.Errorf("%s has no exported fields to set in the Caddyfile", typeName)
+	}
+	return data, nil
+}
+
+// fieldKind returns how a field of type x is parsed from the Caddyfile,
+// along with the name of its type and its size in bits, for numbers.
+func fieldKind(f vetFile, x ast.Expr) (kind, typ string, bits int) {
+	if array, ok := x.(*ast.ArrayType); ok {
+		if elt, ok := array.Elt.(*ast.Ident); ok && array.Len == nil && elt.Name == "string" {
+			return "list", "[]string", 0
+		}
+		return "unsupported", types.ExprString(x), 0
+	}
+	typ = f.qualified(x)
+	switch typ {
+	case "string", "bool":
+		return typ, typ, 0
+	case "caddy.Duration":
+		return "duration", typ, 0
+	case "int", "uint":
+		return typ, typ, 0
+	case "int8", "int16", "int32", "int64":
+		bits, _ = strconv.Atoi(strings.TrimPrefix(typ, "int"))
+		return "int", typ, bits
+	case "uint8", "uint16", "uint32", "uint64":
+		bits, _ = strconv.Atoi(strings.TrimPrefix(typ, "uint"))
+		return "uint", typ, bits
+	case "float32", "float64":
+		bits, _ = strconv.Atoi(strings.TrimPrefix(typ, "float"))
+		return "float", typ, bits
+	}
+	return "unsupported", types.ExprString(x), 0
+}
+
+// moduleName returns the last label of the ID of the module typeName,
+// if it has a CaddyModule method returning a literal ID, or else the
+// lower-cased type name.
+func moduleName(files []vetFile, typeName string) string {
+	name := strings.ToLower(typeName)
+	for _, f := range files {
+		for _, decl := range f.Decls {
+			fn, ok := decl.(*ast.FuncDecl)
+			if !ok || fn.Name.Name != "CaddyModule" || receiverType(fn) != typeName || fn.Body == nil {
+				continue
+			}
+			ast.Inspect(fn.Body, func(n ast.Node) bool {
+				kv, ok := n.(*ast.KeyValueExpr)
+				if !ok {
+					return true
+				}
+				key, ok := kv.Key.(*ast.Ident)
+				value, isLit := kv.Value.(*ast.BasicLit)
+				if ok && key.Name == "ID" && isLit && value.Kind == token.STRING {
+					id, _ := strconv.Unquote(value.Value)
+					name = id[strings.LastIndex(id, ".")+1:]
+				}
+				return true
+			})
+		}
+	}
+	return name
+}
+
+// receiverType returns the name of the type of the receiver of fn.
+func receiverType(fn *ast.FuncDecl) string {
+	if fn.Recv == nil || len(fn.Recv.List) != 1 {
+		return ""
+	}
+	recv := fn.Recv.List[0].Type
+	if star, ok := recv.(*ast.StarExpr); ok {
+		recv = star.X
+	}
+	if ident, ok := recv.(*ast.Ident); ok {
+		return ident.Name
+	}
+	return ""
+}
+
+// snakeCase converts a Go identifier like APIToken to api_token.
+func snakeCase(s string) string {
+	runes := []rune(s)
+	var b strings.Builder
+	for i, r := range runes {
+		if unicode.IsUpper(r) && i > 0 {
+			prevLower := unicode.IsLower(runes[i-1]) || unicode.IsDigit(runes[i-1])
+			nextLower := i+1 < len(runes) && unicode.IsLower(runes[i+1])
+			if prevLower || (unicode.IsUpper(runes[i-1]) && nextLower) {
+				b.WriteRune('_')
+			}
+		}
+		b.WriteRune(unicode.ToLower(r))
+	}
+	return b.String()
+}
+
+// camelCase converts a name like rate_limit to RateLimit.
+func camelCase(s string) string {
+	var b strings.Builder
+	for _, part := range strings.Split(s, "_") {
+		if part != "" {
+			b.WriteString(strings.ToUpper(part[:1]) + part[1:])
+		}
+	}
+	return b.String()
+}
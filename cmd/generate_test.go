@@ -0,0 +1,146 @@
+package xcaddycmd
+
+import (
+	"go/parser"
+	"go/token"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestSnakeCase(t *testing.T) {
+	for input, expected := range map[string]string{
+		"Message":      "message",
+		"APIToken":     "api_token",
+		"MaxEvents":    "max_events",
+		"TTL":          "ttl",
+		"ServerName2":  "server_name2",
+		"HTTPSAddress": "https_address",
+	} {
+		if got := snakeCase(input); got != expected {
+			t.Errorf("snakeCase(%s): expected %s, got %s", input, expected, got)
+		}
+	}
+}
+
+func TestCamelCase(t *testing.T) {
+	for input, expected := range map[string]string{
+		"metrics":    "Metrics",
+		"rate_limit": "RateLimit",
+		"a__b_":      "AB",
+	} {
+		if got := camelCase(input); got != expected {
+			t.Errorf("camelCase(%s): expected %s, got %s", input, expected, got)
+		}
+	}
+}
+
+const generateTestSource = `package mymod
+
+import caddy2 "github.com/caddyserver/caddy/v2"
+
+type Limiter struct {
+	APIToken  string          ` + "`json:\"api_token,omitempty\"`" + `
+	Verbose   bool
+	Hosts     []string
+	Window    caddy2.Duration ` + "`json:\"window,omitempty\"`" + `
+	MaxEvents int64
+	Burst     uint16
+	Zones     map[string]int
+	Ignored   string ` + "`json:\"-\"`" + `
+	internal  string
+}
+
+func (Limiter) CaddyModule() caddy2.ModuleInfo {
+	return caddy2.ModuleInfo{ID: "http.handlers.rate_limit", New: func() caddy2.Module { return new(Limiter) }}
+}
+
+type Done struct{ Name string }
+
+func (d *Done) UnmarshalCaddyfile(*caddyfile.Dispenser) error { return nil }
+`
+
+func TestNewUnmarshalerData(t *testing.T) {
+	f, err := parser.ParseFile(token.NewFileSet(), "mod.go", generateTestSource, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	files := []vetFile{newVetFile(f)}
+
+	data, err := newUnmarshalerData(files, "Limiter")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expected := unmarshalerData{
+		Package: "mymod",
+		Type:    "Limiter",
+		Recv:    "l",
+		Name:    "rate_limit",
+		Fields: []unmarshalerField{
+			{Field: "APIToken", Subdirective: "api_token", Kind: "string", Type: "string"},
+			{Field: "Verbose", Subdirective: "verbose", Kind: "bool", Type: "bool"},
+			{Field: "Hosts", Subdirective: "hosts", Kind: "list", Type: "[]string"},
+			{Field: "Window", Subdirective: "window", Kind: "duration", Type: "caddy.Duration"},
+			{Field: "MaxEvents", Subdirective: "max_events", Kind: "int", Type: "int64", Bits: 64},
+			{Field: "Burst", Subdirective: "burst", Kind: "uint", Type: "uint16", Bits: 16},
+			{Field: "Zones", Subdirective: "zones", Kind: "unsupported", Type: "map[string]int"},
+		},
+		NeedsStrconv: true,
+		NeedsCaddy:   true,
+	}
+	if !reflect.DeepEqual(data, expected) {
+		t.Errorf("Expected %+v, got %+v", expected, data)
+	}
+
+	for typeName, expectedErr := range map[string]string{
+		"Done":    "already has an UnmarshalCaddyfile method",
+		"Missing": "not found",
+	} {
+		_, err := newUnmarshalerData(files, typeName)
+		if err == nil || !strings.Contains(err.Error(), expectedErr) {
+			t.Errorf("%s: expected an error containing %q, got %v", typeName, expectedErr, err)
+		}
+	}
+}
+
+func TestWriteGenerated(t *testing.T) {
+	f, err := parser.ParseFile(token.NewFileSet(), "mod.go", generateTestSource, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data, err := newUnmarshalerData([]vetFile{newVetFile(f)}, "Limiter")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	dir := t.TempDir()
+	for file, generate := range map[string]func(string) error{
+		"limiter_caddyfile.go": func(file string) error {
+			return writeGenerated(file, "caddyfile-unmarshaler.go.tmpl", data)
+		},
+		"admin_rate_limit.go": func(file string) error {
+			return writeGenerated(file, "admin-endpoint.go.tmpl", map[string]string{
+				"Package": "mymod",
+				"Name":    "rate_limit",
+				"Type":    "RateLimitAdmin",
+			})
+		},
+	} {
+		file = filepath.Join(dir, file)
+		if err := generate(file); err != nil {
+			t.Fatalf("Unexpected error: %v", err)
+		}
+		if err := generate(file); err == nil {
+			t.Errorf("Expected an error when %s exists", file)
+		}
+		content, err := os.ReadFile(file)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := parser.ParseFile(token.NewFileSet(), file, content, 0); err != nil {
+			t.Errorf("Generated invalid code: %v\n%s", err, content)
+		}
+	}
+}
@@ -0,0 +1,130 @@
+package xcaddycmd
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+	"time"
+
+	"github.com/caddyserver/xcaddy/internal/utils"
+)
+
+// moduleRelease describes the latest release of a module,
+// which helps to tell whether a plugin is still maintained.
+type moduleRelease struct {
+	Version string    `json:"version"`
+	Time    time.Time `json:"time"`
+
+	// the version of Caddy required by the go.mod
+	// file of the release, if it requires Caddy
+	Caddy string `json:"caddy,omitempty"`
+
+	// the URL of the repository of the module, if known
+	Repository string `json:"repository,omitempty"`
+}
+
+// releaseCacheTTL is the time for which the latest releases
+// of modules and the status of repositories are cached.
+const releaseCacheTTL = time.Hour
+
+// latestRelease looks up the latest release of the module at
+// modulePath with the module proxy.
+func latestRelease(ctx context.Context, modulePath string) (moduleRelease, error) {
+	return utils.Cached(ctx, "release "+modulePath, releaseCacheTTL, func() (moduleRelease, error) {
+		cmd := exec.CommandContext(ctx, utils.GetGo(), "list", "-m", "-json", modulePath+"@latest")
+		out, err := cmd.Output()
+		if err != nil {
+			return moduleRelease{}, fmt.Errorf("exec %v: %v", cmd.Args, err)
+		}
+		return parseModuleRelease(out)
+	})
+}
+
+// parseModuleRelease interprets the output of `go list -m -json`
+// for a release, reading its go.mod file from the module cache.
+func parseModuleRelease(out []byte) (moduleRelease, error) {
+	var mod struct {
+		Path    string
+		Version string
+		Time    time.Time
+		GoMod   string
+		Origin  struct {
+			URL string
+		}
+	}
+	err := json.Unmarshal(out, &mod)
+	if err != nil {
+		return moduleRelease{}, err
+	}
+	release := moduleRelease{
+		Version:    mod.Version,
+		Time:       mod.Time,
+		Repository: mod.Origin.URL,
+	}
+	if release.Repository == "" && strings.HasPrefix(mod.Path, "github.com/") {
+		if elems := strings.Split(mod.Path, "/"); len(elems) >= 3 {
+			release.Repository = "https://" + path.Join(elems[:3]...)
+		}
+	}
+	if mod.GoMod != "" {
+		_, _, requires, err := readGoMod(mod.GoMod)
+		if err != nil {
+			return moduleRelease{}, err
+		}
+		for _, r := range requires {
+			if r.PackagePath == "github.com/caddyserver/caddy/v2" {
+				release.Caddy = r.Version
+			}
+		}
+	}
+	return release, nil
+}
+
+// githubAPI is the base URL of the GitHub REST API.
+var githubAPI = "https://api.github.com"
+
+// repositoryArchived returns true if the repository at repoURL is
+// archived, which means it is no longer maintained. Only GitHub
+// repositories are supported; GITHUB_TOKEN, if set, raises the rate
+// limit of the API.
+func repositoryArchived(ctx context.Context, repoURL string) (bool, error) {
+	u, err := url.Parse(repoURL)
+	if err != nil {
+		return false, err
+	}
+	elems := strings.Split(strings.Trim(strings.TrimSuffix(u.Path, ".git"), "/"), "/")
+	if u.Host != "github.com" || len(elems) != 2 {
+		return false, fmt.Errorf("not a GitHub repository: %s", repoURL)
+	}
+	return utils.Cached(ctx, "archived "+u.Host+"/"+elems[0]+"/"+elems[1], releaseCacheTTL, func() (bool, error) {
+		ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+		defer cancel()
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, githubAPI+"/repos/"+elems[0]+"/"+elems[1], nil)
+		if err != nil {
+			return false, err
+		}
+		req.Header.Set("Accept", "application/vnd.github+json")
+		if token := os.Getenv("GITHUB_TOKEN"); token != "" {
+			req.Header.Set("Authorization", "Bearer "+token)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			return false, err
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return false, fmt.Errorf("looking up %s: %s", repoURL, resp.Status)
+		}
+		var repo struct {
+			Archived bool `json:"archived"`
+		}
+		err = json.NewDecoder(resp.Body).Decode(&repo)
+		return repo.Archived, err
+	})
+}
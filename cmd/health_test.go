@@ -0,0 +1,101 @@
+package xcaddycmd
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/caddyserver/xcaddy"
+)
+
+func TestParseModuleRelease(t *testing.T) {
+	goMod := filepath.Join(t.TempDir(), "v1.2.0.mod")
+	err := os.WriteFile(goMod, []byte("module github.com/example/plugin\n\ngo 1.22\n\nrequire github.com/caddyserver/caddy/v2 v2.8.4\n"), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := `{
+	"Path": "github.com/example/plugin/v2",
+	"Version": "v2.1.0",
+	"Time": "2024-06-01T10:31:11Z",
+	"GoMod": ` + strconv.Quote(goMod) + `
+}`
+	got, err := parseModuleRelease([]byte(out))
+	if err != nil {
+		t.Fatalf("parseModuleRelease() error = %v", err)
+	}
+	want := moduleRelease{
+		Version:    "v2.1.0",
+		Time:       time.Date(2024, 6, 1, 10, 31, 11, 0, time.UTC),
+		Caddy:      "v2.8.4",
+		Repository: "https://github.com/example/plugin",
+	}
+	if !got.Time.Equal(want.Time) || got.Version != want.Version || got.Caddy != want.Caddy || got.Repository != want.Repository {
+		t.Errorf("parseModuleRelease() = %+v, want %+v", got, want)
+	}
+
+	// the origin reported by the module proxy wins
+	got, err = parseModuleRelease([]byte(`{"Path": "example.com/plugin", "Version": "v0.1.0", "Origin": {"URL": "https://git.example.com/plugin"}}`))
+	if err != nil {
+		t.Fatalf("parseModuleRelease() error = %v", err)
+	}
+	if got.Repository != "https://git.example.com/plugin" || got.Caddy != "" {
+		t.Errorf("parseModuleRelease() = %+v", got)
+	}
+}
+
+func TestRepositoryArchived(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/repos/example/old":
+			w.Write([]byte(`{"archived": true}`))
+		case "/repos/example/new":
+			w.Write([]byte(`{"archived": false}`))
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+	defer func(api string) { githubAPI = api }(githubAPI)
+	githubAPI = srv.URL
+	ctx := xcaddy.WithoutCache(context.Background())
+
+	for _, tc := range []struct {
+		repo     string
+		archived bool
+		wantErr  bool
+	}{
+		{repo: "https://github.com/example/old", archived: true},
+		{repo: "https://github.com/example/new.git", archived: false},
+		{repo: "https://github.com/example/missing", wantErr: true},
+		{repo: "https://gitlab.com/example/old", wantErr: true},
+	} {
+		archived, err := repositoryArchived(ctx, tc.repo)
+		if (err != nil) != tc.wantErr {
+			t.Errorf("repositoryArchived(%s) error = %v, wantErr %v", tc.repo, err, tc.wantErr)
+		}
+		if archived != tc.archived {
+			t.Errorf("repositoryArchived(%s) = %t, want %t", tc.repo, archived, tc.archived)
+		}
+	}
+}
+
+func TestNewerVersion(t *testing.T) {
+	for _, tc := range []struct {
+		current, latest, want string
+	}{
+		{current: "v1.2.0", latest: "v1.3.0", want: "v1.3.0"},
+		{current: "v1.3.0", latest: "v1.3.0", want: ""},
+		{current: "v1.4.0", latest: "v1.3.0", want: ""},
+		{current: "(devel)", latest: "v1.3.0", want: ""},
+	} {
+		if got := newerVersion(tc.current, tc.latest); got != tc.want {
+			t.Errorf("newerVersion(%s, %s) = %q, want %q", tc.current, tc.latest, got, tc.want)
+		}
+	}
+}
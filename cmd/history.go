@@ -0,0 +1,426 @@
+package xcaddycmd
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/caddyserver/xcaddy"
+	"github.com/caddyserver/xcaddy/internal/utils"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	historyCommand.Flags().Bool("json", false, "print the builds as JSON")
+	reproduceCommand.Flags().String("output", "", "where to write the rebuilt binary, instead of a temporary file")
+}
+
+var historyCommand = &cobra.Command{
+	Use:   "history [--json]",
+	Short: "Lists the builds made with xcaddy",
+	Long: `
+Lists the builds made with the build command, oldest first, as recorded in the
+history file: when each was made, the SHA-256 of its binary, the Caddy version and
+the output file. The history also records the manifest of each build, its hash,
+the versions of all modules in the binary and the files written along with it,
+like go.mod and go.sum, so it can be audited what was built when, and builds can
+be reproduced with the reproduce command. Only what makes up the binary is
+recorded of the manifest: not the command line, the paths of the .netrc file and
+of TLS keys, nor credentials in the URLs of module proxies.
+
+The history file is history.jsonl in the xcaddy folder of the user's config
+directory, readable only by the user; set XCADDY_HISTORY to the path of another
+file, or to off to not record builds.
+
+Flags:
+ --json prints the builds as JSON, with everything that is recorded.
+`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		asJSON, err := cmd.Flags().GetBool("json")
+		if err != nil {
+			return fmt.Errorf("unable to parse --json arguments: %s", err.Error())
+		}
+		entries, err := readHistory()
+		if err != nil {
+			return err
+		}
+		if asJSON {
+			if entries == nil {
+				entries = []historyEntry{}
+			}
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetIndent("", "\t")
+			return enc.Encode(entries)
+		}
+		return printHistory(os.Stdout, entries)
+	},
+}
+
+var reproduceCommand = &cobra.Command{
+	Use:   "reproduce <sha256> [--output <file>]",
+	Short: "Rebuilds a build of the history and compares it",
+	Long: `
+Rebuilds the build in the history whose binary has the given SHA-256, or a prefix
+of it, from its recorded manifest, and compares the result. Caddy and plugins
+without a version are rebuilt at the versions they got, and with the same Go
+version unless the manifest sets one. If the binaries differ, the versions of the
+modules in them are compared.
+
+Binaries are identical if the build is reproducible: the plugins must have had
+versions, since otherwise the rebuilt binary records different ones, and local
+replacements must not have changed since. It exits with a non-zero status if the
+binaries differ.
+
+Flags:
+ --output writes the rebuilt binary to a file; by default, it is removed.
+`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		output, err := cmd.Flags().GetString("output")
+		if err != nil {
+			return fmt.Errorf("unable to parse --output arguments: %s", err.Error())
+		}
+		entries, err := readHistory()
+		if err != nil {
+			return err
+		}
+		entry, err := findHistoryEntry(entries, args[0])
+		if err != nil {
+			return err
+		}
+		builder, err := entry.reproducibleBuilder()
+		if err != nil {
+			return err
+		}
+		if output == "" {
+			outputDir, err := os.MkdirTemp("", "xcaddy-reproduce-")
+			if err != nil {
+				return err
+			}
+			defer os.RemoveAll(outputDir)
+			output = filepath.Join(outputDir, filepath.Base(entry.Output))
+		}
+		err = builder.Build(cmd.Root().Context(), output)
+		if err != nil {
+			return err
+		}
+
+		digest, err := fileDigest(output)
+		if err != nil {
+			return err
+		}
+		fmt.Println()
+		if digest == entry.SHA256 {
+			fmt.Printf("Reproduced: the rebuilt binary is identical, with SHA-256 %s\n", digest)
+			return nil
+		}
+		fmt.Printf("The rebuilt binary differs: its SHA-256 is %s instead of %s\n", digest, entry.SHA256)
+		bi, inv, err := readBinary(output)
+		if err != nil {
+			return err
+		}
+		fmt.Println("Modules changed from the recorded build:")
+		printDiff(os.Stdout, diffBuilds(buildSummary{Modules: entry.Modules}, summarizeBinary(bi, inv)))
+		return &xcaddy.Error{Failure: xcaddy.FailureVerification, Err: fmt.Errorf("build %s was not reproduced", entry.SHA256)}
+	},
+}
+
+// historyEntry is a build recorded in the history.
+type historyEntry struct {
+	Time         time.Time `json:"time"`
+	SHA256       string    `json:"sha256"`
+	Output       string    `json:"output"`
+	CaddyVersion string    `json:"caddy_version,omitempty"`
+	GoVersion    string    `json:"go_version,omitempty"`
+
+	// The Builder of the build as JSON, like a manifest, and its hash;
+	// see historyManifest for what of it is recorded.
+	Manifest       json.RawMessage `json:"manifest"`
+	ManifestSHA256 string          `json:"manifest_sha256"`
+
+	// The path of every module in the binary, mapped to its
+	// version, including what it is replaced with, if anything.
+	Modules map[string]string `json:"modules,omitempty"`
+
+	// The files written along with the binary, like its go.mod.
+	Reports []string `json:"reports,omitempty"`
+
+	// The patterns of the modules fetched without verifying TLS.
+	InsecureModules []string `json:"insecure_modules,omitempty"`
+}
+
+// historyFile returns the path of the history file,
+// or an empty string if builds are not recorded.
+func historyFile() (string, error) {
+	file := os.Getenv("XCADDY_HISTORY")
+	if file == "off" {
+		return "", nil
+	}
+	if file != "" {
+		return file, nil
+	}
+	configDir, err := os.UserConfigDir()
+	if err != nil {
+		return "", fmt.Errorf("finding the history file: %v", err)
+	}
+	return filepath.Join(configDir, "xcaddy", "history.jsonl"), nil
+}
+
+// recordBuild appends the build by builder, whose result is
+// result, to the history file, unless builds are not recorded.
+func recordBuild(builder xcaddy.Builder, result buildResult, reports []string) error {
+	file, err := historyFile()
+	if err != nil || file == "" {
+		return err
+	}
+	entry, err := newHistoryEntry(builder, result, reports)
+	if err != nil {
+		return err
+	}
+	line, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	err = os.MkdirAll(filepath.Dir(file), 0o755)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(file, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o600)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(append(line, '\n'))
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// newHistoryEntry describes the build by builder
+// with the given result for the history.
+func newHistoryEntry(builder xcaddy.Builder, result buildResult, reports []string) (historyEntry, error) {
+	// the platform is recorded, since it may
+	// have come from the environment
+	if builder.OS == "" {
+		builder.OS = utils.GetGOOS()
+	}
+	if builder.Arch == "" {
+		builder.Arch = utils.GetGOARCH()
+	}
+	if builder.ARM == "" {
+		builder.ARM = os.Getenv("GOARM")
+	}
+	manifest, err := json.Marshal(historyManifest(builder))
+	if err != nil {
+		return historyEntry{}, err
+	}
+	manifestSum := sha256.Sum256(manifest)
+	bi, inv, err := readBinary(result.Output)
+	if err != nil {
+		return historyEntry{}, err
+	}
+	return historyEntry{
+		Time:           time.Now().UTC(),
+		SHA256:         result.SHA256,
+		Output:         result.Output,
+		CaddyVersion:   result.CaddyVersion,
+		GoVersion:      result.GoVersion,
+		Manifest:       manifest,
+		ManifestSHA256: hex.EncodeToString(manifestSum[:]),
+		Modules:        summarizeBinary(bi, inv).Modules,
+		Reports:        reports,
+
+		InsecureModules: result.InsecureModules,
+	}, nil
+}
+
+// historyManifest returns the Builder recorded in the history for
+// builder: only the fields which make up the binary, so that it can be
+// reproduced, and none of the paths of credentials, like the .netrc file
+// or TLS keys, nor the command line, which may carry them. Credentials
+// in the URLs of module proxies and of the Caddy repository are removed.
+func historyManifest(builder xcaddy.Builder) xcaddy.Builder {
+	manifest := xcaddy.Builder{
+		Compile:            builder.Compile,
+		CaddyVersion:       builder.CaddyVersion,
+		Plugins:            builder.Plugins,
+		Replacements:       builder.Replacements,
+		Patches:            builder.Patches,
+		Excludes:           builder.Excludes,
+		Requires:           builder.Requires,
+		RaceDetector:       builder.RaceDetector,
+		Debug:              builder.Debug,
+		BuildFlags:         builder.BuildFlags,
+		ModFlags:           builder.ModFlags,
+		GoModDirectives:    builder.GoModDirectives,
+		IgnoreGoFlags:      builder.IgnoreGoFlags,
+		GoVersion:          builder.GoVersion,
+		Insecure:           builder.Insecure,
+		Bare:               builder.Bare,
+		Without:            builder.Without,
+		MainPreset:         builder.MainPreset,
+		MainDefaults:       builder.MainDefaults,
+		BuildMode:          builder.BuildMode,
+		Defines:            builder.Defines,
+		Cover:              builder.Cover,
+		CaddyGitFallback:   builder.CaddyGitFallback,
+		CaddyRepository:    withoutUserinfo(builder.CaddyRepository),
+		ResolveAmbiguities: builder.ResolveAmbiguities,
+		EmbedModFiles:      builder.EmbedModFiles,
+		ReplaceFrom:        builder.ReplaceFrom,
+		EmbedDirs:          builder.EmbedDirs,
+		EmbedMaxSize:       builder.EmbedMaxSize,
+	}
+	for _, p := range builder.ModuleProxies {
+		p.Proxy = withoutUserinfo(p.Proxy)
+		manifest.ModuleProxies = append(manifest.ModuleProxies, p)
+	}
+	return manifest
+}
+
+// readHistory reads the entries of the history file;
+// there are none if it doesn't exist.
+func readHistory() ([]historyEntry, error) {
+	file, err := historyFile()
+	if err != nil {
+		return nil, err
+	}
+	if file == "" {
+		return nil, fmt.Errorf("builds are not recorded, since XCADDY_HISTORY is off")
+	}
+	data, err := os.ReadFile(file)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var entries []historyEntry
+	sc := bufio.NewScanner(bytes.NewReader(data))
+	sc.Buffer(nil, 16<<20)
+	for line := 1; sc.Scan(); line++ {
+		if len(bytes.TrimSpace(sc.Bytes())) == 0 {
+			continue
+		}
+		var entry historyEntry
+		if err := json.Unmarshal(sc.Bytes(), &entry); err != nil {
+			return nil, fmt.Errorf("reading history %s, line %d: %v", file, line, err)
+		}
+		entries = append(entries, entry)
+	}
+	return entries, sc.Err()
+}
+
+// findHistoryEntry returns the entry whose binary
+// has the SHA-256 digest, or a unique prefix of it.
+func findHistoryEntry(entries []historyEntry, digest string) (historyEntry, error) {
+	digest = strings.ToLower(digest)
+	var found []historyEntry
+	for _, e := range entries {
+		if e.SHA256 == digest {
+			return e, nil
+		}
+		if digest != "" && strings.HasPrefix(e.SHA256, digest) {
+			found = append(found, e)
+		}
+	}
+	switch {
+	case len(found) == 0:
+		return historyEntry{}, fmt.Errorf("no build in the history has SHA-256 %s", digest)
+	case len(found) > 1:
+		// a binary may have been built more than once
+		for _, e := range found[1:] {
+			if e.SHA256 != found[0].SHA256 {
+				return historyEntry{}, fmt.Errorf("more than one build in the history has a SHA-256 starting with %s", digest)
+			}
+		}
+	}
+	return found[0], nil
+}
+
+// reproducibleBuilder returns the Builder of the manifest of e, with
+// Caddy and the plugins pinned to the versions of the binary and the
+// Go version it was built with, and without writing any other files.
+func (e historyEntry) reproducibleBuilder() (xcaddy.Builder, error) {
+	var builder xcaddy.Builder
+	if err := json.Unmarshal(e.Manifest, &builder); err != nil {
+		return xcaddy.Builder{}, fmt.Errorf("reading the manifest of build %s: %v", e.SHA256, err)
+	}
+	// replaced modules have no version to pin
+	if version := e.Modules[caddyModulePath]; version != "" && !strings.Contains(version, " ") {
+		builder.CaddyVersion = version
+	}
+	for i, p := range builder.Plugins {
+		if p.Version != "" {
+			continue
+		}
+		var modulePath string
+		for path := range e.Modules {
+			if (p.PackagePath == path || strings.HasPrefix(p.PackagePath, path+"/")) && len(path) > len(modulePath) {
+				modulePath = path
+			}
+		}
+		if version := e.Modules[modulePath]; version != "" && !strings.Contains(version, " ") {
+			builder.Plugins[i].Version = version
+		}
+	}
+	if builder.GoVersion == "" {
+		builder.GoVersion = strings.TrimPrefix(e.GoVersion, "go")
+	}
+	builder.WriteModFiles = false
+	builder.AttestationFile = ""
+	builder.AttestationKey = ""
+	builder.GraphFile = ""
+	builder.Why = nil
+	builder.PruneReport = false
+	return builder, nil
+}
+
+// printHistory prints entries as a table.
+func printHistory(out io.Writer, entries []historyEntry) error {
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "TIME\tSHA-256\tCADDY\tOUTPUT")
+	for _, e := range entries {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", e.Time.Local().Format(time.DateTime), e.SHA256[:min(12, len(e.SHA256))], e.CaddyVersion, e.Output)
+	}
+	return w.Flush()
+}
+
+// fileDigest returns the SHA-256 of file, in hex.
+func fileDigest(file string) (string, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+// buildReports returns the files which the build by
+// builder wrote along with the binary at output.
+func buildReports(builder xcaddy.Builder, output string) []string {
+	var reports []string
+	if builder.WriteModFiles {
+		reports = append(reports, output+".go.mod", output+".go.sum")
+	}
+	for _, file := range []string{builder.AttestationFile, builder.GraphFile} {
+		if file == "" {
+			continue
+		}
+		if abs, err := filepath.Abs(file); err == nil {
+			file = abs
+		}
+		reports = append(reports, file)
+	}
+	return reports
+}
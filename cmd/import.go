@@ -0,0 +1,223 @@
+package xcaddycmd
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime/debug"
+	"strings"
+	"time"
+
+	"github.com/caddyserver/xcaddy"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	importCommand.Flags().String("from-admin", "", "the address of the admin API of a running Caddy instance, like http://localhost:2019")
+	importCommand.Flags().String("from-binary", "", "the path of a Caddy binary")
+	importCommand.Flags().String("output", "xcaddy.json", "the manifest file to write, or - for stdout")
+}
+
+var importCommand = &cobra.Command{
+	Use: `import
+    [--from-admin <address>]
+    [--from-binary <file>]
+    [--output <file>]`,
+	Short: "Writes a manifest of an existing Caddy build",
+	Long: `
+Reconstructs the Caddy version and plugins (with their versions) of an existing
+Caddy build and writes them to a manifest, which is a JSON file with the fields of
+xcaddy.Builder, or a YAML file if its name ends in .yaml or .yml. This makes it easy to reproduce or upgrade a custom build.
+
+Flags:
+ --from-admin is the address of the admin API of a running Caddy instance on this
+ machine. The admin API of Caddy has no endpoint for the versions of its modules,
+ so the binary of the instance is read instead, which is found through the
+ /debug/vars endpoint; instances on other machines can't be imported this way, so
+ copy their binary and use --from-binary.
+
+ --from-binary is the path of a Caddy binary instead.
+
+ --output is the manifest file to write, like xcaddy.yaml for YAML; defaults to
+ xcaddy.json.
+`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		fromAdmin, err := cmd.Flags().GetString("from-admin")
+		if err != nil {
+			return fmt.Errorf("unable to parse --from-admin arguments: %s", err.Error())
+		}
+		binary, err := cmd.Flags().GetString("from-binary")
+		if err != nil {
+			return fmt.Errorf("unable to parse --from-binary arguments: %s", err.Error())
+		}
+		output, err := cmd.Flags().GetString("output")
+		if err != nil {
+			return fmt.Errorf("unable to parse --output arguments: %s", err.Error())
+		}
+		if (fromAdmin == "") == (binary == "") {
+			return fmt.Errorf("exactly one of --from-admin or --from-binary is required")
+		}
+
+		if fromAdmin != "" {
+			binary, err = binaryFromAdmin(cmd.Root().Context(), fromAdmin)
+			if err != nil {
+				return err
+			}
+			log.Printf("[INFO] Caddy instance at %s runs %s", fromAdmin, binary)
+		}
+
+		manifest, err := manifestFromBinary(cmd.Root().Context(), binary)
+		if err != nil {
+			return err
+		}
+		return writeManifest(output, manifest)
+	},
+}
+
+// binaryFromAdmin returns the path of the binary of the Caddy
+// instance with the given admin API address. Caddy serves expvar
+// at /debug/vars, which includes the command line of the process.
+func binaryFromAdmin(ctx context.Context, adminAddr string) (string, error) {
+	if !strings.Contains(adminAddr, "://") {
+		adminAddr = "http://" + adminAddr
+	}
+	if err := checkLocalAdmin(adminAddr); err != nil {
+		return "", err
+	}
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, strings.TrimSuffix(adminAddr, "/")+"/debug/vars", nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("querying admin API: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("querying admin API: %s", resp.Status)
+	}
+	var vars struct {
+		Cmdline []string `json:"cmdline"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&vars); err != nil {
+		return "", fmt.Errorf("decoding admin API response: %v", err)
+	}
+	if len(vars.Cmdline) == 0 {
+		return "", fmt.Errorf("admin API did not report the command line of the process")
+	}
+	binary := vars.Cmdline[0]
+	if !filepath.IsAbs(binary) {
+		// started from $PATH, presumably the same as ours
+		binary, err = exec.LookPath(binary)
+		if err != nil {
+			return "", fmt.Errorf("finding binary %s of the Caddy instance: %v", vars.Cmdline[0], err)
+		}
+	}
+	return binary, nil
+}
+
+// checkLocalAdmin returns an error unless the admin API at adminAddr
+// is on this machine, since the path of the binary which it reports
+// would otherwise name a file of another machine, if any, here.
+func checkLocalAdmin(adminAddr string) error {
+	u, err := url.Parse(adminAddr)
+	if err != nil {
+		return fmt.Errorf("parsing admin API address: %v", err)
+	}
+	host := u.Hostname()
+	if host == "localhost" {
+		return nil
+	}
+	if ip := net.ParseIP(host); ip != nil && ip.IsLoopback() {
+		return nil
+	}
+	return fmt.Errorf("the admin API at %s is not on this machine; Caddy's admin API doesn't report the versions of its modules, so its binary is read instead: copy the binary here and use --from-binary", adminAddr)
+}
+
+// manifestFromBinary reconstructs the build of the Caddy binary at the
+// given path. Plugins are taken from the invocation recorded by xcaddy
+// if available, otherwise from the binary's own list of non-standard
+// modules, which requires running it.
+func manifestFromBinary(ctx context.Context, binary string) (xcaddy.Builder, error) {
+	bi, inv, err := readBinary(binary)
+	if err != nil {
+		return xcaddy.Builder{}, err
+	}
+	var pluginPaths []string
+	if inv != nil {
+		for _, p := range inv.Plugins {
+			pluginPaths = append(pluginPaths, p.PackagePath)
+		}
+	} else {
+		cmd := exec.CommandContext(ctx, binary, "list-modules", "--packages", "--skip-standard")
+		cmd.Stderr = os.Stderr
+		out, err := cmd.Output()
+		if err != nil {
+			return xcaddy.Builder{}, fmt.Errorf("listing modules of %s: %v", binary, err)
+		}
+		pluginPaths = parseListModules(out)
+	}
+	return newManifest(bi, pluginPaths), nil
+}
+
+// newManifest returns a manifest of the Caddy version and plugins,
+// given by their package paths, of a build. Plugins replaced by a
+// local directory can't be reproduced elsewhere, so they are pinned
+// to the version they replace.
+func newManifest(bi *debug.BuildInfo, pluginPaths []string) xcaddy.Builder {
+	var manifest xcaddy.Builder
+	seen := make(map[string]bool)
+	for _, dep := range bi.Deps {
+		if dep.Path == caddyModulePath {
+			manifest.CaddyVersion = dep.Version
+		}
+	}
+	for _, p := range pluginPaths {
+		mod := moduleOf(p, bi.Deps)
+		if mod == nil || seen[p] {
+			continue
+		}
+		seen[p] = true
+		manifest.Plugins = append(manifest.Plugins, xcaddy.Dependency{PackagePath: p, Version: mod.Version})
+		if mod.Replace == nil {
+			continue
+		}
+		if mod.Replace.Version == "" {
+			log.Printf("[WARNING] Plugin %s is replaced by local directory %s, using %s instead", p, mod.Replace.Path, mod.Version)
+			continue
+		}
+		manifest.Replacements = append(manifest.Replacements, xcaddy.NewReplace(
+			xcaddy.Dependency{PackagePath: mod.Path, Version: mod.Version}.String(),
+			xcaddy.Dependency{PackagePath: mod.Replace.Path, Version: mod.Replace.Version}.String(),
+		))
+	}
+	return manifest
+}
+
+// parseListModules returns the package paths in the output of
+// `caddy list-modules --packages`, which prints a module ID and
+// its package path on each line, followed by a summary.
+func parseListModules(out []byte) []string {
+	var paths []string
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 2 || !strings.Contains(fields[1], "/") {
+			continue
+		}
+		paths = append(paths, fields[1])
+	}
+	return paths
+}
@@ -0,0 +1,75 @@
+package xcaddycmd
+
+import (
+	"reflect"
+	"runtime/debug"
+	"testing"
+
+	"github.com/caddyserver/xcaddy"
+)
+
+func TestParseListModules(t *testing.T) {
+	out := []byte(`dns.providers.cloudflare github.com/caddy-dns/cloudflare
+http.handlers.mercure github.com/dunglas/mercure/caddy
+http.handlers.mercure_subscriber github.com/dunglas/mercure/caddy
+
+  Non-standard modules: 3
+
+  Unknown modules: 0
+`)
+	expected := []string{
+		"github.com/caddy-dns/cloudflare",
+		"github.com/dunglas/mercure/caddy",
+		"github.com/dunglas/mercure/caddy",
+	}
+	if actual := parseListModules(out); !reflect.DeepEqual(actual, expected) {
+		t.Errorf("Expected package paths '%v' but got '%v'", expected, actual)
+	}
+}
+
+func TestNewManifest(t *testing.T) {
+	bi := &debug.BuildInfo{
+		Deps: []*debug.Module{
+			{Path: "github.com/caddyserver/caddy/v2", Version: "v2.8.4"},
+			{Path: "github.com/caddy-dns/cloudflare", Version: "v0.1.0"},
+			{Path: "github.com/dunglas/mercure", Version: "v0.16.3"},
+			{Path: "github.com/dunglas/mercure/caddy", Version: "v0.16.3", Replace: &debug.Module{Path: "github.com/fork/mercure/caddy", Version: "v0.16.3-patched"}},
+			{Path: "github.com/mholt/caddy-l4", Version: "v0.1.0", Replace: &debug.Module{Path: "/src/caddy-l4"}},
+		},
+	}
+	actual := newManifest(bi, []string{
+		"github.com/caddy-dns/cloudflare",
+		"github.com/dunglas/mercure/caddy",
+		"github.com/dunglas/mercure/caddy",
+		"github.com/mholt/caddy-l4/layer4",
+		"github.com/not/in/build",
+	})
+	expected := xcaddy.Builder{
+		CaddyVersion: "v2.8.4",
+		Plugins: []xcaddy.Dependency{
+			{PackagePath: "github.com/caddy-dns/cloudflare", Version: "v0.1.0"},
+			{PackagePath: "github.com/dunglas/mercure/caddy", Version: "v0.16.3"},
+			{PackagePath: "github.com/mholt/caddy-l4/layer4", Version: "v0.1.0"},
+		},
+		Replacements: []xcaddy.Replace{
+			xcaddy.NewReplace("github.com/dunglas/mercure/caddy@v0.16.3", "github.com/fork/mercure/caddy@v0.16.3-patched"),
+		},
+	}
+	if !reflect.DeepEqual(actual, expected) {
+		t.Errorf("Expected manifest '%+v' but got '%+v'", expected, actual)
+	}
+}
+
+func TestCheckLocalAdmin(t *testing.T) {
+	for addr, wantErr := range map[string]bool{
+		"http://localhost:2019":      false,
+		"http://127.0.0.1:2019":      false,
+		"http://[::1]:2019":          false,
+		"http://caddy.internal:2019": true,
+		"http://10.0.0.5:2019":       true,
+	} {
+		if err := checkLocalAdmin(addr); (err != nil) != wantErr {
+			t.Errorf("checkLocalAdmin(%s) error = %v, wantErr %v", addr, err, wantErr)
+		}
+	}
+}
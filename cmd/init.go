@@ -0,0 +1,211 @@
+package xcaddycmd
+
+import (
+	"bytes"
+	"embed"
+	"fmt"
+	"go/format"
+	"log"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"text/template"
+
+	"github.com/caddyserver/xcaddy/internal/utils"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	initCommand.Flags().String("dir", "", "the folder to create the plugin in; defaults to the last element of the module path")
+	initCommand.Flags().String("name", "", "the name of the Caddy module; defaults to one derived from the module path")
+}
+
+// scaffoldTemplates are the templates of the files created by
+// the init command, named like <kind>.go.tmpl for those which
+// depend on the kind of plugin.
+//
+//go:embed scaffold/*.tmpl
+var scaffoldTemplates embed.FS
+
+// scaffoldKinds are the kinds of plugins the init command
+// can create, with their namespace and description.
+var scaffoldKinds = map[string]struct {
+	Namespace   string
+	Description string
+}{
+	"handler":      {"http.handlers", "HTTP handler"},
+	"matcher":      {"http.matchers", "HTTP request matcher"},
+	"dns.provider": {"dns.providers", "DNS provider"},
+	"storage":      {"caddy.storage", "storage"},
+}
+
+var initCommand = &cobra.Command{
+	Use: `init <kind> <module_path>
+    [--dir <dir>]
+    [--name <name>]`,
+	Short: "Creates a new Caddy plugin",
+	Long: `
+Creates a ready-to-build Caddy plugin in a new folder: a Go module with a Caddy
+module of the given kind, which is registered with Caddy and can be configured in
+the Caddyfile, along with its tests, an example Caddyfile and a README describing
+how to develop it with xcaddy.
+
+<kind> is one of handler, matcher, dns.provider or storage.
+
+Flags:
+ --dir sets the folder to create, which must not exist or be empty.
+
+ --name sets the name of the Caddy module, which is the last label of its ID and
+ the name of its Caddyfile directive; by default it is the last element of the
+ module path, without a caddy- prefix.
+`,
+	Args: cobra.ExactArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		kind, modulePath := args[0], args[1]
+		dir, err := cmd.Flags().GetString("dir")
+		if err != nil {
+			return fmt.Errorf("unable to parse --dir arguments: %s", err.Error())
+		}
+		name, err := cmd.Flags().GetString("name")
+		if err != nil {
+			return fmt.Errorf("unable to parse --name arguments: %s", err.Error())
+		}
+		if dir == "" {
+			dir = path.Base(modulePath)
+		}
+		data, err := newScaffoldData(kind, modulePath, name)
+		if err != nil {
+			return err
+		}
+
+		if entries, err := os.ReadDir(dir); err == nil && len(entries) > 0 {
+			return fmt.Errorf("folder %s already exists and isn't empty", dir)
+		}
+		if err := os.MkdirAll(dir, 0o755); err != nil {
+			return err
+		}
+		if err := writeScaffold(dir, kind, data); err != nil {
+			return err
+		}
+
+		goModInit := exec.Command(utils.GetGo(), "mod", "init", modulePath)
+		goModInit.Dir = dir
+		goModInit.Stdout = os.Stdout
+		goModInit.Stderr = os.Stderr
+		if err := goModInit.Run(); err != nil {
+			return fmt.Errorf("go mod init: %v", err)
+		}
+
+		log.Printf("[INFO] Created %s module %s in %s", kind, data.ID, dir)
+		log.Printf("[INFO] Next: cd %s && go mod tidy && xcaddy run --config Caddyfile", dir)
+		return nil
+	},
+}
+
+// scaffoldData is the data of the templates of a new plugin.
+type scaffoldData struct {
+	Module          string // the Go module path
+	Package         string // the Go package name
+	Name            string // the name of the Caddy module
+	ID              string // the ID of the Caddy module
+	EnvName         string // the name in upper case, for environment variables
+	KindDescription string
+}
+
+// scaffoldNameRegexp matches valid names of Caddy modules.
+var scaffoldNameRegexp = regexp.MustCompile(`^[a-z][a-z0-9_]*$`)
+
+// newScaffoldData returns the data of the templates of a plugin
+// of the given kind; name is derived from modulePath if empty.
+func newScaffoldData(kind, modulePath, name string) (scaffoldData, error) {
+	k, ok := scaffoldKinds[kind]
+	if !ok {
+		var kinds []string
+		for kind := range scaffoldKinds {
+			kinds = append(kinds, kind)
+		}
+		sort.Strings(kinds)
+		return scaffoldData{}, fmt.Errorf("unknown kind of plugin '%s'; must be one of: %s", kind, strings.Join(kinds, ", "))
+	}
+	if !strings.Contains(modulePath, "/") || strings.ContainsAny(modulePath, " \\@=") {
+		return scaffoldData{}, fmt.Errorf("invalid module path '%s': expected one like github.com/me/myplugin", modulePath)
+	}
+	if name == "" {
+		name = scaffoldName(modulePath)
+	}
+	if !scaffoldNameRegexp.MatchString(name) {
+		return scaffoldData{}, fmt.Errorf("invalid module name '%s': must start with a letter and contain only lower-case letters, digits and underscores; set one with --name", name)
+	}
+	return scaffoldData{
+		Module:          modulePath,
+		Package:         strings.ReplaceAll(name, "_", ""),
+		Name:            name,
+		ID:              k.Namespace + "." + name,
+		EnvName:         strings.ToUpper(name),
+		KindDescription: k.Description,
+	}, nil
+}
+
+// majorVersionRegexp matches the major version suffix of a module path.
+var majorVersionRegexp = regexp.MustCompile(`^v[0-9]+$`)
+
+// scaffoldName derives the name of a Caddy module from a module path,
+// like ratelimit from github.com/me/caddy-ratelimit/v2.
+func scaffoldName(modulePath string) string {
+	elem := path.Base(modulePath)
+	if majorVersionRegexp.MatchString(elem) {
+		elem = path.Base(path.Dir(modulePath))
+	}
+	elem = strings.ToLower(elem)
+	elem = strings.TrimPrefix(elem, "caddy-")
+	elem = strings.TrimSuffix(elem, "-caddy")
+	var name strings.Builder
+	for _, r := range elem {
+		switch {
+		case r >= 'a' && r <= 'z', r >= '0' && r <= '9':
+			name.WriteRune(r)
+		case r == '-', r == '.', r == '_':
+			name.WriteRune('_')
+		}
+	}
+	return strings.Trim(name.String(), "_")
+}
+
+// writeScaffold writes the files of a new plugin of
+// the given kind to dir, except for its go.mod.
+func writeScaffold(dir, kind string, data scaffoldData) error {
+	files := map[string]string{
+		kind + ".go.tmpl":        data.Package + ".go",
+		kind + "_test.go.tmpl":   data.Package + "_test.go",
+		kind + ".Caddyfile.tmpl": "Caddyfile",
+		"README.md.tmpl":         "README.md",
+		"gitignore.tmpl":         ".gitignore",
+	}
+	for tmplName, name := range files {
+		tmpl, err := template.ParseFS(scaffoldTemplates, "scaffold/"+tmplName)
+		if err != nil {
+			return err
+		}
+		var buf bytes.Buffer
+		if err := tmpl.Execute(&buf, data); err != nil {
+			return err
+		}
+		content := buf.Bytes()
+		if strings.HasSuffix(name, ".go") {
+			content, err = format.Source(content)
+			if err != nil {
+				return fmt.Errorf("formatting %s: %v", name, err)
+			}
+		}
+		file := filepath.Join(dir, name)
+		log.Printf("[INFO] Writing %s", file)
+		if err := os.WriteFile(file, content, 0o644); err != nil {
+			return fmt.Errorf("writing %s: %v", file, err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,80 @@
+package xcaddycmd
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestScaffoldName(t *testing.T) {
+	for modulePath, expected := range map[string]string{
+		"github.com/me/myplugin":             "myplugin",
+		"github.com/me/caddy-ratelimit":      "ratelimit",
+		"github.com/me/caddy-ratelimit/v2":   "ratelimit",
+		"github.com/me/Geo-IP-caddy":         "geo_ip",
+		"github.com/caddy-dns/cloudflare":    "cloudflare",
+		"example.com/plugins/rate.limit.v1_": "rate_limit_v1",
+	} {
+		if got := scaffoldName(modulePath); got != expected {
+			t.Errorf("scaffoldName(%s): expected %s, got %s", modulePath, expected, got)
+		}
+	}
+}
+
+func TestNewScaffoldData(t *testing.T) {
+	data, err := newScaffoldData("dns.provider", "github.com/me/caddy-dns-example", "")
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expected := scaffoldData{
+		Module:          "github.com/me/caddy-dns-example",
+		Package:         "dnsexample",
+		Name:            "dns_example",
+		ID:              "dns.providers.dns_example",
+		EnvName:         "DNS_EXAMPLE",
+		KindDescription: "DNS provider",
+	}
+	if data != expected {
+		t.Errorf("Expected %+v, got %+v", expected, data)
+	}
+
+	for _, args := range [][3]string{
+		{"router", "github.com/me/myplugin", ""},
+		{"handler", "myplugin", ""},
+		{"handler", "github.com/me/myplugin", "My-Plugin"},
+		{"handler", "github.com/me/42", ""},
+	} {
+		if _, err := newScaffoldData(args[0], args[1], args[2]); err == nil {
+			t.Errorf("Expected an error for %v", args)
+		}
+	}
+}
+
+func TestWriteScaffold(t *testing.T) {
+	for kind := range scaffoldKinds {
+		t.Run(kind, func(t *testing.T) {
+			data, err := newScaffoldData(kind, "github.com/me/caddy-myplugin", "")
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			dir := t.TempDir()
+			if err := writeScaffold(dir, kind, data); err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			for _, name := range []string{"myplugin.go", "myplugin_test.go", "Caddyfile", "README.md", ".gitignore"} {
+				if _, err := os.Stat(filepath.Join(dir, name)); err != nil {
+					t.Errorf("Expected %s to be written: %v", name, err)
+				}
+			}
+
+			// the generated module must pass its own checks
+			diagnostics, err := vetModule(dir)
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			for _, d := range diagnostics {
+				t.Errorf("Unexpected diagnostic: %s", d)
+			}
+		})
+	}
+}
@@ -0,0 +1,282 @@
+package xcaddycmd
+
+import (
+	"context"
+	"debug/buildinfo"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"runtime/debug"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/Masterminds/semver/v3"
+	"github.com/caddyserver/xcaddy"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	inspectCommand.Flags().Bool("json", false, "print the result as JSON")
+	inspectCommand.Flags().Bool("updates", true, "check the module proxy for newer releases of each plugin, and how well maintained it looks")
+}
+
+var inspectCommand = &cobra.Command{
+	Use: `inspect <binary>
+    [--json]
+    [--updates=false]`,
+	Short: "Prints how a Caddy binary was built",
+	Long: `
+Reads the Go build information of a Caddy binary and prints its Caddy version,
+its plugins with their versions, and the build settings such as tags, ldflags and
+cgo. Binaries built by xcaddy also record how xcaddy was invoked, which is used to
+tell plugins apart from other dependencies.
+
+Flags:
+ --json prints the result as JSON instead of a table.
+
+ --updates checks the module proxy for a newer release of each plugin; enabled by default.
+ It also shows the date of the latest release of each plugin, the Caddy version required
+ by its go.mod file, and whether its repository is archived (GitHub only; set GITHUB_TOKEN
+ for a higher rate limit of the API), which help to avoid abandoned plugins.
+`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		asJSON, err := cmd.Flags().GetBool("json")
+		if err != nil {
+			return fmt.Errorf("unable to parse --json arguments: %s", err.Error())
+		}
+		checkUpdates, err := cmd.Flags().GetBool("updates")
+		if err != nil {
+			return fmt.Errorf("unable to parse --updates arguments: %s", err.Error())
+		}
+
+		result, err := inspectBinary(args[0])
+		if err != nil {
+			return err
+		}
+		if checkUpdates {
+			for i := range result.Plugins {
+				checkPlugin(cmd.Root().Context(), &result.Plugins[i])
+			}
+		}
+
+		if asJSON {
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetIndent("", "\t")
+			return enc.Encode(result)
+		}
+		return result.print(os.Stdout)
+	},
+}
+
+// inspectResult describes how a Caddy binary was built.
+type inspectResult struct {
+	Path         string             `json:"path"`
+	GoVersion    string             `json:"go_version"`
+	CaddyVersion string             `json:"caddy_version"`
+	Plugins      []inspectedModule  `json:"plugins"`
+	Dependencies []inspectedModule  `json:"dependencies,omitempty"`
+	Settings     map[string]string  `json:"settings,omitempty"`
+	Invocation   *xcaddy.Invocation `json:"invocation,omitempty"`
+}
+
+// inspectedModule is a module which is part of a binary.
+type inspectedModule struct {
+	Path    string `json:"path"`
+	Version string `json:"version"`
+	Replace string `json:"replace,omitempty"`
+	Latest  string `json:"latest,omitempty"`
+
+	// the date of the latest release, the version of Caddy it
+	// requires and whether the repository is archived, which
+	// help to avoid abandoned plugins; see checkPlugin
+	Released      string `json:"released,omitempty"`
+	RequiresCaddy string `json:"requires_caddy,omitempty"`
+	Archived      bool   `json:"archived,omitempty"`
+}
+
+// inspectBinary reads the build information and, if
+// recorded by xcaddy, the invocation from a binary.
+func inspectBinary(file string) (inspectResult, error) {
+	bi, inv, err := readBinary(file)
+	if err != nil {
+		return inspectResult{}, err
+	}
+	result := newInspectResult(bi, inv)
+	result.Path = file
+	return result, nil
+}
+
+// readBinary reads the build information of a binary, along with
+// the invocation recorded by xcaddy if there is one (binaries not
+// built by xcaddy, or older versions of it, have none).
+func readBinary(file string) (*debug.BuildInfo, *xcaddy.Invocation, error) {
+	bi, err := buildinfo.ReadFile(file)
+	if err != nil {
+		return nil, nil, fmt.Errorf("reading build info of %s: %v", file, err)
+	}
+	binary, err := os.ReadFile(file)
+	if err != nil {
+		return nil, nil, err
+	}
+	inv, _ := xcaddy.ReadInvocation(binary)
+	return bi, inv, nil
+}
+
+// newInspectResult interprets the build information of a Caddy binary.
+// Plugins can only be told apart from other dependencies if inv, the
+// invocation recorded by xcaddy, is available; otherwise all modules
+// are listed as dependencies.
+func newInspectResult(bi *debug.BuildInfo, inv *xcaddy.Invocation) inspectResult {
+	result := inspectResult{
+		GoVersion:  bi.GoVersion,
+		Settings:   make(map[string]string),
+		Invocation: inv,
+	}
+	for _, s := range bi.Settings {
+		switch s.Key {
+		case "-tags", "-ldflags", "-gcflags", "-trimpath", "-race", "CGO_ENABLED", "GOOS", "GOARCH", "GOARM", "GOAMD64":
+			result.Settings[s.Key] = s.Value
+		}
+	}
+
+	pluginModules := make(map[string]bool)
+	if inv != nil {
+		for _, p := range inv.Plugins {
+			if mod := moduleOf(p.PackagePath, bi.Deps); mod != nil {
+				pluginModules[mod.Path] = true
+			}
+		}
+	}
+
+	for _, dep := range bi.Deps {
+		mod := inspectedModule{Path: dep.Path, Version: dep.Version}
+		if dep.Replace != nil {
+			mod.Replace = xcaddy.Dependency{PackagePath: dep.Replace.Path, Version: dep.Replace.Version}.String()
+		}
+		if strings.HasPrefix(dep.Path, "github.com/caddyserver/caddy/") {
+			result.CaddyVersion = caddyVersionOf(dep, result.Settings["-ldflags"])
+			continue
+		}
+		if pluginModules[dep.Path] {
+			result.Plugins = append(result.Plugins, mod)
+		} else {
+			result.Dependencies = append(result.Dependencies, mod)
+		}
+	}
+	return result
+}
+
+// caddyVersionOf returns the version of the Caddy module dep of
+// a binary built with ldflags: the version Caddy reports, if it
+// was set with -X, like for a Caddy replaced by a directory, or
+// else the version of its replacement, like a fork, if any.
+func caddyVersionOf(dep *debug.Module, ldflags string) string {
+	for _, field := range strings.Fields(ldflags) {
+		if version, ok := strings.CutPrefix(field, dep.Path+".CustomVersion="); ok {
+			return version
+		}
+	}
+	if dep.Replace != nil && dep.Replace.Version != "" {
+		return dep.Replace.Version
+	}
+	return dep.Version
+}
+
+// moduleOf returns the module among deps which contains the package
+// at packagePath, or nil if there is none. A package belongs to the
+// module with the longest matching path, since modules may be nested.
+func moduleOf(packagePath string, deps []*debug.Module) *debug.Module {
+	var mod *debug.Module
+	for _, dep := range deps {
+		if packagePath != dep.Path && !strings.HasPrefix(packagePath, dep.Path+"/") {
+			continue
+		}
+		if mod == nil || len(dep.Path) > len(mod.Path) {
+			mod = dep
+		}
+	}
+	return mod
+}
+
+// checkPlugin looks up the latest release of the plugin mod, filling
+// in a newer version, if any, and the details which help to tell whether
+// it is still maintained. Lookups which fail are skipped, since all of
+// this is informational.
+func checkPlugin(ctx context.Context, mod *inspectedModule) {
+	if mod.Replace != "" {
+		return
+	}
+	release, err := latestRelease(ctx, mod.Path)
+	if err != nil {
+		return
+	}
+	mod.Latest = newerVersion(mod.Version, release.Version)
+	if !release.Time.IsZero() {
+		mod.Released = release.Time.UTC().Format(time.DateOnly)
+	}
+	mod.RequiresCaddy = release.Caddy
+	if release.Repository != "" {
+		mod.Archived, _ = repositoryArchived(ctx, release.Repository)
+	}
+}
+
+// newerVersion returns latest if it is newer
+// than current, or an empty string otherwise.
+func newerVersion(current, latest string) string {
+	currentVer, err := semver.NewVersion(current)
+	if err != nil {
+		return ""
+	}
+	latestVer, err := semver.NewVersion(latest)
+	if err != nil || !latestVer.GreaterThan(currentVer) {
+		return ""
+	}
+	return latest
+}
+
+func (r inspectResult) print(out io.Writer) error {
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(w, "Binary:\t%s\n", r.Path)
+	fmt.Fprintf(w, "Caddy version:\t%s\n", r.CaddyVersion)
+	fmt.Fprintf(w, "Go version:\t%s\n", r.GoVersion)
+	if r.Invocation != nil {
+		fmt.Fprintf(w, "xcaddy version:\t%s\n", r.Invocation.XcaddyVersion)
+		fmt.Fprintf(w, "xcaddy arguments:\t%s\n", strings.Join(r.Invocation.Args, " "))
+		for _, warning := range r.Invocation.Warnings {
+			fmt.Fprintf(w, "xcaddy warning:\t%s\n", warning)
+		}
+		if len(r.Invocation.TransitivePlugins) > 0 {
+			fmt.Fprintf(w, "Pulled in by plugins:\t%s\n", strings.Join(r.Invocation.TransitivePlugins, ", "))
+		}
+		if len(r.Invocation.InsecureModules) > 0 {
+			fmt.Fprintf(w, "Fetched without TLS verification:\t%s\n", strings.Join(r.Invocation.InsecureModules, ", "))
+		}
+	}
+	for _, key := range sortedKeys(r.Settings) {
+		fmt.Fprintf(w, "%s:\t%s\n", key, r.Settings[key])
+	}
+	if r.Invocation != nil {
+		fmt.Fprintln(w)
+		printModules(w, "PLUGIN", r.Plugins)
+	} else {
+		fmt.Fprintln(w)
+		fmt.Fprintln(w, "Plugins can't be told apart from other dependencies, since the binary wasn't built by this version of xcaddy.")
+		fmt.Fprintln(w)
+		printModules(w, "DEPENDENCY", r.Dependencies)
+	}
+	return w.Flush()
+}
+
+func printModules(w io.Writer, kind string, mods []inspectedModule) {
+	fmt.Fprintf(w, "%s\tVERSION\tREPLACED BY\tNEWER RELEASE\tLAST RELEASE\tREQUIRES CADDY\tARCHIVED\n", kind)
+	for _, m := range mods {
+		archived := ""
+		if m.Archived {
+			archived = "yes"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n", m.Path, m.Version, m.Replace, m.Latest, m.Released, m.RequiresCaddy, archived)
+	}
+}
@@ -0,0 +1,89 @@
+package xcaddycmd
+
+import (
+	"reflect"
+	"runtime/debug"
+	"testing"
+
+	"github.com/caddyserver/xcaddy"
+)
+
+func TestNewInspectResult(t *testing.T) {
+	bi := &debug.BuildInfo{
+		GoVersion: "go1.23.0",
+		Main:      debug.Module{Path: "caddy"},
+		Deps: []*debug.Module{
+			{Path: "github.com/caddyserver/caddy/v2", Version: "v2.8.4"},
+			{Path: "github.com/caddy-dns/cloudflare", Version: "v0.0.0-20240703190432-89f16b99c18e"},
+			{Path: "github.com/dunglas/mercure", Version: "v0.16.3"},
+			{Path: "github.com/dunglas/mercure/caddy", Version: "v0.16.3", Replace: &debug.Module{Path: "/src/mercure/caddy"}},
+			{Path: "golang.org/x/net", Version: "v0.28.0"},
+		},
+		Settings: []debug.BuildSetting{
+			{Key: "-ldflags", Value: "-w -s"},
+			{Key: "-tags", Value: "nobadger,nomysql,nopgx"},
+			{Key: "-trimpath", Value: "true"},
+			{Key: "CGO_ENABLED", Value: "0"},
+			{Key: "vcs.revision", Value: "abc"},
+		},
+	}
+	inv := &xcaddy.Invocation{
+		Args: []string{"build", "--with", "github.com/caddy-dns/cloudflare", "--with", "github.com/dunglas/mercure/caddy=/src/mercure/caddy"},
+		Plugins: []xcaddy.Dependency{
+			{PackagePath: "github.com/caddy-dns/cloudflare"},
+			{PackagePath: "github.com/dunglas/mercure/caddy"},
+		},
+	}
+
+	got := newInspectResult(bi, inv)
+	if got.CaddyVersion != "v2.8.4" {
+		t.Errorf("Expected Caddy version 'v2.8.4' but got '%s'", got.CaddyVersion)
+	}
+	expectedPlugins := []inspectedModule{
+		{Path: "github.com/caddy-dns/cloudflare", Version: "v0.0.0-20240703190432-89f16b99c18e"},
+		{Path: "github.com/dunglas/mercure/caddy", Version: "v0.16.3", Replace: "/src/mercure/caddy"},
+	}
+	if !reflect.DeepEqual(got.Plugins, expectedPlugins) {
+		t.Errorf("Expected plugins '%v' but got '%v'", expectedPlugins, got.Plugins)
+	}
+	expectedDependencies := []inspectedModule{
+		{Path: "github.com/dunglas/mercure", Version: "v0.16.3"},
+		{Path: "golang.org/x/net", Version: "v0.28.0"},
+	}
+	if !reflect.DeepEqual(got.Dependencies, expectedDependencies) {
+		t.Errorf("Expected dependencies '%v' but got '%v'", expectedDependencies, got.Dependencies)
+	}
+	expectedSettings := map[string]string{
+		"-ldflags":    "-w -s",
+		"-tags":       "nobadger,nomysql,nopgx",
+		"-trimpath":   "true",
+		"CGO_ENABLED": "0",
+	}
+	if !reflect.DeepEqual(got.Settings, expectedSettings) {
+		t.Errorf("Expected settings '%v' but got '%v'", expectedSettings, got.Settings)
+	}
+}
+
+func TestCaddyVersionOf(t *testing.T) {
+	caddy := "github.com/caddyserver/caddy/v2"
+	for i, tc := range []struct {
+		dep     *debug.Module
+		ldflags string
+		want    string
+	}{
+		{dep: &debug.Module{Path: caddy, Version: "v2.8.4"}, ldflags: "-w -s", want: "v2.8.4"},
+		{
+			dep:  &debug.Module{Path: caddy, Version: "v2.8.4", Replace: &debug.Module{Path: "github.com/me/caddy/v2", Version: "v2.8.5-0.20240801120000-0123456789ab"}},
+			want: "v2.8.5-0.20240801120000-0123456789ab",
+		},
+		{
+			dep:     &debug.Module{Path: caddy, Version: "v2.0.0", Replace: &debug.Module{Path: "/src/caddy"}},
+			ldflags: "-w -s -X " + caddy + ".CustomVersion=v2.8.4-3-g0123456789ab",
+			want:    "v2.8.4-3-g0123456789ab",
+		},
+	} {
+		if got := caddyVersionOf(tc.dep, tc.ldflags); got != tc.want {
+			t.Errorf("Test %d: expected %s, got %s", i, tc.want, got)
+		}
+	}
+}
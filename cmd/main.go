@@ -41,6 +41,9 @@ var (
 	buildDebugOutput = os.Getenv("XCADDY_DEBUG") == "1"
 	buildFlags       = os.Getenv("XCADDY_GO_BUILD_FLAGS")
 	modFlags         = os.Getenv("XCADDY_GO_MOD_FLAGS")
+	caddyGitFallback = os.Getenv("XCADDY_GIT_FALLBACK") == "1"
+	caddyRepository  = os.Getenv("XCADDY_CADDY_REPO")
+	skipPreflight    = os.Getenv("XCADDY_SKIP_PREFLIGHT") == "1"
 )
 
 func Main() {
@@ -48,12 +51,35 @@ func Main() {
 	defer cancel()
 	go trapSignals(ctx, cancel)
 
-	if err := rootCmd.Execute(); err != nil {
-		fmt.Println(err)
-		os.Exit(1)
+	if err := rootCmd.ExecuteContext(ctx); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(exitCode(err))
 	}
 }
 
+// exitCodes are the exit statuses of xcaddy by the class of
+// failure, so scripts can tell them apart; it exits with 1
+// for any other error. 130 is what shells report for a
+// process interrupted with Ctrl+C, and 124 what timeout(1)
+// exits with when the command times out.
+var exitCodes = map[xcaddy.Failure]int{
+	xcaddy.FailureResolution:   3,
+	xcaddy.FailureCompile:      4,
+	xcaddy.FailureVerification: 5,
+	xcaddy.FailurePolicy:       6,
+	xcaddy.FailureEnvironment:  7,
+	xcaddy.FailureTimeout:      124,
+	xcaddy.FailureCanceled:     130,
+}
+
+// exitCode returns the exit status for err.
+func exitCode(err error) int {
+	if code, ok := exitCodes[xcaddy.FailureOf(err)]; ok {
+		return code
+	}
+	return 1
+}
+
 func getCaddyOutputFile() string {
 	f := "." + string(filepath.Separator) + "caddy"
 	// compiling for Windows or compiling on windows without setting GOOS, use .exe extension
@@ -158,17 +184,78 @@ func parseGoListJson(out []byte) (currentModule, moduleDir string, replacements
 	return
 }
 
+// goModFile is the structure that fits the output
+// of the `go mod edit -json` command.
+type goModFile struct {
+	Require []struct {
+		Path    string
+		Version string
+	}
+	Exclude []struct {
+		Path    string
+		Version string
+	}
+	Replace []struct {
+		Old module
+		New module
+	}
+}
+
+// readGoMod reads the replace, exclude and require directives of the
+// go.mod file at goModPath. Relative replacement paths are resolved
+// against the directory containing the go.mod file.
+func readGoMod(goModPath string) (replacements []xcaddy.Replace, excludes, requires []xcaddy.Dependency, err error) {
+	absGoModPath, err := filepath.Abs(goModPath)
+	if err != nil {
+		return nil, nil, nil, err
+	}
+	cmd := exec.Command(utils.GetGo(), "mod", "edit", "-json", absGoModPath)
+	cmd.Stderr = os.Stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return nil, nil, nil, fmt.Errorf("exec %v: %v", cmd.Args, err)
+	}
+	return parseGoModJson(out, filepath.Dir(absGoModPath))
+}
+
+func parseGoModJson(out []byte, goModDir string) (replacements []xcaddy.Replace, excludes, requires []xcaddy.Dependency, err error) {
+	var gomod goModFile
+	if err = json.Unmarshal(out, &gomod); err != nil {
+		return
+	}
+	for _, r := range gomod.Replace {
+		old := xcaddy.Dependency{PackagePath: r.Old.Path, Version: r.Old.Version}.String()
+		dst := xcaddy.Dependency{PackagePath: r.New.Path, Version: r.New.Version}.String()
+		if r.New.Version == "" && !filepath.IsAbs(dst) {
+			dst = filepath.Join(goModDir, dst)
+			log.Printf("[INFO] Resolved relative replacement %s to %s", r.New.Path, dst)
+		}
+		replacements = append(replacements, xcaddy.NewReplace(old, dst))
+	}
+	for _, e := range gomod.Exclude {
+		excludes = append(excludes, xcaddy.Dependency{PackagePath: e.Path, Version: e.Version})
+	}
+	for _, r := range gomod.Require {
+		requires = append(requires, xcaddy.Dependency{PackagePath: r.Path, Version: r.Version})
+	}
+	return
+}
+
 func normalizeImportPath(currentModule, cwd, moduleDir string) string {
 	return path.Join(currentModule, filepath.ToSlash(strings.TrimPrefix(cwd, moduleDir)))
 }
 
 func trapSignals(ctx context.Context, cancel context.CancelFunc) {
 	sig := make(chan os.Signal, 1)
-	signal.Notify(sig, os.Interrupt)
+	signal.Notify(sig, trappedSignals...)
 
 	select {
-	case <-sig:
-		log.Printf("[INFO] SIGINT: Shutting down")
+	case s := <-sig:
+		name := "SIGINT"
+		if s != os.Interrupt {
+			name = s.String()
+		}
+		log.Printf("[INFO] %s: Shutting down", name)
 		cancel()
 	case <-ctx.Done():
 		return
@@ -194,7 +281,7 @@ func splitWith(arg string) (module, version, replace string, err error) {
 	} else {
 		module, version = module[:lastVersionSplit], module[lastVersionSplit+1:]
 		if replaceIdx := strings.Index(version, replaceSplit); replaceIdx >= 0 {
-			version, replace = module[:replaceIdx], module[replaceIdx+1:]
+			version, replace = version[:replaceIdx], version[replaceIdx+1:]
 		}
 	}
 
@@ -205,6 +292,117 @@ func splitWith(arg string) (module, version, replace string, err error) {
 	return
 }
 
+// resolveReplacementPath expands a leading ~ and environment variables
+// in repl, the target of a replacement. If it then refers to a local
+// directory, it is made absolute and must contain a go.mod file.
+// Otherwise it is a module path, which is returned as-is. Paths with
+// the {name} placeholder of a pattern are not checked, since they
+// don't refer to a single directory.
+func resolveReplacementPath(repl string) (string, error) {
+	expanded := os.ExpandEnv(repl)
+	if expanded == "~" || strings.HasPrefix(expanded, "~/") || strings.HasPrefix(expanded, `~\`) {
+		home, err := os.UserHomeDir()
+		if err != nil {
+			return "", err
+		}
+		expanded = filepath.Join(home, expanded[1:])
+	}
+
+	if strings.Contains(expanded, "{name}") {
+		if isDirectoryPath(expanded) {
+			return filepath.Abs(expanded)
+		}
+		return repl, nil
+	}
+
+	// like for the go command, a directory must be given as a path
+	// which can't be a module path, so a directory which happens to
+	// be named like a module, such as github.com in the current
+	// directory, doesn't take its place
+	info, statErr := os.Stat(expanded)
+	if !isDirectoryPath(expanded) {
+		if !strings.Contains(expanded, "@") && statErr == nil && info.IsDir() {
+			return "", fmt.Errorf("%s is a module path without a version; to replace with the directory, use .%c%s", expanded, filepath.Separator, expanded)
+		}
+		return repl, nil
+	}
+	if statErr != nil || !info.IsDir() {
+		return "", fmt.Errorf("%s is not a directory", expanded)
+	}
+	abs, err := filepath.Abs(expanded)
+	if err != nil {
+		return "", err
+	}
+	if _, err := os.Stat(filepath.Join(abs, "go.mod")); err != nil {
+		return "", fmt.Errorf("%s is not a Go module: no go.mod file found", abs)
+	}
+	return abs, nil
+}
+
+// isDirectoryPath returns true if p is a path to a directory rather
+// than a module path, by the same rule as the go command for the
+// targets of replacements: it starts with ./ or ../, or is absolute.
+func isDirectoryPath(p string) bool {
+	return p == "." || p == ".." ||
+		strings.HasPrefix(p, "./") || strings.HasPrefix(p, "../") ||
+		strings.HasPrefix(p, `.\`) || strings.HasPrefix(p, `..\`) ||
+		strings.HasPrefix(p, "/") || strings.HasPrefix(p, `\`) ||
+		filepath.IsAbs(p)
+}
+
+// parsePatch parses a --patch argument of the form module=file.
+// Relative patch file paths are resolved against the current
+// working directory since the build happens elsewhere.
+func parsePatch(arg string) (xcaddy.Patch, error) {
+	mod, file, found := strings.Cut(arg, "=")
+	if !found || mod == "" || file == "" {
+		return xcaddy.Patch{}, fmt.Errorf("patch must be of the form module=path/to/file.patch: %s", arg)
+	}
+	absFile, err := filepath.Abs(file)
+	if err != nil {
+		return xcaddy.Patch{}, err
+	}
+	return xcaddy.Patch{
+		ModulePath: strings.TrimSuffix(mod, "/"),
+		File:       absFile,
+	}, nil
+}
+
+// parseModuleProxy parses a --module-proxy argument
+// of the form prefix=url.
+func parseModuleProxy(arg string) (xcaddy.ModuleProxy, error) {
+	prefix, proxy, _ := strings.Cut(arg, "=")
+	p := xcaddy.ModuleProxy{
+		Prefix: strings.TrimSuffix(prefix, "/"),
+		Proxy:  strings.TrimSuffix(proxy, "/"),
+	}
+	if p.Prefix == "" || p.Proxy == "" {
+		return xcaddy.ModuleProxy{}, fmt.Errorf("module proxy must be of the form prefix=url: %s", arg)
+	}
+	return p, p.Validate()
+}
+
+// parseKeyValues parses the arguments of a flag of the form
+// key=value, like --define, where what names a key in errors;
+// the value may contain = itself and be empty.
+func parseKeyValues(args []string, what string) (map[string]string, error) {
+	if len(args) == 0 {
+		return nil, nil
+	}
+	values := make(map[string]string)
+	for _, arg := range args {
+		key, value, found := strings.Cut(arg, "=")
+		if !found || key == "" {
+			return nil, fmt.Errorf("%s must be of the form key=value: %s", what, arg)
+		}
+		if _, ok := values[key]; ok {
+			return nil, fmt.Errorf("%s %s is given more than once", what, key)
+		}
+		values[key] = value
+	}
+	return values, nil
+}
+
 // xcaddyVersion returns a detailed version string, if available.
 func xcaddyVersion() string {
 	mod := goModule()
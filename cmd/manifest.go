@@ -0,0 +1,45 @@
+package xcaddycmd
+
+import (
+	"os"
+
+	"github.com/caddyserver/xcaddy"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	manifestCommand.AddCommand(manifestSchemaCommand)
+}
+
+var manifestCommand = &cobra.Command{
+	Use:   "manifest",
+	Short: "Works with manifests",
+	Long: `
+A manifest is a JSON file with the fields of xcaddy.Builder, like xcaddy.json,
+which describes a build, or a YAML file with the same fields, like xcaddy.yaml. Manifests are written by the import and adapt-manifest
+commands, and read by the commands with a --manifest flag, which reject fields
+they don't know and values of the wrong type, naming where they are, like
+plugins[3].version.
+`,
+}
+
+var manifestSchemaCommand = &cobra.Command{
+	Use:   "schema",
+	Short: "Prints the JSON Schema of manifests",
+	Long: `
+Prints the JSON Schema of manifests, which editors can use to complete and check
+them. Save it next to a manifest and refer to it from the manifest, like with
+"$schema": "./xcaddy.schema.json", or configure the editor to use it for files
+named xcaddy.json. YAML manifests refer to it with a comment for the YAML
+language server, like # yaml-language-server: $schema=./xcaddy.schema.json
+`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		schema, err := xcaddy.ManifestSchema()
+		if err != nil {
+			return err
+		}
+		_, err = os.Stdout.Write(append(schema, '\n'))
+		return err
+	},
+}
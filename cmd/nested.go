@@ -0,0 +1,111 @@
+package xcaddycmd
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+
+	"github.com/caddyserver/xcaddy"
+	"github.com/caddyserver/xcaddy/internal/utils"
+)
+
+// checkNestedModule checks whether the module being developed, with
+// the path modulePath in moduleDir, is nested in the folder of another
+// module, like one for the examples or tests of a plugin, and depends
+// on that module without building it from its local copy, which would
+// build the wrong code. If so, it returns an error with guidance, or,
+// if XCADDY_REPLACE_PARENT=1 is set, adds a replacement with the local
+// copy to replacements. listed is the output of go list -m -json all.
+func checkNestedModule(modulePath, moduleDir string, listed []byte, replacements []xcaddy.Replace) ([]xcaddy.Replace, error) {
+	parentDir := parentModuleDir(moduleDir)
+	if parentDir == "" {
+		return replacements, nil
+	}
+	parentPath, err := goModModulePath(filepath.Join(parentDir, "go.mod"))
+	if err != nil {
+		return nil, err
+	}
+	for _, r := range replacements {
+		if r.Old.String() == parentPath {
+			return replacements, nil
+		}
+	}
+	graph, err := listedModulePaths(listed)
+	if err != nil {
+		return nil, err
+	}
+	if !graph[parentPath] {
+		// an independent module which happens to be nested
+		return replacements, nil
+	}
+
+	if os.Getenv("XCADDY_REPLACE_PARENT") == "1" {
+		log.Printf("[INFO] Replacing %s, which %s is nested in, with its local copy: %s", parentPath, modulePath, parentDir)
+		return append(replacements, xcaddy.NewReplace(parentPath, parentDir)), nil
+	}
+	rel, err := filepath.Rel(moduleDir, parentDir)
+	if err != nil {
+		rel = parentDir
+	}
+	return nil, fmt.Errorf("the current folder is in module %s, which is nested in the folder of module %s (%s), "+
+		"but uses a published version of it instead of the local copy; "+
+		"run xcaddy in %s to develop %s, add 'replace %s => %s' to the go.mod of %s, "+
+		"or set XCADDY_REPLACE_PARENT=1 to build both from their local copies",
+		modulePath, parentPath, parentDir, parentDir, parentPath, parentPath, filepath.ToSlash(rel), modulePath)
+}
+
+// parentModuleDir returns the closest folder above moduleDir
+// which has a go.mod file, or an empty string if there is none.
+func parentModuleDir(moduleDir string) string {
+	dir := filepath.Dir(moduleDir)
+	for {
+		if _, err := os.Stat(filepath.Join(dir, "go.mod")); err == nil {
+			return dir
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return ""
+		}
+		dir = parent
+	}
+}
+
+// goModModulePath returns the module path declared in goModPath.
+func goModModulePath(goModPath string) (string, error) {
+	cmd := exec.Command(utils.GetGo(), "mod", "edit", "-json", goModPath)
+	cmd.Stderr = os.Stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("exec %v: %v", cmd.Args, err)
+	}
+	var gomod struct {
+		Module struct {
+			Path string
+		}
+	}
+	if err := json.Unmarshal(out, &gomod); err != nil {
+		return "", err
+	}
+	return gomod.Module.Path, nil
+}
+
+// listedModulePaths returns the paths of the modules
+// in listed, the output of go list -m -json.
+func listedModulePaths(listed []byte) (map[string]bool, error) {
+	paths := make(map[string]bool)
+	decoder := json.NewDecoder(bytes.NewReader(listed))
+	for {
+		var mod module
+		if err := decoder.Decode(&mod); err == io.EOF {
+			return paths, nil
+		} else if err != nil {
+			return nil, err
+		}
+		paths[mod.Path] = true
+	}
+}
@@ -0,0 +1,75 @@
+package xcaddycmd
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/caddyserver/xcaddy"
+)
+
+func TestCheckNestedModule(t *testing.T) {
+	parentDir := t.TempDir()
+	moduleDir := filepath.Join(parentDir, "examples")
+	if err := os.Mkdir(moduleDir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(parentDir, "go.mod"), []byte("module example.com/plugin\n\ngo 1.21\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(moduleDir, "go.mod"), []byte("module example.com/plugin/examples\n\ngo 1.21\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	const modulePath = "example.com/plugin/examples"
+	own := []xcaddy.Replace{xcaddy.NewReplace(modulePath, moduleDir)}
+	withParent := []byte(`{"Path": "example.com/plugin/examples", "Main": true, "Dir": "` + filepath.ToSlash(moduleDir) + `"}
+{"Path": "example.com/plugin", "Version": "v0.1.0"}
+`)
+	withoutParent := []byte(`{"Path": "example.com/plugin/examples", "Main": true, "Dir": "` + filepath.ToSlash(moduleDir) + `"}
+{"Path": "example.com/other", "Version": "v0.1.0"}
+`)
+
+	tests := []struct {
+		name          string
+		moduleDir     string
+		listed        []byte
+		replacements  []xcaddy.Replace
+		replaceParent bool
+		want          []xcaddy.Replace
+		wantErr       bool
+	}{
+		{name: "not nested", moduleDir: parentDir, listed: withParent, replacements: own, want: own},
+		{name: "independent", moduleDir: moduleDir, listed: withoutParent, replacements: own, want: own},
+		{name: "published parent", moduleDir: moduleDir, listed: withParent, replacements: own, wantErr: true},
+		{
+			name:         "replaced parent",
+			moduleDir:    moduleDir,
+			listed:       withParent,
+			replacements: append(own[:1:1], xcaddy.NewReplace("example.com/plugin", parentDir)),
+			want:         append(own[:1:1], xcaddy.NewReplace("example.com/plugin", parentDir)),
+		},
+		{
+			name:          "replace parent",
+			moduleDir:     moduleDir,
+			listed:        withParent,
+			replacements:  own,
+			replaceParent: true,
+			want:          append(own[:1:1], xcaddy.NewReplace("example.com/plugin", parentDir)),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if tt.replaceParent {
+				t.Setenv("XCADDY_REPLACE_PARENT", "1")
+			}
+			got, err := checkNestedModule(modulePath, tt.moduleDir, tt.listed, tt.replacements)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("checkNestedModule() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("checkNestedModule() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,165 @@
+package xcaddycmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/caddyserver/xcaddy"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	planCommand.Flags().String("manifest", "", "the manifest of the build to plan, like xcaddy.json")
+	planCommand.Flags().StringArray("platforms", []string{}, "plans builds for several platforms, as os/arch or a bundle like common or all-first-class")
+	planCommand.Flags().Bool("json", false, "print the plan as JSON")
+	addBuilderFlags(planCommand.Flags())
+}
+
+var planCommand = &cobra.Command{
+	Use: `plan [--manifest <file>]
+    [--platforms <os/arch[/arm]|bundle>...]
+    [--json]
+    [<caddy_version>] [<flags of the build command>...]`,
+	Short: "Resolves a build and prints what would be built, without building",
+	Long: `
+Resolves the versions of Caddy and the plugins of a build, like the build command
+does, and prints what would be built without compiling anything: the selected
+version of Caddy and of each plugin, along with the version it was given with, the
+replacements which apply to modules of the build, the target platforms, cgo, and
+the arguments of go build. Since resolving is quick compared to compiling, this
+lets a build be reviewed and approved before it is made.
+
+The build is configured like the build command is: with a Caddy version and the
+--with, --replace, --patch and other flags, or with a manifest.
+
+Flags:
+ --manifest reads the build from a manifest, which is a JSON or YAML file with the
+ fields of xcaddy.Builder, like for the prefetch command.
+
+ --platforms gives the platforms to build for, like the build command does; the
+ versions are the same for all of them. Defaults to the platform of GOOS and GOARCH.
+
+ --json prints the plan as JSON instead of text, with the fields of xcaddy.Resolution,
+ and the platforms in platforms if --platforms is given.
+`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		manifestFile, err := cmd.Flags().GetString("manifest")
+		if err != nil {
+			return fmt.Errorf("unable to parse --manifest arguments: %s", err.Error())
+		}
+		platformSpecs, err := cmd.Flags().GetStringArray("platforms")
+		if err != nil {
+			return fmt.Errorf("unable to parse --platforms arguments: %s", err.Error())
+		}
+		asJSON, err := cmd.Flags().GetBool("json")
+		if err != nil {
+			return fmt.Errorf("unable to parse --json arguments: %s", err.Error())
+		}
+		builder, err := builderFromFlags(cmd, args)
+		if err != nil {
+			return err
+		}
+		if manifestFile != "" {
+			builder, err = builderFromManifest(cmd, args, manifestFile, builder)
+			if err != nil {
+				return err
+			}
+		}
+		var platforms []xcaddy.Platform
+		if len(platformSpecs) > 0 {
+			platforms, err = xcaddy.ParsePlatforms(cmd.Root().Context(), platformSpecs...)
+			if err != nil {
+				return err
+			}
+		}
+
+		res, err := builder.Resolve(cmd.Root().Context())
+		if err != nil {
+			return err
+		}
+		if len(platforms) == 0 {
+			platforms = []xcaddy.Platform{res.Platform}
+		}
+		if asJSON {
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetIndent("", "\t")
+			return enc.Encode(buildPlan{Resolution: res, Platforms: platforms})
+		}
+		return printPlan(os.Stdout, res, platforms)
+	},
+}
+
+// buildPlan is the plan printed with --json.
+type buildPlan struct {
+	xcaddy.Resolution
+	Platforms []xcaddy.Platform `json:"platforms"`
+}
+
+// printPlan prints res, for a build for platforms, to w.
+func printPlan(w io.Writer, res xcaddy.Resolution, platforms []xcaddy.Platform) error {
+	var names []string
+	for _, p := range platforms {
+		names = append(names, platformName(p))
+	}
+	cgo := "disabled"
+	if res.Cgo {
+		cgo = "enabled"
+	}
+	var buildArgs []string
+	for _, arg := range append([]string{"go"}, res.BuildArgs...) {
+		if arg == "" || strings.ContainsAny(arg, " \t\n\"'\\$`") {
+			arg = shellQuote(arg)
+		}
+		buildArgs = append(buildArgs, arg)
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintf(tw, "Caddy:\t%s %s\n", res.CaddyModule, res.CaddyVersion)
+	fmt.Fprintf(tw, "Platforms:\t%s\n", strings.Join(names, ", "))
+	fmt.Fprintf(tw, "Go:\t%s, cgo %s\n", res.GoVersion, cgo)
+	fmt.Fprintf(tw, "Build:\t%s\n", strings.Join(buildArgs, " "))
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+
+	if len(res.Plugins) > 0 {
+		fmt.Fprintln(w)
+		tw = tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "PLUGIN\tREQUESTED\tSELECTED")
+		for _, p := range res.Plugins {
+			requested := p.Requested
+			if requested == "" {
+				requested = "latest"
+			}
+			selected := p.Version
+			if p.Replace != "" {
+				selected = "=> " + p.Replace
+			}
+			if selected == "" {
+				selected = "missing"
+			}
+			fmt.Fprintf(tw, "%s\t%s\t%s\n", p.PackagePath, requested, selected)
+		}
+		if err := tw.Flush(); err != nil {
+			return err
+		}
+	}
+
+	if len(res.Replacements) > 0 {
+		fmt.Fprintln(w)
+		tw = tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(tw, "REPLACED\tBY")
+		for _, r := range res.Replacements {
+			fmt.Fprintf(tw, "%s\t%s\n", r.Old, r.New)
+		}
+		if err := tw.Flush(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,44 @@
+package xcaddycmd
+
+import (
+	"bytes"
+	"testing"
+
+	"github.com/caddyserver/xcaddy"
+)
+
+func TestPrintPlan(t *testing.T) {
+	res := xcaddy.Resolution{
+		CaddyModule:  "github.com/caddyserver/caddy/v2",
+		CaddyVersion: "v2.8.4",
+		Plugins: []xcaddy.ResolvedPlugin{
+			{PackagePath: "github.com/caddy-dns/cloudflare", Module: "github.com/caddy-dns/cloudflare", Version: "v0.1.0"},
+			{PackagePath: "github.com/me/plugin/caddy", Requested: "v1.2.3", Module: "github.com/me/plugin", Replace: "/src/plugin"},
+		},
+		Replacements: []xcaddy.Replace{
+			xcaddy.NewReplace("github.com/me/plugin", "/src/plugin"),
+		},
+		GoVersion: "go1.23.0",
+		BuildArgs: []string{"build", "-ldflags", "-w -s", "-trimpath", "-tags", "nobadger,nomysql,nopgx"},
+	}
+	platforms := []xcaddy.Platform{{OS: "linux", Arch: "amd64"}, {OS: "linux", Arch: "arm", ARM: "7"}}
+	var buf bytes.Buffer
+	if err := printPlan(&buf, res, platforms); err != nil {
+		t.Fatal(err)
+	}
+	want := `Caddy:      github.com/caddyserver/caddy/v2 v2.8.4
+Platforms:  linux/amd64, linux/arm/v7
+Go:         go1.23.0, cgo disabled
+Build:      go build -ldflags '-w -s' -trimpath -tags nobadger,nomysql,nopgx
+
+PLUGIN                           REQUESTED  SELECTED
+github.com/caddy-dns/cloudflare  latest     v0.1.0
+github.com/me/plugin/caddy       v1.2.3     => /src/plugin
+
+REPLACED              BY
+github.com/me/plugin  /src/plugin
+`
+	if got := buf.String(); got != want {
+		t.Errorf("printPlan() =\n%s\nwant:\n%s", got, want)
+	}
+}
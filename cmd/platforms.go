@@ -0,0 +1,129 @@
+package xcaddycmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+
+	"github.com/caddyserver/xcaddy"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	platformsCommand.Flags().StringArray("os", []string{}, "lists only the platforms of this operating system")
+	platformsCommand.Flags().Bool("cgo", false, "lists only the platforms which support cgo")
+	platformsCommand.Flags().Bool("first-class", false, "lists only the first-class ports of Go")
+	platformsCommand.Flags().StringArray("bundle", []string{}, "lists only the platforms of a bundle, like common or all-first-class")
+	platformsCommand.Flags().Bool("json", false, "print the platforms as JSON")
+}
+
+var platformsCommand = &cobra.Command{
+	Use: `platforms [--os <os>...]
+    [--cgo]
+    [--first-class]
+    [--bundle <name>...]
+    [--json]`,
+	Short: "Lists the platforms Caddy can be built for",
+	Long: `
+Lists the platforms which the go command can build for, which are the values
+of GOOS, GOARCH and GOARM that the build command accepts. The go command is
+the one set by XCADDY_WHICH_GO, if any. This is useful to make a matrix of
+platforms to build for in CI.
+
+Flags:
+ --os lists only the platforms of this operating system, like linux; it can be
+ passed multiple times.
+
+ --cgo lists only the platforms which support cgo.
+
+ --first-class lists only the first-class ports of Go, for which broken builds
+ are blocking issues.
+
+ --bundle lists only the platforms of a bundle, which the --platforms flag of
+ the build command accepts as well: common is linux/amd64, linux/arm64,
+ windows/amd64 and darwin/arm64, and all-first-class is every first-class
+ port. It can be passed multiple times.
+
+ --json prints the platforms as JSON instead of a table.
+`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		oses, err := cmd.Flags().GetStringArray("os")
+		if err != nil {
+			return fmt.Errorf("unable to parse --os arguments: %s", err.Error())
+		}
+		cgoOnly, err := cmd.Flags().GetBool("cgo")
+		if err != nil {
+			return fmt.Errorf("unable to parse --cgo arguments: %s", err.Error())
+		}
+		firstClass, err := cmd.Flags().GetBool("first-class")
+		if err != nil {
+			return fmt.Errorf("unable to parse --first-class arguments: %s", err.Error())
+		}
+		bundles, err := cmd.Flags().GetStringArray("bundle")
+		if err != nil {
+			return fmt.Errorf("unable to parse --bundle arguments: %s", err.Error())
+		}
+		asJSON, err := cmd.Flags().GetBool("json")
+		if err != nil {
+			return fmt.Errorf("unable to parse --json arguments: %s", err.Error())
+		}
+
+		var filters []xcaddy.PlatformFilter
+		if len(oses) > 0 {
+			filters = append(filters, xcaddy.ByOS(oses...))
+		}
+		if cgoOnly {
+			filters = append(filters, xcaddy.CgoOnly)
+		}
+		if firstClass {
+			filters = append(filters, xcaddy.FirstClass)
+		}
+		if len(bundles) > 0 {
+			for _, name := range bundles {
+				if name != xcaddy.PlatformBundleCommon && name != xcaddy.PlatformBundleAllFirstClass {
+					return fmt.Errorf("unknown bundle %q: must be %s or %s", name, xcaddy.PlatformBundleCommon, xcaddy.PlatformBundleAllFirstClass)
+				}
+			}
+			inBundles, err := xcaddy.ParsePlatforms(cmd.Root().Context(), bundles...)
+			if err != nil {
+				return err
+			}
+			filters = append(filters, xcaddy.ByPlatforms(inBundles...))
+		}
+		platforms, err := xcaddy.SupportedPlatformsContext(cmd.Root().Context(), filters...)
+		if err != nil {
+			return err
+		}
+
+		if asJSON {
+			if platforms == nil {
+				platforms = []xcaddy.SupportedPlatform{}
+			}
+			enc := json.NewEncoder(os.Stdout)
+			enc.SetIndent("", "\t")
+			return enc.Encode(platforms)
+		}
+		return printPlatforms(os.Stdout, platforms)
+	},
+}
+
+// printPlatforms prints platforms as a table.
+func printPlatforms(out io.Writer, platforms []xcaddy.SupportedPlatform) error {
+	w := tabwriter.NewWriter(out, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "OS\tARCH\tARM\tCGO\tFIRST-CLASS")
+	for _, p := range platforms {
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", p.OS, p.Arch, p.ARM, yesNo(p.Cgo), yesNo(p.FirstClass))
+	}
+	return w.Flush()
+}
+
+// yesNo formats b for a table.
+func yesNo(b bool) string {
+	if b {
+		return "yes"
+	}
+	return "no"
+}
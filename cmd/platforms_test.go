@@ -0,0 +1,32 @@
+package xcaddycmd
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/caddyserver/xcaddy"
+)
+
+func TestPrintPlatforms(t *testing.T) {
+	var platforms []xcaddy.SupportedPlatform
+	linux := xcaddy.SupportedPlatform{FirstClass: true}
+	linux.OS, linux.Arch, linux.ARM, linux.Cgo = "linux", "arm", "7", true
+	wasm := xcaddy.SupportedPlatform{}
+	wasm.OS, wasm.Arch = "js", "wasm"
+	platforms = append(platforms, linux, wasm)
+
+	var buf bytes.Buffer
+	if err := printPlatforms(&buf, platforms); err != nil {
+		t.Fatal(err)
+	}
+	expected := strings.Join([]string{
+		"OS     ARCH  ARM  CGO  FIRST-CLASS",
+		"linux  arm   7    yes  yes",
+		"js     wasm       no   no",
+		"",
+	}, "\n")
+	if got := buf.String(); got != expected {
+		t.Errorf("printPlatforms():\nexpected:\n%s\ngot:\n%s", expected, got)
+	}
+}
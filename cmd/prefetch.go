@@ -0,0 +1,169 @@
+package xcaddycmd
+
+import (
+	"fmt"
+
+	"github.com/caddyserver/xcaddy"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+)
+
+func init() {
+	prefetchCommand.Flags().String("manifest", "", "the manifest of the build to download the modules of, like xcaddy.json")
+	addBuilderFlags(prefetchCommand.Flags())
+}
+
+var prefetchCommand = &cobra.Command{
+	Use: `prefetch [--manifest <file>]
+    [<caddy_version>] [<flags of the build command>...]`,
+	Short: "Downloads the modules of a build without building",
+	Long: `
+Downloads all modules needed to build Caddy with the given plugins into the module
+cache of the go command (see GOMODCACHE), without building anything. Builds using
+the same module cache don't need the network afterwards, which makes this useful
+as a separate, cached step in CI.
+
+The build is configured like the build command is: with a Caddy version and the
+--with, --replace, --patch and other flags, or with a manifest.
+
+Flags:
+ --manifest reads the build from a manifest, which is a JSON file with the fields
+ of xcaddy.Builder, like the one written by the import command, or a YAML file
+ with the same fields if it is named like xcaddy.yaml. It can't be
+ combined with the Caddy version argument or the flags of the build command,
+ except those which only affect how modules are downloaded: --sandbox, --netrc,
+ --goauth, --proxy, --no-proxy, --ca-cert, --client-cert, --client-key,
+ --insecure-modules, --module-proxy, --modcache, --offline, --replace-root,
+ --ignore-goflags, --go-version and the timeouts.
+ Local paths in the manifest are relative to the current folder. A manifest may
+ extend a base manifest with "extends" and the path of the base, relative to it.
+`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		manifestFile, err := cmd.Flags().GetString("manifest")
+		if err != nil {
+			return fmt.Errorf("unable to parse --manifest arguments: %s", err.Error())
+		}
+		builder, err := builderFromFlags(cmd, args)
+		if err != nil {
+			return err
+		}
+		if manifestFile != "" {
+			builder, err = builderFromManifest(cmd, args, manifestFile, builder)
+			if err != nil {
+				return err
+			}
+		}
+		return builder.Prefetch(cmd.Root().Context())
+	},
+}
+
+// manifestDownloadFlags are the flags of the build command which
+// may be combined with --manifest, since they only affect how the
+// modules are downloaded, not which ones, along with the flags of
+// the commands which take a manifest.
+var manifestDownloadFlags = map[string]bool{
+	"manifest":         true,
+	"inventory":        true,
+	"platforms":        true,
+	"json":             true,
+	"sandbox":          true,
+	"netrc":            true,
+	"goauth":           true,
+	"proxy":            true,
+	"no-proxy":         true,
+	"ca-cert":          true,
+	"client-cert":      true,
+	"client-key":       true,
+	"insecure-modules": true,
+	"module-proxy":     true,
+	"modcache":         true,
+	"offline":          true,
+	"replace-root":     true,
+	"ignore-goflags":   true,
+	"go-version":       true,
+	"timeout-get":      true,
+	"timeout-build":    true,
+	"timeout-compile":  true,
+	"timeout-total":    true,
+}
+
+// builderFromManifest returns the Builder of the manifest in file,
+// with the settings of flags, which was made by builderFromFlags,
+// that only affect how modules are downloaded.
+func builderFromManifest(cmd *cobra.Command, args []string, file string, flags xcaddy.Builder) (xcaddy.Builder, error) {
+	if len(args) > 0 {
+		return xcaddy.Builder{}, fmt.Errorf("the Caddy version can't be given along with --manifest: %s", args[0])
+	}
+	var conflicting string
+	cmd.Flags().Visit(func(f *pflag.Flag) {
+		if !manifestDownloadFlags[f.Name] && conflicting == "" {
+			conflicting = f.Name
+		}
+	})
+	if conflicting != "" {
+		return xcaddy.Builder{}, fmt.Errorf("--%s can't be combined with --manifest; put it in the manifest instead", conflicting)
+	}
+	manifest, err := xcaddy.ReadManifest(file)
+	if err != nil {
+		return xcaddy.Builder{}, err
+	}
+
+	// settings from the command line and environment win
+	manifest.Compile = flags.Compile
+	manifest.SkipCleanup = flags.SkipCleanup
+	manifest.Debug = flags.Debug
+	manifest.SkipPreflight = manifest.SkipPreflight || flags.SkipPreflight
+	if manifest.ModFlags == "" {
+		manifest.ModFlags = flags.ModFlags
+	}
+	if manifest.CaddyVersion == "" {
+		manifest.CaddyVersion = flags.CaddyVersion
+	}
+	if manifest.CaddyRepository == "" {
+		manifest.CaddyRepository = flags.CaddyRepository
+	}
+	manifest.CaddyGitFallback = manifest.CaddyGitFallback || flags.CaddyGitFallback
+	manifest.IgnoreGoFlags = manifest.IgnoreGoFlags || flags.IgnoreGoFlags
+	manifest.Sandbox = manifest.Sandbox || flags.Sandbox
+	if flags.GoVersion != "" {
+		manifest.GoVersion = flags.GoVersion
+	}
+	if flags.Netrc != "" {
+		manifest.Netrc = flags.Netrc
+	}
+	if flags.GoAuth != "" {
+		manifest.GoAuth = flags.GoAuth
+	}
+	// the proxy is never part of a manifest,
+	// since its URL may contain credentials
+	manifest.Proxy = flags.Proxy
+	manifest.NoProxy = flags.NoProxy
+	if flags.CACert != "" {
+		manifest.CACert = flags.CACert
+	}
+	if flags.ClientCert != "" {
+		manifest.ClientCert = flags.ClientCert
+		manifest.ClientKey = flags.ClientKey
+	}
+	manifest.Insecure = append(flags.Insecure, manifest.Insecure...)
+	manifest.ModuleProxies = append(flags.ModuleProxies, manifest.ModuleProxies...)
+	if flags.ModCache != "" {
+		manifest.ModCache = flags.ModCache
+	}
+	manifest.Offline = manifest.Offline || flags.Offline
+	manifest.ReplaceRoots = flags.ReplaceRoots
+	if flags.TimeoutGet != 0 {
+		manifest.TimeoutGet = flags.TimeoutGet
+	}
+	if flags.TimeoutBuild != 0 {
+		manifest.TimeoutBuild = flags.TimeoutBuild
+	}
+	if flags.TimeoutCompile != 0 {
+		manifest.TimeoutCompile = flags.TimeoutCompile
+	}
+	if flags.TimeoutTotal != 0 {
+		manifest.TimeoutTotal = flags.TimeoutTotal
+	}
+	return manifest, nil
+}
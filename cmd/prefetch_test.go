@@ -0,0 +1,78 @@
+package xcaddycmd
+
+import (
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+
+	"github.com/spf13/cobra"
+)
+
+func TestBuilderFromManifest(t *testing.T) {
+	manifest := filepath.Join(t.TempDir(), "xcaddy.json")
+	err := os.WriteFile(manifest, []byte(`{
+	"caddy_version": "v2.8.4",
+	"plugins": [{"module_path": "github.com/caddy-dns/cloudflare", "version": "v0.1.0"}],
+	"module_proxies": [{"prefix": "corp.example.com", "proxy": "https://athens.corp.example.com"}]
+}`), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		name    string
+		file    string
+		args    []string
+		wantErr bool
+	}{
+		{name: "manifest only", args: []string{"--manifest", manifest}},
+		{name: "download flags", args: []string{"--manifest", manifest, "--sandbox", "--go-version", "1.22.5", "--module-proxy", "github.com/corp=https://corp.jfrog.io/api/go/remote", "--replace-root", filepath.Dir(manifest)}},
+		{name: "proxy and TLS flags", args: []string{"--manifest", manifest, "--proxy", "http://proxy.example.com:3128", "--no-proxy", "corp.example.com", "--ca-cert", "ca.pem", "--client-cert", "client.pem", "--client-key", "client.key", "--insecure-modules", "corp.example.com/*"}},
+		{name: "plugins", args: []string{"--manifest", manifest, "--with", "github.com/caddy-dns/route53"}, wantErr: true},
+		{name: "caddy version", args: []string{"--manifest", manifest, "v2.9.0"}, wantErr: true},
+		{name: "missing manifest", file: manifest + ".missing", args: []string{"--manifest", manifest + ".missing"}, wantErr: true},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			cmd := &cobra.Command{}
+			cmd.Flags().String("manifest", "", "")
+			addBuilderFlags(cmd.Flags())
+			if err := cmd.ParseFlags(tt.args); err != nil {
+				t.Fatal(err)
+			}
+			flags, err := builderFromFlags(cmd, cmd.Flags().Args())
+			if err != nil {
+				t.Fatalf("builderFromFlags() error = %v", err)
+			}
+			file := manifest
+			if tt.file != "" {
+				file = tt.file
+			}
+			b, err := builderFromManifest(cmd, cmd.Flags().Args(), file, flags)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("builderFromManifest() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			if b.CaddyVersion != "v2.8.4" || len(b.Plugins) != 1 || b.Plugins[0].Version != "v0.1.0" {
+				t.Errorf("builderFromManifest() = %+v, want the build of the manifest", b)
+			}
+			if b.Sandbox != flags.Sandbox || b.GoVersion != flags.GoVersion {
+				t.Errorf("builderFromManifest() sandbox, go version = %v, %q, want those of the flags", b.Sandbox, b.GoVersion)
+			}
+			if len(b.ModuleProxies) != len(flags.ModuleProxies)+1 {
+				t.Errorf("builderFromManifest() module proxies = %v, want those of the flags and the manifest", b.ModuleProxies)
+			}
+			if b.Proxy != flags.Proxy || b.NoProxy != flags.NoProxy {
+				t.Errorf("builderFromManifest() proxy = %q, %q, want those of the flags", b.Proxy, b.NoProxy)
+			}
+			if b.CACert != flags.CACert || b.ClientCert != flags.ClientCert || b.ClientKey != flags.ClientKey || !slices.Equal(b.Insecure, flags.Insecure) {
+				t.Errorf("builderFromManifest() TLS settings = %q, %q, %q, %v, want those of the flags", b.CACert, b.ClientCert, b.ClientKey, b.Insecure)
+			}
+			if len(b.ReplaceRoots) != len(flags.ReplaceRoots) {
+				t.Errorf("builderFromManifest() replace roots = %v, want those of the flags", b.ReplaceRoots)
+			}
+		})
+	}
+}
@@ -0,0 +1,73 @@
+package xcaddycmd
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// stagingFolder creates a folder next to output to build into with
+// --replace-running, so the binary and the files written next to it
+// can be moved over the old ones without copying.
+func stagingFolder(output string) (string, error) {
+	dir := filepath.Dir(output)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return "", err
+	}
+	return os.MkdirTemp(dir, ".xcaddy-replace-")
+}
+
+// replaceBinary moves the binary built into staging over output,
+// which may be running, along with the files written next to it, like
+// its go.mod. The binary is moved last, so if anything fails before,
+// the old binary is left as it was.
+func replaceBinary(staging, output string) error {
+	entries, err := os.ReadDir(staging)
+	if err != nil {
+		return err
+	}
+	name := filepath.Base(output)
+	dir := filepath.Dir(output)
+	for _, entry := range entries {
+		if entry.Name() == name {
+			continue
+		}
+		err = os.Rename(filepath.Join(staging, entry.Name()), filepath.Join(dir, entry.Name()))
+		if err != nil {
+			return err
+		}
+	}
+	log.Printf("[INFO] Replacing %s", output)
+	return replaceExecutable(filepath.Join(staging, name), output)
+}
+
+// signalPIDFile sends the signal named sig, like TERM or SIGUSR1,
+// to the process whose ID is in pidFile, as written by caddy run
+// with --pidfile.
+func signalPIDFile(pidFile, sig string) error {
+	signal, err := parseSignal(sig)
+	if err != nil {
+		return err
+	}
+	data, err := os.ReadFile(pidFile)
+	if err != nil {
+		return err
+	}
+	pid, err := strconv.Atoi(strings.TrimSpace(string(data)))
+	if err != nil || pid <= 0 {
+		return fmt.Errorf("%s doesn't contain a process ID", pidFile)
+	}
+	process, err := os.FindProcess(pid)
+	if err != nil {
+		return err
+	}
+	log.Printf("[INFO] Sending %s to process %d", signal, pid)
+	err = process.Signal(signal)
+	if err != nil {
+		return fmt.Errorf("signaling process %d: %v", pid, err)
+	}
+	return nil
+}
@@ -0,0 +1,37 @@
+//go:build !windows
+
+package xcaddycmd
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"syscall"
+)
+
+// signalNames are the signals which can be sent with --signal.
+var signalNames = map[string]syscall.Signal{
+	"HUP":  syscall.SIGHUP,
+	"INT":  syscall.SIGINT,
+	"QUIT": syscall.SIGQUIT,
+	"TERM": syscall.SIGTERM,
+	"USR1": syscall.SIGUSR1,
+	"USR2": syscall.SIGUSR2,
+}
+
+// replaceExecutable moves the executable src over dst. A rename
+// replaces the directory entry only, so a process running dst keeps
+// running the old binary until it exits.
+func replaceExecutable(src, dst string) error {
+	return os.Rename(src, dst)
+}
+
+// parseSignal returns the signal named name, with or without
+// the SIG prefix, like TERM or SIGUSR1.
+func parseSignal(name string) (os.Signal, error) {
+	sig, ok := signalNames[strings.TrimPrefix(strings.ToUpper(name), "SIG")]
+	if !ok {
+		return nil, fmt.Errorf("unknown signal %s; use one of HUP, INT, QUIT, TERM, USR1 or USR2", name)
+	}
+	return sig, nil
+}
@@ -0,0 +1,58 @@
+package xcaddycmd
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestReplaceBinary(t *testing.T) {
+	dir := t.TempDir()
+	output := filepath.Join(dir, "caddy")
+	if err := os.WriteFile(output, []byte("old"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	staging, err := stagingFolder(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range map[string]string{"caddy": "new", "caddy.go.mod": "module caddy"} {
+		if err := os.WriteFile(filepath.Join(staging, name), []byte(content), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := replaceBinary(staging, output); err != nil {
+		t.Fatal(err)
+	}
+	for name, want := range map[string]string{"caddy": "new", "caddy.go.mod": "module caddy"} {
+		got, err := os.ReadFile(filepath.Join(dir, name))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(got) != want {
+			t.Errorf("%s = %q, want %q", name, got, want)
+		}
+	}
+	if entries, _ := os.ReadDir(staging); len(entries) != 0 {
+		t.Errorf("staging folder still has %d files", len(entries))
+	}
+}
+
+func TestReplaceBinaryMissing(t *testing.T) {
+	dir := t.TempDir()
+	output := filepath.Join(dir, "caddy")
+	if err := os.WriteFile(output, []byte("old"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	staging, err := stagingFolder(output)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := replaceBinary(staging, output); err == nil {
+		t.Fatal("expected an error without a new binary")
+	}
+	if got, _ := os.ReadFile(output); string(got) != "old" {
+		t.Errorf("old binary = %q, want it unchanged", got)
+	}
+}
@@ -0,0 +1,42 @@
+//go:build !windows
+
+package xcaddycmd
+
+import (
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestSignalPIDFile(t *testing.T) {
+	pidFile := filepath.Join(t.TempDir(), "caddy.pid")
+
+	if err := os.WriteFile(pidFile, []byte("not a pid\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := signalPIDFile(pidFile, "USR1"); err == nil {
+		t.Error("expected an error for a file without a process ID")
+	}
+	if err := signalPIDFile(pidFile, "BOGUS"); err == nil {
+		t.Error("expected an error for an unknown signal")
+	}
+
+	sig := make(chan os.Signal, 1)
+	signal.Notify(sig, syscall.SIGUSR1)
+	defer signal.Stop(sig)
+	if err := os.WriteFile(pidFile, []byte(strconv.Itoa(os.Getpid())+"\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := signalPIDFile(pidFile, "SIGUSR1"); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-sig:
+	case <-time.After(5 * time.Second):
+		t.Fatal("signal not received")
+	}
+}
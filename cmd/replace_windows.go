@@ -0,0 +1,36 @@
+package xcaddycmd
+
+import (
+	"fmt"
+	"os"
+)
+
+// replaceExecutable moves the executable src over dst. Windows doesn't
+// allow replacing a running executable, but allows renaming it, so if
+// dst can't be replaced, it is moved aside to dst.old first, which is
+// removed by the next replacement once the old process has exited.
+func replaceExecutable(src, dst string) error {
+	err := os.Rename(src, dst)
+	if err == nil {
+		return nil
+	}
+	old := dst + ".old"
+	if err := os.Remove(old); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("removing %s, which may still be running: %v", old, err)
+	}
+	if err := os.Rename(dst, old); err != nil {
+		return err
+	}
+	if err := os.Rename(src, dst); err != nil {
+		// put the old binary back, so there is one
+		_ = os.Rename(old, dst)
+		return err
+	}
+	return nil
+}
+
+// parseSignal returns an error, since signals other than
+// Ctrl-Break can't be sent to another process on Windows.
+func parseSignal(name string) (os.Signal, error) {
+	return nil, fmt.Errorf("--signal is not supported on Windows")
+}
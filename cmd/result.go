@@ -0,0 +1,119 @@
+package xcaddycmd
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// buildResult describes the outcome of a build, which is
+// printed as a summary at its end.
+type buildResult struct {
+	Output       string
+	Size         int64
+	SHA256       string
+	CaddyVersion string
+	GoVersion    string
+	Plugins      int
+	Duration     time.Duration
+
+	// the plugins pulled in by requested plugins, as
+	// recorded in the binary; see xcaddy.Invocation
+	TransitivePlugins []string
+
+	// the patterns of the modules fetched without
+	// verifying TLS, as recorded in the binary
+	InsecureModules []string
+}
+
+// newBuildResult describes the build of binary with
+// the given number of plugins, which took duration.
+func newBuildResult(binary string, plugins int, duration time.Duration) (buildResult, error) {
+	absBinary, err := filepath.Abs(binary)
+	if err != nil {
+		return buildResult{}, err
+	}
+	data, err := os.ReadFile(absBinary)
+	if err != nil {
+		return buildResult{}, err
+	}
+	sum := sha256.Sum256(data)
+	bi, inv, err := readBinary(absBinary)
+	if err != nil {
+		return buildResult{}, err
+	}
+	var transitivePlugins, insecureModules []string
+	if inv != nil {
+		transitivePlugins = inv.TransitivePlugins
+		insecureModules = inv.InsecureModules
+	}
+	return buildResult{
+		Output:       absBinary,
+		Size:         int64(len(data)),
+		SHA256:       hex.EncodeToString(sum[:]),
+		CaddyVersion: newInspectResult(bi, nil).CaddyVersion,
+		GoVersion:    bi.GoVersion,
+		Plugins:      plugins,
+		Duration:     duration,
+
+		TransitivePlugins: transitivePlugins,
+		InsecureModules:   insecureModules,
+	}, nil
+}
+
+// print writes the summary for humans.
+func (r buildResult) print(w io.Writer) error {
+	fmt.Fprintln(w)
+	tw := tabwriter.NewWriter(w, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(tw, "Output:\t%s\n", r.Output)
+	fmt.Fprintf(tw, "Size:\t%s\n", formatSize(r.Size))
+	fmt.Fprintf(tw, "SHA-256:\t%s\n", r.SHA256)
+	fmt.Fprintf(tw, "Caddy version:\t%s\n", r.CaddyVersion)
+	fmt.Fprintf(tw, "Go version:\t%s\n", r.GoVersion)
+	fmt.Fprintf(tw, "Plugins:\t%d\n", r.Plugins)
+	if len(r.TransitivePlugins) > 0 {
+		fmt.Fprintf(tw, "Pulled in by plugins:\t%s\n", strings.Join(r.TransitivePlugins, ", "))
+	}
+	if len(r.InsecureModules) > 0 {
+		fmt.Fprintf(tw, "Fetched without TLS verification:\t%s\n", strings.Join(r.InsecureModules, ", "))
+	}
+	fmt.Fprintf(tw, "Duration:\t%s\n", r.Duration.Round(100*time.Millisecond))
+	return tw.Flush()
+}
+
+// printPorcelain writes the summary as name=value lines,
+// a format which is stable for scripts.
+func (r buildResult) printPorcelain(w io.Writer) error {
+	return writeGitHubOutputs(w, [][2]string{
+		{"binary", r.Output},
+		{"size", strconv.FormatInt(r.Size, 10)},
+		{"sha256", r.SHA256},
+		{"version", r.CaddyVersion},
+		{"plugins", strconv.Itoa(r.Plugins)},
+		{"duration", strconv.FormatFloat(r.Duration.Seconds(), 'f', 1, 64)},
+		{"go", r.GoVersion},
+		{"transitive_plugins", strings.Join(r.TransitivePlugins, ",")},
+		{"insecure_modules", strings.Join(r.InsecureModules, ",")},
+	})
+}
+
+// formatSize formats a size in bytes with a binary unit.
+func formatSize(size int64) string {
+	const unit = 1024
+	if size < unit {
+		return fmt.Sprintf("%d B", size)
+	}
+	div, exp := int64(unit), 0
+	for n := size / unit; n >= unit; n /= unit {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(size)/float64(div), "KMGTPE"[exp])
+}
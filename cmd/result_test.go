@@ -0,0 +1,71 @@
+package xcaddycmd
+
+import (
+	"bytes"
+	"os"
+	"runtime"
+	"testing"
+	"time"
+)
+
+func TestFormatSize(t *testing.T) {
+	for size, expected := range map[int64]string{
+		0:        "0 B",
+		1023:     "1023 B",
+		1024:     "1.0 KiB",
+		1536:     "1.5 KiB",
+		43253760: "41.2 MiB",
+	} {
+		if got := formatSize(size); got != expected {
+			t.Errorf("formatSize(%d): expected %s, got %s", size, expected, got)
+		}
+	}
+}
+
+func TestBuildResultPorcelain(t *testing.T) {
+	r := buildResult{
+		Output:       "/tmp/caddy",
+		Size:         43253760,
+		SHA256:       "abc123",
+		CaddyVersion: "v2.8.4",
+		GoVersion:    "go1.22.5",
+		Plugins:      2,
+		Duration:     83 * time.Second / 2,
+
+		TransitivePlugins: []string{"github.com/mholt/caddy-l4/layer4", "github.com/mholt/caddy-l4/modules/l4tls"},
+		InsecureModules:   []string{"git.corp.example.com/*"},
+	}
+	var buf bytes.Buffer
+	if err := r.printPorcelain(&buf); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expected := "binary=/tmp/caddy\nsize=43253760\nsha256=abc123\nversion=v2.8.4\nplugins=2\nduration=41.5\ngo=go1.22.5\ntransitive_plugins=github.com/mholt/caddy-l4/layer4,github.com/mholt/caddy-l4/modules/l4tls\ninsecure_modules=git.corp.example.com/*\n"
+	if buf.String() != expected {
+		t.Errorf("Expected %q, got %q", expected, buf.String())
+	}
+}
+
+func TestNewBuildResult(t *testing.T) {
+	binary, err := os.Executable()
+	if err != nil {
+		t.Skip(err)
+	}
+	r, err := newBuildResult(binary, 3, time.Second)
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	info, err := os.Stat(binary)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Size != info.Size() || len(r.SHA256) != 64 || r.Plugins != 3 || r.GoVersion != runtime.Version() {
+		t.Errorf("Unexpected result: %+v", r)
+	}
+	var buf bytes.Buffer
+	if err := r.print(&buf); err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	if !bytes.Contains(buf.Bytes(), []byte(r.SHA256)) {
+		t.Errorf("Expected the summary to contain the SHA-256, got:\n%s", buf.String())
+	}
+}
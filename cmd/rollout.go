@@ -0,0 +1,237 @@
+package xcaddycmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/caddyserver/xcaddy"
+	"github.com/caddyserver/xcaddy/internal/utils"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	rolloutCommand.Flags().String("inventory", "", "the JSON or YAML file with the hosts to deploy to, in order")
+	rolloutCommand.Flags().String("manifest", "", "the manifest of the build to deploy, like xcaddy.json")
+	addBuilderFlags(rolloutCommand.Flags())
+	_ = rolloutCommand.MarkFlagRequired("inventory")
+}
+
+var rolloutCommand = &cobra.Command{
+	Use: `rollout --inventory <file> [--manifest <file>]
+    [<caddy_version>] [<flags of the build command>...]`,
+	Short: "Builds Caddy and deploys it to hosts one after another",
+	Long: `
+Builds Caddy once for each platform of the hosts in an inventory, then replaces the
+binary on each host in turn and restarts Caddy there, like the --deploy-to flag of
+the build command does. After each restart, the admin API of the host is checked if
+the inventory has its URL. The rollout stops at the first host which fails, so a bad
+build doesn't take down more than one host; the hosts which were already updated
+and those which were not are listed.
+
+The build is configured like for the prefetch command: with a Caddy version and the
+flags of the build command, or with a manifest.
+
+Flags:
+ --inventory is a JSON file with the hosts, like the following, or a YAML file
+ with the same fields if it is named like hosts.yaml:
+
+   {
+     "restart": "systemctl restart caddy",
+     "hosts": [
+       {"host": "root@web1.example.com", "admin": "http://web1.example.com:2019"},
+       {"host": "root@web2.example.com", "arch": "arm64", "path": "/usr/local/bin/caddy"}
+     ]
+   }
+
+ host is the destination of ssh, which must be able to log in without prompting,
+ as [user@]host[:port]; an IPv6 address with a port goes in brackets, like
+ [2001:db8::1]:2222.
+ path is that of the binary on the host, /usr/bin/caddy by default, and os, arch
+ and arm are its platform, that of GOOS, GOARCH and GOARM by default. restart is
+ the command which restarts Caddy, for all hosts or one of them; it is
+ systemctl restart caddy by default. admin is the URL of the admin API, which must
+ respond within 30 seconds after the restart.
+
+ --manifest reads the build from a manifest, like the prefetch command does.
+`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		inventoryFile, err := cmd.Flags().GetString("inventory")
+		if err != nil {
+			return fmt.Errorf("unable to parse --inventory arguments: %s", err.Error())
+		}
+		manifestFile, err := cmd.Flags().GetString("manifest")
+		if err != nil {
+			return fmt.Errorf("unable to parse --manifest arguments: %s", err.Error())
+		}
+		hosts, err := readInventory(inventoryFile)
+		if err != nil {
+			return err
+		}
+		builder, err := builderFromFlags(cmd, args)
+		if err != nil {
+			return err
+		}
+		if manifestFile != "" {
+			builder, err = builderFromManifest(cmd, args, manifestFile, builder)
+			if err != nil {
+				return err
+			}
+		}
+		ctx := cmd.Root().Context()
+
+		tempDir, err := os.MkdirTemp("", "xcaddy-rollout-")
+		if err != nil {
+			return err
+		}
+		defer os.RemoveAll(tempDir)
+
+		// the modules are resolved once, then built for each platform
+		var platforms []xcaddy.Platform
+		binaries := make(map[xcaddy.Platform]string)
+		for _, h := range hosts {
+			if _, ok := binaries[h.Platform]; !ok {
+				binaries[h.Platform] = ""
+				platforms = append(platforms, h.Platform)
+			}
+		}
+		log.Printf("[INFO] Building for %d platforms", len(platforms))
+		results, err := builder.BuildAll(ctx, platforms, filepath.Join(tempDir, "caddy-{{.OS}}-{{.Arch}}{{if .ARM}}v{{.ARM}}{{end}}{{.Ext}}"))
+		if err != nil {
+			return err
+		}
+		for _, r := range results {
+			binaries[r.Platform] = r.Output
+		}
+
+		for i, h := range hosts {
+			err = deploy(ctx, os.Stdout, binaries[h.Platform], h.deployTarget)
+			if err != nil {
+				return fmt.Errorf("rollout stopped: %v\n%s", err, rolloutProgress(hosts, i))
+			}
+		}
+		log.Printf("[INFO] Rolled out to %d hosts", len(hosts))
+		return nil
+	},
+}
+
+// inventoryHost is a host of an inventory.
+type inventoryHost struct {
+	deployTarget
+	xcaddy.Platform
+}
+
+// readInventory reads the hosts of the inventory in file, which
+// is YAML if named like hosts.yaml, with the defaults filled in.
+func readInventory(file string) ([]inventoryHost, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	if utils.IsYAML(file) {
+		data, err = utils.YAMLToJSON(data)
+		if err != nil {
+			return nil, fmt.Errorf("reading inventory %s: %v", file, err)
+		}
+	}
+	var inventory struct {
+		Restart string `json:"restart"`
+		Hosts   []struct {
+			Host    string `json:"host"`
+			Path    string `json:"path"`
+			Restart string `json:"restart"`
+			Admin   string `json:"admin"`
+			xcaddy.Platform
+		} `json:"hosts"`
+	}
+	err = json.Unmarshal(data, &inventory)
+	if err != nil {
+		return nil, fmt.Errorf("reading inventory %s: %v", file, err)
+	}
+	if len(inventory.Hosts) == 0 {
+		return nil, fmt.Errorf("inventory %s has no hosts", file)
+	}
+	if inventory.Restart == "" {
+		inventory.Restart = defaultDeployRestart
+	}
+	hosts := make([]inventoryHost, 0, len(inventory.Hosts))
+	for _, h := range inventory.Hosts {
+		target, err := parseInventoryHost(h.Host)
+		if err != nil {
+			return nil, fmt.Errorf("inventory %s: %v", file, err)
+		}
+		target.Path = defaultDeployPath
+		if h.Path != "" {
+			target.Path = h.Path
+		}
+		target.Restart = h.Restart
+		if target.Restart == "" {
+			target.Restart = inventory.Restart
+		}
+		target.Admin = h.Admin
+		if h.OS == "" {
+			h.OS = utils.GetGOOS()
+		}
+		if h.Arch == "" {
+			h.Arch = utils.GetGOARCH()
+		}
+		if h.ARM == "" && h.Arch == "arm" {
+			h.ARM = os.Getenv("GOARM")
+		}
+		hosts = append(hosts, inventoryHost{deployTarget: target, Platform: h.Platform})
+	}
+	return hosts, nil
+}
+
+// parseInventoryHost parses a host of an inventory of the form
+// [user@]host[:port], where host may be an IPv6 address, which
+// must be in brackets if a port is given, like [2001:db8::1]:2222.
+func parseInventoryHost(s string) (deployTarget, error) {
+	user, host := "", s
+	if i := strings.LastIndex(s, "@"); i >= 0 {
+		user, host = s[:i+1], s[i+1:]
+	}
+	var port string
+	switch {
+	case strings.HasPrefix(host, "[") && strings.HasSuffix(host, "]"):
+		host = host[1 : len(host)-1]
+	case strings.HasPrefix(host, "[") || strings.Count(host, ":") == 1:
+		var err error
+		host, port, err = net.SplitHostPort(host)
+		if err != nil {
+			return deployTarget{}, fmt.Errorf("host must be of the form [user@]host[:port]: %q: %v", s, err)
+		}
+		if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+			return deployTarget{}, fmt.Errorf("host must be of the form [user@]host[:port]: %q: invalid port %q", s, port)
+		}
+	case strings.Contains(host, ":") && net.ParseIP(host) == nil:
+		return deployTarget{}, fmt.Errorf("host must be of the form [user@]host[:port]: %q", s)
+	}
+	if host == "" || strings.HasPrefix(user+host, "-") {
+		return deployTarget{}, fmt.Errorf("host must be of the form [user@]host[:port]: %q", s)
+	}
+	return deployTarget{Host: user + host, Port: port}, nil
+}
+
+// rolloutProgress describes which hosts were updated when the
+// rollout stopped at the host with index failed, whose binary
+// may or may not have been replaced.
+func rolloutProgress(hosts []inventoryHost, failed int) string {
+	names := func(hosts []inventoryHost) string {
+		if len(hosts) == 0 {
+			return "none"
+		}
+		var s []string
+		for _, h := range hosts {
+			s = append(s, h.Host)
+		}
+		return strings.Join(s, ", ")
+	}
+	return fmt.Sprintf("updated: %s\nfailed: %s\nnot updated: %s", names(hosts[:failed]), hosts[failed].Host, names(hosts[failed+1:]))
+}
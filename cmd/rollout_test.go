@@ -0,0 +1,129 @@
+package xcaddycmd
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/caddyserver/xcaddy"
+	"github.com/caddyserver/xcaddy/internal/utils"
+)
+
+func Test_readInventory(t *testing.T) {
+	dir := t.TempDir()
+	write := func(content string) string {
+		file := filepath.Join(dir, "hosts.json")
+		if err := os.WriteFile(file, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return file
+	}
+
+	got, err := readInventory(write(`{
+	"hosts": [
+		{"host": "root@web1.example.com", "admin": "http://web1.example.com:2019"},
+		{"host": "web2.example.com", "os": "linux", "arch": "arm64", "path": "/usr/local/bin/caddy", "restart": "rc-service caddy restart"}
+	]
+}`))
+	if err != nil {
+		t.Fatalf("readInventory() error = %v", err)
+	}
+	want := []inventoryHost{
+		{
+			deployTarget: deployTarget{Host: "root@web1.example.com", Path: "/usr/bin/caddy", Restart: "systemctl restart caddy", Admin: "http://web1.example.com:2019"},
+			Platform:     xcaddy.Platform{OS: utils.GetGOOS(), Arch: utils.GetGOARCH()},
+		},
+		{
+			deployTarget: deployTarget{Host: "web2.example.com", Path: "/usr/local/bin/caddy", Restart: "rc-service caddy restart"},
+			Platform:     xcaddy.Platform{OS: "linux", Arch: "arm64"},
+		},
+	}
+	if utils.GetGOARCH() == "arm" {
+		want[0].ARM = os.Getenv("GOARM")
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("readInventory() = %+v, want %+v", got, want)
+	}
+
+	yamlFile := filepath.Join(dir, "hosts.yaml")
+	yamlInventory := `hosts:
+  - host: root@web1.example.com
+    admin: http://web1.example.com:2019
+  - host: web2.example.com
+    os: linux
+    arch: arm64
+    path: /usr/local/bin/caddy
+    restart: rc-service caddy restart
+`
+	if err := os.WriteFile(yamlFile, []byte(yamlInventory), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	got, err = readInventory(yamlFile)
+	if err != nil {
+		t.Fatalf("readInventory() of a YAML inventory: error = %v", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("readInventory() of a YAML inventory = %+v, want %+v", got, want)
+	}
+
+	for _, content := range []string{
+		`{"hosts": []}`,
+		`{"hosts": [{"host": "web1.example.com:/usr/bin/caddy"}]}`,
+		`{"hosts": [{"host": "-oProxyCommand=sh"}]}`,
+		`[]`,
+	} {
+		if _, err := readInventory(write(content)); err == nil {
+			t.Errorf("readInventory(%s) succeeded, want error", content)
+		}
+	}
+}
+
+func Test_parseInventoryHost(t *testing.T) {
+	for _, tt := range []struct {
+		host string
+		want deployTarget
+	}{
+		{"web1.example.com", deployTarget{Host: "web1.example.com"}},
+		{"root@web1.example.com:2222", deployTarget{Host: "root@web1.example.com", Port: "2222"}},
+		{"root@[2001:db8::1]", deployTarget{Host: "root@2001:db8::1"}},
+		{"[2001:db8::1]:2222", deployTarget{Host: "2001:db8::1", Port: "2222"}},
+		{"2001:db8::1", deployTarget{Host: "2001:db8::1"}},
+	} {
+		got, err := parseInventoryHost(tt.host)
+		if err != nil {
+			t.Errorf("parseInventoryHost(%q) error = %v", tt.host, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("parseInventoryHost(%q) = %+v, want %+v", tt.host, got, tt.want)
+		}
+	}
+	for _, host := range []string{
+		"",
+		"web1.example.com:/usr/bin/caddy",
+		"web1.example.com:0",
+		"web1.example.com:",
+		"[2001:db8::1]:ssh",
+		"2001:db8::1:2222x",
+		"-oProxyCommand=sh",
+	} {
+		if _, err := parseInventoryHost(host); err == nil {
+			t.Errorf("parseInventoryHost(%q) succeeded, want error", host)
+		}
+	}
+}
+
+func Test_rolloutProgress(t *testing.T) {
+	hosts := []inventoryHost{
+		{deployTarget: deployTarget{Host: "web1"}},
+		{deployTarget: deployTarget{Host: "web2"}},
+		{deployTarget: deployTarget{Host: "web3"}},
+	}
+	if got, want := rolloutProgress(hosts, 1), "updated: web1\nfailed: web2\nnot updated: web3"; got != want {
+		t.Errorf("rolloutProgress() = %q, want %q", got, want)
+	}
+	if got, want := rolloutProgress(hosts, 0), "updated: none\nfailed: web1\nnot updated: web2, web3"; got != want {
+		t.Errorf("rolloutProgress() = %q, want %q", got, want)
+	}
+}
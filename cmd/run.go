@@ -0,0 +1,468 @@
+package xcaddycmd
+
+import (
+	"context"
+	"fmt"
+	"io/fs"
+	"log"
+	"maps"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/caddyserver/xcaddy"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	runCommand.Flags().Bool("no-run", false, "only build Caddy with the current module, and keep the binary")
+	runCommand.Flags().Bool("race", false, "build with the Go race detector, like XCADDY_RACE_DETECTOR=1")
+	runCommand.Flags().Bool("watch", false, "rebuild and restart Caddy when the source of the current module changes")
+	runCommand.Flags().Duration("ready-timeout", 0, "wait up to this long for the admin API of Caddy to respond after it starts, and fail otherwise")
+}
+
+var runCommand = &cobra.Command{
+	Use:   "run [--no-run] [--race] [--watch] [--ready-timeout <duration>] [<caddy run args>...] [-- <caddy run args>...]",
+	Short: "Builds Caddy with the current module and runs it",
+	Long: `
+Builds Caddy with the Go module in the current folder plugged in, like xcaddy
+does without a subcommand, then runs caddy run with the remaining arguments, like
+--config caddy.json. The binary is removed when Caddy exits, unless
+XCADDY_SKIP_CLEANUP=1 is set.
+
+The flags below are those of xcaddy; all other arguments are passed to caddy run,
+as are all arguments after --, so caddy run's own --watch is passed as -- --watch.
+
+Flags:
+ --no-run only builds the binary, ./caddy, and keeps it.
+ --race enables the Go race detector in the build.
+ --watch rebuilds Caddy when Go files, go.mod or go.sum of the current module, or
+ of a local replacement, change, and restarts Caddy with the new binary. If the
+ rebuild fails, the running Caddy is kept. The config Caddy ran with is sent to
+ the new one through the /load endpoint of the admin API, which is expected at
+ the address in CADDY_ADMIN, or localhost:2019, so changes made through the API
+ survive rebuilds.
+ --ready-timeout waits up to this long, like 30s, for the admin API of Caddy to
+ respond after Caddy starts, then prints a line like "Caddy is up (admin at
+ localhost:2019)" to stdout. If it doesn't respond in time, Caddy is stopped and
+ xcaddy fails, so scripts can wait for a running Caddy, like before integration
+ tests. With --watch, the admin API is waited for after each restart as well.
+`,
+	// arguments for caddy run would be
+	// rejected as unknown flags of xcaddy
+	DisableFlagParsing: true,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		own, caddyArgs := splitRunArgs(cmd, args)
+		for _, arg := range own {
+			if arg == "-h" || arg == "--help" {
+				return cmd.Help()
+			}
+		}
+		if err := cmd.Flags().Parse(own); err != nil {
+			return err
+		}
+		noRun, err := cmd.Flags().GetBool("no-run")
+		if err != nil {
+			return fmt.Errorf("unable to parse --no-run arguments: %s", err.Error())
+		}
+		race, err := cmd.Flags().GetBool("race")
+		if err != nil {
+			return fmt.Errorf("unable to parse --race arguments: %s", err.Error())
+		}
+		watch, err := cmd.Flags().GetBool("watch")
+		if err != nil {
+			return fmt.Errorf("unable to parse --watch arguments: %s", err.Error())
+		}
+		readyTimeout, err := cmd.Flags().GetDuration("ready-timeout")
+		if err != nil {
+			return fmt.Errorf("unable to parse --ready-timeout arguments: %s", err.Error())
+		}
+		if noRun && watch {
+			return fmt.Errorf("--no-run and --watch cannot be used together")
+		}
+		return runDev(cmd.Root().Context(), append([]string{"run"}, caddyArgs...), devOptions{
+			NoRun:        noRun,
+			Race:         race || raceDetector,
+			Watch:        watch,
+			ReadyTimeout: readyTimeout,
+		})
+	},
+}
+
+// splitRunArgs splits args of the run command, whose flags are not
+// parsed by cobra, into the flags of cmd with their values, including
+// help, and the arguments for caddy run, which are all the others and
+// those after --.
+func splitRunArgs(cmd *cobra.Command, args []string) (own, caddyArgs []string) {
+	for i := 0; i < len(args); i++ {
+		arg := args[i]
+		if arg == "--" {
+			return own, append(caddyArgs, args[i+1:]...)
+		}
+		name, _, hasValue := strings.Cut(strings.TrimLeft(arg, "-"), "=")
+		flag := cmd.Flags().Lookup(name)
+		switch {
+		case arg == "-h" || arg == "--help":
+			own = append(own, arg)
+		case strings.HasPrefix(arg, "--") && flag != nil:
+			own = append(own, arg)
+			if !hasValue && flag.Value.Type() != "bool" && i+1 < len(args) {
+				i++
+				own = append(own, args[i])
+			}
+		default:
+			caddyArgs = append(caddyArgs, arg)
+		}
+	}
+	return own, caddyArgs
+}
+
+// devOptions configures how Caddy is built and run for plugin development.
+type devOptions struct {
+	// Only build the binary and keep it.
+	NoRun bool
+
+	// Enable the race detector in the build.
+	Race bool
+
+	// Rebuild and restart Caddy when the source changes.
+	Watch bool
+
+	// If set, how long to wait for the admin API of Caddy
+	// to respond after it starts before failing.
+	ReadyTimeout time.Duration
+}
+
+// runDev builds Caddy with the module in the current directory plugged
+// in and runs it with args, until it exits or ctx is canceled.
+func runDev(ctx context.Context, args []string, opts devOptions) error {
+	binOutput := getCaddyOutputFile()
+
+	importPath, replacements, err := currentPlugin()
+	if err != nil {
+		return err
+	}
+
+	// build caddy with this module plugged in
+	builder := xcaddy.Builder{
+		Compile: xcaddy.Compile{
+			Cgo: os.Getenv("CGO_ENABLED") == "1",
+		},
+		CaddyVersion: caddyVersion,
+		Plugins: []xcaddy.Dependency{
+			{PackagePath: importPath},
+		},
+		Replacements:     replacements,
+		RaceDetector:     opts.Race,
+		SkipBuild:        skipBuild,
+		SkipCleanup:      skipCleanup,
+		Debug:            buildDebugOutput,
+		CaddyGitFallback: caddyGitFallback,
+		CaddyRepository:  caddyRepository,
+		SkipPreflight:    skipPreflight,
+	}
+	dirs := localSourceDirs(replacements)
+	build := func(output string) error {
+		err := buildDev(ctx, builder, importPath, dirs, output)
+		if err != nil {
+			return err
+		}
+		// if requested, run setcap to allow binding to low ports
+		return setcapIfRequested(output)
+	}
+	if opts.Watch && !opts.NoRun {
+		return watchDev(ctx, binOutput, args, build, dirs, opts.ReadyTimeout)
+	}
+
+	err = build(binOutput)
+	if err != nil {
+		return err
+	}
+	if opts.NoRun {
+		log.Printf("[INFO] Built %s", binOutput)
+		return nil
+	}
+	defer removeDevBinary(binOutput)
+
+	execCmd := startCaddy(ctx, binOutput, args)
+	err = execCmd.Start()
+	if err != nil {
+		return err
+	}
+	if opts.ReadyTimeout <= 0 {
+		return execCmd.Wait()
+	}
+	exited := make(chan error, 1)
+	go func() { exited <- execCmd.Wait() }()
+	if gone, err := waitReady(ctx, opts.ReadyTimeout, exited); err != nil {
+		if !gone {
+			stopCaddy(execCmd, exited)
+		}
+		return err
+	}
+	return <-exited
+}
+
+// waitReady waits up to timeout until the admin API of Caddy run in
+// development mode responds, then prints that Caddy is up. exited
+// reports when Caddy exits; gone is true if it did while waiting.
+func waitReady(ctx context.Context, timeout time.Duration, exited <-chan error) (gone bool, err error) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	ready := make(chan error, 1)
+	go func() { ready <- waitForAdmin(ctx, devAdminURL(), timeout) }()
+	select {
+	case err := <-ready:
+		if err != nil {
+			return false, err
+		}
+		fmt.Printf("Caddy is up (admin at %s)\n", strings.TrimPrefix(devAdminURL(), "http://"))
+		return false, nil
+	case err := <-exited:
+		if err == nil {
+			err = fmt.Errorf("exit status 0")
+		}
+		return true, fmt.Errorf("caddy exited before its admin API responded: %v", err)
+	}
+}
+
+// startCaddy returns the command which runs Caddy
+// from binary with args in development mode.
+func startCaddy(ctx context.Context, binary string, args []string) *exec.Cmd {
+	log.Printf("[INFO] Running %v\n\n", append([]string{binary}, args...))
+
+	// Caddy gets the same signals from the terminal or console as
+	// xcaddy, so it is given time to shut down gracefully before it
+	// is killed; otherwise, it would be stranded if xcaddy is gone
+	execCmd := exec.CommandContext(ctx, binary, args...)
+	execCmd.Cancel = func() error { return nil }
+	execCmd.WaitDelay = caddyShutdownDelay
+	execCmd.Stdin = os.Stdin
+	execCmd.Stdout = os.Stdout
+	execCmd.Stderr = os.Stderr
+	return execCmd
+}
+
+// removeDevBinary removes binary after Caddy exited,
+// unless cleanup is skipped.
+func removeDevBinary(binary string) {
+	if skipCleanup {
+		log.Printf("[INFO] Skipping cleanup as requested; leaving artifact: %s", binary)
+		return
+	}
+	err := os.Remove(binary)
+	if err != nil && !os.IsNotExist(err) {
+		log.Printf("[ERROR] Deleting temporary binary %s: %v", binary, err)
+	}
+}
+
+// watchInterval is how often the source is checked for changes with --watch.
+var watchInterval = time.Second
+
+// watchDev builds Caddy to binOutput with build and runs it with args,
+// then rebuilds it whenever the source in dirs changes and restarts it,
+// until ctx is canceled. A failed rebuild keeps the running Caddy, and
+// if Caddy exits, it is started again after the next change. After a
+// restart, Caddy gets the config it last ran with, so changes made
+// through its admin API are kept. If readyTimeout is set, Caddy must
+// serve its admin API within it after each start, or it is stopped.
+func watchDev(ctx context.Context, binOutput string, args []string, build func(string) error, dirs []string, readyTimeout time.Duration) error {
+	snapshot, err := sourceSnapshot(dirs)
+	if err != nil {
+		return err
+	}
+	err = build(binOutput)
+	if err != nil {
+		return err
+	}
+	defer removeDevBinary(binOutput)
+
+	// the new binary is built next to the running one, which
+	// can't be overwritten while it is running on Windows
+	ext := filepath.Ext(binOutput)
+	newOutput := strings.TrimSuffix(binOutput, ext) + ".new" + ext
+	defer os.Remove(newOutput)
+
+	var running *exec.Cmd
+	exited := make(chan error, 1)
+	adminURL := devAdminURL()
+	var config []byte // the config Caddy last ran with, if any
+	start := func() error {
+		running = startCaddy(ctx, binOutput, args)
+		if err := running.Start(); err != nil {
+			running = nil
+			return err
+		}
+		go func(cmd *exec.Cmd) { exited <- cmd.Wait() }(running)
+		if readyTimeout <= 0 {
+			return nil
+		}
+		if gone, err := waitReady(ctx, readyTimeout, exited); err != nil {
+			if !gone {
+				stopCaddy(running, exited)
+			}
+			running = nil
+			return err
+		}
+		return nil
+	}
+	err = start()
+	if err != nil {
+		return err
+	}
+	log.Printf("[INFO] Watching %s for changes", strings.Join(dirs, ", "))
+
+	ticker := time.NewTicker(watchInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			if running == nil {
+				return nil
+			}
+			return <-exited
+		case err := <-exited:
+			running = nil
+			if err != nil {
+				log.Printf("[ERROR] Caddy exited: %v; restarting it after the next change", err)
+			} else {
+				log.Printf("[INFO] Caddy exited; restarting it after the next change")
+			}
+		case <-ticker.C:
+			current, err := sourceSnapshot(dirs)
+			if err != nil {
+				log.Printf("[ERROR] Checking the source for changes: %v", err)
+				continue
+			}
+			if maps.Equal(current, snapshot) {
+				continue
+			}
+			snapshot = current
+			log.Printf("[INFO] Source changed; rebuilding")
+			err = build(newOutput)
+			if err != nil {
+				log.Printf("[ERROR] Rebuilding failed, keeping the running Caddy: %v", err)
+				continue
+			}
+			if running != nil {
+				// the config may have been changed through the admin API
+				if current, err := fetchConfig(ctx, adminURL); err != nil {
+					log.Printf("[WARNING] Getting the config of Caddy to restore after the restart: %v", err)
+				} else {
+					config = current
+				}
+				stopCaddy(running, exited)
+				running = nil
+			}
+			err = os.Rename(newOutput, binOutput)
+			if err != nil {
+				return err
+			}
+			err = start()
+			if err != nil {
+				log.Printf("[ERROR] Restarting Caddy: %v; restarting it after the next change", err)
+				continue
+			}
+			if config != nil {
+				restoreConfig(ctx, adminURL, config)
+			}
+		}
+	}
+}
+
+// restoreConfig waits until Caddy restarted by watchDev serves its admin
+// API at adminURL, then makes it run with config, the one it ran with
+// before the restart. Failures are only logged, since Caddy still runs.
+func restoreConfig(ctx context.Context, adminURL string, config []byte) {
+	err := waitForAdmin(ctx, adminURL, 30*time.Second)
+	if err == nil {
+		err = loadConfig(ctx, adminURL, config)
+	}
+	if err != nil {
+		log.Printf("[WARNING] Restoring the config of Caddy after the restart: %v", err)
+		return
+	}
+	log.Printf("[INFO] Restored the config Caddy ran with before the restart")
+}
+
+// stopCaddy stops Caddy run by cmd, gracefully if the platform can
+// send it an interrupt, and waits until it exited, as reported by
+// exited, or kills it after caddyShutdownDelay.
+func stopCaddy(cmd *exec.Cmd, exited <-chan error) {
+	// interrupts can't be sent on Windows
+	if err := cmd.Process.Signal(os.Interrupt); err != nil {
+		_ = cmd.Process.Kill()
+	}
+	select {
+	case <-exited:
+	case <-time.After(caddyShutdownDelay):
+		_ = cmd.Process.Kill()
+		<-exited
+	}
+}
+
+// localSourceDirs returns the directories of the local replacements,
+// which include the module being developed.
+func localSourceDirs(replacements []xcaddy.Replace) []string {
+	var dirs []string
+	for _, r := range replacements {
+		dir := r.New.String()
+		if !filepath.IsAbs(dir) {
+			continue
+		}
+		if info, err := os.Stat(dir); err == nil && info.IsDir() {
+			dirs = append(dirs, dir)
+		}
+	}
+	return dirs
+}
+
+// sourceFiles returns the Go files, go.mod and go.sum files in dirs and
+// their subdirectories, skipping those the go command ignores too.
+func sourceFiles(dirs []string) ([]string, error) {
+	var files []string
+	seen := make(map[string]bool)
+	for _, dir := range dirs {
+		err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+			if err != nil {
+				return err
+			}
+			name := d.Name()
+			if d.IsDir() {
+				if path != dir && (strings.HasPrefix(name, ".") || strings.HasPrefix(name, "_") || name == "testdata") {
+					return filepath.SkipDir
+				}
+				return nil
+			}
+			if (strings.HasSuffix(name, ".go") || name == "go.mod" || name == "go.sum") && !seen[path] {
+				seen[path] = true
+				files = append(files, path)
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+	return files, nil
+}
+
+// sourceSnapshot returns the size and modification
+// time of the source files in dirs, by path.
+func sourceSnapshot(dirs []string) (map[string]string, error) {
+	files, err := sourceFiles(dirs)
+	if err != nil {
+		return nil, err
+	}
+	snapshot := make(map[string]string, len(files))
+	for _, file := range files {
+		info, err := os.Stat(file)
+		if err != nil {
+			return nil, err
+		}
+		snapshot[file] = fmt.Sprintf("%d %d", info.Size(), info.ModTime().UnixNano())
+	}
+	return snapshot, nil
+}
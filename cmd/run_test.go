@@ -0,0 +1,156 @@
+package xcaddycmd
+
+import (
+	"context"
+	"errors"
+	"maps"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSplitRunArgs(t *testing.T) {
+	for i, tc := range []struct {
+		args          []string
+		wantOwn       []string
+		wantCaddyArgs []string
+	}{
+		{
+			args: nil,
+		},
+		{
+			args:          []string{"--config", "caddy.json"},
+			wantCaddyArgs: []string{"--config", "caddy.json"},
+		},
+		{
+			args:          []string{"--watch", "--config", "caddy.json", "--race"},
+			wantOwn:       []string{"--watch", "--race"},
+			wantCaddyArgs: []string{"--config", "caddy.json"},
+		},
+		{
+			args:          []string{"--no-run=true", "--adapter", "caddyfile"},
+			wantOwn:       []string{"--no-run=true"},
+			wantCaddyArgs: []string{"--adapter", "caddyfile"},
+		},
+		{
+			args:          []string{"--watch", "--", "--watch", "--race"},
+			wantOwn:       []string{"--watch"},
+			wantCaddyArgs: []string{"--watch", "--race"},
+		},
+		{
+			args:          []string{"--ready-timeout", "30s", "--config", "caddy.json", "--ready-timeout=1m"},
+			wantOwn:       []string{"--ready-timeout", "30s", "--ready-timeout=1m"},
+			wantCaddyArgs: []string{"--config", "caddy.json"},
+		},
+		{
+			args:          []string{"-h", "--envfile", ".env"},
+			wantOwn:       []string{"-h"},
+			wantCaddyArgs: []string{"--envfile", ".env"},
+		},
+	} {
+		own, caddyArgs := splitRunArgs(runCommand, tc.args)
+		if !reflect.DeepEqual(own, tc.wantOwn) {
+			t.Errorf("Test %d: expected own flags %q but got %q", i, tc.wantOwn, own)
+		}
+		if !reflect.DeepEqual(caddyArgs, tc.wantCaddyArgs) {
+			t.Errorf("Test %d: expected Caddy arguments %q but got %q", i, tc.wantCaddyArgs, caddyArgs)
+		}
+	}
+}
+
+func TestCheckPassThrough(t *testing.T) {
+	for _, args := range [][]string{nil, {"list-modules"}, {"adapt", "--config", "Caddyfile"}, {"my-plugin-command"}} {
+		if err := checkPassThrough(rootCmd, args); err != nil {
+			t.Errorf("checkPassThrough(%q) = %v, want nil", args, err)
+		}
+	}
+	if err := checkPassThrough(rootCmd, []string{"biuld", "v2.8.4"}); err == nil {
+		t.Error("checkPassThrough() of a misspelled command of xcaddy = nil, want an error")
+	}
+}
+
+func TestSourceSnapshot(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) {
+		t.Helper()
+		file := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(file), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(file, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	write("go.mod", "module example.com/plugin\n")
+	write("plugin.go", "package plugin\n")
+	write("sub/sub.go", "package sub\n")
+	write("testdata/fixture.go", "package fixture\n")
+	write(".git/config", "")
+	write("caddy", "binary")
+
+	files, err := sourceFiles([]string{dir, filepath.Join(dir, "sub")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{filepath.Join(dir, "go.mod"), filepath.Join(dir, "plugin.go"), filepath.Join(dir, "sub", "sub.go")}
+	if !reflect.DeepEqual(files, want) {
+		t.Errorf("sourceFiles() = %q, want %q", files, want)
+	}
+
+	before, err := sourceSnapshot([]string{dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	write("caddy", "rebuilt binary")
+	write("testdata/fixture.go", "package fixture // changed\n")
+	unchanged, err := sourceSnapshot([]string{dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !maps.Equal(before, unchanged) {
+		t.Error("snapshot changed after changing files which are not source")
+	}
+	write("plugin.go", "package plugin // changed\n")
+	later := time.Now().Add(time.Second)
+	if err := os.Chtimes(filepath.Join(dir, "plugin.go"), later, later); err != nil {
+		t.Fatal(err)
+	}
+	changed, err := sourceSnapshot([]string{dir})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if maps.Equal(before, changed) {
+		t.Error("snapshot did not change after changing a Go file")
+	}
+}
+
+func TestWaitReady(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte("null\n"))
+	}))
+	defer srv.Close()
+	ctx := context.Background()
+
+	t.Setenv("CADDY_ADMIN", strings.TrimPrefix(srv.URL, "http://"))
+	gone, err := waitReady(ctx, 5*time.Second, make(chan error))
+	if gone || err != nil {
+		t.Errorf("waitReady() with a responding admin API = %v, %v, want false, nil", gone, err)
+	}
+
+	srv.Close()
+	exited := make(chan error, 1)
+	exited <- errors.New("exit status 1")
+	gone, err = waitReady(ctx, 5*time.Second, exited)
+	if !gone || err == nil {
+		t.Errorf("waitReady() after Caddy exited = %v, %v, want true and an error", gone, err)
+	}
+	gone, err = waitReady(ctx, 100*time.Millisecond, make(chan error))
+	if gone || err == nil {
+		t.Errorf("waitReady() without an admin API = %v, %v, want false and an error", gone, err)
+	}
+}
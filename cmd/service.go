@@ -0,0 +1,131 @@
+package xcaddycmd
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+)
+
+// writeServiceFiles writes a systemd unit, a default Caddyfile and
+// an install script next to the binary, named by appending .service,
+// .Caddyfile and .install.sh to its name. They follow the layout of
+// the official packages, so a custom build can be deployed the same
+// way: a caddy user and group, /usr/bin/caddy and /etc/caddy.
+func writeServiceFiles(binary string) error {
+	base := filepath.Base(binary)
+	files := []struct {
+		name string
+		tmpl *template.Template
+		mode os.FileMode
+	}{
+		{base + ".service", serviceUnitTemplate, 0o644},
+		{base + ".Caddyfile", serviceCaddyfileTemplate, 0o644},
+		{base + ".install.sh", serviceInstallTemplate, 0o755},
+	}
+	data := map[string]string{
+		"Binary":    shellQuote(base),
+		"Unit":      shellQuote(files[0].name),
+		"Caddyfile": shellQuote(files[1].name),
+	}
+	for _, f := range files {
+		var buf bytes.Buffer
+		if err := f.tmpl.Execute(&buf, data); err != nil {
+			return err
+		}
+		path := filepath.Join(filepath.Dir(binary), f.name)
+		log.Printf("[INFO] Writing %s", path)
+		if err := os.WriteFile(path, buf.Bytes(), f.mode); err != nil {
+			return fmt.Errorf("writing %s: %v", path, err)
+		}
+	}
+	return nil
+}
+
+// shellQuote quotes s as a single word for sh.
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
+
+var serviceUnitTemplate = template.Must(template.New("service").Parse(`# caddy.service, generated by xcaddy
+#
+# WARNING: This service does not use the --resume flag, so if you
+# use the API to make changes, they will be overwritten by the
+# Caddyfile next time the service is restarted.
+
+[Unit]
+Description=Caddy
+Documentation=https://caddyserver.com/docs/
+After=network.target network-online.target
+Requires=network-online.target
+
+[Service]
+Type=notify
+User=caddy
+Group=caddy
+ExecStart=/usr/bin/caddy run --environ --config /etc/caddy/Caddyfile
+ExecReload=/usr/bin/caddy reload --config /etc/caddy/Caddyfile --force
+TimeoutStopSec=5s
+LimitNOFILE=1048576
+PrivateTmp=true
+ProtectSystem=full
+AmbientCapabilities=CAP_NET_ADMIN CAP_NET_BIND_SERVICE
+
+[Install]
+WantedBy=multi-user.target
+`))
+
+var serviceCaddyfileTemplate = template.Must(template.New("Caddyfile").Parse(`# The Caddyfile is an easy way to configure your Caddy web server.
+#
+# Unless the file starts with a global options block, the first
+# uncommented line is always the address of your site.
+#
+# To use your own domain name (with automatic HTTPS), first make
+# sure your domain's A/AAAA DNS records are properly pointed to
+# this machine's public IP, then replace ":80" below with your
+# domain name.
+
+:80 {
+	# Set this path to your site's directory.
+	root * /usr/share/caddy
+
+	# Enable the static file server.
+	file_server
+
+	# Another common task is to set up a reverse proxy:
+	# reverse_proxy localhost:8080
+}
+
+# Refer to the Caddy docs for more information:
+# https://caddyserver.com/docs/caddyfile
+`))
+
+var serviceInstallTemplate = template.Must(template.New("install").Parse(`#!/bin/sh
+# Installs this Caddy build as a systemd service, like the official
+# packages do. Generated by xcaddy; run as root. An existing
+# /etc/caddy/Caddyfile is kept.
+set -eu
+
+cd "$(dirname "$0")"
+
+if ! getent group caddy >/dev/null; then
+	groupadd --system caddy
+fi
+if ! getent passwd caddy >/dev/null; then
+	useradd --system --gid caddy --create-home --home-dir /var/lib/caddy \
+		--shell /usr/sbin/nologin --comment "Caddy web server" caddy
+fi
+
+install -m 0755 {{.Binary}} /usr/bin/caddy
+install -d -m 0755 /etc/caddy /usr/share/caddy
+if [ ! -e /etc/caddy/Caddyfile ]; then
+	install -m 0644 {{.Caddyfile}} /etc/caddy/Caddyfile
+fi
+install -m 0644 {{.Unit}} /etc/systemd/system/caddy.service
+
+systemctl daemon-reload
+systemctl enable --now caddy
+`))
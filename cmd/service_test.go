@@ -0,0 +1,44 @@
+package xcaddycmd
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestWriteServiceFiles(t *testing.T) {
+	dir := t.TempDir()
+	err := writeServiceFiles(filepath.Join(dir, "my caddy"))
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+
+	for file, expected := range map[string]string{
+		"my caddy.service":    "ExecStart=/usr/bin/caddy run --environ --config /etc/caddy/Caddyfile\n",
+		"my caddy.Caddyfile":  "root * /usr/share/caddy\n",
+		"my caddy.install.sh": "install -m 0755 'my caddy' /usr/bin/caddy\n",
+	} {
+		content, err := os.ReadFile(filepath.Join(dir, file))
+		if err != nil {
+			t.Errorf("Reading %s: %v", file, err)
+			continue
+		}
+		if !strings.Contains(string(content), expected) {
+			t.Errorf("Expected %s to contain %q, got:\n%s", file, expected, content)
+		}
+	}
+}
+
+func TestShellQuote(t *testing.T) {
+	for input, expected := range map[string]string{
+		"caddy":       "'caddy'",
+		"my caddy":    "'my caddy'",
+		"it's caddy":  `'it'\''s caddy'`,
+		"$(rm -rf /)": "'$(rm -rf /)'",
+	} {
+		if got := shellQuote(input); got != expected {
+			t.Errorf("shellQuote(%q): expected %s, got %s", input, expected, got)
+		}
+	}
+}
@@ -0,0 +1,8 @@
+//go:build !windows
+
+package xcaddycmd
+
+import "os"
+
+// trappedSignals are the signals which make xcaddy shut down cleanly.
+var trappedSignals = []os.Signal{os.Interrupt}
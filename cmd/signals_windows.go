@@ -0,0 +1,13 @@
+package xcaddycmd
+
+import (
+	"os"
+	"syscall"
+)
+
+// trappedSignals are the signals which make xcaddy shut down cleanly.
+// Besides Ctrl-C and Ctrl-Break, which are delivered as os.Interrupt,
+// this includes syscall.SIGTERM, which Go delivers when the console
+// window is closed or the user logs off; Windows gives the process a
+// few seconds to clean up before terminating it then.
+var trappedSignals = []os.Signal{os.Interrupt, syscall.SIGTERM}
@@ -0,0 +1,114 @@
+package xcaddycmd
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// isVCSURL returns true if the --with argument looks like the
+// URL of a repository rather than a Go module path.
+func isVCSURL(arg string) bool {
+	return strings.HasPrefix(arg, "https://") || strings.HasPrefix(arg, "http://")
+}
+
+// resolveVCSURL turns the URL of a repository into the path of the
+// Go module it hosts. Most forges (GitHub, GitLab, Gitea, etc.) answer
+// `?go-get=1` requests with go-import meta tags, the same way the go
+// command discovers where to fetch a module from; if no matching tag
+// is served, the module path is derived from the URL itself.
+func resolveVCSURL(ctx context.Context, rawURL string) (string, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", fmt.Errorf("invalid repository URL %s: %v", rawURL, err)
+	}
+	if u.Host == "" {
+		return "", fmt.Errorf("invalid repository URL %s: missing host", rawURL)
+	}
+	importPath := u.Host + strings.TrimSuffix(strings.TrimSuffix(u.Path, "/"), ".git")
+
+	ctx, cancel := context.WithTimeout(ctx, 30*time.Second)
+	defer cancel()
+	metaURL := u.Scheme + "://" + importPath + "?go-get=1"
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, metaURL, nil)
+	if err != nil {
+		return "", err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		log.Printf("[WARNING] Unable to discover module path of %s, using %s: %v", rawURL, importPath, err)
+		return importPath, nil
+	}
+	defer resp.Body.Close()
+
+	prefix, err := parseGoImportMeta(resp.Body, importPath)
+	if err != nil || prefix == "" {
+		log.Printf("[WARNING] No go-import meta tag found for %s, using %s", rawURL, importPath)
+		return importPath, nil
+	}
+	log.Printf("[INFO] Resolved repository %s to module %s", rawURL, prefix)
+	return prefix, nil
+}
+
+// parseGoImportMeta returns the import prefix of the go-import meta
+// tag in the HTML document r which matches importPath. If there are
+// several, the longest matching prefix wins.
+// See https://go.dev/ref/mod#vcs-find
+func parseGoImportMeta(r io.Reader, importPath string) (string, error) {
+	// HTML is not XML, but the go command gets away with reading
+	// the head of the document like this, so we can too
+	d := xml.NewDecoder(r)
+	d.Strict = false
+	d.AutoClose = xml.HTMLAutoClose
+	d.Entity = xml.HTMLEntity
+
+	var prefix string
+	for {
+		t, err := d.RawToken()
+		if err == io.EOF {
+			return prefix, nil
+		}
+		if err != nil {
+			return prefix, err
+		}
+		if e, ok := t.(xml.EndElement); ok && strings.EqualFold(e.Name.Local, "head") {
+			return prefix, nil
+		}
+		e, ok := t.(xml.StartElement)
+		if !ok {
+			continue
+		}
+		if strings.EqualFold(e.Name.Local, "body") {
+			return prefix, nil
+		}
+		if !strings.EqualFold(e.Name.Local, "meta") || attrValue(e.Attr, "name") != "go-import" {
+			continue
+		}
+		// content is "<import-prefix> <vcs> <repo-root>"
+		fields := strings.Fields(attrValue(e.Attr, "content"))
+		if len(fields) != 3 {
+			continue
+		}
+		if importPath != fields[0] && !strings.HasPrefix(importPath, fields[0]+"/") {
+			continue
+		}
+		if len(fields[0]) > len(prefix) {
+			prefix = fields[0]
+		}
+	}
+}
+
+func attrValue(attrs []xml.Attr, name string) string {
+	for _, a := range attrs {
+		if strings.EqualFold(a.Name.Local, name) {
+			return a.Value
+		}
+	}
+	return ""
+}
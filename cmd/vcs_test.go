@@ -0,0 +1,65 @@
+package xcaddycmd
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseGoImportMeta(t *testing.T) {
+	for i, tc := range []struct {
+		html         string
+		importPath   string
+		expectPrefix string
+	}{
+		{
+			html: `<!DOCTYPE html>
+<html>
+<head>
+	<meta name="go-import" content="git.example.com/me/plugin git https://git.example.com/me/plugin.git">
+	<meta name="go-source" content="git.example.com/me/plugin _ _ _">
+</head>
+<body>go get git.example.com/me/plugin</body>
+</html>`,
+			importPath:   "git.example.com/me/plugin",
+			expectPrefix: "git.example.com/me/plugin",
+		},
+		{
+			// GitLab subgroups: the repository root is deeper than the URL suggests
+			html: `<html><head>
+<meta content="gitlab.example.com/group/sub/plugin git https://gitlab.example.com/group/sub/plugin.git" name="go-import">
+</head></html>`,
+			importPath:   "gitlab.example.com/group/sub/plugin",
+			expectPrefix: "gitlab.example.com/group/sub/plugin",
+		},
+		{
+			html: `<html><head>
+<meta name="go-import" content="example.com/mono git https://example.com/mono.git">
+<meta name="go-import" content="example.com/mono/plugin git https://example.com/mono-plugin.git">
+</head></html>`,
+			importPath:   "example.com/mono/plugin",
+			expectPrefix: "example.com/mono/plugin",
+		},
+		{
+			html: `<html><head>
+<meta name="go-import" content="example.com/other git https://example.com/other.git">
+</head></html>`,
+			importPath:   "example.com/mono/plugin",
+			expectPrefix: "",
+		},
+		{
+			html: `<html><head></head><body>
+<meta name="go-import" content="example.com/plugin git https://example.com/plugin.git">
+</body></html>`,
+			importPath:   "example.com/plugin",
+			expectPrefix: "",
+		},
+	} {
+		actual, err := parseGoImportMeta(strings.NewReader(tc.html), tc.importPath)
+		if err != nil {
+			t.Errorf("Test %d: Expected no error but got: %s", i, err)
+		}
+		if actual != tc.expectPrefix {
+			t.Errorf("Test %d: Expected prefix '%s' but got '%s'", i, tc.expectPrefix, actual)
+		}
+	}
+}
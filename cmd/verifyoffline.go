@@ -0,0 +1,75 @@
+package xcaddycmd
+
+import (
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/caddyserver/xcaddy"
+	"github.com/spf13/cobra"
+)
+
+func init() {
+	verifyOfflineCommand.Flags().String("manifest", "", "the manifest of the build to verify the modules of, like xcaddy.json")
+	addBuilderFlags(verifyOfflineCommand.Flags())
+}
+
+var verifyOfflineCommand = &cobra.Command{
+	Use: `verify-offline [--manifest <file>] [--modcache <dir>]
+    [<caddy_version>] [<flags of the build command>...]`,
+	Short: "Verifies that a module cache has everything for a build",
+	Long: `
+Checks, without network access, whether the module cache of the go command, or the
+one given with --modcache, has all modules needed to build Caddy with the given
+plugins, and lists those which are missing. This is meant to be run before shipping
+a copy of a module cache, like one filled by the prefetch command, to a build host
+without network access, which then builds with --offline.
+
+The build is configured like for the prefetch command: with a Caddy version and the
+flags of the build command, or with a manifest. Modules which are needed to resolve
+the versions of the build are reported one at a time, since the go command stops at
+the first one which is missing; all others are listed at once.
+
+Flags:
+ --manifest reads the build from a manifest, like the prefetch command does.
+
+ --modcache is the module cache to verify; by default, it is that of the go command.
+`,
+	Args: cobra.MaximumNArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		manifestFile, err := cmd.Flags().GetString("manifest")
+		if err != nil {
+			return fmt.Errorf("unable to parse --manifest arguments: %s", err.Error())
+		}
+		builder, err := builderFromFlags(cmd, args)
+		if err != nil {
+			return err
+		}
+		if manifestFile != "" {
+			builder, err = builderFromManifest(cmd, args, manifestFile, builder)
+			if err != nil {
+				return err
+			}
+		}
+		missing, err := builder.VerifyOffline(cmd.Root().Context())
+		if err != nil {
+			return err
+		}
+		return printMissingModules(os.Stdout, missing)
+	},
+}
+
+// printMissingModules prints the modules which are missing from the
+// module cache, returning an error if there are any, so scripts can
+// tell by the exit status.
+func printMissingModules(out io.Writer, missing []string) error {
+	if len(missing) == 0 {
+		fmt.Fprintln(out, "All modules of the build are in the module cache.")
+		return nil
+	}
+	fmt.Fprintln(out, "Missing from the module cache:")
+	for _, mod := range missing {
+		fmt.Fprintf(out, "  %s\n", mod)
+	}
+	return &xcaddy.Error{Failure: xcaddy.FailureVerification, Err: fmt.Errorf("%d modules of the build are missing from the module cache", len(missing))}
+}
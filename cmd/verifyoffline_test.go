@@ -0,0 +1,26 @@
+package xcaddycmd
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestPrintMissingModules(t *testing.T) {
+	var buf bytes.Buffer
+	if err := printMissingModules(&buf, nil); err != nil {
+		t.Errorf("printMissingModules() without missing modules returned error: %v", err)
+	}
+	if got, want := buf.String(), "All modules of the build are in the module cache.\n"; got != want {
+		t.Errorf("printMissingModules():\nexpected:\n%s\ngot:\n%s", want, got)
+	}
+
+	buf.Reset()
+	err := printMissingModules(&buf, []string{"go.uber.org/zap@v1.27.0", "golang.org/x/time@v0.5.0"})
+	if err == nil {
+		t.Error("printMissingModules() with missing modules returned no error")
+	}
+	want := "Missing from the module cache:\n  go.uber.org/zap@v1.27.0\n  golang.org/x/time@v0.5.0\n"
+	if got := buf.String(); got != want {
+		t.Errorf("printMissingModules():\nexpected:\n%s\ngot:\n%s", want, got)
+	}
+}
@@ -0,0 +1,529 @@
+package xcaddycmd
+
+import (
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/caddyserver/xcaddy"
+	"github.com/spf13/cobra"
+)
+
+var vetCommand = &cobra.Command{
+	Use:   "vet",
+	Short: "Checks the Caddy modules of the current Go module for common mistakes",
+	Long: `
+Checks the Caddy modules in the packages of the Go module in the current directory
+for mistakes which the compiler can't catch, but which make them misbehave at runtime:
+
+- Module IDs returned by CaddyModule must be dot-separated, lower-case labels.
+- ModuleInfo must have a New function.
+- Module types must be registered with caddy.RegisterModule.
+- Provision, Validate, Cleanup and UnmarshalCaddyfile must have the signatures of
+  the interfaces Caddy calls them through; otherwise they are silently never called.
+  Provision and UnmarshalCaddyfile must have pointer receivers, since their changes
+  would be lost otherwise.
+- Each of those methods should have an interface guard, like
+  var _ caddy.Provisioner = (*MyModule)(nil), which makes the compiler check them.
+- Packages which register a Caddyfile directive must have a module implementing
+  UnmarshalCaddyfile.
+
+The check is syntactic; it doesn't need the module's dependencies to be downloaded.
+`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		cwd, err := os.Getwd()
+		if err != nil {
+			return fmt.Errorf("unable to determine current directory: %v", err)
+		}
+		root, err := findModuleRoot(cwd)
+		if err != nil {
+			return err
+		}
+		diagnostics, err := vetModule(root)
+		if err != nil {
+			return err
+		}
+		for _, d := range diagnostics {
+			if rel, err := filepath.Rel(cwd, d.Pos.Filename); err == nil {
+				d.Pos.Filename = rel
+			}
+			fmt.Println(d)
+		}
+		if len(diagnostics) > 0 {
+			return &xcaddy.Error{Failure: xcaddy.FailureVerification, Err: fmt.Errorf("found %d problem(s)", len(diagnostics))}
+		}
+		return nil
+	},
+}
+
+// vetDiagnostic is a problem found by vet.
+type vetDiagnostic struct {
+	Pos     token.Position
+	Message string
+}
+
+func (d vetDiagnostic) String() string {
+	return fmt.Sprintf("%s: %s", d.Pos, d.Message)
+}
+
+// findModuleRoot returns the closest folder
+// containing a go.mod file, starting at dir.
+func findModuleRoot(dir string) (string, error) {
+	for {
+		if _, err := os.Stat(filepath.Join(dir, "go.mod")); err == nil {
+			return dir, nil
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "", fmt.Errorf("no go.mod found in the current directory or any parent")
+		}
+		dir = parent
+	}
+}
+
+// vetModule checks the packages of the module in root, skipping
+// nested modules, testdata and hidden folders.
+func vetModule(root string) ([]vetDiagnostic, error) {
+	fset := token.NewFileSet()
+	var diagnostics []vetDiagnostic
+	err := filepath.WalkDir(root, func(dir string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if !d.IsDir() {
+			return nil
+		}
+		if dir != root {
+			name := d.Name()
+			if strings.HasPrefix(name, ".") || strings.HasPrefix(name, "_") || name == "testdata" || name == "vendor" {
+				return filepath.SkipDir
+			}
+			if _, err := os.Stat(filepath.Join(dir, "go.mod")); err == nil {
+				return filepath.SkipDir
+			}
+		}
+		pkgs, err := parser.ParseDir(fset, dir, func(fi fs.FileInfo) bool {
+			return !strings.HasSuffix(fi.Name(), "_test.go")
+		}, 0)
+		if err != nil {
+			return err
+		}
+		for _, pkg := range pkgs {
+			var files []*ast.File
+			for _, f := range pkg.Files {
+				files = append(files, f)
+			}
+			diagnostics = append(diagnostics, vetPackage(fset, files)...)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	return diagnostics, nil
+}
+
+// The import paths of the Caddy packages vet knows about.
+const (
+	caddyImportPath         = "github.com/caddyserver/caddy/v2"
+	caddyfileImportPath     = "github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	httpcaddyfileImportPath = "github.com/caddyserver/caddy/v2/caddyconfig/httpcaddyfile"
+)
+
+// vetMethod is a method through which Caddy uses modules.
+type vetMethod struct {
+	Name      string
+	Signature string // with qualified type names, like func(caddy.Context) error
+	Interface string
+	Pointer   bool // whether it needs a pointer receiver
+}
+
+var vetMethods = []vetMethod{
+	{Name: "Provision", Signature: "func(caddy.Context) error", Interface: "caddy.Provisioner", Pointer: true},
+	{Name: "Validate", Signature: "func() error", Interface: "caddy.Validator"},
+	{Name: "Cleanup", Signature: "func() error", Interface: "caddy.CleanerUpper"},
+	{Name: "UnmarshalCaddyfile", Signature: "func(*caddyfile.Dispenser) error", Interface: "caddyfile.Unmarshaler", Pointer: true},
+}
+
+// moduleIDRegexp matches well-formed module IDs:
+// dot-separated labels in snake_case.
+var moduleIDRegexp = regexp.MustCompile(`^[a-z0-9_]+(\.[a-z0-9_]+)*$`)
+
+// vetFile is a parsed file with the names of its imports.
+type vetFile struct {
+	*ast.File
+	imports map[string]string // local name to import path
+}
+
+// newVetFile returns f along with the names of its imports.
+func newVetFile(f *ast.File) vetFile {
+	file := vetFile{File: f, imports: make(map[string]string)}
+	for _, imp := range f.Imports {
+		importPath, _ := strconv.Unquote(imp.Path.Value)
+		name := path.Base(importPath)
+		if importPath == caddyImportPath {
+			name = "caddy"
+		}
+		if imp.Name != nil {
+			name = imp.Name.Name
+		}
+		file.imports[name] = importPath
+	}
+	return file
+}
+
+// inCaddy returns whether the file is part of the root package
+// of Caddy itself, which refers to its types unqualified.
+func (f vetFile) inCaddy() bool {
+	return f.Name.Name == "caddy"
+}
+
+// qualified returns the qualified name of x, like caddy.Context
+// for a selector on the import of Caddy, whatever its local name.
+func (f vetFile) qualified(x ast.Expr) string {
+	switch x := x.(type) {
+	case *ast.Ident:
+		if f.inCaddy() && ast.IsExported(x.Name) {
+			return "caddy." + x.Name
+		}
+		return x.Name
+	case *ast.StarExpr:
+		return "*" + f.qualified(x.X)
+	case *ast.SelectorExpr:
+		if pkg, ok := x.X.(*ast.Ident); ok {
+			if importPath, ok := f.imports[pkg.Name]; ok {
+				switch importPath {
+				case caddyImportPath:
+					return "caddy." + x.Sel.Name
+				default:
+					return path.Base(importPath) + "." + x.Sel.Name
+				}
+			}
+			return pkg.Name + "." + x.Sel.Name
+		}
+	}
+	return "?"
+}
+
+// signature returns the signature of a function type,
+// without parameter names and with qualified type names.
+func (f vetFile) signature(ft *ast.FuncType) string {
+	fields := func(list *ast.FieldList) []string {
+		var types []string
+		if list == nil {
+			return nil
+		}
+		for _, field := range list.List {
+			n := len(field.Names)
+			if n == 0 {
+				n = 1
+			}
+			for i := 0; i < n; i++ {
+				types = append(types, f.qualified(field.Type))
+			}
+		}
+		return types
+	}
+	sig := "func(" + strings.Join(fields(ft.Params), ", ") + ")"
+	results := fields(ft.Results)
+	switch len(results) {
+	case 0:
+	case 1:
+		sig += " " + results[0]
+	default:
+		sig += " (" + strings.Join(results, ", ") + ")"
+	}
+	return sig
+}
+
+// typeOfValue returns the name of the type of a value like T{},
+// &T{}, new(T) or (*T)(nil), as used for registrations and guards.
+func typeOfValue(x ast.Expr) string {
+	switch x := x.(type) {
+	case *ast.CompositeLit:
+		if ident, ok := x.Type.(*ast.Ident); ok {
+			return ident.Name
+		}
+	case *ast.UnaryExpr:
+		return typeOfValue(x.X)
+	case *ast.CallExpr:
+		if ident, ok := x.Fun.(*ast.Ident); ok && ident.Name == "new" && len(x.Args) == 1 {
+			if arg, ok := x.Args[0].(*ast.Ident); ok {
+				return arg.Name
+			}
+		}
+		if paren, ok := x.Fun.(*ast.ParenExpr); ok {
+			if star, ok := paren.X.(*ast.StarExpr); ok {
+				if ident, ok := star.X.(*ast.Ident); ok {
+					return ident.Name
+				}
+			}
+		}
+	}
+	return ""
+}
+
+// vetPackage checks the files of a package.
+func vetPackage(fset *token.FileSet, astFiles []*ast.File) []vetDiagnostic {
+	var diagnostics []vetDiagnostic
+	report := func(pos token.Pos, format string, args ...any) {
+		diagnostics = append(diagnostics, vetDiagnostic{Pos: fset.Position(pos), Message: fmt.Sprintf(format, args...)})
+	}
+
+	type method struct {
+		decl *ast.FuncDecl
+		file vetFile
+	}
+	methods := make(map[string]map[string]method) // by type name, then method name
+	guards := make(map[string]map[string]bool)    // by type name, then interface
+	funcs := make(map[string]*ast.FuncDecl)
+	registered := make(map[string]bool)
+	var directives []*ast.CallExpr
+
+	for _, astFile := range astFiles {
+		file := newVetFile(astFile)
+
+		for _, decl := range astFile.Decls {
+			switch decl := decl.(type) {
+			case *ast.FuncDecl:
+				if decl.Recv == nil {
+					funcs[decl.Name.Name] = decl
+					continue
+				}
+				if len(decl.Recv.List) != 1 {
+					continue
+				}
+				recv := decl.Recv.List[0].Type
+				if star, ok := recv.(*ast.StarExpr); ok {
+					recv = star.X
+				}
+				ident, ok := recv.(*ast.Ident)
+				if !ok {
+					continue
+				}
+				if methods[ident.Name] == nil {
+					methods[ident.Name] = make(map[string]method)
+				}
+				methods[ident.Name][decl.Name.Name] = method{decl: decl, file: file}
+			case *ast.GenDecl:
+				if decl.Tok != token.VAR {
+					continue
+				}
+				for _, spec := range decl.Specs {
+					spec := spec.(*ast.ValueSpec)
+					if spec.Type == nil || len(spec.Names) != 1 || spec.Names[0].Name != "_" || len(spec.Values) != 1 {
+						continue
+					}
+					typeName := typeOfValue(spec.Values[0])
+					if typeName == "" {
+						continue
+					}
+					if guards[typeName] == nil {
+						guards[typeName] = make(map[string]bool)
+					}
+					guards[typeName][file.qualified(spec.Type)] = true
+				}
+			}
+		}
+
+		ast.Inspect(astFile, func(n ast.Node) bool {
+			call, ok := n.(*ast.CallExpr)
+			if !ok {
+				return true
+			}
+			switch file.qualified(call.Fun) {
+			case "caddy.RegisterModule":
+				if len(call.Args) == 1 {
+					registered[typeOfValue(call.Args[0])] = true
+				}
+			case "httpcaddyfile.RegisterHandlerDirective", "httpcaddyfile.RegisterDirective":
+				if len(call.Args) == 2 {
+					directives = append(directives, call)
+				}
+			}
+			return true
+		})
+	}
+
+	var typeNames []string
+	for typeName := range methods {
+		typeNames = append(typeNames, typeName)
+	}
+	sort.Strings(typeNames)
+
+	for _, typeName := range typeNames {
+		caddyModule, ok := methods[typeName]["CaddyModule"]
+		if !ok {
+			continue
+		}
+		if sig := caddyModule.file.signature(caddyModule.decl.Type); sig != "func() caddy.ModuleInfo" {
+			report(caddyModule.decl.Pos(), "%s.CaddyModule has signature %s, but caddy.Module needs func() caddy.ModuleInfo", typeName, sig)
+		}
+		vetModuleInfo(caddyModule.decl, caddyModule.file, typeName, report)
+		if !registered[typeName] {
+			report(caddyModule.decl.Pos(), "%s is a Caddy module, but isn't registered with caddy.RegisterModule", typeName)
+		}
+
+		for _, vm := range vetMethods {
+			m, ok := methods[typeName][vm.Name]
+			if !ok {
+				continue
+			}
+			if sig := m.file.signature(m.decl.Type); sig != vm.Signature {
+				report(m.decl.Pos(), "%s.%s has signature %s, but %s needs %s; Caddy won't call it", typeName, vm.Name, sig, vm.Interface, vm.Signature)
+				continue
+			}
+			if _, pointer := m.decl.Recv.List[0].Type.(*ast.StarExpr); vm.Pointer && !pointer && assignsToReceiver(m.decl) {
+				report(m.decl.Pos(), "%s.%s has a value receiver, so its changes to the module are lost; use a pointer receiver", typeName, vm.Name)
+				continue
+			}
+			if !guards[typeName][vm.Interface] {
+				report(m.decl.Pos(), "%s.%s has no interface guard; add var _ %s = (*%s)(nil)", typeName, vm.Name, vm.Interface, typeName)
+			}
+		}
+	}
+
+	for _, call := range directives {
+		var body *ast.BlockStmt
+		switch parse := call.Args[1].(type) {
+		case *ast.FuncLit:
+			body = parse.Body
+		case *ast.Ident:
+			if decl, ok := funcs[parse.Name]; ok {
+				body = decl.Body
+			}
+		}
+		if body != nil && !parsesTokens(body, funcs, make(map[string]bool)) {
+			report(call.Pos(), "the Caddyfile directive registered here never reads its tokens; implement caddyfile.Unmarshaler and call UnmarshalCaddyfile from its parse function")
+		}
+	}
+
+	sort.Slice(diagnostics, func(i, j int) bool {
+		a, b := diagnostics[i].Pos, diagnostics[j].Pos
+		if a.Filename != b.Filename {
+			return a.Filename < b.Filename
+		}
+		return a.Offset < b.Offset
+	})
+	return diagnostics
+}
+
+// assignsToReceiver returns whether the method decl assigns to a
+// field of its receiver, or to the receiver itself. Assignments to
+// elements of slices and maps aren't counted, since they're shared.
+func assignsToReceiver(decl *ast.FuncDecl) bool {
+	names := decl.Recv.List[0].Names
+	if len(names) == 0 || names[0].Name == "_" || decl.Body == nil {
+		return false
+	}
+	recv := names[0].Name
+	assigns := false
+	ast.Inspect(decl.Body, func(n ast.Node) bool {
+		var lhs []ast.Expr
+		switch n := n.(type) {
+		case *ast.AssignStmt:
+			lhs = n.Lhs
+		case *ast.IncDecStmt:
+			lhs = []ast.Expr{n.X}
+		}
+		for _, x := range lhs {
+			for {
+				sel, ok := x.(*ast.SelectorExpr)
+				if !ok {
+					break
+				}
+				x = sel.X
+			}
+			if ident, ok := x.(*ast.Ident); ok && ident.Name == recv {
+				assigns = true
+			}
+		}
+		return !assigns
+	})
+	return assigns
+}
+
+// caddyfileReaders are the methods of a caddyfile.Dispenser
+// or httpcaddyfile.Helper which read tokens.
+var caddyfileReaders = map[string]bool{
+	"Next": true, "NextArg": true, "NextBlock": true, "NextLine": true,
+	"Args": true, "AllArgs": true, "RemainingArgs": true, "CountRemainingArgs": true,
+	"Val": true, "UnmarshalCaddyfile": true,
+}
+
+// parsesTokens returns whether body, or a function of the package
+// it calls, reads Caddyfile tokens.
+func parsesTokens(body *ast.BlockStmt, funcs map[string]*ast.FuncDecl, visited map[string]bool) bool {
+	parses := false
+	ast.Inspect(body, func(n ast.Node) bool {
+		call, ok := n.(*ast.CallExpr)
+		if !ok {
+			return !parses
+		}
+		switch fun := call.Fun.(type) {
+		case *ast.SelectorExpr:
+			if caddyfileReaders[fun.Sel.Name] {
+				parses = true
+			}
+		case *ast.Ident:
+			if decl, ok := funcs[fun.Name]; ok && !visited[fun.Name] && decl.Body != nil {
+				visited[fun.Name] = true
+				parses = parsesTokens(decl.Body, funcs, visited)
+			}
+		}
+		return !parses
+	})
+	return parses
+}
+
+// vetModuleInfo checks the caddy.ModuleInfo literals returned by
+// the CaddyModule method decl, with IDs given as string literals.
+func vetModuleInfo(decl *ast.FuncDecl, file vetFile, typeName string, report func(token.Pos, string, ...any)) {
+	if decl.Body == nil {
+		return
+	}
+	ast.Inspect(decl.Body, func(n ast.Node) bool {
+		lit, ok := n.(*ast.CompositeLit)
+		if !ok || file.qualified(lit.Type) != "caddy.ModuleInfo" {
+			return true
+		}
+		hasNew := false
+		for _, elt := range lit.Elts {
+			kv, ok := elt.(*ast.KeyValueExpr)
+			if !ok {
+				continue
+			}
+			key, ok := kv.Key.(*ast.Ident)
+			if !ok {
+				continue
+			}
+			switch key.Name {
+			case "New":
+				hasNew = true
+			case "ID":
+				value, ok := kv.Value.(*ast.BasicLit)
+				if !ok || value.Kind != token.STRING {
+					continue
+				}
+				id, _ := strconv.Unquote(value.Value)
+				if !moduleIDRegexp.MatchString(id) {
+					report(value.Pos(), "module ID %q of %s is malformed: it must be dot-separated labels of lower-case letters, digits and underscores", id, typeName)
+				}
+			}
+		}
+		if !hasNew {
+			report(lit.Pos(), "ModuleInfo of %s has no New function, so Caddy can't create the module", typeName)
+		}
+		return false
+	})
+}
@@ -0,0 +1,150 @@
+package xcaddycmd
+
+import (
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"strings"
+	"testing"
+)
+
+func TestVetPackage(t *testing.T) {
+	const src = `package myplugin
+
+import (
+	"github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/caddyserver/caddy/v2/caddyconfig/httpcaddyfile"
+)
+
+func init() {
+	caddy.RegisterModule(Good{})
+	caddy.RegisterModule(&BadID{})
+	httpcaddyfile.RegisterHandlerDirective("good", nil)
+}
+
+type Good struct{}
+
+func (Good) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "http.handlers.good",
+		New: func() caddy.Module { return new(Good) },
+	}
+}
+
+func (g *Good) Provision(ctx caddy.Context) error { return nil }
+
+func (g *Good) UnmarshalCaddyfile(d *caddyfile.Dispenser) error { return nil }
+
+var (
+	_ caddy.Provisioner     = (*Good)(nil)
+	_ caddyfile.Unmarshaler = (*Good)(nil)
+)
+
+type BadID struct{}
+
+func (BadID) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{
+		ID:  "http.handlers.Bad-ID",
+		New: func() caddy.Module { return new(BadID) },
+	}
+}
+
+type Unregistered struct{ ready bool }
+
+func (Unregistered) CaddyModule() caddy.ModuleInfo {
+	return caddy.ModuleInfo{ID: "http.handlers.unregistered"}
+}
+
+func (u Unregistered) Provision(ctx caddy.Context) error { u.ready = true; return nil }
+
+func (u *Unregistered) Validate() error { return nil }
+
+func (u *Unregistered) Cleanup() {}
+`
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, "myplugin.go", src, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, d := range vetPackage(fset, []*ast.File{f}) {
+		got = append(got, d.String())
+	}
+	expected := []string{
+		`myplugin.go:37:8: module ID "http.handlers.Bad-ID" of BadID is malformed: it must be dot-separated labels of lower-case letters, digits and underscores`,
+		`myplugin.go:44:1: Unregistered is a Caddy module, but isn't registered with caddy.RegisterModule`,
+		`myplugin.go:45:9: ModuleInfo of Unregistered has no New function, so Caddy can't create the module`,
+		`myplugin.go:48:1: Unregistered.Provision has a value receiver, so its changes to the module are lost; use a pointer receiver`,
+		`myplugin.go:50:1: Unregistered.Validate has no interface guard; add var _ caddy.Validator = (*Unregistered)(nil)`,
+		`myplugin.go:52:1: Unregistered.Cleanup has signature func(), but caddy.CleanerUpper needs func() error; Caddy won't call it`,
+	}
+	if strings.Join(got, "\n") != strings.Join(expected, "\n") {
+		t.Errorf("Expected:\n%s\ngot:\n%s", strings.Join(expected, "\n"), strings.Join(got, "\n"))
+	}
+}
+
+func TestVetPackageDirectives(t *testing.T) {
+	const src = `package myplugin
+
+import (
+	caddy2 "github.com/caddyserver/caddy/v2"
+	"github.com/caddyserver/caddy/v2/caddyconfig/caddyfile"
+	"github.com/caddyserver/caddy/v2/caddyconfig/httpcaddyfile"
+	"github.com/caddyserver/caddy/v2/modules/caddyhttp"
+)
+
+func init() {
+	caddy2.RegisterModule(Handler{})
+	caddy2.RegisterModule(Paths{})
+	httpcaddyfile.RegisterHandlerDirective("handler", parseHandler)
+	httpcaddyfile.RegisterHandlerDirective("broken", func(h httpcaddyfile.Helper) (caddyhttp.MiddlewareHandler, error) {
+		return new(Handler), nil
+	})
+}
+
+type Handler struct{}
+
+func (Handler) CaddyModule() caddy2.ModuleInfo {
+	return caddy2.ModuleInfo{ID: "http.handlers.handler", New: func() caddy2.Module { return new(Handler) }}
+}
+
+func (h *Handler) UnmarshalCaddyfile(d *caddyfile.Dispenser) error { return nil }
+
+var _ caddyfile.Unmarshaler = (*Handler)(nil)
+
+func parseHandler(h httpcaddyfile.Helper) (caddyhttp.MiddlewareHandler, error) {
+	return unmarshal(h.Dispenser)
+}
+
+func unmarshal(d *caddyfile.Dispenser) (*Handler, error) {
+	var handler Handler
+	return &handler, handler.UnmarshalCaddyfile(d)
+}
+
+type Paths []string
+
+func (Paths) CaddyModule() caddy2.ModuleInfo {
+	return caddy2.ModuleInfo{ID: "http.matchers.paths", New: func() caddy2.Module { return new(Paths) }}
+}
+
+// elements of a slice are shared, so a value receiver is fine
+func (p Paths) Provision(caddy2.Context) error {
+	for i := range p {
+		p[i] = "/" + p[i]
+	}
+	return nil
+}
+
+var _ caddy2.Provisioner = Paths{}
+`
+	fset := token.NewFileSet()
+	f, err := parser.ParseFile(fset, "myplugin.go", src, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	diagnostics := vetPackage(fset, []*ast.File{f})
+	if len(diagnostics) != 1 || diagnostics[0].Pos.Line != 14 || !strings.Contains(diagnostics[0].Message, "never reads its tokens") {
+		t.Errorf("Expected only the broken directive, got %v", diagnostics)
+	}
+}
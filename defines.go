@@ -0,0 +1,85 @@
+// Copyright 2020 Matthew Holt
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package xcaddy
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// definesVariable is the variable which holds the
+// definitions for plugins; see Builder.Defines.
+const definesVariable = "github.com/caddyserver/xcaddy/defines.encoded"
+
+// checkDefines returns an error if a key of defines is empty,
+// or contains characters which would be ambiguous on the
+// command line, where definitions are given as key=value.
+func checkDefines(defines map[string]string) error {
+	for key := range defines {
+		if key == "" || strings.ContainsAny(key, "= \t\n") {
+			return fmt.Errorf("invalid key of definition %q: must not be empty or contain = or spaces", key)
+		}
+	}
+	return nil
+}
+
+// definesLdflag returns the linker flag which sets the definitions
+// in the package defines; see its variable for the encoding. Map keys
+// are sorted by encoding/json, so the flag, and thus the binary, is
+// reproducible.
+func definesLdflag(defines map[string]string) string {
+	data, _ := json.Marshal(defines)
+	return "-X " + definesVariable + "=" + base64.RawURLEncoding.EncodeToString(data)
+}
+
+// addLdflags adds ldflag to the value of the last -ldflags flag in args,
+// the arguments of `go build`, since only the last one counts. If there
+// is none, a -ldflags flag is added, which keeps the -ldflags of goflags,
+// the GOFLAGS of the build, since it overrides those.
+func addLdflags(args []string, goflags, ldflag string) []string {
+	for i := len(args) - 1; i >= 0; i-- {
+		name, value, hasValue := strings.Cut(args[i], "=")
+		if name != "-ldflags" && name != "--ldflags" {
+			continue
+		}
+		if hasValue {
+			args[i] = name + "=" + strings.TrimSpace(value+" "+ldflag)
+		} else if i+1 < len(args) {
+			args[i+1] = strings.TrimSpace(args[i+1] + " " + ldflag)
+		}
+		return args
+	}
+	var base string
+	for _, flag := range strings.Fields(goflags) {
+		name, value, _ := strings.Cut(flag, "=")
+		if name == "-ldflags" || name == "--ldflags" {
+			base = value
+		}
+	}
+	return append(args, "-ldflags", strings.TrimSpace(base+" "+ldflag))
+}
+
+// sortedKeys returns the keys of m in order.
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,74 @@
+// Copyright 2020 Matthew Holt
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package defines gives Caddy plugins access to the build-time
+// configuration which the operator baked into the binary with
+// `xcaddy build --define key=value`, like a default endpoint:
+//
+//	endpoint := defines.Get("telemetry.endpoint")
+//
+// It has no dependencies, so importing it is cheap. In binaries
+// not built by xcaddy, or without definitions, it is empty.
+package defines
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"sync"
+)
+
+// encoded is set by xcaddy with the linker flag -X to the definitions,
+// as a JSON object encoded with unpadded URL-safe base64, so it needs
+// no quoting in -ldflags.
+var encoded string
+
+var (
+	decodeOnce sync.Once
+	defined    map[string]string
+)
+
+// Lookup returns the value of the definition of key,
+// and whether it was defined.
+func Lookup(key string) (string, bool) {
+	decodeOnce.Do(decode)
+	value, ok := defined[key]
+	return value, ok
+}
+
+// Get returns the value of the definition
+// of key, or "" if it wasn't defined.
+func Get(key string) string {
+	value, _ := Lookup(key)
+	return value
+}
+
+// All returns a copy of all definitions.
+func All() map[string]string {
+	decodeOnce.Do(decode)
+	all := make(map[string]string, len(defined))
+	for k, v := range defined {
+		all[k] = v
+	}
+	return all
+}
+
+// decode decodes encoded; malformed definitions are
+// ignored, since there is nobody to report them to.
+func decode() {
+	data, err := base64.RawURLEncoding.DecodeString(encoded)
+	if err != nil {
+		return
+	}
+	_ = json.Unmarshal(data, &defined)
+}
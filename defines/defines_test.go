@@ -0,0 +1,60 @@
+// Copyright 2020 Matthew Holt
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package defines
+
+import (
+	"reflect"
+	"sync"
+	"testing"
+)
+
+func TestLookup(t *testing.T) {
+	for _, tt := range []struct {
+		name    string
+		encoded string
+		want    map[string]string
+	}{
+		{
+			name: "none",
+			want: map[string]string{},
+		},
+		{
+			// {"a":"1","telemetry.endpoint":"https://t.example.com"}
+			name:    "defined",
+			encoded: "eyJhIjoiMSIsInRlbGVtZXRyeS5lbmRwb2ludCI6Imh0dHBzOi8vdC5leGFtcGxlLmNvbSJ9",
+			want:    map[string]string{"a": "1", "telemetry.endpoint": "https://t.example.com"},
+		},
+		{
+			name:    "malformed",
+			encoded: "not base64!",
+			want:    map[string]string{},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			encoded, decodeOnce, defined = tt.encoded, sync.Once{}, nil
+			if got := All(); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("All() = %v, want %v", got, tt.want)
+			}
+			for k, v := range tt.want {
+				if got, ok := Lookup(k); !ok || got != v {
+					t.Errorf("Lookup(%q) = %q, %v, want %q, true", k, got, ok, v)
+				}
+			}
+			if got, ok := Lookup("missing"); ok || got != "" {
+				t.Errorf("Lookup(missing) = %q, %v, want none", got, ok)
+			}
+		})
+	}
+}
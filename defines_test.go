@@ -0,0 +1,92 @@
+// Copyright 2020 Matthew Holt
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package xcaddy
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDefinesLdflag(t *testing.T) {
+	defines := map[string]string{"telemetry.endpoint": "https://t.example.com/v1?a=b c", "b": ""}
+	flag := definesLdflag(defines)
+	prefix := "-X " + definesVariable + "="
+	if !strings.HasPrefix(flag, prefix) || strings.ContainsAny(strings.TrimPrefix(flag, prefix), " '\"=") {
+		t.Fatalf("definesLdflag() = %q, want a value which needs no quoting", flag)
+	}
+	data, err := base64.RawURLEncoding.DecodeString(strings.TrimPrefix(flag, prefix))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got map[string]string
+	if err := json.Unmarshal(data, &got); err != nil || !reflect.DeepEqual(got, defines) {
+		t.Errorf("definesLdflag() encodes %v (%v), want %v", got, err, defines)
+	}
+	if again := definesLdflag(map[string]string{"b": "", "telemetry.endpoint": "https://t.example.com/v1?a=b c"}); again != flag {
+		t.Errorf("definesLdflag() = %q, then %q for the same definitions", flag, again)
+	}
+}
+
+func TestAddLdflags(t *testing.T) {
+	const x = "-X pkg.v=1"
+	for _, tt := range []struct {
+		name    string
+		args    []string
+		goflags string
+		want    []string
+	}{
+		{
+			name: "separate value",
+			args: []string{"go", "build", "-ldflags", "-w -s", "-trimpath"},
+			want: []string{"go", "build", "-ldflags", "-w -s -X pkg.v=1", "-trimpath"},
+		},
+		{
+			name: "last one counts",
+			args: []string{"go", "build", "-ldflags=-w", "--ldflags=-s"},
+			want: []string{"go", "build", "-ldflags=-w", "--ldflags=-s -X pkg.v=1"},
+		},
+		{
+			name: "none",
+			args: []string{"go", "build", "-o", "caddy"},
+			want: []string{"go", "build", "-o", "caddy", "-ldflags", x},
+		},
+		{
+			name:    "from GOFLAGS",
+			args:    []string{"go", "build"},
+			goflags: "-trimpath -ldflags=-buildid=",
+			want:    []string{"go", "build", "-ldflags", "-buildid= -X pkg.v=1"},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := addLdflags(tt.args, tt.goflags, x); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("addLdflags() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCheckDefines(t *testing.T) {
+	if err := checkDefines(map[string]string{"telemetry.endpoint": "a=b c"}); err != nil {
+		t.Errorf("checkDefines() error = %v", err)
+	}
+	for _, key := range []string{"", "a=b", "a b"} {
+		if err := checkDefines(map[string]string{key: "v"}); err == nil {
+			t.Errorf("checkDefines() of key %q succeeded", key)
+		}
+	}
+}
@@ -17,11 +17,19 @@ package xcaddy
 import (
 	"bytes"
 	"context"
+	"encoding/json"
 	"fmt"
+	"io"
+	"io/fs"
 	"log"
+	"net/url"
 	"os"
 	"os/exec"
+	"path"
 	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
 	"strings"
 	"text/template"
 	"time"
@@ -30,46 +38,78 @@ import (
 	"github.com/google/shlex"
 )
 
-func (b Builder) newEnvironment(ctx context.Context) (*environment, error) {
+// caddyModulePathOf returns the path of the module of
+// Caddy at version, which is v2 unless version says otherwise.
+func caddyModulePathOf(version string) (string, error) {
 	// assume Caddy v2 if no semantic version is provided
 	caddyModulePath := defaultCaddyModulePath
-	if !strings.HasPrefix(b.CaddyVersion, "v") || !strings.Contains(b.CaddyVersion, ".") {
+	if !strings.HasPrefix(version, "v") || !strings.Contains(version, ".") {
 		caddyModulePath += "/v2"
 	}
-	caddyModulePath, err := versionedModulePath(caddyModulePath, b.CaddyVersion)
+	return versionedModulePath(caddyModulePath, version)
+}
+
+// newEnvironment prepares a build environment in folder, which must
+// not exist yet, or in a new temporary folder if folder is empty.
+func (b Builder) newEnvironment(ctx context.Context, folder string) (*environment, error) {
+	caddyModulePath, err := caddyModulePathOf(b.CaddyVersion)
 	if err != nil {
 		return nil, err
 	}
 
-	// clean up any SIV-incompatible module paths real quick
-	for i, p := range b.Plugins {
-		b.Plugins[i].PackagePath, err = versionedModulePath(p.PackagePath, p.Version)
-		if err != nil {
-			return nil, err
+	// excludes and requires only make sense for a specific version
+	for _, e := range b.Excludes {
+		if e.PackagePath == "" || e.Version == "" {
+			return nil, fmt.Errorf("exclude %s: module path and version are required", e)
+		}
+	}
+	for _, r := range b.Requires {
+		if r.PackagePath == "" || r.Version == "" {
+			return nil, fmt.Errorf("require %s: module path and version are required", r)
 		}
 	}
 
-	// create the context for the main module template
-	tplCtx := goModTemplateContext{
-		CaddyModule: caddyModulePath,
+	b.Plugins, err = uniquePlugins(b.Plugins)
+	if err != nil {
+		return nil, err
 	}
-	for _, p := range b.Plugins {
-		tplCtx.Plugins = append(tplCtx.Plugins, p.PackagePath)
+	// a plugin which a replacement was meant for, but which
+	// it doesn't apply to, wouldn't be built as it was asked
+	_, err = b.Plan()
+	if err != nil {
+		return nil, err
 	}
 
-	// evaluate the template for the main module
-	var buf bytes.Buffer
-	tpl, err := template.New("main").Parse(mainModuleTemplate)
+	err = checkReplaceFrom(b.ReplaceFrom)
 	if err != nil {
 		return nil, err
 	}
-	err = tpl.Execute(&buf, tplCtx)
+
+	for _, d := range b.GoModDirectives {
+		if _, err := goModEditFlag(d); err != nil {
+			return nil, err
+		}
+	}
+
+	if b.Bare && len(b.Without) > 0 {
+		return nil, fmt.Errorf("standard modules can't be excluded from a bare build, which has none")
+	}
+
+	err = b.preflight(ctx, folder)
 	if err != nil {
-		return nil, err
+		return nil, &Error{Failure: FailureEnvironment, Err: err}
 	}
 
 	// create the folder in which the build environment will operate
-	tempFolder, err := newTempFolder()
+	tempFolder := folder
+	if tempFolder == "" {
+		tempFolder, err = newTempFolder()
+	} else {
+		err = os.MkdirAll(filepath.Dir(tempFolder), 0o755)
+		if err == nil {
+			err = os.Mkdir(tempFolder, 0o755)
+		}
+	}
 	if err != nil {
 		return nil, err
 	}
@@ -81,44 +121,7 @@ func (b Builder) newEnvironment(ctx context.Context) (*environment, error) {
 			}
 		}
 	}()
-	log.Printf("[INFO] Temporary folder: %s", tempFolder)
-
-	// write the main module file to temporary folder
-	mainPath := filepath.Join(tempFolder, "main.go")
-	log.Printf("[INFO] Writing main module: %s\n%s", mainPath, buf.Bytes())
-	err = os.WriteFile(mainPath, buf.Bytes(), 0o644)
-	if err != nil {
-		return nil, err
-	}
-
-	if len(b.EmbedDirs) > 0 {
-		for _, d := range b.EmbedDirs {
-			err = copy(d.Dir, filepath.Join(tempFolder, "files", d.Name))
-			if err != nil {
-				return nil, err
-			}
-			_, err = os.Stat(d.Dir)
-			if err != nil {
-				return nil, fmt.Errorf("embed directory does not exist: %s", d.Dir)
-			}
-			log.Printf("[INFO] Embedding directory: %s", d.Dir)
-			buf.Reset()
-			tpl, err = template.New("embed").Parse(embeddedModuleTemplate)
-			if err != nil {
-				return nil, err
-			}
-			err = tpl.Execute(&buf, tplCtx)
-			if err != nil {
-				return nil, err
-			}
-			log.Printf("[INFO] Writing 'embedded' module: %s\n%s", mainPath, buf.Bytes())
-			emedPath := filepath.Join(tempFolder, "embed.go")
-			err = os.WriteFile(emedPath, buf.Bytes(), 0o644)
-			if err != nil {
-				return nil, err
-			}
-		}
-	}
+	log.Printf("[INFO] Environment folder: %s", tempFolder)
 
 	env := &environment{
 		caddyVersion:    b.CaddyVersion,
@@ -129,33 +132,21 @@ func (b Builder) newEnvironment(ctx context.Context) (*environment, error) {
 		skipCleanup:     b.SkipCleanup,
 		buildFlags:      b.BuildFlags,
 		modFlags:        b.ModFlags,
+		defines:         b.Defines,
+		stdout:          b.Stdout,
+		stderr:          b.stderr,
+		progress:        b.ProgressFunc,
 	}
-
-	// initialize the go module
-	log.Println("[INFO] Initializing Go module")
-	cmd := env.newGoModCommand(ctx, "init")
-	cmd.Args = append(cmd.Args, "caddy")
-	err = env.runCommand(ctx, cmd)
+	err = env.setGoEnv(ctx, b)
 	if err != nil {
 		return nil, err
 	}
 
-	// specify module replacements before pinning versions
-	replaced := make(map[string]string)
-	for _, r := range b.Replacements {
-		log.Printf("[INFO] Replace %s => %s", r.Old.String(), r.New.String())
-		replaced[r.Old.String()] = r.New.String()
-	}
-	if len(replaced) > 0 {
-		cmd := env.newGoModCommand(ctx, "edit")
-		for o, n := range replaced {
-			cmd.Args = append(cmd.Args, "-replace", fmt.Sprintf("%s=%s", o, n))
-		}
-		err := env.runCommand(ctx, cmd)
-		if err != nil {
-			return nil, err
-		}
+	module, err := env.writeModule(ctx, b)
+	if err != nil {
+		return nil, err
 	}
+	tplCtx, replaced := module.tplCtx, module.replaced
 
 	// check for early abort
 	select {
@@ -164,20 +155,32 @@ func (b Builder) newEnvironment(ctx context.Context) (*environment, error) {
 	default:
 	}
 
-	// The timeout for the `go get` command may be different than `go build`,
-	// so create a new context with the timeout for `go get`
+	// pinning versions has its own timeout, which
+	// still counts towards the total one, if any
 	if env.timeoutGoGet > 0 {
 		var cancel context.CancelFunc
-		ctx, cancel = context.WithTimeout(context.Background(), env.timeoutGoGet)
+		ctx, cancel = context.WithTimeout(ctx, env.timeoutGoGet)
 		defer cancel()
 	}
 
+	// the flags of go get changed over time, so they
+	// depend on the version of the go command
+	env.goVersion, err = env.goCommandVersion(ctx)
+	if err != nil {
+		return nil, err
+	}
+
 	// pin versions by populating go.mod, first for Caddy itself and then plugins
 	log.Println("[INFO] Pinning versions")
 	err = env.execGoGet(ctx, caddyModulePath, env.caddyVersion, "", "")
+	if err != nil && b.CaddyGitFallback && env.caddyVersion != "" && ctx.Err() == nil {
+		log.Printf("[WARNING] Unable to get Caddy %s from the module proxy: %v", env.caddyVersion, err)
+		err = env.useCaddyClone(ctx, b.CaddyRepository)
+	}
 	if err != nil {
 		return nil, err
 	}
+	var pluginGets []Dependency
 nextPlugin:
 	for _, p := range b.Plugins {
 		// if module is locally available, do not "go get" it;
@@ -189,9 +192,23 @@ nextPlugin:
 				continue nextPlugin
 			}
 		}
-		// also pass the Caddy version to prevent it from being upgraded
-		err = env.execGoGet(ctx, p.PackagePath, p.Version, caddyModulePath, env.caddyVersion)
+		pluginGets = append(pluginGets, p)
+	}
+	// also pass the Caddy version to prevent it from being upgraded,
+	// unless Caddy is replaced by a clone, which it can't be upgraded from
+	pinnedCaddyModulePath := caddyModulePath
+	if env.caddyClone != "" {
+		pinnedCaddyModulePath = ""
+	}
+	if env.getPluginsConcurrently(ctx, pluginGets, pinnedCaddyModulePath, env.caddyVersion) {
+		pluginGets = nil
+	}
+	for _, p := range pluginGets {
+		err = env.execGoGet(ctx, p.PackagePath, p.Version, pinnedCaddyModulePath, env.caddyVersion)
 		if err != nil {
+			if suggestions := env.suggestPluginPaths(ctx, p); len(suggestions) > 0 {
+				err = fmt.Errorf("%w; did you mean: %s", err, strings.Join(suggestions, ", "))
+			}
 			return nil, err
 		}
 		// check for early abort
@@ -201,16 +218,627 @@ nextPlugin:
 		default:
 		}
 	}
-
-	// doing an empty "go get -d" can potentially resolve some
-	// ambiguities introduced by one of the plugins;
-	// see https://github.com/caddyserver/xcaddy/pull/92
-	err = env.execGoGet(ctx, "", "", "", "")
-	if err != nil {
-		return nil, err
-	}
-
-	log.Println("[INFO] Build environment ready")
+
+	if b.ReplaceFrom != "" {
+		err = env.replaceFromForks(ctx, b.ReplaceFrom, b.Plugins, replaced)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if len(module.replacePatterns) > 0 {
+		err = env.applyReplacePatterns(ctx, module.replacePatterns, replaced, b.ReplaceRoots)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// patched modules are copied out of the module cache and
+	// replaced with the patched copy, so this must happen after
+	// all versions have been pinned
+	for _, p := range b.Patches {
+		err = env.applyPatch(ctx, p)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// the packages of the standard modules are only known
+	// now, so the main module imports them one by one instead
+	if len(b.Without) > 0 {
+		tplCtx.StandardPackages, err = env.standardPackagesWithout(ctx, b.Without)
+		if err != nil {
+			return nil, err
+		}
+		env.without = b.Without
+		err = env.writeMainModule(tplCtx)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	// doing an empty "go get" can potentially resolve some
+	// ambiguities introduced by one of the plugins;
+	// see https://github.com/caddyserver/xcaddy/pull/92
+	if b.ResolveAmbiguities || !env.importsResolved(ctx, b.buildTags(env)) {
+		err = env.execGoGet(ctx, "", "", "", "")
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	err = env.checkModuleProxies(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	log.Println("[INFO] Build environment ready")
+	return env, nil
+}
+
+// generatedModule is what writeModule wrote of the main module of an
+// environment, which the versions are then resolved for.
+type generatedModule struct {
+	tplCtx goModTemplateContext
+
+	// the replacements written to go.mod, from old to new
+	// module, and the patterns which are applied later
+	replaced        map[string]string
+	replacePatterns []Replace
+}
+
+// writeModule writes the main module of the environment for b, before
+// any versions are resolved: go.mod, with the replacements, excludes,
+// requirements and directives of b, and the main package, along with
+// the embedded directories. The go commands it runs don't need the
+// network, except to look up plugins with a major version suffix.
+func (env *environment) writeModule(ctx context.Context, b Builder) (generatedModule, error) {
+	// initialize the go module
+	log.Println("[INFO] Initializing Go module")
+	cmd := env.newGoModCommand(ctx, "init")
+	cmd.Args = append(cmd.Args, "caddy")
+	err := env.runCommand(ctx, cmd)
+	if err != nil {
+		return generatedModule{}, err
+	}
+
+	// specify module replacements before pinning versions;
+	// patterns can only be expanded once versions are pinned
+	replaced := make(map[string]string)
+	var replacePatterns []Replace
+	for _, r := range b.Replacements {
+		err = r.Validate()
+		if err != nil {
+			return generatedModule{}, err
+		}
+		if r.isPattern() {
+			replacePatterns = append(replacePatterns, r)
+			continue
+		}
+		log.Printf("[INFO] Replace %s => %s", r.Old.String(), r.New.String())
+		// local paths may legitimately contain spaces, so
+		// only module paths are converted to path@version
+		newPath := r.New.String()
+		if !r.New.isLocal() {
+			newPath = r.New.Param()
+		}
+		replaced[r.Old.Param()] = newPath
+	}
+	deps := append([]Dependency{{PackagePath: env.caddyModulePath, Version: b.CaddyVersion}}, b.Plugins...)
+	env.warnings = shadowedVersions(append(deps, b.Requires...), b.Replacements)
+	for _, w := range env.warnings {
+		log.Printf("[WARNING] %s", w)
+	}
+	if len(replaced) > 0 {
+		// in order, so that go.mod and the logs are the same every time
+		cmd := env.newGoModCommand(ctx, "edit")
+		for _, o := range sortedKeys(replaced) {
+			cmd.Args = append(cmd.Args, "-replace", fmt.Sprintf("%s=%s", o, replaced[o]))
+		}
+		err := env.runCommand(ctx, cmd)
+		if err != nil {
+			return generatedModule{}, err
+		}
+	}
+
+	// exclude known-bad versions, also before pinning versions,
+	// so that they are never selected during resolution
+	if len(b.Excludes) > 0 {
+		cmd := env.newGoModCommand(ctx, "edit")
+		for _, e := range b.Excludes {
+			log.Printf("[INFO] Exclude %s", e)
+			cmd.Args = append(cmd.Args, "-exclude", e.String())
+		}
+		err := env.runCommand(ctx, cmd)
+		if err != nil {
+			return generatedModule{}, err
+		}
+	}
+
+	// requirements set minimum versions for dependencies;
+	// go get may still upgrade them if something needs it
+	if len(b.Requires) > 0 {
+		cmd := env.newGoModCommand(ctx, "edit")
+		for _, r := range b.Requires {
+			log.Printf("[INFO] Require %s", r)
+			cmd.Args = append(cmd.Args, "-require", r.String())
+		}
+		err := env.runCommand(ctx, cmd)
+		if err != nil {
+			return generatedModule{}, err
+		}
+	}
+
+	// directives are added last, so they can also
+	// override what xcaddy wrote to go.mod before
+	if len(b.GoModDirectives) > 0 {
+		cmd := env.newGoModCommand(ctx, "edit")
+		for _, d := range b.GoModDirectives {
+			log.Printf("[INFO] go.mod directive: %s", d)
+			flag, err := goModEditFlag(d)
+			if err != nil {
+				return generatedModule{}, err
+			}
+			cmd.Args = append(cmd.Args, flag)
+		}
+		err := env.runCommand(ctx, cmd)
+		if err != nil {
+			return generatedModule{}, err
+		}
+	}
+
+	// clean up any SIV-incompatible module paths real quick;
+	// plugins which are replaced are used as-is, since they
+	// may not exist upstream
+	for i, p := range b.Plugins {
+		if isPackageReplaced(p.PackagePath, replaced) {
+			continue
+		}
+		b.Plugins[i].PackagePath, err = env.resolvePackagePath(ctx, p.PackagePath, p.Version)
+		if err != nil {
+			return generatedModule{}, err
+		}
+	}
+
+	// create the context for the main module template
+	tplCtx := goModTemplateContext{
+		CaddyModule: env.caddyModulePath,
+		Bare:        b.Bare,
+		RunPreset:   b.MainPreset == MainPresetRun,
+		Defaults:    b.MainDefaults,
+	}
+	var pluginPackages []string
+	for _, p := range b.Plugins {
+		pluginPackages = append(pluginPackages, p.PackagePath)
+	}
+	// the order of the plugins given doesn't matter to the build, since
+	// packages are initialized in order of their paths, so the main
+	// package is the same for any order
+	sort.Strings(pluginPackages)
+	if b.BuildMode == BuildModePlugin {
+		// the plugins go into the Go plugin instead, whose
+		// package keeps their modules in go.mod when tidying
+		env.goPlugin = true
+		err = env.writeGoPlugin(pluginPackages)
+		if err != nil {
+			return generatedModule{}, err
+		}
+	} else {
+		tplCtx.Plugins = pluginPackages
+	}
+	if b.Bare {
+		log.Println("[INFO] Leaving out the standard modules of Caddy as requested")
+	}
+	err = env.writeMainModule(tplCtx)
+	if err != nil {
+		return generatedModule{}, err
+	}
+
+	err = env.writeEmbedDirs(b.EmbedDirs, b.EmbedMaxSize)
+	if err != nil {
+		return generatedModule{}, err
+	}
+
+	return generatedModule{tplCtx: tplCtx, replaced: replaced, replacePatterns: replacePatterns}, nil
+}
+
+// writeMainModule writes the main package of
+// the environment, evaluated for tplCtx.
+func (env environment) writeMainModule(tplCtx goModTemplateContext) error {
+	var buf bytes.Buffer
+	tpl, err := template.New("main").Parse(mainModuleTemplate)
+	if err != nil {
+		return err
+	}
+	err = tpl.Execute(&buf, tplCtx)
+	if err != nil {
+		return err
+	}
+	mainPath := filepath.Join(env.tempFolder, "main.go")
+	log.Printf("[INFO] Writing main module: %s\n%s", mainPath, buf.Bytes())
+	return os.WriteFile(mainPath, buf.Bytes(), 0o644)
+}
+
+// standardPackagesWithout returns the packages of the standard modules
+// of Caddy, without those matched by without (see Builder.Without).
+// The standard packages, like modules/standard, only import others,
+// so they are expanded until the packages of the modules remain.
+func (env environment) standardPackagesWithout(ctx context.Context, without []string) ([]string, error) {
+	var pkgs []string
+	queue := []string{env.caddyModulePath + "/modules/standard"}
+	for len(queue) > 0 {
+		pkg := queue[0]
+		queue = queue[1:]
+		cmd := env.newCommand(ctx, utils.GetGo(), "list", "-f", `{{join .Imports "\n"}}`, pkg)
+		var buf bytes.Buffer
+		cmd.Stdout = &buf
+		err := env.runCommand(ctx, cmd)
+		if err != nil {
+			return nil, err
+		}
+		for _, imp := range strings.Fields(buf.String()) {
+			if strings.HasPrefix(imp, env.caddyModulePath+"/") && path.Base(imp) == "standard" {
+				queue = append(queue, imp)
+			} else {
+				pkgs = append(pkgs, imp)
+			}
+		}
+	}
+	kept, err := excludeStandardPackages(pkgs, env.caddyModulePath, without)
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("[INFO] Leaving out standard modules as requested: %s", strings.Join(without, ", "))
+	return kept, nil
+}
+
+// excludeStandardPackages returns pkgs without the packages matched by
+// the patterns in without, which are package paths, either absolute or
+// relative to the modules folder of Caddy, like caddyhttp/templates; a
+// pattern also matches the packages in subfolders. Patterns which don't
+// match any package are an error, since they are most likely typos.
+func excludeStandardPackages(pkgs []string, caddyModulePath string, without []string) ([]string, error) {
+	matched := make(map[string]bool)
+	var kept []string
+nextPackage:
+	for _, pkg := range pkgs {
+		for _, w := range without {
+			if standardPackageMatches(pkg, caddyModulePath, w) {
+				matched[w] = true
+				continue nextPackage
+			}
+		}
+		kept = append(kept, pkg)
+	}
+	for _, w := range without {
+		if !matched[w] {
+			var rel []string
+			for _, pkg := range pkgs {
+				rel = append(rel, strings.TrimPrefix(pkg, caddyModulePath+"/modules/"))
+			}
+			return nil, fmt.Errorf("%s is not a standard module of Caddy; they are: %s", w, strings.Join(rel, ", "))
+		}
+	}
+	return kept, nil
+}
+
+// stillIncluded returns the packages among pkgs which are matched by
+// the patterns in without, since other packages import them.
+func stillIncluded(pkgs []listedPackage, caddyModulePath string, without []string) []string {
+	var included []string
+	for _, pkg := range pkgs {
+		for _, w := range without {
+			if standardPackageMatches(pkg.ImportPath, caddyModulePath, w) {
+				included = append(included, pkg.ImportPath)
+				break
+			}
+		}
+	}
+	return included
+}
+
+// standardPackageMatches reports whether pattern,
+// as explained at excludeStandardPackages, matches pkg.
+func standardPackageMatches(pkg, caddyModulePath, pattern string) bool {
+	pattern = strings.Trim(pattern, "/")
+	if !strings.HasPrefix(pattern, caddyModulePath+"/") {
+		pattern = caddyModulePath + "/modules/" + pattern
+	}
+	return pkg == pattern || strings.HasPrefix(pkg, pattern+"/")
+}
+
+// writeEmbedDirs copies the directories to embed into the
+// environment, along with the module which serves them, after
+// checking that their aliases and files don't collide and that
+// their total size doesn't exceed maxSize (see Builder.EmbedMaxSize).
+func (env environment) writeEmbedDirs(embedDirs []struct {
+	Dir  string `json:"dir,omitempty"`
+	Name string `json:"name,omitempty"`
+}, maxSize int64,
+) error {
+	if len(embedDirs) == 0 {
+		return nil
+	}
+	err := checkEmbedAliases(embedDirs)
+	if err != nil {
+		return err
+	}
+	var totalSize int64
+	var totalFiles int
+	embedded := make(map[string]string)
+	for _, d := range embedDirs {
+		size, files, err := checkEmbedDir(d.Dir, d.Name, embedded)
+		if err != nil {
+			return err
+		}
+		totalSize += size
+		totalFiles += files
+	}
+	if maxSize == 0 {
+		maxSize = DefaultEmbedMaxSize
+	}
+	if maxSize > 0 && totalSize > maxSize {
+		return fmt.Errorf("the embedded directories total %s, more than the maximum of %s; raise the maximum if this is intended", formatMiB(totalSize), formatMiB(maxSize))
+	}
+	log.Printf("[INFO] Embedding %d files totaling %s", totalFiles, formatMiB(totalSize))
+
+	for _, d := range embedDirs {
+		log.Printf("[INFO] Embedding directory: %s", d.Dir)
+		err := copy(d.Dir, filepath.Join(env.tempFolder, "files", d.Name))
+		if err != nil {
+			return err
+		}
+	}
+	var buf bytes.Buffer
+	tpl, err := template.New("embed").Parse(embeddedModuleTemplate)
+	if err != nil {
+		return err
+	}
+	err = tpl.Execute(&buf, goModTemplateContext{CaddyModule: env.caddyModulePath})
+	if err != nil {
+		return err
+	}
+	embedPath := filepath.Join(env.tempFolder, "embed.go")
+	log.Printf("[INFO] Writing 'embedded' module: %s\n%s", embedPath, buf.Bytes())
+	return os.WriteFile(embedPath, buf.Bytes(), 0o644)
+}
+
+// checkEmbedAliases ensures that the aliases of the directories to
+// embed are valid slash-separated paths, and that no two of them are
+// the same or nested in each other. The empty alias and "." both
+// stand for the root of the embedded filesystem, into which any
+// number of directories may be merged.
+func checkEmbedAliases(embedDirs []struct {
+	Dir  string `json:"dir,omitempty"`
+	Name string `json:"name,omitempty"`
+},
+) error {
+	aliases := make(map[string]string)
+	for _, d := range embedDirs {
+		if d.Name == "" || d.Name == "." {
+			continue
+		}
+		if !fs.ValidPath(d.Name) || strings.Contains(d.Name, `\`) {
+			return fmt.Errorf("invalid embed alias %q for %s: it must be a relative slash-separated path without '.' or '..' elements", d.Name, d.Dir)
+		}
+		for alias, dir := range aliases {
+			switch {
+			case alias == d.Name:
+				return fmt.Errorf("embed alias %q is used for both %s and %s", alias, dir, d.Dir)
+			case strings.HasPrefix(d.Name, alias+"/"):
+				return fmt.Errorf("embed alias %q for %s is nested in alias %q for %s", d.Name, d.Dir, alias, dir)
+			case strings.HasPrefix(alias, d.Name+"/"):
+				return fmt.Errorf("embed alias %q for %s is nested in alias %q for %s", alias, dir, d.Name, d.Dir)
+			}
+		}
+		aliases[d.Name] = d.Dir
+	}
+	return nil
+}
+
+// checkEmbedDir ensures that dir is a readable directory containing at
+// least one file, and returns the total size and number of its files.
+// Its files are recorded in embedded by their path in the embedded
+// filesystem under alias, and it is an error if one of them would
+// overwrite a file already recorded there from another directory.
+func checkEmbedDir(dir, alias string, embedded map[string]string) (size int64, files int, err error) {
+	info, err := os.Stat(dir)
+	if err != nil {
+		return 0, 0, fmt.Errorf("embed directory does not exist: %s", dir)
+	}
+	if !info.IsDir() {
+		return 0, 0, fmt.Errorf("embed directory is not a directory: %s", dir)
+	}
+	err = filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return fmt.Errorf("reading embed directory: %v", err)
+		}
+		if d.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return fmt.Errorf("reading embed directory: %v", err)
+		}
+		target := filepath.ToSlash(rel)
+		if alias != "" && alias != "." {
+			target = alias + "/" + target
+		}
+		if other, ok := embedded[target]; ok {
+			return fmt.Errorf("embed directories %s and %s both contain %s, which would be overwritten", other, dir, target)
+		}
+		embedded[target] = dir
+		f, err := os.Open(path)
+		if err != nil {
+			return fmt.Errorf("reading embed directory: %v", err)
+		}
+		info, err := f.Stat()
+		f.Close()
+		if err != nil {
+			return fmt.Errorf("reading embed directory: %v", err)
+		}
+		size += info.Size()
+		files++
+		return nil
+	})
+	if err != nil {
+		return 0, 0, err
+	}
+	if files == 0 {
+		return 0, 0, fmt.Errorf("embed directory contains no files: %s", dir)
+	}
+	return size, files, nil
+}
+
+// formatMiB formats a size in bytes in mebibytes.
+func formatMiB(size int64) string {
+	return fmt.Sprintf("%.1f MiB", float64(size)/(1<<20))
+}
+
+// environmentStateFile is the file in a prepared environment which
+// holds what is needed to build from it again.
+const environmentStateFile = "xcaddy-environment.json"
+
+// environmentState is what a prepared environment was prepared with.
+type environmentState struct {
+	CaddyVersion    string            `json:"caddy_version,omitempty"`
+	CaddyModulePath string            `json:"caddy_module_path"`
+	CaddyClone      string            `json:"caddy_clone,omitempty"`
+	Plugins         []Dependency      `json:"plugins,omitempty"`
+	Warnings        []string          `json:"warnings,omitempty"`
+	GoToolchain     string            `json:"go_toolchain,omitempty"`
+	Sandbox         bool              `json:"sandbox,omitempty"`
+	Netrc           string            `json:"netrc,omitempty"`
+	GoAuth          string            `json:"goauth,omitempty"`
+	CACert          string            `json:"ca_cert,omitempty"`
+	ClientCert      string            `json:"client_cert,omitempty"`
+	ClientKey       string            `json:"client_key,omitempty"`
+	Insecure        []string          `json:"insecure,omitempty"`
+	ModuleProxies   []ModuleProxy     `json:"module_proxies,omitempty"`
+	ModCache        string            `json:"modcache,omitempty"`
+	Offline         bool              `json:"offline,omitempty"`
+	Without         []string          `json:"without,omitempty"`
+	Defines         map[string]string `json:"defines,omitempty"`
+	GoPlugin        bool              `json:"go_plugin,omitempty"`
+}
+
+// saveState writes the state of the environment to its folder,
+// so it can be opened again with openEnvironment.
+func (env environment) saveState() error {
+	data, err := json.MarshalIndent(environmentState{
+		CaddyVersion:    env.caddyVersion,
+		CaddyModulePath: env.caddyModulePath,
+		CaddyClone:      env.caddyClone,
+		Plugins:         env.plugins,
+		Warnings:        env.warnings,
+		GoToolchain:     env.goToolchain,
+		Sandbox:         env.sandbox != nil,
+		Netrc:           env.netrc,
+		GoAuth:          env.goAuth,
+		CACert:          env.caCert,
+		ClientCert:      env.clientCert,
+		ClientKey:       env.clientKey,
+		Insecure:        env.insecure,
+		ModuleProxies:   env.moduleProxies,
+		ModCache:        env.modCache,
+		Offline:         env.offline,
+		Without:         env.without,
+		Defines:         env.defines,
+		GoPlugin:        env.goPlugin,
+	}, "", "\t")
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(env.tempFolder, environmentStateFile), data, 0o644)
+}
+
+// openEnvironment opens the environment prepared in folder by
+// PrepareEnvironment. The environment is never cleaned up on Close.
+func (b Builder) openEnvironment(ctx context.Context, folder string) (*environment, error) {
+	folder, err := filepath.Abs(folder)
+	if err != nil {
+		return nil, err
+	}
+	data, err := os.ReadFile(filepath.Join(folder, environmentStateFile))
+	if err != nil {
+		return nil, fmt.Errorf("opening environment: %v", err)
+	}
+	var state environmentState
+	err = json.Unmarshal(data, &state)
+	if err != nil {
+		return nil, fmt.Errorf("opening environment %s: %v", folder, err)
+	}
+	log.Printf("[INFO] Using prepared environment: %s", folder)
+
+	env := &environment{
+		caddyVersion:    state.CaddyVersion,
+		plugins:         state.Plugins,
+		caddyModulePath: state.CaddyModulePath,
+		caddyClone:      state.CaddyClone,
+		tempFolder:      folder,
+		timeoutGoGet:    b.TimeoutGet,
+		skipCleanup:     true,
+		buildFlags:      b.BuildFlags,
+		modFlags:        b.ModFlags,
+		warnings:        state.Warnings,
+		without:         state.Without,
+		defines:         b.Defines,
+		goPlugin:        state.GoPlugin,
+		stdout:          b.Stdout,
+		stderr:          b.stderr,
+		progress:        b.ProgressFunc,
+	}
+	if len(env.defines) == 0 {
+		env.defines = state.Defines
+	}
+	if b.GoVersion == "" {
+		b.GoVersion = state.GoToolchain
+	}
+	b.Sandbox = b.Sandbox || state.Sandbox
+	if b.Netrc == "" {
+		b.Netrc = state.Netrc
+	}
+	if b.GoAuth == "" {
+		b.GoAuth = state.GoAuth
+	}
+	if b.CACert == "" {
+		b.CACert = state.CACert
+	}
+	if b.ClientCert == "" && b.ClientKey == "" {
+		b.ClientCert, b.ClientKey = state.ClientCert, state.ClientKey
+	}
+	if len(b.Insecure) == 0 {
+		b.Insecure = state.Insecure
+	}
+	if len(b.ModuleProxies) == 0 {
+		b.ModuleProxies = state.ModuleProxies
+	}
+	if b.ModCache == "" {
+		b.ModCache = state.ModCache
+	}
+	b.Offline = b.Offline || state.Offline
+	err = env.setGoEnv(ctx, b)
+	if err != nil {
+		return nil, err
+	}
+
+	// files added by previous builds must not leak into this one
+	for _, name := range []string{"provenance.bin", "provenance.go", "invocation.bin", "invocation.go"} {
+		err = os.Remove(filepath.Join(folder, name))
+		if err != nil && !os.IsNotExist(err) {
+			return nil, err
+		}
+	}
+	if len(b.EmbedDirs) > 0 {
+		err = os.RemoveAll(filepath.Join(folder, "files"))
+		if err != nil {
+			return nil, err
+		}
+		err = env.writeEmbedDirs(b.EmbedDirs, b.EmbedMaxSize)
+		if err != nil {
+			return nil, err
+		}
+	}
 	return env, nil
 }
 
@@ -218,11 +846,66 @@ type environment struct {
 	caddyVersion    string
 	plugins         []Dependency
 	caddyModulePath string
+	caddyClone      string
 	tempFolder      string
 	timeoutGoGet    time.Duration
 	skipCleanup     bool
 	buildFlags      string
 	modFlags        string
+	goFlags         string
+	goFlagsTags     []string
+	goToolchain     string
+	goVersion       string
+	sandbox         *sandbox
+	netrc           string
+	goAuth          string
+	proxy           string
+	noProxy         string
+	caCert          string
+	caBundle        string
+	clientCert      string
+	clientKey       string
+	insecure        []string
+	moduleProxies   []ModuleProxy
+	goProxy         string
+	goNoSumDB       string
+	modCache        string
+	offline         bool
+	stdout          io.Writer
+	stderr          io.Writer
+	progress        func(Event)
+
+	// the plugins which requested plugins pulled in; see findTransitivePlugins
+	transitivePlugins []string
+
+	// the patterns of the modules fetched without verifying
+	// TLS, including those of GOINSECURE of the caller
+	insecureModules []string
+
+	// the standard modules left out; see Builder.Without
+	without []string
+
+	// the definitions for plugins; see Builder.Defines
+	defines map[string]string
+
+	// whether the plugins are built into a Go plugin;
+	// see Builder.BuildMode
+	goPlugin bool
+
+	// problems with the configuration which
+	// don't prevent the build from working
+	warnings []string
+}
+
+// logWarnings repeats the warnings about the configuration,
+// since they are easy to miss among the output of the build.
+func (env environment) logWarnings() {
+	for _, w := range env.warnings {
+		log.Printf("[WARNING] %s", w)
+	}
+	if len(env.insecureModules) > 0 {
+		log.Printf("[WARNING] %s", insecureWarning(env.insecureModules))
+	}
 }
 
 // Close cleans up the build environment, including deleting
@@ -239,11 +922,145 @@ func (env environment) Close() error {
 func (env environment) newCommand(ctx context.Context, command string, args ...string) *exec.Cmd {
 	cmd := exec.CommandContext(ctx, command, args...)
 	cmd.Dir = env.tempFolder
+	cmd.Env = env.environ(os.Environ())
 	cmd.Stdout = os.Stdout
+	if env.stdout != nil {
+		cmd.Stdout = env.stdout
+	}
 	cmd.Stderr = os.Stderr
+	if env.stderr != nil {
+		cmd.Stderr = io.MultiWriter(os.Stderr, env.stderr)
+	}
 	return cmd
 }
 
+// environ sets the variables for the go commands of
+// the environment in the environment variables base.
+func (env environment) environ(base []string) []string {
+	base = setEnv(base, "GOFLAGS="+env.goFlags)
+	if env.goToolchain != "" {
+		base = setEnv(base, "GOTOOLCHAIN="+env.goToolchain)
+	}
+	if env.sandbox != nil {
+		base = env.sandbox.environ(base)
+	}
+	if env.netrc != "" {
+		base = setEnv(base, "NETRC="+env.netrc)
+	}
+	if env.goAuth != "" {
+		base = setEnv(base, "GOAUTH="+env.goAuth)
+	}
+	base = proxyEnviron(base, env.proxy, env.noProxy)
+	base = tlsEnviron(base, env.caBundle, env.clientCert, env.clientKey, env.insecure)
+	if env.goProxy != "" {
+		base = setEnv(base, "GOPROXY="+env.goProxy)
+		base = setEnv(base, "GONOSUMDB="+env.goNoSumDB)
+	}
+	if env.modCache != "" {
+		base = setEnv(base, "GOMODCACHE="+env.modCache)
+	}
+	if env.offline {
+		base = offlineEnviron(base, env.modCache)
+	}
+	return base
+}
+
+// setGoEnv sets up the GOFLAGS, GOTOOLCHAIN, sandbox, credentials,
+// module proxies and module cache of the go commands of the
+// environment for b.
+func (env *environment) setGoEnv(ctx context.Context, b Builder) error {
+	env.goFlags, env.goFlagsTags = goFlags(b.IgnoreGoFlags)
+	toolchain, err := goToolchain(b.GoVersion)
+	if err != nil {
+		return err
+	}
+	if toolchain != "" {
+		log.Printf("[INFO] Using Go toolchain %s", toolchain)
+	}
+	env.goToolchain = toolchain
+	if b.Netrc != "" {
+		env.netrc, err = netrcFile(b.Netrc)
+		if err != nil {
+			return err
+		}
+		log.Printf("[INFO] Using credentials from %s for downloading modules", env.netrc)
+	}
+	if b.GoAuth != "" {
+		env.goAuth = b.GoAuth
+		log.Printf("[INFO] Authenticating module downloads with GOAUTH")
+	}
+	if b.Proxy != "" {
+		env.proxy, err = proxyURL(b.Proxy)
+		if err != nil {
+			return err
+		}
+		proxy, _ := url.Parse(env.proxy)
+		log.Printf("[INFO] Using proxy %s", proxy.Redacted())
+	}
+	env.noProxy = b.NoProxy
+	if b.CACert != "" {
+		env.caCert, err = filepath.Abs(b.CACert)
+		if err != nil {
+			return err
+		}
+		env.caBundle, err = writeCABundle(env.tempFolder, env.caCert)
+		if err != nil {
+			return err
+		}
+	}
+	env.clientCert, env.clientKey, err = clientCertFiles(b.ClientCert, b.ClientKey)
+	if err != nil {
+		return err
+	}
+	env.insecure = b.Insecure
+	env.insecureModules = insecurePatterns(os.Environ(), env.insecure)
+	if len(env.insecureModules) > 0 {
+		log.Printf("[WARNING] %s", insecureWarning(env.insecureModules))
+	}
+	if b.Sandbox {
+		env.sandbox, err = newSandbox(ctx, env.tempFolder, env.netrc)
+		if err != nil {
+			return err
+		}
+	}
+	if b.ModCache != "" || b.Offline {
+		env.modCache, err = b.moduleCache(ctx)
+		if err != nil {
+			return err
+		}
+		log.Printf("[INFO] Using module cache %s", env.modCache)
+	}
+	if b.Offline {
+		if len(b.ModuleProxies) > 0 {
+			return fmt.Errorf("module proxies can't be used offline, when modules are only taken from the module cache")
+		}
+		env.offline = true
+		log.Println("[INFO] Working offline, with the modules in the module cache only")
+	}
+	if len(b.ModuleProxies) > 0 {
+		return env.setModuleProxies(ctx, b.ModuleProxies)
+	}
+	return nil
+}
+
+// goToolchain returns the value of GOTOOLCHAIN which selects the Go
+// toolchain of version (see Builder.GoVersion), or "" if it is empty.
+func goToolchain(version string) (string, error) {
+	if version == "" {
+		return "", nil
+	}
+	if !goVersionRegexp.MatchString(version) {
+		return "", fmt.Errorf("invalid Go version %q: must be a release like 1.22.5", version)
+	}
+	return "go" + strings.TrimPrefix(version, "go"), nil
+}
+
+// goVersionRegexp matches the versions of Go toolchain
+// releases, like 1.22.5 or 1.23rc1, optionally prefixed
+// with "go" as in GOTOOLCHAIN. Language versions like
+// 1.22 are not toolchains, so they don't match.
+var goVersionRegexp = regexp.MustCompile(`^(go)?1\.\d+(\.\d+|rc\d+)$`)
+
 // newGoBuildCommand creates a new *exec.Cmd which assumes the first element in `args` is one of: build, clean, get, install, list, run, or test. The
 // created command will also have the value of `XCADDY_GO_BUILD_FLAGS` appended to its arguments, if set.
 func (env environment) newGoBuildCommand(ctx context.Context, goCommand string, args ...string) (*exec.Cmd, error) {
@@ -289,12 +1106,26 @@ func (env environment) runCommand(ctx context.Context, cmd *exec.Cmd) error {
 		timeout = time.Until(deadline)
 	}
 	log.Printf("[INFO] exec (timeout=%s): %+v ", timeout, cmd)
+	if env.progress != nil {
+		env.progress(CommandExec{Args: cmd.Args, Dir: cmd.Dir})
+		cmd.Stdout = withOutputChunks(cmd.Stdout, env.progress, cmd.Args, "stdout")
+		cmd.Stderr = withOutputChunks(cmd.Stderr, env.progress, cmd.Args, "stderr")
+	}
 
-	// start the command; if it fails to start, report error immediately
-	err := cmd.Start()
+	// start the command; if it fails to start, report error immediately;
+	// commands which may read from the terminal must stay in its
+	// process group, otherwise they are stopped when they do
+	var err error
+	release := func() {}
+	if cmd.Stdin == nil {
+		release, err = startProcessGroup(cmd)
+	} else {
+		err = cmd.Start()
+	}
 	if err != nil {
 		return err
 	}
+	defer release()
 
 	// wait for the command in a goroutine; the reason for this is
 	// very subtle: if, in our select, we do `case cmdErr := <-cmd.Wait()`,
@@ -316,8 +1147,8 @@ func (env environment) runCommand(ctx context.Context, cmd *exec.Cmd) error {
 	case <-ctx.Done():
 		// context was canceled, either due to timeout or
 		// maybe a signal from higher up canceled the parent
-		// context; presumably, the OS also sent the signal
-		// to the child process, so wait for it to die
+		// context; the command (and its process group, if
+		// it has one) is killed by now, so wait for it to die
 		select {
 		case <-time.After(15 * time.Second):
 			_ = cmd.Process.Kill()
@@ -327,13 +1158,61 @@ func (env environment) runCommand(ctx context.Context, cmd *exec.Cmd) error {
 	}
 }
 
-// execGoGet runs "go get -v" with the given module/version as an argument.
+// runBuildCommand runs cmd, a go build command, like runCommand. If it
+// fails because of missing go.sum entries, which happens when plugins
+// have incomplete go.sum files, the modules are downloaded and cmd is
+// retried once with -mod=mod, which lets it add the missing entries.
+func (env environment) runBuildCommand(ctx context.Context, cmd *exec.Cmd) error {
+	// runCommand wraps the outputs of cmd for the progress, so the
+	// retry is given those of the caller rather than the wrapped ones
+	stdout, output := cmd.Stdout, cmd.Stderr
+	var stderr bytes.Buffer
+	cmd.Stderr = io.MultiWriter(output, &stderr)
+	err := env.runCommand(ctx, cmd)
+	if err == nil || ctx.Err() != nil || !goSumErrorRegexp.Match(stderr.Bytes()) {
+		return err
+	}
+	log.Printf("[WARNING] The build failed because of missing go.sum entries; downloading modules and retrying with -mod=mod")
+	err = env.runCommand(ctx, env.newGoModCommand(ctx, "download"))
+	if err != nil {
+		return err
+	}
+	retry := env.newCommand(ctx, cmd.Path, cmd.Args[1:]...)
+	retry.Dir = cmd.Dir
+	if cmd.Env != nil {
+		retry.Env = append([]string(nil), cmd.Env...)
+	}
+	retry.Stdin = cmd.Stdin
+	retry.Stdout = stdout
+	retry.Stderr = output
+	retry.Env = setEnv(retry.Env, "GOFLAGS="+strings.TrimSpace(env.goFlags+" -mod=mod"))
+	return env.runCommand(ctx, retry)
+}
+
+// goSumErrorRegexp matches the errors of the go command
+// about go.sum entries which are missing.
+var goSumErrorRegexp = regexp.MustCompile(`missing go\.sum entry|updates to go\.sum needed`)
+
+// execGoGet runs "go get -v" with the given module/version as an argument,
+// or none if modulePath is empty, which resolves the main package.
 // Also allows passing in a second module/version pair, meant to be the main
 // Caddy module/version we're building against; this will prevent the
 // plugin module from causing the Caddy version to upgrade, if the plugin
 // version requires a newer version of Caddy.
 // See https://github.com/caddyserver/xcaddy/issues/54
 func (env environment) execGoGet(ctx context.Context, modulePath, moduleVersion, caddyModulePath, caddyVersion string) error {
+	cmd, err := env.newGoBuildCommand(ctx, "get", goGetFlags(env.goVersion)...)
+	if err != nil {
+		return err
+	}
+	cmd.Args = append(cmd.Args, goGetArgs(modulePath, moduleVersion, caddyModulePath, caddyVersion)...)
+	return env.runCommand(ctx, cmd)
+}
+
+// goGetArgs returns the arguments of "go get" for the module at
+// moduleVersion and Caddy at caddyVersion, leaving out either if
+// its path is empty.
+func goGetArgs(modulePath, moduleVersion, caddyModulePath, caddyVersion string) []string {
 	mod := modulePath
 	if moduleVersion != "" {
 		mod += "@" + moduleVersion
@@ -343,42 +1222,548 @@ func (env environment) execGoGet(ctx context.Context, modulePath, moduleVersion,
 		caddy += "@" + caddyVersion
 	}
 
-	cmd, err := env.newGoBuildCommand(ctx, "get", "-v")
-	if err != nil {
-		return err
-	}
 	// using an empty string as an additional argument to "go get"
 	// breaks the command since it treats the empty string as a
-	// distinct argument, so we're using an if statement to avoid it.
+	// distinct argument, so we're using if statements to avoid it.
+	var args []string
+	if mod != "" {
+		args = append(args, mod)
+	}
 	if caddy != "" {
-		cmd.Args = append(cmd.Args, mod, caddy)
+		args = append(args, caddy)
+	}
+	return args
+}
+
+// goGetFlags returns the flags of "go get" for the go command of
+// goVersion, like go1.22.5. Before Go 1.18, go get also built and
+// installed the packages unless -d was given, which has been
+// deprecated since, and is a no-op printing a warning. If the
+// version is unknown, the go command is assumed to be recent.
+func goGetFlags(goVersion string) []string {
+	if minor, ok := goMinorVersion(goVersion); ok && minor < 18 {
+		return []string{"-d", "-v"}
+	}
+	return []string{"-v"}
+}
+
+// goMinorVersion returns the minor version of the Go release
+// goVersion, like 22 for go1.22.5 or go1.22rc1, and false if
+// it is not a release, like a development version.
+func goMinorVersion(goVersion string) (int, bool) {
+	m := goReleaseRegexp.FindStringSubmatch(goVersion)
+	if m == nil {
+		return 0, false
+	}
+	minor, err := strconv.Atoi(m[1])
+	return minor, err == nil
+}
+
+// goReleaseRegexp matches the versions of Go releases as
+// reported by the go command, capturing the minor version.
+var goReleaseRegexp = regexp.MustCompile(`^go1\.(\d+)(\.\d+|rc\d+|beta\d+)?$`)
+
+// goCommandVersion returns the version of the go command of the
+// environment, like go1.22.5, which is that of its toolchain.
+func (env environment) goCommandVersion(ctx context.Context) (string, error) {
+	cmd := env.newCommand(ctx, utils.GetGo(), "env", "GOVERSION")
+	cmd.Stdout = nil
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("exec %v: %v", cmd.Args, err)
+	}
+	goVersion := strings.TrimSpace(string(out))
+	log.Printf("[INFO] Using %s", goVersion)
+	return goVersion, nil
+}
+
+// resolvePackagePath returns the import path of the package at
+// packagePath for use with the given module version, enforcing
+// Semantic Import Versioning like versionedModulePath does. But
+// a package may live in a subdirectory of its module, for example
+// in a monorepo, in which case the major version suffix belongs
+// after the module root rather than at the end of the package path.
+// If the SIV-corrected path is not a module, the module boundary is
+// found by querying the module proxy for each parent path.
+func (env environment) resolvePackagePath(ctx context.Context, packagePath, version string) (string, error) {
+	versioned, err := versionedModulePath(packagePath, version)
+	if err != nil {
+		return "", err
+	}
+	// nothing was added to the path, so there is
+	// nothing that could have been put in the wrong place
+	if versioned == path.Clean(packagePath) || env.moduleExists(ctx, versioned, version) {
+		return versioned, nil
+	}
+
+	elems := strings.Split(path.Clean(packagePath), "/")
+	for i := len(elems) - 1; i > 0; i-- {
+		candidate, err := versionedModulePath(strings.Join(elems[:i], "/"), version)
+		if err != nil {
+			continue
+		}
+		if env.moduleExists(ctx, candidate, version) {
+			resolved := path.Join(candidate, strings.Join(elems[i:], "/"))
+			log.Printf("[INFO] Resolved package %s@%s to %s within module %s", packagePath, version, resolved, candidate)
+			return resolved, nil
+		}
+	}
+
+	// couldn't find a better fit; let go get report the problem
+	return versioned, nil
+}
+
+// isPackageReplaced returns true if the package at packagePath is
+// within a module among replaced, at any version; see isReplaced.
+func isPackageReplaced(packagePath string, replaced map[string]string) bool {
+	for old := range replaced {
+		if oldPath, _, _ := strings.Cut(old, "@"); withinPath(packagePath, oldPath) {
+			return true
+		}
+	}
+	return false
+}
+
+// moduleExists returns true if a module at modulePath
+// with the given version can be found.
+func (env environment) moduleExists(ctx context.Context, modulePath, version string) bool {
+	cmd, err := env.newGoBuildCommand(ctx, "list", "-m", modulePath+"@"+version)
+	if err != nil {
+		return false
+	}
+	cmd.Stdout = io.Discard
+	cmd.Stderr = io.Discard
+	return env.runCommand(ctx, cmd) == nil
+}
+
+// applyReplacePatterns replaces each module of the build which
+// matches one of the patterns, unless it is replaced already.
+// Local replacements only apply if their directory exists, so
+// not every module matching the pattern needs to be forked.
+// Each of them must be within one of roots, if any, once the
+// pattern is expanded.
+func (env environment) applyReplacePatterns(ctx context.Context, patterns []Replace, replaced map[string]string, roots []string) error {
+	cmd, err := env.newGoBuildCommand(ctx, "list", "-m", "-f", "{{.Path}}", "all")
+	if err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	cmd.Stdout = &buf
+	err = env.runCommand(ctx, cmd)
+	if err != nil {
+		return err
+	}
+	modulePaths := strings.Fields(buf.String())
+
+	editCmd := env.newGoModCommand(ctx, "edit")
+	var count int
+	for _, pattern := range patterns {
+		for _, r := range expandReplacePattern(pattern, modulePaths) {
+			if isReplaced(r.Old.Param(), replaced) {
+				continue
+			}
+			newPath := r.New.String()
+			if r.New.isLocal() {
+				if _, err := os.Stat(filepath.Join(newPath, "go.mod")); err != nil {
+					continue
+				}
+				if err := checkReplaceRoots([]Replace{r}, roots); err != nil {
+					return &Error{Failure: FailurePolicy, Err: err}
+				}
+			} else {
+				newPath = r.New.Param()
+			}
+			log.Printf("[INFO] Replace %s => %s (from %s)", r.Old, newPath, pattern.Old)
+			replaced[r.Old.Param()] = newPath
+			editCmd.Args = append(editCmd.Args, "-replace", fmt.Sprintf("%s=%s", r.Old.Param(), newPath))
+			count++
+		}
+	}
+	if count == 0 {
+		log.Printf("[WARNING] No modules of the build were replaced by the patterns")
+		return nil
+	}
+	return env.runCommand(ctx, editCmd)
+}
+
+// requiredModules returns the paths of the modules
+// which are required by the go.mod of the environment.
+func (env environment) requiredModules(ctx context.Context) (map[string]bool, error) {
+	cmd := env.newGoModCommand(ctx, "edit", "-json")
+	var buf bytes.Buffer
+	cmd.Stdout = &buf
+	err := env.runCommand(ctx, cmd)
+	if err != nil {
+		return nil, err
+	}
+	var goMod struct {
+		Require []struct {
+			Path string
+		}
+	}
+	err = json.Unmarshal(buf.Bytes(), &goMod)
+	if err != nil {
+		return nil, fmt.Errorf("parsing go.mod: %v", err)
+	}
+	required := make(map[string]bool)
+	for _, r := range goMod.Require {
+		required[r.Path] = true
+	}
+	return required, nil
+}
+
+// droppedPlugins returns the package paths of the plugins whose
+// module was required before, but not after, which happens when
+// `go mod tidy -e` can't resolve the packages of a plugin.
+func droppedPlugins(plugins []Dependency, before, after map[string]bool) []string {
+	var dropped []string
+	for _, p := range plugins {
+		// the module of a package is the longest matching path
+		var modulePath string
+		for path := range before {
+			if (p.PackagePath == path || strings.HasPrefix(p.PackagePath, path+"/")) && len(path) > len(modulePath) {
+				modulePath = path
+			}
+		}
+		if modulePath != "" && !after[modulePath] {
+			dropped = append(dropped, p.PackagePath)
+		}
+	}
+	return dropped
+}
+
+// listedPackage is the part of the output of
+// `go list -json` which listDeps keeps.
+type listedPackage struct {
+	ImportPath string
+	Dir        string
+	GoFiles    []string
+	Imports    []string
+	Standard   bool
+	Module     *struct {
+		Path string
+		Main bool
+	}
+}
+
+// listDeps returns the packages which are part of the build,
+// including those of the Go plugin, if any.
+func (env environment) listDeps(ctx context.Context) ([]listedPackage, error) {
+	cmd := env.newCommand(ctx, utils.GetGo(), "list", "-deps", "-json=ImportPath,Dir,GoFiles,Imports,Standard,Module", ".")
+	if env.goPlugin {
+		cmd.Args = append(cmd.Args, "./"+goPluginFolder)
+	}
+	var buf bytes.Buffer
+	cmd.Stdout = &buf
+	err := env.runCommand(ctx, cmd)
+	if err != nil {
+		return nil, err
+	}
+	var pkgs []listedPackage
+	dec := json.NewDecoder(&buf)
+	for dec.More() {
+		var pkg listedPackage
+		err = dec.Decode(&pkg)
+		if err != nil {
+			return nil, fmt.Errorf("parsing go list output: %v", err)
+		}
+		pkgs = append(pkgs, pkg)
+	}
+	return pkgs, nil
+}
+
+// findTransitivePlugins returns the import paths of the packages among
+// pkgs which are neither part of Caddy nor requested plugins, but import
+// Caddy and register modules with it, as reported by registers. Requested
+// plugins pull them in, and they show up in `caddy list-modules` as well.
+func findTransitivePlugins(pkgs []listedPackage, caddyModulePath string, plugins []Dependency, registers func(listedPackage) bool) []string {
+	requested := make(map[string]bool)
+	for _, p := range plugins {
+		requested[p.PackagePath] = true
+	}
+	var found []string
+	for _, pkg := range pkgs {
+		if pkg.Standard || pkg.Module == nil || pkg.Module.Main || pkg.Module.Path == caddyModulePath || requested[pkg.ImportPath] {
+			continue
+		}
+		importsCaddy := false
+		for _, imp := range pkg.Imports {
+			if imp == caddyModulePath {
+				importsCaddy = true
+				break
+			}
+		}
+		if importsCaddy && registers(pkg) {
+			found = append(found, pkg.ImportPath)
+		}
+	}
+	sort.Strings(found)
+	return found
+}
+
+// registersModules reports whether the source of pkg calls
+// caddy.RegisterModule, which plugins do in an init function.
+func registersModules(pkg listedPackage) bool {
+	for _, name := range pkg.GoFiles {
+		src, err := os.ReadFile(filepath.Join(pkg.Dir, name))
+		if err == nil && bytes.Contains(src, []byte("RegisterModule(")) {
+			return true
+		}
+	}
+	return false
+}
+
+// suggestPluginPaths returns alternatives to the path of plugin p
+// which do exist, for when getting p failed; see pluginPathCandidates.
+func (env environment) suggestPluginPaths(ctx context.Context, p Dependency) []string {
+	version := p.Version
+	if version == "" {
+		version = "latest"
+	}
+	var suggestions []string
+	for _, candidate := range pluginPathCandidates(p.PackagePath) {
+		if env.moduleExists(ctx, candidate, version) {
+			suggestions = append(suggestions, Dependency{PackagePath: candidate, Version: p.Version}.String())
+		}
+	}
+	return suggestions
+}
+
+// pluginPathCandidates returns plausible corrections for common
+// mistakes in a plugin's package path: a missing or superfluous
+// major version suffix, a path to a package within a module rather
+// than the module itself, and modules which keep their Caddy plugin
+// in a "caddy" subdirectory (like github.com/dunglas/mercure/caddy).
+func pluginPathCandidates(packagePath string) []string {
+	packagePath = path.Clean(packagePath)
+	var candidates []string
+	if matches := moduleVersionRegexp.FindStringSubmatch(packagePath); len(matches) == 2 {
+		candidates = append(candidates, path.Dir(packagePath))
 	} else {
-		cmd.Args = append(cmd.Args, mod)
+		candidates = append(candidates, packagePath+"/v2")
+	}
+	candidates = append(candidates, packagePath+"/caddy")
+	// the first two elements are the host and owner at best
+	for dir := path.Dir(packagePath); strings.Count(dir, "/") >= 2; dir = path.Dir(dir) {
+		if dir != candidates[0] {
+			candidates = append(candidates, dir)
+		}
+	}
+	return candidates
+}
+
+// useCaddyClone clones the Caddy repository at env.caddyVersion, which
+// may be any ref such as a branch or a (full) commit hash, into the
+// build environment and replaces the Caddy module with the clone.
+func (env *environment) useCaddyClone(ctx context.Context, repository string) error {
+	if repository == "" {
+		repository = defaultCaddyRepository
+	}
+	cloneDir := filepath.Join(env.tempFolder, "caddy-src")
+	log.Printf("[INFO] Cloning %s at %s", repository, env.caddyVersion)
+
+	// fetching just the one ref works for both branches and commits,
+	// unlike `git clone --branch`, which doesn't accept commits
+	for _, args := range [][]string{
+		{"init", "--quiet", cloneDir},
+		{"-C", cloneDir, "fetch", "--depth", "1", repository, env.caddyVersion},
+		{"-C", cloneDir, "checkout", "--quiet", "FETCH_HEAD"},
+	} {
+		err := env.runCommand(ctx, env.newCommand(ctx, "git", args...))
+		if err != nil {
+			return fmt.Errorf("cloning %s at %s: %v", repository, env.caddyVersion, err)
+		}
+	}
+
+	// the version required doesn't matter since all versions are
+	// replaced, but there must be a requirement for the replacement
+	cmd := env.newGoModCommand(ctx, "edit")
+	cmd.Args = append(cmd.Args,
+		"-replace", fmt.Sprintf("%s=%s", env.caddyModulePath, cloneDir),
+		"-require", env.caddyModulePath+"@"+placeholderVersion(env.caddyModulePath),
+	)
+	err := env.runCommand(ctx, cmd)
+	if err != nil {
+		return err
+	}
+	env.caddyClone = cloneDir
+	env.caddyVersion = ""
+	return nil
+}
+
+// placeholderVersion returns the lowest valid version for the
+// module at modulePath, for use in requirements of modules which
+// are replaced by a directory.
+func placeholderVersion(modulePath string) string {
+	if matches := moduleVersionRegexp.FindStringSubmatch(modulePath); len(matches) == 2 {
+		return "v" + matches[1] + ".0.0"
+	}
+	return "v0.0.0-00010101000000-000000000000"
+}
+
+// applyPatch copies the source of the module named by p into the
+// build environment, applies the patch file to that copy, then
+// replaces the module with the patched copy. The module must
+// already be part of the build list.
+func (env environment) applyPatch(ctx context.Context, p Patch) error {
+	patchFile, err := filepath.Abs(p.File)
+	if err != nil {
+		return err
+	}
+
+	// download the module so we know where its source is
+	cmd := env.newGoModCommand(ctx, "download", "-json")
+	cmd.Args = append(cmd.Args, p.ModulePath)
+	var buf bytes.Buffer
+	cmd.Stdout = &buf
+	err = env.runCommand(ctx, cmd)
+	if err != nil {
+		return fmt.Errorf("downloading %s to patch: %v", p.ModulePath, err)
+	}
+	var mod struct {
+		Path    string
+		Version string
+		Dir     string
+	}
+	err = json.Unmarshal(buf.Bytes(), &mod)
+	if err != nil {
+		return fmt.Errorf("decoding module info for %s: %v", p.ModulePath, err)
+	}
+
+	// files in the module cache are read-only, so make sure
+	// the copy is writable or the patch can't be applied; the
+	// copy is laid out like the module cache, so that no two
+	// modules, nor versions of one, share a folder
+	patchedDir := filepath.Join(env.tempFolder, "patched", filepath.FromSlash(escapeModulePath(mod.Path)+"@"+mod.Version))
+	log.Printf("[INFO] Patching %s@%s with %s", mod.Path, mod.Version, patchFile)
+	err = copyWritable(mod.Dir, patchedDir)
+	if err != nil {
+		return err
+	}
+
+	// git apply works outside of a repository too, but it must
+	// not discover a repository in any parent folder, otherwise
+	// the paths in the patch are resolved relative to that one
+	cmd = env.newCommand(ctx, "git", "apply", "--verbose", patchFile)
+	cmd.Dir = patchedDir
+	cmd.Env = append(cmd.Env, "GIT_CEILING_DIRECTORIES="+env.tempFolder)
+	err = env.runCommand(ctx, cmd)
+	if err != nil {
+		return fmt.Errorf("applying patch %s to %s: %v", patchFile, mod.Path, err)
 	}
 
+	cmd = env.newGoModCommand(ctx, "edit")
+	cmd.Args = append(cmd.Args, "-replace", fmt.Sprintf("%s=%s", mod.Path, patchedDir))
 	return env.runCommand(ctx, cmd)
 }
 
+// escapeModulePath escapes the path of a module like the module
+// cache does, with each upper-case letter replaced by an exclamation
+// mark and the lower-case letter, so that paths which differ only in
+// case get their own folder on case-insensitive file systems.
+func escapeModulePath(modulePath string) string {
+	var sb strings.Builder
+	for _, r := range modulePath {
+		if 'A' <= r && r <= 'Z' {
+			sb.WriteByte('!')
+			r += 'a' - 'A'
+		}
+		sb.WriteRune(r)
+	}
+	return sb.String()
+}
+
 type goModTemplateContext struct {
 	CaddyModule string
 	Plugins     []string
+
+	// the main module imports either the standard modules of Caddy,
+	// or else the packages in StandardPackages, or none if Bare
+	Bare             bool
+	StandardPackages []string
+
+	// the main package of the run preset applies
+	// the defaults before running Caddy
+	RunPreset bool
+	Defaults  MainDefaults
 }
 
 const mainModuleTemplate = `package main
 
 import (
+{{- if .RunPreset}}
+	"os"
+	"strings"
+{{end}}
 	caddycmd "{{.CaddyModule}}/cmd"
 
 	// plug in Caddy modules here
+	{{- if .StandardPackages}}
+	{{- range .StandardPackages}}
+	_ "{{.}}"
+	{{- end}}
+	{{- else if not .Bare}}
 	_ "{{.CaddyModule}}/modules/standard"
+	{{- end}}
 	{{- range .Plugins}}
 	_ "{{.}}"
 	{{- end}}
 )
 
 func main() {
+	{{- if .RunPreset}}
+	applyDefaults()
+	{{- end}}
 	caddycmd.Main()
 }
+{{- if .RunPreset}}
+
+// applyDefaults applies the defaults of this build, unless
+// they are overridden by the environment or flags.
+func applyDefaults() {
+	{{- range $key, $value := .Defaults.Env}}
+	if _, ok := os.LookupEnv({{printf "%q" $key}}); !ok {
+		os.Setenv({{printf "%q" $key}}, {{printf "%q" $value}})
+	}
+	{{- end}}
+	if len(os.Args) == 1 {
+		os.Args = append(os.Args, "run")
+	}
+	switch os.Args[1] {
+	case "run", "start", "reload", "validate", "adapt":
+	default:
+		return
+	}
+	{{- if .Defaults.Config}}
+	if !hasFlag("config", "c") {
+		os.Args = append(os.Args, "--config", {{printf "%q" .Defaults.Config}})
+	}
+	{{- end}}
+	{{- if .Defaults.Adapter}}
+	if !hasFlag("adapter", "a") {
+		os.Args = append(os.Args, "--adapter", {{printf "%q" .Defaults.Adapter}})
+	}
+	{{- end}}
+}
+
+// hasFlag returns true if the command line
+// has a flag with one of the names.
+func hasFlag(names ...string) bool {
+	for _, arg := range os.Args[2:] {
+		if arg == "--" {
+			break
+		}
+		arg = strings.TrimLeft(arg, "-")
+		arg, _, _ = strings.Cut(arg, "=")
+		for _, name := range names {
+			if arg == name {
+				return true
+			}
+		}
+	}
+	return false
+}
+{{- end}}
 `
 
 // originally published in: https://github.com/mholt/caddy-embed
@@ -15,10 +15,26 @@
 package xcaddy
 
 import (
+	"bytes"
 	"context"
+	"flag"
+	"fmt"
+	"go/ast"
+	"go/importer"
+	"go/parser"
+	"go/token"
+	"go/types"
+	"os"
+	"os/exec"
+	"path"
+	"path/filepath"
 	"reflect"
+	"regexp"
+	"runtime"
+	"strings"
 	"testing"
 
+	"github.com/caddyserver/xcaddy/internal/fakego"
 	"github.com/caddyserver/xcaddy/internal/utils"
 )
 
@@ -112,3 +128,979 @@ func Test_environment_newGoBuildCommand(t *testing.T) {
 		})
 	}
 }
+
+func Test_pluginPathCandidates(t *testing.T) {
+	tests := []struct {
+		name        string
+		packagePath string
+		want        []string
+	}{
+		{
+			name:        "module root",
+			packagePath: "github.com/dunglas/mercure",
+			want: []string{
+				"github.com/dunglas/mercure/v2",
+				"github.com/dunglas/mercure/caddy",
+			},
+		},
+		{
+			name:        "superfluous major version",
+			packagePath: "github.com/x/y/v2",
+			want: []string{
+				"github.com/x/y",
+				"github.com/x/y/v2/caddy",
+			},
+		},
+		{
+			name:        "package in module",
+			packagePath: "github.com/x/y/z/plugin/",
+			want: []string{
+				"github.com/x/y/z/plugin/v2",
+				"github.com/x/y/z/plugin/caddy",
+				"github.com/x/y/z",
+				"github.com/x/y",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := pluginPathCandidates(tt.packagePath); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("pluginPathCandidates() = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_resolvePackagePath(t *testing.T) {
+	tests := []struct {
+		name        string
+		packagePath string
+		version     string
+		missing     []string
+		want        string
+	}{
+		{
+			name:        "v1 package",
+			packagePath: "github.com/acme/plugin/caddy",
+			version:     "v1.2.3",
+			want:        "github.com/acme/plugin/caddy",
+		},
+		{
+			name:        "v2 module",
+			packagePath: "github.com/acme/plugin",
+			version:     "v2.0.0",
+			want:        "github.com/acme/plugin/v2",
+		},
+		{
+			name:        "v2 package in a subdirectory of its module",
+			packagePath: "github.com/acme/mono/caddy",
+			version:     "v2.0.0",
+			missing:     []string{"github.com/acme/mono/caddy/v2@v2.0.0"},
+			want:        "github.com/acme/mono/v2/caddy",
+		},
+		{
+			name:        "v2 package in a module of its own",
+			packagePath: "github.com/acme/mono/caddy",
+			version:     "v2.0.0",
+			want:        "github.com/acme/mono/caddy/v2",
+		},
+		{
+			name:        "no module found",
+			packagePath: "github.com/acme/mono/caddy",
+			version:     "v2.0.0",
+			missing:     []string{"github.com/acme/mono/caddy/v2@v2.0.0", "github.com/acme/mono/v2@v2.0.0", "github.com/acme/v2@v2.0.0", "github.com/v2@v2.0.0"},
+			want:        "github.com/acme/mono/caddy/v2",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := fakego.New(t)
+			for _, m := range tt.missing {
+				g.Handle(fakego.Rule{Args: []string{"list", "-m", m}, Exit: 1})
+			}
+			env := environment{tempFolder: t.TempDir()}
+			got, err := env.resolvePackagePath(context.Background(), tt.packagePath, tt.version)
+			if err != nil {
+				t.Fatalf("resolvePackagePath() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("resolvePackagePath() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_isPackageReplaced(t *testing.T) {
+	replaced := map[string]string{
+		"github.com/acme/plugin@v1.0.0": "../plugin",
+		"github.com/acme/mono":          "../mono",
+	}
+	tests := []struct {
+		packagePath string
+		want        bool
+	}{
+		{packagePath: "github.com/acme/plugin", want: true},
+		{packagePath: "github.com/acme/plugin/caddy", want: true},
+		{packagePath: "github.com/acme/mono/v2/caddy", want: true},
+		{packagePath: "github.com/acme/plugin-extra", want: false},
+		{packagePath: "github.com/acme/monorepo/caddy", want: false},
+		{packagePath: "github.com/acme", want: false},
+	}
+	for _, tt := range tests {
+		if got := isPackageReplaced(tt.packagePath, replaced); got != tt.want {
+			t.Errorf("isPackageReplaced(%s) = %t, want %t", tt.packagePath, got, tt.want)
+		}
+	}
+}
+
+func Test_placeholderVersion(t *testing.T) {
+	tests := []struct {
+		modulePath string
+		want       string
+	}{
+		{"github.com/caddyserver/caddy/v2", "v2.0.0"},
+		{"github.com/caddyserver/caddy/v3", "v3.0.0"},
+		{"github.com/caddyserver/caddy", "v0.0.0-00010101000000-000000000000"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.modulePath, func(t *testing.T) {
+			if got := placeholderVersion(tt.modulePath); got != tt.want {
+				t.Errorf("placeholderVersion() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_openEnvironment(t *testing.T) {
+	folder := t.TempDir()
+	prepared := environment{
+		caddyVersion:    "v2.8.4",
+		plugins:         []Dependency{{PackagePath: "github.com/caddy-dns/cloudflare", Version: "v0.1.0"}},
+		caddyModulePath: "github.com/caddyserver/caddy/v2",
+		tempFolder:      folder,
+	}
+	if err := prepared.saveState(); err != nil {
+		t.Fatalf("saveState() error = %v", err)
+	}
+	// left behind by a previous build
+	if err := os.WriteFile(filepath.Join(folder, "invocation.go"), nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	env, err := Builder{BuildFlags: "-ldflags '-w -s'"}.openEnvironment(context.Background(), folder)
+	if err != nil {
+		t.Fatalf("openEnvironment() error = %v", err)
+	}
+	if env.caddyVersion != prepared.caddyVersion || env.caddyModulePath != prepared.caddyModulePath || !reflect.DeepEqual(env.plugins, prepared.plugins) {
+		t.Errorf("openEnvironment() = %+v, want state of %+v", env, prepared)
+	}
+	if env.buildFlags != "-ldflags '-w -s'" {
+		t.Errorf("openEnvironment() buildFlags = %q, want those of the Builder", env.buildFlags)
+	}
+	if !env.skipCleanup {
+		t.Errorf("openEnvironment() must not clean up the prepared environment")
+	}
+	if _, err := os.Stat(filepath.Join(folder, "invocation.go")); !os.IsNotExist(err) {
+		t.Errorf("openEnvironment() did not remove invocation.go of a previous build")
+	}
+
+	if _, err := (Builder{}).openEnvironment(context.Background(), t.TempDir()); err == nil {
+		t.Errorf("openEnvironment() of an unprepared folder succeeded")
+	}
+}
+
+func Test_droppedPlugins(t *testing.T) {
+	plugins := []Dependency{
+		{PackagePath: "github.com/caddy-dns/cloudflare"},
+		{PackagePath: "github.com/dunglas/mercure/caddy"},
+		{PackagePath: "example.com/local/plugin"},
+	}
+	before := map[string]bool{
+		"github.com/caddyserver/caddy/v2":  true,
+		"github.com/caddy-dns/cloudflare":  true,
+		"github.com/dunglas/mercure":       true,
+		"github.com/dunglas/mercure/caddy": true,
+	}
+	after := map[string]bool{
+		"github.com/caddyserver/caddy/v2": true,
+		"github.com/caddy-dns/cloudflare": true,
+		"github.com/dunglas/mercure":      true,
+	}
+	want := []string{"github.com/dunglas/mercure/caddy"}
+	if got := droppedPlugins(plugins, before, after); !reflect.DeepEqual(got, want) {
+		t.Errorf("droppedPlugins() = %v, want %v", got, want)
+	}
+	if got := droppedPlugins(plugins, before, before); got != nil {
+		t.Errorf("droppedPlugins() = %v, want none", got)
+	}
+}
+
+func Test_findTransitivePlugins(t *testing.T) {
+	const caddy = "github.com/caddyserver/caddy/v2"
+	pkg := func(importPath, module string, imports ...string) listedPackage {
+		p := listedPackage{ImportPath: importPath, Imports: imports}
+		p.Module = &struct {
+			Path string
+			Main bool
+		}{Path: module, Main: module == "caddy"}
+		return p
+	}
+	pkgs := []listedPackage{
+		{ImportPath: "fmt", Standard: true},
+		pkg("caddy", "caddy", caddy, "github.com/mholt/caddy-l4/modules/l4tls"),
+		pkg(caddy+"/modules/caddyhttp", caddy, caddy),
+		pkg("github.com/mholt/caddy-l4/modules/l4tls", "github.com/mholt/caddy-l4", caddy, "github.com/mholt/caddy-l4/layer4"),
+		pkg("github.com/mholt/caddy-l4/layer4", "github.com/mholt/caddy-l4", caddy),
+		pkg("github.com/mholt/caddy-l4/internal/util", "github.com/mholt/caddy-l4", caddy),
+		pkg("github.com/caddyserver/certmagic", "github.com/caddyserver/certmagic"),
+	}
+	plugins := []Dependency{{PackagePath: "github.com/mholt/caddy-l4/modules/l4tls"}}
+	registers := func(p listedPackage) bool {
+		// the helper package imports Caddy only for its types
+		return p.ImportPath != "github.com/mholt/caddy-l4/internal/util"
+	}
+
+	want := []string{"github.com/mholt/caddy-l4/layer4"}
+	if got := findTransitivePlugins(pkgs, caddy, plugins, registers); !reflect.DeepEqual(got, want) {
+		t.Errorf("findTransitivePlugins() = %v, want %v", got, want)
+	}
+}
+
+func Test_registersModules(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"module.go": "package layer4\n\nfunc init() {\n\tcaddy.RegisterModule(Handler{})\n}\n",
+		"types.go":  "package util\n\ntype Context = caddy.Context\n",
+	}
+	for name, src := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(src), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !registersModules(listedPackage{Dir: dir, GoFiles: []string{"types.go", "module.go"}}) {
+		t.Errorf("registersModules() = false for a package registering a module")
+	}
+	if registersModules(listedPackage{Dir: dir, GoFiles: []string{"types.go"}}) {
+		t.Errorf("registersModules() = true for a package only using Caddy's types")
+	}
+}
+
+func Test_writeMainModule(t *testing.T) {
+	const caddy = "github.com/caddyserver/caddy/v2"
+	for _, tt := range []struct {
+		name    string
+		tplCtx  goModTemplateContext
+		want    []string
+		notWant []string
+	}{
+		{
+			name:   "standard",
+			tplCtx: goModTemplateContext{CaddyModule: caddy, Plugins: []string{"github.com/caddy-dns/cloudflare"}},
+			want:   []string{"\t// plug in Caddy modules here\n\t_ \"" + caddy + "/modules/standard\"\n\t_ \"github.com/caddy-dns/cloudflare\"\n)"},
+		},
+		{
+			name:    "bare",
+			tplCtx:  goModTemplateContext{CaddyModule: caddy, Plugins: []string{"github.com/caddy-dns/cloudflare"}, Bare: true},
+			want:    []string{"\t// plug in Caddy modules here\n\t_ \"github.com/caddy-dns/cloudflare\"\n)"},
+			notWant: []string{"/modules/"},
+		},
+		{
+			name:    "without",
+			tplCtx:  goModTemplateContext{CaddyModule: caddy, StandardPackages: []string{caddy + "/modules/caddyhttp", caddy + "/modules/caddytls"}},
+			want:    []string{"\t// plug in Caddy modules here\n\t_ \"" + caddy + "/modules/caddyhttp\"\n\t_ \"" + caddy + "/modules/caddytls\"\n)"},
+			notWant: []string{"/modules/standard"},
+		},
+		{
+			name:    "plain",
+			tplCtx:  goModTemplateContext{CaddyModule: caddy},
+			want:    []string{"import (\n\tcaddycmd \"" + caddy + "/cmd\"\n", "func main() {\n\tcaddycmd.Main()\n}\n"},
+			notWant: []string{"applyDefaults", "\"os\""},
+		},
+		{
+			name: "run",
+			tplCtx: goModTemplateContext{CaddyModule: caddy, RunPreset: true, Defaults: MainDefaults{
+				Config:  "/etc/caddy/Caddyfile",
+				Adapter: "caddyfile",
+				Env:     map[string]string{"XDG_DATA_HOME": "/var/lib"},
+			}},
+			want: []string{
+				"func main() {\n\tapplyDefaults()\n\tcaddycmd.Main()\n}",
+				"if _, ok := os.LookupEnv(\"XDG_DATA_HOME\"); !ok {\n\t\tos.Setenv(\"XDG_DATA_HOME\", \"/var/lib\")",
+				"os.Args = append(os.Args, \"--config\", \"/etc/caddy/Caddyfile\")",
+				"os.Args = append(os.Args, \"--adapter\", \"caddyfile\")",
+			},
+		},
+		{
+			name:    "run without defaults",
+			tplCtx:  goModTemplateContext{CaddyModule: caddy, RunPreset: true},
+			want:    []string{"os.Args = append(os.Args, \"run\")"},
+			notWant: []string{"--config", "--adapter", "os.Setenv"},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			env := environment{tempFolder: t.TempDir()}
+			if err := env.writeMainModule(tt.tplCtx); err != nil {
+				t.Fatalf("writeMainModule() error = %v", err)
+			}
+			src, err := os.ReadFile(filepath.Join(env.tempFolder, "main.go"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			typeCheckMain(t, env.tempFolder, caddy)
+			for _, want := range tt.want {
+				if !strings.Contains(string(src), want) {
+					t.Errorf("writeMainModule() wrote\n%s\nwant it to contain\n%s", src, want)
+				}
+			}
+			for _, notWant := range tt.notWant {
+				if strings.Contains(string(src), notWant) {
+					t.Errorf("writeMainModule() wrote\n%s\nwant it not to contain %s", src, notWant)
+				}
+			}
+		})
+	}
+}
+
+func Test_excludeStandardPackages(t *testing.T) {
+	const caddy = "github.com/caddyserver/caddy/v2"
+	pkgs := []string{
+		caddy + "/modules/caddyhttp",
+		caddy + "/modules/caddyhttp/reverseproxy",
+		caddy + "/modules/caddyhttp/reverseproxy/fastcgi",
+		caddy + "/modules/caddyhttp/templates",
+		caddy + "/modules/caddytls",
+	}
+	for _, tt := range []struct {
+		name    string
+		without []string
+		want    []string
+		wantErr bool
+	}{
+		{
+			name:    "relative and absolute",
+			without: []string{"caddyhttp/templates", caddy + "/modules/caddytls"},
+			want:    pkgs[:3],
+		},
+		{
+			name:    "subfolders",
+			without: []string{"caddyhttp/reverseproxy/"},
+			want:    []string{pkgs[0], pkgs[3], pkgs[4]},
+		},
+		{
+			name:    "unknown",
+			without: []string{"caddyhttp/template"},
+			wantErr: true,
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := excludeStandardPackages(pkgs, caddy, tt.without)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("excludeStandardPackages() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("excludeStandardPackages() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+
+	deps := []listedPackage{{ImportPath: caddy + "/modules/caddyhttp"}, {ImportPath: caddy + "/modules/caddyhttp/reverseproxy"}}
+	want := []string{caddy + "/modules/caddyhttp/reverseproxy"}
+	if got := stillIncluded(deps, caddy, []string{"caddyhttp/reverseproxy", "caddyhttp/templates"}); !reflect.DeepEqual(got, want) {
+		t.Errorf("stillIncluded() = %v, want %v", got, want)
+	}
+}
+
+func Test_writeEmbedDirs(t *testing.T) {
+	site := t.TempDir()
+	if err := os.MkdirAll(filepath.Join(site, "css"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range map[string]string{"index.html": "<h1>Hi</h1>", "css/site.css": "h1 {}"} {
+		if err := os.WriteFile(filepath.Join(site, name), []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	empty := t.TempDir()
+	if err := os.Mkdir(filepath.Join(empty, "sub"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	embedDirs := func(dirs ...string) []struct {
+		Dir  string `json:"dir,omitempty"`
+		Name string `json:"name,omitempty"`
+	} {
+		var embed []struct {
+			Dir  string `json:"dir,omitempty"`
+			Name string `json:"name,omitempty"`
+		}
+		for _, dir := range dirs {
+			embed = append(embed, struct {
+				Dir  string `json:"dir,omitempty"`
+				Name string `json:"name,omitempty"`
+			}{Dir: dir})
+		}
+		return embed
+	}
+
+	tests := []struct {
+		name    string
+		dirs    []string
+		maxSize int64
+		wantErr bool
+	}{
+		{name: "default maximum", dirs: []string{site}},
+		{name: "unlimited", dirs: []string{site}, maxSize: -1},
+		{name: "too large", dirs: []string{site}, maxSize: 10, wantErr: true},
+		{name: "missing", dirs: []string{filepath.Join(site, "missing")}, wantErr: true},
+		{name: "not a directory", dirs: []string{filepath.Join(site, "index.html")}, wantErr: true},
+		{name: "no files", dirs: []string{site, empty}, wantErr: true},
+		{name: "overwritten files", dirs: []string{site, site}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			env := environment{tempFolder: t.TempDir()}
+			err := env.writeEmbedDirs(embedDirs(tt.dirs...), tt.maxSize)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("writeEmbedDirs() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			// nothing is copied unless all directories are fine
+			_, statErr := os.Stat(filepath.Join(env.tempFolder, "files"))
+			if tt.wantErr != os.IsNotExist(statErr) {
+				t.Errorf("writeEmbedDirs() copied files: %v, want copied: %v", statErr == nil, !tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_checkEmbedAliases(t *testing.T) {
+	tests := []struct {
+		name    string
+		aliases []string
+		wantErr bool
+	}{
+		{name: "none", aliases: []string{"", ""}},
+		{name: "root", aliases: []string{"", ".", "foo"}},
+		{name: "distinct", aliases: []string{"foo", "bar", "foo2/bar"}},
+		{name: "prefix but not nested", aliases: []string{"foo", "foobar"}},
+		{name: "duplicate", aliases: []string{"foo", "bar", "foo"}, wantErr: true},
+		{name: "nested", aliases: []string{"foo", "foo/bar"}, wantErr: true},
+		{name: "nesting", aliases: []string{"foo/bar", "foo"}, wantErr: true},
+		{name: "parent", aliases: []string{".."}, wantErr: true},
+		{name: "escaping", aliases: []string{"foo/../../bar"}, wantErr: true},
+		{name: "absolute", aliases: []string{"/foo"}, wantErr: true},
+		{name: "trailing slash", aliases: []string{"foo/"}, wantErr: true},
+		{name: "backslash", aliases: []string{`foo\bar`}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var embedDirs []struct {
+				Dir  string `json:"dir,omitempty"`
+				Name string `json:"name,omitempty"`
+			}
+			for i, alias := range tt.aliases {
+				embedDirs = append(embedDirs, struct {
+					Dir  string `json:"dir,omitempty"`
+					Name string `json:"name,omitempty"`
+				}{Dir: fmt.Sprintf("dir%d", i), Name: alias})
+			}
+			if err := checkEmbedAliases(embedDirs); (err != nil) != tt.wantErr {
+				t.Errorf("checkEmbedAliases() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_goToolchain(t *testing.T) {
+	tests := []struct {
+		version string
+		want    string
+		wantErr bool
+	}{
+		{version: "", want: ""},
+		{version: "1.22.5", want: "go1.22.5"},
+		{version: "go1.22.5", want: "go1.22.5"},
+		{version: "1.23rc1", want: "go1.23rc1"},
+		{version: "1.22", wantErr: true},
+		{version: "local", wantErr: true},
+		{version: "1.22.5+auto", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.version, func(t *testing.T) {
+			got, err := goToolchain(tt.version)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("goToolchain() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("goToolchain() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_goGetFlags(t *testing.T) {
+	tests := []struct {
+		goVersion string
+		want      []string
+	}{
+		{goVersion: "go1.17.13", want: []string{"-d", "-v"}},
+		{goVersion: "go1.18", want: []string{"-v"}},
+		{goVersion: "go1.22.5", want: []string{"-v"}},
+		{goVersion: "go1.24rc1", want: []string{"-v"}},
+		{goVersion: "devel go1.25-1b2a3c4 Mon Jan 6 2025", want: []string{"-v"}},
+		{goVersion: "", want: []string{"-v"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.goVersion, func(t *testing.T) {
+			if got := goGetFlags(tt.goVersion); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("goGetFlags() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_execGoGet(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses a shell script as the go command")
+	}
+	dir := t.TempDir()
+	logFile := filepath.Join(dir, "log")
+	goCmd := filepath.Join(dir, "go")
+	script := `#!/bin/sh
+echo "$# $*" >> "` + logFile + `"
+`
+	if err := os.WriteFile(goCmd, []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("XCADDY_WHICH_GO", goCmd)
+	t.Setenv("XCADDY_GO_BUILD_FLAGS", "")
+
+	env := environment{tempFolder: dir, goVersion: "go1.22.5"}
+	ctx := context.Background()
+	for _, args := range [][4]string{
+		{"github.com/caddyserver/caddy/v2", "v2.8.4", "", ""},
+		{"github.com/caddy-dns/cloudflare", "", "github.com/caddyserver/caddy/v2", "v2.8.4"},
+		{"", "", "", ""},
+	} {
+		if err := env.execGoGet(ctx, args[0], args[1], args[2], args[3]); err != nil {
+			t.Fatalf("execGoGet() error = %v", err)
+		}
+	}
+	got, err := os.ReadFile(logFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "3 get -v github.com/caddyserver/caddy/v2@v2.8.4\n" +
+		"4 get -v github.com/caddy-dns/cloudflare github.com/caddyserver/caddy/v2@v2.8.4\n" +
+		"2 get -v\n"
+	if string(got) != want {
+		t.Errorf("execGoGet() ran:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func Test_writeModuleOrder(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses a shell script as the go command")
+	}
+	dir := t.TempDir()
+	logFile := filepath.Join(dir, "log")
+	goCmd := filepath.Join(dir, "go")
+	script := `#!/bin/sh
+echo "$*" >> "` + logFile + `"
+`
+	if err := os.WriteFile(goCmd, []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("XCADDY_WHICH_GO", goCmd)
+	t.Setenv("XCADDY_GO_BUILD_FLAGS", "")
+
+	b := Builder{
+		Plugins: []Dependency{
+			{PackagePath: "github.com/mholt/caddy-l4/layer4"},
+			{PackagePath: "github.com/caddy-dns/cloudflare"},
+			{PackagePath: "github.com/greenpau/caddy-security"},
+		},
+		Replacements: []Replace{
+			NewReplace("github.com/mholt/caddy-l4", "github.com/acme/caddy-l4@v0.1.0"),
+			NewReplace("github.com/caddy-dns/cloudflare", "github.com/acme/cloudflare@v0.2.0"),
+			NewReplace("github.com/greenpau/caddy-security", "github.com/acme/caddy-security@v1.0.0"),
+		},
+	}
+	for i := 0; i < 5; i++ {
+		folder := filepath.Join(dir, fmt.Sprint(i))
+		if err := os.Mkdir(folder, 0o755); err != nil {
+			t.Fatal(err)
+		}
+		env := &environment{caddyModulePath: "github.com/caddyserver/caddy/v2", tempFolder: folder}
+		module, err := env.writeModule(context.Background(), b)
+		if err != nil {
+			t.Fatalf("writeModule() error = %v", err)
+		}
+		wantPlugins := []string{"github.com/caddy-dns/cloudflare", "github.com/greenpau/caddy-security", "github.com/mholt/caddy-l4/layer4"}
+		if !reflect.DeepEqual(module.tplCtx.Plugins, wantPlugins) {
+			t.Fatalf("writeModule() plugins of main.go = %v, want %v", module.tplCtx.Plugins, wantPlugins)
+		}
+	}
+
+	got, err := os.ReadFile(logFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	wantEdit := "mod edit" +
+		" -replace github.com/caddy-dns/cloudflare=github.com/acme/cloudflare@v0.2.0" +
+		" -replace github.com/greenpau/caddy-security=github.com/acme/caddy-security@v1.0.0" +
+		" -replace github.com/mholt/caddy-l4=github.com/acme/caddy-l4@v0.1.0"
+	for _, line := range strings.Split(strings.TrimSpace(string(got)), "\n") {
+		if strings.HasPrefix(line, "mod edit") && line != wantEdit {
+			t.Errorf("writeModule() ran:\n%s\nwant:\n%s", line, wantEdit)
+		}
+	}
+}
+
+func Test_runBuildCommandRetriesGoSumErrors(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses a shell script as the go command")
+	}
+	dir := t.TempDir()
+	logFile := filepath.Join(dir, "log")
+	goCmd := filepath.Join(dir, "go")
+	script := `#!/bin/sh
+echo "$* GOFLAGS=$GOFLAGS" >> "` + logFile + `"
+case "$1 $GOFLAGS" in
+"build ") echo "main.go:1: missing go.sum entry for module providing package x" >&2; exit 1 ;;
+"build -mod=mod") echo "built in $PWD"; echo "go: added x" >&2 ;;
+"vet "*) echo "main.go:1: undefined: x" >&2; exit 1 ;;
+esac
+`
+	if err := os.WriteFile(goCmd, []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("XCADDY_WHICH_GO", goCmd)
+
+	// the retry writes where the command does, through the
+	// progress, and runs in its folder
+	var stdout, stderr bytes.Buffer
+	var chunks []string
+	env := environment{
+		tempFolder: dir,
+		progress: func(e Event) {
+			if c, ok := e.(CommandOutputChunk); ok && c.Stream == "stdout" {
+				chunks = append(chunks, string(c.Data))
+			}
+		},
+	}
+	ctx := context.Background()
+	build := env.newCommand(ctx, goCmd, "build")
+	build.Dir = t.TempDir()
+	build.Stdout, build.Stderr = &stdout, &stderr
+	if err := env.runBuildCommand(ctx, build); err != nil {
+		t.Fatalf("runBuildCommand() error = %v", err)
+	}
+	if want := "built in " + build.Dir + "\n"; stdout.String() != want {
+		t.Errorf("runBuildCommand() output = %q, want %q", stdout.String(), want)
+	}
+	if !strings.Contains(stderr.String(), "missing go.sum entry") || !strings.HasSuffix(stderr.String(), "go: added x\n") {
+		t.Errorf("runBuildCommand() errors = %q, want those of both runs", stderr.String())
+	}
+	if strings.Join(chunks, "") != stdout.String() {
+		t.Errorf("progress output = %q, want the output of the retry once", chunks)
+	}
+	got, err := os.ReadFile(logFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := "build GOFLAGS=\nmod download GOFLAGS=\nbuild GOFLAGS=-mod=mod\n"
+	if string(got) != want {
+		t.Errorf("runBuildCommand() ran:\n%s\nwant:\n%s", got, want)
+	}
+
+	// other errors are not retried
+	if err := os.WriteFile(logFile, nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := env.runBuildCommand(ctx, env.newCommand(ctx, goCmd, "vet")); err == nil {
+		t.Fatal("runBuildCommand() succeeded, want error")
+	}
+	if got, _ := os.ReadFile(logFile); string(got) != "vet GOFLAGS=\n" {
+		t.Errorf("runBuildCommand() ran:\n%s\nwant only vet", got)
+	}
+}
+
+var updateGolden = flag.Bool("update", false, "update the golden files in testdata")
+
+// goDirectiveRegexp and toolchainDirectiveRegexp match the lines of
+// a go.mod written by go mod init which depend on the go command.
+var (
+	goDirectiveRegexp        = regexp.MustCompile(`(?m)^go .*$`)
+	toolchainDirectiveRegexp = regexp.MustCompile(`(?m)^toolchain .*\n`)
+)
+
+// Test_writeModuleGolden compares the files which are generated for
+// the main module with those in testdata/generated, which are updated
+// with -update. It runs the go command, which doesn't need the network
+// for any of the builds.
+func Test_writeModuleGolden(t *testing.T) {
+	goCmd, err := exec.LookPath("go")
+	if err != nil {
+		t.Skip("the go command is required")
+	}
+	t.Setenv("XCADDY_WHICH_GO", goCmd)
+	t.Setenv("XCADDY_GO_BUILD_FLAGS", "")
+	t.Setenv("GOFLAGS", "")
+	t.Setenv("GOTOOLCHAIN", "local")
+
+	local := filepath.Join(t.TempDir(), "cloudflare")
+	site := t.TempDir()
+	if err := os.WriteFile(filepath.Join(site, "index.html"), []byte("<h1>Hi</h1>"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	embed := func(dir, name string) []struct {
+		Dir  string `json:"dir,omitempty"`
+		Name string `json:"name,omitempty"`
+	} {
+		return []struct {
+			Dir  string `json:"dir,omitempty"`
+			Name string `json:"name,omitempty"`
+		}{{Dir: dir, Name: name}}
+	}
+
+	for _, tt := range []struct {
+		name    string
+		builder Builder
+	}{
+		{
+			name:    "standard",
+			builder: Builder{CaddyVersion: "v2.8.4"},
+		},
+		{
+			name: "plugins",
+			builder: Builder{CaddyVersion: "v2.8.4", Plugins: []Dependency{
+				{PackagePath: "github.com/caddy-dns/cloudflare", Version: "v0.1.0"},
+				{PackagePath: "github.com/mholt/caddy-l4/layer4"},
+			}},
+		},
+		{
+			name: "replace",
+			builder: Builder{
+				CaddyVersion: "v2.8.4",
+				Plugins:      []Dependency{{PackagePath: "github.com/caddy-dns/cloudflare"}},
+				Replacements: []Replace{
+					NewReplace("github.com/caddy-dns/cloudflare", local),
+					NewReplace("github.com/libdns/libdns", "github.com/acme/libdns@v0.2.3"),
+				},
+				Excludes:        []Dependency{{PackagePath: "golang.org/x/net", Version: "v0.28.0"}},
+				Requires:        []Dependency{{PackagePath: "golang.org/x/crypto", Version: "v0.27.0"}},
+				GoModDirectives: []string{"godebug default=go1.21"},
+			},
+		},
+		{
+			name: "embed",
+			builder: Builder{
+				CaddyVersion: "v2.8.4",
+				Plugins:      []Dependency{{PackagePath: "github.com/caddy-dns/cloudflare", Version: "v0.1.0"}},
+				EmbedDirs:    embed(site, "www"),
+			},
+		},
+		{
+			name: "bare run",
+			builder: Builder{
+				CaddyVersion: "v2.8.4",
+				Plugins:      []Dependency{{PackagePath: "github.com/caddy-dns/cloudflare", Version: "v0.1.0"}},
+				Bare:         true,
+				MainPreset:   MainPresetRun,
+				MainDefaults: MainDefaults{Config: "/etc/caddy/Caddyfile", Adapter: "caddyfile"},
+			},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			env := &environment{
+				caddyVersion:    tt.builder.CaddyVersion,
+				caddyModulePath: "github.com/caddyserver/caddy/v2",
+				tempFolder:      t.TempDir(),
+			}
+			if _, err := env.writeModule(context.Background(), tt.builder); err != nil {
+				t.Fatalf("writeModule() error = %v", err)
+			}
+			golden := filepath.Join("testdata", "generated", strings.ReplaceAll(tt.name, " ", "-"))
+			for _, name := range []string{"go.mod", "main.go", "embed.go"} {
+				got, err := os.ReadFile(filepath.Join(env.tempFolder, name))
+				if os.IsNotExist(err) {
+					got = nil
+				} else if err != nil {
+					t.Fatal(err)
+				}
+				if name == "go.mod" {
+					got = goDirectiveRegexp.ReplaceAll(got, []byte("go <version>"))
+					got = toolchainDirectiveRegexp.ReplaceAll(got, nil)
+					got = bytes.ReplaceAll(got, []byte(filepath.ToSlash(local)), []byte("/path/to/cloudflare"))
+				}
+				checkGolden(t, filepath.Join(golden, name+".golden"), got)
+			}
+			// a golden file must not freeze a template which doesn't compile
+			typeCheckMain(t, env.tempFolder, env.caddyModulePath)
+		})
+	}
+}
+
+// checkGolden compares got with the golden file, which must not
+// exist if got is nil, or updates the file if -update is given.
+// typeCheckMain type-checks the Go files of the main package in dir,
+// as generated for caddyModule, so that a template which doesn't
+// compile, like with an unused import, fails. Tests can't download
+// Caddy, so its packages are stubs with what the templates use, and
+// the other packages outside of the standard library are empty.
+func typeCheckMain(t *testing.T, dir, caddyModule string) {
+	t.Helper()
+	names, err := filepath.Glob(filepath.Join(dir, "*.go"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	fset := token.NewFileSet()
+	var files []*ast.File
+	for _, name := range names {
+		f, err := parser.ParseFile(fset, name, nil, 0)
+		if err != nil {
+			t.Fatalf("generated invalid code: %v", err)
+		}
+		files = append(files, f)
+	}
+	conf := types.Config{Importer: &stubImporter{
+		fset: fset,
+		std:  importer.ForCompiler(fset, "source", nil),
+		stubs: map[string]string{
+			caddyModule + "/cmd": `package caddycmd
+func Main() {}`,
+			caddyModule: `package caddy
+type ModuleID string
+type ModuleInfo struct {
+	ID  ModuleID
+	New func() Module
+}
+type Module interface{ CaddyModule() ModuleInfo }
+func RegisterModule(instance Module) {}`,
+			caddyModule + "/caddyconfig/caddyfile": `package caddyfile
+type Dispenser struct{}
+type Unmarshaler interface{ UnmarshalCaddyfile(d *Dispenser) error }`,
+		},
+	}}
+	if _, err := conf.Check("main", fset, files, nil); err != nil {
+		var src []byte
+		for _, name := range names {
+			data, _ := os.ReadFile(name)
+			src = append(src, data...)
+		}
+		t.Errorf("generated code doesn't compile: %v\n%s", err, src)
+	}
+}
+
+// stubImporter imports the standard library with std,
+// and the other packages from the source in stubs, or
+// as empty packages if they have none.
+type stubImporter struct {
+	fset  *token.FileSet
+	std   types.Importer
+	stubs map[string]string
+}
+
+func (imp *stubImporter) Import(importPath string) (*types.Package, error) {
+	if elem, _, _ := strings.Cut(importPath, "/"); !strings.Contains(elem, ".") {
+		return imp.std.Import(importPath)
+	}
+	src, ok := imp.stubs[importPath]
+	if !ok {
+		pkg := types.NewPackage(importPath, path.Base(importPath))
+		pkg.MarkComplete()
+		return pkg, nil
+	}
+	f, err := parser.ParseFile(imp.fset, importPath+".go", src, 0)
+	if err != nil {
+		return nil, err
+	}
+	conf := types.Config{Importer: imp}
+	return conf.Check(importPath, imp.fset, []*ast.File{f}, nil)
+}
+
+func checkGolden(t *testing.T, file string, got []byte) {
+	t.Helper()
+	if *updateGolden {
+		if got == nil {
+			if err := os.Remove(file); err != nil && !os.IsNotExist(err) {
+				t.Fatal(err)
+			}
+			return
+		}
+		if err := os.MkdirAll(filepath.Dir(file), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(file, got, 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return
+	}
+	want, err := os.ReadFile(file)
+	if os.IsNotExist(err) && got == nil {
+		return
+	}
+	if err != nil {
+		t.Fatalf("%v; run the test with -update to write it", err)
+	}
+	if !bytes.Equal(got, want) {
+		t.Errorf("generated %s differs from %s; run the test with -update if this is intended\ngot:\n%s\nwant:\n%s", filepath.Base(file), file, got, want)
+	}
+}
+
+func Test_escapeModulePath(t *testing.T) {
+	for _, tt := range []struct {
+		path string
+		want string
+	}{
+		{"github.com/caddyserver/caddy/v2", "github.com/caddyserver/caddy/v2"},
+		{"github.com/BurntSushi/toml", "github.com/!burnt!sushi/toml"},
+		{"github.com/a_b/c", "github.com/a_b/c"},
+	} {
+		if got := escapeModulePath(tt.path); got != tt.want {
+			t.Errorf("escapeModulePath(%q) = %q, want %q", tt.path, got, tt.want)
+		}
+	}
+}
+
+func Test_newCommandStdout(t *testing.T) {
+	var stdout bytes.Buffer
+	env := environment{tempFolder: t.TempDir(), stdout: &stdout}
+	cmd := env.newCommand(context.Background(), utils.GetGo(), "env", "GOVERSION")
+	if err := env.runCommand(context.Background(), cmd); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(stdout.String(), "go") {
+		t.Errorf("output written to stdout = %q, want the version of go", stdout.String())
+	}
+}
+
+func Test_applyReplacePatterns(t *testing.T) {
+	g := fakego.New(t)
+	g.Handle(fakego.Rule{
+		Args:   []string{"list", "-m", "-f", "{{.Path}}", "all"},
+		Stdout: "caddy\ngithub.com/caddy-dns/cloudflare\ngithub.com/caddy-dns/route53\n",
+	})
+	env := environment{tempFolder: t.TempDir()}
+	replaced := map[string]string{
+		"github.com/caddy-dns/cloudflare@v0.1.0": "github.com/me/cloudflare@v0.1.1",
+	}
+	patterns := []Replace{NewReplace("github.com/caddy-dns/*", "github.com/fork/{name}@v1.0.0")}
+	if err := env.applyReplacePatterns(context.Background(), patterns, replaced, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]string{
+		"github.com/caddy-dns/cloudflare@v0.1.0": "github.com/me/cloudflare@v0.1.1",
+		"github.com/caddy-dns/route53":           "github.com/fork/route53@v1.0.0",
+	}
+	if !reflect.DeepEqual(replaced, want) {
+		t.Errorf("replaced = %v, want %v", replaced, want)
+	}
+	edits := g.Find("mod", "edit")
+	if len(edits) != 1 {
+		t.Fatalf("go mod edit was run %d times, want once", len(edits))
+	}
+	if got, want := strings.Join(edits[0].Args, " "), "mod edit -replace github.com/caddy-dns/route53=github.com/fork/route53@v1.0.0"; got != want {
+		t.Errorf("go %s, want go %s", got, want)
+	}
+}
@@ -0,0 +1,114 @@
+// Copyright 2020 Matthew Holt
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package xcaddy
+
+import (
+	"context"
+	"errors"
+	"io/fs"
+	"os/exec"
+)
+
+// Failure is the class of a failed build, so callers, like CI
+// pipelines, can react to it without parsing the error message.
+type Failure string
+
+// The classes of failures of FailureOf.
+const (
+	// Anything else, like invalid settings.
+	FailureOther Failure = "other"
+
+	// The versions of Caddy and the plugins couldn't be
+	// resolved, or their modules couldn't be downloaded.
+	FailureResolution Failure = "resolution"
+
+	// Compiling or linking the binary failed.
+	FailureCompile Failure = "compile"
+
+	// A check of the build found a problem, like modules missing
+	// from the module cache, or a rebuild which differs.
+	FailureVerification Failure = "verification"
+
+	// The build breaks a rule it was given, like ReplaceRoots,
+	// or Strict when tidying the module removes plugins.
+	FailurePolicy Failure = "policy"
+
+	// The build was canceled, like with Ctrl+C.
+	FailureCanceled Failure = "canceled"
+
+	// The build took longer than one of its timeouts allow,
+	// like TimeoutTotal, or the deadline of its context.
+	FailureTimeout Failure = "timeout"
+
+	// The system can't build, like if the go command is missing,
+	// there isn't enough free space, or a file can't be written.
+	FailureEnvironment Failure = "environment"
+)
+
+// Error is an error of a known Failure.
+type Error struct {
+	Failure Failure
+	Err     error
+}
+
+func (e *Error) Error() string { return e.Err.Error() }
+func (e *Error) Unwrap() error { return e.Err }
+
+// FailureOf returns the class of err, which is "" if err is nil.
+// Errors of canceled contexts are FailureCanceled, those of expired
+// deadlines FailureTimeout, and errors of the file system and missing
+// commands FailureEnvironment, unless they are wrapped in an Error of
+// another Failure.
+func FailureOf(err error) Failure {
+	if err == nil {
+		return ""
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return FailureTimeout
+	}
+	if errors.Is(err, context.Canceled) {
+		return FailureCanceled
+	}
+	var e *Error
+	if errors.As(err, &e) {
+		return e.Failure
+	}
+	var pathErr *fs.PathError
+	if errors.Is(err, exec.ErrNotFound) || errors.As(err, &pathErr) {
+		return FailureEnvironment
+	}
+	return FailureOther
+}
+
+// withFailure returns err as an Error of failure,
+// unless FailureOf knows its class already.
+func withFailure(err error, failure Failure) error {
+	if FailureOf(err) != FailureOther {
+		return err
+	}
+	return &Error{Failure: failure, Err: err}
+}
+
+// phaseFailure returns err, which ended phase, as
+// an Error of the failure of that phase, if any.
+func phaseFailure(err error, phase Phase) error {
+	switch phase {
+	case PhasePrepare, PhaseTidy:
+		return withFailure(err, FailureResolution)
+	case PhaseCompile:
+		return withFailure(err, FailureCompile)
+	}
+	return err
+}
@@ -0,0 +1,67 @@
+// Copyright 2020 Matthew Holt
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package xcaddy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"testing"
+)
+
+func TestFailureOf(t *testing.T) {
+	_, notExist := os.Open("/nonexistent/xcaddy.json")
+	_, notFound := exec.LookPath("nonexistent-xcaddy-command")
+	for _, tt := range []struct {
+		name string
+		err  error
+		want Failure
+	}{
+		{name: "nil", err: nil, want: ""},
+		{name: "other", err: errors.New("invalid settings"), want: FailureOther},
+		{name: "canceled", err: fmt.Errorf("go get: %w", context.Canceled), want: FailureCanceled},
+		{name: "timed out", err: phaseFailure(context.DeadlineExceeded, PhaseCompile), want: FailureTimeout},
+		{name: "file", err: notExist, want: FailureEnvironment},
+		{name: "command", err: notFound, want: FailureEnvironment},
+		{name: "wrapped", err: fmt.Errorf("linux/amd64: %w", &Error{Failure: FailurePolicy, Err: errors.New("outside of the roots")}), want: FailurePolicy},
+		{name: "prepare", err: phaseFailure(errors.New("exit status 1"), PhasePrepare), want: FailureResolution},
+		{name: "tidy", err: phaseFailure(errors.New("exit status 1"), PhaseTidy), want: FailureResolution},
+		{name: "compile", err: phaseFailure(errors.New("exit status 1"), PhaseCompile), want: FailureCompile},
+		{name: "no phase", err: phaseFailure(errors.New("invalid settings"), ""), want: FailureOther},
+		{name: "classified", err: phaseFailure(&Error{Failure: FailureEnvironment, Err: errors.New("not enough free space")}, PhasePrepare), want: FailureEnvironment},
+		{name: "file in phase", err: phaseFailure(notExist, PhaseCompile), want: FailureEnvironment},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := FailureOf(tt.err); got != tt.want {
+				t.Errorf("FailureOf(%v) = %q, want %q", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBuildFailure(t *testing.T) {
+	b := Builder{
+		CaddyVersion:  "v2.8.4",
+		Replacements:  []Replace{NewReplace("github.com/caddy-dns/cloudflare", t.TempDir())},
+		ReplaceRoots:  []string{t.TempDir()},
+		SkipPreflight: true,
+	}
+	_, err := b.BuildFile(context.Background(), "caddy")
+	if got := FailureOf(err); got != FailurePolicy {
+		t.Errorf("BuildFile() error = %v, of failure %q, want %q", err, got, FailurePolicy)
+	}
+}
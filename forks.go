@@ -0,0 +1,116 @@
+// Copyright 2020 Matthew Holt
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package xcaddy
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"path"
+	"path/filepath"
+	"strings"
+)
+
+// checkReplaceFrom returns an error if namespace, the value of
+// Builder.ReplaceFrom, is not a module path prefix.
+func checkReplaceFrom(namespace string) error {
+	if namespace == "" {
+		return nil
+	}
+	if strings.HasPrefix(namespace, ".") || filepath.IsAbs(namespace) || !strings.Contains(namespace, ".") {
+		return fmt.Errorf("replace from %s: must be a module path prefix, like github.com/acme/forks", namespace)
+	}
+	return nil
+}
+
+// forkPath returns the module path of the fork of the module at
+// modulePath in the namespace, like github.com/acme/forks/cloudflare
+// for github.com/caddy-dns/cloudflare; the major version suffix of
+// the module path, if any, is kept, as in github.com/acme/forks/l4/v2.
+func forkPath(namespace, modulePath string) string {
+	name, major := path.Base(modulePath), ""
+	if matches := moduleVersionRegexp.FindStringSubmatch(modulePath); len(matches) == 2 {
+		name, major = path.Base(path.Dir(modulePath)), "/"+name
+	}
+	return strings.TrimSuffix(namespace, "/") + "/" + name + major
+}
+
+// replaceFromForks replaces the modules of the plugins which were
+// given with a version by their forks in the namespace, at the
+// version selected for the build; see Builder.ReplaceFrom. The
+// modules which are replaced already, in replaced, are left as is.
+func (env environment) replaceFromForks(ctx context.Context, namespace string, plugins []Dependency, replaced map[string]string) error {
+	cmd, err := env.newGoBuildCommand(ctx, "list", "-m", "-f", "{{.Path}} {{.Version}}", "all")
+	if err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	cmd.Stdout = &buf
+	err = env.runCommand(ctx, cmd)
+	if err != nil {
+		return err
+	}
+	versions := make(map[string]string)
+	for _, line := range strings.Split(buf.String(), "\n") {
+		if modulePath, version, ok := strings.Cut(strings.TrimSpace(line), " "); ok {
+			versions[modulePath] = version
+		}
+	}
+
+	editCmd := env.newGoModCommand(ctx, "edit")
+	var count int
+	for _, p := range plugins {
+		if p.Version == "" {
+			continue
+		}
+		// the module of a plugin is the one with the
+		// longest path which contains its package
+		var modulePath string
+		for m := range versions {
+			if withinPath(p.PackagePath, m) && len(m) > len(modulePath) {
+				modulePath = m
+			}
+		}
+		if modulePath == "" {
+			return fmt.Errorf("replacing %s from %s: its module is not part of the build", p.PackagePath, namespace)
+		}
+		if isReplaced(modulePath, replaced) {
+			continue
+		}
+		fork := forkPath(namespace, modulePath) + "@" + versions[modulePath]
+		log.Printf("[INFO] Replace %s => %s (from %s)", modulePath, fork, namespace)
+		replaced[modulePath] = fork
+		editCmd.Args = append(editCmd.Args, "-replace", fmt.Sprintf("%s=%s", modulePath, fork))
+		count++
+	}
+	if count == 0 {
+		log.Printf("[WARNING] No plugins were replaced from %s, since none was given with a version", namespace)
+		return nil
+	}
+	return env.runCommand(ctx, editCmd)
+}
+
+// isReplaced returns true if the module at modulePath, at any
+// version, is among replaced, whose keys are module paths with
+// an optional version, like example.com/mod@v1.2.3.
+func isReplaced(modulePath string, replaced map[string]string) bool {
+	for old := range replaced {
+		if oldPath, _, _ := strings.Cut(old, "@"); oldPath == modulePath {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,117 @@
+// Copyright 2020 Matthew Holt
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package xcaddy
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestForkPath(t *testing.T) {
+	for i, tc := range []struct {
+		namespace, modulePath, expect string
+	}{
+		{"github.com/acme/forks", "github.com/caddy-dns/cloudflare", "github.com/acme/forks/cloudflare"},
+		{"github.com/acme/forks/", "github.com/mholt/caddy-l4", "github.com/acme/forks/caddy-l4"},
+		{"github.com/acme/forks", "github.com/example/plugin/v2", "github.com/acme/forks/plugin/v2"},
+	} {
+		if got := forkPath(tc.namespace, tc.modulePath); got != tc.expect {
+			t.Errorf("Test %d: expected %s, got %s", i, tc.expect, got)
+		}
+	}
+}
+
+func TestCheckReplaceFrom(t *testing.T) {
+	for _, namespace := range []string{"", "github.com/acme/forks"} {
+		if err := checkReplaceFrom(namespace); err != nil {
+			t.Errorf("checkReplaceFrom(%q) = %v", namespace, err)
+		}
+	}
+	for _, namespace := range []string{"../forks", "/src/forks", "forks"} {
+		if err := checkReplaceFrom(namespace); err == nil {
+			t.Errorf("checkReplaceFrom(%q): expected an error", namespace)
+		}
+	}
+}
+
+func TestReplaceFromForks(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses a shell script as the go command")
+	}
+	dir := t.TempDir()
+	goCmd := filepath.Join(dir, "go")
+	logFile := filepath.Join(dir, "go.log")
+	script := `#!/bin/sh
+if [ "$1" = list ]; then
+	echo "caddy "
+	echo "github.com/caddyserver/caddy/v2 v2.8.4"
+	echo "github.com/caddy-dns/cloudflare v0.1.0"
+	echo "github.com/mholt/caddy-l4 v0.0.0-20240812213304-8e2e3d8a5a8b"
+	echo "github.com/me/local v0.0.0-00010101000000-000000000000"
+	echo "github.com/me/unversioned v1.0.0"
+fi
+if [ "$1 $2" = "mod edit" ]; then
+	echo "$*" >> "` + logFile + `"
+fi
+`
+	if err := os.WriteFile(goCmd, []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("XCADDY_WHICH_GO", goCmd)
+	t.Setenv("XCADDY_GO_BUILD_FLAGS", "")
+
+	env := environment{tempFolder: dir}
+	plugins := []Dependency{
+		{PackagePath: "github.com/caddy-dns/cloudflare", Version: "v0.1.0"},
+		{PackagePath: "github.com/mholt/caddy-l4/layer4", Version: "8e2e3d8a5a8b"},
+		{PackagePath: "github.com/me/local", Version: "v1.0.0"},
+		{PackagePath: "github.com/me/unversioned"},
+	}
+	replaced := map[string]string{"github.com/me/local@v1.0.0": "/src/local"}
+	err := env.replaceFromForks(context.Background(), "github.com/acme/forks", plugins, replaced)
+	if err != nil {
+		t.Fatal(err)
+	}
+	got, err := os.ReadFile(logFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expect := "mod edit -replace github.com/caddy-dns/cloudflare=github.com/acme/forks/cloudflare@v0.1.0 " +
+		"-replace github.com/mholt/caddy-l4=github.com/acme/forks/caddy-l4@v0.0.0-20240812213304-8e2e3d8a5a8b"
+	if strings.TrimSpace(string(got)) != expect {
+		t.Errorf("expected %s, got %s", expect, got)
+	}
+	if _, ok := replaced["github.com/caddy-dns/cloudflare"]; !ok {
+		t.Errorf("replaced = %v, want the forks added", replaced)
+	}
+
+	err = env.replaceFromForks(context.Background(), "github.com/acme/forks", []Dependency{{PackagePath: "github.com/nowhere/plugin", Version: "v1.0.0"}}, map[string]string{})
+	if err == nil {
+		t.Error("expected an error for a plugin whose module is not part of the build")
+	}
+}
+
+func TestIsReplaced(t *testing.T) {
+	replaced := map[string]string{"example.com/a@v1.0.0": "../a", "example.com/b": "../b"}
+	got := []bool{isReplaced("example.com/a", replaced), isReplaced("example.com/b", replaced), isReplaced("example.com/c", replaced)}
+	if expect := []bool{true, true, false}; !reflect.DeepEqual(got, expect) {
+		t.Errorf("expected %v, got %v", expect, got)
+	}
+}
@@ -0,0 +1,91 @@
+// Copyright 2020 Matthew Holt
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package xcaddy
+
+import (
+	"log"
+	"os"
+	"strings"
+)
+
+// managedGoFlags are the flags which are dropped from GOFLAGS, since
+// xcaddy manages the module it builds in: -mod=vendor or -modfile
+// would make the go command look for files which don't exist there.
+var managedGoFlags = map[string]bool{
+	"mod":     true,
+	"modfile": true,
+}
+
+// goFlags returns the value of GOFLAGS for the go commands run in the
+// build environment, and the build tags it sets, if any. These are
+// the caller's GOFLAGS without the managed flags, or nothing if
+// ignore is set (see Builder.IgnoreGoFlags).
+func goFlags(ignore bool) (flags string, tags []string) {
+	goflags := strings.TrimSpace(os.Getenv("GOFLAGS"))
+	if goflags == "" {
+		return "", nil
+	}
+	if ignore {
+		log.Printf("[INFO] Ignoring GOFLAGS as requested: %s", goflags)
+		return "", nil
+	}
+	kept, tags, dropped := parseGoFlags(goflags)
+	if len(dropped) > 0 {
+		log.Printf("[WARNING] Dropping %s from GOFLAGS, since xcaddy manages the module it builds in", strings.Join(dropped, " "))
+	}
+	flags = strings.Join(kept, " ")
+	if flags != "" {
+		log.Printf("[INFO] Using GOFLAGS: %s", flags)
+	}
+	return flags, tags
+}
+
+// parseGoFlags splits the space-separated flags of GOFLAGS into those
+// which are kept and those which are dropped, and returns the build
+// tags set by a -tags flag among the kept ones.
+func parseGoFlags(goflags string) (kept, tags, dropped []string) {
+	for _, flag := range strings.Fields(goflags) {
+		name, value, _ := strings.Cut(strings.TrimLeft(flag, "-"), "=")
+		if managedGoFlags[name] {
+			dropped = append(dropped, flag)
+			continue
+		}
+		if name == "tags" {
+			// like the go command, the last -tags wins
+			tags = nil
+			for _, tag := range strings.Split(value, ",") {
+				if tag = strings.TrimSpace(tag); tag != "" {
+					tags = append(tags, tag)
+				}
+			}
+		}
+		kept = append(kept, flag)
+	}
+	return kept, tags, dropped
+}
+
+// mergeTags returns the comma-separated list of the build tags in
+// tags followed by those in more, leaving out duplicates.
+func mergeTags(tags string, more []string) string {
+	var merged []string
+	seen := make(map[string]bool)
+	for _, tag := range append(strings.Split(tags, ","), more...) {
+		if tag != "" && !seen[tag] {
+			seen[tag] = true
+			merged = append(merged, tag)
+		}
+	}
+	return strings.Join(merged, ",")
+}
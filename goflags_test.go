@@ -0,0 +1,80 @@
+// Copyright 2020 Matthew Holt
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package xcaddy
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseGoFlags(t *testing.T) {
+	tests := []struct {
+		goflags     string
+		wantKept    []string
+		wantTags    []string
+		wantDropped []string
+	}{
+		{
+			goflags: "",
+		},
+		{
+			goflags:  "-trimpath  -buildvcs=false",
+			wantKept: []string{"-trimpath", "-buildvcs=false"},
+		},
+		{
+			goflags:     "-mod=vendor -trimpath --modfile=other.mod",
+			wantKept:    []string{"-trimpath"},
+			wantDropped: []string{"-mod=vendor", "--modfile=other.mod"},
+		},
+		{
+			goflags:  "-tags=foo,bar -modcacherw",
+			wantKept: []string{"-tags=foo,bar", "-modcacherw"},
+			wantTags: []string{"foo", "bar"},
+		},
+		{
+			goflags:  "-tags=foo -tags=baz",
+			wantKept: []string{"-tags=foo", "-tags=baz"},
+			wantTags: []string{"baz"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.goflags, func(t *testing.T) {
+			kept, tags, dropped := parseGoFlags(tt.goflags)
+			if !reflect.DeepEqual(kept, tt.wantKept) {
+				t.Errorf("parseGoFlags() kept = %v, want %v", kept, tt.wantKept)
+			}
+			if !reflect.DeepEqual(tags, tt.wantTags) {
+				t.Errorf("parseGoFlags() tags = %v, want %v", tags, tt.wantTags)
+			}
+			if !reflect.DeepEqual(dropped, tt.wantDropped) {
+				t.Errorf("parseGoFlags() dropped = %v, want %v", dropped, tt.wantDropped)
+			}
+		})
+	}
+}
+
+func TestGoFlags(t *testing.T) {
+	t.Setenv("GOFLAGS", "-mod=vendor -tags=foo,nopgx -trimpath")
+	flags, tags := goFlags(false)
+	if flags != "-tags=foo,nopgx -trimpath" {
+		t.Errorf("goFlags() flags = %q", flags)
+	}
+	if got := mergeTags("nobadger,nomysql,nopgx", tags); got != "nobadger,nomysql,nopgx,foo" {
+		t.Errorf("mergeTags() = %q", got)
+	}
+	if flags, tags := goFlags(true); flags != "" || tags != nil {
+		t.Errorf("goFlags() with ignore = %q, %v, want nothing", flags, tags)
+	}
+}
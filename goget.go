@@ -0,0 +1,214 @@
+// Copyright 2020 Matthew Holt
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package xcaddy
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// maxConcurrentGoGets is the number of plugins which
+// are resolved at once by getPluginsConcurrently.
+const maxConcurrentGoGets = 4
+
+// getPluginsConcurrently pins the versions of plugins, pinning Caddy
+// to caddyVersion along with each, like execGoGet does one by one,
+// and returns true if it did. Groups of plugins which don't depend
+// on each other are resolved concurrently, each in a copy of go.mod,
+// which fills the module cache; then they are all added to go.mod at
+// once. It returns false, changing nothing, if the go command still
+// builds what it gets, there is only one group, or resolving or adding
+// them fails, like on a conflict, since the plugins are then better
+// added in order, which reports the plugin at fault.
+func (env environment) getPluginsConcurrently(ctx context.Context, plugins []Dependency, caddyModulePath, caddyVersion string) bool {
+	if minor, ok := goMinorVersion(env.goVersion); ok && minor < 18 {
+		return false
+	}
+	groups := groupPlugins(plugins)
+	if len(groups) < 2 {
+		return false
+	}
+	goMod, err := os.ReadFile(filepath.Join(env.tempFolder, "go.mod"))
+	if err != nil {
+		return false
+	}
+	goSum, err := os.ReadFile(filepath.Join(env.tempFolder, "go.sum"))
+	if err != nil && !os.IsNotExist(err) {
+		return false
+	}
+
+	log.Printf("[INFO] Resolving %d plugins concurrently", len(plugins))
+	if report := env.progress; report != nil {
+		// the progress is reported by one goroutine at a time
+		var mu sync.Mutex
+		env.progress = func(e Event) {
+			mu.Lock()
+			defer mu.Unlock()
+			report(e)
+		}
+	}
+	errs := make([]error, len(groups))
+	sem := make(chan struct{}, min(maxConcurrentGoGets, len(groups)))
+	var wg sync.WaitGroup
+	for i, group := range groups {
+		wg.Add(1)
+		go func(i int, group []Dependency) {
+			defer wg.Done()
+			sem <- struct{}{}
+			defer func() { <-sem }()
+			errs[i] = env.resolvePlugins(ctx, fmt.Sprintf(".xcaddy-get-%d", i), goMod, goSum, group, caddyModulePath, caddyVersion)
+		}(i, group)
+	}
+	wg.Wait()
+	for _, err := range errs {
+		if err != nil {
+			log.Printf("[INFO] %v; resolving plugins in order", err)
+			return false
+		}
+	}
+
+	cmd, err := env.newGoBuildCommand(ctx, "get", goGetFlags(env.goVersion)...)
+	if err != nil {
+		return false
+	}
+	for _, p := range plugins {
+		cmd.Args = append(cmd.Args, goGetArgs(p.PackagePath, p.Version, "", "")...)
+	}
+	cmd.Args = append(cmd.Args, goGetArgs("", "", caddyModulePath, caddyVersion)...)
+	err = env.runCommand(ctx, cmd)
+	if err != nil {
+		log.Printf("[INFO] Getting the plugins at once failed: %v; getting them in order", err)
+		// go get may have changed go.mod before failing
+		err = os.WriteFile(filepath.Join(env.tempFolder, "go.mod"), goMod, 0o644)
+		if err == nil && goSum != nil {
+			err = os.WriteFile(filepath.Join(env.tempFolder, "go.sum"), goSum, 0o644)
+		} else if err == nil {
+			err = os.Remove(filepath.Join(env.tempFolder, "go.sum"))
+			if os.IsNotExist(err) {
+				err = nil
+			}
+		}
+		if err != nil {
+			log.Printf("[ERROR] Restoring go.mod: %v", err)
+		}
+		return false
+	}
+	return true
+}
+
+// resolvePlugins gets plugins one by one in the folder dir within
+// the environment, with a copy of its go.mod and go.sum, so the
+// modules they need are in the module cache. Its output is only
+// shown if it fails, since it is interleaved with that of others.
+func (env environment) resolvePlugins(ctx context.Context, dir string, goMod, goSum []byte, plugins []Dependency, caddyModulePath, caddyVersion string) error {
+	scratch := env
+	scratch.tempFolder = filepath.Join(env.tempFolder, dir)
+	err := os.Mkdir(scratch.tempFolder, 0o755)
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(scratch.tempFolder)
+	err = os.WriteFile(filepath.Join(scratch.tempFolder, "go.mod"), goMod, 0o644)
+	if err != nil {
+		return err
+	}
+	if goSum != nil {
+		err = os.WriteFile(filepath.Join(scratch.tempFolder, "go.sum"), goSum, 0o644)
+		if err != nil {
+			return err
+		}
+	}
+	for _, p := range plugins {
+		cmd, err := scratch.newGoBuildCommand(ctx, "get", goGetFlags(env.goVersion)...)
+		if err != nil {
+			return err
+		}
+		cmd.Args = append(cmd.Args, goGetArgs(p.PackagePath, p.Version, caddyModulePath, caddyVersion)...)
+		// the outputs are written by different goroutines
+		// once runCommand wraps them for the progress
+		var stdout, stderr bytes.Buffer
+		cmd.Stdout = &stdout
+		cmd.Stderr = &stderr
+		err = scratch.runCommand(ctx, cmd)
+		if err != nil {
+			return fmt.Errorf("resolving %s: %v: %s", p, err, strings.TrimSpace(stdout.String()+stderr.String()))
+		}
+	}
+	return nil
+}
+
+// groupPlugins groups plugins which may be in the same module, since
+// the package path of one is within that of another, in their order;
+// the groups don't depend on each other, so they can be resolved at
+// the same time, while those within a group share their downloads.
+func groupPlugins(plugins []Dependency) [][]Dependency {
+	var groups [][]Dependency
+nextPlugin:
+	for _, p := range plugins {
+		for i, group := range groups {
+			for _, q := range group {
+				if withinPath(p.PackagePath, q.PackagePath) || withinPath(q.PackagePath, p.PackagePath) {
+					groups[i] = append(groups[i], p)
+					continue nextPlugin
+				}
+			}
+		}
+		groups = append(groups, []Dependency{p})
+	}
+	return groups
+}
+
+// withinPath returns true if the import path p is
+// parent or within it, at a path element boundary.
+func withinPath(p, parent string) bool {
+	return p == parent || strings.HasPrefix(p, parent+"/")
+}
+
+// importsResolved returns true if the imports of the build, including
+// those of the Go plugin, if any, resolve with go.mod and go.sum as
+// they are, so an empty "go get" wouldn't change anything. They don't
+// if a plugin imports a package which is ambiguous, or missing from
+// the requirements, which go list reports with -mod=readonly. The
+// imports are those with the build flags and tags of the build, so
+// that files only built with some tags are taken into account.
+func (env environment) importsResolved(ctx context.Context, tags string) bool {
+	args := []string{"-mod=readonly", "-deps"}
+	if tags != "" {
+		args = append(args, "-tags", tags)
+	}
+	cmd, err := env.newGoBuildCommand(ctx, "list", append(args, ".")...)
+	if err != nil {
+		return false
+	}
+	if env.goPlugin {
+		cmd.Args = append(cmd.Args, "./"+goPluginFolder)
+	}
+	var stderr bytes.Buffer
+	cmd.Stdout = io.Discard
+	cmd.Stderr = &stderr
+	err = env.runCommand(ctx, cmd)
+	if err != nil {
+		log.Printf("[INFO] Resolving the imports of the build: %s", strings.TrimSpace(stderr.String()))
+		return false
+	}
+	return true
+}
@@ -0,0 +1,203 @@
+// Copyright 2020 Matthew Holt
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package xcaddy
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"runtime"
+	"slices"
+	"strings"
+	"testing"
+)
+
+func TestGroupPlugins(t *testing.T) {
+	l4 := Dependency{PackagePath: "github.com/mholt/caddy-l4"}
+	l4tls := Dependency{PackagePath: "github.com/mholt/caddy-l4/modules/l4tls"}
+	l4x := Dependency{PackagePath: "github.com/mholt/caddy-l4x"}
+	dns := Dependency{PackagePath: "github.com/caddy-dns/cloudflare", Version: "v0.1.0"}
+
+	got := groupPlugins([]Dependency{l4tls, dns, l4, l4x})
+	want := [][]Dependency{{l4tls, l4}, {dns}, {l4x}}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("groupPlugins() = %v, want %v", got, want)
+	}
+}
+
+func TestGoGetArgs(t *testing.T) {
+	for i, tc := range []struct {
+		modulePath, moduleVersion, caddyModulePath, caddyVersion string
+		want                                                     []string
+	}{
+		{modulePath: "github.com/caddy-dns/cloudflare", want: []string{"github.com/caddy-dns/cloudflare"}},
+		{
+			modulePath:      "github.com/caddy-dns/cloudflare",
+			moduleVersion:   "v0.1.0",
+			caddyModulePath: defaultCaddyModulePath,
+			caddyVersion:    "v2.8.4",
+			want:            []string{"github.com/caddy-dns/cloudflare@v0.1.0", defaultCaddyModulePath + "@v2.8.4"},
+		},
+		{caddyModulePath: defaultCaddyModulePath, want: []string{defaultCaddyModulePath}},
+	} {
+		got := goGetArgs(tc.modulePath, tc.moduleVersion, tc.caddyModulePath, tc.caddyVersion)
+		if !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("test %d: goGetArgs() = %v, want %v", i, got, tc.want)
+		}
+	}
+}
+
+func TestGetPluginsConcurrentlySkipped(t *testing.T) {
+	plugins := []Dependency{
+		{PackagePath: "github.com/mholt/caddy-l4"},
+		{PackagePath: "github.com/caddy-dns/cloudflare"},
+	}
+	// the environment has no go.mod, so it would fail if it tried
+	env := environment{tempFolder: t.TempDir(), goVersion: "go1.17.13"}
+	if env.getPluginsConcurrently(context.Background(), plugins, defaultCaddyModulePath, "") {
+		t.Error("expected plugins to be resolved in order with go1.17")
+	}
+	env.goVersion = "go1.22.5"
+	if env.getPluginsConcurrently(context.Background(), plugins[:1], defaultCaddyModulePath, "") {
+		t.Error("expected a single plugin to be resolved in order")
+	}
+	if env.getPluginsConcurrently(context.Background(), plugins, defaultCaddyModulePath, "") {
+		t.Error("expected plugins to be resolved in order without go.mod")
+	}
+}
+
+func TestResolvePlugins(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses a shell script as the go command")
+	}
+	dir := t.TempDir()
+	goCmd := filepath.Join(dir, "go")
+	script := `#!/bin/sh
+case "$*" in
+*route53*) echo "go: github.com/caddy-dns/route53: no matching versions" >&2; exit 1 ;;
+esac
+`
+	if err := os.WriteFile(goCmd, []byte(script), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("XCADDY_WHICH_GO", goCmd)
+
+	// the go commands run like the others of the build,
+	// so their progress is reported
+	var execs [][]string
+	env := environment{
+		tempFolder: dir,
+		goVersion:  "go1.22.5",
+		progress: func(e Event) {
+			if c, ok := e.(CommandExec); ok {
+				execs = append(execs, c.Args)
+			}
+		},
+	}
+	plugins := []Dependency{
+		{PackagePath: "github.com/mholt/caddy-l4"},
+		{PackagePath: "github.com/mholt/caddy-l4/modules/l4tls"},
+	}
+	goMod := []byte("module caddy\n")
+	if err := env.resolvePlugins(context.Background(), "scratch", goMod, nil, plugins, defaultCaddyModulePath, "v2.8.4"); err != nil {
+		t.Fatalf("resolvePlugins() error = %v", err)
+	}
+	if len(execs) != 2 || !slices.Contains(execs[0], "get") || !slices.Contains(execs[1], "github.com/mholt/caddy-l4/modules/l4tls") {
+		t.Errorf("resolvePlugins() reported %q, want a go get of each plugin", execs)
+	}
+
+	err := env.resolvePlugins(context.Background(), "scratch", goMod, nil, []Dependency{{PackagePath: "github.com/caddy-dns/route53"}}, defaultCaddyModulePath, "v2.8.4")
+	if err == nil || !strings.Contains(err.Error(), "no matching versions") {
+		t.Errorf("resolvePlugins() error = %v, want the output of go get", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "scratch")); !os.IsNotExist(err) {
+		t.Errorf("the scratch folder was left behind: %v", err)
+	}
+}
+
+// TestImportsResolved covers what the empty "go get" after pinning
+// the plugins was added for, in caddyserver/xcaddy#92: a package of
+// the build which go.mod doesn't provide yet.
+func TestImportsResolved(t *testing.T) {
+	t.Setenv("GOPROXY", "off")
+	t.Setenv("GOFLAGS", "-mod=mod")
+	dir := t.TempDir()
+	pluginDir := filepath.Join(dir, "plugin")
+	folder := filepath.Join(dir, "env")
+	for name, content := range map[string]string{
+		filepath.Join(pluginDir, "go.mod"):    "module example.com/plugin\n\ngo 1.21\n",
+		filepath.Join(pluginDir, "plugin.go"): "package plugin\n",
+		filepath.Join(folder, "go.mod"):       "module caddy\n\ngo 1.21\n\nreplace example.com/plugin => " + pluginDir + "\n",
+		filepath.Join(folder, "main.go"):      "package main\n\nimport _ \"example.com/plugin\"\n\nfunc main() {}\n",
+	} {
+		if err := os.MkdirAll(filepath.Dir(name), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(name, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	env := environment{tempFolder: folder, goFlags: "-mod=mod"}
+	if env.importsResolved(context.Background(), "") {
+		t.Fatal("expected the import of the plugin not to resolve before go get")
+	}
+	if err := env.execGoGet(context.Background(), "", "", "", ""); err != nil {
+		t.Fatal(err)
+	}
+	goMod, err := os.ReadFile(filepath.Join(folder, "go.mod"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(goMod), "require example.com/plugin") {
+		t.Errorf("go.mod doesn't require the plugin after go get:\n%s", goMod)
+	}
+	if !env.importsResolved(context.Background(), "") {
+		t.Error("expected the imports to resolve after go get")
+	}
+}
+
+func TestImportsResolvedTags(t *testing.T) {
+	t.Setenv("GOPROXY", "off")
+	t.Setenv("GOFLAGS", "-mod=mod")
+	dir := t.TempDir()
+	pluginDir := filepath.Join(dir, "plugin")
+	folder := filepath.Join(dir, "env")
+	for name, content := range map[string]string{
+		filepath.Join(pluginDir, "go.mod"):     "module example.com/plugin\n\ngo 1.21\n",
+		filepath.Join(pluginDir, "plugin.go"):  "package plugin\n",
+		filepath.Join(folder, "go.mod"):        "module caddy\n\ngo 1.21\n\nreplace example.com/plugin => " + pluginDir + "\n",
+		filepath.Join(folder, "main.go"):       "package main\n\nfunc main() {}\n",
+		filepath.Join(folder, "main_extra.go"): "//go:build extra\n\npackage main\n\nimport _ \"example.com/plugin\"\n",
+	} {
+		if err := os.MkdirAll(filepath.Dir(name), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(name, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	env := environment{tempFolder: folder, goFlags: "-mod=mod"}
+	if !env.importsResolved(context.Background(), "") {
+		t.Error("expected the imports without the tag to resolve")
+	}
+	if env.importsResolved(context.Background(), "nobadger,extra") {
+		t.Error("expected the import of the plugin with the tag not to resolve")
+	}
+	env.buildFlags = "-tags=extra"
+	if env.importsResolved(context.Background(), "") {
+		t.Error("expected the import of the plugin with the tag of the build flags not to resolve")
+	}
+}
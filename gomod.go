@@ -0,0 +1,54 @@
+// Copyright 2020 Matthew Holt
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package xcaddy
+
+import (
+	"fmt"
+	"strings"
+)
+
+// goModEditFlag returns the flag of "go mod edit" which adds the
+// go.mod directive, like "godebug default=go1.21", to go.mod. Only
+// directives on a single line, without a comment, are supported.
+func goModEditFlag(directive string) (string, error) {
+	fields := strings.Fields(directive)
+	if len(fields) < 2 || strings.Contains(directive, "//") || strings.ContainsAny(directive, "()") {
+		return "", fmt.Errorf("go.mod directive %q: must be a single line like \"godebug default=go1.21\"", directive)
+	}
+	verb, args := fields[0], fields[1:]
+	switch verb {
+	case "go", "toolchain", "godebug", "tool":
+		if len(args) == 1 {
+			return "-" + verb + "=" + args[0], nil
+		}
+	case "require", "exclude":
+		if len(args) == 2 {
+			return "-" + verb + "=" + args[0] + "@" + args[1], nil
+		}
+	case "retract":
+		// a version, or an interval like [v1.0.0, v1.9.9]
+		return "-retract=" + strings.Join(args, ""), nil
+	case "replace":
+		// old [version] => new [version]
+		old, repl, ok := strings.Cut(strings.Join(args, " "), "=>")
+		oldFields, newFields := strings.Fields(old), strings.Fields(repl)
+		if ok && len(oldFields) >= 1 && len(oldFields) <= 2 && len(newFields) >= 1 && len(newFields) <= 2 {
+			return "-replace=" + strings.Join(oldFields, "@") + "=" + strings.Join(newFields, "@"), nil
+		}
+	default:
+		return "", fmt.Errorf("go.mod directive %q: unsupported directive %s", directive, verb)
+	}
+	return "", fmt.Errorf("go.mod directive %q: wrong number of arguments for %s", directive, verb)
+}
@@ -0,0 +1,85 @@
+// Copyright 2020 Matthew Holt
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package xcaddy
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestGoModEditFlag(t *testing.T) {
+	for i, tc := range []struct {
+		directive string
+		expect    string
+		expectErr bool
+	}{
+		{directive: "godebug default=go1.21", expect: "-godebug=default=go1.21"},
+		{directive: "toolchain go1.22.5", expect: "-toolchain=go1.22.5"},
+		{directive: "go 1.22", expect: "-go=1.22"},
+		{directive: "tool golang.org/x/tools/cmd/stringer", expect: "-tool=golang.org/x/tools/cmd/stringer"},
+		{directive: "exclude example.com/mod v1.2.3", expect: "-exclude=example.com/mod@v1.2.3"},
+		{directive: "require  example.com/mod   v1.2.3", expect: "-require=example.com/mod@v1.2.3"},
+		{directive: "replace example.com/mod => ../mod", expect: "-replace=example.com/mod=../mod"},
+		{directive: "replace example.com/mod v1.2.3 => example.com/fork v1.2.4", expect: "-replace=example.com/mod@v1.2.3=example.com/fork@v1.2.4"},
+		{directive: "retract [v1.0.0, v1.0.5]", expect: "-retract=[v1.0.0,v1.0.5]"},
+		{directive: "retract v1.0.0", expect: "-retract=v1.0.0"},
+		{directive: "godebug", expectErr: true},
+		{directive: "exclude example.com/mod", expectErr: true},
+		{directive: "replace example.com/mod ../mod", expectErr: true},
+		{directive: "module caddy", expectErr: true},
+		{directive: "require (", expectErr: true},
+		{directive: "exclude example.com/mod v1.2.3 // broken", expectErr: true},
+	} {
+		got, err := goModEditFlag(tc.directive)
+		if (err != nil) != tc.expectErr {
+			t.Errorf("Test %d (%s): expected error %v, got %v", i, tc.directive, tc.expectErr, err)
+			continue
+		}
+		if got != tc.expect {
+			t.Errorf("Test %d (%s): expected %s, got %s", i, tc.directive, tc.expect, got)
+		}
+	}
+}
+
+func TestGoModEditFlagApplies(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "go.mod"), []byte("module caddy\n\ngo 1.21\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	env := environment{tempFolder: dir}
+	cmd := env.newGoModCommand(context.Background(), "edit")
+	for _, d := range []string{"toolchain go1.22.5", "exclude example.com/mod v1.2.3", "replace example.com/mod => ../mod"} {
+		flag, err := goModEditFlag(d)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cmd.Args = append(cmd.Args, flag)
+	}
+	if err := env.runCommand(context.Background(), cmd); err != nil {
+		t.Fatal(err)
+	}
+	goMod, err := os.ReadFile(filepath.Join(dir, "go.mod"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"toolchain go1.22.5", "exclude example.com/mod v1.2.3", "replace example.com/mod => ../mod"} {
+		if !strings.Contains(string(goMod), want) {
+			t.Errorf("go.mod doesn't contain %q:\n%s", want, goMod)
+		}
+	}
+}
@@ -0,0 +1,246 @@
+// Copyright 2020 Matthew Holt
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package xcaddy
+
+import (
+	"bytes"
+	"context"
+	"debug/buildinfo"
+	"fmt"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime/debug"
+	"strings"
+	"text/template"
+)
+
+// The build modes; see Builder.BuildMode.
+const (
+	BuildModeExe    = "exe"
+	BuildModePlugin = "plugin"
+)
+
+// goPluginFolder is the folder of the environment with the main
+// package of the Go plugin, when building in BuildModePlugin.
+const goPluginFolder = "goplugin"
+
+// GoPluginsEnv is the environment variable with the paths of the
+// Go plugins which Caddy, built in BuildModePlugin, loads at start,
+// separated like the paths of PATH.
+const GoPluginsEnv = "CADDY_GO_PLUGINS"
+
+// checkBuildMode returns an error if mode is not
+// one of the build modes, or can't be used with b.
+func checkBuildMode(mode string, b Builder) error {
+	switch mode {
+	case "", BuildModeExe:
+	case BuildModePlugin:
+		if len(b.Plugins) == 0 {
+			return fmt.Errorf("build mode %s requires at least one plugin", BuildModePlugin)
+		}
+		if b.Cover {
+			return fmt.Errorf("coverage instrumentation is not supported in build mode %s", BuildModePlugin)
+		}
+	default:
+		return fmt.Errorf("unknown build mode %q: must be %s or %s", mode, BuildModeExe, BuildModePlugin)
+	}
+	return nil
+}
+
+// checkGoPluginOS returns an error if Go plugins
+// can't be built for the operating system goos.
+func checkGoPluginOS(goos string) error {
+	switch goos {
+	case "linux", "freebsd", "darwin":
+		return nil
+	}
+	return fmt.Errorf("Go plugins are not supported on %s, only on linux, freebsd and darwin", goos)
+}
+
+// goPluginFile returns the path of the Go plugin
+// built along with the binary at outputFile.
+func goPluginFile(outputFile string) string {
+	return outputFile + ".so"
+}
+
+// writeGoPlugin writes the main package of the Go plugin with plugins,
+// and the file of the main package of Caddy which loads Go plugins.
+func (env environment) writeGoPlugin(plugins []string) error {
+	var buf bytes.Buffer
+	tpl, err := template.New("goplugin").Parse(goPluginTemplate)
+	if err != nil {
+		return err
+	}
+	err = tpl.Execute(&buf, plugins)
+	if err != nil {
+		return err
+	}
+	dir := filepath.Join(env.tempFolder, goPluginFolder)
+	err = os.MkdirAll(dir, 0o755)
+	if err != nil {
+		return err
+	}
+	mainPath := filepath.Join(dir, "main.go")
+	log.Printf("[INFO] Writing main package of the Go plugin: %s\n%s", mainPath, buf.Bytes())
+	err = os.WriteFile(mainPath, buf.Bytes(), 0o644)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(env.tempFolder, "goplugins.go"), []byte(goPluginLoader), 0o644)
+}
+
+// buildGoPlugin builds the Go plugin next to hostOutput, the binary
+// which hostCmd built, with the same flags and environment, and checks
+// that both match.
+func (env environment) buildGoPlugin(ctx context.Context, hostCmd *exec.Cmd, hostOutput string) error {
+	pluginFile := goPluginFile(hostOutput)
+	args := append([]string(nil), hostCmd.Args[1:]...)
+	for i := range args {
+		if args[i] == "-o" && i+1 < len(args) {
+			args[i+1] = pluginFile
+		}
+	}
+	args = append(args, "-buildmode=plugin", "./"+goPluginFolder)
+	cmd := env.newCommand(ctx, hostCmd.Path, args...)
+	cmd.Env = hostCmd.Env
+	log.Println("[INFO] Building the Go plugin")
+	err := env.runBuildCommand(ctx, cmd)
+	if err != nil {
+		return err
+	}
+	return checkGoPluginMatches(hostOutput, pluginFile)
+}
+
+// checkGoPluginMatches returns an error if the Go plugin at pluginFile
+// was built differently than the binary at hostFile, which would make
+// loading it fail: with another toolchain, other build settings or
+// other versions of the modules they share.
+func checkGoPluginMatches(hostFile, pluginFile string) error {
+	host, err := buildinfo.ReadFile(hostFile)
+	if err != nil {
+		return err
+	}
+	plugin, err := buildinfo.ReadFile(pluginFile)
+	if err != nil {
+		return err
+	}
+	return compareGoPluginBuildInfo(host, plugin)
+}
+
+// compareGoPluginBuildInfo does the work of checkGoPluginMatches
+// with the build information of both files.
+func compareGoPluginBuildInfo(host, plugin *debug.BuildInfo) error {
+	var problems []string
+	if host.GoVersion != plugin.GoVersion {
+		problems = append(problems, fmt.Sprintf("Go %s instead of %s", plugin.GoVersion, host.GoVersion))
+	}
+	hostSettings := make(map[string]string)
+	for _, s := range host.Settings {
+		hostSettings[s.Key] = s.Value
+	}
+	pluginSettings := make(map[string]string)
+	for _, s := range plugin.Settings {
+		pluginSettings[s.Key] = s.Value
+	}
+	for _, key := range sortedKeys(mergeKeys(hostSettings, pluginSettings)) {
+		// the build mode is the one setting which must differ, and
+		// the VCS settings and ldflags don't affect the packages
+		if key == "-buildmode" || key == "-ldflags" || strings.HasPrefix(key, "vcs") {
+			continue
+		}
+		if hostSettings[key] != pluginSettings[key] {
+			problems = append(problems, fmt.Sprintf("%s=%q instead of %q", key, pluginSettings[key], hostSettings[key]))
+		}
+	}
+	hostDeps := make(map[string]*debug.Module)
+	for _, dep := range host.Deps {
+		hostDeps[dep.Path] = dep
+	}
+	for _, dep := range plugin.Deps {
+		hostDep, ok := hostDeps[dep.Path]
+		if !ok {
+			continue
+		}
+		if moduleString(dep) != moduleString(hostDep) {
+			problems = append(problems, fmt.Sprintf("%s instead of %s", moduleString(dep), moduleString(hostDep)))
+		}
+	}
+	if len(problems) > 0 {
+		return fmt.Errorf("the Go plugin was built differently than Caddy, which won't load it: %s", strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+// moduleString returns m with its version and sum,
+// and those of its replacement, if any.
+func moduleString(m *debug.Module) string {
+	s := m.Path + "@" + m.Version
+	if m.Sum != "" {
+		s += " (" + m.Sum + ")"
+	}
+	if m.Replace != nil {
+		s += " => " + moduleString(m.Replace)
+	}
+	return s
+}
+
+// mergeKeys returns a map with the keys of a and b.
+func mergeKeys(a, b map[string]string) map[string]string {
+	merged := make(map[string]string, len(a)+len(b))
+	for k := range a {
+		merged[k] = ""
+	}
+	for k := range b {
+		merged[k] = ""
+	}
+	return merged
+}
+
+const goPluginTemplate = `// Package main is a Go plugin with Caddy modules; loading it
+// with the package plugin registers them with Caddy.
+package main
+
+import (
+	{{- range .}}
+	_ "{{.}}"
+	{{- end}}
+)
+`
+
+const goPluginLoader = `package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"plugin"
+)
+
+// init loads the Go plugins in ` + GoPluginsEnv + `, which register
+// their modules, before Caddy reads its config.
+func init() {
+	for _, path := range filepath.SplitList(os.Getenv("` + GoPluginsEnv + `")) {
+		if path == "" {
+			continue
+		}
+		if _, err := plugin.Open(path); err != nil {
+			fmt.Fprintf(os.Stderr, "loading Go plugin: %v\n", err)
+			os.Exit(1)
+		}
+	}
+}
+`
@@ -0,0 +1,132 @@
+// Copyright 2020 Matthew Holt
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package xcaddy
+
+import (
+	"os"
+	"path/filepath"
+	"runtime/debug"
+	"strings"
+	"testing"
+)
+
+func TestCheckBuildMode(t *testing.T) {
+	plugins := []Dependency{{PackagePath: "github.com/caddyserver/transform-encoder"}}
+	tests := []struct {
+		mode    string
+		builder Builder
+		wantErr bool
+	}{
+		{"", Builder{}, false},
+		{BuildModeExe, Builder{}, false},
+		{BuildModePlugin, Builder{Plugins: plugins}, false},
+		{BuildModePlugin, Builder{}, true},
+		{BuildModePlugin, Builder{Plugins: plugins, Cover: true}, true},
+		{"shared", Builder{Plugins: plugins}, true},
+	}
+	for _, tt := range tests {
+		err := checkBuildMode(tt.mode, tt.builder)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("checkBuildMode(%q) error = %v, wantErr %v", tt.mode, err, tt.wantErr)
+		}
+	}
+}
+
+func TestCheckGoPluginOS(t *testing.T) {
+	for _, goos := range []string{"linux", "freebsd", "darwin"} {
+		if err := checkGoPluginOS(goos); err != nil {
+			t.Errorf("checkGoPluginOS(%s) = %v", goos, err)
+		}
+	}
+	for _, goos := range []string{"windows", "openbsd", "js"} {
+		if err := checkGoPluginOS(goos); err == nil {
+			t.Errorf("checkGoPluginOS(%s) = nil, want an error", goos)
+		}
+	}
+}
+
+func TestCompareGoPluginBuildInfo(t *testing.T) {
+	newInfo := func(goVersion, buildMode, tags, zapVersion string) *debug.BuildInfo {
+		return &debug.BuildInfo{
+			GoVersion: goVersion,
+			Settings: []debug.BuildSetting{
+				{Key: "-buildmode", Value: buildMode},
+				{Key: "-tags", Value: tags},
+				{Key: "CGO_ENABLED", Value: "1"},
+			},
+			Deps: []*debug.Module{
+				{Path: "github.com/caddyserver/caddy/v2", Version: "v2.8.4", Sum: "h1:abc="},
+				{Path: "go.uber.org/zap", Version: zapVersion, Sum: "h1:" + zapVersion + "="},
+			},
+		}
+	}
+	host := newInfo("go1.22.5", "exe", "nobadger", "v1.27.0")
+
+	tests := []struct {
+		name    string
+		plugin  *debug.BuildInfo
+		wantErr string
+	}{
+		{"matching", newInfo("go1.22.5", "plugin", "nobadger", "v1.27.0"), ""},
+		{"toolchain", newInfo("go1.22.6", "plugin", "nobadger", "v1.27.0"), "Go go1.22.6 instead of go1.22.5"},
+		{"tags", newInfo("go1.22.5", "plugin", "", "v1.27.0"), `-tags="" instead of "nobadger"`},
+		{"dependency", newInfo("go1.22.5", "plugin", "nobadger", "v1.26.0"), "go.uber.org/zap@v1.26.0"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := compareGoPluginBuildInfo(host, tt.plugin)
+			if tt.wantErr == "" {
+				if err != nil {
+					t.Fatalf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Fatalf("error = %v, want one containing %q", err, tt.wantErr)
+			}
+		})
+	}
+
+	// modules only the plugin needs are fine
+	plugin := newInfo("go1.22.5", "plugin", "nobadger", "v1.27.0")
+	plugin.Deps = append(plugin.Deps, &debug.Module{Path: "example.com/only/plugin", Version: "v1.0.0"})
+	if err := compareGoPluginBuildInfo(host, plugin); err != nil {
+		t.Errorf("unexpected error for a module only the plugin needs: %v", err)
+	}
+}
+
+func TestWriteGoPlugin(t *testing.T) {
+	env := environment{tempFolder: t.TempDir()}
+	err := env.writeGoPlugin([]string{"github.com/caddyserver/transform-encoder", "example.com/hello"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	src, err := os.ReadFile(filepath.Join(env.tempFolder, goPluginFolder, "main.go"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"package main", `_ "github.com/caddyserver/transform-encoder"`, `_ "example.com/hello"`} {
+		if !strings.Contains(string(src), want) {
+			t.Errorf("main package of the Go plugin doesn't contain %s:\n%s", want, src)
+		}
+	}
+	loader, err := os.ReadFile(filepath.Join(env.tempFolder, "goplugins.go"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(string(loader), `os.Getenv("`+GoPluginsEnv+`")`) {
+		t.Errorf("loader doesn't read %s:\n%s", GoPluginsEnv, loader)
+	}
+}
@@ -0,0 +1,197 @@
+// Copyright 2020 Matthew Holt
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package xcaddy
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"sort"
+	"strings"
+)
+
+// graphEdge is a requirement of one module version on another,
+// as printed by `go mod graph`.
+type graphEdge struct {
+	From string `json:"from"`
+	To   string `json:"to"`
+
+	// The plugins which introduced the requirement, or "caddy"
+	// if Caddy itself needs it, regardless of any plugins.
+	IntroducedBy []string `json:"introduced_by,omitempty"`
+}
+
+// writeGraph writes the dependency graph of the build environment
+// to file, as JSON if its name ends in .json and in DOT otherwise.
+func (env environment) writeGraph(ctx context.Context, file string) error {
+	edges, err := env.annotatedGraph(ctx)
+	if err != nil {
+		return err
+	}
+	f, err := os.Create(file)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	log.Printf("[INFO] Writing dependency graph: %s", file)
+	if strings.HasSuffix(file, ".json") {
+		enc := json.NewEncoder(f)
+		enc.SetIndent("", "\t")
+		return enc.Encode(struct {
+			Edges []graphEdge `json:"edges"`
+		}{edges})
+	}
+	return writeDOT(f, edges)
+}
+
+// annotatedGraph returns the dependency graph of the build environment,
+// with each edge annotated with the plugins which introduced it.
+func (env environment) annotatedGraph(ctx context.Context) ([]graphEdge, error) {
+	cmd := env.newGoModCommand(ctx, "graph")
+	var buf bytes.Buffer
+	cmd.Stdout = &buf
+	err := env.runCommand(ctx, cmd)
+	if err != nil {
+		return nil, err
+	}
+	edges := parseModGraph(buf.Bytes())
+
+	var pluginPaths []string
+	for _, p := range env.plugins {
+		pluginPaths = append(pluginPaths, p.PackagePath)
+	}
+	annotateGraph(edges, env.caddyModulePath, pluginPaths)
+	return edges, nil
+}
+
+// parseModGraph parses the output of `go mod graph`.
+func parseModGraph(out []byte) []graphEdge {
+	var edges []graphEdge
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		from, to, ok := strings.Cut(strings.TrimSpace(scanner.Text()), " ")
+		if !ok {
+			continue
+		}
+		edges = append(edges, graphEdge{From: from, To: to})
+	}
+	return edges
+}
+
+// annotateGraph sets which plugins, given by their package paths,
+// introduced each edge: those from which the edge is reachable.
+// Edges which Caddy itself needs aren't attributed to plugins.
+func annotateGraph(edges []graphEdge, caddyModulePath string, pluginPaths []string) {
+	graph := make(map[string][]string)
+	for _, e := range edges {
+		graph[e.From] = append(graph[e.From], e.To)
+	}
+	nodePath := func(node string) string {
+		path, _, _ := strings.Cut(node, "@")
+		return path
+	}
+
+	// modules are nodes at one or more versions; the main
+	// module depends on the versions which were selected
+	var mainModule string
+	if len(edges) > 0 {
+		mainModule = edges[0].From
+	}
+	reachableFrom := func(modulePath string) map[string]bool {
+		reachable := make(map[string]bool)
+		var visit func(node string)
+		visit = func(node string) {
+			if reachable[node] {
+				return
+			}
+			reachable[node] = true
+			for _, next := range graph[node] {
+				visit(next)
+			}
+		}
+		for _, node := range graph[mainModule] {
+			if nodePath(node) == modulePath {
+				visit(node)
+			}
+		}
+		return reachable
+	}
+
+	// the main module requires every module of the build directly,
+	// so its edges are needed by whatever reaches their target
+	needs := func(reachable map[string]bool, e graphEdge) bool {
+		return reachable[e.From] || (e.From == mainModule && reachable[e.To])
+	}
+
+	caddyReachable := reachableFrom(caddyModulePath)
+	for i, e := range edges {
+		if needs(caddyReachable, e) {
+			edges[i].IntroducedBy = []string{"caddy"}
+		}
+	}
+
+	for _, p := range pluginPaths {
+		modulePath := pluginModulePath(p, graph[mainModule])
+		if modulePath == "" {
+			continue
+		}
+		reachable := reachableFrom(modulePath)
+		for i, e := range edges {
+			if needs(caddyReachable, e) {
+				continue
+			}
+			if needs(reachable, e) {
+				edges[i].IntroducedBy = append(edges[i].IntroducedBy, p)
+			}
+		}
+	}
+}
+
+// pluginModulePath returns the path of the module among nodes which
+// contains the package at packagePath, with the longest matching path.
+func pluginModulePath(packagePath string, nodes []string) string {
+	var modulePath string
+	for _, node := range nodes {
+		path, _, _ := strings.Cut(node, "@")
+		if (packagePath == path || strings.HasPrefix(packagePath, path+"/")) && len(path) > len(modulePath) {
+			modulePath = path
+		}
+	}
+	return modulePath
+}
+
+// writeDOT writes the edges as a graph in the DOT language,
+// labeling each edge with the plugins which introduced it.
+func writeDOT(w io.Writer, edges []graphEdge) error {
+	var buf bytes.Buffer
+	buf.WriteString("digraph dependencies {\n")
+	for _, e := range edges {
+		fmt.Fprintf(&buf, "\t%q -> %q", e.From, e.To)
+		if len(e.IntroducedBy) > 0 {
+			introducedBy := append([]string(nil), e.IntroducedBy...)
+			sort.Strings(introducedBy)
+			fmt.Fprintf(&buf, " [label=%q]", strings.Join(introducedBy, ", "))
+		}
+		buf.WriteString(";\n")
+	}
+	buf.WriteString("}\n")
+	_, err := w.Write(buf.Bytes())
+	return err
+}
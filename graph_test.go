@@ -0,0 +1,81 @@
+// Copyright 2020 Matthew Holt
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package xcaddy
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+const testModGraph = `caddy github.com/caddyserver/caddy/v2@v2.8.4
+caddy github.com/dunglas/mercure/caddy@v0.16.3
+caddy github.com/dunglas/mercure@v0.16.3
+caddy golang.org/x/net@v0.28.0
+caddy github.com/gofrs/uuid@v4.4.0+incompatible
+github.com/caddyserver/caddy/v2@v2.8.4 golang.org/x/net@v0.28.0
+github.com/dunglas/mercure/caddy@v0.16.3 github.com/caddyserver/caddy/v2@v2.8.4
+github.com/dunglas/mercure/caddy@v0.16.3 github.com/dunglas/mercure@v0.16.3
+github.com/dunglas/mercure@v0.16.3 github.com/gofrs/uuid@v4.4.0+incompatible
+github.com/dunglas/mercure@v0.16.3 golang.org/x/net@v0.27.0
+`
+
+func TestAnnotateGraph(t *testing.T) {
+	edges := parseModGraph([]byte(testModGraph))
+	annotateGraph(edges, "github.com/caddyserver/caddy/v2", []string{"github.com/dunglas/mercure/caddy"})
+
+	const mercure = "github.com/dunglas/mercure/caddy"
+	expected := [][]string{
+		{"caddy"},
+		{mercure},
+		{mercure},
+		{"caddy"},
+		{mercure},
+		{"caddy"},
+		{mercure},
+		{mercure},
+		{mercure},
+		{mercure},
+	}
+	if len(edges) != len(expected) {
+		t.Fatalf("Expected %d edges, got %d", len(expected), len(edges))
+	}
+	for i, e := range edges {
+		if !reflect.DeepEqual(e.IntroducedBy, expected[i]) {
+			t.Errorf("Edge %s -> %s: expected introduced by %v, got %v", e.From, e.To, expected[i], e.IntroducedBy)
+		}
+	}
+}
+
+func TestWriteDOT(t *testing.T) {
+	var buf bytes.Buffer
+	err := writeDOT(&buf, []graphEdge{
+		{From: "caddy", To: "github.com/caddyserver/caddy/v2@v2.8.4", IntroducedBy: []string{"caddy"}},
+		{From: "a@v1.0.0", To: "b@v1.0.0", IntroducedBy: []string{"z/plugin", "a/plugin"}},
+		{From: "c@v1.0.0", To: "d@v1.0.0"},
+	})
+	if err != nil {
+		t.Fatalf("Unexpected error: %v", err)
+	}
+	expected := `digraph dependencies {
+	"caddy" -> "github.com/caddyserver/caddy/v2@v2.8.4" [label="caddy"];
+	"a@v1.0.0" -> "b@v1.0.0" [label="a/plugin, z/plugin"];
+	"c@v1.0.0" -> "d@v1.0.0";
+}
+`
+	if buf.String() != expected {
+		t.Errorf("Expected:\n%s\ngot:\n%s", expected, buf.String())
+	}
+}
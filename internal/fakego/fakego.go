@@ -0,0 +1,404 @@
+// Package fakego is a fake go command for testing xcaddy end to end,
+// without the network or compiling anything: it records how it is run
+// and answers with canned outputs, like a successful build by default.
+//
+// The test binary itself is the fake, so a package using it must call
+// Main first thing in its TestMain:
+//
+//	func TestMain(m *testing.M) {
+//		fakego.Main()
+//		os.Exit(m.Run())
+//	}
+//
+// Then New sets XCADDY_WHICH_GO to the fake for a test. The binaries
+// it builds are copies of the fake too, which act like a Caddy binary.
+package fakego
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"testing"
+)
+
+const (
+	// envDir is the folder of the fake, with its rules and
+	// the record of its invocations, for the fake process
+	envDir = "XCADDY_FAKEGO_DIR"
+
+	rulesFile       = "rules.json"
+	invocationsFile = "invocations.jsonl"
+)
+
+// GoVersion is the version of Go the fake reports.
+const GoVersion = "go1.23.0"
+
+// CaddyVersion is the version of Caddy the fake resolves,
+// and its binaries report, unless a Rule says otherwise.
+const CaddyVersion = "v2.8.4"
+
+// distList is the output of go tool dist list -json, with
+// a few platforms which xcaddy knows.
+const distList = `[
+	{"GOOS": "darwin", "GOARCH": "arm64", "CgoSupported": true, "FirstClass": true},
+	{"GOOS": "linux", "GOARCH": "amd64", "CgoSupported": true, "FirstClass": true},
+	{"GOOS": "linux", "GOARCH": "arm", "CgoSupported": true, "FirstClass": true},
+	{"GOOS": "linux", "GOARCH": "arm64", "CgoSupported": true, "FirstClass": true},
+	{"GOOS": "windows", "GOARCH": "amd64", "CgoSupported": true, "FirstClass": true}
+]`
+
+// Go is the fake go command of a test.
+type Go struct {
+	t     testing.TB
+	dir   string
+	rules []Rule
+}
+
+// Rule answers the invocations of the fake whose arguments start
+// with Args, in which "*" matches any argument, instead of the
+// default answer. Its Name is "go", unless it's for the binaries
+// built by the fake, which are named "caddy".
+type Rule struct {
+	Name   string   `json:"name,omitempty"`
+	Args   []string `json:"args"`
+	Stdout string   `json:"stdout,omitempty"`
+	Stderr string   `json:"stderr,omitempty"`
+	Exit   int      `json:"exit,omitempty"`
+}
+
+// Invocation is a run of the fake, or of a binary it built.
+type Invocation struct {
+	// "go", or "caddy" for binaries built by the fake.
+	Name string   `json:"name"`
+	Args []string `json:"args"`
+
+	// The folder it ran in.
+	Dir string `json:"dir"`
+
+	// Its environment variables which configure the go command:
+	// those starting with GO, and CGO_ENABLED.
+	Env map[string]string `json:"env,omitempty"`
+
+	// The files in Dir and its subfolders, relative to it and
+	// with forward slashes, when the go command ran; not set
+	// for binaries built by the fake.
+	Files []string `json:"files,omitempty"`
+}
+
+// New sets XCADDY_WHICH_GO to a fake go command for the test, and
+// the home folder of the user to an empty one.
+func New(t testing.TB) *Go {
+	t.Helper()
+	exe, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+	g := &Go{t: t, dir: t.TempDir()}
+	t.Setenv("XCADDY_WHICH_GO", exe)
+	t.Setenv(envDir, g.dir)
+	// the go command of the test may run things too, like
+	// the built binaries, but its own settings mustn't leak in
+	t.Setenv("GOFLAGS", "")
+	t.Setenv("XCADDY_GO_BUILD_FLAGS", "")
+	// nor may xcaddy use or change the caches, history and
+	// other files of the user
+	home := filepath.Join(g.dir, "home")
+	for _, name := range []string{"HOME", "XDG_CACHE_HOME", "XDG_CONFIG_HOME", "AppData", "LocalAppData"} {
+		t.Setenv(name, home)
+	}
+	g.save()
+	return g
+}
+
+// Handle adds rule, which takes precedence
+// over the rules which were added before.
+func (g *Go) Handle(rule Rule) {
+	g.t.Helper()
+	if rule.Name == "" {
+		rule.Name = "go"
+	}
+	g.rules = append([]Rule{rule}, g.rules...)
+	g.save()
+}
+
+func (g *Go) save() {
+	g.t.Helper()
+	data, err := json.Marshal(g.rules)
+	if err != nil {
+		g.t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(g.dir, rulesFile), data, 0o644); err != nil {
+		g.t.Fatal(err)
+	}
+}
+
+// Invocations returns the runs of the fake so far, in order,
+// along with those of the binaries it built.
+func (g *Go) Invocations() []Invocation {
+	g.t.Helper()
+	data, err := os.ReadFile(filepath.Join(g.dir, invocationsFile))
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		g.t.Fatal(err)
+	}
+	var invocations []Invocation
+	for _, line := range strings.Split(strings.TrimSpace(string(data)), "\n") {
+		var inv Invocation
+		if err := json.Unmarshal([]byte(line), &inv); err != nil {
+			g.t.Fatal(err)
+		}
+		invocations = append(invocations, inv)
+	}
+	return invocations
+}
+
+// Find returns the invocations of the go command
+// whose arguments start with args, like Rule.Args.
+func (g *Go) Find(args ...string) []Invocation {
+	g.t.Helper()
+	var found []Invocation
+	for _, inv := range g.Invocations() {
+		if inv.Name == "go" && matches(args, inv.Args) {
+			found = append(found, inv)
+		}
+	}
+	return found
+}
+
+// Main runs the fake instead of the tests, and exits,
+// if the test binary was run as the fake.
+func Main() {
+	dir := os.Getenv(envDir)
+	if dir == "" {
+		return
+	}
+	name := "caddy"
+	if exe, err := os.Executable(); err == nil && sameFile(exe, os.Getenv("XCADDY_WHICH_GO")) {
+		name = "go"
+	}
+	os.Exit(run(dir, name, os.Args[1:]))
+}
+
+// run runs the fake named name with args, and returns its exit status.
+func run(dir, name string, args []string) int {
+	cwd, err := os.Getwd()
+	if err != nil {
+		return fail(err)
+	}
+	inv := Invocation{Name: name, Args: args, Dir: cwd, Env: make(map[string]string)}
+	for _, kv := range os.Environ() {
+		k, v, _ := strings.Cut(kv, "=")
+		if strings.HasPrefix(k, "GO") || k == "CGO_ENABLED" {
+			inv.Env[k] = v
+		}
+	}
+	if name == "go" {
+		inv.Files = listFiles(cwd)
+	}
+	if err := record(dir, inv); err != nil {
+		return fail(err)
+	}
+
+	var rules []Rule
+	data, err := os.ReadFile(filepath.Join(dir, rulesFile))
+	if err != nil {
+		return fail(err)
+	}
+	if err := json.Unmarshal(data, &rules); err != nil {
+		return fail(err)
+	}
+	for _, r := range rules {
+		if r.Name == name && matches(r.Args, args) {
+			fmt.Fprint(os.Stdout, r.Stdout)
+			fmt.Fprint(os.Stderr, r.Stderr)
+			return r.Exit
+		}
+	}
+	if name == "caddy" {
+		return runCaddy(args)
+	}
+	return runGo(args)
+}
+
+// runGo answers args like a go command which succeeds, as far as
+// xcaddy can tell, and returns the exit status.
+func runGo(args []string) int {
+	switch {
+	case matches([]string{"version"}, args):
+		fmt.Printf("go version %s %s/%s\n", GoVersion, runtime.GOOS, runtime.GOARCH)
+	case matches([]string{"env"}, args):
+		return goEnv(args[1:])
+	case matches([]string{"mod", "init", "*"}, args):
+		// go.sum is written by go get, which doesn't here
+		if code := writeFile("go.sum", nil, 0o644); code != 0 {
+			return code
+		}
+		return writeFile("go.mod", []byte("module "+args[2]+"\n\ngo 1.21\n"), 0o644)
+	case matches([]string{"mod", "edit", "-json"}, args):
+		fmt.Println(`{"Module": {"Path": "caddy"}, "Go": "1.21"}`)
+	case matches([]string{"tool", "dist", "list", "-json"}, args):
+		fmt.Println(distList)
+	case matches([]string{"list", "-m", "-json"}, args) && len(args) == 4:
+		fmt.Printf(`{"Path": %q, "Version": %q}`+"\n", args[3], CaddyVersion)
+	case matches([]string{"build"}, args):
+		for i, arg := range args {
+			if arg == "-o" && i+1 < len(args) {
+				return copySelf(args[i+1])
+			}
+		}
+	}
+	return 0
+}
+
+// goEnv prints the variables in args like go env does,
+// with -json if it is the first argument.
+func goEnv(args []string) int {
+	asJSON := len(args) > 0 && args[0] == "-json"
+	if asJSON {
+		args = args[1:]
+	}
+	vars := make(map[string]string)
+	for _, name := range args {
+		v := os.Getenv(name)
+		switch name {
+		case "GOVERSION":
+			v = GoVersion
+		case "GOOS":
+			if v == "" {
+				v = runtime.GOOS
+			}
+		case "GOARCH":
+			if v == "" {
+				v = runtime.GOARCH
+			}
+		case "GOMODCACHE", "GOCACHE", "GOPATH":
+			if v == "" {
+				v = filepath.Join(os.Getenv(envDir), strings.ToLower(name))
+			}
+		}
+		vars[name] = v
+	}
+	if asJSON {
+		data, err := json.MarshalIndent(vars, "", "\t")
+		if err != nil {
+			return fail(err)
+		}
+		fmt.Println(string(data))
+		return 0
+	}
+	for _, name := range args {
+		fmt.Println(vars[name])
+	}
+	return 0
+}
+
+// runCaddy answers args like a Caddy binary.
+func runCaddy(args []string) int {
+	if matches([]string{"version"}, args) {
+		fmt.Println(CaddyVersion)
+	}
+	return 0
+}
+
+// matches reports whether args start with prefix,
+// in which "*" matches any argument.
+func matches(prefix, args []string) bool {
+	if len(args) < len(prefix) {
+		return false
+	}
+	for i, p := range prefix {
+		if p != "*" && p != args[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// record appends inv to the invocations in dir.
+func record(dir string, inv Invocation) error {
+	data, err := json.Marshal(inv)
+	if err != nil {
+		return err
+	}
+	f, err := os.OpenFile(filepath.Join(dir, invocationsFile), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(append(data, '\n'))
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// listFiles returns the files in dir, as described by Invocation.Files.
+func listFiles(dir string) []string {
+	var files []string
+	_ = filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() {
+			return nil
+		}
+		rel, err := filepath.Rel(dir, path)
+		if err == nil {
+			files = append(files, filepath.ToSlash(rel))
+		}
+		return nil
+	})
+	sort.Strings(files)
+	return files
+}
+
+// copySelf writes a copy of the fake to file, as the built binary.
+func copySelf(file string) int {
+	exe, err := os.Executable()
+	if err != nil {
+		return fail(err)
+	}
+	src, err := os.Open(exe)
+	if err != nil {
+		return fail(err)
+	}
+	defer src.Close()
+	dst, err := os.OpenFile(file, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0o755)
+	if err != nil {
+		return fail(err)
+	}
+	_, err = io.Copy(dst, src)
+	if closeErr := dst.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fail(err)
+	}
+	return 0
+}
+
+func writeFile(name string, data []byte, perm os.FileMode) int {
+	if err := os.WriteFile(name, data, perm); err != nil {
+		return fail(err)
+	}
+	return 0
+}
+
+// sameFile reports whether a and b are paths of the same file.
+func sameFile(a, b string) bool {
+	if b == "" {
+		return false
+	}
+	infoA, errA := os.Stat(a)
+	infoB, errB := os.Stat(b)
+	return errA == nil && errB == nil && os.SameFile(infoA, infoB)
+}
+
+func fail(err error) int {
+	fmt.Fprintln(os.Stderr, "fakego:", err)
+	return 1
+}
@@ -0,0 +1,104 @@
+package utils
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// noCacheKey is the context key which disables Cached.
+type noCacheKey struct{}
+
+// WithoutCache returns a copy of ctx with which
+// Cached neither reads nor writes the cache.
+func WithoutCache(ctx context.Context) context.Context {
+	return context.WithValue(ctx, noCacheKey{}, true)
+}
+
+// Cached returns the result of the lookup named key from the cache
+// in the cache folder of the user, like ~/.cache/xcaddy/lookups, if
+// it was cached less than ttl ago, or else calls lookup and caches its
+// result if it succeeds. Errors of the cache itself are ignored, since
+// it only saves time.
+func Cached[T any](ctx context.Context, key string, ttl time.Duration, lookup func() (T, error)) (T, error) {
+	var file string
+	if !CacheDisabled(ctx) {
+		file = cacheFile(key)
+	}
+	if file != "" {
+		if value, ok := readCached[T](file, key, ttl); ok {
+			return value, nil
+		}
+	}
+	value, err := lookup()
+	if err == nil && file != "" {
+		writeCached(file, key, value)
+	}
+	return value, err
+}
+
+// cacheEntry is the content of a file of the cache.
+type cacheEntry[T any] struct {
+	Key   string    `json:"key"`
+	Time  time.Time `json:"time"`
+	Value T         `json:"value"`
+}
+
+// cacheFile returns the file of the cache for key,
+// or an empty string if there is no cache folder.
+func cacheFile(key string) string {
+	cacheDir, err := os.UserCacheDir()
+	if err != nil {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(key))
+	return filepath.Join(cacheDir, "xcaddy", "lookups", hex.EncodeToString(sum[:16])+".json")
+}
+
+// readCached reads the value cached for key from file, if any,
+// and returns false if there is none, or it is older than ttl.
+func readCached[T any](file, key string, ttl time.Duration) (T, bool) {
+	var entry cacheEntry[T]
+	data, err := os.ReadFile(file)
+	if err != nil || json.Unmarshal(data, &entry) != nil {
+		return entry.Value, false
+	}
+	age := time.Since(entry.Time)
+	return entry.Value, entry.Key == key && age >= 0 && age < ttl
+}
+
+// writeCached writes value for key to file, replacing it at once,
+// so concurrent invocations never read a partial file.
+func writeCached[T any](file, key string, value T) {
+	data, err := json.Marshal(cacheEntry[T]{Key: key, Time: time.Now(), Value: value})
+	if err != nil {
+		return
+	}
+	if os.MkdirAll(filepath.Dir(file), 0o755) != nil {
+		return
+	}
+	tmp, err := os.CreateTemp(filepath.Dir(file), ".lookup-*")
+	if err != nil {
+		return
+	}
+	_, err = tmp.Write(data)
+	if err2 := tmp.Close(); err == nil {
+		err = err2
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), file)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+	}
+}
+
+// CacheDisabled returns whether ctx was made with WithoutCache.
+func CacheDisabled(ctx context.Context) bool {
+	noCache, _ := ctx.Value(noCacheKey{}).(bool)
+	return noCache
+}
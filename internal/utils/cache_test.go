@@ -0,0 +1,56 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestCached(t *testing.T) {
+	dir := t.TempDir()
+	t.Setenv("XDG_CACHE_HOME", dir)
+	t.Setenv("HOME", dir)
+	t.Setenv("LocalAppData", dir)
+	ctx := context.Background()
+
+	calls := 0
+	lookup := func() ([]string, error) {
+		calls++
+		return []string{"v2.8.4", "v2.9.0"}, nil
+	}
+	for i := 0; i < 2; i++ {
+		got, err := Cached(ctx, "versions", time.Hour, lookup)
+		if err != nil {
+			t.Fatalf("Cached() error = %v", err)
+		}
+		if len(got) != 2 || got[1] != "v2.9.0" {
+			t.Errorf("Cached() = %v", got)
+		}
+	}
+	if calls != 1 {
+		t.Errorf("lookup was called %d times, want once", calls)
+	}
+
+	// expired entries, other keys and WithoutCache look up again
+	if _, err := Cached(ctx, "versions", 0, lookup); err != nil || calls != 2 {
+		t.Errorf("Cached() with expired entry: error = %v, lookups = %d, want 2", err, calls)
+	}
+	if _, err := Cached(ctx, "other", time.Hour, lookup); err != nil || calls != 3 {
+		t.Errorf("Cached() with other key: error = %v, lookups = %d, want 3", err, calls)
+	}
+	if _, err := Cached(WithoutCache(ctx), "versions", time.Hour, lookup); err != nil || calls != 4 {
+		t.Errorf("Cached() without cache: error = %v, lookups = %d, want 4", err, calls)
+	}
+
+	// errors are not cached
+	failing := errors.New("offline")
+	_, err := Cached(ctx, "failing", time.Hour, func() (string, error) { return "", failing })
+	if !errors.Is(err, failing) {
+		t.Errorf("Cached() error = %v, want %v", err, failing)
+	}
+	got, err := Cached(ctx, "failing", time.Hour, func() (string, error) { return "ok", nil })
+	if err != nil || got != "ok" {
+		t.Errorf("Cached() after error = %q, %v, want ok", got, err)
+	}
+}
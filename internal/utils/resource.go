@@ -15,9 +15,9 @@ import (
 var embedFS embed.FS
 
 // WindowsResource create a Windows resource system object
-// for embedding into the Caddy binary.
+// for embedding into the Caddy binary for the architecture arch.
 // reference: https://github.com/rclone/rclone/blob/v1.66.0/bin/resource_windows.go
-func WindowsResource(version, outputFile, tempDir string) error {
+func WindowsResource(version, arch, outputFile, tempDir string) error {
 	vi := &goversioninfo.VersionInfo{}
 
 	// FixedFileInfo
@@ -79,8 +79,12 @@ func WindowsResource(version, outputFile, tempDir string) error {
 	// Write the native structures as binary data to a buffer
 	vi.Walk()
 
-	arch := GetGOARCH()
-
-	// Write the binary data buffer to file
-	return vi.WriteSyso(filepath.Join(tempDir, fmt.Sprintf("resource_windows_%s.syso", arch)), arch)
+	// Write the binary data buffer to file, which is renamed into
+	// place, since a build for another target may be reading it
+	syso := filepath.Join(tempDir, fmt.Sprintf("resource_windows_%s.syso", arch))
+	err = vi.WriteSyso(syso+".tmp", arch)
+	if err != nil {
+		return err
+	}
+	return os.Rename(syso+".tmp", syso)
 }
@@ -0,0 +1,32 @@
+package utils
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWindowsResource(t *testing.T) {
+	dir := t.TempDir()
+	for _, arch := range []string{"amd64", "arm64"} {
+		if err := WindowsResource("v2.8.4", arch, "caddy_windows_"+arch+".exe", dir); err != nil {
+			t.Fatal(err)
+		}
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	for _, want := range []string{"resource_windows_amd64.syso", "resource_windows_arm64.syso"} {
+		if _, err := os.Stat(filepath.Join(dir, want)); err != nil {
+			t.Errorf("%s is missing among %v", want, names)
+		}
+	}
+	if len(names) != 3 {
+		t.Errorf("files = %v, want the icon and a resource for each architecture", names)
+	}
+}
@@ -0,0 +1,89 @@
+package utils
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// IsYAML reports whether file is a YAML file,
+// like xcaddy.yaml, by its extension.
+func IsYAML(file string) bool {
+	ext := strings.ToLower(filepath.Ext(file))
+	return ext == ".yaml" || ext == ".yml"
+}
+
+// YAMLToJSON converts the YAML document in data to JSON, so it can
+// be decoded and checked like a JSON file. Keys must be strings, and
+// an empty document converts to null.
+func YAMLToJSON(data []byte) ([]byte, error) {
+	var v any
+	if err := yaml.Unmarshal(data, &v); err != nil {
+		return nil, err
+	}
+	v, err := jsonValue("", v)
+	if err != nil {
+		return nil, err
+	}
+	return json.Marshal(v)
+}
+
+// jsonValue returns v, as decoded from YAML at the position
+// path, with only the types which JSON can represent.
+func jsonValue(path string, v any) (any, error) {
+	switch v := v.(type) {
+	case map[string]any:
+		for k, item := range v {
+			itemPath := k
+			if path != "" {
+				itemPath = path + "." + k
+			}
+			item, err := jsonValue(itemPath, item)
+			if err != nil {
+				return nil, err
+			}
+			v[k] = item
+		}
+		return v, nil
+	case map[any]any:
+		if path == "" {
+			return nil, fmt.Errorf("keys must be strings")
+		}
+		return nil, fmt.Errorf("%s: keys must be strings", path)
+	case []any:
+		for i, item := range v {
+			item, err := jsonValue(fmt.Sprintf("%s[%d]", path, i), item)
+			if err != nil {
+				return nil, err
+			}
+			v[i] = item
+		}
+		return v, nil
+	default:
+		return v, nil
+	}
+}
+
+// JSONToYAML converts the JSON document in data to YAML in block
+// style, keeping the order of the fields.
+func JSONToYAML(data []byte) ([]byte, error) {
+	// JSON is YAML, in flow style
+	var doc yaml.Node
+	if err := yaml.Unmarshal(data, &doc); err != nil {
+		return nil, err
+	}
+	blockStyle(&doc)
+	return yaml.Marshal(&doc)
+}
+
+// blockStyle clears the style of n and its children, so they are
+// written in block style, with strings quoted only where needed.
+func blockStyle(n *yaml.Node) {
+	n.Style = 0
+	for _, c := range n.Content {
+		blockStyle(c)
+	}
+}
@@ -0,0 +1,68 @@
+package utils
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestYAMLToJSON(t *testing.T) {
+	tests := []struct {
+		name    string
+		yaml    string
+		want    string
+		wantErr bool
+	}{
+		{
+			name: "manifest",
+			yaml: "caddy_version: v2.8.4\nplugins:\n  - module_path: github.com/caddy-dns/cloudflare\n    version: v0.1.0\ntimeout_get: 300000000000\nbare: true\n",
+			want: `{"bare":true,"caddy_version":"v2.8.4","plugins":[{"module_path":"github.com/caddy-dns/cloudflare","version":"v0.1.0"}],"timeout_get":300000000000}`,
+		},
+		{name: "empty", yaml: "", want: "null"},
+		{name: "syntax error", yaml: "plugins: [\n", wantErr: true},
+		{name: "key which is not a string", yaml: "defines:\n  1: one\n", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := YAMLToJSON([]byte(tt.yaml))
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("YAMLToJSON() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if string(got) != tt.want {
+				t.Errorf("YAMLToJSON() = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestJSONToYAML(t *testing.T) {
+	data := []byte(`{"caddy_version": "v2.8.4", "plugins": [{"module_path": "github.com/caddy-dns/cloudflare"}], "defines": {"a": "true", "b": "1.0"}}`)
+	got, err := JSONToYAML(data)
+	if err != nil {
+		t.Fatalf("JSONToYAML() error = %v", err)
+	}
+	want := `caddy_version: v2.8.4
+plugins:
+    - module_path: github.com/caddy-dns/cloudflare
+defines:
+    a: "true"
+    b: "1.0"
+`
+	if string(got) != want {
+		t.Errorf("JSONToYAML() =\n%s\nwant:\n%s", got, want)
+	}
+	back, err := YAMLToJSON(got)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var v1, v2 any
+	if err := json.Unmarshal(data, &v1); err != nil {
+		t.Fatal(err)
+	}
+	if err := json.Unmarshal(back, &v2); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(v1, v2) {
+		t.Errorf("YAMLToJSON(JSONToYAML()) = %s, want %s", back, data)
+	}
+}
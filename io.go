@@ -12,6 +12,18 @@ import (
 
 // copy recursively copies src into dst with src's file modes.
 func copy(src, dst string) error {
+	return copyWithMode(src, dst, 0)
+}
+
+// copyWritable is like copy, but ensures the copied files
+// and directories are writable by the owner.
+func copyWritable(src, dst string) error {
+	return copyWithMode(src, dst, 0o200)
+}
+
+// copyWithMode recursively copies src into dst with src's
+// file modes, plus any bits set in extraMode.
+func copyWithMode(src, dst string, extraMode os.FileMode) error {
 	src, _ = filepath.Abs(src)
 	src = filepath.ToSlash(src)
 	dst = filepath.ToSlash(dst)
@@ -28,12 +40,12 @@ func copy(src, dst string) error {
 		// So we join "a/b" with "c.txt" and use it as the destination.
 		dst := filepath.ToSlash(filepath.Join(dst, strings.Replace(path, src, "", 1)))
 		if info.IsDir() {
-			return os.MkdirAll(dst, info.Mode())
+			return os.MkdirAll(dst, info.Mode()|extraMode)
 		}
 		if info.Mode()&os.ModeSymlink != 0 {
 			return copySymlink(path, dst)
 		}
-		return copyFile(path, dst, info.Mode())
+		return copyFile(path, dst, info.Mode()|extraMode)
 	})
 }
 
@@ -0,0 +1,67 @@
+// Copyright 2020 Matthew Holt
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package xcaddy
+
+import (
+	"fmt"
+	"strings"
+)
+
+// The presets of the main package; see Builder.MainPreset.
+const (
+	MainPresetPlain = "plain"
+	MainPresetRun   = "run"
+)
+
+// MainDefaults are the defaults which the main package of the
+// run preset applies before running Caddy.
+type MainDefaults struct {
+	// The config file of the commands run, start, reload, validate
+	// and adapt, unless the --config flag is given.
+	Config string `json:"config,omitempty"`
+
+	// The config adapter of those commands, unless the --adapter
+	// flag is given, like caddyfile.
+	Adapter string `json:"adapter,omitempty"`
+
+	// Environment variables which are set when Caddy starts,
+	// unless they are set already.
+	Env map[string]string `json:"env,omitempty"`
+}
+
+// IsZero returns true if no default is set.
+func (d MainDefaults) IsZero() bool {
+	return d.Config == "" && d.Adapter == "" && len(d.Env) == 0
+}
+
+// checkMainPreset returns an error if preset is not one of the presets
+// of the main package, or defaults are set for one which has none.
+func checkMainPreset(preset string, defaults MainDefaults) error {
+	switch preset {
+	case "", MainPresetPlain:
+		if !defaults.IsZero() {
+			return fmt.Errorf("defaults of the main package require the %s preset", MainPresetRun)
+		}
+	case MainPresetRun:
+		for key := range defaults.Env {
+			if key == "" || strings.ContainsAny(key, "= \t\n") {
+				return fmt.Errorf("invalid name of environment variable %q: must not be empty or contain = or spaces", key)
+			}
+		}
+	default:
+		return fmt.Errorf("unknown preset of the main package %q: must be %s or %s", preset, MainPresetPlain, MainPresetRun)
+	}
+	return nil
+}
@@ -0,0 +1,462 @@
+// Copyright 2020 Matthew Holt
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package xcaddy
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/caddyserver/xcaddy/internal/utils"
+)
+
+// ManifestSchema returns the JSON Schema of manifests, which are JSON
+// or YAML files with the fields of Builder, like xcaddy.json, for
+// editors to complete and check them. ParseManifest checks manifests against it.
+func ManifestSchema() ([]byte, error) {
+	return json.MarshalIndent(manifestSchema(), "", "\t")
+}
+
+// ParseManifest parses the manifest in data into a Builder, after
+// checking it against the schema of ManifestSchema. Its errors name
+// the position of each problem, like "plugins[3].version: invalid
+// semver: v1.2", since unknown fields would otherwise be ignored.
+// Manifests which extend others must be read with ReadManifest.
+func ParseManifest(data []byte) (Builder, error) {
+	manifest, err := decodeManifest(data)
+	if err != nil {
+		return Builder{}, err
+	}
+	if _, ok := manifest["extends"]; ok {
+		return Builder{}, fmt.Errorf("extends: the manifest must be read from its file, which the path of its base is relative to")
+	}
+	manifest, err = mergeManifests(map[string]any{}, manifest)
+	if err != nil {
+		return Builder{}, err
+	}
+	return builderOf(manifest)
+}
+
+// ReadManifest reads the manifest in file like ParseManifest, along
+// with the manifest it extends, if any. Files named *.yaml or *.yml,
+// like xcaddy.yaml, are read as YAML, with the same fields. A manifest extends another
+// one, like a base with the plugins shared by many services, with
+// "extends" and the path of that one, relative to its own folder.
+// It is then merged onto its base, which may extend another one in
+// turn: fields replace those of the base, except for objects, which
+// are merged field by field, and lists, which are appended to those
+// of the base. Plugins replace those of the base with the same path,
+// and "remove_plugins" lists plugins of the base to leave out. A
+// null field resets that of the base.
+func ReadManifest(file string) (Builder, error) {
+	manifest, err := readManifestFile(file, nil)
+	if err != nil {
+		return Builder{}, err
+	}
+	return builderOf(manifest)
+}
+
+// readManifestFile reads the manifest in file, merged onto the
+// manifests it extends. extending are the absolute paths of the
+// manifests which extend it, to detect cycles.
+func readManifestFile(file string, extending []string) (map[string]any, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	if utils.IsYAML(file) {
+		data, err = utils.YAMLToJSON(data)
+		if err != nil {
+			return nil, fmt.Errorf("reading manifest %s: %v", file, err)
+		}
+	}
+	manifest, err := decodeManifest(data)
+	if err != nil {
+		return nil, fmt.Errorf("reading manifest %s: %v", file, err)
+	}
+	base := map[string]any{}
+	if extends, ok := manifest["extends"].(string); ok {
+		delete(manifest, "extends")
+		if !filepath.IsAbs(extends) {
+			extends = filepath.Join(filepath.Dir(file), extends)
+		}
+		abs, err := filepath.Abs(file)
+		if err != nil {
+			return nil, err
+		}
+		extending = append(extending, abs)
+		absBase, err := filepath.Abs(extends)
+		if err != nil {
+			return nil, err
+		}
+		for _, f := range extending {
+			if f == absBase {
+				return nil, fmt.Errorf("reading manifest %s: it extends itself through %s", extends, strings.Join(extending, ", "))
+			}
+		}
+		base, err = readManifestFile(extends, extending)
+		if err != nil {
+			return nil, err
+		}
+	}
+	manifest, err = mergeManifests(base, manifest)
+	if err != nil {
+		return nil, fmt.Errorf("reading manifest %s: %v", file, err)
+	}
+	return manifest, nil
+}
+
+// decodeManifest decodes the manifest in data,
+// after checking it against the schema.
+func decodeManifest(data []byte) (map[string]any, error) {
+	var v any
+	if err := json.Unmarshal(data, &v); err != nil {
+		var syntaxErr *json.SyntaxError
+		if errors.As(err, &syntaxErr) {
+			line, col := position(data, syntaxErr.Offset-1)
+			return nil, fmt.Errorf("line %d, column %d: %v", line, col, err)
+		}
+		return nil, err
+	}
+	var errs []error
+	manifestSchema().validate("", v, &errs)
+	if len(errs) > 0 {
+		return nil, errors.Join(errs...)
+	}
+	manifest, ok := v.(map[string]any)
+	if !ok {
+		return nil, fmt.Errorf("manifest: expected an object, got %s", jsonType(v))
+	}
+	return manifest, nil
+}
+
+// mergeManifests returns the decoded manifest merged onto base,
+// as described by ReadManifest. Neither may extend another one.
+func mergeManifests(base, manifest map[string]any) (map[string]any, error) {
+	if removed, ok := manifest["remove_plugins"].([]any); ok {
+		// plugins are removed from those of the base, so the
+		// manifest may add them back with another version
+		basePlugins, _ := base["plugins"].([]any)
+		for i, r := range removed {
+			if !slices.ContainsFunc(basePlugins, func(p any) bool { return pluginPath(p) == r }) {
+				return nil, fmt.Errorf("remove_plugins[%d]: %s is not a plugin of the manifest it extends", i, r)
+			}
+		}
+		var plugins []any
+		for _, p := range basePlugins {
+			if !slices.Contains(removed, any(pluginPath(p))) {
+				plugins = append(plugins, p)
+			}
+		}
+		base = mergeObjects(base, map[string]any{"plugins": nil})
+		if len(plugins) > 0 {
+			base["plugins"] = plugins
+		}
+	}
+	merged := mergeObjects(base, manifest)
+	delete(merged, "remove_plugins")
+	return merged, nil
+}
+
+// mergeObjects returns the fields of overlay merged onto
+// those of base, without changing either.
+func mergeObjects(base, overlay map[string]any) map[string]any {
+	merged := make(map[string]any, len(base)+len(overlay))
+	for name, v := range base {
+		merged[name] = v
+	}
+	for name, v := range overlay {
+		baseValue, ok := merged[name]
+		switch {
+		case v == nil:
+			delete(merged, name)
+		case !ok || baseValue == nil:
+			merged[name] = v
+		case name == "plugins":
+			merged[name] = mergePlugins(baseValue.([]any), v.([]any))
+		default:
+			switch v := v.(type) {
+			case map[string]any:
+				merged[name] = mergeObjects(baseValue.(map[string]any), v)
+			case []any:
+				merged[name] = append(append([]any{}, baseValue.([]any)...), v...)
+			default:
+				merged[name] = v
+			}
+		}
+	}
+	return merged
+}
+
+// mergePlugins returns the plugins of base, replaced by those
+// of plugins with the same path, followed by the other plugins.
+func mergePlugins(base, plugins []any) []any {
+	merged := append([]any{}, base...)
+	for _, p := range plugins {
+		replaced := false
+		for i, b := range merged {
+			if pluginPath(b) == pluginPath(p) {
+				merged[i] = p
+				replaced = true
+			}
+		}
+		if !replaced {
+			merged = append(merged, p)
+		}
+	}
+	return merged
+}
+
+// pluginPath returns the path of the decoded plugin p.
+func pluginPath(p any) string {
+	plugin, _ := p.(map[string]any)
+	path, _ := plugin["module_path"].(string)
+	return path
+}
+
+// builderOf returns the Builder of the decoded manifest.
+func builderOf(manifest map[string]any) (Builder, error) {
+	data, err := json.Marshal(manifest)
+	if err != nil {
+		return Builder{}, err
+	}
+	var b Builder
+	err = json.Unmarshal(data, &b)
+	return b, err
+}
+
+// jsonSchema is the subset of JSON Schema which
+// describes manifests, and which validate checks.
+type jsonSchema struct {
+	Schema               string                 `json:"$schema,omitempty"`
+	Title                string                 `json:"title,omitempty"`
+	Type                 string                 `json:"type,omitempty"`
+	Properties           map[string]*jsonSchema `json:"properties,omitempty"`
+	Required             []string               `json:"required,omitempty"`
+	AdditionalProperties any                    `json:"additionalProperties,omitempty"`
+	Items                *jsonSchema            `json:"items,omitempty"`
+	Pattern              string                 `json:"pattern,omitempty"`
+
+	// the error if a string doesn't match Pattern
+	patternError string
+}
+
+// semverPattern matches the versions of modules: anything which is
+// not prefixed with v, like a branch or commit, is passed to go get
+// as is, but otherwise it must be a semantic version, as required by
+// versionedModulePath.
+const semverPattern = `^([^v].*|v(0|[1-9][0-9]*)\.(0|[1-9][0-9]*)\.(0|[1-9][0-9]*)(-[0-9A-Za-z.-]+)?(\+[0-9A-Za-z.-]+)?)?$`
+
+// manifestSchema returns the schema of manifests, derived from
+// the fields of Builder, so it can't get out of date.
+func manifestSchema() *jsonSchema {
+	s := schemaOf(reflect.TypeOf(Builder{}))
+	s.Schema = "https://json-schema.org/draft/2020-12/schema"
+	s.Title = "xcaddy manifest"
+	// lets editors find the schema of a manifest
+	s.Properties["$schema"] = &jsonSchema{Type: "string"}
+	// see ReadManifest
+	s.Properties["extends"] = &jsonSchema{Type: "string"}
+	s.Properties["remove_plugins"] = &jsonSchema{Type: "array", Items: &jsonSchema{Type: "string"}}
+	return s
+}
+
+// schemaOf returns the schema of the JSON encoding of values of t.
+func schemaOf(t reflect.Type) *jsonSchema {
+	switch t.Kind() {
+	case reflect.Pointer:
+		return schemaOf(t.Elem())
+	case reflect.Bool:
+		return &jsonSchema{Type: "boolean"}
+	case reflect.String:
+		return &jsonSchema{Type: "string"}
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return &jsonSchema{Type: "integer"}
+	case reflect.Float32, reflect.Float64:
+		return &jsonSchema{Type: "number"}
+	case reflect.Slice, reflect.Array:
+		return &jsonSchema{Type: "array", Items: schemaOf(t.Elem())}
+	case reflect.Map:
+		return &jsonSchema{Type: "object", AdditionalProperties: schemaOf(t.Elem())}
+	case reflect.Struct:
+		s := &jsonSchema{Type: "object", Properties: make(map[string]*jsonSchema), AdditionalProperties: false}
+		addProperties(s, t)
+		switch t {
+		case reflect.TypeOf(Dependency{}):
+			s.Required = []string{"module_path"}
+			s.Properties["version"].Pattern = semverPattern
+			s.Properties["version"].patternError = "invalid semver"
+		case reflect.TypeOf(Replace{}):
+			s.Required = []string{"old", "new"}
+		case reflect.TypeOf(Patch{}):
+			s.Required = []string{"module_path", "file"}
+		}
+		return s
+	}
+	return &jsonSchema{}
+}
+
+// addProperties adds the fields of the struct type t to s,
+// including those of embedded structs, as encoding/json does.
+func addProperties(s *jsonSchema, t reflect.Type) {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		name, _, _ := strings.Cut(f.Tag.Get("json"), ",")
+		if !f.IsExported() || name == "-" || f.Type.Kind() == reflect.Func {
+			continue
+		}
+		if f.Anonymous && name == "" && f.Type.Kind() == reflect.Struct {
+			addProperties(s, f.Type)
+			continue
+		}
+		if name == "" {
+			name = f.Name
+		}
+		s.Properties[name] = schemaOf(f.Type)
+	}
+}
+
+// validate appends the errors of v, found at path, to errs.
+func (s *jsonSchema) validate(path string, v any, errs *[]error) {
+	if v == nil {
+		// null is the same as leaving the field out
+		return
+	}
+	fail := func(format string, args ...any) {
+		at := path
+		if at == "" {
+			at = "manifest"
+		}
+		*errs = append(*errs, fmt.Errorf("%s: %s", at, fmt.Sprintf(format, args...)))
+	}
+	switch s.Type {
+	case "boolean":
+		if _, ok := v.(bool); !ok {
+			fail("expected a boolean, got %s", jsonType(v))
+		}
+	case "string":
+		str, ok := v.(string)
+		if !ok {
+			fail("expected a string, got %s", jsonType(v))
+			return
+		}
+		if s.Pattern != "" && !regexp.MustCompile(s.Pattern).MatchString(str) {
+			fail("%s: %s", s.patternError, str)
+		}
+	case "integer":
+		n, ok := v.(float64)
+		if !ok || n != math.Trunc(n) {
+			fail("expected an integer, got %s", jsonType(v))
+		}
+	case "number":
+		if _, ok := v.(float64); !ok {
+			fail("expected a number, got %s", jsonType(v))
+		}
+	case "array":
+		items, ok := v.([]any)
+		if !ok {
+			fail("expected an array, got %s", jsonType(v))
+			return
+		}
+		for i, item := range items {
+			at := fmt.Sprintf("%s[%d]", path, i)
+			if item == nil && s.Items.Type != "" {
+				// unlike fields, items can't be left out
+				*errs = append(*errs, fmt.Errorf("%s: expected %s, got null", at, withArticle(s.Items.Type)))
+				continue
+			}
+			s.Items.validate(at, item, errs)
+		}
+	case "object":
+		obj, ok := v.(map[string]any)
+		if !ok {
+			fail("expected an object, got %s", jsonType(v))
+			return
+		}
+		for _, name := range s.Required {
+			if obj[name] == nil || obj[name] == "" {
+				fail("%s is required", name)
+			}
+		}
+		names := make([]string, 0, len(obj))
+		for name := range obj {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			at := name
+			if path != "" {
+				at = path + "." + name
+			}
+			if prop, ok := s.Properties[name]; ok {
+				prop.validate(at, obj[name], errs)
+			} else if additional, ok := s.AdditionalProperties.(*jsonSchema); ok {
+				additional.validate(at, obj[name], errs)
+			} else {
+				*errs = append(*errs, fmt.Errorf("%s: unknown field", at))
+			}
+		}
+	}
+}
+
+// jsonType returns the JSON type of v, as decoded by encoding/json.
+func jsonType(v any) string {
+	switch v.(type) {
+	case bool:
+		return "a boolean"
+	case string:
+		return "a string"
+	case float64:
+		return "a number"
+	case []any:
+		return "an array"
+	case map[string]any:
+		return "an object"
+	}
+	return "null"
+}
+
+// withArticle returns the JSON type typ of a schema
+// with its article, like jsonType names decoded values.
+func withArticle(typ string) string {
+	if strings.ContainsAny(typ[:1], "aeiou") {
+		return "an " + typ
+	}
+	return "a " + typ
+}
+
+// position returns the line and column, from 1,
+// of the byte at offset in data.
+func position(data []byte, offset int64) (line, col int) {
+	line, col = 1, 1
+	for _, c := range data[:min(offset, int64(len(data)))] {
+		if c == '\n' {
+			line++
+			col = 1
+		} else {
+			col++
+		}
+	}
+	return line, col
+}
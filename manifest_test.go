@@ -0,0 +1,293 @@
+// Copyright 2020 Matthew Holt
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package xcaddy
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseManifest(t *testing.T) {
+	for _, tt := range []struct {
+		name     string
+		manifest string
+		wantErrs []string
+	}{
+		{
+			name: "valid",
+			manifest: `{
+	"$schema": "./xcaddy.schema.json",
+	"caddy_version": "v2.8.4",
+	"os": "linux",
+	"plugins": [
+		{"module_path": "github.com/caddy-dns/cloudflare", "version": "v0.1.0"},
+		{"module_path": "github.com/mholt/caddy-l4", "version": "master"},
+		{"module_path": "github.com/example/incompatible", "version": "v2.0.0+incompatible"},
+		{"module_path": "github.com/example/latest"}
+	],
+	"defines": {"example.com/plugin.Name": "value"},
+	"embed_dir": [{"dir": "./site"}],
+	"timeout_get": 300000000000,
+	"invocation": null
+}`,
+		},
+		{
+			name: "errors",
+			manifest: `{
+	"plugins": [
+		{"module_path": "github.com/caddy-dns/cloudflare", "version": "v0.1"},
+		{"version": "v0.1.0"}
+	],
+	"replacements": [{"old": "github.com/caddy-dns/cloudflare"}],
+	"defines": {"example.com/plugin.Name": 1},
+	"timeout_get": "5m",
+	"embed_max_size": 1.5,
+	"sandbx": true,
+	"main_defaults": {"confg": "Caddyfile"}
+}`,
+			wantErrs: []string{
+				"defines.example.com/plugin.Name: expected a string, got a number",
+				"embed_max_size: expected an integer, got a number",
+				"main_defaults.confg: unknown field",
+				"plugins[0].version: invalid semver: v0.1",
+				"plugins[1]: module_path is required",
+				"replacements[0]: new is required",
+				"sandbx: unknown field",
+				"timeout_get: expected an integer, got a string",
+			},
+		},
+		{
+			name:     "not an object",
+			manifest: `["github.com/caddy-dns/cloudflare"]`,
+			wantErrs: []string{"manifest: expected an object, got an array"},
+		},
+		{
+			name:     "null",
+			manifest: `null`,
+			wantErrs: []string{"manifest: expected an object, got null"},
+		},
+		{
+			name:     "null plugin",
+			manifest: `{"plugins": [null, {"module_path": "github.com/caddy-dns/cloudflare"}]}`,
+			wantErrs: []string{"plugins[0]: expected an object, got null"},
+		},
+		{
+			name:     "syntax",
+			manifest: "{\n\t\"caddy_version\" \"v2.8.4\"\n}",
+			wantErrs: []string{`line 2, column 18: invalid character '"' after object key`},
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			b, err := ParseManifest([]byte(tt.manifest))
+			if len(tt.wantErrs) == 0 {
+				if err != nil {
+					t.Fatalf("ParseManifest() error = %v", err)
+				}
+				if len(b.Plugins) != 4 || b.Plugins[1].Version != "master" || b.OS != "linux" {
+					t.Errorf("ParseManifest() = %+v, want the build of the manifest", b)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatal("ParseManifest() error = nil")
+			}
+			if got, want := err.Error(), strings.Join(tt.wantErrs, "\n"); got != want {
+				t.Errorf("ParseManifest() error =\n%s\nwant\n%s", got, want)
+			}
+		})
+	}
+}
+
+func TestReadManifest(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, manifest string) string {
+		file := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(file), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(file, []byte(manifest), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return file
+	}
+	write("base.json", `{
+	"caddy_version": "v2.8.4",
+	"os": "linux",
+	"arch": "amd64",
+	"plugins": [
+		{"module_path": "github.com/caddy-dns/cloudflare", "version": "v0.1.0"},
+		{"module_path": "github.com/mholt/caddy-l4/layer4"},
+		{"module_path": "github.com/caddyserver/transform-encoder"}
+	],
+	"defines": {"example.com/plugin.A": "a", "example.com/plugin.B": "b"},
+	"why": ["github.com/libdns/libdns"],
+	"sandbox": true
+}`)
+	overlay := write("services/api/xcaddy.json", `{
+	"extends": "../../base.json",
+	"arch": "arm64",
+	"plugins": [
+		{"module_path": "github.com/caddy-dns/cloudflare", "version": "v0.2.0"},
+		{"module_path": "github.com/greenpau/caddy-security"}
+	],
+	"remove_plugins": ["github.com/mholt/caddy-l4/layer4"],
+	"defines": {"example.com/plugin.B": "c"},
+	"why": ["golang.org/x/net"],
+	"sandbox": null
+}`)
+
+	got, err := ReadManifest(overlay)
+	if err != nil {
+		t.Fatalf("ReadManifest() error = %v", err)
+	}
+	want := Builder{
+		Compile:      Compile{Platform: Platform{OS: "linux", Arch: "arm64"}},
+		CaddyVersion: "v2.8.4",
+		Plugins: []Dependency{
+			{PackagePath: "github.com/caddy-dns/cloudflare", Version: "v0.2.0"},
+			{PackagePath: "github.com/caddyserver/transform-encoder"},
+			{PackagePath: "github.com/greenpau/caddy-security"},
+		},
+		Defines: map[string]string{"example.com/plugin.A": "a", "example.com/plugin.B": "c"},
+		Why:     []string{"github.com/libdns/libdns", "golang.org/x/net"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ReadManifest() =\n%+v\nwant\n%+v", got, want)
+	}
+
+	for _, tt := range []struct {
+		name     string
+		manifest string
+		wantErr  string
+	}{
+		{
+			name:     "unknown plugin",
+			manifest: `{"extends": "base.json", "remove_plugins": ["github.com/caddy-dns/route53"]}`,
+			wantErr:  "remove_plugins[0]: github.com/caddy-dns/route53 is not a plugin of the manifest it extends",
+		},
+		{
+			name:     "null plugin",
+			manifest: `{"extends": "base.json", "plugins": [null]}`,
+			wantErr:  "plugins[0]: expected an object, got null",
+		},
+		{
+			name:     "plugin which is not an object",
+			manifest: `{"extends": "base.json", "plugins": ["github.com/caddy-dns/route53"]}`,
+			wantErr:  "plugins[0]: expected an object, got a string",
+		},
+		{
+			name:     "null removed plugin",
+			manifest: `{"extends": "base.json", "remove_plugins": [null]}`,
+			wantErr:  "remove_plugins[0]: expected a string, got null",
+		},
+		{
+			name:     "null base",
+			manifest: `{"extends": "null.json"}`,
+			wantErr:  "null.json: manifest: expected an object, got null",
+		},
+		{
+			name:     "cycle",
+			manifest: `{"extends": "service.json"}`,
+			wantErr:  "extends itself",
+		},
+		{
+			name:     "invalid base",
+			manifest: `{"extends": "invalid.json"}`,
+			wantErr:  "invalid.json: sandbx: unknown field",
+		},
+	} {
+		t.Run(tt.name, func(t *testing.T) {
+			write("invalid.json", `{"sandbx": true}`)
+			write("null.json", `null`)
+			file := write("service.json", tt.manifest)
+			_, err := ReadManifest(file)
+			if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+				t.Errorf("ReadManifest() error = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+
+	yamlOverlay := write("services/web/xcaddy.yaml", `# yaml-language-server: $schema=../../xcaddy.schema.json
+extends: ../../base.json
+arch: arm64
+plugins:
+  - module_path: github.com/caddy-dns/cloudflare
+    version: v0.2.0
+  - module_path: github.com/greenpau/caddy-security
+remove_plugins:
+  - github.com/mholt/caddy-l4/layer4
+defines:
+  example.com/plugin.B: c
+why: [golang.org/x/net]
+sandbox: null
+`)
+	got, err = ReadManifest(yamlOverlay)
+	if err != nil {
+		t.Fatalf("ReadManifest() of a YAML manifest: error = %v", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ReadManifest() of a YAML manifest =\n%+v\nwant\n%+v", got, want)
+	}
+	if _, err := ReadManifest(write("invalid.yml", "plugins:\n  - module_path: github.com/caddy-dns/cloudflare\n    verison: v0.1.0\n")); err == nil || !strings.Contains(err.Error(), "plugins[0].verison: unknown field") {
+		t.Errorf("ReadManifest() of an invalid YAML manifest: error = %v", err)
+	}
+
+	if _, err := ParseManifest([]byte(`{"extends": "base.json"}`)); err == nil {
+		t.Error("ParseManifest() of a manifest which extends another one: error = nil")
+	}
+}
+
+func TestManifestSchema(t *testing.T) {
+	data, err := ManifestSchema()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var schema struct {
+		Properties map[string]struct {
+			Type  string `json:"type"`
+			Items struct {
+				Required   []string `json:"required"`
+				Properties map[string]struct {
+					Pattern string `json:"pattern"`
+				} `json:"properties"`
+			} `json:"items"`
+		} `json:"properties"`
+		AdditionalProperties bool `json:"additionalProperties"`
+	}
+	if err := json.Unmarshal(data, &schema); err != nil {
+		t.Fatal(err)
+	}
+	if schema.AdditionalProperties {
+		t.Error("schema allows unknown fields")
+	}
+	for _, name := range []string{"os", "cgo", "caddy_version", "timeout_get", "embed_dir"} {
+		if _, ok := schema.Properties[name]; !ok {
+			t.Errorf("schema has no property %s", name)
+		}
+	}
+	for _, name := range []string{"Proxy", "proxy", "environment", "ProgressFunc"} {
+		if _, ok := schema.Properties[name]; ok {
+			t.Errorf("schema has property %s, which is not part of manifests", name)
+		}
+	}
+	plugins := schema.Properties["plugins"]
+	if plugins.Type != "array" || len(plugins.Items.Required) != 1 || plugins.Items.Properties["version"].Pattern != semverPattern {
+		t.Errorf("schema of plugins = %+v, want an array of dependencies", plugins)
+	}
+}
@@ -0,0 +1,217 @@
+// Copyright 2020 Matthew Holt
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package xcaddy
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"net/url"
+	"os"
+	"path/filepath"
+	"slices"
+	"sort"
+	"strings"
+
+	"github.com/caddyserver/xcaddy/internal/utils"
+)
+
+// ModuleProxy is a module proxy, like Athens or Artifactory, from which
+// the modules with a path prefix are downloaded; see Builder.ModuleProxies.
+type ModuleProxy struct {
+	// The module path prefix, like corp.example.com or
+	// github.com/corp, of the modules served by the proxy.
+	Prefix string `json:"prefix,omitempty"`
+
+	// The URL of the proxy, like https://athens.corp.example.com.
+	// Its credentials, if any, are read from the .netrc file.
+	Proxy string `json:"proxy,omitempty"`
+}
+
+func (p ModuleProxy) String() string { return p.Prefix + "=" + p.Proxy }
+
+// Validate returns an error if the prefix or the URL of p can't be
+// used in the GOPROXY and GONOSUMDB of the go command.
+func (p ModuleProxy) Validate() error {
+	if p.Prefix == "" || strings.ContainsAny(p.Prefix, ", |") {
+		return fmt.Errorf("invalid module prefix of module proxy %s", p)
+	}
+	u, err := url.Parse(p.Proxy)
+	if err != nil {
+		return fmt.Errorf("invalid URL of module proxy %s: %v", p, err)
+	}
+	if u.Scheme != "https" && u.Scheme != "http" && u.Scheme != "file" {
+		return fmt.Errorf("invalid URL of module proxy %s: must be https, http or file", p)
+	}
+	if strings.ContainsAny(p.Proxy, ",|") {
+		return fmt.Errorf("invalid URL of module proxy %s: must not contain , or |", p)
+	}
+	if u.User != nil {
+		return fmt.Errorf("invalid URL of module proxy %s: credentials belong in the .netrc file", p)
+	}
+	return nil
+}
+
+// moduleProxyEnv returns the GOPROXY and GONOSUMDB which make the go
+// command use the module proxies, given its current values of them.
+// The go command can't choose a proxy by module path, so the proxies
+// are tried in order before the current ones, falling back to the
+// next one when a proxy doesn't have a module, and their modules are
+// not looked up in the checksum database, which doesn't know them.
+// Since a module of a prefix may then come from a public proxy, and
+// is not checked, checkModuleProxies makes sure none did.
+func moduleProxyEnv(proxies []ModuleProxy, goproxy, gonosumdb string) (string, string) {
+	var list, prefixes []string
+	seen := make(map[string]bool)
+	for _, p := range proxies {
+		if !seen[p.Proxy] {
+			seen[p.Proxy] = true
+			list = append(list, p.Proxy)
+		}
+		prefixes = append(prefixes, p.Prefix)
+	}
+	if goproxy == "" {
+		goproxy = "https://proxy.golang.org,direct"
+	}
+	if gonosumdb != "" {
+		prefixes = append([]string{gonosumdb}, prefixes...)
+	}
+	return strings.Join(append(list, goproxy), ","), strings.Join(prefixes, ",")
+}
+
+// setModuleProxies sets up the GOPROXY and GONOSUMDB of the go commands
+// of the environment for proxies, based on those of the go env, which
+// already takes the sandbox and the toolchain of the environment into
+// account.
+func (env *environment) setModuleProxies(ctx context.Context, proxies []ModuleProxy) error {
+	for _, p := range proxies {
+		err := p.Validate()
+		if err != nil {
+			return err
+		}
+	}
+	cmd := env.newCommand(ctx, utils.GetGo(), "env", "GOPROXY", "GONOSUMDB", "GONOPROXY")
+	cmd.Stdout = nil
+	out, err := cmd.Output()
+	if err != nil {
+		return fmt.Errorf("exec %v: %v", cmd.Args, err)
+	}
+	lines := strings.Split(strings.TrimRight(string(out), "\r\n"), "\n")
+	if len(lines) != 3 {
+		return fmt.Errorf("unexpected output of %v: %s", cmd.Args, out)
+	}
+	noProxy := strings.Split(strings.TrimSpace(lines[2]), ",")
+	for _, p := range proxies {
+		for _, pattern := range noProxy {
+			if pattern == p.Prefix {
+				log.Printf("[WARNING] GONOPROXY, or GOPRIVATE, makes the go command download %s directly instead of from %s", p.Prefix, p.Proxy)
+			}
+		}
+		log.Printf("[INFO] Using module proxy %s for %s", p.Proxy, p.Prefix)
+	}
+	env.goProxy, env.goNoSumDB = moduleProxyEnv(proxies, strings.TrimSpace(lines[0]), strings.TrimSpace(lines[1]))
+	env.moduleProxies = proxies
+	return nil
+}
+
+// checkModuleProxies returns an error unless each module of the build
+// whose path has the prefix of a module proxy is served by one of the
+// proxies of its prefix. The go command falls back to the public
+// proxies for the modules which the private ones don't have, and
+// doesn't check those against the checksum database, so a public
+// module could otherwise take the place of a private one. Nothing
+// has been compiled yet when this is checked.
+func (env environment) checkModuleProxies(ctx context.Context) error {
+	if len(env.moduleProxies) == 0 {
+		return nil
+	}
+	modules, err := env.listModules(ctx)
+	if err != nil {
+		return err
+	}
+	queries := make(map[string][]string)
+	for _, mod := range modules {
+		if mod.Replace != nil {
+			if mod.Replace.Version == "" {
+				// replaced by a local directory
+				continue
+			}
+			mod = *mod.Replace
+		}
+		var proxies []string
+		for _, p := range env.moduleProxies {
+			if withinPath(mod.Path, p.Prefix) && !slices.Contains(proxies, p.Proxy) {
+				proxies = append(proxies, p.Proxy)
+			}
+		}
+		if len(proxies) > 0 {
+			goProxy := strings.Join(proxies, ",")
+			queries[goProxy] = append(queries[goProxy], mod.Path+"@"+mod.Version)
+		}
+	}
+	goProxies := make([]string, 0, len(queries))
+	for goProxy := range queries {
+		goProxies = append(goProxies, goProxy)
+	}
+	sort.Strings(goProxies)
+	for _, goProxy := range goProxies {
+		err = env.queryModuleProxy(ctx, goProxy, queries[goProxy])
+		if err != nil {
+			return &Error{Failure: FailurePolicy, Err: err}
+		}
+	}
+	return nil
+}
+
+// queryModuleProxy returns an error unless the module proxies
+// in goProxy, as in GOPROXY, serve all of modules, given as
+// path@version, which it asks them for with an empty module
+// cache, so that neither the module cache nor other proxies
+// can answer instead.
+func (env environment) queryModuleProxy(ctx context.Context, goProxy string, modules []string) error {
+	dir, err := os.MkdirTemp("", "xcaddy-proxy-check-")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(dir)
+	cmd := env.newCommand(ctx, utils.GetGo(), append([]string{"list", "-m"}, modules...)...)
+	cmd.Dir = dir
+	for _, set := range []string{
+		"GOPROXY=" + goProxy,
+		"GOMODCACHE=" + filepath.Join(dir, "modcache"),
+		"GOFLAGS=-modcacherw",
+		"GOWORK=off",
+		// the query doesn't depend on the toolchain,
+		// which would be downloaded to the empty cache
+		"GOTOOLCHAIN=local",
+	} {
+		cmd.Env = setEnv(cmd.Env, set)
+	}
+	var stderr bytes.Buffer
+	cmd.Stdout = io.Discard
+	cmd.Stderr = &stderr
+	log.Printf("[INFO] Checking that %s serves %d modules of the build", goProxy, len(modules))
+	err = env.runCommand(ctx, cmd)
+	if err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return fmt.Errorf("modules with the prefix of module proxy %s were fetched from another source, like a public proxy, which could be a dependency confusion attack; check them, and add them to the proxy if they are right: %s",
+			goProxy, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
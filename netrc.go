@@ -0,0 +1,44 @@
+// Copyright 2020 Matthew Holt
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package xcaddy
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"runtime"
+)
+
+// netrcFile returns the absolute path of the .netrc file at path
+// (see Builder.Netrc), since the go commands of the build run in
+// the environment folder. It warns if others can read the file.
+func netrcFile(path string) (string, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return "", err
+	}
+	info, err := os.Stat(abs)
+	if err != nil {
+		return "", fmt.Errorf("netrc file: %v", err)
+	}
+	if !info.Mode().IsRegular() {
+		return "", fmt.Errorf("netrc file %s is not a regular file", abs)
+	}
+	if runtime.GOOS != "windows" && info.Mode().Perm()&0o077 != 0 {
+		log.Printf("[WARNING] The netrc file %s can be read by other users; restrict its permissions with chmod 600", abs)
+	}
+	return abs, nil
+}
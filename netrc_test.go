@@ -0,0 +1,73 @@
+// Copyright 2020 Matthew Holt
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package xcaddy
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestNetrcFile(t *testing.T) {
+	dir := t.TempDir()
+	netrc := filepath.Join(dir, "ci.netrc")
+	if err := os.WriteFile(netrc, []byte("machine proxy.example.com login ci password token\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	wd, err := os.Getwd()
+	if err != nil {
+		t.Fatal(err)
+	}
+	rel, err := filepath.Rel(wd, netrc)
+	if err != nil {
+		t.Skipf("no relative path to the netrc file: %v", err)
+	}
+
+	got, err := netrcFile(rel)
+	if err != nil {
+		t.Fatalf("netrcFile() error = %v", err)
+	}
+	if !filepath.IsAbs(got) || filepath.Base(got) != "ci.netrc" {
+		t.Errorf("netrcFile() = %q, want the absolute path of ci.netrc", got)
+	}
+	if _, err := netrcFile(filepath.Join(dir, "missing.netrc")); err == nil {
+		t.Errorf("netrcFile() of a missing file succeeded")
+	}
+	if _, err := netrcFile(dir); err == nil {
+		t.Errorf("netrcFile() of a folder succeeded")
+	}
+}
+
+func TestLinkNetrc(t *testing.T) {
+	home := t.TempDir()
+	netrc := filepath.Join(t.TempDir(), "ci.netrc")
+	if err := os.WriteFile(netrc, []byte("machine proxy.example.com\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	// linking again, like a later build in a prepared environment, must work
+	for i := 0; i < 2; i++ {
+		if err := linkNetrc(home, netrc); err != nil {
+			t.Skipf("symbolic links are not supported: %v", err)
+		}
+	}
+	entries, err := os.ReadDir(home)
+	if err != nil || len(entries) != 1 {
+		t.Fatalf("linkNetrc() left %v in home: %v", entries, err)
+	}
+	data, err := os.ReadFile(filepath.Join(home, entries[0].Name()))
+	if err != nil || string(data) != "machine proxy.example.com\n" {
+		t.Errorf("linkNetrc() link reads %q, %v", data, err)
+	}
+}
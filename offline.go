@@ -0,0 +1,167 @@
+// Copyright 2020 Matthew Holt
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package xcaddy
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"unicode"
+)
+
+// VerifyOffline checks, without using the network, that the module
+// cache of b (see ModCache and Offline) has all modules needed to build
+// Caddy with the plugins of b, like before shipping a copy of the cache
+// to a build host without network access. It returns the modules which
+// are missing, as module@version, so it can be fixed with Prefetch.
+// Modules needed to resolve the versions of the build are reported by
+// the error instead, one at a time, since the go command stops there.
+func (b Builder) VerifyOffline(ctx context.Context) ([]string, error) {
+	ctx, cancel := b.withTimeoutTotal(ctx)
+	defer cancel()
+	b.setDefaults()
+	b.Offline = true
+
+	// the go command reports modules missing from the
+	// cache like any other error, so they are taken from
+	// its errors, which it tries to report all at once
+	var stderr bytes.Buffer
+	b.stderr = &stderr
+	var buildEnv *environment
+	var err error
+	if b.Environment != "" {
+		buildEnv, err = b.openEnvironment(ctx, b.Environment)
+	} else {
+		buildEnv, err = b.prepareEnvironment(ctx, "")
+	}
+	if err != nil {
+		if missing := missingModules(stderr.String()); len(missing) > 0 {
+			return missing, nil
+		}
+		return nil, withFailure(err, FailureVerification)
+	}
+	defer buildEnv.Close()
+
+	// like Prefetch, but without downloading
+	log.Printf("[INFO] Verifying the modules in %s", buildEnv.modCache)
+	cmd := buildEnv.newGoModCommand(ctx, "download", "-json")
+	var out bytes.Buffer
+	cmd.Stdout = &out
+	err = buildEnv.runCommand(ctx, cmd)
+	missing := missingModules(stderr.String() + out.String())
+	if err != nil && len(missing) == 0 {
+		return nil, err
+	}
+	return missing, nil
+}
+
+// missingModules returns the modules which the go command, with
+// a module cache as its module proxy, failed to read in output, as
+// module@version, or only the module path if it has no versions.
+func missingModules(output string) []string {
+	seen := make(map[string]bool)
+	var missing []string
+	for _, m := range missingFileRegexp.FindAllStringSubmatch(output, -1) {
+		mod := unescapeModulePath(m[1])
+		if m[2] != "list" {
+			mod += "@" + unescapeModulePath(strings.TrimSuffix(m[2], path.Ext(m[2])))
+		}
+		if !seen[mod] {
+			seen[mod] = true
+			missing = append(missing, mod)
+		}
+	}
+	sort.Strings(missing)
+	return missing
+}
+
+// unescapeModulePath reverses the escaping of module paths and versions
+// in the module cache, where uppercase letters are written as ! and the
+// lowercase letter, since file systems may be case-insensitive.
+func unescapeModulePath(escaped string) string {
+	var sb strings.Builder
+	upper := false
+	for _, r := range escaped {
+		switch {
+		case r == '!':
+			upper = true
+			continue
+		case upper:
+			r = unicode.ToUpper(r)
+		}
+		upper = false
+		sb.WriteRune(r)
+	}
+	return sb.String()
+}
+
+// missingFileRegexp matches the errors of the go command about files
+// of modules it can't read from the downloads of a module cache, like
+// reading file:///root/go/pkg/mod/cache/download/go.uber.org/zap/@v/v1.27.0.zip:
+// no such file or directory, capturing the escaped module path and the file.
+var missingFileRegexp = regexp.MustCompile(`reading file://\S*?/cache/download/(\S+?)/@v/(list|\S+?\.(?:info|mod|zip)):`)
+
+// moduleCache returns the absolute path of the module cache
+// of b, which is ModCache if set, or else that of the go
+// command. Offline, it must contain downloaded modules.
+func (b Builder) moduleCache(ctx context.Context) (string, error) {
+	modCache := b.ModCache
+	if modCache == "" {
+		var err error
+		modCache, _, err = goCacheDirs(ctx)
+		if err != nil {
+			return "", err
+		}
+	}
+	modCache, err := filepath.Abs(modCache)
+	if err != nil {
+		return "", err
+	}
+	if b.Offline {
+		info, err := os.Stat(moduleDownloads(modCache))
+		if err != nil || !info.IsDir() {
+			return "", fmt.Errorf("%s is not a module cache with downloaded modules", modCache)
+		}
+	}
+	return modCache, nil
+}
+
+// offlineEnviron sets up base, the environment of a go command, so
+// it only uses the modules in modCache, the module cache: its folder
+// of downloads serves as the module proxy, as the go command allows.
+// The checksum database can't be reached, so it is turned off, and
+// nothing checks the modules: they are trusted as the cache has them.
+func offlineEnviron(base []string, modCache string) []string {
+	proxy := filepath.ToSlash(moduleDownloads(modCache))
+	if !strings.HasPrefix(proxy, "/") {
+		proxy = "/" + proxy // like C:/Users on Windows
+	}
+	base = setEnv(base, "GOPROXY=file://"+proxy)
+	base = setEnv(base, "GOSUMDB=off")
+	return base
+}
+
+// moduleDownloads returns the folder in which the
+// module cache modCache keeps the downloaded modules.
+func moduleDownloads(modCache string) string {
+	return filepath.Join(modCache, "cache", "download")
+}
@@ -0,0 +1,77 @@
+// Copyright 2020 Matthew Holt
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package xcaddy
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func Test_missingModules(t *testing.T) {
+	output := `go: github.com/caddyserver/caddy/v2 imports
+	go.uber.org/zap: go.uber.org/zap@v1.27.0: reading file:///root/go/pkg/mod/cache/download/go.uber.org/zap/@v/v1.27.0.zip: no such file or directory
+go: github.com/caddyserver/caddy/v2 imports
+	go.uber.org/zap/zapcore: go.uber.org/zap@v1.27.0: reading file:///root/go/pkg/mod/cache/download/go.uber.org/zap/@v/v1.27.0.zip: no such file or directory
+go: github.com/!burnt!sushi/toml@v1.4.0: reading file:///C:/Users/me/go/pkg/mod/cache/download/github.com/!burnt!sushi/toml/@v/v1.4.0.mod: The system cannot find the file specified.
+go: example.com/nope@latest: reading file:///root/go/pkg/mod/cache/download/example.com/nope/@v/list: no such file or directory
+{
+	"Path": "golang.org/x/time",
+	"Version": "v0.5.0",
+	"Error": "golang.org/x/time@v0.5.0: reading file:///root/go/pkg/mod/cache/download/golang.org/x/time/@v/v0.5.0.info: no such file or directory"
+}
+`
+	want := []string{
+		"example.com/nope",
+		"github.com/BurntSushi/toml@v1.4.0",
+		"go.uber.org/zap@v1.27.0",
+		"golang.org/x/time@v0.5.0",
+	}
+	if got := missingModules(output); !reflect.DeepEqual(got, want) {
+		t.Errorf("missingModules() = %v, want %v", got, want)
+	}
+	if got := missingModules("go: downloading github.com/caddyserver/caddy/v2 v2.8.4\n"); len(got) != 0 {
+		t.Errorf("missingModules() = %v, want none", got)
+	}
+}
+
+func Test_offlineEnviron(t *testing.T) {
+	modCache := filepath.Join(string(filepath.Separator)+"srv", "modcache")
+	got := offlineEnviron([]string{"GOPROXY=https://proxy.golang.org,direct", "HOME=/home/me"}, modCache)
+	want := []string{"GOPROXY=file:///srv/modcache/cache/download", "HOME=/home/me", "GOSUMDB=off"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("offlineEnviron() = %v, want %v", got, want)
+	}
+}
+
+func TestBuilder_moduleCache(t *testing.T) {
+	modCache := t.TempDir()
+	b := Builder{ModCache: modCache, Offline: true}
+	if _, err := b.moduleCache(context.Background()); err == nil {
+		t.Error("moduleCache() of an empty folder succeeded offline, want error")
+	}
+	if err := os.MkdirAll(filepath.Join(modCache, "cache", "download"), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	got, err := b.moduleCache(context.Background())
+	if err != nil {
+		t.Fatalf("moduleCache() error = %v", err)
+	}
+	if got != modCache {
+		t.Errorf("moduleCache() = %s, want %s", got, modCache)
+	}
+}
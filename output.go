@@ -0,0 +1,87 @@
+// Copyright 2020 Matthew Holt
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package xcaddy
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"sort"
+	"strings"
+	"text/template"
+)
+
+// OutputContext is what is available to a template of the name of
+// the output file, like "dist/caddy_{{.CaddyVersion}}_{{.OS}}_{{.Arch}}{{.Ext}}".
+// Parent directories of the output file are created as needed.
+type OutputContext struct {
+	// The version of Caddy which was selected, like v2.8.4;
+	// for branches and commits, this is a pseudo-version.
+	CaddyVersion string
+
+	// The target platform.
+	OS, Arch, ARM string
+
+	// The extension of executables on the target OS,
+	// which is ".exe" for Windows and empty otherwise.
+	Ext string
+
+	// The date of the build in UTC, like 20240131.
+	Date string
+
+	// A short hash of the plugins and their versions, which
+	// tells apart builds of the same Caddy version.
+	PluginsHash string
+}
+
+// isOutputTemplate returns true if outputFile is a template.
+func isOutputTemplate(outputFile string) bool {
+	return strings.Contains(outputFile, "{{")
+}
+
+// expandOutputFile executes the template of the output file name.
+func expandOutputFile(outputTemplate string, octx OutputContext) (string, error) {
+	tpl, err := template.New("output").Option("missingkey=error").Parse(outputTemplate)
+	if err != nil {
+		return "", fmt.Errorf("parsing output file template: %v", err)
+	}
+	var buf bytes.Buffer
+	err = tpl.Execute(&buf, octx)
+	if err != nil {
+		return "", fmt.Errorf("expanding output file template: %v", err)
+	}
+	return buf.String(), nil
+}
+
+// executableExt returns the extension of executables on goos.
+func executableExt(goos string) string {
+	if goos == "windows" {
+		return ".exe"
+	}
+	return ""
+}
+
+// pluginsHash returns a short hash of the plugins,
+// which doesn't depend on the order they were given in.
+func pluginsHash(plugins []Dependency) string {
+	var names []string
+	for _, p := range plugins {
+		names = append(names, p.String())
+	}
+	sort.Strings(names)
+	sum := sha256.Sum256([]byte(strings.Join(names, "\n")))
+	return hex.EncodeToString(sum[:])[:8]
+}
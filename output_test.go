@@ -0,0 +1,64 @@
+// Copyright 2020 Matthew Holt
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package xcaddy
+
+import "testing"
+
+func TestExpandOutputFile(t *testing.T) {
+	octx := OutputContext{
+		CaddyVersion: "v2.8.4",
+		OS:           "windows",
+		Arch:         "amd64",
+		Ext:          executableExt("windows"),
+		Date:         "20240131",
+		PluginsHash:  "0123abcd",
+	}
+	tests := []struct {
+		template string
+		want     string
+		wantErr  bool
+	}{
+		{"dist/caddy_{{.CaddyVersion}}_{{.OS}}_{{.Arch}}{{.Ext}}", "dist/caddy_v2.8.4_windows_amd64.exe", false},
+		{"caddy-{{.Date}}-{{.PluginsHash}}", "caddy-20240131-0123abcd", false},
+		{"caddy-{{.Version}}", "", true},
+		{"caddy-{{.OS", "", true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.template, func(t *testing.T) {
+			got, err := expandOutputFile(tt.template, octx)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("expandOutputFile() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if got != tt.want {
+				t.Errorf("expandOutputFile() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPluginsHash(t *testing.T) {
+	a := Dependency{PackagePath: "github.com/caddy-dns/cloudflare", Version: "v0.1.0"}
+	b := Dependency{PackagePath: "github.com/mholt/caddy-l4"}
+	if pluginsHash([]Dependency{a, b}) != pluginsHash([]Dependency{b, a}) {
+		t.Errorf("pluginsHash() depends on the order of plugins")
+	}
+	a.Version = "v0.2.0"
+	if pluginsHash([]Dependency{a, b}) == pluginsHash([]Dependency{b}) {
+		t.Errorf("pluginsHash() is the same for different plugins")
+	}
+	if got := len(pluginsHash(nil)); got != 8 {
+		t.Errorf("pluginsHash() has length %d, want 8", got)
+	}
+}
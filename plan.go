@@ -0,0 +1,174 @@
+// Copyright 2020 Matthew Holt
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package xcaddy
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"github.com/Masterminds/semver/v3"
+)
+
+// Plan returns a line for Caddy and each plugin of b which says where
+// it is built from, like "github.com/me/plugin: using local path
+// ./plugin, version pin v1.2.3 ignored", followed by a line for each
+// of the other replacements, without building anything.
+//
+// It returns an error if the plugins and replacements contradict each
+// other, so the build wouldn't be what they ask for: if a replacement
+// is meant for a plugin, but for another major version of its module,
+// or only for another version than the one the plugin is pinned to.
+func (b Builder) Plan() ([]string, error) {
+	caddyModulePath, err := caddyModulePathOf(b.CaddyVersion)
+	if err != nil {
+		return nil, err
+	}
+	plugins, err := uniquePlugins(b.Plugins)
+	if err != nil {
+		return nil, err
+	}
+	deps := append([]Dependency{{PackagePath: caddyModulePath, Version: b.CaddyVersion}}, plugins...)
+	used := make(map[int]bool)
+	var plan []string
+	for _, d := range deps {
+		i, err := planReplacement(d, b.Replacements)
+		if err != nil {
+			return nil, err
+		}
+		if i < 0 {
+			if d.Version == "" {
+				plan = append(plan, fmt.Sprintf("%s: latest version", d.PackagePath))
+			} else {
+				plan = append(plan, fmt.Sprintf("%s: version %s", d.PackagePath, d.Version))
+			}
+			continue
+		}
+		used[i] = true
+		r := b.Replacements[i]
+		line := fmt.Sprintf("%s: using module %s", d.PackagePath, r.New.Param())
+		if r.New.isLocal() {
+			line = fmt.Sprintf("%s: using local path %s", d.PackagePath, r.New)
+		}
+		if d.Version != "" {
+			line += fmt.Sprintf(", version pin %s ignored", d.Version)
+		}
+		plan = append(plan, line)
+	}
+	for i, r := range b.Replacements {
+		switch {
+		case used[i]:
+		case r.isPattern():
+			plan = append(plan, fmt.Sprintf("%s: replaced by %s, for each module of the build it matches", r.Old, r.New))
+		default:
+			plan = append(plan, fmt.Sprintf("%s: replaced by %s", r.Old, r.New))
+		}
+	}
+	return plan, nil
+}
+
+// planReplacement returns the index of the replacement among
+// replacements which applies to d, or -1 if there is none, or an
+// error if one is meant for d but doesn't apply (see Builder.Plan).
+// If several apply, the one of the longest module path wins, like
+// the go command uses the module with the longest path for a package.
+func planReplacement(d Dependency, replacements []Replace) (int, error) {
+	found := -1
+	var foundPath string
+	for i, r := range replacements {
+		if r.isPattern() {
+			continue
+		}
+		oldPath, oldVersion, _ := strings.Cut(r.Old.Param(), "@")
+		base, major, explicit := splitMajorVersion(oldPath)
+		if !withinPath(d.PackagePath, base) {
+			continue
+		}
+		// a replacement applies to a single major version
+		// of a module, which has a path of its own
+		depMajor, depExplicit := majorVersionElem(strings.TrimPrefix(d.PackagePath, base))
+		if !depExplicit {
+			depMajor = 1
+			if explicit {
+				// the path of the plugin gets the suffix of its version
+				depMajor = semverMajor(d.Version)
+			}
+		}
+		if depMajor != major {
+			return -1, fmt.Errorf("%s is in major version %d of %s, but the replacement %s => %s is for major version %d, so it wouldn't apply; replace %s instead",
+				d, depMajor, base, r.Old, r.New, major, majorVersionPath(base, depMajor))
+		}
+		if oldVersion != "" && oldVersion != d.Version {
+			if d.Version == "" {
+				return -1, fmt.Errorf("%s is not pinned to a version, but the replacement %s => %s only applies to %s; pin it to %s, or replace any version", d, r.Old, r.New, oldVersion, oldVersion)
+			}
+			return -1, fmt.Errorf("%s is pinned to %s, but the replacement %s => %s only applies to %s; pin it to %s, or replace any version", d.PackagePath, d.Version, r.Old, r.New, oldVersion, oldVersion)
+		}
+		if found < 0 || len(oldPath) > len(foundPath) {
+			found, foundPath = i, oldPath
+		}
+	}
+	return found, nil
+}
+
+// majorVersionElemRegexp matches a major version suffix of a
+// module path at the start of the rest of a package path.
+var majorVersionElemRegexp = regexp.MustCompile(`^/v(\d+)(/|$)`)
+
+// majorVersionElem returns the major version of a module if rest,
+// the part of a package path after the path of the module without a
+// major version suffix, starts with one, which must be 2 or more.
+func majorVersionElem(rest string) (major int, ok bool) {
+	m := majorVersionElemRegexp.FindStringSubmatch(rest)
+	if m == nil {
+		return 0, false
+	}
+	major, err := strconv.Atoi(m[1])
+	if err != nil || major < 2 {
+		return 0, false
+	}
+	return major, true
+}
+
+// splitMajorVersion splits the major version suffix, like /v2, off
+// modulePath, and returns the path without it and the major version,
+// which is 1 without a suffix, and whether there was a suffix.
+func splitMajorVersion(modulePath string) (base string, major int, ok bool) {
+	if i := strings.LastIndex(modulePath, "/"); i > 0 {
+		if major, ok := majorVersionElem(modulePath[i:]); ok {
+			return modulePath[:i], major, true
+		}
+	}
+	return modulePath, 1, false
+}
+
+// majorVersionPath returns the path of the module base at major.
+func majorVersionPath(base string, major int) string {
+	if major < 2 {
+		return base
+	}
+	return fmt.Sprintf("%s/v%d", base, major)
+}
+
+// semverMajor returns the major version of version, or 1 if it
+// is lower, empty, or not a semantic version, like a commit.
+func semverMajor(version string) int {
+	v, err := semver.StrictNewVersion(strings.TrimPrefix(version, "v"))
+	if err != nil || v.Major() < 2 {
+		return 1
+	}
+	return int(v.Major())
+}
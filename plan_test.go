@@ -0,0 +1,160 @@
+// Copyright 2020 Matthew Holt
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package xcaddy
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestBuilderPlan(t *testing.T) {
+	const caddy = "github.com/caddyserver/caddy/v2"
+	tests := []struct {
+		name    string
+		builder Builder
+		want    []string
+		wantErr string
+	}{
+		{
+			name: "versions",
+			builder: Builder{CaddyVersion: "v2.8.4", Plugins: []Dependency{
+				{PackagePath: "github.com/caddy-dns/cloudflare", Version: "v0.1.0"},
+				{PackagePath: "github.com/mholt/caddy-l4"},
+			}},
+			want: []string{
+				caddy + ": version v2.8.4",
+				"github.com/caddy-dns/cloudflare: version v0.1.0",
+				"github.com/mholt/caddy-l4: latest version",
+			},
+		},
+		{
+			name: "replacements",
+			builder: Builder{
+				Plugins: []Dependency{
+					{PackagePath: "github.com/me/plugin", Version: "v1.2.3"},
+					{PackagePath: "github.com/me/monorepo/caddy"},
+					{PackagePath: "github.com/me/other/v3", Version: "v3.0.1"},
+				},
+				Replacements: []Replace{
+					NewReplace("github.com/me/plugin@v1.2.3", "./plugin"),
+					NewReplace("github.com/me/monorepo", "github.com/fork/monorepo@v0.4.0"),
+					NewReplace("github.com/me/other/v3", "/src/other"),
+					NewReplace(caddy, "../caddy"),
+					NewReplace("golang.org/x/net", "golang.org/x/net@v0.30.0"),
+					NewReplace("github.com/acme/*", "../forks/{name}"),
+				},
+			},
+			want: []string{
+				caddy + ": using local path ../caddy",
+				"github.com/me/plugin: using local path ./plugin, version pin v1.2.3 ignored",
+				"github.com/me/monorepo/caddy: using module github.com/fork/monorepo@v0.4.0",
+				"github.com/me/other/v3: using local path /src/other, version pin v3.0.1 ignored",
+				"golang.org/x/net: replaced by golang.org/x/net@v0.30.0",
+				"github.com/acme/*: replaced by ../forks/{name}, for each module of the build it matches",
+			},
+		},
+		{
+			name: "longest module path",
+			builder: Builder{
+				CaddyVersion: "v2.8.4",
+				Plugins:      []Dependency{{PackagePath: "github.com/me/monorepo/caddy"}},
+				Replacements: []Replace{
+					NewReplace("github.com/me/monorepo", "./monorepo"),
+					NewReplace("github.com/me/monorepo/caddy", "./monorepo/caddy"),
+				},
+			},
+			want: []string{
+				caddy + ": version v2.8.4",
+				"github.com/me/monorepo/caddy: using local path ./monorepo/caddy",
+				"github.com/me/monorepo: replaced by ./monorepo",
+			},
+		},
+		{
+			name: "major version suffix given by version",
+			builder: Builder{
+				CaddyVersion: "v2.8.4",
+				Plugins:      []Dependency{{PackagePath: "github.com/me/plugin", Version: "v2.1.0"}},
+				Replacements: []Replace{NewReplace("github.com/me/plugin/v2", "./plugin")},
+			},
+			want: []string{
+				caddy + ": version v2.8.4",
+				"github.com/me/plugin: using local path ./plugin, version pin v2.1.0 ignored",
+			},
+		},
+		{
+			name: "plugin of other major version",
+			builder: Builder{
+				Plugins:      []Dependency{{PackagePath: "github.com/me/plugin/v2/caddy", Version: "v2.1.0"}},
+				Replacements: []Replace{NewReplace("github.com/me/plugin", "./plugin")},
+			},
+			wantErr: "github.com/me/plugin/v2/caddy@v2.1.0 is in major version 2 of github.com/me/plugin, but the replacement github.com/me/plugin => ./plugin is for major version 1, so it wouldn't apply; replace github.com/me/plugin/v2 instead",
+		},
+		{
+			name: "replacement of other major version",
+			builder: Builder{
+				Plugins:      []Dependency{{PackagePath: "github.com/me/plugin", Version: "v1.5.0"}},
+				Replacements: []Replace{NewReplace("github.com/me/plugin/v2", "./plugin")},
+			},
+			wantErr: "is in major version 1 of github.com/me/plugin, but the replacement github.com/me/plugin/v2 => ./plugin is for major version 2",
+		},
+		{
+			name: "replacement of other version",
+			builder: Builder{
+				Plugins:      []Dependency{{PackagePath: "github.com/me/plugin", Version: "v1.5.0"}},
+				Replacements: []Replace{NewReplace("github.com/me/plugin@v1.4.0", "./plugin")},
+			},
+			wantErr: "github.com/me/plugin is pinned to v1.5.0, but the replacement github.com/me/plugin@v1.4.0 => ./plugin only applies to v1.4.0",
+		},
+		{
+			name: "replacement of version of unpinned plugin",
+			builder: Builder{
+				Plugins:      []Dependency{{PackagePath: "github.com/me/plugin"}},
+				Replacements: []Replace{NewReplace("github.com/me/plugin@v1.4.0", "./plugin")},
+			},
+			wantErr: "github.com/me/plugin is not pinned to a version",
+		},
+		{
+			name: "module with common prefix",
+			builder: Builder{
+				CaddyVersion: "v2.8.4",
+				Plugins:      []Dependency{{PackagePath: "github.com/me/plugin-extra", Version: "v1.5.0"}},
+				Replacements: []Replace{NewReplace("github.com/me/plugin@v1.4.0", "./plugin")},
+			},
+			want: []string{
+				caddy + ": version v2.8.4",
+				"github.com/me/plugin-extra: version v1.5.0",
+				"github.com/me/plugin@v1.4.0: replaced by ./plugin",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := tt.builder.Plan()
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("Plan() error = %v, want %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Plan() error = %v", err)
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Plan() =\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(tt.want, "\n"))
+			}
+		})
+	}
+}
@@ -1,8 +1,14 @@
 package xcaddy
 
 import (
+	"context"
 	"encoding/json"
+	"fmt"
+	"os"
 	"os/exec"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/caddyserver/xcaddy/internal/utils"
 )
@@ -29,40 +35,262 @@ type Platform struct {
 	ARM  string `json:"arm,omitempty"`
 }
 
+// SupportedPlatform is a build target supported by the go command.
+// Cgo is whether cgo is supported on it, and FirstClass whether it
+// is a first-class port of Go, for which broken builds are blocking.
+type SupportedPlatform struct {
+	Compile
+	FirstClass bool `json:"first_class,omitempty"`
+}
+
+// PlatformFilter reports whether a platform should be kept
+// in the list returned by SupportedPlatformsContext.
+type PlatformFilter func(SupportedPlatform) bool
+
+// ByOS keeps the platforms of the given operating systems.
+func ByOS(oses ...string) PlatformFilter {
+	return func(p SupportedPlatform) bool {
+		for _, goos := range oses {
+			if p.OS == goos {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// CgoOnly keeps the platforms which support cgo.
+func CgoOnly(p SupportedPlatform) bool {
+	return p.Cgo
+}
+
+// FirstClass keeps the first-class ports of Go.
+func FirstClass(p SupportedPlatform) bool {
+	return p.FirstClass
+}
+
+// ByPlatforms keeps the given platforms; a platform without a
+// version of ARM matches all versions.
+func ByPlatforms(platforms ...Platform) PlatformFilter {
+	return func(p SupportedPlatform) bool {
+		for _, want := range platforms {
+			if p.OS == want.OS && p.Arch == want.Arch && (want.ARM == "" || p.ARM == want.ARM) {
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// The named bundles of platforms, for release matrices
+// which don't list each platform; see ParsePlatforms.
+const (
+	// The platforms most people run Caddy on.
+	PlatformBundleCommon = "common"
+
+	// The first-class ports of Go, as listed by the go command,
+	// for which broken builds are blocking issues of Go.
+	PlatformBundleAllFirstClass = "all-first-class"
+)
+
+// commonPlatforms are the platforms of PlatformBundleCommon.
+var commonPlatforms = []Platform{
+	{OS: "linux", Arch: "amd64"},
+	{OS: "linux", Arch: "arm64"},
+	{OS: "windows", Arch: "amd64"},
+	{OS: "darwin", Arch: "arm64"},
+}
+
+// ParsePlatforms returns the platforms of specs, each of which is
+// either the name of a bundle, like common, or a platform given as
+// os/arch, with the version of ARM as a third element for arm, like
+// linux/arm/7. Each may also be a comma-separated list of them. The
+// platforms are checked against those the go command supports, which
+// the bundles are made of, so they change along with the go command;
+// see SupportedPlatformsContext. Platforms given twice are only returned once.
+func ParsePlatforms(ctx context.Context, specs ...string) ([]Platform, error) {
+	supported, err := SupportedPlatformsContext(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return resolvePlatforms(specs, supported)
+}
+
+// resolvePlatforms does the work of ParsePlatforms,
+// with the platforms the go command supports.
+func resolvePlatforms(specs []string, supported []SupportedPlatform) ([]Platform, error) {
+	var platforms []Platform
+	seen := make(map[Platform]bool)
+	add := func(p Platform) {
+		if !seen[p] {
+			seen[p] = true
+			platforms = append(platforms, p)
+		}
+	}
+	for _, spec := range specs {
+		for _, name := range strings.Split(spec, ",") {
+			name = strings.TrimSpace(name)
+			switch name {
+			case "":
+			case PlatformBundleCommon:
+				for _, p := range commonPlatforms {
+					if !isSupported(p, supported) {
+						return nil, fmt.Errorf("platform %s/%s of bundle %s is not supported by the go command", p.OS, p.Arch, name)
+					}
+					add(p)
+				}
+			case PlatformBundleAllFirstClass:
+				for _, p := range supported {
+					if p.FirstClass {
+						add(p.Platform)
+					}
+				}
+			default:
+				p, err := parsePlatform(name)
+				if err != nil {
+					return nil, err
+				}
+				if !isSupported(p, supported) {
+					return nil, fmt.Errorf("platform %s is not supported by the go command; see go tool dist list", name)
+				}
+				add(p)
+			}
+		}
+	}
+	if len(platforms) == 0 {
+		return nil, fmt.Errorf("no platforms given")
+	}
+	return platforms, nil
+}
+
+// parsePlatform parses a platform given as os/arch or os/arm/version,
+// where the version may be prefixed by v, like linux/arm/v7.
+func parsePlatform(s string) (Platform, error) {
+	parts := strings.Split(s, "/")
+	if len(parts) < 2 || len(parts) > 3 || parts[0] == "" || parts[1] == "" {
+		return Platform{}, fmt.Errorf("platform must be a bundle (%s or %s) or of the form os/arch[/arm]: %s", PlatformBundleCommon, PlatformBundleAllFirstClass, s)
+	}
+	p := Platform{OS: parts[0], Arch: parts[1]}
+	if len(parts) == 3 {
+		if p.Arch != "arm" {
+			return Platform{}, fmt.Errorf("a version of ARM is only valid for arm: %s", s)
+		}
+		p.ARM = strings.TrimPrefix(parts[2], "v")
+	}
+	return p, nil
+}
+
+// isSupported returns true if p is one of supported.
+func isSupported(p Platform, supported []SupportedPlatform) bool {
+	for _, sp := range supported {
+		if ByPlatforms(p)(sp) {
+			return true
+		}
+	}
+	return false
+}
+
 // SupportedPlatforms runs `go tool dist list` to make
 // a list of possible build targets.
 func SupportedPlatforms() ([]Compile, error) {
-	out, err := exec.Command(utils.GetGo(), "tool", "dist", "list", "-json").Output()
+	platforms, err := SupportedPlatformsContext(context.Background())
 	if err != nil {
 		return nil, err
 	}
-	var dists []dist
-	err = json.Unmarshal(out, &dists)
+	compiles := make([]Compile, len(platforms))
+	for i, p := range platforms {
+		compiles[i] = p.Compile
+	}
+	return compiles, nil
+}
+
+// SupportedPlatformsContext runs `go tool dist list`, with the go
+// command set by XCADDY_WHICH_GO if any, to make a list of possible
+// build targets, keeping only those matched by all of the filters. The
+// output of the go command is cached, so calling it again is cheap.
+func SupportedPlatformsContext(ctx context.Context, filters ...PlatformFilter) ([]SupportedPlatform, error) {
+	dists, err := distList(ctx, utils.GetGo())
 	if err != nil {
 		return nil, err
 	}
+	var platforms []SupportedPlatform
+nextPlatform:
+	for _, p := range platformsFromDists(dists) {
+		for _, keep := range filters {
+			if !keep(p) {
+				continue nextPlatform
+			}
+		}
+		platforms = append(platforms, p)
+	}
+	return platforms, nil
+}
+
+// distLists caches the output of `go tool dist list`
+// by the go command it was obtained from.
+var distLists = struct {
+	sync.Mutex
+	byGo map[string][]dist
+}{byGo: make(map[string][]dist)}
+
+// distList returns the platforms listed by `go tool dist list`,
+// running it only if it wasn't run with goCmd before.
+func distList(ctx context.Context, goCmd string) ([]dist, error) {
+	distLists.Lock()
+	defer distLists.Unlock()
+	if dists, ok := distLists.byGo[goCmd]; ok {
+		return dists, nil
+	}
+	dists, err := utils.Cached(ctx, distListCacheKey(goCmd), platformsCacheTTL, func() ([]dist, error) {
+		out, err := exec.CommandContext(ctx, goCmd, "tool", "dist", "list", "-json").Output()
+		if err != nil {
+			return nil, fmt.Errorf("listing platforms with %s: %v", goCmd, err)
+		}
+		var dists []dist
+		err = json.Unmarshal(out, &dists)
+		return dists, err
+	})
+	if err != nil {
+		return nil, err
+	}
+	distLists.byGo[goCmd] = dists
+	return dists, nil
+}
+
+// distListCacheKey returns the key of the platforms listed by goCmd
+// in the cache, which changes when the go command is replaced, like
+// by an update, or GOTOOLCHAIN selects another toolchain.
+func distListCacheKey(goCmd string) string {
+	key := "dist list " + goCmd + " " + os.Getenv("GOTOOLCHAIN")
+	if path, err := exec.LookPath(goCmd); err == nil {
+		if info, err := os.Stat(path); err == nil {
+			key += " " + path + " " + info.ModTime().UTC().Format(time.RFC3339Nano)
+		}
+	}
+	return key
+}
 
-	// translate from the go command's output structure
-	// to our own user-facing structure
-	var compiles []Compile
+// platformsFromDists translates from the go command's output
+// structure to our own user-facing structure.
+func platformsFromDists(dists []dist) []SupportedPlatform {
+	var platforms []SupportedPlatform
 	for _, d := range dists {
-		comp := d.toCompile()
+		p := d.toSupportedPlatform()
 		if d.GOARCH == "arm" {
 			if d.GOOS == "linux" {
 				// only linux supports ARMv5; see https://github.com/golang/go/issues/18418
-				comp.ARM = "5"
-				compiles = append(compiles, comp)
+				p.ARM = "5"
+				platforms = append(platforms, p)
 			}
-			comp.ARM = "6"
-			compiles = append(compiles, comp)
-			comp.ARM = "7"
-			compiles = append(compiles, comp)
+			p.ARM = "6"
+			platforms = append(platforms, p)
+			p.ARM = "7"
+			platforms = append(platforms, p)
 		} else {
-			compiles = append(compiles, comp)
+			platforms = append(platforms, p)
 		}
 	}
-
-	return compiles, nil
+	return platforms
 }
 
 // dist is the structure that fits the output
@@ -71,14 +299,18 @@ type dist struct {
 	GOOS         string `json:"GOOS"`
 	GOARCH       string `json:"GOARCH"`
 	CgoSupported bool   `json:"CgoSupported"`
+	FirstClass   bool   `json:"FirstClass"`
 }
 
-func (d dist) toCompile() Compile {
-	return Compile{
-		Platform: Platform{
-			OS:   d.GOOS,
-			Arch: d.GOARCH,
+func (d dist) toSupportedPlatform() SupportedPlatform {
+	return SupportedPlatform{
+		Compile: Compile{
+			Platform: Platform{
+				OS:   d.GOOS,
+				Arch: d.GOARCH,
+			},
+			Cgo: d.CgoSupported,
 		},
-		Cgo: d.CgoSupported,
+		FirstClass: d.FirstClass,
 	}
 }
@@ -0,0 +1,148 @@
+// Copyright 2020 Matthew Holt
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package xcaddy
+
+import (
+	"context"
+	"reflect"
+	"testing"
+)
+
+func TestPlatformsFromDists(t *testing.T) {
+	dists := []dist{
+		{GOOS: "linux", GOARCH: "amd64", CgoSupported: true, FirstClass: true},
+		{GOOS: "linux", GOARCH: "arm", CgoSupported: true, FirstClass: true},
+		{GOOS: "freebsd", GOARCH: "arm", CgoSupported: true},
+		{GOOS: "js", GOARCH: "wasm"},
+	}
+	var got []string
+	for _, p := range platformsFromDists(dists) {
+		got = append(got, p.OS+"/"+p.Arch+p.ARM)
+	}
+	want := []string{
+		"linux/amd64",
+		"linux/arm5", "linux/arm6", "linux/arm7",
+		"freebsd/arm6", "freebsd/arm7",
+		"js/wasm",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("platformsFromDists() = %v, want %v", got, want)
+	}
+}
+
+func TestSupportedPlatformsContext(t *testing.T) {
+	ctx := WithoutCache(context.Background())
+	all, err := SupportedPlatformsContext(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	firstClassLinux, err := SupportedPlatformsContext(ctx, ByOS("linux"), FirstClass, CgoOnly)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(firstClassLinux) == 0 || len(firstClassLinux) >= len(all) {
+		t.Fatalf("got %d first-class linux platforms with cgo out of %d", len(firstClassLinux), len(all))
+	}
+	for _, p := range firstClassLinux {
+		if p.OS != "linux" || !p.FirstClass || !p.Cgo {
+			t.Errorf("SupportedPlatformsContext() returned %+v, which doesn't match the filters", p)
+		}
+	}
+}
+
+func TestSupportedPlatforms(t *testing.T) {
+	// listed first, so SupportedPlatforms finds
+	// them in memory, instead of the user's cache
+	platforms, err := SupportedPlatformsContext(WithoutCache(context.Background()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	compiles, err := SupportedPlatforms()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(compiles) == 0 || len(compiles) != len(platforms) {
+		t.Fatalf("SupportedPlatforms() = %d platforms, want the %d of SupportedPlatformsContext()", len(compiles), len(platforms))
+	}
+	for i, c := range compiles {
+		if c != platforms[i].Compile {
+			t.Errorf("SupportedPlatforms()[%d] = %+v, want %+v", i, c, platforms[i].Compile)
+		}
+	}
+}
+
+func TestResolvePlatforms(t *testing.T) {
+	supported := platformsFromDists([]dist{
+		{GOOS: "darwin", GOARCH: "arm64", FirstClass: true},
+		{GOOS: "linux", GOARCH: "amd64", FirstClass: true},
+		{GOOS: "linux", GOARCH: "arm", FirstClass: true},
+		{GOOS: "linux", GOARCH: "arm64", FirstClass: true},
+		{GOOS: "linux", GOARCH: "riscv64"},
+		{GOOS: "windows", GOARCH: "amd64", FirstClass: true},
+	})
+	tests := []struct {
+		specs   []string
+		want    []string
+		wantErr bool
+	}{
+		{
+			specs: []string{"common"},
+			want:  []string{"linux/amd64", "linux/arm64", "windows/amd64", "darwin/arm64"},
+		},
+		{
+			specs: []string{"all-first-class"},
+			want:  []string{"darwin/arm64", "linux/amd64", "linux/arm5", "linux/arm6", "linux/arm7", "linux/arm64", "windows/amd64"},
+		},
+		{
+			specs: []string{"linux/riscv64,linux/arm/v7", "common", "linux/amd64"},
+			want:  []string{"linux/riscv64", "linux/arm7", "linux/amd64", "linux/arm64", "windows/amd64", "darwin/arm64"},
+		},
+		{specs: []string{"linux/arm"}, want: []string{"linux/arm"}},
+		{specs: []string{"plan9/amd64"}, wantErr: true},
+		{specs: []string{"linux/amd64/7"}, wantErr: true},
+		{specs: []string{"linux"}, wantErr: true},
+		{specs: []string{"uncommon"}, wantErr: true},
+		{specs: []string{""}, wantErr: true},
+	}
+	for _, tt := range tests {
+		platforms, err := resolvePlatforms(tt.specs, supported)
+		if (err != nil) != tt.wantErr {
+			t.Errorf("resolvePlatforms(%q) error = %v, wantErr %v", tt.specs, err, tt.wantErr)
+			continue
+		}
+		var got []string
+		for _, p := range platforms {
+			got = append(got, p.OS+"/"+p.Arch+p.ARM)
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("resolvePlatforms(%q) = %v, want %v", tt.specs, got, tt.want)
+		}
+	}
+
+	// the bundles are made of the platforms the go command supports
+	if _, err := resolvePlatforms([]string{"common"}, supported[1:]); err == nil {
+		t.Error("expected an error for a platform of a bundle which isn't supported")
+	}
+}
+
+func TestParsePlatforms(t *testing.T) {
+	platforms, err := ParsePlatforms(WithoutCache(context.Background()), "common")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(platforms, commonPlatforms) {
+		t.Errorf("ParsePlatforms(common) = %v, want %v", platforms, commonPlatforms)
+	}
+}
@@ -0,0 +1,208 @@
+// Copyright 2020 Matthew Holt
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package xcaddy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io/fs"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+
+	"github.com/caddyserver/xcaddy/internal/utils"
+)
+
+// The free space a build needs at least with empty caches, in bytes.
+// They are measured by building Caddy with a few plugins, like
+// github.com/caddy-dns/cloudflare, with GOMODCACHE and GOCACHE set to
+// new folders and --skip-cleanup, then taking du -s of those folders
+// and of the environment folder, and rounding up to the next power of
+// two for the headroom of heavier plugins. The environment folder holds
+// the main module, the embedded files and the Caddy clone, if any,
+// while the module and build caches of the go command take most of
+// the space.
+const (
+	preflightEnvironmentSpace = 128 << 20
+	preflightModCacheSpace    = 512 << 20
+	preflightBuildCacheSpace  = 512 << 20
+)
+
+// preflightWarmModCacheSpace is the free space a module cache which
+// already holds Caddy needs at least, since most of the modules of a
+// build are those Caddy requires; it is the headroom for the modules
+// of plugins, with the same rounding as above.
+const preflightWarmModCacheSpace = 128 << 20
+
+// windowsMaxPath is the length limit of paths on Windows,
+// unless long paths are enabled; see longPathsEnabled.
+const windowsMaxPath = 260
+
+// errFreeSpaceUnknown is returned by freeSpace on
+// systems where it can't be determined.
+var errFreeSpaceUnknown = errors.New("free space can't be determined on this system")
+
+// preflight checks, before the environment is prepared in folder
+// (a new temporary folder if empty), that there is enough free space
+// for the build, and on Windows, that its paths don't get too long;
+// so the build fails early with clear guidance, instead of in the
+// middle of it with an obscure error from the go command.
+func (b Builder) preflight(ctx context.Context, folder string) error {
+	if b.SkipPreflight {
+		return nil
+	}
+	if folder == "" {
+		parent, err := tempParentDir()
+		if err != nil {
+			return err
+		}
+		// as long as the name newTempFolder makes where it matters
+		folder = filepath.Join(parent, "xc1234567890")
+	}
+	checks := []spaceCheck{
+		{"build environment", filepath.Dir(folder), preflightEnvironmentSpace, "set TMPDIR (TMP on Windows) to a folder on another disk"},
+	}
+	modCache, buildCache, err := goCacheDirs(ctx)
+	if b.ModCache != "" {
+		modCache = b.ModCache
+	}
+	if err != nil {
+		log.Printf("[WARNING] Skipping the free space check of the Go caches: %v", err)
+	} else {
+		modCacheSpace := int64(preflightModCacheSpace)
+		if modCacheHasCaddy(modCache) {
+			modCacheSpace = preflightWarmModCacheSpace
+		}
+		checks = append(checks,
+			spaceCheck{"Go module cache", modCache, modCacheSpace, "set GOMODCACHE to a folder on another disk, or clean it with go clean -modcache"},
+			spaceCheck{"Go build cache", buildCache, preflightBuildCacheSpace, "set GOCACHE to a folder on another disk, or clean it with go clean -cache"},
+		)
+	}
+	for _, c := range checks {
+		err := checkFreeSpace(c.dir, c.need)
+		if errors.Is(err, errFreeSpaceUnknown) {
+			break
+		}
+		if err != nil {
+			return fmt.Errorf("not enough free space for the %s: %v; free up space, or %s (set XCADDY_SKIP_PREFLIGHT=1 or Builder.SkipPreflight to skip this check)", c.name, err, c.hint)
+		}
+	}
+
+	if runtime.GOOS == "windows" && !longPathsEnabled() {
+		return checkEmbedPathLengths(folder, b.EmbedDirs)
+	}
+	return nil
+}
+
+// modCacheHasCaddy reports whether the module cache in
+// modCache already holds a version of Caddy.
+func modCacheHasCaddy(modCache string) bool {
+	dir := filepath.Join(modCache, "cache", "download", filepath.FromSlash(defaultCaddyModulePath+"/v2"), "@v")
+	matches, _ := filepath.Glob(filepath.Join(dir, "*.zip"))
+	return len(matches) > 0
+}
+
+// spaceCheck is a folder which needs free space for the build,
+// with a hint on how to make space if there isn't enough.
+type spaceCheck struct {
+	name string
+	dir  string
+	need int64
+	hint string
+}
+
+// checkFreeSpace returns an error if the file system
+// of dir, or its closest existing parent, has less
+// than need bytes of free space.
+func checkFreeSpace(dir string, need int64) error {
+	dir = existingParent(dir)
+	free, err := freeSpace(dir)
+	if err != nil {
+		return err
+	}
+	if free < need {
+		return fmt.Errorf("%s has %s free, but at least %s are needed", dir, formatMiB(free), formatMiB(need))
+	}
+	return nil
+}
+
+// existingParent returns dir, if it exists, or else its
+// closest parent which exists; caches may not exist yet.
+func existingParent(dir string) string {
+	for {
+		if _, err := os.Stat(dir); err == nil {
+			return dir
+		}
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return dir
+		}
+		dir = parent
+	}
+}
+
+// goCacheDirs returns the module and build cache folders of the go command.
+func goCacheDirs(ctx context.Context) (modCache, buildCache string, err error) {
+	cmd := exec.CommandContext(ctx, utils.GetGo(), "env", "GOMODCACHE", "GOCACHE")
+	// the go.mod of the current folder doesn't matter,
+	// and no toolchain must be downloaded just for this
+	cmd.Dir = os.TempDir()
+	cmd.Env = setEnv(os.Environ(), "GOTOOLCHAIN=local")
+	out, err := cmd.Output()
+	if err != nil {
+		return "", "", fmt.Errorf("exec %v: %v", cmd.Args, err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(out)), "\n")
+	if len(lines) != 2 {
+		return "", "", fmt.Errorf("unexpected output of %v: %s", cmd.Args, out)
+	}
+	return strings.TrimSpace(lines[0]), strings.TrimSpace(lines[1]), nil
+}
+
+// checkEmbedPathLengths returns an error if the copies of the embedded
+// files in the environment folder would have paths longer than Windows
+// supports without long paths, since tools like the C compiler used
+// by cgo don't support them then.
+func checkEmbedPathLengths(folder string, embedDirs []struct {
+	Dir  string `json:"dir,omitempty"`
+	Name string `json:"name,omitempty"`
+},
+) error {
+	for _, d := range embedDirs {
+		target := filepath.Join(folder, "files", d.Name)
+		err := filepath.WalkDir(d.Dir, func(path string, _ fs.DirEntry, err error) error {
+			if err != nil {
+				// reported when the directory is embedded
+				return filepath.SkipDir
+			}
+			rel, err := filepath.Rel(d.Dir, path)
+			if err != nil {
+				return nil
+			}
+			if copied := filepath.Join(target, rel); len(copied) >= windowsMaxPath {
+				return fmt.Errorf("the copy of embedded file %s would have a path longer than %d characters: %s; enable long paths in Windows, or set TMP to a shorter folder like C:\\tmp", path, windowsMaxPath-1, copied)
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
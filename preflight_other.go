@@ -0,0 +1,28 @@
+// Copyright 2020 Matthew Holt
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !linux && !darwin && !freebsd && !windows
+
+package xcaddy
+
+// freeSpace can't determine the free space on this system.
+func freeSpace(dir string) (int64, error) {
+	return 0, errFreeSpaceUnknown
+}
+
+// longPathsEnabled reports whether paths may be longer
+// than windowsMaxPath, which they always may here.
+func longPathsEnabled() bool {
+	return true
+}
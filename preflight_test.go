@@ -0,0 +1,86 @@
+// Copyright 2020 Matthew Holt
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package xcaddy
+
+import (
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestCheckFreeSpace(t *testing.T) {
+	dir := t.TempDir()
+	err := checkFreeSpace(filepath.Join(dir, "missing", "cache"), 1)
+	if errors.Is(err, errFreeSpaceUnknown) {
+		t.Skip(err)
+	}
+	if err != nil {
+		t.Errorf("checkFreeSpace() error = %v, want none", err)
+	}
+	if err := checkFreeSpace(dir, 1<<62); err == nil {
+		t.Errorf("checkFreeSpace() found 4 EiB free")
+	}
+}
+
+func TestExistingParent(t *testing.T) {
+	dir := t.TempDir()
+	if got := existingParent(filepath.Join(dir, "a", "b")); got != dir {
+		t.Errorf("existingParent() = %s, want %s", got, dir)
+	}
+	if got := existingParent(dir); got != dir {
+		t.Errorf("existingParent() = %s, want %s", got, dir)
+	}
+}
+
+func TestModCacheHasCaddy(t *testing.T) {
+	modCache := t.TempDir()
+	if modCacheHasCaddy(modCache) {
+		t.Error("modCacheHasCaddy() of an empty module cache = true")
+	}
+	dir := filepath.Join(modCache, "cache", "download", "github.com", "caddyserver", "caddy", "v2", "@v")
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "v2.8.4.zip"), nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if !modCacheHasCaddy(modCache) {
+		t.Error("modCacheHasCaddy() of a module cache with Caddy = false")
+	}
+}
+
+func TestCheckEmbedPathLengths(t *testing.T) {
+	site := t.TempDir()
+	long := strings.Repeat("a", 100)
+	if err := os.MkdirAll(filepath.Join(site, long), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(site, long, long), nil, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	embedDirs := []struct {
+		Dir  string `json:"dir,omitempty"`
+		Name string `json:"name,omitempty"`
+	}{{Dir: site, Name: "site"}}
+
+	if err := checkEmbedPathLengths(`C:\tmp\xc1234567890`, embedDirs); err != nil {
+		t.Errorf("checkEmbedPathLengths() error = %v, want none", err)
+	}
+	if err := checkEmbedPathLengths(`C:\Users\someone\AppData\Local\Temp\xc1234567890`, embedDirs); err == nil {
+		t.Errorf("checkEmbedPathLengths() found no path too long")
+	}
+}
@@ -0,0 +1,54 @@
+// Copyright 2020 Matthew Holt
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package xcaddy
+
+import (
+	"syscall"
+	"unsafe"
+)
+
+var procGetDiskFreeSpaceExW = kernel32.NewProc("GetDiskFreeSpaceExW")
+
+// freeSpace returns the space available to
+// the current user in the file system of dir.
+func freeSpace(dir string) (int64, error) {
+	path, err := syscall.UTF16PtrFromString(dir)
+	if err != nil {
+		return 0, err
+	}
+	var available uint64
+	r, _, err := procGetDiskFreeSpaceExW.Call(uintptr(unsafe.Pointer(path)), uintptr(unsafe.Pointer(&available)), 0, 0)
+	if r == 0 {
+		return 0, err
+	}
+	return int64(available), nil
+}
+
+// longPathsEnabled reports whether paths may be longer than
+// windowsMaxPath, which is only the case if long paths are
+// enabled in the registry (Windows 10, version 1607 or newer).
+func longPathsEnabled() bool {
+	var key syscall.Handle
+	name, _ := syscall.UTF16PtrFromString(`SYSTEM\CurrentControlSet\Control\FileSystem`)
+	if syscall.RegOpenKeyEx(syscall.HKEY_LOCAL_MACHINE, name, 0, syscall.KEY_READ, &key) != nil {
+		return false
+	}
+	defer syscall.RegCloseKey(key)
+	var value, valueType uint32
+	size := uint32(unsafe.Sizeof(value))
+	valueName, _ := syscall.UTF16PtrFromString("LongPathsEnabled")
+	err := syscall.RegQueryValueEx(key, valueName, nil, &valueType, (*byte)(unsafe.Pointer(&value)), &size)
+	return err == nil && valueType == syscall.REG_DWORD && value == 1
+}
@@ -0,0 +1,34 @@
+// Copyright 2020 Matthew Holt
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+//go:build !windows
+
+package xcaddy
+
+import (
+	"os/exec"
+	"syscall"
+)
+
+// startProcessGroup starts cmd in a process group of its own, which
+// is killed as a whole when the context of cmd is done, so the compiler
+// and linker processes of the go command don't outlive it. The returned
+// function releases the process group once cmd is done.
+func startProcessGroup(cmd *exec.Cmd) (release func(), err error) {
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error {
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
+	return func() {}, cmd.Start()
+}
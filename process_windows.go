@@ -0,0 +1,126 @@
+// Copyright 2020 Matthew Holt
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package xcaddy
+
+import (
+	"log"
+	"os/exec"
+	"syscall"
+	"unsafe"
+)
+
+var (
+	kernel32                     = syscall.NewLazyDLL("kernel32.dll")
+	procCreateJobObjectW         = kernel32.NewProc("CreateJobObjectW")
+	procSetInformationJobObject  = kernel32.NewProc("SetInformationJobObject")
+	procAssignProcessToJobObject = kernel32.NewProc("AssignProcessToJobObject")
+	procTerminateJobObject       = kernel32.NewProc("TerminateJobObject")
+)
+
+const (
+	jobObjectExtendedLimitInformationClass = 9
+	jobObjectLimitKillOnJobClose           = 0x2000
+	processSetQuota                        = 0x0100
+)
+
+// jobObjectExtendedLimitInformation is JOBOBJECT_EXTENDED_LIMIT_INFORMATION.
+type jobObjectExtendedLimitInformation struct {
+	BasicLimitInformation struct {
+		PerProcessUserTimeLimit int64
+		PerJobUserTimeLimit     int64
+		LimitFlags              uint32
+		MinimumWorkingSetSize   uintptr
+		MaximumWorkingSetSize   uintptr
+		ActiveProcessLimit      uint32
+		Affinity                uintptr
+		PriorityClass           uint32
+		SchedulingClass         uint32
+	}
+	IoInfo struct {
+		ReadOperationCount  uint64
+		WriteOperationCount uint64
+		OtherOperationCount uint64
+		ReadTransferCount   uint64
+		WriteTransferCount  uint64
+		OtherTransferCount  uint64
+	}
+	ProcessMemoryLimit    uintptr
+	JobMemoryLimit        uintptr
+	PeakProcessMemoryUsed uintptr
+	PeakJobMemoryUsed     uintptr
+}
+
+// startProcessGroup starts cmd in a job object of its own, which is
+// terminated as a whole when the context of cmd is done, so the compiler
+// and linker processes of the go command don't outlive it. They are also
+// terminated if xcaddy itself dies, since the job is set to kill its
+// processes when its last handle is closed. The returned function closes
+// the job once cmd is done. If the job can't be created, cmd is started
+// as usual.
+func startProcessGroup(cmd *exec.Cmd) (release func(), err error) {
+	job, err := newKillOnCloseJob()
+	if err != nil {
+		log.Printf("[WARNING] Unable to create a job object for %s: %v", cmd.Path, err)
+		return func() {}, cmd.Start()
+	}
+	release = func() { _ = syscall.CloseHandle(job) }
+	cmd.Cancel = func() error {
+		// the process may not be assigned to the job yet
+		_, _, _ = procTerminateJobObject.Call(uintptr(job), 1)
+		return cmd.Process.Kill()
+	}
+	err = cmd.Start()
+	if err != nil {
+		release()
+		return nil, err
+	}
+	// processes started by cmd before this are not part of the job;
+	// the go command doesn't start any that early
+	process, err := syscall.OpenProcess(processSetQuota|syscall.PROCESS_TERMINATE, false, uint32(cmd.Process.Pid))
+	if err == nil {
+		r, _, callErr := procAssignProcessToJobObject.Call(uintptr(job), uintptr(process))
+		if r == 0 {
+			err = callErr
+		}
+		_ = syscall.CloseHandle(process)
+	}
+	if err != nil {
+		log.Printf("[WARNING] Unable to assign %s to a job object: %v", cmd.Path, err)
+	}
+	return release, nil
+}
+
+// newKillOnCloseJob creates a job object whose
+// processes are killed when it is closed.
+func newKillOnCloseJob() (syscall.Handle, error) {
+	r, _, err := procCreateJobObjectW.Call(0, 0)
+	if r == 0 {
+		return 0, err
+	}
+	job := syscall.Handle(r)
+	var info jobObjectExtendedLimitInformation
+	info.BasicLimitInformation.LimitFlags = jobObjectLimitKillOnJobClose
+	r, _, err = procSetInformationJobObject.Call(
+		uintptr(job),
+		jobObjectExtendedLimitInformationClass,
+		uintptr(unsafe.Pointer(&info)),
+		unsafe.Sizeof(info),
+	)
+	if r == 0 {
+		_ = syscall.CloseHandle(job)
+		return 0, err
+	}
+	return job, nil
+}
@@ -0,0 +1,136 @@
+// Copyright 2020 Matthew Holt
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package xcaddy
+
+import (
+	"io"
+	"time"
+)
+
+// Event is something which happened during a build, reported to
+// Builder.ProgressFunc: a PhaseStarted, CommandExec,
+// CommandOutputChunk or PhaseCompleted.
+type Event interface {
+	isEvent()
+}
+
+// Phase is a phase of a build.
+type Phase string
+
+// The phases of a build, in order. Builds from a prepared
+// environment skip most of the work of PhasePrepare.
+const (
+	// Creating the Go module of the build and resolving
+	// the versions of Caddy and the plugins.
+	PhasePrepare Phase = "prepare"
+
+	// Tidying the Go module and listing the packages of the build.
+	PhaseTidy Phase = "tidy"
+
+	// Compiling and linking the binary.
+	PhaseCompile Phase = "compile"
+)
+
+// PhaseStarted is reported when a phase of a build starts.
+type PhaseStarted struct {
+	Phase Phase
+	Time  time.Time
+}
+
+// PhaseCompleted is reported when a phase of a build ends,
+// successfully unless Err is set.
+type PhaseCompleted struct {
+	Phase    Phase
+	Duration time.Duration
+	Err      error
+}
+
+// CommandExec is reported when a command, like go get,
+// is run for a build.
+type CommandExec struct {
+	// The command and its arguments.
+	Args []string
+
+	// The folder the command runs in.
+	Dir string
+}
+
+// CommandOutputChunk is output written by the command
+// which was reported last with the same Args.
+type CommandOutputChunk struct {
+	Args []string
+
+	// "stdout" or "stderr".
+	Stream string
+
+	Data []byte
+}
+
+func (PhaseStarted) isEvent()       {}
+func (PhaseCompleted) isEvent()     {}
+func (CommandExec) isEvent()        {}
+func (CommandOutputChunk) isEvent() {}
+
+// phases keeps track of the phases of a build, one after
+// another, and reports them to report, if set.
+type phases struct {
+	report  func(Event)
+	current Phase
+	started time.Time
+}
+
+// start completes the current phase, if any, and starts phase.
+func (p *phases) start(phase Phase) {
+	p.end(nil)
+	p.current = phase
+	p.started = time.Now()
+	if p.report != nil {
+		p.report(PhaseStarted{Phase: phase, Time: p.started})
+	}
+}
+
+// end completes the current phase, if any, with err.
+func (p *phases) end(err error) {
+	if p.current == "" {
+		return
+	}
+	if p.report != nil {
+		p.report(PhaseCompleted{Phase: p.current, Duration: time.Since(p.started), Err: err})
+	}
+	p.current = ""
+}
+
+// outputChunks reports what is written to it
+// as output of the command with args.
+type outputChunks struct {
+	report func(Event)
+	args   []string
+	stream string
+}
+
+func (w outputChunks) Write(p []byte) (int, error) {
+	w.report(CommandOutputChunk{Args: w.args, Stream: w.stream, Data: append([]byte(nil), p...)})
+	return len(p), nil
+}
+
+// withOutputChunks returns w, which may be nil, extended
+// to report what is written to it to report.
+func withOutputChunks(w io.Writer, report func(Event), args []string, stream string) io.Writer {
+	chunks := outputChunks{report: report, args: args, stream: stream}
+	if w == nil {
+		return chunks
+	}
+	return io.MultiWriter(w, chunks)
+}
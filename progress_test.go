@@ -0,0 +1,91 @@
+// Copyright 2020 Matthew Holt
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package xcaddy
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"os/exec"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/caddyserver/xcaddy/internal/utils"
+)
+
+func TestPhases(t *testing.T) {
+	var events []Event
+	p := &phases{report: func(e Event) { events = append(events, e) }}
+	p.start(PhasePrepare)
+	p.start(PhaseTidy)
+	p.end(errors.New("tidy failed"))
+	p.end(nil)
+
+	var got []string
+	for _, e := range events {
+		switch e := e.(type) {
+		case PhaseStarted:
+			got = append(got, "started "+string(e.Phase))
+		case PhaseCompleted:
+			s := "completed " + string(e.Phase)
+			if e.Err != nil {
+				s += ": " + e.Err.Error()
+			}
+			got = append(got, s)
+		}
+	}
+	want := []string{"started prepare", "completed prepare", "started tidy", "completed tidy: tidy failed"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("events = %q, want %q", got, want)
+	}
+
+	// without a report function, nothing happens
+	p = &phases{}
+	p.start(PhaseCompile)
+	p.end(nil)
+}
+
+func TestRunCommandProgress(t *testing.T) {
+	var events []Event
+	env := environment{tempFolder: t.TempDir(), progress: func(e Event) { events = append(events, e) }}
+	var stdout bytes.Buffer
+	cmd := exec.CommandContext(context.Background(), utils.GetGo(), "env", "GOVERSION")
+	cmd.Dir = env.tempFolder
+	cmd.Stdout = &stdout
+	if err := env.runCommand(context.Background(), cmd); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(events) == 0 {
+		t.Fatal("no events were reported")
+	}
+	first, ok := events[0].(CommandExec)
+	if !ok || !reflect.DeepEqual(first.Args, cmd.Args) || first.Dir != env.tempFolder {
+		t.Errorf("first event = %#v, want the command", events[0])
+	}
+	var output string
+	for _, e := range events[1:] {
+		chunk, ok := e.(CommandOutputChunk)
+		if !ok || chunk.Stream != "stdout" {
+			t.Errorf("event = %#v, want output on stdout", e)
+			continue
+		}
+		output += string(chunk.Data)
+	}
+	if !strings.HasPrefix(output, "go") || output != stdout.String() {
+		t.Errorf("reported output %q, want %q", output, stdout.String())
+	}
+}
@@ -0,0 +1,208 @@
+// Copyright 2020 Matthew Holt
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package xcaddy
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"text/template"
+)
+
+// provenanceMarker precedes the compressed go.mod and go.sum
+// embedded into a binary, so they can be found again.
+const provenanceMarker = "\x00xcaddy-provenance-v1\x00"
+
+// invocationMarker precedes the Invocation recorded in a binary.
+const invocationMarker = "\x00xcaddy-invocation-v1\x00"
+
+const (
+	provenanceGoModHeader = "-- go.mod --\n"
+	provenanceGoSumHeader = "-- go.sum --\n"
+)
+
+// writeProvenance writes the go.mod and go.sum of the build
+// environment, compressed, to a file along with a Go source
+// file which embeds it into the binary.
+func (env environment) writeProvenance() error {
+	goMod, err := os.ReadFile(filepath.Join(env.tempFolder, "go.mod"))
+	if err != nil {
+		return err
+	}
+	goSum, err := os.ReadFile(filepath.Join(env.tempFolder, "go.sum"))
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString(provenanceMarker)
+	zw := gzip.NewWriter(&buf)
+	for _, b := range [][]byte{[]byte(provenanceGoModHeader), goMod, []byte(provenanceGoSumHeader), goSum} {
+		if _, err := zw.Write(b); err != nil {
+			return err
+		}
+	}
+	if err := zw.Close(); err != nil {
+		return err
+	}
+
+	log.Printf("[INFO] Embedding go.mod and go.sum into the binary")
+	return env.writeEmbeddedData("provenance", "xcaddyProvenance", buf.Bytes())
+}
+
+// Invocation describes how xcaddy was invoked to produce a binary.
+type Invocation struct {
+	// The version of xcaddy.
+	XcaddyVersion string `json:"xcaddy_version,omitempty"`
+
+	// The command line arguments, without the program name. They
+	// are recorded as they are, so they mustn't contain credentials;
+	// xcaddy removes them from URLs and leaves out the values of
+	// its flags which point to them, like --netrc.
+	Args []string `json:"args,omitempty"`
+
+	// These are filled in by the Builder.
+	CaddyVersion string       `json:"caddy_version,omitempty"`
+	Plugins      []Dependency `json:"plugins,omitempty"`
+	Warnings     []string     `json:"warnings,omitempty"`
+
+	// The packages which register Caddy modules, but were
+	// not requested, since requested plugins import them.
+	TransitivePlugins []string `json:"transitive_plugins,omitempty"`
+
+	// The patterns of the modules which were fetched
+	// without verifying TLS, as in GOINSECURE.
+	InsecureModules []string `json:"insecure_modules,omitempty"`
+}
+
+// writeInvocation writes inv as JSON to a file along with a
+// Go source file which embeds it into the binary. The JSON is
+// not compressed, so it can be found with tools like strings(1).
+func (env environment) writeInvocation(inv Invocation) error {
+	inv.CaddyVersion = env.caddyVersion
+	inv.Plugins = env.plugins
+	inv.Warnings = env.warnings
+	inv.TransitivePlugins = env.transitivePlugins
+	inv.InsecureModules = env.insecureModules
+	data, err := json.Marshal(inv)
+	if err != nil {
+		return err
+	}
+	log.Printf("[INFO] Recording invocation in the binary: %s", data)
+	return env.writeEmbeddedData("invocation", "xcaddyInvocation", append([]byte(invocationMarker), data...))
+}
+
+// writeEmbeddedData writes data to a file called name in the build
+// environment, along with a Go source file which embeds it into the
+// binary as the variable varName.
+func (env environment) writeEmbeddedData(name, varName string, data []byte) error {
+	err := os.WriteFile(filepath.Join(env.tempFolder, name+".bin"), data, 0o644)
+	if err != nil {
+		return err
+	}
+	var buf bytes.Buffer
+	err = embeddedDataTemplate.Execute(&buf, map[string]string{
+		"File": name + ".bin",
+		"Var":  varName,
+	})
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(filepath.Join(env.tempFolder, name+".go"), buf.Bytes(), 0o644)
+}
+
+// ReadInvocation extracts the Invocation which was recorded in
+// a binary built by xcaddy. The input is the content of the binary.
+func ReadInvocation(binary []byte) (*Invocation, error) {
+	data, err := findMarked(binary, invocationMarker, []byte("{"))
+	if err != nil {
+		return nil, fmt.Errorf("no recorded invocation found")
+	}
+	// the JSON is followed by the rest of the binary
+	var inv Invocation
+	err = json.NewDecoder(bytes.NewReader(data)).Decode(&inv)
+	if err != nil {
+		return nil, fmt.Errorf("reading recorded invocation: %v", err)
+	}
+	return &inv, nil
+}
+
+// findMarked returns the part of binary after marker. The marker itself
+// may appear elsewhere in the binary, like in xcaddy itself, but only
+// the real one is followed by data starting with prefix.
+func findMarked(binary []byte, marker string, prefix []byte) ([]byte, error) {
+	for rest := binary; ; {
+		idx := bytes.Index(rest, []byte(marker))
+		if idx < 0 {
+			return nil, fmt.Errorf("marker not found")
+		}
+		rest = rest[idx+len(marker):]
+		if bytes.HasPrefix(rest, prefix) {
+			return rest, nil
+		}
+	}
+}
+
+// ReadProvenance extracts the go.mod and go.sum which were embedded
+// into a binary built with the EmbedModFiles option. The input is the
+// content of the binary.
+func ReadProvenance(binary []byte) (goMod, goSum []byte, err error) {
+	// the real marker is followed by the gzip magic number
+	data, err := findMarked(binary, provenanceMarker, []byte{0x1f, 0x8b})
+	if err != nil {
+		return nil, nil, fmt.Errorf("no embedded go.mod and go.sum found")
+	}
+	zr, err := gzip.NewReader(bytes.NewReader(data))
+	if err != nil {
+		return nil, nil, fmt.Errorf("reading embedded go.mod and go.sum: %v", err)
+	}
+	// the compressed data is followed by the rest of the binary
+	zr.Multistream(false)
+	content, err := io.ReadAll(zr)
+	if err != nil {
+		return nil, nil, fmt.Errorf("reading embedded go.mod and go.sum: %v", err)
+	}
+	content, found := bytes.CutPrefix(content, []byte(provenanceGoModHeader))
+	if !found {
+		return nil, nil, fmt.Errorf("malformed embedded go.mod and go.sum")
+	}
+	goMod, goSum, found = bytes.Cut(content, []byte(provenanceGoSumHeader))
+	if !found {
+		return nil, nil, fmt.Errorf("malformed embedded go.mod and go.sum")
+	}
+	return goMod, goSum, nil
+}
+
+// the embedded data must be referenced, or the linker drops it
+var embeddedDataTemplate = template.Must(template.New("embedded").Parse(`package main
+
+import _ "embed"
+
+// {{.Var}} was added by xcaddy.
+//
+//go:embed {{.File}}
+var {{.Var}} []byte
+
+func init() {
+	{{.Var}}Size = len({{.Var}})
+}
+
+var {{.Var}}Size int
+`))
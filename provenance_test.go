@@ -0,0 +1,107 @@
+// Copyright 2020 Matthew Holt
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package xcaddy
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+func TestReadProvenance(t *testing.T) {
+	env := environment{tempFolder: t.TempDir()}
+	goMod := []byte("module caddy\n\ngo 1.21\n\nrequire github.com/caddyserver/caddy/v2 v2.8.4\n")
+	goSum := []byte("github.com/caddyserver/caddy/v2 v2.8.4 h1:abc=\n")
+	if err := os.WriteFile(filepath.Join(env.tempFolder, "go.mod"), goMod, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(env.tempFolder, "go.sum"), goSum, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if err := env.writeProvenance(); err != nil {
+		t.Fatalf("writeProvenance() error = %v", err)
+	}
+	embedded, err := os.ReadFile(filepath.Join(env.tempFolder, "provenance.bin"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// simulate a binary which also contains the marker elsewhere
+	var binary []byte
+	binary = append(binary, "ELF..."+provenanceMarker+"const data..."...)
+	binary = append(binary, embedded...)
+	binary = append(binary, "...more data"...)
+
+	gotMod, gotSum, err := ReadProvenance(binary)
+	if err != nil {
+		t.Fatalf("ReadProvenance() error = %v", err)
+	}
+	if !bytes.Equal(gotMod, goMod) {
+		t.Errorf("ReadProvenance() go.mod = %q, want %q", gotMod, goMod)
+	}
+	if !bytes.Equal(gotSum, goSum) {
+		t.Errorf("ReadProvenance() go.sum = %q, want %q", gotSum, goSum)
+	}
+
+	if _, _, err := ReadProvenance([]byte("ELF...")); err == nil {
+		t.Errorf("ReadProvenance() expected error for binary without provenance")
+	}
+}
+
+func TestReadInvocation(t *testing.T) {
+	env := environment{
+		tempFolder:   t.TempDir(),
+		caddyVersion: "v2.8.4",
+		plugins: []Dependency{
+			{PackagePath: "github.com/caddyserver/ntlm-transport", Version: "v0.1.1"},
+		},
+	}
+	inv := Invocation{
+		XcaddyVersion: "v0.4.4",
+		Args:          []string{"build", "v2.8.4", "--with", "github.com/caddyserver/ntlm-transport@v0.1.1"},
+	}
+	if err := env.writeInvocation(inv); err != nil {
+		t.Fatalf("writeInvocation() error = %v", err)
+	}
+	embedded, err := os.ReadFile(filepath.Join(env.tempFolder, "invocation.bin"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var binary []byte
+	binary = append(binary, "ELF..."+invocationMarker+"const data..."...)
+	binary = append(binary, embedded...)
+	binary = append(binary, "...more data"...)
+
+	got, err := ReadInvocation(binary)
+	if err != nil {
+		t.Fatalf("ReadInvocation() error = %v", err)
+	}
+	want := &Invocation{
+		XcaddyVersion: inv.XcaddyVersion,
+		Args:          inv.Args,
+		CaddyVersion:  env.caddyVersion,
+		Plugins:       env.plugins,
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ReadInvocation() = %+v, want %+v", got, want)
+	}
+
+	if _, err := ReadInvocation([]byte("ELF...")); err == nil {
+		t.Errorf("ReadInvocation() expected error for binary without invocation")
+	}
+}
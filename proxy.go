@@ -0,0 +1,65 @@
+// Copyright 2020 Matthew Holt
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package xcaddy
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// proxyURL returns the URL of the proxy given as proxy (see
+// Builder.Proxy), with the scheme http:// added if it has none,
+// as the go command and git assume.
+func proxyURL(proxy string) (string, error) {
+	if !strings.Contains(proxy, "://") {
+		proxy = "http://" + proxy
+	}
+	u, err := url.Parse(proxy)
+	if err != nil {
+		// the error of url.Parse quotes the URL, with any password
+		if urlErr, ok := err.(*url.Error); ok {
+			err = urlErr.Err
+		}
+		return "", fmt.Errorf("invalid proxy: %v", err)
+	}
+	switch u.Scheme {
+	case "http", "https", "socks5", "socks5h":
+	default:
+		return "", fmt.Errorf("invalid proxy %s: the scheme must be http, https, socks5 or socks5h", u.Redacted())
+	}
+	if u.Host == "" {
+		return "", fmt.Errorf("invalid proxy %s: no host", u.Redacted())
+	}
+	return u.String(), nil
+}
+
+// proxyEnviron sets the proxy variables of env to proxy and
+// noProxy, if set. Both the upper and lower case variables are
+// set, since programs like git prefer the lower case ones and
+// the go command the upper case ones.
+func proxyEnviron(env []string, proxy, noProxy string) []string {
+	if proxy != "" {
+		for _, name := range []string{"HTTP_PROXY", "HTTPS_PROXY", "http_proxy", "https_proxy"} {
+			env = setEnv(env, name+"="+proxy)
+		}
+	}
+	if noProxy != "" {
+		for _, name := range []string{"NO_PROXY", "no_proxy"} {
+			env = setEnv(env, name+"="+noProxy)
+		}
+	}
+	return env
+}
@@ -0,0 +1,132 @@
+// Copyright 2020 Matthew Holt
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package xcaddy
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"text/tabwriter"
+)
+
+// pruneReportLimit is the number of modules in the prune report.
+const pruneReportLimit = 20
+
+// moduleWeight is the share of a dependency in the build,
+// measured by the Go source of its packages which are part
+// of it, since the binary doesn't keep its symbols.
+type moduleWeight struct {
+	Path     string
+	Packages int
+	Size     int64
+
+	// the plugins which introduced the module,
+	// or "caddy" if Caddy itself needs it
+	IntroducedBy []string
+}
+
+// explainModules logs why the modules with the given paths are
+// part of the build, as explained by `go mod why -m`: the shortest
+// chain of imports from the main package to a package of each.
+func (env environment) explainModules(ctx context.Context, modulePaths []string) error {
+	log.Printf("[INFO] Explaining why modules are part of the build: %s", strings.Join(modulePaths, ", "))
+	cmd := env.newGoModCommand(ctx, "why", "-m")
+	cmd.Args = append(cmd.Args, modulePaths...)
+	return env.runCommand(ctx, cmd)
+}
+
+// writePruneReport writes the heaviest dependencies among deps, the
+// packages of the build, to w, each with the plugins responsible for it.
+func (env environment) writePruneReport(ctx context.Context, w io.Writer, deps []listedPackage) error {
+	edges, err := env.annotatedGraph(ctx)
+	if err != nil {
+		return err
+	}
+	weights := weighModules(deps, env.caddyModulePath, edges, sourceSize)
+	if len(weights) > pruneReportLimit {
+		weights = weights[:pruneReportLimit]
+	}
+	fmt.Fprintln(w)
+	fmt.Fprintln(w, "Heaviest dependencies, by the size of their Go source in the build:")
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "MODULE\tPACKAGES\tSOURCE\tINTRODUCED BY")
+	for _, m := range weights {
+		fmt.Fprintf(tw, "%s\t%d\t%s\t%s\n", m.Path, m.Packages, formatMiB(m.Size), strings.Join(m.IntroducedBy, ", "))
+	}
+	return tw.Flush()
+}
+
+// weighModules sums up the sizes of the packages among deps, as reported
+// by sizeOf, by their module, leaving out the standard library, the main
+// module and Caddy, and attributes each module to the plugins which
+// introduced it according to edges. The heaviest modules come first.
+func weighModules(deps []listedPackage, caddyModulePath string, edges []graphEdge, sizeOf func(listedPackage) int64) []moduleWeight {
+	introducedBy := make(map[string]map[string]bool)
+	for _, e := range edges {
+		path, _, _ := strings.Cut(e.To, "@")
+		if introducedBy[path] == nil {
+			introducedBy[path] = make(map[string]bool)
+		}
+		for _, p := range e.IntroducedBy {
+			introducedBy[path][p] = true
+		}
+	}
+
+	byPath := make(map[string]*moduleWeight)
+	for _, pkg := range deps {
+		if pkg.Standard || pkg.Module == nil || pkg.Module.Main || pkg.Module.Path == caddyModulePath {
+			continue
+		}
+		m := byPath[pkg.Module.Path]
+		if m == nil {
+			m = &moduleWeight{Path: pkg.Module.Path}
+			byPath[pkg.Module.Path] = m
+		}
+		m.Packages++
+		m.Size += sizeOf(pkg)
+	}
+
+	var weights []moduleWeight
+	for path, m := range byPath {
+		for p := range introducedBy[path] {
+			m.IntroducedBy = append(m.IntroducedBy, p)
+		}
+		sort.Strings(m.IntroducedBy)
+		weights = append(weights, *m)
+	}
+	sort.Slice(weights, func(i, j int) bool {
+		if weights[i].Size != weights[j].Size {
+			return weights[i].Size > weights[j].Size
+		}
+		return weights[i].Path < weights[j].Path
+	})
+	return weights
+}
+
+// sourceSize returns the size of the Go files of pkg.
+func sourceSize(pkg listedPackage) int64 {
+	var size int64
+	for _, name := range pkg.GoFiles {
+		if info, err := os.Stat(filepath.Join(pkg.Dir, name)); err == nil {
+			size += info.Size()
+		}
+	}
+	return size
+}
@@ -0,0 +1,60 @@
+// Copyright 2020 Matthew Holt
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package xcaddy
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestWeighModules(t *testing.T) {
+	const caddy = "github.com/caddyserver/caddy/v2"
+	pkg := func(importPath, module string) listedPackage {
+		p := listedPackage{ImportPath: importPath}
+		p.Module = &struct {
+			Path string
+			Main bool
+		}{Path: module, Main: module == "caddy"}
+		return p
+	}
+	deps := []listedPackage{
+		{ImportPath: "net/http", Standard: true},
+		pkg("caddy", "caddy"),
+		pkg(caddy, caddy),
+		pkg("github.com/quic-go/quic-go", "github.com/quic-go/quic-go"),
+		pkg("github.com/aws/aws-sdk-go-v2/service/route53", "github.com/aws/aws-sdk-go-v2/service/route53"),
+		pkg("github.com/aws/aws-sdk-go-v2/service/route53/types", "github.com/aws/aws-sdk-go-v2/service/route53"),
+	}
+	edges := []graphEdge{
+		{From: "caddy", To: caddy + "@v2.8.4", IntroducedBy: []string{"caddy"}},
+		{From: caddy + "@v2.8.4", To: "github.com/quic-go/quic-go@v0.44.0", IntroducedBy: []string{"caddy"}},
+		{From: "caddy", To: "github.com/aws/aws-sdk-go-v2/service/route53@v1.40.0", IntroducedBy: []string{"github.com/caddy-dns/route53"}},
+	}
+	sizes := map[string]int64{
+		"github.com/quic-go/quic-go":                         300,
+		"github.com/aws/aws-sdk-go-v2/service/route53":       400,
+		"github.com/aws/aws-sdk-go-v2/service/route53/types": 200,
+		caddy: 1000,
+	}
+
+	got := weighModules(deps, caddy, edges, func(p listedPackage) int64 { return sizes[p.ImportPath] })
+	want := []moduleWeight{
+		{Path: "github.com/aws/aws-sdk-go-v2/service/route53", Packages: 2, Size: 600, IntroducedBy: []string{"github.com/caddy-dns/route53"}},
+		{Path: "github.com/quic-go/quic-go", Packages: 1, Size: 300, IntroducedBy: []string{"caddy"}},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("weighModules() = %+v, want %+v", got, want)
+	}
+}
@@ -0,0 +1,190 @@
+// Copyright 2020 Matthew Holt
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package xcaddy
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// Resolution is what a build of a Builder is made of, as found by
+// Resolve: the versions of Caddy and the plugins which are selected,
+// the replacements, and how Caddy is compiled.
+type Resolution struct {
+	// The module of Caddy, and the version of it which is selected.
+	CaddyModule  string `json:"caddy_module"`
+	CaddyVersion string `json:"caddy_version"`
+
+	Plugins []ResolvedPlugin `json:"plugins,omitempty"`
+
+	// The replacements which apply to modules of the build.
+	Replacements []Replace `json:"replacements,omitempty"`
+
+	// The target platform, and whether cgo is enabled.
+	Platform Platform `json:"platform"`
+	Cgo      bool     `json:"cgo"`
+
+	// The version of the go command, and the arguments of go
+	// build, along with its flags, but without the output file.
+	GoVersion string   `json:"go_version"`
+	BuildArgs []string `json:"build_args"`
+}
+
+// ResolvedPlugin is a plugin of a Resolution.
+type ResolvedPlugin struct {
+	// The package of the plugin, and the version it was given with.
+	PackagePath string `json:"package_path"`
+	Requested   string `json:"requested,omitempty"`
+
+	// The module of the package, and the version of it which is
+	// selected, or of its replacement; a local directory has none.
+	Module  string `json:"module,omitempty"`
+	Version string `json:"version,omitempty"`
+
+	// What the module is replaced by, if it is: a module
+	// with a version, like path@version, or a directory.
+	Replace string `json:"replace,omitempty"`
+}
+
+// Resolve resolves the versions of the modules of the build of b,
+// like a build does, but without compiling anything, so the build can
+// be reviewed before it is made. It uses the environment in
+// Environment if set, or else a temporary one.
+func (b Builder) Resolve(ctx context.Context) (Resolution, error) {
+	ctx, cancel := b.withTimeoutTotal(ctx)
+	defer cancel()
+	b.setDefaults()
+	var buildEnv *environment
+	var err error
+	if b.Environment != "" {
+		buildEnv, err = b.openEnvironment(ctx, b.Environment)
+	} else {
+		buildEnv, err = b.prepareEnvironment(ctx, "")
+	}
+	if err != nil {
+		return Resolution{}, withFailure(err, FailureResolution)
+	}
+	defer buildEnv.Close()
+
+	res, err := b.resolve(ctx, buildEnv)
+	if err != nil {
+		return Resolution{}, withFailure(err, FailureResolution)
+	}
+	return res, nil
+}
+
+// resolve returns the Resolution of the build of b in buildEnv.
+func (b Builder) resolve(ctx context.Context, buildEnv *environment) (Resolution, error) {
+	selected := b.selectedCaddy
+	if selected == nil {
+		s, err := buildEnv.selectCaddy(ctx)
+		if err != nil {
+			return Resolution{}, err
+		}
+		selected = &s
+	}
+	goVersion, err := buildEnv.goCommandVersion(ctx)
+	if err != nil {
+		return Resolution{}, err
+	}
+	modules, err := buildEnv.listModules(ctx)
+	if err != nil {
+		return Resolution{}, err
+	}
+	cmd, err := buildEnv.newGoBuildCommand(ctx, "build")
+	if err != nil {
+		return Resolution{}, err
+	}
+
+	res := Resolution{
+		CaddyModule:  buildEnv.caddyModulePath,
+		CaddyVersion: selected.version,
+		Platform:     Platform{OS: b.OS, Arch: b.Arch, ARM: b.ARM},
+		Cgo:          b.Compile.Cgo || b.RaceDetector || buildEnv.goPlugin,
+		GoVersion:    goVersion,
+		BuildArgs:    b.appendBuildFlags(cmd.Args[1:], buildEnv, *selected),
+	}
+	for _, p := range buildEnv.plugins {
+		plugin := ResolvedPlugin{PackagePath: p.PackagePath, Requested: p.Version}
+		if mod := moduleOfPackage(p.PackagePath, modules); mod != nil {
+			plugin.Module, plugin.Version = mod.Path, mod.Version
+			if mod.Replace != nil {
+				plugin.Version = mod.Replace.Version
+				plugin.Replace = Dependency{PackagePath: mod.Replace.Path, Version: mod.Replace.Version}.String()
+			}
+		}
+		res.Plugins = append(res.Plugins, plugin)
+	}
+	for _, mod := range modules {
+		if mod.Replace != nil {
+			res.Replacements = append(res.Replacements, NewReplace(mod.Path, Dependency{PackagePath: mod.Replace.Path, Version: mod.Replace.Version}.String()))
+		}
+	}
+	return res, nil
+}
+
+// listedModule is a module as listed by go list -m -json.
+type listedModule struct {
+	Path    string
+	Version string
+	Main    bool
+	Replace *listedModule
+}
+
+// listModules returns the modules of the build, except the main one.
+func (env environment) listModules(ctx context.Context) ([]listedModule, error) {
+	cmd, err := env.newGoBuildCommand(ctx, "list", "-m", "-json", "all")
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	cmd.Stdout = &buf
+	err = env.runCommand(ctx, cmd)
+	if err != nil {
+		return nil, err
+	}
+	var modules []listedModule
+	dec := json.NewDecoder(&buf)
+	for {
+		var mod listedModule
+		err := dec.Decode(&mod)
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("parsing go list output: %v", err)
+		}
+		if !mod.Main {
+			modules = append(modules, mod)
+		}
+	}
+	return modules, nil
+}
+
+// moduleOfPackage returns the module among modules which contains
+// the package at packagePath, or nil if there is none. A package
+// belongs to the module with the longest matching path.
+func moduleOfPackage(packagePath string, modules []listedModule) *listedModule {
+	var found *listedModule
+	for i, mod := range modules {
+		if withinPath(packagePath, mod.Path) && (found == nil || len(mod.Path) > len(found.Path)) {
+			found = &modules[i]
+		}
+	}
+	return found
+}
@@ -0,0 +1,80 @@
+// Copyright 2020 Matthew Holt
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package xcaddy
+
+import (
+	"context"
+	"os"
+	"reflect"
+	"testing"
+
+	"github.com/caddyserver/xcaddy/internal/fakego"
+)
+
+func TestMain(m *testing.M) {
+	fakego.Main()
+	os.Exit(m.Run())
+}
+
+func TestBuilderResolve(t *testing.T) {
+	g := fakego.New(t)
+	g.Handle(fakego.Rule{
+		Args: []string{"list", "-m", "-json", "all"},
+		Stdout: `{"Path": "caddy", "Main": true}
+{"Path": "github.com/caddy-dns/cloudflare", "Version": "v0.1.0"}
+{"Path": "github.com/caddyserver/caddy/v2", "Version": "v2.8.4"}
+{"Path": "github.com/libdns/libdns", "Version": "v0.2.2", "Replace": {"Path": "github.com/acme/libdns", "Version": "v0.2.3"}}
+{"Path": "github.com/me/plugin", "Version": "v0.0.0-00010101000000-000000000000", "Replace": {"Path": "/src/plugin"}}
+`,
+	})
+	b := Builder{
+		Compile:      Compile{Platform: Platform{OS: "linux", Arch: "arm64"}},
+		CaddyVersion: "v2.8.4",
+		Plugins: []Dependency{
+			{PackagePath: "github.com/caddy-dns/cloudflare"},
+			{PackagePath: "github.com/me/plugin/caddy", Version: "v1.2.3"},
+		},
+		Replacements: []Replace{
+			NewReplace("github.com/me/plugin", "/src/plugin"),
+			NewReplace("github.com/libdns/libdns", "github.com/acme/libdns@v0.2.3"),
+		},
+		SkipPreflight: true,
+	}
+	got, err := b.Resolve(context.Background())
+	if err != nil {
+		t.Fatalf("Resolve() error = %v", err)
+	}
+	want := Resolution{
+		CaddyModule:  "github.com/caddyserver/caddy/v2",
+		CaddyVersion: fakego.CaddyVersion,
+		Plugins: []ResolvedPlugin{
+			{PackagePath: "github.com/caddy-dns/cloudflare", Module: "github.com/caddy-dns/cloudflare", Version: "v0.1.0"},
+			{PackagePath: "github.com/me/plugin/caddy", Requested: "v1.2.3", Module: "github.com/me/plugin", Replace: "/src/plugin"},
+		},
+		Replacements: []Replace{
+			NewReplace("github.com/libdns/libdns", "github.com/acme/libdns@v0.2.3"),
+			NewReplace("github.com/me/plugin", "/src/plugin"),
+		},
+		Platform:  Platform{OS: "linux", Arch: "arm64"},
+		GoVersion: fakego.GoVersion,
+		BuildArgs: []string{"build", "-ldflags", "-w -s", "-trimpath", "-tags", "nobadger,nomysql,nopgx"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Resolve() =\n%+v\nwant:\n%+v", got, want)
+	}
+	if builds := g.Find("build"); len(builds) > 0 {
+		t.Errorf("Resolve() built Caddy: %v", builds)
+	}
+}
@@ -0,0 +1,100 @@
+// Copyright 2020 Matthew Holt
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package xcaddy
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"runtime"
+)
+
+// sandbox isolates the go commands of an environment from the
+// settings of the user; see Builder.Sandbox.
+type sandbox struct {
+	// the folder of the throwaway HOME and GOPATH
+	dir string
+
+	// the caches of the user, which are shared
+	modCache   string
+	buildCache string
+}
+
+// newSandbox creates a sandbox in the environment folder. Its
+// name starts with a dot, so the go command ignores it as part of
+// the main module. If netrc is set, the home folder of the sandbox
+// links to it, so git finds the credentials as well.
+func newSandbox(ctx context.Context, envFolder, netrc string) (*sandbox, error) {
+	modCache, buildCache, err := goCacheDirs(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("finding the Go caches to share with the sandbox: %v", err)
+	}
+	sb := &sandbox{
+		dir:        filepath.Join(envFolder, ".sandbox"),
+		modCache:   modCache,
+		buildCache: buildCache,
+	}
+	for _, dir := range []string{sb.home(), sb.gopath()} {
+		err = os.MkdirAll(dir, 0o755)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if netrc != "" {
+		err = linkNetrc(sb.home(), netrc)
+		if err != nil {
+			log.Printf("[WARNING] Unable to make %s available to git in the sandbox: %v", netrc, err)
+		}
+	}
+	log.Printf("[INFO] Sandboxing go commands in %s, sharing the module cache %s and build cache %s", sb.dir, modCache, buildCache)
+	return sb, nil
+}
+
+func (sb sandbox) home() string   { return filepath.Join(sb.dir, "home") }
+func (sb sandbox) gopath() string { return filepath.Join(sb.dir, "gopath") }
+
+// environ sets the variables which point the go command,
+// and tools like git, to the sandbox in base.
+func (sb sandbox) environ(base []string) []string {
+	for _, set := range []string{
+		"HOME=" + sb.home(),
+		"USERPROFILE=" + sb.home(),
+		"XDG_CONFIG_HOME=" + filepath.Join(sb.home(), ".config"),
+		"GOENV=off",
+		"GOPATH=" + sb.gopath(),
+		"GOMODCACHE=" + sb.modCache,
+		"GOCACHE=" + sb.buildCache,
+	} {
+		base = setEnv(base, set)
+	}
+	return base
+}
+
+// linkNetrc links the .netrc file of home to netrc,
+// replacing the link of a previous build, if any.
+func linkNetrc(home, netrc string) error {
+	name := ".netrc"
+	if runtime.GOOS == "windows" {
+		name = "_netrc"
+	}
+	link := filepath.Join(home, name)
+	err := os.Remove(link)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return os.Symlink(netrc, link)
+}
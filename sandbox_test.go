@@ -0,0 +1,67 @@
+// Copyright 2020 Matthew Holt
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package xcaddy
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestNewSandbox(t *testing.T) {
+	folder := t.TempDir()
+	sb, err := newSandbox(context.Background(), folder, "")
+	if err != nil {
+		t.Fatalf("newSandbox() error = %v", err)
+	}
+	for _, dir := range []string{sb.home(), sb.gopath()} {
+		if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+			t.Errorf("newSandbox() did not create %s: %v", dir, err)
+		}
+	}
+	if sb.modCache == "" || sb.buildCache == "" {
+		t.Errorf("newSandbox() caches = %q, %q, want those of the go command", sb.modCache, sb.buildCache)
+	}
+}
+
+func TestSandboxEnviron(t *testing.T) {
+	sb := sandbox{dir: filepath.FromSlash("/env/.sandbox"), modCache: "/mod", buildCache: "/build"}
+	env := sb.environ([]string{"HOME=/home/user", "GOPATH=/home/user/go", "GOENV=/home/user/.config/go/env", "GOPROXY=direct"})
+	want := map[string]string{
+		"HOME":            sb.home(),
+		"USERPROFILE":     sb.home(),
+		"XDG_CONFIG_HOME": filepath.Join(sb.home(), ".config"),
+		"GOENV":           "off",
+		"GOPATH":          sb.gopath(),
+		"GOMODCACHE":      "/mod",
+		"GOCACHE":         "/build",
+		"GOPROXY":         "direct",
+	}
+	got := make(map[string]string)
+	for _, kv := range env {
+		k, v, _ := strings.Cut(kv, "=")
+		if _, dup := got[k]; dup {
+			t.Errorf("environ() sets %s more than once", k)
+		}
+		got[k] = v
+	}
+	for k, v := range want {
+		if got[k] != v {
+			t.Errorf("environ() %s = %q, want %q", k, got[k], v)
+		}
+	}
+}
@@ -0,0 +1,161 @@
+// Copyright 2020 Matthew Holt
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package xcaddy
+
+import (
+	"bytes"
+	"crypto/x509"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+)
+
+// caBundleFile is the name of the file in the environment folder with
+// the certificates trusted by the commands of the build; see
+// Builder.CACert. Its name starts with a dot, so the go command
+// ignores it.
+const caBundleFile = ".xcaddy-ca.pem"
+
+// systemCAFiles are the files in which the operating systems keep the
+// certificates they trust, as searched by the crypto/x509 package.
+var systemCAFiles = []string{
+	"/etc/ssl/certs/ca-certificates.crt",                // Debian/Ubuntu/Gentoo etc.
+	"/etc/pki/tls/certs/ca-bundle.crt",                  // Fedora/RHEL 6
+	"/etc/ssl/ca-bundle.pem",                            // OpenSUSE
+	"/etc/pki/tls/cacert.pem",                           // OpenELEC
+	"/etc/pki/ca-trust/extracted/pem/tls-ca-bundle.pem", // CentOS/RHEL 7
+	"/etc/ssl/cert.pem",                                 // Alpine Linux, BSDs
+	"/usr/local/etc/ssl/cert.pem",                       // FreeBSD
+}
+
+// writeCABundle writes the certificates in the file caCert (see
+// Builder.CACert), after those the system trusts, to a bundle in dir,
+// since a bundle given to the go command or git replaces the system's.
+// It returns the path of the bundle.
+func writeCABundle(dir, caCert string) (string, error) {
+	pem, err := os.ReadFile(caCert)
+	if err != nil {
+		return "", fmt.Errorf("CA certificate: %v", err)
+	}
+	if !x509.NewCertPool().AppendCertsFromPEM(pem) {
+		return "", fmt.Errorf("CA certificate %s contains no PEM-encoded certificates", caCert)
+	}
+	if runtime.GOOS == "darwin" || runtime.GOOS == "windows" {
+		log.Printf("[WARNING] The go command only trusts the certificates of the system on %s, so %s only applies to git", runtime.GOOS, caCert)
+	}
+
+	var bundle bytes.Buffer
+	if system := systemCABundle(); system != nil {
+		bundle.Write(system)
+		if !bytes.HasSuffix(system, []byte("\n")) {
+			bundle.WriteByte('\n')
+		}
+	}
+	bundle.Write(pem)
+	path := filepath.Join(dir, caBundleFile)
+	err = os.WriteFile(path, bundle.Bytes(), 0o644)
+	if err != nil {
+		return "", err
+	}
+	log.Printf("[INFO] Trusting the certificates in %s for downloading modules", caCert)
+	return path, nil
+}
+
+// systemCABundle returns the certificates the system trusts, from
+// the file in SSL_CERT_FILE or else the first of systemCAFiles which
+// exists, or nil if there is none.
+func systemCABundle() []byte {
+	files := systemCAFiles
+	if f := os.Getenv("SSL_CERT_FILE"); f != "" {
+		files = []string{f}
+	}
+	for _, f := range files {
+		if data, err := os.ReadFile(f); err == nil {
+			return data
+		}
+	}
+	return nil
+}
+
+// clientCertFiles returns the absolute paths of the client certificate
+// and key of mutual TLS (see Builder.ClientCert), which must be given
+// together, after checking that they exist; empty if neither is given.
+func clientCertFiles(cert, key string) (string, string, error) {
+	if (cert == "") != (key == "") {
+		return "", "", fmt.Errorf("a client certificate and its key must be given together")
+	}
+	if cert == "" {
+		return "", "", nil
+	}
+	var abs [2]string
+	for i, file := range []string{cert, key} {
+		var err error
+		abs[i], err = filepath.Abs(file)
+		if err != nil {
+			return "", "", err
+		}
+		if _, err = os.Stat(abs[i]); err != nil {
+			return "", "", fmt.Errorf("client certificate: %v", err)
+		}
+	}
+	return abs[0], abs[1], nil
+}
+
+// tlsEnviron sets the variables of env which make the commands of the
+// build trust the certificates in caBundle, present the client
+// certificate clientCert with clientKey, and fetch the modules matched
+// by insecure without verifying TLS; each is only set if given.
+func tlsEnviron(env []string, caBundle, clientCert, clientKey string, insecure []string) []string {
+	if caBundle != "" {
+		env = setEnv(env, "SSL_CERT_FILE="+caBundle)
+		env = setEnv(env, "GIT_SSL_CAINFO="+caBundle)
+	}
+	if clientCert != "" {
+		env = setEnv(env, "GIT_SSL_CERT="+clientCert)
+		env = setEnv(env, "GIT_SSL_KEY="+clientKey)
+	}
+	if len(insecure) > 0 {
+		env = setEnv(env, "GOINSECURE="+strings.Join(insecurePatterns(env, insecure), ","))
+	}
+	return env
+}
+
+// insecurePatterns returns the patterns of GOINSECURE in the
+// environment variables env, if any, followed by insecure; those
+// are the modules which are fetched without verifying TLS.
+func insecurePatterns(env []string, insecure []string) []string {
+	var patterns []string
+	for _, kv := range env {
+		if current, ok := strings.CutPrefix(kv, "GOINSECURE="); ok {
+			patterns = nil
+			for _, p := range strings.Split(current, ",") {
+				if p = strings.TrimSpace(p); p != "" {
+					patterns = append(patterns, p)
+				}
+			}
+		}
+	}
+	return append(patterns, insecure...)
+}
+
+// insecureWarning returns the warning about fetching the
+// modules matching patterns without verifying TLS.
+func insecureWarning(patterns []string) string {
+	return fmt.Sprintf("modules matching %s are fetched without verifying TLS, as in GOINSECURE, so they can be tampered with on the way unless the checksum database knows them",
+		strings.Join(patterns, ","))
+}
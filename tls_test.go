@@ -0,0 +1,176 @@
+// Copyright 2020 Matthew Holt
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package xcaddy
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testCertPEM returns a PEM-encoded self-signed CA certificate.
+func testCertPEM(t *testing.T, name string) []byte {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now(),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+}
+
+func TestWriteCABundle(t *testing.T) {
+	dir := t.TempDir()
+	system := testCertPEM(t, "System Root")
+	systemFile := filepath.Join(dir, "system.pem")
+	if err := os.WriteFile(systemFile, system, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("SSL_CERT_FILE", systemFile)
+	corp := testCertPEM(t, "Corp Root")
+	corpFile := filepath.Join(dir, "corp.pem")
+	if err := os.WriteFile(corpFile, corp, 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	bundle, err := writeCABundle(dir, corpFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if bundle != filepath.Join(dir, caBundleFile) {
+		t.Errorf("bundle = %s, want it in %s", bundle, dir)
+	}
+	got, err := os.ReadFile(bundle)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := string(system) + string(corp); string(got) != want {
+		t.Errorf("bundle =\n%s\nwant the system certificates followed by the given ones", got)
+	}
+
+	notPEM := filepath.Join(dir, "corp.der")
+	if err := os.WriteFile(notPEM, []byte("not a certificate"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := writeCABundle(dir, notPEM); err == nil {
+		t.Error("expected an error for a file without certificates")
+	}
+	if _, err := writeCABundle(dir, filepath.Join(dir, "missing.pem")); err == nil {
+		t.Error("expected an error for a missing file")
+	}
+}
+
+func TestClientCertFiles(t *testing.T) {
+	dir := t.TempDir()
+	cert := filepath.Join(dir, "me.crt")
+	key := filepath.Join(dir, "me.key")
+	for _, f := range []string{cert, key} {
+		if err := os.WriteFile(f, []byte("pem"), 0o600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if c, k, err := clientCertFiles("", ""); err != nil || c != "" || k != "" {
+		t.Errorf("clientCertFiles() without files = %q, %q, %v", c, k, err)
+	}
+	if c, k, err := clientCertFiles(cert, key); err != nil || c != cert || k != key {
+		t.Errorf("clientCertFiles() = %q, %q, %v", c, k, err)
+	}
+	if _, _, err := clientCertFiles(cert, ""); err == nil {
+		t.Error("expected an error for a certificate without key")
+	}
+	if _, _, err := clientCertFiles(cert, filepath.Join(dir, "missing.key")); err == nil {
+		t.Error("expected an error for a missing key")
+	}
+}
+
+func TestTLSEnviron(t *testing.T) {
+	base := []string{"PATH=/usr/bin", "GOINSECURE=old.example.com"}
+	got := tlsEnviron(append([]string(nil), base...), "", "", "", nil)
+	if !reflect.DeepEqual(got, base) {
+		t.Errorf("tlsEnviron() without settings = %v, want it unchanged", got)
+	}
+
+	got = tlsEnviron(append([]string(nil), base...), "/env/.xcaddy-ca.pem", "/me.crt", "/me.key", []string{"git.corp.example.com/*", "*.lab"})
+	want := []string{
+		"PATH=/usr/bin",
+		"GOINSECURE=old.example.com,git.corp.example.com/*,*.lab",
+		"SSL_CERT_FILE=/env/.xcaddy-ca.pem",
+		"GIT_SSL_CAINFO=/env/.xcaddy-ca.pem",
+		"GIT_SSL_CERT=/me.crt",
+		"GIT_SSL_KEY=/me.key",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("tlsEnviron() =\n%s\nwant\n%s", strings.Join(got, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestInsecurePatterns(t *testing.T) {
+	for i, tc := range []struct {
+		env      []string
+		insecure []string
+		want     []string
+	}{
+		{env: []string{"PATH=/usr/bin"}},
+		{env: []string{"GOINSECURE="}, insecure: []string{"*.lab"}, want: []string{"*.lab"}},
+		{env: []string{"GOINSECURE=old.example.com, other.example.com"}, want: []string{"old.example.com", "other.example.com"}},
+		{
+			env:      []string{"GOINSECURE=old.example.com", "PATH=/usr/bin"},
+			insecure: []string{"git.corp.example.com/*"},
+			want:     []string{"old.example.com", "git.corp.example.com/*"},
+		},
+	} {
+		if got := insecurePatterns(tc.env, tc.insecure); !reflect.DeepEqual(got, tc.want) {
+			t.Errorf("test %d: insecurePatterns(%v, %v) = %v, want %v", i, tc.env, tc.insecure, got, tc.want)
+		}
+	}
+}
+
+func TestSetGoEnvInsecureModules(t *testing.T) {
+	t.Setenv("GOINSECURE", "old.example.com")
+	env := &environment{tempFolder: t.TempDir()}
+	err := env.setGoEnv(context.Background(), Builder{Insecure: []string{"git.corp.example.com/*"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"old.example.com", "git.corp.example.com/*"}
+	if !reflect.DeepEqual(env.insecureModules, want) {
+		t.Errorf("insecureModules = %v, want %v", env.insecureModules, want)
+	}
+	if !reflect.DeepEqual(env.insecure, want[1:]) {
+		t.Errorf("insecure = %v, want only the patterns of the builder", env.insecure)
+	}
+}
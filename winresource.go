@@ -0,0 +1,47 @@
+// Copyright 2020 Matthew Holt
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package xcaddy
+
+import (
+	"log"
+	"path/filepath"
+
+	"github.com/caddyserver/xcaddy/internal/utils"
+)
+
+// windowsResources maps the architectures of Windows to the
+// version of Caddy and name of the binary which their version
+// resource, a .syso file in the environment, was generated for.
+// The builds of BuildAll share it while they hold Builder.shared.
+type windowsResources map[string]string
+
+// generate writes the version resource of the Windows binary
+// outputFile for arch to folder, unless the same one was written
+// for arch before. A nil map generates it each time.
+func (r windowsResources) generate(caddyVersion, arch, outputFile, folder string) error {
+	key := caddyVersion + " " + filepath.Base(outputFile)
+	if r != nil && r[arch] == key {
+		log.Printf("[INFO] Reusing the version resource for windows/%s", arch)
+		return nil
+	}
+	err := utils.WindowsResource(caddyVersion, arch, outputFile, folder)
+	if err != nil {
+		return err
+	}
+	if r != nil {
+		r[arch] = key
+	}
+	return nil
+}
@@ -0,0 +1,60 @@
+// Copyright 2020 Matthew Holt
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package xcaddy
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestWindowsResourcesGenerate(t *testing.T) {
+	dir := t.TempDir()
+	syso := filepath.Join(dir, "resource_windows_arm64.syso")
+	resources := make(windowsResources)
+	if err := resources.generate("v2.8.4", "arm64", "caddy.exe", dir); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Remove(syso); err != nil {
+		t.Fatal(err)
+	}
+
+	// the same resource isn't generated again
+	if err := resources.generate("v2.8.4", "arm64", "caddy.exe", dir); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(syso); !os.IsNotExist(err) {
+		t.Errorf("expected the resource to be reused, got %v", err)
+	}
+
+	// a different one is
+	if err := resources.generate("v2.8.4", "arm64", "caddy_arm64.exe", dir); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(syso); err != nil {
+		t.Errorf("expected a new resource: %v", err)
+	}
+
+	// without a map, it is generated each time
+	if err := os.Remove(syso); err != nil {
+		t.Fatal(err)
+	}
+	if err := windowsResources(nil).generate("v2.8.4", "arm64", "caddy_arm64.exe", dir); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(syso); err != nil {
+		t.Errorf("expected a resource without a map: %v", err)
+	}
+}
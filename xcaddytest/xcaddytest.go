@@ -0,0 +1,266 @@
+// Copyright 2020 Matthew Holt
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+// Package xcaddytest helps Caddy plugins test themselves end to end:
+// it builds Caddy with the plugin, runs it with a config on free ports
+// and stops it when the test ends, without shelling out to xcaddy.
+//
+//	func TestPlugin(t *testing.T) {
+//		caddy := xcaddytest.BuildAndRun(context.Background(), t, xcaddy.Builder{
+//			Plugins:      []xcaddy.Dependency{{PackagePath: "github.com/me/caddy-hello"}},
+//			Replacements: []xcaddy.Replace{xcaddy.NewReplace("github.com/me/caddy-hello", "..")},
+//		}, `
+//			{
+//				http_port {xcaddytest.http_port}
+//			}
+//			:{xcaddytest.http_port} {
+//				hello
+//			}
+//		`)
+//		resp, err := http.Get(caddy.HTTPURL + "/")
+//		...
+//	}
+//
+// Builds take a while, so tests sharing a build can call Build once,
+// like in TestMain, and Run for each config.
+package xcaddytest
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strconv"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/caddyserver/xcaddy"
+)
+
+// readyTimeout is how long Caddy has to serve its admin API after it starts.
+const readyTimeout = 30 * time.Second
+
+// stopTimeout is how long Caddy has to shut down before it is killed.
+const stopTimeout = 10 * time.Second
+
+// Caddy is a Caddy process started by Run.
+type Caddy struct {
+	// The free ports which replaced the placeholders
+	// {xcaddytest.http_port} and {xcaddytest.https_port}
+	// in the config.
+	HTTPPort  int
+	HTTPSPort int
+
+	// http://127.0.0.1 with HTTPPort, for convenience.
+	HTTPURL string
+
+	// The URL of the admin API, on a free port which replaced the
+	// placeholder {xcaddytest.admin} in the config; it is also the
+	// default admin address of Caddy, if the config doesn't set one.
+	AdminURL string
+
+	cmd    *exec.Cmd
+	exited chan struct{}
+	output *syncBuffer
+}
+
+// Output returns what Caddy has written to stdout and stderr so far.
+// It is also logged when the test fails.
+func (c *Caddy) Output() string {
+	return c.output.String()
+}
+
+// BuildAndRun builds Caddy with builder and runs it with config, like
+// Build and Run do.
+func BuildAndRun(ctx context.Context, t testing.TB, builder xcaddy.Builder, config string) *Caddy {
+	t.Helper()
+	return Run(t, Build(ctx, t, builder), config)
+}
+
+// Build builds Caddy with builder into a temporary folder, which is
+// removed when the test ends, and returns the path of the binary. The
+// build stops when ctx is done, and the test fails if the build does.
+func Build(ctx context.Context, t testing.TB, builder xcaddy.Builder) string {
+	t.Helper()
+	binary := filepath.Join(t.TempDir(), "caddy")
+	if runtime.GOOS == "windows" {
+		binary += ".exe"
+	}
+	if err := builder.Build(ctx, binary); err != nil {
+		t.Fatalf("building Caddy: %v", err)
+	}
+	return binary
+}
+
+// Run starts Caddy from binary with config, a Caddyfile or JSON config,
+// waits until its admin API responds and returns it. Caddy is stopped
+// when the test ends. The placeholders {xcaddytest.http_port},
+// {xcaddytest.https_port} and {xcaddytest.admin} in config are replaced
+// with free ports and the admin address before Caddy starts, so tests
+// can run in parallel. Caddy keeps its data, like certificates, in a
+// temporary folder. The test fails if Caddy doesn't start.
+func Run(t testing.TB, binary, config string) *Caddy {
+	t.Helper()
+	ports, err := freePorts(3)
+	if err != nil {
+		t.Fatalf("finding free ports for Caddy: %v", err)
+	}
+	admin := "127.0.0.1:" + strconv.Itoa(ports[2])
+	c := &Caddy{
+		HTTPPort:  ports[0],
+		HTTPSPort: ports[1],
+		HTTPURL:   "http://127.0.0.1:" + strconv.Itoa(ports[0]),
+		AdminURL:  "http://" + admin,
+		exited:    make(chan struct{}),
+		output:    new(syncBuffer),
+	}
+	config = strings.NewReplacer(
+		"{xcaddytest.http_port}", strconv.Itoa(c.HTTPPort),
+		"{xcaddytest.https_port}", strconv.Itoa(c.HTTPSPort),
+		"{xcaddytest.admin}", admin,
+	).Replace(config)
+
+	dir := t.TempDir()
+	configFile := filepath.Join(dir, "caddy.json")
+	args := []string{"run", "--config", configFile}
+	if !json.Valid([]byte(config)) {
+		configFile = filepath.Join(dir, "Caddyfile")
+		args = []string{"run", "--config", configFile, "--adapter", "caddyfile"}
+	}
+	if err := os.WriteFile(configFile, []byte(config), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	c.cmd = exec.Command(binary, args...)
+	c.cmd.Dir = dir
+	c.cmd.Env = append(os.Environ(),
+		"CADDY_ADMIN="+admin,
+		"XDG_DATA_HOME="+filepath.Join(dir, "data"),
+		"XDG_CONFIG_HOME="+filepath.Join(dir, "config"),
+	)
+	c.cmd.Stdout = c.output
+	c.cmd.Stderr = c.output
+	if err := c.cmd.Start(); err != nil {
+		t.Fatalf("starting Caddy: %v", err)
+	}
+	var exitErr error
+	go func() {
+		exitErr = c.cmd.Wait()
+		close(c.exited)
+	}()
+	t.Cleanup(func() {
+		c.stop()
+		if t.Failed() {
+			t.Logf("output of Caddy:\n%s", c.Output())
+		}
+	})
+
+	if err := c.waitReady(); err != nil {
+		select {
+		case <-c.exited:
+			t.Fatalf("Caddy exited before its admin API responded: %v\n%s", exitErr, c.Output())
+		default:
+			t.Fatalf("Caddy didn't start: %v", err)
+		}
+	}
+	return c
+}
+
+// waitReady waits until the admin API of c responds,
+// c exits or readyTimeout expires.
+func (c *Caddy) waitReady() error {
+	deadline := time.After(readyTimeout)
+	client := &http.Client{Timeout: time.Second}
+	for {
+		resp, err := client.Get(c.AdminURL + "/config/")
+		if err == nil {
+			resp.Body.Close()
+			if resp.StatusCode == http.StatusOK {
+				return nil
+			}
+			err = fmt.Errorf("%s", resp.Status)
+		}
+		select {
+		case <-c.exited:
+			return err
+		case <-deadline:
+			return fmt.Errorf("admin API at %s did not respond within %s: %v", c.AdminURL, readyTimeout, err)
+		case <-time.After(100 * time.Millisecond):
+		}
+	}
+}
+
+// stop stops c, gracefully if the platform can send it
+// an interrupt, and kills it if it doesn't exit in time.
+func (c *Caddy) stop() {
+	select {
+	case <-c.exited:
+		return
+	default:
+	}
+	// interrupts can't be sent on Windows
+	if err := c.cmd.Process.Signal(os.Interrupt); err != nil {
+		_ = c.cmd.Process.Kill()
+	}
+	select {
+	case <-c.exited:
+	case <-time.After(stopTimeout):
+		_ = c.cmd.Process.Kill()
+		<-c.exited
+	}
+}
+
+// freePorts returns n distinct TCP ports on the loopback
+// interface which are free at the moment.
+func freePorts(n int) ([]int, error) {
+	var ports []int
+	for i := 0; i < n; i++ {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			return nil, err
+		}
+		// the listeners are kept open until all are
+		// found, so no port is returned twice
+		defer ln.Close()
+		ports = append(ports, ln.Addr().(*net.TCPAddr).Port)
+	}
+	return ports, nil
+}
+
+// syncBuffer is a bytes.Buffer which can
+// be written and read concurrently.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
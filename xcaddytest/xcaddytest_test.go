@@ -0,0 +1,108 @@
+// Copyright 2020 Matthew Holt
+//
+// Licensed under the Apache License, Version 2.0 (the "License");
+// you may not use this file except in compliance with the License.
+// You may obtain a copy of the License at
+//
+//     http://www.apache.org/licenses/LICENSE-2.0
+//
+// Unless required by applicable law or agreed to in writing, software
+// distributed under the License is distributed on an "AS IS" BASIS,
+// WITHOUT WARRANTIES OR CONDITIONS OF ANY KIND, either express or implied.
+// See the License for the specific language governing permissions and
+// limitations under the License.
+
+package xcaddytest
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestMain(m *testing.M) {
+	if os.Getenv("XCADDYTEST_FAKE_CADDY") == "1" {
+		fakeCaddy()
+		return
+	}
+	os.Exit(m.Run())
+}
+
+// fakeCaddy serves the admin API like caddy run does, with
+// the config file and the adapter given in the arguments.
+func fakeCaddy() {
+	var configFile, adapter string
+	for i, arg := range os.Args {
+		switch arg {
+		case "--config":
+			configFile = os.Args[i+1]
+		case "--adapter":
+			adapter = os.Args[i+1]
+		}
+	}
+	config, err := os.ReadFile(configFile)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+	fmt.Println("fake caddy started")
+	http.HandleFunc("/config/", func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintf(w, "%s\n%s", adapter, config)
+	})
+	err = http.ListenAndServe(os.Getenv("CADDY_ADMIN"), nil)
+	fmt.Fprintln(os.Stderr, err)
+	os.Exit(1)
+}
+
+func TestRun(t *testing.T) {
+	t.Setenv("XCADDYTEST_FAKE_CADDY", "1")
+	for _, tc := range []struct {
+		config      string
+		wantAdapter string
+	}{
+		{config: `{"apps": {"http": {"servers": {"srv0": {"listen": [":{xcaddytest.http_port}", ":{xcaddytest.https_port}"]}}}}}`},
+		{config: ":{xcaddytest.http_port} {\n\trespond ok\n}\n", wantAdapter: "caddyfile"},
+	} {
+		caddy := Run(t, os.Args[0], tc.config)
+		resp, err := http.Get(caddy.AdminURL + "/config/")
+		if err != nil {
+			t.Fatal(err)
+		}
+		body, err := io.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			t.Fatal(err)
+		}
+		adapter, config, _ := strings.Cut(string(body), "\n")
+		if adapter != tc.wantAdapter {
+			t.Errorf("adapter = %q, want %q", adapter, tc.wantAdapter)
+		}
+		if strings.Contains(config, "{xcaddytest.") || !strings.Contains(config, ":"+strconv.Itoa(caddy.HTTPPort)) {
+			t.Errorf("placeholders were not replaced with the ports: %s", config)
+		}
+		if caddy.HTTPURL != "http://127.0.0.1:"+strconv.Itoa(caddy.HTTPPort) {
+			t.Errorf("HTTPURL = %q for HTTP port %d", caddy.HTTPURL, caddy.HTTPPort)
+		}
+		if !strings.Contains(caddy.Output(), "fake caddy started") {
+			t.Errorf("output of Caddy is missing: %q", caddy.Output())
+		}
+	}
+}
+
+func TestFreePorts(t *testing.T) {
+	ports, err := freePorts(3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	seen := make(map[int]bool)
+	for _, port := range ports {
+		if port <= 0 || seen[port] {
+			t.Errorf("freePorts() = %v, want distinct ports", ports)
+		}
+		seen[port] = true
+	}
+}